@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Values of ACCESS_LOG_FORMAT, the format of the line logged per request.
+const (
+	accessLogSlog        = "slog"
+	accessLogCombined    = "combined"
+	accessLogJSONCompact = "json-compact"
+)
+
+// accessRecord is what the per-request line describes: the entry written to
+// api_logs plus the request details only the line needs.
+type accessRecord struct {
+	logEntry
+	time      time.Time
+	target    string // the request URI, query included
+	proto     string
+	user      string // the basic auth user, if any
+	referer   string
+	userAgent string
+	requestID string
+}
+
+// newAccessRecord describes r, received at start, as entry records it.
+func newAccessRecord(r *http.Request, entry logEntry, start time.Time) accessRecord {
+	user, _, _ := r.BasicAuth()
+	return accessRecord{
+		logEntry:  entry,
+		time:      start,
+		target:    r.URL.RequestURI(),
+		proto:     r.Proto,
+		user:      user,
+		referer:   r.Referer(),
+		userAgent: r.UserAgent(),
+		requestID: r.Header.Get(headerRequestID),
+	}
+}
+
+// accessLogFormatter emits the access log line of one request.
+type accessLogFormatter func(rec accessRecord)
+
+// accessLogFormatters maps each ACCESS_LOG_FORMAT to its formatter.
+var accessLogFormatters = map[string]accessLogFormatter{
+	accessLogSlog:        logAccessSlog,
+	accessLogCombined:    writeAccessLine(formatCombined),
+	accessLogJSONCompact: writeAccessLine(formatJSONCompact),
+}
+
+// accessLog is the formatter main picks with ACCESS_LOG_FORMAT.
+var accessLog accessLogFormatter = logAccessSlog
+
+// parseAccessLogFormat accepts slog, combined or json-compact,
+// case-insensitively.
+func parseAccessLogFormat(s string) (string, error) {
+	f := strings.ToLower(s)
+	if _, ok := accessLogFormatters[f]; !ok {
+		return "", fmt.Errorf("want %s, %s or %s, got %q", accessLogSlog, accessLogCombined, accessLogJSONCompact, s)
+	}
+	return f, nil
+}
+
+// logAccessSlog logs the request as a "request completed" record through
+// the default logger, like every other log line.
+func logAccessSlog(rec accessRecord) {
+	attrs := []any{
+		"method", rec.method,
+		"path", rec.endpoint,
+		"status", rec.status,
+		"duration_ms", rec.durationMs,
+		"ttfb_ms", rec.ttfbMs,
+		"remote_addr", rec.remoteAddr,
+	}
+	if rec.trace.IsValid() {
+		attrs = append(attrs, "trace_id", rec.trace.TraceID().String(), "span_id", rec.trace.SpanID().String())
+	}
+	if rec.requestID != "" {
+		attrs = append(attrs, "request_id", rec.requestID)
+	}
+	if rec.rateLimited {
+		attrs = append(attrs, "rate_limited", true)
+	}
+	slog.Info("request completed", attrs...) // #nosec G706 -- slog JSON handler safely encodes values
+}
+
+var (
+	// accessLogOut receives the combined and json-compact lines. They
+	// bypass slog, and so LOG_OUTPUT and LOG_LEVEL, because the pipelines
+	// reading them expect nothing else on the stream.
+	accessLogOut   io.Writer = os.Stdout
+	accessLogOutMu sync.Mutex
+)
+
+// writeAccessLine writes the line format makes of each record to
+// accessLogOut.
+func writeAccessLine(format func(accessRecord) string) accessLogFormatter {
+	return func(rec accessRecord) {
+		line := format(rec)
+		accessLogOutMu.Lock()
+		defer accessLogOutMu.Unlock()
+		if _, err := io.WriteString(accessLogOut, line); err != nil {
+			slog.Error("failed to write access log", "error", err)
+		}
+	}
+}
+
+// formatCombined formats rec in the Apache combined log format:
+//
+//	%h %l %u %t "%r" %>s %b "%{Referer}i" "%{User-agent}i"
+//
+// The response size isn't tracked, so %b is always "-".
+func formatCombined(rec accessRecord) string {
+	var b strings.Builder
+	b.WriteString(combinedField(rec.remoteAddr))
+	b.WriteString(" - ")
+	b.WriteString(combinedField(rec.user))
+	b.WriteString(rec.time.Format(" [02/Jan/2006:15:04:05 -0700] "))
+	b.WriteString(`"` + escapeCombined(rec.method+" "+rec.target+" "+rec.proto) + `" `)
+	b.WriteString(strconv.Itoa(rec.status))
+	b.WriteString(" - ")
+	b.WriteString(`"` + escapeCombined(orDash(rec.referer)) + `" `)
+	b.WriteString(`"` + escapeCombined(orDash(rec.userAgent)) + `"`)
+	b.WriteString("\n")
+	return b.String()
+}
+
+// combinedField is an unquoted combined log field: "-" when empty, with
+// spaces escaped so the field count stays fixed.
+func combinedField(s string) string {
+	return strings.ReplaceAll(escapeCombined(orDash(s)), " ", `\x20`)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// escapeCombined escapes quotes, backslashes and control characters the way
+// Apache does, so a client can't break a line or a quoted field.
+func escapeCombined(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x20 || c == 0x7f:
+			fmt.Fprintf(&b, `\x%02x`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// compactAccessLine is one json-compact line: only the request's fields,
+// without slog's level and message.
+type compactAccessLine struct {
+	Time        string  `json:"time"`
+	Method      string  `json:"method"`
+	Path        string  `json:"path"`
+	Status      int     `json:"status"`
+	DurationMs  float64 `json:"duration_ms"`
+	TTFBMs      float64 `json:"ttfb_ms"`
+	RemoteAddr  string  `json:"remote_addr"`
+	TraceID     string  `json:"trace_id,omitempty"`
+	RequestID   string  `json:"request_id,omitempty"`
+	RateLimited bool    `json:"rate_limited,omitempty"`
+}
+
+// formatJSONCompact formats rec as a single-line JSON object.
+func formatJSONCompact(rec accessRecord) string {
+	line := compactAccessLine{
+		Time:        rec.time.UTC().Format(time.RFC3339Nano),
+		Method:      rec.method,
+		Path:        rec.endpoint,
+		Status:      rec.status,
+		DurationMs:  rec.durationMs,
+		TTFBMs:      rec.ttfbMs,
+		RemoteAddr:  rec.remoteAddr,
+		TraceID:     rec.traceID().String,
+		RequestID:   rec.requestID,
+		RateLimited: rec.rateLimited,
+	}
+	b, err := json.Marshal(line)
+	if err != nil {
+		// Every field is a string, number or bool, so this can't happen.
+		return ""
+	}
+	return string(b) + "\n"
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// testAccessRecord is a traced request with every optional field set.
+func testAccessRecord(t *testing.T) accessRecord {
+	t.Helper()
+	traceID, err := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	if err != nil {
+		t.Fatal(err)
+	}
+	spanID, err := trace.SpanIDFromHex("00f067aa0ba902b7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := testLogEntry
+	entry.trace = trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID, TraceFlags: trace.FlagsSampled})
+	return accessRecord{
+		logEntry:  entry,
+		time:      time.Date(2026, 2, 27, 12, 0, 0, 123e6, time.FixedZone("ICT", 7*3600)),
+		target:    "/api/v1/time?tz=Asia%2FBangkok",
+		proto:     "HTTP/1.1",
+		referer:   "https://example.com/",
+		userAgent: "curl/8.5.0",
+		requestID: "req-1",
+	}
+}
+
+func TestFormatCombined(t *testing.T) {
+	rec := testAccessRecord(t)
+	want := `10.0.0.1 - - [27/Feb/2026:12:00:00 +0700] "GET /api/v1/time?tz=Asia%2FBangkok HTTP/1.1" 200 - "https://example.com/" "curl/8.5.0"` + "\n"
+	if got := formatCombined(rec); got != want {
+		t.Errorf("expected\n%s got\n%s", want, got)
+	}
+
+	// Missing values are dashes, and nothing a client sends can break the
+	// line or add a field.
+	rec.user = "prom scraper"
+	rec.status = http.StatusNotFound
+	rec.target = "/missing"
+	rec.referer = ""
+	rec.userAgent = "evil\" \\agent\n"
+	want = `10.0.0.1 - prom\x20scraper [27/Feb/2026:12:00:00 +0700] "GET /missing HTTP/1.1" 404 - "-" "evil\" \\agent\x0a"` + "\n"
+	if got := formatCombined(rec); got != want {
+		t.Errorf("expected\n%s got\n%s", want, got)
+	}
+}
+
+func TestFormatJSONCompact(t *testing.T) {
+	rec := testAccessRecord(t)
+	want := `{"time":"2026-02-27T05:00:00.123Z","method":"GET","path":"/api/v1/time","status":200,"duration_ms":1.5,"ttfb_ms":0.5,"remote_addr":"10.0.0.1","trace_id":"4bf92f3577b34da6a3ce929d0e0e4736","request_id":"req-1"}` + "\n"
+	if got := formatJSONCompact(rec); got != want {
+		t.Errorf("expected\n%s got\n%s", want, got)
+	}
+
+	rec.trace = trace.SpanContext{}
+	rec.requestID = ""
+	want = `{"time":"2026-02-27T05:00:00.123Z","method":"GET","path":"/api/v1/time","status":200,"duration_ms":1.5,"ttfb_ms":0.5,"remote_addr":"10.0.0.1"}` + "\n"
+	if got := formatJSONCompact(rec); got != want {
+		t.Errorf("expected\n%s got\n%s", want, got)
+	}
+}
+
+func TestLogAccessSlog(t *testing.T) {
+	var buf bytes.Buffer
+	prev := slog.Default()
+	defer slog.SetDefault(prev)
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})))
+
+	logAccessSlog(testAccessRecord(t))
+	want := `{"level":"INFO","msg":"request completed","method":"GET","path":"/api/v1/time","status":200,"duration_ms":1.5,"ttfb_ms":0.5,"remote_addr":"10.0.0.1","trace_id":"4bf92f3577b34da6a3ce929d0e0e4736","span_id":"00f067aa0ba902b7","request_id":"req-1"}` + "\n"
+	if got := buf.String(); got != want {
+		t.Errorf("expected\n%s got\n%s", want, got)
+	}
+}
+
+func TestMetricsMiddleware_AccessLogFormat(t *testing.T) {
+	var out bytes.Buffer
+	prevLog, prevOut := accessLog, accessLogOut
+	accessLog, accessLogOut = accessLogFormatters[accessLogCombined], &out
+	defer func() { accessLog, accessLogOut = prevLog, prevOut }()
+	logs := captureLogs(t)
+
+	m, _ := newTestMetrics(t)
+	mux := newPublicMux("test")
+	req := httptest.NewRequest(http.MethodGet, routePublic+"?format=unix", nil)
+	req.RemoteAddr = "192.0.2.10:51234"
+	req.Header.Set("User-Agent", "probe/1.0")
+	metricsMiddleware(m, mux).ServeHTTP(httptest.NewRecorder(), req)
+
+	line := out.String()
+	if !strings.HasPrefix(line, "192.0.2.10 - - [") || !strings.HasSuffix(line, `] "GET /api/v1/time?format=unix HTTP/1.1" 200 - "-" "probe/1.0"`+"\n") {
+		t.Errorf("expected a combined line for the request, got %q", line)
+	}
+	if strings.Contains(logs.String(), "request completed") {
+		t.Errorf("expected no slog record with the combined format, got %s", logs.String())
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// purgeChunkSize bounds how many rows one DELETE statement removes, so a
+// large purge never holds long locks on api_logs.
+const purgeChunkSize = 1000
+
+// adminToken is the bearer token required by /admin/* routes; main sets it
+// from ADMIN_TOKEN. Unlike the worker's pause controls, these routes delete
+// data, so they stay disabled while it is unset.
+var adminToken string
+
+// adminServerEnabled is set by main when ADMIN_PORT is set. The internal
+// server then leaves the admin routes and pprof to the admin server and
+// answers 404 for them.
+var adminServerEnabled bool
+
+// adminHandler guards a destructive control endpoint with bearer auth.
+func adminHandler(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if adminToken == "" {
+			writeError(w, http.StatusForbidden, codeForbidden, "admin endpoints disabled: ADMIN_TOKEN not set")
+			return
+		}
+		if !validBearerToken(r, adminToken) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			writeError(w, http.StatusUnauthorized, codeUnauthorized, "unauthorized")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// PurgeLogsResponse is the JSON summary returned by DELETE /admin/logs.
+type PurgeLogsResponse struct {
+	Status    string  `json:"status"`
+	Message   string  `json:"message,omitempty"`
+	Before    string  `json:"before"`
+	DryRun    bool    `json:"dry_run,omitempty"`
+	Matched   *int64  `json:"matched,omitempty"`
+	Deleted   int64   `json:"deleted"`
+	ElapsedMS float64 `json:"elapsed_ms"`
+}
+
+// purgeLogsHandler deletes api_logs rows created before ?before= in chunks
+// of purgeChunkSize, or only counts them with ?dry_run=true.
+func purgeLogsHandler(m *metrics) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		beforeStr := r.URL.Query().Get("before")
+		if beforeStr == "" {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "before is required")
+			return
+		}
+		before, err := time.Parse(time.RFC3339, beforeStr)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "invalid before: want RFC3339")
+			return
+		}
+		if before.After(start) {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "before must not be in the future")
+			return
+		}
+		dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+
+		d := WriteDB()
+		if d == nil {
+			writeError(w, http.StatusServiceUnavailable, codeUnavailable, "db not configured")
+			return
+		}
+
+		resp := PurgeLogsResponse{Status: "ok", Before: before.UTC().Format(time.RFC3339), DryRun: dryRun}
+		code := http.StatusOK
+		if dryRun {
+			var n int64
+			err = d.QueryRowContext(r.Context(), `SELECT count(*) FROM api_logs WHERE created_at < $1`, before.UTC()).Scan(&n)
+			resp.Matched = &n
+		} else {
+			// A large purge can outlast the server's write timeout.
+			_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+			for {
+				res, execErr := d.ExecContext(r.Context(), `
+					DELETE FROM api_logs WHERE id IN (
+						SELECT id FROM api_logs WHERE created_at < $1 LIMIT $2
+					)`, before.UTC(), purgeChunkSize)
+				if execErr != nil {
+					err = execErr
+					break
+				}
+				n, _ := res.RowsAffected()
+				resp.Deleted += n
+				m.logsPurged.Add(float64(n))
+				slog.Info("purged api_logs chunk", "deleted", n, "total", resp.Deleted)
+				if n < purgeChunkSize {
+					break
+				}
+			}
+		}
+		if err != nil {
+			slog.Error("log purge failed", "deleted", resp.Deleted, "error", err)
+			resp.Status, resp.Message = "error", "log purge failed"
+			code = http.StatusInternalServerError
+		}
+		resp.ElapsedMS = float64(time.Since(start).Microseconds()) / 1000
+
+		writeJSON(w, code, resp)
+	}
+}
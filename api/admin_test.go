@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func withAdminToken(t *testing.T, token string) {
+	t.Helper()
+	prev := adminToken
+	adminToken = token
+	t.Cleanup(func() { adminToken = prev })
+}
+
+func withMockDB(t *testing.T) sqlmock.Sqlmock {
+	t.Helper()
+	mockDB, mock, _ := sqlmock.New()
+	dbMu.Lock()
+	db = mockDB
+	dbMu.Unlock()
+	t.Cleanup(func() {
+		dbMu.Lock()
+		db = nil
+		dbMu.Unlock()
+		_ = mockDB.Close()
+	})
+	return mock
+}
+
+func servePurge(t *testing.T, m *metrics, target, token string) (*httptest.ResponseRecorder, PurgeLogsResponse) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodDelete, target, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	newInternalMux(prometheus.NewRegistry(), m, time.Now()).ServeHTTP(rec, req)
+	var resp PurgeLogsResponse
+	_ = json.Unmarshal(rec.Body.Bytes(), &resp)
+	return rec, resp
+}
+
+func TestAdminLogs_Auth(t *testing.T) {
+	m, _ := newTestMetrics(t)
+
+	withAdminToken(t, "")
+	if rec, _ := servePurge(t, m, "/admin/logs?before=2024-01-01T00:00:00Z", "anything"); rec.Code != http.StatusForbidden {
+		t.Errorf("expected 403 without ADMIN_TOKEN, got %d", rec.Code)
+	}
+
+	withAdminToken(t, "s3cret")
+	rec, _ := servePurge(t, m, "/admin/logs?before=2024-01-01T00:00:00Z", "wrong")
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for a wrong token, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("WWW-Authenticate"); got == "" {
+		t.Error("expected a WWW-Authenticate challenge")
+	}
+}
+
+func TestAdminLogs_RejectsBadBefore(t *testing.T) {
+	withAdminToken(t, "s3cret")
+	m, _ := newTestMetrics(t)
+	for _, target := range []string{
+		"/admin/logs",
+		"/admin/logs?before=yesterday",
+		"/admin/logs?before=" + time.Now().Add(time.Hour).UTC().Format(time.RFC3339),
+	} {
+		if rec, _ := servePurge(t, m, target, "s3cret"); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", target, rec.Code)
+		}
+	}
+}
+
+func TestAdminLogs_DryRun(t *testing.T) {
+	withAdminToken(t, "s3cret")
+	mock := withMockDB(t)
+	m, _ := newTestMetrics(t)
+	before := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM api_logs WHERE created_at < $1`)).
+		WithArgs(before).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1234))
+
+	rec, resp := servePurge(t, m, "/admin/logs?before=2024-01-01T00:00:00Z&dry_run=true", "s3cret")
+
+	if rec.Code != http.StatusOK || !resp.DryRun || resp.Matched == nil || *resp.Matched != 1234 || resp.Deleted != 0 {
+		t.Errorf("unexpected dry run response %d %s", rec.Code, rec.Body)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled mock: %s", err)
+	}
+}
+
+func TestAdminLogs_DeletesInChunks(t *testing.T) {
+	withAdminToken(t, "s3cret")
+	mock := withMockDB(t)
+	m, _ := newTestMetrics(t)
+	before := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectExec("DELETE FROM api_logs WHERE id IN").WithArgs(before, purgeChunkSize).
+		WillReturnResult(sqlmock.NewResult(0, purgeChunkSize))
+	mock.ExpectExec("DELETE FROM api_logs WHERE id IN").WithArgs(before, purgeChunkSize).
+		WillReturnResult(sqlmock.NewResult(0, 42))
+
+	rec, resp := servePurge(t, m, "/admin/logs?before=2024-01-01T00:00:00Z", "s3cret")
+
+	if rec.Code != http.StatusOK || resp.Status != "ok" || resp.Deleted != purgeChunkSize+42 {
+		t.Errorf("unexpected response %d %s", rec.Code, rec.Body)
+	}
+	if resp.Before != "2024-01-01T00:00:00Z" {
+		t.Errorf("expected before echoed back, got %s", resp.Before)
+	}
+	if got := testutil.ToFloat64(m.logsPurged); got != purgeChunkSize+42 {
+		t.Errorf("expected api_logs_purged_total %d, got %v", purgeChunkSize+42, got)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled mock: %s", err)
+	}
+}
+
+func TestAdminLogs_MethodNotAllowed(t *testing.T) {
+	withAdminToken(t, "s3cret")
+	m, _ := newTestMetrics(t)
+	rec := httptest.NewRecorder()
+	newInternalMux(prometheus.NewRegistry(), m, time.Now()).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/logs?before=2024-01-01T00:00:00Z", nil))
+	if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != http.MethodDelete {
+		t.Errorf("expected 405 with Allow: DELETE, got %d %q", rec.Code, rec.Header().Get("Allow"))
+	}
+}
+
+// The worker keeps its own copy of the admin server setup; both binaries
+// must serve ADMIN_PORT the same way.
+func TestAdminServer_MatchesWorker(t *testing.T) {
+	for _, name := range []string{"adminserver.go", "adminserver_test.go"} {
+		worker, err := os.ReadFile(filepath.Join("../worker", name))
+		if err != nil {
+			t.Skipf("worker copy not available: %v", err)
+		}
+		api, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !bytes.Equal(api, worker) {
+			t.Errorf("api/%s and worker/%s differ", name, name)
+		}
+	}
+}
+
+func TestAdminPort_RoutesOnlyOnAdminServer(t *testing.T) {
+	withAdminToken(t, "s3cret")
+	withPprof(t, true)
+	prev := adminServerEnabled
+	adminServerEnabled = true
+	defer func() { adminServerEnabled = prev }()
+	m, reg := newTestMetrics(t)
+	internal := newInternalMux(reg, m, time.Now())
+	admin := newAdminServer(0, "s3cret", newAdminMux(m), true).Handler
+
+	serve := func(h http.Handler, target string) int {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set("Authorization", "Bearer s3cret")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	for _, target := range []string{routeAdminLogLevel, routePprof, routePprof + "cmdline"} {
+		if code := serve(internal, target); code != http.StatusNotFound {
+			t.Errorf("expected 404 for %s on the internal server, got %d", target, code)
+		}
+		if code := serve(newPublicMux("test"), target); code != http.StatusNotFound {
+			t.Errorf("expected 404 for %s on the public server, got %d", target, code)
+		}
+		if code := serve(admin, target); code != http.StatusOK {
+			t.Errorf("expected 200 for %s on the admin server, got %d", target, code)
+		}
+	}
+	if code := serve(internal, routeLive); code != http.StatusOK {
+		t.Errorf("expected the internal server to keep serving %s, got %d", routeLive, code)
+	}
+	if code := serve(admin, routeLive); code != http.StatusNotFound {
+		t.Errorf("expected 404 for %s on the admin server, got %d", routeLive, code)
+	}
+}
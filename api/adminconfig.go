@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"time"
+)
+
+// Where a setting got its value, as reported by /admin/config.
+const (
+	sourceDefault    = "default"
+	sourceFile       = "file"
+	sourceSecretFile = "secret_file"
+	sourceEnv        = "env"
+	sourceFlag       = "flag"
+)
+
+// secretMask replaces the value of a set secret in /admin/config.
+const secretMask = "***"
+
+// currentConfig returns the configuration in effect; main points it at the
+// config reloader, which keeps it current across SIGHUP reloads.
+var currentConfig func() Config
+
+// setSource records that the setting read from env got its value from
+// source, a later layer overriding an earlier one.
+func (c *Config) setSource(env, source string) {
+	if c.sources == nil {
+		c.sources = make(map[string]string)
+	}
+	c.sources[env] = source
+}
+
+// settingSources returns the source of every setting by environment
+// variable: file (CONFIG_FILE), secret_file (DB_DSN_FILE, for DB_DSN), env,
+// flag or default.
+func (c Config) settingSources() map[string]string {
+	sources := make(map[string]string, len(configFields))
+	for _, f := range configFields {
+		sources[f.env] = sourceDefault
+		if s, ok := c.sources[f.env]; ok {
+			sources[f.env] = s
+		}
+	}
+	return sources
+}
+
+// redactedConfig renders c field by field with its secrets masked as their
+// secret tags ask. Any other tag value masks the whole value, so a typo
+// can't leak one.
+func redactedConfig(c Config) map[string]any {
+	return redactedFields(reflect.ValueOf(c))
+}
+
+func redactedFields(v reflect.Value) map[string]any {
+	t := v.Type()
+	out := make(map[string]any, t.NumField())
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		fv := v.Field(i)
+		switch tag := f.Tag.Get("secret"); {
+		case tag == "":
+			out[f.Name] = configValue(fv)
+		case fv.IsZero():
+			out[f.Name] = ""
+		case tag == "password" && fv.Kind() == reflect.String:
+			out[f.Name] = redactDSN(fv.String())
+		default:
+			out[f.Name] = secretMask
+		}
+	}
+	return out
+}
+
+// configValue converts a setting into what it looks like in the
+// environment where that reads better than its JSON: durations as 5s and
+// types with a String method, such as the rate limit, through it.
+func configValue(v reflect.Value) any {
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Map, reflect.Slice, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+	}
+	switch x := v.Interface().(type) {
+	case time.Duration:
+		return x.String()
+	case json.Marshaler, encoding.TextMarshaler:
+		return x
+	case fmt.Stringer:
+		return x.String()
+	}
+	switch v.Kind() {
+	case reflect.Pointer:
+		return configValue(v.Elem())
+	case reflect.Struct:
+		return redactedFields(v)
+	case reflect.Map:
+		out := make(map[string]any, v.Len())
+		for iter := v.MapRange(); iter.Next(); {
+			out[fmt.Sprint(iter.Key().Interface())] = configValue(iter.Value())
+		}
+		return out
+	case reflect.Slice:
+		out := make([]any, v.Len())
+		for i := range out {
+			out[i] = configValue(v.Index(i))
+		}
+		return out
+	}
+	return v.Interface()
+}
+
+// ConfigResponse is the JSON body of /admin/config.
+type ConfigResponse struct {
+	Status  string            `json:"status"`
+	Config  map[string]any    `json:"config"`
+	Sources map[string]string `json:"sources"`
+}
+
+// configHandler serves the configuration in effect, secrets masked, with
+// the source of each setting, to compare what two deployments really run.
+func configHandler(w http.ResponseWriter, r *http.Request) {
+	if currentConfig == nil {
+		writeError(w, http.StatusServiceUnavailable, codeUnavailable, "configuration not loaded")
+		return
+	}
+	cfg := currentConfig()
+	writeJSON(w, http.StatusOK, ConfigResponse{
+		Status:  "ok",
+		Config:  redactedConfig(cfg),
+		Sources: cfg.settingSources(),
+	})
+}
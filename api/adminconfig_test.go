@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// withCurrentConfig serves cfg from /admin/config for the test.
+func withCurrentConfig(t *testing.T, cfg Config) {
+	t.Helper()
+	prev := currentConfig
+	currentConfig = func() Config { return cfg }
+	t.Cleanup(func() { currentConfig = prev })
+}
+
+func serveAdminConfig(t *testing.T) (*httptest.ResponseRecorder, ConfigResponse) {
+	t.Helper()
+	m, reg := newTestMetrics(t)
+	rec := httptest.NewRecorder()
+	newInternalMux(reg, m, time.Now()).ServeHTTP(rec, adminRequest(http.MethodGet, routeAdminConfig))
+	var resp ConfigResponse
+	_ = json.Unmarshal(rec.Body.Bytes(), &resp)
+	return rec, resp
+}
+
+func TestAdminConfig_RedactsSecrets(t *testing.T) {
+	withAdminToken(t, "s3cret")
+	cfg := defaultConfig()
+	cfg.DSN = "postgres://app:hunter2@db:5432/app"
+	cfg.ReadDSN = "host=replica user=app password=hunter3"
+	cfg.AdminToken = "s3cret"
+	cfg.MetricsAuth = metricsCredentials{token: "scrape-token"}
+	cfg.ErrorWebhookURL = "https://hooks.example.com/services/T0/B0/hook-secret"
+	cfg.RouteTimeouts = map[string]time.Duration{routeStats: 30 * time.Second}
+	withCurrentConfig(t, cfg)
+
+	rec, resp := serveAdminConfig(t)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	for _, secret := range []string{"hunter2", "hunter3", "s3cret", "scrape-token", "hook-secret"} {
+		if strings.Contains(rec.Body.String(), secret) {
+			t.Errorf("expected %q redacted, got %s", secret, rec.Body.String())
+		}
+	}
+	for field, want := range map[string]any{
+		"DSN":                 "postgres://app:xxxxx@db:5432/app",
+		"ReadDSN":             "host=replica user=app password=xxxxx",
+		"AdminToken":          secretMask,
+		"MetricsAuth":         secretMask,
+		"ErrorWebhookURL":     secretMask,
+		"ReadinessWebhookURL": "",
+		"Port":                float64(8080),
+		"RequestTimeout":      "5s",
+		"LogLevel":            "INFO",
+		"RateLimit":           "100/second",
+	} {
+		if got := resp.Config[field]; got != want {
+			t.Errorf("%s: expected %v, got %v", field, want, got)
+		}
+	}
+	if got := resp.Config["RouteTimeouts"]; !reflect.DeepEqual(got, map[string]any{routeStats: "30s"}) {
+		t.Errorf("expected the route timeouts as durations, got %v", got)
+	}
+	if pool, _ := resp.Config["DBPool"].(map[string]any); pool["ConnMaxLifetime"] != "5m0s" {
+		t.Errorf("expected the nested pool settings, got %v", resp.Config["DBPool"])
+	}
+	if resp.Sources["PORT"] != sourceDefault || len(resp.Sources) != len(configFields) {
+		t.Errorf("expected a default source for every setting, got %v", resp.Sources)
+	}
+}
+
+func TestAdminConfig_RequiresAdminToken(t *testing.T) {
+	withAdminToken(t, "")
+	withCurrentConfig(t, defaultConfig())
+	if rec, _ := serveAdminConfig(t); rec.Code != http.StatusForbidden {
+		t.Errorf("expected 403 without ADMIN_TOKEN, got %d", rec.Code)
+	}
+}
+
+// TestConfig_SecretsTagged keeps new credential fields from reaching
+// /admin/config unmasked.
+func TestConfig_SecretsTagged(t *testing.T) {
+	credential := regexp.MustCompile(`DSN$|Token|Secret|Password|Auth|WebhookURL|AccessKey`)
+	typ := reflect.TypeOf(Config{})
+	for i := range typ.NumField() {
+		f := typ.Field(i)
+		if f.IsExported() && credential.MatchString(f.Name) && f.Tag.Get("secret") == "" {
+			t.Errorf("%s looks like a credential but has no secret tag", f.Name)
+		}
+	}
+}
+
+func TestRedactedConfig_UnknownTagMasks(t *testing.T) {
+	got := redactedFields(reflect.ValueOf(struct {
+		Key   string `secret:"yes"`
+		Empty string `secret:"yes"`
+		Name  string
+	}{Key: "k3y", Name: "api"}))
+	if got["Key"] != secretMask || got["Empty"] != "" || got["Name"] != "api" {
+		t.Errorf("expected only the set secret masked, got %v", got)
+	}
+}
+
+func TestLoadConfig_Sources(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv("CONFIG_FILE", writeConfigFile(t, "port: 9001\npublic_port: 9101\napp_env: staging\n"))
+	t.Setenv("PUBLIC_PORT", "9102")
+	t.Setenv("DB_DSN", "postgres://app:pw@db:5432/app")
+	t.Setenv("DB_DSN_FILE", writeDSNFile(t, "postgres://app:rotated@db:5432/app"))
+	cfg, err := LoadConfig([]string{"--app-env", "prod"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sources := cfg.settingSources()
+	for env, want := range map[string]string{
+		"PORT":        sourceFile,
+		"PUBLIC_PORT": sourceEnv,
+		"APP_ENV":     sourceFlag,
+		"DB_DSN":      sourceSecretFile,
+		"DB_DSN_FILE": sourceEnv,
+		"RATE_LIMIT":  sourceDefault,
+	} {
+		if got := sources[env]; got != want {
+			t.Errorf("%s: expected source %s, got %s", env, want, got)
+		}
+	}
+}
+
+func TestConfigReloader_Sources(t *testing.T) {
+	captureLogs(t)
+	running := defaultConfig()
+	running.setSource("LOG_LEVEL", sourceEnv)
+	applied := running
+	applied.LogLevel = slog.LevelDebug
+	applied.sources = map[string]string{"LOG_LEVEL": sourceFile}
+	partial := applied
+	partial.Port = 9000
+	partial.sources = map[string]string{"LOG_LEVEL": sourceFile, "PORT": sourceEnv}
+	r := &configReloader{load: reloadSequence(applied, partial), settings: reloadableSettings(running.RateLimit.newLimiter(), nil), running: running}
+	defer logLevel.Set(slog.LevelInfo)
+
+	if err := r.reload(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := r.current().settingSources()["LOG_LEVEL"]; got != sourceFile {
+		t.Errorf("expected the applied reload's sources, got %s", got)
+	}
+	if err := r.reload(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := r.current().settingSources()["PORT"]; got != sourceDefault {
+		t.Errorf("expected the running port's source kept while it awaits a restart, got %s", got)
+	}
+}
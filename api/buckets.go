@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// parseBuckets parses a comma-separated list of histogram upper bounds,
+// which must be positive, finite and strictly increasing.
+func parseBuckets(s string) ([]float64, error) {
+	parts := strings.Split(s, ",")
+	buckets := make([]float64, 0, len(parts))
+	for _, part := range parts {
+		b, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bucket %q: %w", part, err)
+		}
+		if b <= 0 || math.IsInf(b, 0) || math.IsNaN(b) {
+			return nil, fmt.Errorf("bucket %v must be positive and finite", b)
+		}
+		if n := len(buckets); n > 0 && b <= buckets[n-1] {
+			return nil, fmt.Errorf("buckets must be strictly increasing: %v after %v", b, buckets[n-1])
+		}
+		buckets = append(buckets, b)
+	}
+	return buckets, nil
+}
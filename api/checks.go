@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Checker is a single dependency probed by /ready.
+type Checker interface {
+	Name() string
+	Check(ctx context.Context) error
+}
+
+// readinessCheck pairs a Checker with whether its failure makes the service
+// unready. Optional checks that fail only mark the service degraded.
+type readinessCheck struct {
+	Checker
+	required bool
+}
+
+// checkResult is the per-check entry in the /ready response.
+type checkResult struct {
+	Name      string  `json:"name"`
+	OK        bool    `json:"ok"`
+	Required  bool    `json:"required"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// readyChecks are the checks run by readyHandler; main registers the
+// optional ones that need runtime state.
+var readyChecks = []readinessCheck{{Checker: dbChecker{}, required: true}}
+
+// runChecks runs every check in order. The result is 503 if any required
+// check fails, and 200 "degraded" if only optional checks fail.
+func runChecks(ctx context.Context, checks []readinessCheck) readyResult {
+	res := readyResult{code: http.StatusOK, status: "ready"}
+	for _, c := range checks {
+		start := time.Now()
+		err := c.Check(ctx)
+		cr := checkResult{
+			Name:      c.Name(),
+			OK:        err == nil,
+			Required:  c.required,
+			LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+		}
+		if err != nil {
+			cr.Error = err.Error()
+			switch {
+			case c.required && res.code == http.StatusOK:
+				res.code = http.StatusServiceUnavailable
+				res.status = "error"
+				res.message = cr.Error
+			case !c.required && res.status == "ready":
+				res.status = "degraded"
+			}
+		}
+		res.checks = append(res.checks, cr)
+	}
+	return res
+}
+
+// dbRequired makes a missing database fail readiness; main sets it from
+// DB_REQUIRED.
+var dbRequired = true
+
+// checkReady runs readyChecks and adds pool statistics and the last database
+// error, or notes when the database is still connecting or deliberately
+// disabled.
+func checkReady(ctx context.Context) readyResult {
+	res := runChecks(ctx, readyChecks)
+	d := WriteDB()
+	switch {
+	case d != nil:
+		res.pool = newPoolStats(d.Stats())
+	case dbConnecting.Load():
+		res.db = "connecting"
+	case !dbRequired:
+		res.db = "disabled"
+	}
+	res.dbError = lastDBError.get()
+	return res
+}
+
+// dbChecker pings the database within readyPingTimeout. A missing or
+// still-connecting database passes when it is not required.
+type dbChecker struct{}
+
+func (dbChecker) Name() string { return "database" }
+
+func (dbChecker) Check(ctx context.Context) error {
+	d := WriteDB()
+	if d == nil {
+		switch {
+		case !dbRequired:
+			return nil
+		case dbConnecting.Load():
+			return errors.New("db connecting")
+		}
+		return errors.New("db not configured")
+	}
+	pingCtx, cancel := context.WithTimeout(ctx, readyPingTimeout)
+	defer cancel()
+	err := d.PingContext(pingCtx)
+	dbConnected.Store(err == nil)
+	lastDBError.record(err)
+	if err != nil {
+		if errors.Is(pingCtx.Err(), context.DeadlineExceeded) {
+			return errors.New("db ping timeout")
+		}
+		return errors.New("db unreachable")
+	}
+	return nil
+}
+
+// pgReadOnlyTransaction is the SQLSTATE Postgres returns for a write to a
+// server in recovery or with default_transaction_read_only on.
+const pgReadOnlyTransaction = "25006"
+
+// dbWriteChecker upserts the ready_heartbeat row within readyPingTimeout,
+// catching a database that answers pings but refuses writes, as a replica
+// does until it is promoted. main adds it with READY_CHECK_WRITE; the
+// readiness cache bounds how often it writes. A missing database is left to
+// dbChecker.
+type dbWriteChecker struct{}
+
+func (dbWriteChecker) Name() string { return "database_write" }
+
+func (dbWriteChecker) Check(ctx context.Context) error {
+	d := WriteDB()
+	if d == nil {
+		return nil
+	}
+	writeCtx, cancel := context.WithTimeout(ctx, readyPingTimeout)
+	defer cancel()
+	_, err := d.ExecContext(writeCtx, `INSERT INTO ready_heartbeat (id, checked_at) VALUES (1, now())
+		ON CONFLICT (id) DO UPDATE SET checked_at = EXCLUDED.checked_at`)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		switch {
+		case errors.As(err, &pgErr) && pgErr.Code == pgReadOnlyTransaction:
+			return errors.New("db read-only")
+		case errors.Is(writeCtx.Err(), context.DeadlineExceeded):
+			return errors.New("db write timeout")
+		}
+		return errors.New("db write failed")
+	}
+	return nil
+}
+
+// logPipelineChecker reports whether the access log flusher is running,
+// making progress, and keeping up with incoming requests.
+type logPipelineChecker struct{}
+
+func (logPipelineChecker) Name() string { return "log_pipeline" }
+
+func (logPipelineChecker) Check(context.Context) error {
+	f := flusher
+	if f == nil {
+		return errors.New("log flusher not started")
+	}
+	return f.check(time.Now())
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultClientStatsWindow = time.Hour
+	maxClientStatsWindow     = 24 * time.Hour
+	defaultClientStatsLimit  = 20
+	maxClientStatsLimit      = 100
+	clientStatsCacheTTL      = 30 * time.Second
+)
+
+// ClientStatsResponse is the JSON response for /api/v1/stats/clients.
+type ClientStatsResponse struct {
+	From    string        `json:"from"`
+	To      string        `json:"to"`
+	Window  string        `json:"window"`
+	Limit   int           `json:"limit"`
+	Clients []ClientStats `json:"clients"`
+}
+
+// ClientStats summarizes one client's requests. Errors counts 4xx and 5xx
+// responses.
+type ClientStats struct {
+	Client        string  `json:"client"`
+	Requests      int64   `json:"requests"`
+	Errors        int64   `json:"errors"`
+	AvgDurationMS float64 `json:"avg_duration_ms"`
+}
+
+// clientStatsQuery ranks the clients of a window by request count.
+const clientStatsQuery = `
+	SELECT remote_addr,
+		count(*),
+		count(*) FILTER (WHERE status >= 400),
+		avg(duration_ms)
+	FROM api_logs
+	WHERE created_at >= $1 AND created_at < $2
+	GROUP BY 1
+	ORDER BY 2 DESC, 1
+	LIMIT $3`
+
+// parseClientStatsParams reads window as a duration, defaulting to an hour
+// and capped at a day, and limit, defaulting to 20 and capped at 100.
+func parseClientStatsParams(windowStr, limitStr string) (time.Duration, int, error) {
+	window := defaultClientStatsWindow
+	if windowStr != "" {
+		d, err := time.ParseDuration(windowStr)
+		if err != nil || d <= 0 {
+			return 0, 0, errors.New("invalid window: want a positive duration such as 1h")
+		}
+		window = d
+	}
+	if window > maxClientStatsWindow {
+		return 0, 0, errors.New("window must not exceed 24h")
+	}
+	limit := defaultClientStatsLimit
+	if limitStr != "" {
+		n, err := strconv.Atoi(limitStr)
+		if err != nil || n < 1 || n > maxClientStatsLimit {
+			return 0, 0, errors.New("invalid limit: want 1 to 100")
+		}
+		limit = n
+	}
+	return window, limit, nil
+}
+
+// clientStatsKey identifies a cached ranking.
+type clientStatsKey struct {
+	window time.Duration
+	limit  int
+}
+
+type cachedClientStats struct {
+	resp    ClientStatsResponse
+	expires time.Time
+}
+
+// clientStatsCache keeps each ranking for ttl, so an incident's worth of
+// refreshes doesn't rescan api_logs every time. Concurrent callers share one
+// query, as with readyCache.
+type clientStatsCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	now     func() time.Time
+	entries map[clientStatsKey]cachedClientStats
+}
+
+func newClientStatsCache(ttl time.Duration) *clientStatsCache {
+	return &clientStatsCache{
+		ttl:     ttl,
+		now:     time.Now,
+		entries: make(map[clientStatsKey]cachedClientStats),
+	}
+}
+
+// get returns the cached ranking for key while it is fresh, otherwise it
+// runs query for the window ending now and caches a successful result.
+// Expired rankings are dropped as new ones are stored.
+func (c *clientStatsCache) get(key clientStatsKey, query func(from, to time.Time) ([]ClientStats, error)) (ClientStatsResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if cached, ok := c.entries[key]; ok && now.Before(cached.expires) {
+		return cached.resp, nil
+	}
+	to := now.UTC()
+	from := to.Add(-key.window)
+	clients, err := query(from, to)
+	if err != nil {
+		return ClientStatsResponse{}, err
+	}
+	resp := ClientStatsResponse{
+		From:    from.Format(time.RFC3339),
+		To:      to.Format(time.RFC3339),
+		Window:  key.window.String(),
+		Limit:   key.limit,
+		Clients: clients,
+	}
+	for k, cached := range c.entries {
+		if !now.Before(cached.expires) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = cachedClientStats{resp: resp, expires: now.Add(c.ttl)}
+	return resp, nil
+}
+
+// clientStats caches the /api/v1/stats/clients rankings.
+var clientStats = newClientStatsCache(clientStatsCacheTTL)
+
+// clientStatsHandler lists the clients that sent the most requests in the
+// last window, with their error counts and average duration.
+func clientStatsHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	window, limit, err := parseClientStatsParams(q.Get("window"), q.Get("limit"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	}
+
+	d := ReadDB()
+	if d == nil {
+		writeError(w, http.StatusServiceUnavailable, codeUnavailable, "db not configured")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), statsQueryTimeout)
+	defer cancel()
+	resp, err := clientStats.get(clientStatsKey{window: window, limit: limit}, func(from, to time.Time) ([]ClientStats, error) {
+		return queryClientStats(ctx, d, from, to, limit)
+	})
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			writeError(w, http.StatusGatewayTimeout, codeTimeout, "stats query timed out")
+			return
+		}
+		slog.Error("client stats query failed", "error", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "stats query failed")
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func queryClientStats(ctx context.Context, d *sql.DB, from, to time.Time, limit int) ([]ClientStats, error) {
+	rows, err := d.QueryContext(ctx, clientStatsQuery, from, to, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	clients := []ClientStats{}
+	for rows.Next() {
+		var (
+			client sql.NullString
+			c      ClientStats
+			avg    sql.NullFloat64
+		)
+		if err := rows.Scan(&client, &c.Requests, &c.Errors, &avg); err != nil {
+			return nil, err
+		}
+		c.Client, c.AvgDurationMS = client.String, avg.Float64
+		clients = append(clients, c)
+	}
+	return clients, rows.Err()
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestParseClientStatsParams(t *testing.T) {
+	window, limit, err := parseClientStatsParams("", "")
+	if err != nil || window != time.Hour || limit != 20 {
+		t.Errorf("expected 1h and 20, got %s and %d, %v", window, limit, err)
+	}
+	window, limit, err = parseClientStatsParams("24h", "100")
+	if err != nil || window != 24*time.Hour || limit != 100 {
+		t.Errorf("expected the caps to be allowed, got %s and %d, %v", window, limit, err)
+	}
+	for _, bad := range [][2]string{{"soon", ""}, {"-1h", ""}, {"0s", ""}, {"25h", ""}, {"", "0"}, {"", "101"}, {"", "ten"}} {
+		if _, _, err := parseClientStatsParams(bad[0], bad[1]); err == nil {
+			t.Errorf("window %q, limit %q: expected an error", bad[0], bad[1])
+		}
+	}
+}
+
+// withClientStatsCache swaps in an empty cache whose clock the test moves.
+func withClientStatsCache(t *testing.T, now *time.Time) {
+	t.Helper()
+	prev := clientStats
+	clientStats = newClientStatsCache(clientStatsCacheTTL)
+	clientStats.now = func() time.Time { return *now }
+	t.Cleanup(func() { clientStats = prev })
+}
+
+func TestClientStatsHandler(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer func() { _ = mockDB.Close() }()
+	dbMu.Lock()
+	db = mockDB
+	dbMu.Unlock()
+	defer func() {
+		dbMu.Lock()
+		db = nil
+		dbMu.Unlock()
+	}()
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	withClientStatsCache(t, &now)
+
+	columns := []string{"remote_addr", "requests", "errors", "avg"}
+	mock.ExpectQuery(regexp.QuoteMeta(clientStatsQuery)).
+		WithArgs(now.Add(-30*time.Minute), now, 2).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow("203.0.113.7", 500, 480, 1.25).
+			AddRow(nil, 3, 0, 2.0))
+	serve := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		clientStatsHandler(rec, httptest.NewRequest(http.MethodGet, "/api/v1/stats/clients?window=30m&limit=2", nil))
+		return rec
+	}
+
+	want := `{"from":"2024-01-01T11:30:00Z","to":"2024-01-01T12:00:00Z","window":"30m0s","limit":2,"clients":[` +
+		`{"client":"203.0.113.7","requests":500,"errors":480,"avg_duration_ms":1.25},` +
+		`{"client":"","requests":3,"errors":0,"avg_duration_ms":2}]}`
+	rec := serve()
+	if got := strings.TrimSpace(rec.Body.String()); rec.Code != http.StatusOK || got != want {
+		t.Fatalf("unexpected response %d:\n got %s\nwant %s", rec.Code, got, want)
+	}
+
+	// Within the TTL the same ranking is served without another scan.
+	now = now.Add(29 * time.Second)
+	if rec := serve(); strings.TrimSpace(rec.Body.String()) != want {
+		t.Errorf("expected the cached ranking, got %s", rec.Body)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled mock: %s", err)
+	}
+
+	// Once it expires the query runs again, and a failure isn't cached.
+	now = now.Add(time.Second)
+	mock.ExpectQuery(regexp.QuoteMeta(clientStatsQuery)).WillReturnError(errors.New("connection reset"))
+	mock.ExpectQuery(regexp.QuoteMeta(clientStatsQuery)).
+		WithArgs(now.Add(-30*time.Minute), now, 2).
+		WillReturnRows(sqlmock.NewRows(columns))
+	if rec := serve(); rec.Code != http.StatusInternalServerError {
+		t.Errorf("expected 500, got %d", rec.Code)
+	}
+	if rec := serve(); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"clients":[]`) {
+		t.Errorf("expected a fresh, empty ranking, got %d %s", rec.Code, rec.Body)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled mock: %s", err)
+	}
+}
+
+func TestClientStatsHandler_Rejects(t *testing.T) {
+	dbMu.Lock()
+	db = nil
+	dbMu.Unlock()
+	for target, want := range map[string]int{
+		"/api/v1/stats/clients?window=48h": http.StatusBadRequest,
+		"/api/v1/stats/clients?limit=500":  http.StatusBadRequest,
+		"/api/v1/stats/clients":            http.StatusServiceUnavailable,
+	} {
+		rec := httptest.NewRecorder()
+		clientStatsHandler(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		if rec.Code != want {
+			t.Errorf("%s: expected %d, got %d", target, want, rec.Code)
+		}
+	}
+}
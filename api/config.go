@@ -0,0 +1,715 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Config is every setting the API reads at startup. LoadConfig fills it from
+// the environment and main hands the pieces to the code that uses them.
+//
+// Fields holding credentials carry a secret tag for /admin/config:
+// "true" masks the whole value and "password" only the password in a URL
+// or connection string. Tag every new one.
+type Config struct {
+	Port                  int
+	PublicPort            int
+	AdminPort             int
+	Env                   string
+	ServiceName           string
+	DSN                   string `secret:"password"`
+	ReadDSN               string `secret:"password"`
+	DSNFile               string
+	DBDriver              string
+	DBPool                dbPoolConfig
+	DBKeepaliveInterval   time.Duration
+	LogLevel              slog.Level
+	LogFormat             string
+	LogOutput             string
+	SyslogNetwork         string
+	SyslogAddr            string
+	LogSource             bool
+	AccessLogFormat       string
+	DebugLogSample        float64
+	DebugLogMatch         string
+	RateLimit             rateSpec
+	DBRequired            bool
+	LogPipelineRequired   bool
+	ReadyCacheTTL         time.Duration
+	ReadyPingTimeout      time.Duration
+	ReadyCheckWrite       bool
+	StatsQueryTimeout     time.Duration
+	RequestTimeout        time.Duration
+	RouteTimeouts         map[string]time.Duration
+	SlowRequestThreshold  time.Duration
+	RouteSlowThresholds   map[string]time.Duration
+	RouteCacheMaxAges     map[string]time.Duration
+	TimeCacheResolution   time.Duration
+	DurationBuckets       []float64
+	MetricsStrictRoutes   bool
+	SLORoutes             map[string]float64
+	SLOCountRateLimited   bool
+	HealthzDegradedStatus int
+	HealthzErrorStatus    int
+	AdminToken            string             `secret:"true"`
+	MetricsAuth           metricsCredentials `secret:"true"`
+	EnablePprof           bool
+	FaultInjection        bool
+	FaultDelayP50         time.Duration
+	FaultErrorRate        float64
+	TrustedProxies        []netip.Prefix
+	ShutdownDrainDelay    time.Duration
+	ShutdownTimeout       time.Duration
+	SinglePort            bool
+	InternalPrefix        string
+	OTLPEndpoint          string
+	OTLPMetricsEndpoint   string
+	OTLPMetricsInterval   time.Duration
+	ErrorWebhookURL       string `secret:"true"`
+	StatsdAddr            string
+	StatsdPrefix          string
+	StatsdTags            []string
+	ReadinessWebhookURL   string `secret:"true"`
+	ReadinessInterval     time.Duration
+
+	// warnings are the invalid settings that fell back to their defaults;
+	// setupLogger reports them once the logger is built.
+	warnings []error
+	// sources names where each setting, by environment variable, got its
+	// value from; settings left at their defaults are missing.
+	sources map[string]string
+}
+
+// defaultConfig is the configuration with nothing set.
+func defaultConfig() Config {
+	return Config{
+		Port:                  8080,
+		PublicPort:            8090,
+		Env:                   "development",
+		ServiceName:           defaultServiceName,
+		DBDriver:              dbDriverStdlib,
+		DBPool:                defaultDBPool,
+		DBKeepaliveInterval:   defaultDBKeepaliveInterval,
+		LogLevel:              slog.LevelInfo,
+		LogFormat:             logFormatJSON,
+		LogOutput:             logOutputStdout,
+		SyslogNetwork:         syslogNetworkUDP,
+		AccessLogFormat:       accessLogSlog,
+		RateLimit:             rateSpec{count: 100, window: time.Second},
+		DBRequired:            true,
+		ReadyCacheTTL:         defaultReadyCacheTTL,
+		ReadyPingTimeout:      defaultReadyPingTimeout,
+		StatsQueryTimeout:     defaultStatsQueryTimeout,
+		RequestTimeout:        defaultRequestTimeout,
+		SlowRequestThreshold:  defaultSlowRequestThreshold,
+		TimeCacheResolution:   defaultTimeCacheResolution,
+		DurationBuckets:       defaultDurationBuckets,
+		HealthzDegradedStatus: healthzStatusCodes["degraded"],
+		HealthzErrorStatus:    healthzStatusCodes["error"],
+		ShutdownDrainDelay:    defaultShutdownDrainDelay,
+		ShutdownTimeout:       defaultShutdownTimeout,
+		InternalPrefix:        defaultInternalPrefix,
+		OTLPMetricsInterval:   defaultOTLPMetricsInterval,
+		ReadinessInterval:     defaultReadinessInterval,
+	}
+}
+
+// configField is one environment variable and how it is parsed into Config.
+type configField struct {
+	env   string
+	usage string
+	set   func(c *Config, s string) error
+}
+
+// flagName is the command-line flag for f: its environment variable in lower
+// case with dashes, e.g. --public-port for PUBLIC_PORT.
+func (f configField) flagName() string {
+	return strings.ReplaceAll(strings.ToLower(f.env), "_", "-")
+}
+
+var configFields = []configField{
+	{"PORT", "internal server port", portVar(func(c *Config) *int { return &c.Port })},
+	{"PUBLIC_PORT", "public server port", portVar(func(c *Config) *int { return &c.PublicPort })},
+	{"ADMIN_PORT", "admin server port for /admin/* and pprof; unset serves /admin/* on PORT", portVar(func(c *Config) *int { return &c.AdminPort })},
+	{"APP_ENV", "environment name reported by the time endpoint", stringVar(func(c *Config) *string { return &c.Env })},
+	{"SERVICE_NAME", "service label on metrics and the OpenAPI title", stringVar(func(c *Config) *string { return &c.ServiceName })},
+	{"DB_DSN", "PostgreSQL connection string", func(c *Config, s string) error {
+		if err := checkDSN(s); err != nil {
+			return err
+		}
+		c.DSN = s
+		return nil
+	}},
+	{"DB_READ_DSN", "read replica connection string for the stats and export queries", func(c *Config, s string) error {
+		if err := checkDSN(s); err != nil {
+			return err
+		}
+		c.ReadDSN = s
+		return nil
+	}},
+	{"DB_DSN_FILE", "file holding the PostgreSQL connection string; wins over DB_DSN but not --db-dsn", stringVar(func(c *Config) *string { return &c.DSNFile })},
+	{"DB_DRIVER", "data layer of the log flusher: stdlib or pgx-native", func(c *Config, s string) error {
+		if s != dbDriverStdlib && s != dbDriverPgxNative {
+			return fmt.Errorf("want %s or %s, got %q", dbDriverStdlib, dbDriverPgxNative, s)
+		}
+		c.DBDriver = s
+		return nil
+	}},
+	{"DB_MAX_OPEN_CONNS", "connections the pool may open", positiveIntVar(func(c *Config) *int { return &c.DBPool.MaxOpenConns })},
+	{"DB_MAX_IDLE_CONNS", "idle connections the pool keeps", nonNegativeIntVar(func(c *Config) *int { return &c.DBPool.MaxIdleConns })},
+	{"DB_CONN_MAX_LIFETIME", "age at which a connection is replaced; 0 disables it", durationVar(func(c *Config) *time.Duration { return &c.DBPool.ConnMaxLifetime }, true)},
+	{"DB_CONN_MAX_IDLE_TIME", "idle time after which a connection is closed; 0 disables it", durationVar(func(c *Config) *time.Duration { return &c.DBPool.ConnMaxIdleTime }, true)},
+	{"DB_KEEPALIVE_INTERVAL", "how often the pool is pinged in the background; 0 disables it", durationVar(func(c *Config) *time.Duration { return &c.DBKeepaliveInterval }, true)},
+	{"LOG_LEVEL", "debug, info, warn or error", lenient(func(c *Config, s string) error {
+		level, err := parseLogLevel(s)
+		if err != nil {
+			return err
+		}
+		c.LogLevel = level
+		return nil
+	})},
+	{"LOG_FORMAT", "json or text", lenient(func(c *Config, s string) error {
+		format, err := parseLogFormat(s)
+		if err != nil {
+			return err
+		}
+		c.LogFormat = format
+		return nil
+	})},
+	{"LOG_OUTPUT", "stdout, stderr, syslog or the path of a rotated log file", stringVar(func(c *Config) *string { return &c.LogOutput })},
+	{"SYSLOG_NETWORK", "udp or tcp, for LOG_OUTPUT=syslog with SYSLOG_ADDR", lenient(func(c *Config, s string) error {
+		network, err := parseSyslogNetwork(s)
+		if err != nil {
+			return err
+		}
+		c.SyslogNetwork = network
+		return nil
+	})},
+	{"SYSLOG_ADDR", "host:port of a remote syslog server; unset uses the local daemon", lenient(func(c *Config, s string) error {
+		if _, _, err := net.SplitHostPort(s); err != nil {
+			return fmt.Errorf("want host:port, got %q", s)
+		}
+		c.SyslogAddr = s
+		return nil
+	})},
+	{"LOG_SOURCE", "add the source file and line to log records", lenient(boolVar(func(c *Config) *bool { return &c.LogSource }))},
+	{"ACCESS_LOG_FORMAT", "per-request log line: slog, combined or json-compact", lenient(func(c *Config, s string) error {
+		format, err := parseAccessLogFormat(s)
+		if err != nil {
+			return err
+		}
+		c.AccessLogFormat = format
+		return nil
+	})},
+	{"DEBUG_LOG_SAMPLE", "fraction of requests logged with headers and query at debug level", func(c *Config, s string) (err error) {
+		c.DebugLogSample, err = parseSampleRate(s)
+		return err
+	}},
+	{"DEBUG_LOG_MATCH", "path prefix of the requests DEBUG_LOG_SAMPLE applies to", func(c *Config, s string) error {
+		if !strings.HasPrefix(s, "/") {
+			return fmt.Errorf("want a path prefix starting with /, got %q", s)
+		}
+		c.DebugLogMatch = s
+		return nil
+	}},
+	{"RATE_LIMIT", "internal server rate, e.g. 100, 0.5 or 30/minute", lenient(func(c *Config, s string) error {
+		spec, err := parseRate(s)
+		if err != nil {
+			return err
+		}
+		c.RateLimit = spec
+		return nil
+	})},
+	{"DB_REQUIRED", "fail /ready when the database is down", boolVar(func(c *Config) *bool { return &c.DBRequired })},
+	{"LOG_PIPELINE_REQUIRED", "fail /ready when the log buffer is stuck", boolVar(func(c *Config) *bool { return &c.LogPipelineRequired })},
+	{"READY_CACHE_TTL", "how long a /ready result is reused", durationVar(func(c *Config) *time.Duration { return &c.ReadyCacheTTL }, true)},
+	{"READY_PING_TIMEOUT", "timeout of the /ready database ping", durationVar(func(c *Config) *time.Duration { return &c.ReadyPingTimeout }, false)},
+	{"READY_CHECK_WRITE", "fail /ready when the database refuses writes", boolVar(func(c *Config) *bool { return &c.ReadyCheckWrite })},
+	{"STATS_QUERY_TIMEOUT", "timeout of the /api/v1/stats query", durationVar(func(c *Config) *time.Duration { return &c.StatsQueryTimeout }, false)},
+	{"REQUEST_TIMEOUT", "per-request deadline; 0 disables it", durationVar(func(c *Config) *time.Duration { return &c.RequestTimeout }, true)},
+	{"ROUTE_TIMEOUTS", "per-route deadlines, e.g. /api/v1/stats=30s", func(c *Config, s string) (err error) {
+		c.RouteTimeouts, err = parseRouteDurations(s)
+		return err
+	}},
+	{"SLOW_REQUEST_THRESHOLD", "duration past which a request is logged as slow; 0 disables it", durationVar(func(c *Config) *time.Duration { return &c.SlowRequestThreshold }, true)},
+	{"ROUTE_SLOW_THRESHOLDS", "per-route slow request thresholds, e.g. /api/v1/stats=5s", func(c *Config, s string) (err error) {
+		c.RouteSlowThresholds, err = parseRouteDurations(s)
+		return err
+	}},
+	{"ROUTE_CACHE_MAX_AGE", "per-route Cache-Control max-age of ETag'd routes, e.g. /version=1h", func(c *Config, s string) (err error) {
+		c.RouteCacheMaxAges, err = parseRouteDurations(s)
+		return err
+	}},
+	{"TIME_CACHE_RESOLUTION", "how long a rendered /api/v1/time response is reused; 0 disables it", durationVar(func(c *Config) *time.Duration { return &c.TimeCacheResolution }, true)},
+	{"METRICS_DURATION_BUCKETS", "request duration histogram buckets in seconds", func(c *Config, s string) (err error) {
+		c.DurationBuckets, err = parseBuckets(s)
+		return err
+	}},
+	{"METRICS_STRICT_ROUTES", "fail startup when a registered route is missing from the metrics route set", boolVar(func(c *Config) *bool { return &c.MetricsStrictRoutes })},
+	{"SLO_ROUTES", "availability objectives, e.g. /api/v1/time=0.999", func(c *Config, s string) (err error) {
+		c.SLORoutes, err = parseSLORoutes(s)
+		return err
+	}},
+	{"SLO_COUNT_RATE_LIMITED", "count 429s against the SLO", boolVar(func(c *Config) *bool { return &c.SLOCountRateLimited })},
+	{"HEALTHZ_DEGRADED_STATUS", "/healthz status when degraded", statusVar(func(c *Config) *int { return &c.HealthzDegradedStatus })},
+	{"HEALTHZ_ERROR_STATUS", "/healthz status when unhealthy", statusVar(func(c *Config) *int { return &c.HealthzErrorStatus })},
+	{"ADMIN_TOKEN", "bearer token for the admin routes", stringVar(func(c *Config) *string { return &c.AdminToken })},
+	{"METRICS_AUTH_TOKEN", "bearer token for /metrics", stringVar(func(c *Config) *string { return &c.MetricsAuth.token })},
+	{"METRICS_BASIC_AUTH", "user:pass for /metrics", func(c *Config, s string) error {
+		user, password, ok := strings.Cut(s, ":")
+		if !ok || user == "" || password == "" {
+			return errors.New("want user:pass")
+		}
+		c.MetricsAuth.user, c.MetricsAuth.password = user, password
+		return nil
+	}},
+	{"ENABLE_PPROF", "serve /debug/pprof/ behind the admin token", boolVar(func(c *Config) *bool { return &c.EnablePprof })},
+	{"FAULT_INJECTION", "honour the X-Fault-Delay and X-Fault-Status headers; never in production", boolVar(func(c *Config) *bool { return &c.FaultInjection })},
+	{"FAULT_DELAY_P50", "median delay added to every request when FAULT_INJECTION is on", durationVar(func(c *Config) *time.Duration { return &c.FaultDelayP50 }, true)},
+	{"FAULT_ERROR_RATE", "fraction of requests failed with a 503 when FAULT_INJECTION is on", func(c *Config, s string) (err error) {
+		c.FaultErrorRate, err = parseSampleRate(s)
+		return err
+	}},
+	{"TRUSTED_PROXIES", "CIDRs whose forwarding headers are believed", func(c *Config, s string) (err error) {
+		c.TrustedProxies, err = parseTrustedProxies(s)
+		return err
+	}},
+	{"SHUTDOWN_DRAIN_DELAY", "how long /ready fails before the servers stop", durationVar(func(c *Config) *time.Duration { return &c.ShutdownDrainDelay }, true)},
+	{"SHUTDOWN_TIMEOUT", "bound on the whole shutdown", durationVar(func(c *Config) *time.Duration { return &c.ShutdownTimeout }, false)},
+	{"SINGLE_PORT", "serve both route sets on PORT", boolVar(func(c *Config) *bool { return &c.SinglePort })},
+	{"INTERNAL_PREFIX", "path of the internal routes in single-port mode", func(c *Config, s string) error {
+		if !strings.HasPrefix(s, "/") || strings.HasSuffix(s, "/") {
+			return fmt.Errorf("want a path like %s, got %q", defaultInternalPrefix, s)
+		}
+		c.InternalPrefix = s
+		return nil
+	}},
+	{"OTEL_EXPORTER_OTLP_ENDPOINT", "OTLP/HTTP collector to send traces to; unset disables tracing", urlVar(func(c *Config) *string { return &c.OTLPEndpoint })},
+	{"OTEL_EXPORTER_OTLP_METRICS_ENDPOINT", "OTLP/HTTP collector to push metrics to; unset disables the push", urlVar(func(c *Config) *string { return &c.OTLPMetricsEndpoint })},
+	{"OTLP_METRICS_INTERVAL", "pause between OTLP metrics pushes", durationVar(func(c *Config) *time.Duration { return &c.OTLPMetricsInterval }, false)},
+	{"ERROR_WEBHOOK_URL", "webhook to POST error reports to; unset disables reporting", urlVar(func(c *Config) *string { return &c.ErrorWebhookURL })},
+	{"STATSD_ADDR", "statsd agent host:port to mirror metrics to over UDP; unset disables it", func(c *Config, s string) error {
+		if err := checkStatsdAddr(s); err != nil {
+			return err
+		}
+		c.StatsdAddr = s
+		return nil
+	}},
+	{"STATSD_PREFIX", "prefix of every statsd metric name, e.g. agnos.", stringVar(func(c *Config) *string { return &c.StatsdPrefix })},
+	{"STATSD_TAGS", "tags on every statsd metric, e.g. team:platform,region:th", func(c *Config, s string) (err error) {
+		c.StatsdTags, err = parseStatsdTags(s)
+		return err
+	}},
+	{"READINESS_WEBHOOK_URL", "webhook to POST readiness changes to; unset disables the watcher", urlVar(func(c *Config) *string { return &c.ReadinessWebhookURL })},
+	{"READINESS_WATCH_INTERVAL", "pause between the watcher's readiness checks", durationVar(func(c *Config) *time.Duration { return &c.ReadinessInterval }, false)},
+}
+
+// LoadConfig stops early with these instead of a configuration when the
+// command line asks for something other than a normal start.
+var (
+	// errVersion means --version was passed.
+	errVersion = errors.New("version requested")
+	// errValidateOnly means --validate-config was passed and the
+	// configuration is valid.
+	errValidateOnly = errors.New("configuration validated")
+	// errMigrateOnly means --migrate-only was passed and the configuration,
+	// which names a database, is valid.
+	errMigrateOnly = errors.New("migrations requested")
+)
+
+// LoadConfig builds the configuration from, in increasing precedence, the
+// defaults, the YAML file named by --config-file or CONFIG_FILE, the
+// environment and the flags in args, the command line without the program
+// name. The error lists every invalid value, not just the first; it is
+// flag.ErrHelp, errVersion, errValidateOnly or errMigrateOnly when the
+// command line asked for that instead.
+func LoadConfig(args []string) (Config, error) {
+	var passed []flagValue
+	fs := newFlagSet(&passed)
+	configFile := fs.String("config-file", os.Getenv("CONFIG_FILE"), "YAML file of settings, overridden by the environment (env CONFIG_FILE)")
+	showVersion := fs.Bool("version", false, "print build information and exit")
+	validateOnly := fs.Bool("validate-config", false, "print the effective configuration and exit")
+	migrateOnly := fs.Bool("migrate-only", false, "apply database migrations and exit")
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+	if *showVersion {
+		return Config{}, errVersion
+	}
+
+	cfg := defaultConfig()
+	var errs []error
+	if *configFile != "" {
+		errs = append(errs, loadConfigFile(&cfg, *configFile)...)
+	}
+	for _, f := range configFields {
+		s := os.Getenv(f.env)
+		if s == "" {
+			continue
+		}
+		if err := f.set(&cfg, s); err != nil {
+			errs = cfg.addError(errs, fmt.Errorf("%s: %w", f.env, err))
+			continue
+		}
+		cfg.setSource(f.env, sourceEnv)
+	}
+	for _, p := range passed {
+		if err := p.field.set(&cfg, p.value); err != nil {
+			errs = cfg.addError(errs, fmt.Errorf("--%s: %w", p.field.flagName(), err))
+			continue
+		}
+		cfg.setSource(p.field.env, sourceFlag)
+	}
+	// DB_DSN_FILE wins over DB_DSN from the environment or CONFIG_FILE,
+	// but not over a --db-dsn that was passed.
+	if cfg.DSNFile != "" && cfg.sources["DB_DSN"] != sourceFlag {
+		if dsn, err := readDSNFile(cfg.DSNFile); err != nil {
+			errs = append(errs, fmt.Errorf("DB_DSN_FILE: %w", err))
+		} else {
+			cfg.DSN = dsn
+			cfg.setSource("DB_DSN", sourceSecretFile)
+		}
+	}
+	if fs.NArg() > 0 {
+		errs = append(errs, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " ")))
+	}
+	errs = append(errs, cfg.validate()...)
+	if *migrateOnly && cfg.DSN == "" {
+		errs = append(errs, errors.New("--migrate-only needs DB_DSN or DB_DSN_FILE"))
+	}
+	if cfg.ReadDSN != "" && cfg.DSN == "" {
+		errs = append(errs, errors.New("DB_READ_DSN needs DB_DSN or DB_DSN_FILE for the primary"))
+	}
+	if err := errors.Join(errs...); err != nil {
+		return cfg, err
+	}
+	if *validateOnly {
+		return cfg, errValidateOnly
+	}
+	if *migrateOnly {
+		return cfg, errMigrateOnly
+	}
+	return cfg, nil
+}
+
+// flagValue is a setting passed on the command line, applied once the file
+// and the environment have been.
+type flagValue struct {
+	field configField
+	value string
+}
+
+// newFlagSet defines a flag for every config field. Parsing records the
+// passed ones in passed, in command-line order.
+func newFlagSet(passed *[]flagValue) *flag.FlagSet {
+	fs := flag.NewFlagSet("api", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage of api:\n\nEach flag falls back to the environment variable named after it, then to CONFIG_FILE, then to its default.\n\n")
+		fs.PrintDefaults()
+	}
+	for _, f := range configFields {
+		fs.Func(f.flagName(), fmt.Sprintf("%s (env %s)", f.usage, f.env), func(s string) error {
+			*passed = append(*passed, flagValue{field: f, value: s})
+			return nil
+		})
+	}
+	return fs
+}
+
+// validate checks the rules that span more than one setting.
+func (c Config) validate() []error {
+	var errs []error
+	if c.DBPool.MaxIdleConns > c.DBPool.MaxOpenConns {
+		errs = append(errs, fmt.Errorf("DB_MAX_IDLE_CONNS (%d) must not exceed DB_MAX_OPEN_CONNS (%d)", c.DBPool.MaxIdleConns, c.DBPool.MaxOpenConns))
+	}
+	if !c.SinglePort && c.Port == c.PublicPort {
+		errs = append(errs, fmt.Errorf("PORT and PUBLIC_PORT are both %d; set SINGLE_PORT to share one port", c.Port))
+	}
+	if c.AdminPort != 0 {
+		if c.AdminToken == "" {
+			errs = append(errs, errors.New("ADMIN_PORT requires ADMIN_TOKEN"))
+		}
+		if c.AdminPort == c.Port || (!c.SinglePort && c.AdminPort == c.PublicPort) {
+			errs = append(errs, fmt.Errorf("ADMIN_PORT (%d) must differ from PORT and PUBLIC_PORT", c.AdminPort))
+		}
+	}
+	if c.ShutdownDrainDelay >= c.ShutdownTimeout {
+		errs = append(errs, fmt.Errorf("SHUTDOWN_DRAIN_DELAY (%s) must be shorter than SHUTDOWN_TIMEOUT (%s)", c.ShutdownDrainDelay, c.ShutdownTimeout))
+	}
+	if c.FaultInjection && isProductionEnv(c.Env) {
+		errs = append(errs, fmt.Errorf("FAULT_INJECTION must not be set when APP_ENV is %s", c.Env))
+	}
+	return errs
+}
+
+// requestTimeouts builds the per-route deadlines, with ROUTE_TIMEOUTS
+// layered over the routes that are unbounded by default.
+func (c Config) requestTimeouts() routeDurations {
+	cfg := routeDurations{fallback: c.RequestTimeout, routes: unboundedRoutes()}
+	for route, timeout := range c.RouteTimeouts {
+		cfg.routes[route] = timeout
+	}
+	return cfg
+}
+
+// slowRequestThresholds builds the per-route slow request thresholds, with
+// ROUTE_SLOW_THRESHOLDS layered over the long-running routes, which are
+// never flagged by default.
+func (c Config) slowRequestThresholds() routeDurations {
+	cfg := routeDurations{fallback: c.SlowRequestThreshold, routes: unboundedRoutes()}
+	for route, threshold := range c.RouteSlowThresholds {
+		cfg.routes[route] = threshold
+	}
+	return cfg
+}
+
+// cacheMaxAges builds the per-route Cache-Control max-age, with
+// ROUTE_CACHE_MAX_AGE layered over the defaults for /version and
+// /openapi.json.
+func (c Config) cacheMaxAges() routeDurations {
+	cfg := routeDurations{routes: defaultCacheMaxAges()}
+	for route, maxAge := range c.RouteCacheMaxAges {
+		cfg.routes[route] = maxAge
+	}
+	return cfg
+}
+
+// LogValue logs the effective configuration without its secrets.
+func (c Config) LogValue() slog.Value {
+	metricsAuthMode := "none"
+	switch {
+	case c.MetricsAuth.user != "":
+		metricsAuthMode = "basic"
+	case c.MetricsAuth.token != "":
+		metricsAuthMode = "bearer"
+	}
+	routeTimeouts := make(map[string]string, len(c.RouteTimeouts))
+	for route, timeout := range c.RouteTimeouts {
+		routeTimeouts[route] = timeout.String()
+	}
+	routeSlowThresholds := make(map[string]string, len(c.RouteSlowThresholds))
+	for route, threshold := range c.RouteSlowThresholds {
+		routeSlowThresholds[route] = threshold.String()
+	}
+	routeCacheMaxAges := make(map[string]string, len(c.RouteCacheMaxAges))
+	for route, maxAge := range c.RouteCacheMaxAges {
+		routeCacheMaxAges[route] = maxAge.String()
+	}
+	return slog.GroupValue(
+		slog.Int("port", c.Port),
+		slog.Int("public_port", c.PublicPort),
+		slog.Int("admin_port", c.AdminPort),
+		slog.String("env", c.Env),
+		slog.String("service_name", c.ServiceName),
+		slog.String("db_dsn", redactDSN(c.DSN)),
+		slog.String("db_dsn_file", c.DSNFile),
+		slog.String("db_read_dsn", redactDSN(c.ReadDSN)),
+		slog.String("db_driver", c.DBDriver),
+		slog.Any("db_pool", c.DBPool),
+		slog.String("db_keepalive_interval", c.DBKeepaliveInterval.String()),
+		slog.String("log_level", c.LogLevel.String()),
+		slog.String("log_format", c.LogFormat),
+		slog.String("log_output", c.LogOutput),
+		slog.String("syslog_network", c.SyslogNetwork),
+		slog.String("syslog_addr", c.SyslogAddr),
+		slog.Bool("log_source", c.LogSource),
+		slog.String("access_log_format", c.AccessLogFormat),
+		slog.Float64("debug_log_sample", c.DebugLogSample),
+		slog.String("debug_log_match", c.DebugLogMatch),
+		slog.String("rate_limit", c.RateLimit.String()),
+		slog.Float64("rate_limit_per_second", float64(c.RateLimit.limit())),
+		slog.Bool("db_required", c.DBRequired),
+		slog.Bool("log_pipeline_required", c.LogPipelineRequired),
+		slog.String("ready_cache_ttl", c.ReadyCacheTTL.String()),
+		slog.String("ready_ping_timeout", c.ReadyPingTimeout.String()),
+		slog.Bool("ready_check_write", c.ReadyCheckWrite),
+		slog.String("stats_query_timeout", c.StatsQueryTimeout.String()),
+		slog.String("request_timeout", c.RequestTimeout.String()),
+		slog.Any("route_timeouts", routeTimeouts),
+		slog.String("slow_request_threshold", c.SlowRequestThreshold.String()),
+		slog.Any("route_slow_thresholds", routeSlowThresholds),
+		slog.Any("route_cache_max_age", routeCacheMaxAges),
+		slog.String("time_cache_resolution", c.TimeCacheResolution.String()),
+		slog.Any("metrics_duration_buckets", c.DurationBuckets),
+		slog.Bool("metrics_strict_routes", c.MetricsStrictRoutes),
+		slog.Any("slo_routes", c.SLORoutes),
+		slog.Bool("slo_count_rate_limited", c.SLOCountRateLimited),
+		slog.Int("healthz_degraded_status", c.HealthzDegradedStatus),
+		slog.Int("healthz_error_status", c.HealthzErrorStatus),
+		slog.Bool("admin_token_set", c.AdminToken != ""),
+		slog.String("metrics_auth", metricsAuthMode),
+		slog.Bool("enable_pprof", c.EnablePprof),
+		slog.Bool("fault_injection", c.FaultInjection),
+		slog.String("fault_delay_p50", c.FaultDelayP50.String()),
+		slog.Float64("fault_error_rate", c.FaultErrorRate),
+		slog.Any("trusted_proxies", c.TrustedProxies),
+		slog.String("shutdown_drain_delay", c.ShutdownDrainDelay.String()),
+		slog.String("shutdown_timeout", c.ShutdownTimeout.String()),
+		slog.Bool("single_port", c.SinglePort),
+		slog.String("internal_prefix", c.InternalPrefix),
+		slog.String("otel_exporter_otlp_endpoint", c.OTLPEndpoint),
+		slog.String("otel_exporter_otlp_metrics_endpoint", c.OTLPMetricsEndpoint),
+		slog.String("otlp_metrics_interval", c.OTLPMetricsInterval.String()),
+		// Webhook URLs often carry their credentials in the path.
+		slog.Bool("error_webhook_url_set", c.ErrorWebhookURL != ""),
+		slog.String("statsd_addr", c.StatsdAddr),
+		slog.String("statsd_prefix", c.StatsdPrefix),
+		slog.Any("statsd_tags", c.StatsdTags),
+		slog.Bool("readiness_webhook_url_set", c.ReadinessWebhookURL != ""),
+		slog.String("readiness_watch_interval", c.ReadinessInterval.String()),
+	)
+}
+
+// fallbackError is an invalid value of a logging setting or RATE_LIMIT. It
+// leaves the setting as it was and is only warned about, since a typo in
+// how the service logs or throttles shouldn't keep it from starting.
+type fallbackError struct{ err error }
+
+func (e fallbackError) Error() string { return e.err.Error() }
+func (e fallbackError) Unwrap() error { return e.err }
+
+// lenient makes the errors of set fallbackErrors.
+func lenient(set func(*Config, string) error) func(*Config, string) error {
+	return func(c *Config, s string) error {
+		if err := set(c, s); err != nil {
+			return fallbackError{err}
+		}
+		return nil
+	}
+}
+
+// addError appends err to errs, or records it as a warning when it is a
+// fallbackError.
+func (c *Config) addError(errs []error, err error) []error {
+	if fe := (fallbackError{}); errors.As(err, &fe) {
+		c.warnings = append(c.warnings, err)
+		return errs
+	}
+	return append(errs, err)
+}
+
+func stringVar(field func(*Config) *string) func(*Config, string) error {
+	return func(c *Config, s string) error {
+		*field(c) = s
+		return nil
+	}
+}
+
+func boolVar(field func(*Config) *bool) func(*Config, string) error {
+	return func(c *Config, s string) error {
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("want true or false, got %q", s)
+		}
+		*field(c) = b
+		return nil
+	}
+}
+
+func portVar(field func(*Config) *int) func(*Config, string) error {
+	return func(c *Config, s string) error {
+		port, err := strconv.Atoi(s)
+		if err != nil || port < 1 || port > 65535 {
+			return fmt.Errorf("want a port between 1 and 65535, got %q", s)
+		}
+		*field(c) = port
+		return nil
+	}
+}
+
+func positiveIntVar(field func(*Config) *int) func(*Config, string) error {
+	return func(c *Config, s string) error {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("want a positive integer, got %q", s)
+		}
+		*field(c) = n
+		return nil
+	}
+}
+
+func nonNegativeIntVar(field func(*Config) *int) func(*Config, string) error {
+	return func(c *Config, s string) error {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return fmt.Errorf("want a non-negative integer, got %q", s)
+		}
+		*field(c) = n
+		return nil
+	}
+}
+
+func statusVar(field func(*Config) *int) func(*Config, string) error {
+	return func(c *Config, s string) error {
+		code, err := strconv.Atoi(s)
+		if err != nil || code < 200 || code > 599 {
+			return fmt.Errorf("want an HTTP status between 200 and 599, got %q", s)
+		}
+		*field(c) = code
+		return nil
+	}
+}
+
+// durationVar parses a Go duration, which must be positive, or non-negative
+// when allowZero is set.
+func durationVar(field func(*Config) *time.Duration, allowZero bool) func(*Config, string) error {
+	return func(c *Config, s string) error {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("want a duration such as 5s, got %q", s)
+		}
+		if d < 0 && allowZero {
+			return fmt.Errorf("must not be negative, got %s", s)
+		}
+		if d <= 0 && !allowZero {
+			return fmt.Errorf("must be positive, got %s", s)
+		}
+		*field(c) = d
+		return nil
+	}
+}
+
+// urlVar accepts an absolute http or https URL.
+func urlVar(field func(*Config) *string) func(*Config, string) error {
+	return func(c *Config, s string) error {
+		if u, err := url.Parse(s); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("want http(s)://host[:port], got %q", s)
+		}
+		*field(c) = s
+		return nil
+	}
+}
+
+// checkDSN reports whether s parses as a PostgreSQL connection string.
+func checkDSN(s string) error {
+	if _, err := pgx.ParseConfig(s); err != nil {
+		return errors.New("not a valid PostgreSQL URL or key=value connection string")
+	}
+	return nil
+}
+
+var dsnPassword = regexp.MustCompile(`(password\s*=\s*)('(?:[^'\\]|\\.)*'|\S*)`)
+
+// redactDSN masks the password in a URL or key=value connection string.
+func redactDSN(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && u.Scheme != "" {
+		if q := u.Query(); q.Has("password") {
+			q.Set("password", redacted)
+			u.RawQuery = q.Encode()
+		}
+		return u.Redacted()
+	}
+	return dsnPassword.ReplaceAllString(dsn, "${1}xxxxx")
+}
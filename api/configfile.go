@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// fileKey is the key for f in CONFIG_FILE: its environment variable in lower
+// case, e.g. public_port for PUBLIC_PORT.
+func (f configField) fileKey() string {
+	return strings.ToLower(f.env)
+}
+
+// loadConfigFile applies the settings in the YAML file at path to cfg and
+// returns every problem found, including keys that aren't settings, so a
+// typo can't silently leave a default in place.
+func loadConfigFile(cfg *Config, path string) []error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return []error{fmt.Errorf("CONFIG_FILE: %w", err)}
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return []error{fmt.Errorf("%s: %w", path, err)}
+	}
+	if len(doc.Content) == 0 {
+		return nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return []error{fmt.Errorf("%s: want a mapping of settings", path)}
+	}
+
+	fields := make(map[string]configField, len(configFields))
+	for _, f := range configFields {
+		fields[f.fileKey()] = f
+	}
+	var errs []error
+	seen := make(map[string]bool)
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		f, ok := fields[key.Value]
+		switch {
+		case !ok:
+			errs = append(errs, fmt.Errorf("%s:%d: unknown key %q", path, key.Line, key.Value))
+			continue
+		case seen[key.Value]:
+			errs = append(errs, fmt.Errorf("%s:%d: duplicate key %q", path, key.Line, key.Value))
+			continue
+		}
+		seen[key.Value] = true
+		if value.Tag == "!!null" {
+			continue
+		}
+		s, err := nodeString(value)
+		if err == nil {
+			err = f.set(cfg, s)
+		}
+		if err != nil {
+			errs = cfg.addError(errs, fmt.Errorf("%s:%d: %s: %w", path, key.Line, key.Value, err))
+			continue
+		}
+		cfg.setSource(f.env, sourceFile)
+	}
+	return errs
+}
+
+// nodeString renders a YAML value in the form its environment variable
+// takes: scalars as written, lists joined with commas and maps as
+// comma-separated key=value pairs, e.g. route_timeouts: {/api/v1/stats: 30s}.
+func nodeString(n *yaml.Node) (string, error) {
+	switch n.Kind {
+	case yaml.ScalarNode:
+		return n.Value, nil
+	case yaml.SequenceNode:
+		items := make([]string, 0, len(n.Content))
+		for _, item := range n.Content {
+			if item.Kind != yaml.ScalarNode {
+				return "", errors.New("want a list of plain values")
+			}
+			items = append(items, item.Value)
+		}
+		return strings.Join(items, ","), nil
+	case yaml.MappingNode:
+		pairs := make([]string, 0, len(n.Content)/2)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k, v := n.Content[i], n.Content[i+1]
+			if k.Kind != yaml.ScalarNode || v.Kind != yaml.ScalarNode {
+				return "", errors.New("want a map of plain values")
+			}
+			pairs = append(pairs, k.Value+"="+v.Value)
+		}
+		return strings.Join(pairs, ","), nil
+	}
+	return "", errors.New("want a value, list or map")
+}
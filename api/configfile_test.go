@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfigFile writes body to a config.yaml in a temporary directory and
+// returns its path.
+func writeConfigFile(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	path := writeConfigFile(t, `
+port: 9000
+db_dsn: "host=db user=app password=secret"
+single_port: true
+request_timeout: 2s
+route_timeouts:
+  /api/v1/stats: 30s
+  /live: 1s
+trusted_proxies: [10.0.0.0/8, 192.168.0.1]
+metrics_duration_buckets: [0.5, 1]
+admin_token:
+`)
+	cfg := defaultConfig()
+	if errs := loadConfigFile(&cfg, path); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	want := defaultConfig()
+	want.Port = 9000
+	want.DSN = "host=db user=app password=secret"
+	want.SinglePort = true
+	want.RequestTimeout = 2 * time.Second
+	want.RouteTimeouts = map[string]time.Duration{routeStats: 30 * time.Second, routeLive: time.Second}
+	want.TrustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("192.168.0.1/32")}
+	want.DurationBuckets = []float64{0.5, 1}
+	want.sources = cfg.sources // checked by TestLoadConfig_Sources
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("expected %+v, got %+v", want, cfg)
+	}
+}
+
+func TestLoadConfigFile_Errors(t *testing.T) {
+	tests := []struct {
+		name, body, want string
+	}{
+		{"unknown key", "port: 9000\nrate_limt: 5\n", `config.yaml:2: unknown key "rate_limt"`},
+		{"env-style key", "PORT: 9000\n", `unknown key "PORT"`},
+		{"duplicate key", "port: 9000\nport: 9001\n", `config.yaml:2: duplicate key "port"`},
+		{"invalid value", "port: lots\n", "config.yaml:1: port: want a port"},
+		{"nested value", "trusted_proxies: [[10.0.0.0/8]]\n", "trusted_proxies: want a list of plain values"},
+		{"not a mapping", "- port\n", "want a mapping of settings"},
+		{"not yaml", "port: [9000\n", "config.yaml"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := defaultConfig()
+			err := errors.Join(loadConfigFile(&cfg, writeConfigFile(t, tt.body))...)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected an error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+
+	cfg := defaultConfig()
+	if errs := loadConfigFile(&cfg, filepath.Join(t.TempDir(), "missing.yaml")); len(errs) != 1 || !strings.Contains(errs[0].Error(), "CONFIG_FILE:") {
+		t.Errorf("expected a missing file to be reported, got %v", errs)
+	}
+	if errs := loadConfigFile(&cfg, writeConfigFile(t, "")); len(errs) != 0 {
+		t.Errorf("expected an empty file to change nothing, got %v", errs)
+	}
+}
+
+// Each layer overrides the one below it: default < file < environment < flag.
+func TestLoadConfig_Precedence(t *testing.T) {
+	path := writeConfigFile(t, "port: 9001\npublic_port: 9101\nrate_limit: 20/minute\napp_env: staging\n")
+	tests := []struct {
+		name       string
+		env        map[string]string
+		args       []string
+		wantPort   int
+		wantRate   string
+		wantPubl   int
+		wantAppEnv string
+	}{
+		{"defaults", nil, nil, 8080, "100/second", 8090, "development"},
+		{"file", map[string]string{"CONFIG_FILE": path}, nil, 9001, "20/minute", 9101, "staging"},
+		{"env over file", map[string]string{"CONFIG_FILE": path, "PORT": "9002", "APP_ENV": "qa"}, nil, 9002, "20/minute", 9101, "qa"},
+		{"flag over env", map[string]string{"CONFIG_FILE": path, "PORT": "9002", "APP_ENV": "qa"}, []string{"--port=9003"}, 9003, "20/minute", 9101, "qa"},
+		{"flag names the file", map[string]string{"PORT": "9002"}, []string{"--config-file", path}, 9002, "20/minute", 9101, "staging"},
+		{"flag over file", nil, []string{"--config-file", path, "--rate-limit", "40", "--app-env", "prod"}, 9001, "40/second", 9101, "prod"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearConfigEnv(t)
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			cfg, err := LoadConfig(tt.args)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if cfg.Port != tt.wantPort || cfg.RateLimit.String() != tt.wantRate || cfg.PublicPort != tt.wantPubl || cfg.Env != tt.wantAppEnv {
+				t.Errorf("expected port %d, rate %s, public port %d, env %s; got %d, %s, %d, %s",
+					tt.wantPort, tt.wantRate, tt.wantPubl, tt.wantAppEnv, cfg.Port, cfg.RateLimit, cfg.PublicPort, cfg.Env)
+			}
+		})
+	}
+}
+
+func TestLoadConfig_ValidateOnly(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv("CONFIG_FILE", writeConfigFile(t, "port: 9000\n"))
+	cfg, err := LoadConfig([]string{"--validate-config"})
+	if !errors.Is(err, errValidateOnly) || cfg.Port != 9000 {
+		t.Errorf("expected errValidateOnly with the loaded config, got %v, %+v", err, cfg)
+	}
+
+	t.Setenv("CONFIG_FILE", writeConfigFile(t, "port: 9000\npublic_port: 9000\n"))
+	if _, err := LoadConfig([]string{"--validate-config"}); err == nil || errors.Is(err, errValidateOnly) {
+		t.Errorf("expected the validation error, got %v", err)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestDBPoolConfig_Apply(t *testing.T) {
+	d, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = d.Close() }()
+
+	dbPoolConfig{MaxOpenConns: 40, MaxIdleConns: 10, ConnMaxLifetime: time.Hour, ConnMaxIdleTime: time.Minute}.apply(d)
+	if got := d.Stats().MaxOpenConnections; got != 40 {
+		t.Errorf("expected MaxOpenConnections 40, got %d", got)
+	}
+}
+
+func TestDBStatsCollector_ReportsPoolSettings(t *testing.T) {
+	d, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = d.Close() }()
+	defer func(p dbPoolConfig) { dbPool = p }(dbPool)
+	dbPool = dbPoolConfig{MaxOpenConns: 40, MaxIdleConns: 10, ConnMaxLifetime: time.Hour}
+	dbPool.apply(d)
+	dbMu.Lock()
+	db = d
+	dbMu.Unlock()
+	defer func() {
+		dbMu.Lock()
+		db = nil
+		dbMu.Unlock()
+	}()
+
+	want := `
+# HELP db_conn_max_idle_time_seconds Idle time after which a connection is closed, 0 if never
+# TYPE db_conn_max_idle_time_seconds gauge
+db_conn_max_idle_time_seconds 0
+# HELP db_conn_max_lifetime_seconds Age at which a connection is replaced, 0 if never
+# TYPE db_conn_max_lifetime_seconds gauge
+db_conn_max_lifetime_seconds 3600
+# HELP db_max_idle_connections Maximum number of idle connections
+# TYPE db_max_idle_connections gauge
+db_max_idle_connections 10
+# HELP db_max_open_connections Maximum number of open connections
+# TYPE db_max_open_connections gauge
+db_max_open_connections 40
+`
+	if err := testutil.CollectAndCompare(newDBStatsCollector(), strings.NewReader(want),
+		"db_max_open_connections", "db_max_idle_connections", "db_conn_max_lifetime_seconds", "db_conn_max_idle_time_seconds"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// debugLogSampling picks the requests that get a "request debug" record;
+// main sets it from DEBUG_LOG_SAMPLE and DEBUG_LOG_MATCH. A zero rate turns
+// the record off.
+type debugLogSampling struct {
+	rate   float64
+	prefix string
+}
+
+var debugLog debugLogSampling
+
+// debugLogHeaders are the only request headers a debug record may carry.
+// Credentials such as Authorization and Cookie are deliberately absent.
+var debugLogHeaders = []string{
+	"Accept",
+	"Accept-Encoding",
+	"Accept-Language",
+	"Content-Length",
+	"Content-Type",
+	"Forwarded",
+	"If-None-Match",
+	"Referer",
+	"Traceparent",
+	"User-Agent",
+	"X-Forwarded-For",
+	"X-Forwarded-Proto",
+	"X-Real-IP",
+}
+
+// sensitiveQueryKeys mark query parameters whose values are masked: any key
+// containing one of them, ignoring case.
+var sensitiveQueryKeys = []string{"auth", "key", "password", "secret", "session", "signature", "token"}
+
+// parseSampleRate accepts a fraction between 0 and 1.
+func parseSampleRate(s string) (float64, error) {
+	rate, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(rate) || rate < 0 || rate > 1 {
+		return 0, fmt.Errorf("want a fraction between 0 and 1, got %q", s)
+	}
+	return rate, nil
+}
+
+// sampled decides, once per request, whether r gets a debug record. With an
+// X-Request-ID the decision is a hash of it, so the ingress, every replica
+// and a retried request agree on which IDs are sampled; without one it is
+// random.
+func (s debugLogSampling) sampled(r *http.Request) bool {
+	if s.rate <= 0 || !strings.HasPrefix(r.URL.Path, s.prefix) {
+		return false
+	}
+	if s.rate >= 1 {
+		return true
+	}
+	id := r.Header.Get(headerRequestID)
+	if id == "" {
+		return rand.Float64() < s.rate
+	}
+	sum := sha256.Sum256([]byte(id))
+	return float64(binary.BigEndian.Uint64(sum[:8]))/math.MaxUint64 < s.rate
+}
+
+// logRequestDebug logs the allowlisted headers and the query parameters of
+// a sampled request as a "request debug" record beside its access log
+// line, keyed by the same request ID. It is logged at debug level, so it
+// also needs LOG_LEVEL=debug or a PUT /admin/loglevel.
+func logRequestDebug(ctx context.Context, r *http.Request, rec accessRecord) {
+	if !slog.Default().Enabled(ctx, slog.LevelDebug) {
+		return
+	}
+	slog.DebugContext(ctx, "request debug", // #nosec G706 -- slog JSON handler safely encodes values
+		"request_id", rec.requestID,
+		"method", rec.method,
+		"path", rec.endpoint,
+		"status", rec.status,
+		slog.Attr{Key: "headers", Value: slog.GroupValue(debugHeaders(r.Header)...)},
+		slog.Attr{Key: "query", Value: slog.GroupValue(debugQuery(r)...)},
+	)
+}
+
+// debugHeaders returns the allowlisted headers present in h.
+func debugHeaders(h http.Header) []slog.Attr {
+	var attrs []slog.Attr
+	for _, name := range debugLogHeaders {
+		if values := h.Values(name); len(values) > 0 {
+			attrs = append(attrs, slog.String(name, strings.Join(values, ", ")))
+		}
+	}
+	return attrs
+}
+
+// debugQuery returns r's query parameters, masking sensitive ones.
+func debugQuery(r *http.Request) []slog.Attr {
+	query := r.URL.Query()
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	attrs := make([]slog.Attr, 0, len(keys))
+	for _, key := range keys {
+		value := strings.Join(query[key], ",")
+		if isSensitiveQueryKey(key) {
+			value = redacted
+		}
+		attrs = append(attrs, slog.String(key, value))
+	}
+	return attrs
+}
+
+func isSensitiveQueryKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range sensitiveQueryKeys {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return false
+}
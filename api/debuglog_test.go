@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// withDebugLog sets debugLog for the duration of the test.
+func withDebugLog(t *testing.T, s debugLogSampling) {
+	t.Helper()
+	prev := debugLog
+	debugLog = s
+	t.Cleanup(func() { debugLog = prev })
+}
+
+// serveDebugLogged sends req through the metrics middleware of the internal
+// mux and returns the "request debug" records logged for it.
+func serveDebugLogged(t *testing.T, req *http.Request) []string {
+	t.Helper()
+	buf := captureLogs(t)
+	logLevels.set(slog.LevelDebug, 0)
+	m, _ := newTestMetrics(t)
+	metricsMiddleware(m, newInternalMux(nil, m, time.Now())).ServeHTTP(httptest.NewRecorder(), req)
+
+	var records []string
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if strings.Contains(line, `"msg":"request debug"`) {
+			records = append(records, line)
+		}
+	}
+	return records
+}
+
+func TestLogRequestDebug(t *testing.T) {
+	withDebugLog(t, debugLogSampling{rate: 1, prefix: "/version"})
+	req := httptest.NewRequest(http.MethodGet, "/version?verbose=1&b=2&b=3", nil)
+	req.Header.Set(headerRequestID, "req-debug")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", "probe/1.0")
+
+	records := serveDebugLogged(t, req)
+	if len(records) != 1 {
+		t.Fatalf("expected one request debug record, got %d", len(records))
+	}
+	for _, want := range []string{
+		`"level":"DEBUG"`,
+		`"request_id":"req-debug"`,
+		`"method":"GET"`,
+		`"path":"/version"`,
+		`"status":200`,
+		`"headers":{"Accept":"application/json","User-Agent":"probe/1.0"}`,
+		`"query":{"b":"2,3","verbose":"1"}`,
+	} {
+		if !strings.Contains(records[0], want) {
+			t.Errorf("expected %s in %s", want, records[0])
+		}
+	}
+}
+
+func TestLogRequestDebug_RedactsSecrets(t *testing.T) {
+	resetLogSecrets(t)
+	addLogSecret("postgres://app:hunter2@db:5432/app")
+	withDebugLog(t, debugLogSampling{rate: 1})
+	req := httptest.NewRequest(http.MethodGet, "/version?access_token=tok123&apiKey=key123&session_id=sess123&q=ok", nil)
+	req.Header.Set("Authorization", "Bearer admin-secret")
+	req.Header.Set("Proxy-Authorization", "Basic cHJveHk6c2VjcmV0")
+	req.Header.Set("Cookie", "session=cookie-secret")
+	req.Header.Set("X-Api-Key", "header-key")
+	req.Header.Set("Referer", "https://example.com/?dsn=postgres://app:hunter2@db/app")
+
+	records := serveDebugLogged(t, req)
+	if len(records) != 1 {
+		t.Fatalf("expected one request debug record, got %d", len(records))
+	}
+	record := records[0]
+	for _, leaked := range []string{
+		"Authorization", "admin-secret", "cHJveHk6c2VjcmV0",
+		"Cookie", "cookie-secret", "X-Api-Key", "header-key",
+		"tok123", "key123", "sess123", "hunter2",
+	} {
+		if strings.Contains(record, leaked) {
+			t.Errorf("expected %q to be kept out of %s", leaked, record)
+		}
+	}
+	for _, want := range []string{
+		`"access_token":"xxxxx"`,
+		`"apiKey":"xxxxx"`,
+		`"session_id":"xxxxx"`,
+		`"q":"ok"`,
+		`"Referer":"https://example.com/?dsn=postgres://app:xxxxx@db/app"`,
+	} {
+		if !strings.Contains(record, want) {
+			t.Errorf("expected %s in %s", want, record)
+		}
+	}
+}
+
+func TestLogRequestDebug_NeedsDebugLevel(t *testing.T) {
+	withDebugLog(t, debugLogSampling{rate: 1})
+	buf := captureLogs(t)
+	m, _ := newTestMetrics(t)
+	req := httptest.NewRequest(http.MethodGet, "/version", nil)
+	metricsMiddleware(m, newInternalMux(nil, m, time.Now())).ServeHTTP(httptest.NewRecorder(), req)
+	if strings.Contains(buf.String(), "request debug") {
+		t.Errorf("expected no debug record at info level, got %s", buf)
+	}
+}
+
+func TestDebugLogSampling(t *testing.T) {
+	withID := func(path, id string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if id != "" {
+			req.Header.Set(headerRequestID, id)
+		}
+		return req
+	}
+	if (debugLogSampling{}).sampled(withID("/version", "a")) {
+		t.Error("expected no sampling at rate 0")
+	}
+	if (debugLogSampling{rate: 1, prefix: "/api/"}).sampled(withID("/version", "a")) {
+		t.Error("expected a path outside the prefix not to be sampled")
+	}
+	if !(debugLogSampling{rate: 1, prefix: "/api/"}).sampled(withID("/api/v1/time", "")) {
+		t.Error("expected every matching request at rate 1")
+	}
+
+	// The decision follows the request ID, so it is the same every time an
+	// ID is seen, and the rate holds across IDs.
+	s := debugLogSampling{rate: 0.25}
+	sampled := 0
+	for i := 0; i < 4000; i++ {
+		id := fmt.Sprintf("req-%d", i)
+		first := s.sampled(withID("/version", id))
+		if again := s.sampled(withID("/version", id)); again != first {
+			t.Fatalf("%s: expected a consistent decision", id)
+		}
+		if first {
+			sampled++
+		}
+	}
+	if sampled < 800 || sampled > 1200 {
+		t.Errorf("expected about 1000 of 4000 IDs sampled, got %d", sampled)
+	}
+}
+
+func TestParseSampleRate(t *testing.T) {
+	for in, want := range map[string]float64{"0": 0, "0.01": 0.01, "1": 1} {
+		if got, err := parseSampleRate(in); err != nil || got != want {
+			t.Errorf("%q: expected %v, got %v, %v", in, want, got, err)
+		}
+	}
+	for _, bad := range []string{"-0.1", "1.01", "NaN", "half"} {
+		if _, err := parseSampleRate(bad); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
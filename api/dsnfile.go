@@ -0,0 +1,80 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+// readDSNFile returns the trimmed contents of a DB_DSN_FILE secret.
+func readDSNFile(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	dsn := strings.TrimSpace(string(b))
+	if dsn == "" {
+		return "", errors.New("file is empty")
+	}
+	if err := checkDSN(dsn); err != nil {
+		return "", err
+	}
+	return dsn, nil
+}
+
+// dsnSource is the DSN the service connects with. A configuration reload
+// that reads a different DSN, from a rotated DB_DSN_FILE secret say, hands
+// it to switchTo so it takes effect without a restart.
+type dsnSource struct {
+	connect func(dsn string) (*sql.DB, error)
+	// reloaded, if set, moves anything else holding a connection over to
+	// a DSN that switchTo has just switched db to.
+	reloaded func(dsn string) error
+
+	mu  sync.Mutex
+	dsn string
+}
+
+func (s *dsnSource) current() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dsn
+}
+
+// switchTo connects with dsn, if it differs from the current DSN, and swaps
+// the new pool in for db. The old pool is closed once the new one is in
+// place, and keeps serving if the new DSN can't connect.
+func (s *dsnSource) switchTo(dsn string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if dsn == s.dsn {
+		return nil
+	}
+	addLogSecret(dsn)
+	d, err := s.connect(dsn)
+	if err != nil {
+		return err
+	}
+	s.dsn = dsn
+
+	dbMu.Lock()
+	old := db
+	db = d
+	dbMu.Unlock()
+	dbConnected.Store(true)
+	if old != nil {
+		if err := old.Close(); err != nil {
+			slog.Error("error closing db", "error", err)
+		}
+	}
+	if s.reloaded != nil {
+		if err := s.reloaded(dsn); err != nil {
+			return err
+		}
+	}
+	slog.Info("switched to the reloaded DSN", "db_dsn", redactDSN(dsn))
+	return nil
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// dsnResolveTimeout bounds the DNS lookup of each DSN host.
+	dsnResolveTimeout = 2 * time.Second
+	// dsnDialTimeout bounds the bare TCP dial of each DSN host.
+	dsnDialTimeout = 2 * time.Second
+	// dsnUnresolvableRetries caps the connection attempts while no DSN host
+	// resolves: a misspelt name won't fix itself, so waiting out the full
+	// backoff only delays the error.
+	dsnUnresolvableRetries = 2
+	// defaultPostgresPort is the port of a DSN host without one.
+	defaultPostgresPort = "5432"
+)
+
+// lookupHost and dialContext are the resolver and dialer preflightDSN uses;
+// tests replace them.
+var (
+	lookupHost  = net.DefaultResolver.LookupHost
+	dialContext = (&net.Dialer{Timeout: dsnDialTimeout}).DialContext
+)
+
+// unresolvableError is a pre-flight failure where no DSN host resolves.
+type unresolvableError struct{ err error }
+
+func (e unresolvableError) Error() string { return e.err.Error() }
+func (e unresolvableError) Unwrap() error { return e.err }
+
+// dsnAddr is a host and port a DSN names.
+type dsnAddr struct {
+	host, port string
+}
+
+// preflightDSN checks that a host of dsn resolves and accepts a TCP
+// connection before pgx is handed it, so a typo in a ConfigMap reads as
+// `DSN host "postgress.default" does not resolve` instead of a driver error.
+// It passes when any host answers, as pgx tries each in turn. Unix socket
+// hosts and DSNs without a host are left to pgx. The error never contains
+// the DSN itself, so it can't carry the password.
+func preflightDSN(ctx context.Context, dsn string) error {
+	addrs, err := dsnAddrs(dsn)
+	if err != nil {
+		return err
+	}
+	if len(addrs) == 0 {
+		return nil
+	}
+	var errs []error
+	unresolved := 0
+	for _, a := range addrs {
+		err := probeDSNHost(ctx, a)
+		if err == nil {
+			return nil
+		}
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			unresolved++
+		}
+		errs = append(errs, err)
+	}
+	if unresolved == len(addrs) {
+		return unresolvableError{errors.Join(errs...)}
+	}
+	return errors.Join(errs...)
+}
+
+// probeDSNHost resolves a.host and dials its port on the first address.
+func probeDSNHost(ctx context.Context, a dsnAddr) error {
+	resolveCtx, cancel := context.WithTimeout(ctx, dsnResolveTimeout)
+	ips, err := lookupHost(resolveCtx, a.host)
+	cancel()
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return fmt.Errorf("DSN host %q does not resolve: %w", a.host, err)
+		}
+		return fmt.Errorf("DSN host %q could not be resolved: %w", a.host, err)
+	}
+	conn, err := dialContext(ctx, "tcp", net.JoinHostPort(ips[0], a.port))
+	if err != nil {
+		return fmt.Errorf("DSN host %q resolves to %s but port %s does not accept connections: %w", a.host, ips[0], a.port, err)
+	}
+	_ = conn.Close()
+	return nil
+}
+
+// dsnAddrs returns the TCP hosts of a URL (postgres://u@db1:5432,db2/app)
+// or key=value (host=db1,db2 port=5432) DSN, with the port each is dialled
+// on. Like pgx, it falls back to PGHOST and PGPORT.
+func dsnAddrs(dsn string) ([]dsnAddr, error) {
+	var hosts, ports string
+	if scheme, rest, ok := strings.Cut(dsn, "://"); ok {
+		if scheme != "postgres" && scheme != "postgresql" {
+			return nil, fmt.Errorf("DSN scheme %q is not postgres or postgresql", scheme)
+		}
+		var err error
+		if hosts, ports, err = urlHosts(rest); err != nil {
+			return nil, err
+		}
+	} else {
+		params, err := parseKeywordDSN(dsn)
+		if err != nil {
+			return nil, err
+		}
+		hosts, ports = params["host"], params["port"]
+		if addr := params["hostaddr"]; addr != "" {
+			hosts = addr
+		}
+	}
+	if hosts == "" {
+		hosts = os.Getenv("PGHOST")
+	}
+	if ports == "" {
+		ports = os.Getenv("PGPORT")
+	}
+	if hosts == "" {
+		return nil, nil
+	}
+
+	hostList := strings.Split(hosts, ",")
+	portList := strings.Split(ports, ",")
+	if len(portList) != 1 && len(portList) != len(hostList) {
+		return nil, fmt.Errorf("DSN lists %d hosts but %d ports", len(hostList), len(portList))
+	}
+	var addrs []dsnAddr
+	for i, host := range hostList {
+		port := portList[0]
+		if len(portList) > 1 {
+			port = portList[i]
+		}
+		if port == "" {
+			port = defaultPostgresPort
+		}
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return nil, errors.New("DSN port is not a number between 1 and 65535")
+		}
+		if host == "" || strings.HasPrefix(host, "/") {
+			continue
+		}
+		addrs = append(addrs, dsnAddr{host: host, port: port})
+	}
+	return addrs, nil
+}
+
+// urlHosts splits the authority of a postgres URL, rest being everything
+// after the scheme, into comma-separated hosts and ports. A host in the
+// query, as in postgres:///app?host=db, is used when the authority has none.
+func urlHosts(rest string) (hosts, ports string, err error) {
+	authority, query := rest, ""
+	if i := strings.IndexAny(rest, "/?#"); i >= 0 {
+		authority = rest[:i]
+		query, _, _ = strings.Cut(rest[i:], "#")
+		_, query, _ = strings.Cut(query, "?")
+	}
+	if i := strings.LastIndex(authority, "@"); i >= 0 {
+		authority = authority[i+1:]
+	}
+	if authority == "" {
+		q, err := url.ParseQuery(query)
+		if err != nil {
+			return "", "", errors.New("DSN query string is not valid URL encoding")
+		}
+		return q.Get("host"), q.Get("port"), nil
+	}
+
+	var hostList, portList []string
+	for _, hp := range strings.Split(authority, ",") {
+		host, port := hp, ""
+		if strings.HasPrefix(hp, "[") {
+			end := strings.Index(hp, "]")
+			if end < 0 {
+				return "", "", fmt.Errorf("DSN host %q has an unclosed [", hp)
+			}
+			host, port = hp[1:end], strings.TrimPrefix(hp[end+1:], ":")
+		} else if h, p, ok := strings.Cut(hp, ":"); ok {
+			host, port = h, p
+		}
+		unescaped, err := url.PathUnescape(host)
+		if err != nil {
+			return "", "", fmt.Errorf("DSN host %q is not valid URL encoding", host)
+		}
+		hostList = append(hostList, unescaped)
+		portList = append(portList, port)
+	}
+	if strings.Join(portList, "") == "" {
+		portList = nil
+	}
+	return strings.Join(hostList, ","), strings.Join(portList, ","), nil
+}
+
+// parseKeywordDSN parses a key=value DSN. Values may be single-quoted, with
+// \' and \\ escapes, to hold spaces.
+func parseKeywordDSN(dsn string) (map[string]string, error) {
+	const space = " \t\r\n"
+	params := make(map[string]string)
+	prev := ""
+	s := strings.TrimLeft(dsn, space)
+	for s != "" {
+		key, rest, ok := strings.Cut(s, "=")
+		key = strings.TrimRight(key, space)
+		// The stray text isn't quoted back: it may be half of an
+		// unquoted password.
+		switch {
+		case (!ok || strings.ContainsAny(key, space)) && prev == "":
+			return nil, errors.New("DSN is neither a postgres:// URL nor key=value pairs")
+		case !ok || strings.ContainsAny(key, space):
+			return nil, fmt.Errorf("DSN has text without a key after %s=", prev)
+		case key == "":
+			return nil, errors.New("DSN has a value without a key")
+		}
+		prev = key
+		rest = strings.TrimLeft(rest, space)
+		var value strings.Builder
+		if strings.HasPrefix(rest, "'") {
+			i, closed := 1, false
+			for ; i < len(rest) && !closed; i++ {
+				switch c := rest[i]; {
+				case c == '\\' && i+1 < len(rest):
+					i++
+					value.WriteByte(rest[i])
+				case c == '\'':
+					closed = true
+				default:
+					value.WriteByte(c)
+				}
+			}
+			if !closed {
+				return nil, fmt.Errorf("DSN value of %s has an unterminated quote", key)
+			}
+			rest = rest[i:]
+		} else {
+			end := strings.IndexAny(rest, space)
+			if end < 0 {
+				end = len(rest)
+			}
+			value.WriteString(rest[:end])
+			rest = rest[end:]
+		}
+		params[key] = value.String()
+		s = strings.TrimLeft(rest, space)
+	}
+	return params, nil
+}
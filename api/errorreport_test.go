@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"golang.org/x/time/rate"
+)
+
+// reportReceiver is a webhook that records the reports posted to it and
+// answers with status.
+type reportReceiver struct {
+	status int
+
+	mu           sync.Mutex
+	contentTypes []string
+	reports      []ErrorReport
+}
+
+func (rr *reportReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var rep ErrorReport
+	if err := json.NewDecoder(r.Body).Decode(&rep); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rr.mu.Lock()
+	rr.contentTypes = append(rr.contentTypes, r.Header.Get("Content-Type"))
+	rr.reports = append(rr.reports, rep)
+	rr.mu.Unlock()
+	w.WriteHeader(rr.status)
+}
+
+func newTestReporter(t *testing.T, url string) (*webhookReporter, *prometheus.CounterVec) {
+	t.Helper()
+	outcomes := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "error_reports_total"}, []string{"outcome"})
+	return newWebhookReporter(url, "api", "test", outcomes), outcomes
+}
+
+func TestWebhookReporter_Delivers(t *testing.T) {
+	receiver := &reportReceiver{status: http.StatusAccepted}
+	srv := httptest.NewServer(receiver)
+	defer srv.Close()
+	r, outcomes := newTestReporter(t, srv.URL)
+	go r.run()
+
+	r.Report(ErrorReport{
+		Message:   "panic serving request",
+		Error:     "boom",
+		Stack:     strings.Repeat("x", maxReportStackLen+100),
+		RequestID: "req-1",
+		Details:   map[string]any{"route": routeStats},
+	})
+	if err := r.Close(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	receiver.mu.Lock()
+	defer receiver.mu.Unlock()
+	if len(receiver.reports) != 1 {
+		t.Fatalf("expected 1 report, got %d", len(receiver.reports))
+	}
+	rep := receiver.reports[0]
+	if rep.Service != "api" || rep.Env != "test" || rep.Version != version || rep.RequestID != "req-1" || rep.Error != "boom" {
+		t.Errorf("unexpected report %+v", rep)
+	}
+	if rep.Details["route"] != routeStats {
+		t.Errorf("expected the route in the details, got %v", rep.Details)
+	}
+	if _, err := time.Parse(time.RFC3339Nano, rep.Time); err != nil {
+		t.Errorf("expected an RFC3339 time, got %q", rep.Time)
+	}
+	if len(rep.Stack) != maxReportStackLen || !strings.HasSuffix(rep.Stack, "(truncated)") {
+		t.Errorf("expected the stack cut to %d bytes, got %d", maxReportStackLen, len(rep.Stack))
+	}
+	if receiver.contentTypes[0] != contentTypeJSON {
+		t.Errorf("expected a JSON body, got %q", receiver.contentTypes[0])
+	}
+	if got := testutil.ToFloat64(outcomes.WithLabelValues(reportSent)); got != 1 {
+		t.Errorf("expected 1 sent report, got %v", got)
+	}
+}
+
+func TestWebhookReporter_Drops(t *testing.T) {
+	receiver := &reportReceiver{status: http.StatusOK}
+	srv := httptest.NewServer(receiver)
+	defer srv.Close()
+	r, outcomes := newTestReporter(t, srv.URL)
+
+	// Nothing is delivered until run starts, so the queue fills up behind
+	// the burst, and the rate limit stops the rest.
+	for range errorReportQueueSize + 1 {
+		r.Report(ErrorReport{Message: "server error response"})
+	}
+	if got := testutil.ToFloat64(outcomes.WithLabelValues(reportRateLimited)); got != errorReportQueueSize+1-errorReportBurst {
+		t.Errorf("expected %d rate-limited reports, got %v", errorReportQueueSize+1-errorReportBurst, got)
+	}
+	r.limiter.SetLimit(rate.Inf)
+	for range errorReportQueueSize {
+		r.Report(ErrorReport{Message: "server error response"})
+	}
+	if got := testutil.ToFloat64(outcomes.WithLabelValues(reportQueueFull)); got != errorReportBurst {
+		t.Errorf("expected %d reports dropped on a full queue, got %v", errorReportBurst, got)
+	}
+
+	go r.run()
+	if err := r.Close(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := testutil.ToFloat64(outcomes.WithLabelValues(reportSent)); got != errorReportQueueSize {
+		t.Errorf("expected the %d queued reports to be delivered on close, got %v", errorReportQueueSize, got)
+	}
+}
+
+func TestWebhookReporter_Failures(t *testing.T) {
+	receiver := &reportReceiver{status: http.StatusBadGateway}
+	srv := httptest.NewServer(receiver)
+	defer srv.Close()
+	r, outcomes := newTestReporter(t, srv.URL)
+	go r.run()
+	logs := captureLogs(t)
+
+	r.Report(ErrorReport{Message: "server error response"})
+	if err := r.Close(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := testutil.ToFloat64(outcomes.WithLabelValues(reportFailed)); got != 1 {
+		t.Errorf("expected 1 failed report, got %v", got)
+	}
+	if !strings.Contains(logs.String(), "error report delivery failed") || !strings.Contains(logs.String(), "502") {
+		t.Errorf("expected the failure to be logged with the status, got %s", logs.String())
+	}
+}
+
+// recordingReporter keeps the reports made while it is errorReporter.
+type recordingReporter struct {
+	mu      sync.Mutex
+	reports []ErrorReport
+}
+
+func (rr *recordingReporter) Report(rep ErrorReport) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	rr.reports = append(rr.reports, rep)
+}
+
+func useRecordingReporter(t *testing.T) *recordingReporter {
+	t.Helper()
+	rr := &recordingReporter{}
+	prev := errorReporter
+	errorReporter = rr
+	t.Cleanup(func() { errorReporter = prev })
+	return rr
+}
+
+func TestRecoverMiddleware_ReportsPanic(t *testing.T) {
+	rr := useRecordingReporter(t)
+	captureLogs(t)
+	m, _ := newTestMetrics(t)
+	rt := newRouter()
+	registerRoute(rt, "/boom", func(w http.ResponseWriter, r *http.Request) { panic("boom") })
+
+	req := httptest.NewRequest(http.MethodGet, "/boom", nil)
+	req.Header.Set(headerRequestID, "req-42")
+	recoverMiddleware(m, rt, metricsMiddleware(m, rt)).ServeHTTP(httptest.NewRecorder(), req)
+
+	if len(rr.reports) != 1 {
+		t.Fatalf("expected exactly one report for a panic, got %+v", rr.reports)
+	}
+	rep := rr.reports[0]
+	// timeoutHandler re-raises the panic with the handler's stack appended.
+	if rep.Message != "panic serving request" || !strings.HasPrefix(rep.Error, "boom") || rep.RequestID != "req-42" || rep.Details["route"] != "/boom" {
+		t.Errorf("unexpected report %+v", rep)
+	}
+	if !strings.Contains(rep.Stack, "goroutine") {
+		t.Errorf("expected the stack in the report, got %q", rep.Stack)
+	}
+}
+
+func TestMetricsMiddleware_ReportsServerErrors(t *testing.T) {
+	rr := useRecordingReporter(t)
+	captureLogs(t)
+	m, _ := newTestMetrics(t)
+	rt := newRouter()
+	for path, status := range map[string]int{"/fail": http.StatusBadGateway, "/missing": http.StatusNotFound, routeReady: http.StatusServiceUnavailable} {
+		registerRoute(rt, path, func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(status) })
+	}
+	handler := metricsMiddleware(m, rt)
+	for _, path := range []string{"/fail", "/missing", routeReady} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	if len(rr.reports) != 1 {
+		t.Fatalf("expected only the 502 to be reported, got %+v", rr.reports)
+	}
+	rep := rr.reports[0]
+	if rep.Message != "server error response" || rep.Details["route"] != "/fail" || rep.Details["status"] != http.StatusBadGateway {
+		t.Errorf("unexpected report %+v", rep)
+	}
+}
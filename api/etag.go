@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultCacheMaxAge = time.Minute
+
+// cacheMaxAges is the Cache-Control max-age per route served with withETag,
+// set by main from ROUTE_CACHE_MAX_AGE. Zero leaves the header out.
+var cacheMaxAges = routeDurations{routes: defaultCacheMaxAges()}
+
+// defaultCacheMaxAges lists the routes that only change with a deploy, so a
+// poller may reuse them for a while.
+func defaultCacheMaxAges() map[string]time.Duration {
+	return map[string]time.Duration{
+		routeVersion: defaultCacheMaxAge,
+		routeOpenAPI: defaultCacheMaxAge,
+	}
+}
+
+// etagWriter holds back a handler's status and body so withETag can hash the
+// body before anything is sent. Headers go straight to the real writer.
+type etagWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *etagWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+}
+
+func (w *etagWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(b)
+}
+
+// withETag serves next's 200 responses with an ETag hashed from the body and
+// route's Cache-Control max-age, and answers a matching If-None-Match with a
+// bodiless 304. Other statuses pass through untouched. It buffers the whole
+// body, so it suits small documents that rarely change, not streams.
+func withETag(route string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ew := &etagWriter{ResponseWriter: w}
+		next(ew, r)
+		if ew.status == 0 {
+			ew.status = http.StatusOK
+		}
+		if ew.status == http.StatusOK {
+			sum := sha256.Sum256(ew.body.Bytes())
+			tag := `"` + hex.EncodeToString(sum[:16]) + `"`
+			w.Header().Set("ETag", tag)
+			if maxAge := cacheMaxAges.forRoute(route); maxAge > 0 {
+				w.Header().Set("Cache-Control", "max-age="+strconv.Itoa(int(maxAge.Seconds())))
+			}
+			if etagMatches(r.Header.Get("If-None-Match"), tag) {
+				w.Header().Del(headerContentType)
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+		w.WriteHeader(ew.status)
+		if _, err := w.Write(ew.body.Bytes()); err != nil {
+			slog.Error(errWriteResponse, "error", err)
+		}
+	}
+}
+
+// etagMatches reports whether an If-None-Match header lists tag, using the
+// weak comparison RFC 9110 prescribes for it.
+func etagMatches(header, tag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == tag {
+			return true
+		}
+	}
+	return false
+}
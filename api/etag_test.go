@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// withCacheMaxAges sets cacheMaxAges for the duration of the test.
+func withCacheMaxAges(t *testing.T, cfg routeDurations) {
+	t.Helper()
+	prev := cacheMaxAges
+	cacheMaxAges = cfg
+	t.Cleanup(func() { cacheMaxAges = prev })
+}
+
+func TestWithETag(t *testing.T) {
+	withCacheMaxAges(t, routeDurations{routes: defaultCacheMaxAges()})
+	handler := withETag(routeVersion, versionHandler)
+	serve := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, routeVersion, nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	miss := serve("")
+	tag := miss.Header().Get("ETag")
+	if miss.Code != http.StatusOK || tag == "" || miss.Body.Len() == 0 {
+		t.Fatalf("expected a 200 with an ETag and a body, got %d %q %q", miss.Code, tag, miss.Body)
+	}
+	if cc := miss.Header().Get("Cache-Control"); cc != "max-age=60" {
+		t.Errorf("expected Cache-Control max-age=60, got %q", cc)
+	}
+	if again := serve(""); again.Header().Get("ETag") != tag {
+		t.Errorf("expected a stable ETag, got %q then %q", tag, again.Header().Get("ETag"))
+	}
+
+	for _, header := range []string{tag, "W/" + tag, `"stale", ` + tag, "*"} {
+		hit := serve(header)
+		if hit.Code != http.StatusNotModified || hit.Body.Len() != 0 {
+			t.Errorf("If-None-Match %s: expected an empty 304, got %d %q", header, hit.Code, hit.Body)
+		}
+		if hit.Header().Get("ETag") != tag || hit.Header().Get("Cache-Control") != "max-age=60" {
+			t.Errorf("If-None-Match %s: expected the ETag and Cache-Control on the 304, got %v", header, hit.Header())
+		}
+	}
+	if stale := serve(`"stale"`); stale.Code != http.StatusOK || stale.Body.String() != miss.Body.String() {
+		t.Errorf("expected the full document for a stale ETag, got %d %q", stale.Code, stale.Body)
+	}
+}
+
+func TestWithETag_PassesErrorsThrough(t *testing.T) {
+	handler := withETag(routeVersion, func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusServiceUnavailable, codeUnavailable, "not yet")
+	})
+	req := httptest.NewRequest(http.MethodGet, routeVersion, nil)
+	req.Header.Set("If-None-Match", "*")
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("ETag") != "" || rec.Body.Len() == 0 {
+		t.Errorf("expected the 503 untouched, got %d %v %q", rec.Code, rec.Header(), rec.Body)
+	}
+}
+
+func TestWithETag_NoMaxAge(t *testing.T) {
+	withCacheMaxAges(t, routeDurations{routes: map[string]time.Duration{routeOpenAPI: 0}})
+	rec := httptest.NewRecorder()
+	withETag(routeOpenAPI, openAPIHandler(false))(rec, httptest.NewRequest(http.MethodGet, routeOpenAPI, nil))
+	if rec.Header().Get("ETag") == "" || rec.Header().Get("Cache-Control") != "" {
+		t.Errorf("expected an ETag without Cache-Control, got %v", rec.Header())
+	}
+}
+
+func TestWithETag_NotModifiedMetrics(t *testing.T) {
+	m, _ := newTestMetrics(t)
+	rt := newInternalMux(nil, m, time.Now())
+	handler := metricsMiddleware(m, rt)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, routeOpenAPI, nil))
+	req := httptest.NewRequest(http.MethodGet, routeOpenAPI, nil)
+	req.Header.Set("If-None-Match", rec.Header().Get("ETag"))
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNotModified {
+		t.Fatalf("expected 304 through the mux, got %d", rec.Code)
+	}
+	if got := testutil.ToFloat64(m.requestsTotal.WithLabelValues(http.MethodGet, routeOpenAPI, "Not Modified", "false")); got != 1 {
+		t.Errorf("expected one request labelled Not Modified, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.requestsTotal.WithLabelValues(http.MethodGet, routeOpenAPI, "OK", "false")); got != 1 {
+		t.Errorf("expected one request labelled OK, got %v", got)
+	}
+}
+
+func TestConfig_CacheMaxAges(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.RouteCacheMaxAges = map[string]time.Duration{routeVersion: time.Hour, routeOpenAPI: 0}
+	maxAges := cfg.cacheMaxAges()
+	for route, want := range map[string]time.Duration{
+		routeVersion: time.Hour,
+		routeOpenAPI: 0,
+		routeHealthz: 0,
+	} {
+		if got := maxAges.forRoute(route); got != want {
+			t.Errorf("%s: expected %v, got %v", route, want, got)
+		}
+	}
+}
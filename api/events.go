@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Lifecycle events recorded in service_events. A shutdown without a
+// forced_shutdown after it was clean.
+const (
+	eventStarted        = "started"
+	eventShutdown       = "shutdown"
+	eventForcedShutdown = "forced_shutdown"
+	eventDBReconnected  = "db_reconnected"
+)
+
+// Outcomes counted in service_events_total.
+const (
+	eventWritten = "written"
+	eventFailed  = "failed"
+	eventDropped = "dropped"
+)
+
+const (
+	// eventQueueSize bounds the events waiting for the log flusher; more
+	// are dropped so recording one never blocks.
+	eventQueueSize = 64
+	// serviceNameMaxLen is the width of service_events.service.
+	serviceNameMaxLen = 255
+
+	defaultEventsLimit = 50
+	maxEventsLimit     = 500
+)
+
+// eventFlusher is the log flusher recordEvent queues events on. Unlike
+// flusher it is atomic: the background connect and the keepalive start
+// before the flusher does and may record events from then on.
+var eventFlusher atomic.Pointer[logFlusher]
+
+const insertEventSQL = `
+	INSERT INTO service_events (service, event, detail, created_at)
+	VALUES ($1, $2, $3::jsonb, $4)
+`
+
+// serviceEvent is one service_events row waiting to be written. detail is
+// a JSON object.
+type serviceEvent struct {
+	service string
+	event   string
+	detail  []byte
+	at      time.Time
+}
+
+// recordEvent queues event, with detail as its JSON detail, for the log
+// flusher to write, so the lifecycle step recording it never waits on the
+// database. Events are dropped while there is no flusher, and when the
+// queue is full; the flusher drops them too while there is no database.
+func recordEvent(event string, detail map[string]any) {
+	f := eventFlusher.Load()
+	if f == nil {
+		return
+	}
+	b, err := json.Marshal(detail)
+	if err != nil || detail == nil {
+		b = []byte("{}")
+	}
+	select {
+	case f.events <- serviceEvent{service: serviceName, event: event, detail: b, at: time.Now()}:
+	default:
+		f.dropEvent()
+	}
+}
+
+// flushEvent writes e through the pool db points at and counts the outcome
+// on m.
+func flushEvent(e serviceEvent, m *metrics) {
+	d := WriteDB()
+	if d == nil {
+		m.serviceEvents.WithLabelValues(eventDropped).Inc()
+		return
+	}
+	if _, err := d.ExecContext(context.Background(), insertEventSQL, e.service, e.event, string(e.detail), e.at.UTC()); err != nil {
+		m.serviceEvents.WithLabelValues(eventFailed).Inc()
+		slog.Error("failed to record service event", "event", e.event, "error", err)
+		return
+	}
+	m.serviceEvents.WithLabelValues(eventWritten).Inc()
+}
+
+// EventsResponse is the JSON response for /api/v1/events.
+type EventsResponse struct {
+	Events []ServiceEvent `json:"events"`
+}
+
+// ServiceEvent is one recorded lifecycle event.
+type ServiceEvent struct {
+	Service   string         `json:"service"`
+	Event     string         `json:"event"`
+	Detail    map[string]any `json:"detail"`
+	CreatedAt string         `json:"created_at"`
+}
+
+// parseEventsParams reads service, at most serviceNameMaxLen bytes, and
+// limit, defaulting to 50 and capped at 500.
+func parseEventsParams(service, limitStr string) (int, error) {
+	if len(service) > serviceNameMaxLen {
+		return 0, errors.New("invalid service: want at most 255 bytes")
+	}
+	limit := defaultEventsLimit
+	if limitStr != "" {
+		n, err := strconv.Atoi(limitStr)
+		if err != nil || n < 1 || n > maxEventsLimit {
+			return 0, errors.New("invalid limit: want 1 to 500")
+		}
+		limit = n
+	}
+	return limit, nil
+}
+
+// eventsHandler lists the most recent lifecycle events, newest first,
+// optionally of one service.
+func eventsHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	service := q.Get("service")
+	limit, err := parseEventsParams(service, q.Get("limit"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	}
+
+	d := ReadDB()
+	if d == nil {
+		writeError(w, http.StatusServiceUnavailable, codeUnavailable, "db not configured")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), statsQueryTimeout)
+	defer cancel()
+	events, err := queryEvents(ctx, d, service, limit)
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			writeError(w, http.StatusGatewayTimeout, codeTimeout, "events query timed out")
+			return
+		}
+		slog.Error("events query failed", "error", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "events query failed")
+		return
+	}
+	writeJSON(w, http.StatusOK, EventsResponse{Events: events})
+}
+
+func queryEvents(ctx context.Context, d *sql.DB, service string, limit int) ([]ServiceEvent, error) {
+	rows, err := d.QueryContext(ctx, `
+		SELECT service, event, detail, created_at
+		FROM service_events
+		WHERE $1 = '' OR service = $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2`, service, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	events := []ServiceEvent{}
+	for rows.Next() {
+		var e ServiceEvent
+		var detail []byte
+		var createdAt time.Time
+		if err := rows.Scan(&e.Service, &e.Event, &detail, &createdAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(detail, &e.Detail); err != nil {
+			return nil, err
+		}
+		e.CreatedAt = createdAt.UTC().Format(time.RFC3339Nano)
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// withEventFlusher starts a log flusher that writes to the mock database
+// and returns the metrics it counts on and a func that stops it and waits
+// for it to drain.
+func withEventFlusher(t *testing.T) (*metrics, func()) {
+	t.Helper()
+	prevFlusher, prevBuffer, prevEvents := flusher, logBuffer, eventFlusher.Load()
+	t.Cleanup(func() {
+		flusher, logBuffer = prevFlusher, prevBuffer
+		eventFlusher.Store(prevEvents)
+	})
+	m, _ := newTestMetrics(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	startLogFlusher(ctx, 8, sqlLogSink{}, m)
+	f := flusher
+	stop := func() {
+		cancel()
+		<-f.done
+	}
+	t.Cleanup(stop)
+	return m, stop
+}
+
+func expectEvent(mock sqlmock.Sqlmock, event, detail string) {
+	mock.ExpectExec("INSERT INTO service_events").
+		WithArgs(serviceName, event, detail, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+}
+
+func TestRecordEvent_StartupShutdownCycle(t *testing.T) {
+	defer draining.Store(false)
+	mock := withMockDB(t)
+	m, stop := withEventFlusher(t)
+	f := flusher
+
+	expectEvent(mock, eventStarted, `{"commit":"unknown","env":"test","version":"dev"}`)
+	expectEvent(mock, eventShutdown, `{"signal":"terminated"}`)
+	expectEvent(mock, eventForcedShutdown, `{"error":"context deadline exceeded","phase":"servers"}`)
+
+	// As main does once the log flusher is running.
+	recordEvent(eventStarted, map[string]any{"version": version, "commit": commit, "env": "test"})
+
+	stuck := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer stuck.Close()
+	defer stuck.CloseClientConnections()
+	go func() { _, _ = http.Get(stuck.URL) }()
+	time.Sleep(50 * time.Millisecond)
+
+	quit := make(chan os.Signal, 1)
+	quit <- syscall.SIGTERM
+	shutdownSequence{
+		timeout:  3 * time.Second,
+		servers:  map[string]*http.Server{"public": stuck.Config},
+		stopLogs: stop,
+		logsDone: f.done,
+	}.run(quit)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+	if got := testutil.ToFloat64(m.serviceEvents.WithLabelValues(eventWritten)); got != 3 {
+		t.Errorf("expected 3 events written, got %v", got)
+	}
+}
+
+func TestRecordEvent_NeverBlocks(t *testing.T) {
+	m, stop := withEventFlusher(t)
+	stop()
+	start := time.Now()
+	for range eventQueueSize + 10 {
+		recordEvent(eventDBReconnected, nil)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected recording to return at once, took %v", elapsed)
+	}
+	if got := testutil.ToFloat64(m.serviceEvents.WithLabelValues(eventDropped)); got != 10 {
+		t.Errorf("expected the events past the queue dropped, got %v", got)
+	}
+}
+
+func TestRecordEvent_NoDatabase(t *testing.T) {
+	dbMu.Lock()
+	db = nil
+	dbMu.Unlock()
+	m, stop := withEventFlusher(t)
+	recordEvent(eventStarted, map[string]any{"version": "dev"})
+	stop()
+	if got := testutil.ToFloat64(m.serviceEvents.WithLabelValues(eventDropped)); got != 1 {
+		t.Errorf("expected the event dropped without a database, got %v", got)
+	}
+}
+
+func serveEvents(t *testing.T, target string) *httptest.ResponseRecorder {
+	t.Helper()
+	m, reg := newTestMetrics(t)
+	rec := httptest.NewRecorder()
+	newInternalMux(reg, m, time.Now()).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	return rec
+}
+
+func TestEventsHandler(t *testing.T) {
+	mock := withMockDB(t)
+	created := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("SELECT service, event, detail, created_at FROM service_events").
+		WithArgs("worker", 2).
+		WillReturnRows(sqlmock.NewRows([]string{"service", "event", "detail", "created_at"}).
+			AddRow("worker", "resumed", []byte(`{}`), created).
+			AddRow("worker", "paused", []byte(`{"by":"admin"}`), created.Add(-time.Minute)))
+
+	rec := serveEvents(t, routeEvents+"?service=worker&limit=2")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var resp EventsResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to parse response JSON: %v", err)
+	}
+	if len(resp.Events) != 2 || resp.Events[0].Event != "resumed" || resp.Events[1].Detail["by"] != "admin" {
+		t.Errorf("unexpected events: %+v", resp.Events)
+	}
+	if resp.Events[0].CreatedAt != "2026-03-01T12:00:00Z" {
+		t.Errorf("expected an RFC3339 created_at, got %s", resp.Events[0].CreatedAt)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestEventsHandler_InvalidParams(t *testing.T) {
+	withMockDB(t)
+	for target, want := range map[string]string{
+		routeEvents + "?limit=0":                             "invalid limit: want 1 to 500",
+		routeEvents + "?limit=501":                           "invalid limit: want 1 to 500",
+		routeEvents + "?service=" + strings.Repeat("a", 256): "invalid service: want at most 255 bytes",
+		routeEvents + "?limit=ten":                           "invalid limit: want 1 to 500",
+	} {
+		rec := serveEvents(t, target)
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("%s: expected 400 %q, got %d %s", target, want, rec.Code, rec.Body)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	exportFormatCSV    = "csv"
+	exportFormatNDJSON = "ndjson"
+
+	maxExportWindow = 24 * time.Hour
+)
+
+// exportFlushRows is how many rows an export writes between flushes; rows
+// go out as they are read instead of piling up in the response buffer.
+var exportFlushRows = 1000
+
+// exportContentTypes maps each export format to its Content-Type.
+var exportContentTypes = map[string]string{
+	exportFormatCSV:    "text/csv; charset=utf-8",
+	exportFormatNDJSON: "application/x-ndjson",
+}
+
+// exportQuery reads the rows of a window oldest first; api_logs_created_at_idx
+// serves both the range and the order.
+const exportQuery = `
+	SELECT id, method, endpoint, status, duration_ms, remote_addr, created_at, processed_at, trace_id
+	FROM api_logs
+	WHERE created_at >= $1 AND created_at < $2
+	ORDER BY created_at, id`
+
+// exportColumns is the CSV header, in exportQuery's order.
+var exportColumns = []string{"id", "method", "endpoint", "status", "duration_ms", "remote_addr", "created_at", "processed_at", "trace_id"}
+
+// LogExportRow is one api_logs row as an NDJSON export line. Times are
+// RFC3339 in UTC; processed_at and trace_id are null when unset.
+type LogExportRow struct {
+	ID          int64   `json:"id"`
+	Method      string  `json:"method"`
+	Endpoint    string  `json:"endpoint"`
+	Status      int64   `json:"status"`
+	DurationMS  float64 `json:"duration_ms"`
+	RemoteAddr  string  `json:"remote_addr"`
+	CreatedAt   string  `json:"created_at"`
+	ProcessedAt *string `json:"processed_at"`
+	TraceID     *string `json:"trace_id"`
+}
+
+// csvRecord is the row as a CSV record, with empty fields for NULLs.
+func (row LogExportRow) csvRecord() []string {
+	return []string{
+		strconv.FormatInt(row.ID, 10),
+		row.Method,
+		row.Endpoint,
+		strconv.FormatInt(row.Status, 10),
+		strconv.FormatFloat(row.DurationMS, 'f', -1, 64),
+		row.RemoteAddr,
+		row.CreatedAt,
+		derefOrEmpty(row.ProcessedAt),
+		derefOrEmpty(row.TraceID),
+	}
+}
+
+func derefOrEmpty(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// parseExportWindow reads the required from and to as RFC3339 and rejects
+// empty, reversed or over-long windows.
+func parseExportWindow(fromStr, toStr string) (time.Time, time.Time, error) {
+	if fromStr == "" || toStr == "" {
+		return time.Time{}, time.Time{}, errors.New("from and to are required")
+	}
+	from, err := time.Parse(time.RFC3339, fromStr)
+	if err != nil {
+		return time.Time{}, time.Time{}, errors.New("invalid from: want RFC3339")
+	}
+	to, err := time.Parse(time.RFC3339, toStr)
+	if err != nil {
+		return time.Time{}, time.Time{}, errors.New("invalid to: want RFC3339")
+	}
+	if !from.Before(to) {
+		return time.Time{}, time.Time{}, errors.New("from must be before to")
+	}
+	if to.Sub(from) > maxExportWindow {
+		return time.Time{}, time.Time{}, errors.New("window must not exceed 24 hours")
+	}
+	return from.UTC(), to.UTC(), nil
+}
+
+// exportRowWriter writes rows in one export format.
+type exportRowWriter interface {
+	writeRow(row LogExportRow) error
+	// flush pushes buffered rows to the underlying writer.
+	flush() error
+}
+
+type csvRowWriter struct{ w *csv.Writer }
+
+func (c csvRowWriter) writeRow(row LogExportRow) error { return c.w.Write(row.csvRecord()) }
+
+func (c csvRowWriter) flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type ndjsonRowWriter struct{ enc *json.Encoder }
+
+func (n ndjsonRowWriter) writeRow(row LogExportRow) error { return n.enc.Encode(row) }
+
+func (n ndjsonRowWriter) flush() error { return nil }
+
+// newExportRowWriter returns the writer for format, with the CSV header
+// already written.
+func newExportRowWriter(w io.Writer, format string) (exportRowWriter, error) {
+	if format == exportFormatNDJSON {
+		return ndjsonRowWriter{enc: json.NewEncoder(w)}, nil
+	}
+	cw := csv.NewWriter(w)
+	return csvRowWriter{w: cw}, cw.Write(exportColumns)
+}
+
+// exportLogsHandler streams the api_logs rows created in [from, to) as CSV
+// or NDJSON straight from the database cursor. The query runs on the
+// request's context, so a client that disconnects stops it.
+func exportLogsHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	format := q.Get("format")
+	if format == "" {
+		format = exportFormatCSV
+	}
+	contentType, ok := exportContentTypes[format]
+	if !ok {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "invalid format: want csv or ndjson")
+		return
+	}
+	from, to, err := parseExportWindow(q.Get("from"), q.Get("to"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	}
+
+	d := ReadDB()
+	if d == nil {
+		writeError(w, http.StatusServiceUnavailable, codeUnavailable, "db not configured")
+		return
+	}
+
+	ctx := r.Context()
+	rows, err := d.QueryContext(ctx, exportQuery, from, to)
+	if err != nil {
+		if ctx.Err() != nil {
+			slog.Info("log export cancelled before the first row", "error", err)
+			return
+		}
+		slog.Error("log export query failed", "error", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "log export query failed")
+		return
+	}
+	defer func() { _ = rows.Close() }()
+
+	// A day of logs can take far longer to send than the write timeout.
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{})
+	w.Header().Set(headerContentType, contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="api_logs_%s_%s.%s"`,
+		from.Format("20060102T150405Z"), to.Format("20060102T150405Z"), format))
+	w.WriteHeader(http.StatusOK)
+
+	n, err := streamExport(ctx, rows, w, format, func() error { return rc.Flush() })
+	switch {
+	case ctx.Err() != nil:
+		slog.Info("log export cancelled by the client", "rows", n)
+	case err != nil:
+		// The status is already sent; the truncated body is all the client
+		// can be told.
+		slog.Error("log export failed", "rows", n, "error", err)
+	default:
+		slog.Info("log export completed", "format", format, "from", from, "to", to, "rows", n)
+	}
+}
+
+// streamExport writes rows to w in format, flushing every exportFlushRows
+// rows, and stops early once ctx is done. It returns how many rows it wrote.
+func streamExport(ctx context.Context, rows *sql.Rows, w io.Writer, format string, flush func() error) (int, error) {
+	out, err := newExportRowWriter(w, format)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for rows.Next() {
+		row, err := scanExportRow(rows)
+		if err != nil {
+			return n, err
+		}
+		if err := out.writeRow(row); err != nil {
+			return n, err
+		}
+		n++
+		if n%exportFlushRows == 0 {
+			if err := flushExport(ctx, out, flush); err != nil {
+				return n, err
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return n, err
+	}
+	return n, flushExport(ctx, out, flush)
+}
+
+// flushExport sends what out has buffered to the client, then reports a
+// client that has gone away, which a buffered write wouldn't notice.
+func flushExport(ctx context.Context, out exportRowWriter, flush func() error) error {
+	if err := out.flush(); err != nil {
+		return err
+	}
+	if err := flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+	return ctx.Err()
+}
+
+func scanExportRow(rows *sql.Rows) (LogExportRow, error) {
+	var (
+		row                      LogExportRow
+		method, endpoint, remote sql.NullString
+		status                   sql.NullInt64
+		duration                 sql.NullFloat64
+		createdAt, processedAt   sql.NullTime
+		traceID                  sql.NullString
+	)
+	if err := rows.Scan(&row.ID, &method, &endpoint, &status, &duration, &remote, &createdAt, &processedAt, &traceID); err != nil {
+		return LogExportRow{}, err
+	}
+	row.Method, row.Endpoint, row.RemoteAddr = method.String, endpoint.String, remote.String
+	row.Status, row.DurationMS = status.Int64, duration.Float64
+	if createdAt.Valid {
+		row.CreatedAt = createdAt.Time.UTC().Format(time.RFC3339Nano)
+	}
+	if processedAt.Valid {
+		s := processedAt.Time.UTC().Format(time.RFC3339Nano)
+		row.ProcessedAt = &s
+	}
+	if traceID.Valid {
+		row.TraceID = &traceID.String
+	}
+	return row, nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestParseExportWindow(t *testing.T) {
+	from, to, err := parseExportWindow("2024-01-01T07:00:00+07:00", "2024-01-02T00:00:00Z")
+	if err != nil || !from.Equal(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)) || from.Location() != time.UTC || !to.Equal(from.Add(24*time.Hour)) {
+		t.Errorf("expected exactly 24 hours in UTC to be allowed, got %v..%v, %v", from, to, err)
+	}
+
+	tests := map[string][2]string{
+		"missing from":    {"", "2024-01-02T00:00:00Z"},
+		"missing to":      {"2024-01-01T00:00:00Z", ""},
+		"invalid from":    {"yesterday", "2024-01-02T00:00:00Z"},
+		"invalid to":      {"2024-01-01T00:00:00Z", "now"},
+		"reversed":        {"2024-01-02T00:00:00Z", "2024-01-01T00:00:00Z"},
+		"empty":           {"2024-01-01T00:00:00Z", "2024-01-01T00:00:00Z"},
+		"longer than 24h": {"2024-01-01T00:00:00Z", "2024-01-02T00:00:01Z"},
+	}
+	for name, tt := range tests {
+		if _, _, err := parseExportWindow(tt[0], tt[1]); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+// exportRows returns two rows: one processed and traced, one with every
+// nullable column NULL.
+func exportRows() *sqlmock.Rows {
+	created := time.Date(2024, 1, 1, 10, 0, 0, 500e6, time.UTC)
+	return sqlmock.NewRows(exportColumns).
+		AddRow(1, "GET", "/api/v1/time", 200, 1.25, "10.0.0.1", created, created.Add(time.Minute), "4bf92f3577b34da6a3ce929d0e0e4736").
+		AddRow(2, nil, nil, nil, nil, nil, created.Add(time.Second), nil, nil)
+}
+
+func serveExport(t *testing.T, w http.ResponseWriter, r *http.Request) {
+	t.Helper()
+	m, _ := newTestMetrics(t)
+	r.Header.Set("Authorization", "Bearer s3cret")
+	newInternalMux(prometheus.NewRegistry(), m, time.Now()).ServeHTTP(w, r)
+}
+
+const exportTarget = routeLogsExport + "?from=2024-01-01T00:00:00Z&to=2024-01-02T00:00:00Z"
+
+func TestExportLogs_Formats(t *testing.T) {
+	withAdminToken(t, "s3cret")
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(24 * time.Hour)
+
+	tests := []struct {
+		format      string
+		contentType string
+		body        string
+	}{
+		{
+			format:      "csv",
+			contentType: "text/csv; charset=utf-8",
+			body: "id,method,endpoint,status,duration_ms,remote_addr,created_at,processed_at,trace_id\n" +
+				"1,GET,/api/v1/time,200,1.25,10.0.0.1,2024-01-01T10:00:00.5Z,2024-01-01T10:01:00.5Z,4bf92f3577b34da6a3ce929d0e0e4736\n" +
+				"2,,,0,0,,2024-01-01T10:00:01.5Z,,\n",
+		},
+		{
+			format:      "ndjson",
+			contentType: "application/x-ndjson",
+			body: `{"id":1,"method":"GET","endpoint":"/api/v1/time","status":200,"duration_ms":1.25,"remote_addr":"10.0.0.1","created_at":"2024-01-01T10:00:00.5Z","processed_at":"2024-01-01T10:01:00.5Z","trace_id":"4bf92f3577b34da6a3ce929d0e0e4736"}` + "\n" +
+				`{"id":2,"method":"","endpoint":"","status":0,"duration_ms":0,"remote_addr":"","created_at":"2024-01-01T10:00:01.5Z","processed_at":null,"trace_id":null}` + "\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			mock := withMockDB(t)
+			mock.ExpectQuery(regexp.QuoteMeta(exportQuery)).WithArgs(from, to).WillReturnRows(exportRows())
+
+			rec := httptest.NewRecorder()
+			serveExport(t, rec, httptest.NewRequest(http.MethodGet, exportTarget+"&format="+tt.format, nil))
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+			}
+			if got := rec.Header().Get("Content-Type"); got != tt.contentType {
+				t.Errorf("expected Content-Type %q, got %q", tt.contentType, got)
+			}
+			wantDisposition := `attachment; filename="api_logs_20240101T000000Z_20240102T000000Z.` + tt.format + `"`
+			if got := rec.Header().Get("Content-Disposition"); got != wantDisposition {
+				t.Errorf("expected Content-Disposition %q, got %q", wantDisposition, got)
+			}
+			if got := rec.Body.String(); got != tt.body {
+				t.Errorf("expected body\n%s got\n%s", tt.body, got)
+			}
+			if !rec.Flushed {
+				t.Error("expected the rows to be flushed")
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("unfulfilled mock: %s", err)
+			}
+		})
+	}
+}
+
+func TestExportLogs_Errors(t *testing.T) {
+	withAdminToken(t, "s3cret")
+	withMockDB(t)
+	for _, target := range []string{
+		routeLogsExport,
+		exportTarget + "&format=xml",
+		routeLogsExport + "?from=2024-01-01T00:00:00Z&to=2024-01-03T00:00:00Z",
+	} {
+		rec := httptest.NewRecorder()
+		serveExport(t, rec, httptest.NewRequest(http.MethodGet, target, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", target, rec.Code)
+		}
+	}
+
+	withAdminToken(t, "other")
+	rec := httptest.NewRecorder()
+	serveExport(t, rec, httptest.NewRequest(http.MethodGet, exportTarget, nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for a wrong token, got %d", rec.Code)
+	}
+}
+
+// disconnectingWriter is a client that goes away after the first flush.
+type disconnectingWriter struct {
+	*httptest.ResponseRecorder
+	disconnect context.CancelFunc
+}
+
+func (w disconnectingWriter) Flush() {
+	w.ResponseRecorder.Flush()
+	w.disconnect()
+}
+
+func TestExportLogs_ClientDisconnect(t *testing.T) {
+	withAdminToken(t, "s3cret")
+	mock := withMockDB(t)
+	prev := exportFlushRows
+	exportFlushRows = 2
+	t.Cleanup(func() { exportFlushRows = prev })
+
+	rows := sqlmock.NewRows(exportColumns)
+	for i := 1; i <= 10; i++ {
+		rows.AddRow(i, "GET", "/api/v1/time", 200, 1.0, "10.0.0.1", time.Date(2024, 1, 1, 0, 0, i, 0, time.UTC), nil, nil)
+	}
+	mock.ExpectQuery(regexp.QuoteMeta(exportQuery)).WillReturnRows(rows).RowsWillBeClosed()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w := disconnectingWriter{ResponseRecorder: httptest.NewRecorder(), disconnect: cancel}
+	logs := captureLogs(t)
+	serveExport(t, w, httptest.NewRequestWithContext(ctx, http.MethodGet, exportTarget, nil))
+
+	if lines := strings.Count(w.Body.String(), "\n"); lines != 3 {
+		t.Errorf("expected the header and the first 2 rows before the export stopped, got %d lines:\n%s", lines, w.Body)
+	}
+	if !strings.Contains(logs.String(), "log export cancelled by the client") {
+		t.Errorf("expected the cancellation to be logged, got %s", logs.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expected the cursor to be closed: %s", err)
+	}
+}
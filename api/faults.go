@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Request headers that ask for a fault when FAULT_INJECTION is on.
+const (
+	headerFaultDelay  = "X-Fault-Delay"
+	headerFaultStatus = "X-Fault-Status"
+)
+
+const (
+	// maxFaultDelay caps an injected delay, asked for or drawn, so a fault
+	// always ends before the server gives up on writing the response.
+	maxFaultDelay = serverWriteTimeout - time.Second
+	// faultErrorStatus is the status FAULT_ERROR_RATE answers with.
+	faultErrorStatus = http.StatusServiceUnavailable
+
+	codeFaultInjected = "fault_injected"
+)
+
+// productionEnvs are the APP_ENV values fault injection refuses to run in,
+// compared ignoring case.
+var productionEnvs = []string{"production", "prod"}
+
+// isProductionEnv reports whether env names a production environment.
+func isProductionEnv(env string) bool {
+	for _, p := range productionEnvs {
+		if strings.EqualFold(env, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// faultInjector delays or fails requests on demand, so clients can test
+// their retries against the real API. The X-Fault-Delay and X-Fault-Status
+// headers ask for a fault on one request; delayP50 and errorRate apply to
+// every request except the probes, at random.
+type faultInjector struct {
+	// delayP50 is the median of the exponentially distributed delay added
+	// to each request; zero adds none.
+	delayP50 time.Duration
+	// errorRate is the fraction of requests answered with
+	// faultErrorStatus.
+	errorRate float64
+	// rand returns a number in [0, 1); tests replace it.
+	rand func() float64
+}
+
+// faults is the running injector, set by main from FAULT_INJECTION; nil
+// turns injection off.
+var faults *faultInjector
+
+// newFaultInjector returns the injector for FAULT_INJECTION, or nil when it
+// is off. It also refuses production environments itself, so a config that
+// slips past validation still can't fail real traffic.
+func newFaultInjector(enabled bool, env string, delayP50 time.Duration, errorRate float64) *faultInjector {
+	if !enabled {
+		return nil
+	}
+	if isProductionEnv(env) {
+		slog.Error("fault injection refused in production", "env", env)
+		return nil
+	}
+	slog.Warn("fault injection enabled", "env", env, "delay_p50", delayP50, "error_rate", errorRate)
+	return &faultInjector{delayP50: delayP50, errorRate: errorRate, rand: rand.Float64}
+}
+
+// fault is what an injector does to one request.
+type fault struct {
+	delay  time.Duration
+	status int
+}
+
+// injected reports whether f changes the request at all.
+func (f fault) injected() bool {
+	return f.delay > 0 || f.status != 0
+}
+
+// pick decides the fault for r, on route. The headers win over the random
+// defaults, which leave the probe routes alone so they keep reporting the
+// pod's real state.
+func (fi *faultInjector) pick(r *http.Request, route string) (fault, error) {
+	var f fault
+	if fi == nil {
+		return f, nil
+	}
+	if !probeRoutes[route] && route != routeMetrics {
+		if fi.delayP50 > 0 {
+			// An exponential distribution with median delayP50.
+			f.delay = time.Duration(-math.Log(1-fi.rand()) / math.Ln2 * float64(fi.delayP50))
+		}
+		if fi.errorRate > 0 && fi.rand() < fi.errorRate {
+			f.status = faultErrorStatus
+		}
+	}
+	if s := r.Header.Get(headerFaultDelay); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 0 {
+			return fault{}, errors.New("invalid X-Fault-Delay: want a duration such as 2s")
+		}
+		f.delay = d
+	}
+	if s := r.Header.Get(headerFaultStatus); s != "" {
+		code, err := strconv.Atoi(s)
+		if err != nil || code < 400 || code > 599 {
+			return fault{}, errors.New("invalid X-Fault-Status: want 400 to 599")
+		}
+		f.status = code
+	}
+	f.delay = min(f.delay, maxFaultDelay)
+	return f, nil
+}
+
+// apply waits out f's delay, cut short if the request's context ends, and
+// answers with f's status if it has one. It reports whether the response
+// was written, in which case the handler must not run.
+func (f fault) apply(ctx context.Context, w http.ResponseWriter) bool {
+	if f.delay > 0 {
+		t := time.NewTimer(f.delay)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+		}
+	}
+	if f.status == 0 {
+		return false
+	}
+	writeError(w, f.status, codeFaultInjected, "injected fault: "+http.StatusText(f.status))
+	return true
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// withFaults installs fi as the running injector for the test.
+func withFaults(t *testing.T, fi *faultInjector) {
+	t.Helper()
+	prev := faults
+	faults = fi
+	t.Cleanup(func() { faults = prev })
+}
+
+// fixedRand returns a rand func that yields vals in turn, then repeats the
+// last one.
+func fixedRand(vals ...float64) func() float64 {
+	return func() float64 {
+		v := vals[0]
+		if len(vals) > 1 {
+			vals = vals[1:]
+		}
+		return v
+	}
+}
+
+// serveFaulty sends one request with headers through the metrics middleware
+// in front of a route that answers 200.
+func serveFaulty(t *testing.T, m *metrics, target string, headers map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+	rt := newRouter()
+	registerRoute(rt, "/tracked", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	})
+	registerRoute(rt, routeLive, noopHandler)
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	metricsMiddleware(m, rt).ServeHTTP(rec, req)
+	return rec
+}
+
+func TestFaults_Headers(t *testing.T) {
+	withFaults(t, &faultInjector{rand: fixedRand(0.5)})
+	prev := slo
+	defer func() { slo = prev }()
+	slo = sloConfig{objectives: map[string]float64{"/tracked": 0.999}}
+	m, _ := newTestMetrics(t)
+
+	start := time.Now()
+	rec := serveFaulty(t, m, "/tracked", map[string]string{headerFaultDelay: "100ms", headerFaultStatus: "503"})
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("expected the request delayed by 100ms, took %v", elapsed)
+	}
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), codeFaultInjected) {
+		t.Errorf("expected an injected 503, got %d %s", rec.Code, rec.Body)
+	}
+
+	// A delay alone still runs the handler.
+	rec = serveFaulty(t, m, "/tracked", map[string]string{headerFaultDelay: "10ms"})
+	if rec.Code != http.StatusOK || rec.Body.String() != "ok" {
+		t.Errorf("expected the handler's 200 after the delay, got %d %s", rec.Code, rec.Body)
+	}
+	serveFaulty(t, m, "/tracked", nil)
+
+	unavailable := http.StatusText(http.StatusServiceUnavailable)
+	if got := testutil.ToFloat64(m.requestsTotal.WithLabelValues(http.MethodGet, "/tracked", unavailable, "true")); got != 1 {
+		t.Errorf("expected the injected 503 labelled injected=\"true\", got %v", got)
+	}
+	if got := testutil.ToFloat64(m.errorsTotal.WithLabelValues(http.MethodGet, "/tracked", unavailable, "true")); got != 1 {
+		t.Errorf("expected the injected 503 in http_errors_total with injected=\"true\", got %v", got)
+	}
+	if got := testutil.ToFloat64(m.requestsTotal.WithLabelValues(http.MethodGet, "/tracked", "OK", "false")); got != 1 {
+		t.Errorf("expected the plain request labelled injected=\"false\", got %v", got)
+	}
+	if got := testutil.ToFloat64(m.sloRequests.WithLabelValues("/tracked")); got != 1 {
+		t.Errorf("expected only the plain request counted against the SLO, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.sloErrors.WithLabelValues("/tracked")); got != 0 {
+		t.Errorf("expected no SLO errors from injected faults, got %v", got)
+	}
+}
+
+func TestFaults_InvalidHeaders(t *testing.T) {
+	withFaults(t, &faultInjector{rand: fixedRand(0.5)})
+	m, _ := newTestMetrics(t)
+	for _, tt := range []struct {
+		header, value, want string
+	}{
+		{headerFaultDelay, "soon", "invalid X-Fault-Delay"},
+		{headerFaultDelay, "-1s", "invalid X-Fault-Delay"},
+		{headerFaultStatus, "200", "invalid X-Fault-Status"},
+		{headerFaultStatus, "teapot", "invalid X-Fault-Status"},
+	} {
+		rec := serveFaulty(t, m, "/tracked", map[string]string{tt.header: tt.value})
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), tt.want) {
+			t.Errorf("%s: %s: expected 400 %q, got %d %s", tt.header, tt.value, tt.want, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestFaults_DisabledIgnoresHeaders(t *testing.T) {
+	withFaults(t, nil)
+	m, _ := newTestMetrics(t)
+	rec := serveFaulty(t, m, "/tracked", map[string]string{headerFaultStatus: "503", headerFaultDelay: "5s"})
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected the headers ignored without FAULT_INJECTION, got %d", rec.Code)
+	}
+}
+
+func TestFaults_Probabilistic(t *testing.T) {
+	m, _ := newTestMetrics(t)
+
+	// A draw under the error rate fails the request.
+	withFaults(t, &faultInjector{errorRate: 0.1, rand: fixedRand(0.05)})
+	if rec := serveFaulty(t, m, "/tracked", nil); rec.Code != faultErrorStatus {
+		t.Errorf("expected a random %d, got %d", faultErrorStatus, rec.Code)
+	}
+	// One over it passes.
+	withFaults(t, &faultInjector{errorRate: 0.1, rand: fixedRand(0.5)})
+	if rec := serveFaulty(t, m, "/tracked", nil); rec.Code != http.StatusOK {
+		t.Errorf("expected a 200 above the error rate, got %d", rec.Code)
+	}
+	// Probes are never failed at random, but a header still reaches them.
+	withFaults(t, &faultInjector{errorRate: 1, rand: fixedRand(0)})
+	if rec := serveFaulty(t, m, routeLive, nil); rec.Code != http.StatusOK {
+		t.Errorf("expected the probe left alone, got %d", rec.Code)
+	}
+	if rec := serveFaulty(t, m, routeLive, map[string]string{headerFaultStatus: "500"}); rec.Code != http.StatusInternalServerError {
+		t.Errorf("expected the header honoured on a probe, got %d", rec.Code)
+	}
+}
+
+func TestFaultInjector_PickDelay(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/tracked", nil)
+	// A draw of 0.5 lands on the median.
+	fi := &faultInjector{delayP50: 200 * time.Millisecond, rand: fixedRand(0.5)}
+	f, err := fi.pick(req, "/tracked")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if f.delay < 199*time.Millisecond || f.delay > 201*time.Millisecond {
+		t.Errorf("expected a 200ms delay at the median, got %v", f.delay)
+	}
+	if f.status != 0 {
+		t.Errorf("expected no status without an error rate, got %d", f.status)
+	}
+
+	// The tail is capped.
+	fi.delayP50, fi.rand = time.Second, fixedRand(0.999999999)
+	if f, _ := fi.pick(req, "/tracked"); f.delay != maxFaultDelay {
+		t.Errorf("expected the delay capped at %v, got %v", maxFaultDelay, f.delay)
+	}
+	req.Header.Set(headerFaultDelay, "1h")
+	if f, _ := fi.pick(req, "/tracked"); f.delay != maxFaultDelay {
+		t.Errorf("expected the asked-for delay capped at %v, got %v", maxFaultDelay, f.delay)
+	}
+}
+
+func TestNewFaultInjector_ProductionGuard(t *testing.T) {
+	captureLogs(t)
+	if fi := newFaultInjector(false, "staging", time.Second, 0.5); fi != nil {
+		t.Error("expected no injector when FAULT_INJECTION is off")
+	}
+	if fi := newFaultInjector(true, "staging", time.Second, 0.5); fi == nil {
+		t.Error("expected an injector in staging")
+	}
+	for _, env := range []string{"production", "PROD", "Production"} {
+		if fi := newFaultInjector(true, env, time.Second, 0.5); fi != nil {
+			t.Errorf("expected the injector refused for APP_ENV=%s", env)
+		}
+	}
+}
+
+func TestLoadConfig_FaultInjectionInProduction(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv("FAULT_INJECTION", "true")
+	if _, err := LoadConfig(nil); err != nil {
+		t.Fatalf("expected fault injection allowed outside production, got %v", err)
+	}
+	t.Setenv("APP_ENV", "production")
+	if _, err := LoadConfig(nil); err == nil || !strings.Contains(err.Error(), "FAULT_INJECTION must not be set when APP_ENV is production") {
+		t.Errorf("expected fault injection rejected in production, got %v", err)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The probes hit /live, /ready and /startup every few seconds, so their
+// bodies are encoded ahead of time rather than per request: /startup has
+// two fixed bodies, /live only changes in its timestamp, and /ready is
+// encoded once per check and reused while readyCache serves the result.
+
+// livePayload is the encoded /live body on either side of its timestamp,
+// for the service and version it reports.
+type livePayload struct {
+	service, version string
+	head, tail       []byte
+}
+
+// livePayloads holds the payload for the running service; loadLivePayload
+// replaces it when SERVICE_NAME or the version no longer match.
+var livePayloads atomic.Pointer[livePayload]
+
+// liveTimestampMark stands in for the timestamp while the payload is
+// encoded. json escapes it as \u0000, which nothing before the timestamp
+// field can contain.
+const liveTimestampMark = "\x00"
+
+// loadLivePayload returns the payload for serviceName and version,
+// encoding it if they changed since the last one.
+func loadLivePayload() (*livePayload, error) {
+	if p := livePayloads.Load(); p != nil && p.service == serviceName && p.version == version {
+		return p, nil
+	}
+	body, err := encodeJSON(HealthResponse{Status: "ok", Timestamp: liveTimestampMark, Service: serviceName, Version: version})
+	if err != nil {
+		return nil, err
+	}
+	head, tail, _ := bytes.Cut(body, []byte(`\u0000`))
+	p := &livePayload{service: serviceName, version: version, head: head, tail: tail}
+	livePayloads.Store(p)
+	return p, nil
+}
+
+// liveBuffers hold the /live body while the timestamp is spliced in.
+var liveBuffers = sync.Pool{New: func() any { return new([]byte) }}
+
+// writeLive writes the /live body for now.
+func writeLive(w http.ResponseWriter, now time.Time) {
+	p, err := loadLivePayload()
+	if err != nil {
+		slog.Error(errWriteResponse, "error", err)
+		writeJSON(w, http.StatusOK, HealthResponse{Status: "ok", Timestamp: now.UTC().Format(time.RFC3339), Service: serviceName, Version: version})
+		return
+	}
+	buf := liveBuffers.Get().(*[]byte)
+	defer liveBuffers.Put(buf)
+	b := append((*buf)[:0], p.head...)
+	b = now.UTC().AppendFormat(b, time.RFC3339)
+	b = append(b, p.tail...)
+	*buf = b
+	writeJSONBody(w, http.StatusOK, b)
+}
+
+// The two /startup bodies.
+var (
+	startupStarting = mustEncodeJSON(statusResponse{Status: "starting"})
+	startupStarted  = mustEncodeJSON(statusResponse{Status: "started"})
+)
+
+// mustEncodeJSON is encodeJSON for values that always encode.
+func mustEncodeJSON(v any) []byte {
+	body, err := encodeJSON(v)
+	if err != nil {
+		panic(err)
+	}
+	return body
+}
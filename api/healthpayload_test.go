@@ -0,0 +1,124 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// encoded returns v as writeJSON sends it.
+func encoded(t *testing.T, v any) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	writeJSON(rec, http.StatusOK, v)
+	return rec.Body.String()
+}
+
+func TestWriteLive_MatchesWriteJSON(t *testing.T) {
+	prevService, prevVersion := serviceName, version
+	t.Cleanup(func() { serviceName, version = prevService, prevVersion })
+	now := time.Date(2026, 10, 18, 9, 30, 0, 0, time.FixedZone("ICT", 7*3600))
+	for _, tt := range []struct{ service, version string }{
+		{"api", "1.2.3"},
+		{"api-canary", "dev"},
+		{"<api> & \"quoted\"", "v\u0000"},
+	} {
+		serviceName, version = tt.service, tt.version
+		rec := httptest.NewRecorder()
+		writeLive(rec, now)
+		want := encoded(t, HealthResponse{Status: "ok", Timestamp: now.UTC().Format(time.RFC3339), Service: tt.service, Version: tt.version})
+		if got := rec.Body.String(); got != want {
+			t.Errorf("%s %s: expected %q, got %q", tt.service, tt.version, want, got)
+		}
+		if ct := rec.Header().Get(headerContentType); ct != contentTypeJSON {
+			t.Errorf("expected Content-Type %s, got %q", contentTypeJSON, ct)
+		}
+	}
+}
+
+func TestStartupHandler_MatchesWriteJSON(t *testing.T) {
+	prev := started.Load()
+	t.Cleanup(func() { started.Store(prev) })
+	for _, tt := range []struct {
+		started bool
+		code    int
+		status  string
+	}{
+		{false, http.StatusServiceUnavailable, "starting"},
+		{true, http.StatusOK, "started"},
+	} {
+		started.Store(tt.started)
+		rec := httptest.NewRecorder()
+		startupHandler(rec, httptest.NewRequest(http.MethodGet, routeStartup, nil))
+		if rec.Code != tt.code {
+			t.Errorf("expected %d, got %d", tt.code, rec.Code)
+		}
+		if want := encoded(t, statusResponse{Status: tt.status}); rec.Body.String() != want {
+			t.Errorf("expected %q, got %q", want, rec.Body.String())
+		}
+	}
+}
+
+func TestReadyHandler_ReusesEncodedResult(t *testing.T) {
+	dbMu.Lock()
+	db = nil
+	dbMu.Unlock()
+	withCachedReadiness(t)
+
+	first := httptest.NewRecorder()
+	readyHandler(first, httptest.NewRequest(http.MethodGet, routeReady, nil))
+	readiness.mu.Lock()
+	last := readiness.last
+	readiness.mu.Unlock()
+	if last.body == nil {
+		t.Fatal("expected the cached result to carry its encoded body")
+	}
+	if want := encoded(t, last.response()); first.Body.String() != want {
+		t.Errorf("expected %q, got %q", want, first.Body.String())
+	}
+	second := httptest.NewRecorder()
+	readyHandler(second, httptest.NewRequest(http.MethodGet, routeReady, nil))
+	if second.Code != first.Code || second.Body.String() != first.Body.String() {
+		t.Errorf("expected the cached response repeated, got %d %q after %d %q", second.Code, second.Body, first.Code, first.Body)
+	}
+}
+
+// withCachedReadiness caches /ready results for a minute and forgets them
+// afterwards, so a test sees one check and doesn't leak it to others.
+func withCachedReadiness(t testing.TB) {
+	t.Helper()
+	reset := func(ttl time.Duration) {
+		readiness.mu.Lock()
+		readiness.ttl, readiness.last = ttl, readyResult{}
+		readiness.mu.Unlock()
+	}
+	prev := readiness.ttl
+	reset(time.Minute)
+	t.Cleanup(func() { reset(prev) })
+}
+
+func BenchmarkHealthHandlers(b *testing.B) {
+	dbMu.Lock()
+	db = nil
+	dbMu.Unlock()
+	withCachedReadiness(b)
+	for _, bc := range []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{"live", liveHandler},
+		{"ready", readyHandler},
+		{"startup", startupHandler},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			w := &discardWriter{header: make(http.Header)}
+			b.ReportAllocs()
+			for b.Loop() {
+				clear(w.header)
+				bc.handler(w, req)
+			}
+		})
+	}
+}
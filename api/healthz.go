@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// healthzStatusCodes maps the overall readiness status to the /healthz HTTP
+// status; main applies HEALTHZ_DEGRADED_STATUS and HEALTHZ_ERROR_STATUS.
+var healthzStatusCodes = map[string]int{
+	"ready":    http.StatusOK,
+	"degraded": http.StatusOK,
+	"error":    http.StatusServiceUnavailable,
+}
+
+// HealthzResponse is the single document served by /healthz for external
+// monitors that can only poll one URL.
+type HealthzResponse struct {
+	Status        string        `json:"status"`
+	Live          string        `json:"live"`
+	Service       string        `json:"service"`
+	Version       string        `json:"version"`
+	StartedAt     string        `json:"started_at"`
+	Uptime        string        `json:"uptime"`
+	UptimeSeconds float64       `json:"uptime_seconds"`
+	Readiness     readyResponse `json:"readiness"`
+}
+
+// newHealthzResponse builds the /healthz document for a process that started
+// at startedAt.
+func newHealthzResponse(res readyResult, startedAt time.Time) HealthzResponse {
+	uptime := time.Since(startedAt).Truncate(time.Second)
+	return HealthzResponse{
+		Status:        res.status,
+		Live:          "ok",
+		Service:       serviceName,
+		Version:       version,
+		StartedAt:     startedAt.UTC().Format(time.RFC3339),
+		Uptime:        uptime.String(),
+		UptimeSeconds: uptime.Seconds(),
+		Readiness:     res.response(),
+	}
+}
+
+func healthzHandler(startedAt time.Time) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		res := readiness.get(r.Context(), checkReady)
+		writeJSON(w, healthzStatusCodes[res.status], newHealthzResponse(res, startedAt))
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+)
+
+// Error codes carried in the code field of errorResponse, so clients can
+// branch on the kind of failure without parsing the message.
+const (
+	codeInvalidRequest   = "invalid_request"
+	codeUnauthorized     = "unauthorized"
+	codeForbidden        = "forbidden"
+	codeNotFound         = "not_found"
+	codeMethodNotAllowed = "method_not_allowed"
+	codeRateLimited      = "rate_limited"
+	codeInternal         = "internal_error"
+	codeUnavailable      = "unavailable"
+	codeTimeout          = "timeout"
+)
+
+// statusResponse is a body that only reports a status, such as /startup's.
+type statusResponse struct {
+	Status string `json:"status"`
+}
+
+// errorResponse is the JSON body of every error reply.
+type errorResponse struct {
+	Status  string `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// writeJSON writes v as the JSON body of a status response. A failed write
+// means the client has gone, so it is only logged.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set(headerContentType, contentTypeJSON)
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error(errWriteResponse, "error", err)
+	}
+}
+
+// encodeJSON returns v encoded exactly as writeJSON sends it, trailing
+// newline included, for a handler that writes the same body many times.
+func encodeJSON(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// jsonContentType is the Content-Type header of writeJSONBody. Every
+// response shares it, so it is clipped: a middleware appending to it gets a
+// new slice instead of writing into this one.
+var jsonContentType = slices.Clip([]string{contentTypeJSON})
+
+// writeJSONBody writes body, already encoded by encodeJSON, as the JSON body
+// of a status response.
+func writeJSONBody(w http.ResponseWriter, status int, body []byte) {
+	w.Header()[headerContentType] = jsonContentType
+	w.WriteHeader(status)
+	if _, err := w.Write(body); err != nil {
+		slog.Error(errWriteResponse, "error", err)
+	}
+}
+
+// writeError writes {"status":"error","code":code,"message":message} with
+// status.
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, errorResponse{Status: "error", Code: code, Message: message})
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestWriteJSON(t *testing.T) {
+	rec := httptest.NewRecorder()
+	writeJSON(rec, http.StatusAccepted, statusResponse{Status: "queued"})
+
+	if rec.Code != http.StatusAccepted {
+		t.Errorf("expected 202, got %d", rec.Code)
+	}
+	if ct := rec.Header().Get(headerContentType); ct != contentTypeJSON {
+		t.Errorf("expected Content-Type %s, got %q", contentTypeJSON, ct)
+	}
+	if got := rec.Body.String(); got != "{\"status\":\"queued\"}\n" {
+		t.Errorf("unexpected body %q", got)
+	}
+}
+
+func TestWriteJSON_LogsWriteFailureOnce(t *testing.T) {
+	buf := captureLogs(t)
+	writeJSON(&errorResponseWriter{ResponseWriter: httptest.NewRecorder()}, http.StatusOK, statusResponse{Status: "ok"})
+	if n := strings.Count(buf.String(), errWriteResponse); n != 1 {
+		t.Errorf("expected the write failure to be logged once, got %d times: %s", n, buf.String())
+	}
+}
+
+// decodeErrorEnvelope checks that rec holds exactly the error envelope
+// fields and returns them.
+func decodeErrorEnvelope(t *testing.T, rec *httptest.ResponseRecorder) map[string]string {
+	t.Helper()
+	if ct := rec.Header().Get(headerContentType); ct != contentTypeJSON {
+		t.Errorf("expected Content-Type %s, got %q", contentTypeJSON, ct)
+	}
+	var body map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("expected a JSON object of strings, got %q: %v", rec.Body.String(), err)
+	}
+	keys := make([]string, 0, len(body))
+	for k := range body {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	if want := []string{"code", "message", "status"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("expected the envelope fields %v, got %v", want, keys)
+	}
+	if body["status"] != "error" || body["code"] == "" || body["message"] == "" {
+		t.Errorf("unexpected envelope %v", body)
+	}
+	return body
+}
+
+func TestWriteError_Envelope(t *testing.T) {
+	rec := httptest.NewRecorder()
+	writeError(rec, http.StatusTooManyRequests, codeRateLimited, "rate limit exceeded")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("expected 429, got %d", rec.Code)
+	}
+	body := decodeErrorEnvelope(t, rec)
+	if body["code"] != "rate_limited" || body["message"] != "rate limit exceeded" {
+		t.Errorf("unexpected envelope %v", body)
+	}
+}
+
+func TestErrorEnvelope_Endpoints(t *testing.T) {
+	// Every request but the last fits in the burst, which leaves the last
+	// one rate limited.
+	l := newRateLimiter(rate.Every(time.Hour), 5)
+	srv := newLimitedInternalServer(t, l)
+	metricsAuth = metricsCredentials{token: "metrics-token"}
+	defer func() { metricsAuth = metricsCredentials{} }()
+
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		return rec
+	}
+	tests := []struct {
+		name     string
+		req      *http.Request
+		wantCode int
+		want     string
+	}{
+		{"not found", adminRequest(http.MethodGet, "/no/such/route"), http.StatusNotFound, codeNotFound},
+		{"method not allowed", adminRequest(http.MethodDelete, routeLive), http.StatusMethodNotAllowed, codeMethodNotAllowed},
+		{"metrics unauthorized", httptest.NewRequest(http.MethodGet, "/metrics", nil), http.StatusUnauthorized, codeUnauthorized},
+		{"admin unauthorized", httptest.NewRequest(http.MethodGet, routeAdminLogLevel, nil), http.StatusUnauthorized, codeUnauthorized},
+		{"invalid request", adminRequest(http.MethodPut, routeAdminLogLevel+"?level=loud"), http.StatusBadRequest, codeInvalidRequest},
+		{"rate limited", adminRequest(http.MethodGet, routeLive), http.StatusTooManyRequests, codeRateLimited},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(tt.req)
+			if rec.Code != tt.wantCode {
+				t.Fatalf("expected %d, got %d: %s", tt.wantCode, rec.Code, rec.Body.String())
+			}
+			if body := decodeErrorEnvelope(t, rec); body["code"] != tt.want {
+				t.Errorf("expected code %q, got %v", tt.want, body)
+			}
+		})
+	}
+}
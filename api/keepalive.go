@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// defaultDBKeepaliveInterval is how often the keepalive pings the pool.
+	defaultDBKeepaliveInterval = 30 * time.Second
+	// dbKeepaliveFailures is how many pings in a row must fail before the
+	// keepalive replaces the pool.
+	dbKeepaliveFailures = 3
+)
+
+// dbKeepalive pings the primary pool in the background, so a pool left
+// holding dead connections by a network blip is noticed and replaced before
+// requests draw them. Each ping is recorded for /ready and /healthz like a
+// readiness ping. A missing pool is left to connectLater.
+type dbKeepalive struct {
+	interval time.Duration
+	// connect opens the replacement pool.
+	connect    func(ctx context.Context) (*sql.DB, error)
+	reconnects prometheus.Counter
+
+	failures int
+}
+
+// run pings every interval until ctx is cancelled.
+func (k *dbKeepalive) run(ctx context.Context) {
+	t := time.NewTicker(k.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			k.check(ctx)
+		}
+	}
+}
+
+// check pings the pool once, and replaces it once dbKeepaliveFailures pings
+// in a row have failed.
+func (k *dbKeepalive) check(ctx context.Context) {
+	d := WriteDB()
+	if d == nil {
+		k.failures = 0
+		return
+	}
+	pingCtx, cancel := context.WithTimeout(ctx, readyPingTimeout)
+	err := d.PingContext(pingCtx)
+	cancel()
+	if ctx.Err() != nil {
+		return
+	}
+	dbConnected.Store(err == nil)
+	lastDBError.record(err)
+	if err == nil {
+		k.failures = 0
+		return
+	}
+	k.failures++
+	slog.Warn("db keepalive ping failed", "failures", k.failures, "error", err)
+	if k.failures >= dbKeepaliveFailures {
+		k.replace(ctx, d)
+	}
+}
+
+// replace swaps a pool from connect in for old and then closes old, so
+// requests never find db empty. If connect fails old stays, to be replaced
+// after the next failed ping; if something else, such as a DB_DSN_FILE
+// reload, has already replaced old, the new pool is dropped.
+func (k *dbKeepalive) replace(ctx context.Context, old *sql.DB) {
+	k.reconnects.Inc()
+	slog.Warn("db keepalive failing, replacing the pool")
+	d, err := k.connect(ctx)
+	if err != nil {
+		slog.Error("db keepalive reconnect failed", "error", err)
+		return
+	}
+
+	dbMu.Lock()
+	if db != old {
+		dbMu.Unlock()
+		_ = d.Close()
+		return
+	}
+	db = d
+	dbMu.Unlock()
+	k.failures = 0
+	dbConnected.Store(true)
+	lastDBError.record(nil)
+	if err := old.Close(); err != nil {
+		slog.Error("error closing db", "error", err)
+	}
+	slog.Info("replaced the db pool after failed keepalive pings")
+	recordEvent(eventDBReconnected, map[string]any{"reason": "keepalive pings failed", "failures": dbKeepaliveFailures})
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// withKeepalivePool points db at d and clears the connection state the
+// keepalive records when the test ends.
+func withKeepalivePool(t *testing.T, d *sql.DB) {
+	t.Helper()
+	resetDB(t)
+	withDBs(t, d, nil)
+	t.Cleanup(func() { lastDBError.record(nil) })
+}
+
+func TestDBKeepalive_ReplacesFailingPool(t *testing.T) {
+	failing, failingMock, _ := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	healthy, healthyMock, _ := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	defer func() { _ = healthy.Close() }()
+	withKeepalivePool(t, failing)
+	m, _ := newTestMetrics(t)
+	connects := 0
+	k := &dbKeepalive{
+		connect: func(context.Context) (*sql.DB, error) {
+			connects++
+			return healthy, nil
+		},
+		reconnects: m.dbReconnects,
+	}
+
+	for range dbKeepaliveFailures {
+		failingMock.ExpectPing().WillReturnError(errors.New("connection reset by peer"))
+	}
+	failingMock.ExpectClose()
+	healthyMock.ExpectPing()
+
+	for i := range dbKeepaliveFailures - 1 {
+		k.check(context.Background())
+		if WriteDB() != failing || connects != 0 {
+			t.Fatalf("ping %d: expected the pool kept until %d failures", i+1, dbKeepaliveFailures)
+		}
+		if dbConnected.Load() || lastDBError.get() == nil {
+			t.Errorf("ping %d: expected the failure recorded for readiness", i+1)
+		}
+	}
+	k.check(context.Background())
+	if WriteDB() != healthy || connects != 1 {
+		t.Fatalf("expected the failing pool replaced, got %d connects", connects)
+	}
+	if !dbConnected.Load() || lastDBError.get() != nil {
+		t.Error("expected the replacement recorded as connected")
+	}
+	if got := testutil.ToFloat64(m.dbReconnects); got != 1 {
+		t.Errorf("expected 1 reconnect, got %v", got)
+	}
+
+	k.check(context.Background())
+	if k.failures != 0 {
+		t.Errorf("expected the healthy pool to pass, got %d failures", k.failures)
+	}
+	if err := failingMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("failing pool: %s", err)
+	}
+	if err := healthyMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("healthy pool: %s", err)
+	}
+}
+
+func TestDBKeepalive_KeepsPoolWhenReconnectFails(t *testing.T) {
+	failing, failingMock, _ := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	defer func() { _ = failing.Close() }()
+	withKeepalivePool(t, failing)
+	m, _ := newTestMetrics(t)
+	k := &dbKeepalive{
+		connect: func(context.Context) (*sql.DB, error) {
+			return nil, errors.New("connection refused")
+		},
+		reconnects: m.dbReconnects,
+		failures:   dbKeepaliveFailures - 1,
+	}
+
+	failingMock.ExpectPing().WillReturnError(errors.New("connection reset by peer"))
+	failingMock.ExpectPing().WillReturnError(errors.New("connection reset by peer"))
+	failingMock.ExpectPing()
+	k.check(context.Background())
+	if WriteDB() != failing {
+		t.Fatal("expected the old pool kept while the database is unreachable")
+	}
+	k.check(context.Background())
+	if got := testutil.ToFloat64(m.dbReconnects); got != 2 {
+		t.Errorf("expected a reconnect on every failed ping past the threshold, got %v", got)
+	}
+
+	// The old pool recovers once the network does.
+	k.check(context.Background())
+	if k.failures != 0 || WriteDB() != failing || !dbConnected.Load() {
+		t.Errorf("expected the recovered pool kept, got %d failures", k.failures)
+	}
+	if err := failingMock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDBKeepalive_LeavesReplacedPool(t *testing.T) {
+	failing, failingMock, _ := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	defer func() { _ = failing.Close() }()
+	reloaded, _, _ := sqlmock.New()
+	defer func() { _ = reloaded.Close() }()
+	fresh, freshMock, _ := sqlmock.New()
+	withKeepalivePool(t, failing)
+	m, _ := newTestMetrics(t)
+	k := &dbKeepalive{
+		connect: func(context.Context) (*sql.DB, error) {
+			// A DB_DSN_FILE reload swaps the pool while this one connects.
+			dbMu.Lock()
+			db = reloaded
+			dbMu.Unlock()
+			return fresh, nil
+		},
+		reconnects: m.dbReconnects,
+		failures:   dbKeepaliveFailures - 1,
+	}
+
+	failingMock.ExpectPing().WillReturnError(errors.New("connection reset by peer"))
+	freshMock.ExpectClose()
+	k.check(context.Background())
+	if WriteDB() != reloaded {
+		t.Error("expected the reloaded pool kept")
+	}
+	if err := freshMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expected the keepalive's pool closed: %s", err)
+	}
+}
+
+func TestDBKeepalive_NoPool(t *testing.T) {
+	withKeepalivePool(t, nil)
+	k := &dbKeepalive{
+		connect: func(context.Context) (*sql.DB, error) {
+			t.Fatal("expected no reconnect without a pool")
+			return nil, nil
+		},
+		failures: dbKeepaliveFailures,
+	}
+	k.check(context.Background())
+	if k.failures != 0 {
+		t.Errorf("expected the failures reset, got %d", k.failures)
+	}
+}
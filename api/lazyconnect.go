@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// dbRetryMaxDelay caps the backoff between background connection attempts.
+const dbRetryMaxDelay = 30 * time.Second
+
+// dbConnecting is set while connectLater is still trying to reach the
+// database DB_DSN names. Readiness reports it according to DB_REQUIRED.
+var dbConnecting atomic.Bool
+
+// connectLater marks the database as connecting and keeps calling connect
+// in the background, backing off exponentially from base up to
+// dbRetryMaxDelay. The first pool it gets is swapped into db, where
+// readiness and the log flusher pick it up. It stops early when ctx is
+// cancelled or something else, such as a DB_DSN_FILE reload, has already
+// set db. The returned channel is closed once it stops.
+func connectLater(ctx context.Context, connect func() (*sql.DB, error), base time.Duration) <-chan struct{} {
+	dbConnecting.Store(true)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer dbConnecting.Store(false)
+		delay := base
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+			if WriteDB() != nil {
+				return
+			}
+			d, err := connect()
+			if err == nil {
+				useDB(d)
+				recordEvent(eventDBReconnected, map[string]any{"reason": "connected in the background after startup"})
+				return
+			}
+			delay = min(delay*2, dbRetryMaxDelay)
+			slog.Warn("db still unreachable, retrying in the background", "delay", delay, "error", err)
+		}
+	}()
+	return done
+}
+
+// useDB points db at d unless another pool got there first, in which case
+// d is closed.
+func useDB(d *sql.DB) {
+	dbMu.Lock()
+	if db != nil {
+		dbMu.Unlock()
+		_ = d.Close()
+		return
+	}
+	db = d
+	dbMu.Unlock()
+	dbConnected.Store(true)
+	slog.Info("connected to postgres successfully")
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// resetDB clears db and the connection flags when the test ends.
+func resetDB(t *testing.T) {
+	t.Helper()
+	t.Cleanup(func() {
+		dbMu.Lock()
+		db = nil
+		dbMu.Unlock()
+		dbConnected.Store(false)
+		dbConnecting.Store(false)
+		dbRequired = true
+	})
+	dbMu.Lock()
+	db = nil
+	dbMu.Unlock()
+}
+
+func serveReady(t *testing.T) (int, readyResponse) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	readyHandler(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	var resp readyResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	return rec.Code, resp
+}
+
+func TestConnectLater_RecoversAfterFailures(t *testing.T) {
+	resetDB(t)
+	mockDB, mock, _ := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	defer func() { _ = mockDB.Close() }()
+
+	attempts := 0
+	connect := func() (*sql.DB, error) {
+		attempts++
+		if attempts < 3 {
+			return nil, errors.New("connection refused")
+		}
+		return mockDB, nil
+	}
+	done := connectLater(context.Background(), connect, time.Millisecond)
+	if !dbConnecting.Load() {
+		t.Error("expected dbConnecting to be set while retrying")
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("connectLater did not finish")
+	}
+
+	if attempts != 3 {
+		t.Errorf("expected 3 attempts, got %d", attempts)
+	}
+	if WriteDB() != mockDB || !dbConnected.Load() || dbConnecting.Load() {
+		t.Errorf("expected db swapped in and connecting cleared, got db=%v connected=%v connecting=%v",
+			WriteDB(), dbConnected.Load(), dbConnecting.Load())
+	}
+
+	mock.ExpectPing()
+	if code, resp := serveReady(t); code != http.StatusOK || resp.Status != "ready" {
+		t.Errorf("expected ready once connected, got %d %+v", code, resp)
+	}
+
+	mock.ExpectExec("INSERT INTO api_logs").WillReturnResult(sqlmock.NewResult(1, 1))
+	if err := (sqlLogSink{}).WriteLog(context.Background(), logEntry{}); err != nil {
+		t.Errorf("expected the log sink to write through the new pool, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReadyHandler_DBConnecting(t *testing.T) {
+	resetDB(t)
+	dbConnecting.Store(true)
+
+	dbRequired = true
+	code, resp := serveReady(t)
+	if code != http.StatusServiceUnavailable || resp.DB != "connecting" {
+		t.Errorf("required connecting db: expected 503 with db connecting, got %d %+v", code, resp)
+	}
+	if len(resp.Checks) != 1 || resp.Checks[0].Error != "db connecting" {
+		t.Errorf("expected the database check to report db connecting, got %+v", resp.Checks)
+	}
+
+	dbRequired = false
+	if code, resp := serveReady(t); code != http.StatusOK || resp.Status != "ready" || resp.DB != "connecting" {
+		t.Errorf("optional connecting db: expected ready with db connecting, got %d %+v", code, resp)
+	}
+}
+
+func TestConnectLater_Stops(t *testing.T) {
+	t.Run("context cancelled", func(t *testing.T) {
+		resetDB(t)
+		ctx, cancel := context.WithCancel(context.Background())
+		done := connectLater(ctx, func() (*sql.DB, error) {
+			return nil, errors.New("connection refused")
+		}, time.Millisecond)
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("connectLater ignored cancellation")
+		}
+		if dbConnecting.Load() || WriteDB() != nil {
+			t.Error("expected no db and connecting cleared after cancellation")
+		}
+	})
+
+	t.Run("db already set", func(t *testing.T) {
+		resetDB(t)
+		mockDB, _, _ := sqlmock.New()
+		defer func() { _ = mockDB.Close() }()
+		dbMu.Lock()
+		db = mockDB
+		dbMu.Unlock()
+		called := false
+		done := connectLater(context.Background(), func() (*sql.DB, error) {
+			called = true
+			return nil, errors.New("unexpected")
+		}, time.Millisecond)
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("connectLater did not stop")
+		}
+		if called || WriteDB() != mockDB {
+			t.Errorf("expected the existing db to be kept without connecting, called=%v", called)
+		}
+	})
+}
+
+func TestUseDB_KeepsExistingPool(t *testing.T) {
+	resetDB(t)
+	first, _, _ := sqlmock.New()
+	defer func() { _ = first.Close() }()
+	second, mock, _ := sqlmock.New()
+	mock.ExpectClose()
+
+	useDB(first)
+	useDB(second)
+	if WriteDB() != first {
+		t.Error("expected the first pool to stay in place")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expected the second pool to be closed: %v", err)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// flusherHeartbeatInterval is how often an idle flusher still reports in.
+	flusherHeartbeatInterval = time.Second
+	// flusherStallAfter is how long without a heartbeat before the flusher
+	// counts as stuck, e.g. blocked on a hung insert.
+	flusherStallAfter = 5 * flusherHeartbeatInterval
+	// flusherSaturationWindow is how long the buffer may stay full before
+	// the pipeline counts as unhealthy; short bursts are expected.
+	flusherSaturationWindow = 30 * time.Second
+)
+
+// flusher is the running log flusher; nil until startLogFlusher is called.
+var flusher *logFlusher
+
+// logFlusher drains logBuffer, and the lifecycle events recordEvent queues,
+// into the database and exposes its progress so readiness can tell when
+// access logs are being lost.
+type logFlusher struct {
+	ch         chan logEntry
+	flush      func(logEntry)
+	events     chan serviceEvent
+	flushEvent func(serviceEvent)
+	dropEvent  func()
+	heartbeat  atomic.Int64 // unix nanos of the last loop iteration
+	fullSince  atomic.Int64 // unix nanos the buffer was first seen full, 0 if not full
+	stopped    atomic.Bool
+	done       chan struct{} // closed once run has returned
+}
+
+func newLogFlusher(bufSize int, sink LogSink, m *metrics) *logFlusher {
+	f := &logFlusher{
+		ch:         make(chan logEntry, bufSize),
+		flush:      func(e logEntry) { flushLog(sink, e, m) },
+		events:     make(chan serviceEvent, eventQueueSize),
+		flushEvent: func(e serviceEvent) { flushEvent(e, m) },
+		dropEvent:  func() { m.serviceEvents.WithLabelValues(eventDropped).Inc() },
+		done:       make(chan struct{}),
+	}
+	f.beat(time.Now())
+	return f
+}
+
+// run flushes entries until ctx is cancelled, then drains what is left.
+// A panic stops the flusher instead of crashing the server; check reports it.
+func (f *logFlusher) run(ctx context.Context) {
+	defer close(f.done)
+	defer f.stopped.Store(true)
+	defer func() {
+		if r := recover(); r != nil {
+			slog.Error("log flusher panicked", "panic", r)
+		}
+	}()
+
+	ticker := time.NewTicker(flusherHeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		f.beat(time.Now())
+		select {
+		case entry := <-f.ch:
+			f.flush(entry)
+		case e := <-f.events:
+			f.flushEvent(e)
+		case <-ticker.C:
+		case <-ctx.Done():
+			// Drain remaining entries
+			for {
+				select {
+				case entry := <-f.ch:
+					f.flush(entry)
+				case e := <-f.events:
+					f.flushEvent(e)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// beat records a loop iteration and whether the buffer is currently full.
+func (f *logFlusher) beat(now time.Time) {
+	f.heartbeat.Store(now.UnixNano())
+	if len(f.ch) == cap(f.ch) {
+		f.fullSince.CompareAndSwap(0, now.UnixNano())
+	} else {
+		f.fullSince.Store(0)
+	}
+}
+
+// check reports why the pipeline is unhealthy at now, or nil.
+func (f *logFlusher) check(now time.Time) error {
+	if f.stopped.Load() {
+		return errors.New("log flusher stopped")
+	}
+	if now.Sub(time.Unix(0, f.heartbeat.Load())) > flusherStallAfter {
+		return errors.New("log flusher stalled")
+	}
+	if since := f.fullSince.Load(); since != 0 && now.Sub(time.Unix(0, since)) > flusherSaturationWindow {
+		return errors.New("log buffer saturated")
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLogFlusher_Check(t *testing.T) {
+	now := time.Now()
+
+	f := newLogFlusher(1, nil, nil)
+	if err := f.check(now); err != nil {
+		t.Errorf("expected a fresh flusher to pass, got %v", err)
+	}
+	if err := f.check(now.Add(flusherStallAfter + time.Second)); err == nil || err.Error() != "log flusher stalled" {
+		t.Errorf("expected log flusher stalled, got %v", err)
+	}
+
+	f.ch <- logEntry{}
+	f.beat(now)
+	if err := f.check(now.Add(time.Second)); err != nil {
+		t.Errorf("expected a briefly full buffer to pass, got %v", err)
+	}
+	f.beat(now.Add(flusherSaturationWindow))
+	if err := f.check(now.Add(flusherSaturationWindow + time.Second)); err == nil || err.Error() != "log buffer saturated" {
+		t.Errorf("expected log buffer saturated, got %v", err)
+	}
+
+	<-f.ch
+	f.beat(now.Add(flusherSaturationWindow + time.Second))
+	if err := f.check(now.Add(flusherSaturationWindow + time.Second)); err != nil {
+		t.Errorf("expected draining the buffer to clear saturation, got %v", err)
+	}
+
+	f.stopped.Store(true)
+	if err := f.check(now); err == nil || err.Error() != "log flusher stopped" {
+		t.Errorf("expected log flusher stopped, got %v", err)
+	}
+}
+
+func TestLogFlusher_RecoversPanic(t *testing.T) {
+	f := newLogFlusher(1, nil, nil)
+	f.flush = func(logEntry) { panic("boom") }
+	f.ch <- logEntry{}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f.run(context.Background())
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("flusher did not stop after panicking")
+	}
+	if err := f.check(time.Now()); err == nil || err.Error() != "log flusher stopped" {
+		t.Errorf("expected log flusher stopped, got %v", err)
+	}
+}
+
+func TestReadyHandler_LogPipeline(t *testing.T) {
+	prevChecks, prevFlusher, prevBuffer := readyChecks, flusher, logBuffer
+	defer func() { readyChecks, flusher, logBuffer = prevChecks, prevFlusher, prevBuffer }()
+	defer func() { dbRequired = true }()
+
+	dbMu.Lock()
+	db = nil
+	dbMu.Unlock()
+	dbRequired = false
+
+	pipelineStatus := func() (string, checkResult) {
+		rec := httptest.NewRecorder()
+		readyHandler(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+		var resp readyResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		for _, c := range resp.Checks {
+			if c.Name == "log_pipeline" {
+				return resp.Status, c
+			}
+		}
+		t.Fatalf("log_pipeline check missing from %+v", resp.Checks)
+		return "", checkResult{}
+	}
+
+	readyChecks = append(readyChecks, readinessCheck{Checker: logPipelineChecker{}})
+	ctx, cancel := context.WithCancel(context.Background())
+	m, _ := newTestMetrics(t)
+	startLogFlusher(ctx, 8, sqlLogSink{}, m)
+
+	if status, c := pipelineStatus(); status != "ready" || !c.OK {
+		t.Errorf("expected a ready pipeline, got %s %+v", status, c)
+	}
+
+	cancel()
+	deadline := time.Now().Add(time.Second)
+	for !flusher.stopped.Load() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	if status, c := pipelineStatus(); status != "degraded" || c.OK || c.Error != "log flusher stopped" {
+		t.Errorf("expected a degraded pipeline after the flusher stopped, got %s %+v", status, c)
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	logFormatJSON   = "json"
+	logFormatText   = "text"
+	logOutputStdout = "stdout"
+	logOutputStderr = "stderr"
+
+	// logFileMaxSize and logFileMaxBackups bound a LOG_OUTPUT file: it is
+	// rotated once it would grow past logFileMaxSize, keeping
+	// logFileMaxBackups old files beside it.
+	logFileMaxSize    = 100 << 20
+	logFileMaxBackups = 5
+)
+
+// parseLogFormat accepts json or text, case-insensitively.
+func parseLogFormat(s string) (string, error) {
+	switch f := strings.ToLower(s); f {
+	case logFormatJSON, logFormatText:
+		return f, nil
+	}
+	return "", fmt.Errorf("want %s or %s, got %q", logFormatJSON, logFormatText, s)
+}
+
+// newLogHandler formats records at logLevel as JSON or, for format text, as
+// key=value pairs, with secrets redacted and optionally the source line.
+func newLogHandler(w io.Writer, format string, source bool) slog.Handler {
+	opts := &slog.HandlerOptions{
+		AddSource:   source,
+		Level:       logLevel,
+		ReplaceAttr: redactLogAttr,
+	}
+	if format == logFormatText {
+		return slog.NewTextHandler(w, opts)
+	}
+	return slog.NewJSONHandler(w, opts)
+}
+
+// openLogOutput opens the destination LOG_OUTPUT names: stdout (also for an
+// empty value), stderr or the path of a file that is appended to and
+// rotated by size.
+func openLogOutput(output string) (io.WriteCloser, error) {
+	switch output {
+	case "", logOutputStdout:
+		return nopWriteCloser{os.Stdout}, nil
+	case logOutputStderr:
+		return nopWriteCloser{os.Stderr}, nil
+	}
+	return openRotatingFile(output, logFileMaxSize, logFileMaxBackups)
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+// setupLogger makes the logger cfg describes the default and reports the
+// settings that fell back to their defaults. LOG_OUTPUT syslog sends the
+// records to syslog rather than a stream, and an output that can't be opened
+// or dialled falls back to stdout in the same way. The returned function
+// closes the output.
+func setupLogger(cfg Config) func() {
+	warnings := cfg.warnings
+	var out io.WriteCloser
+	var err error
+	if cfg.LogOutput == logOutputSyslog {
+		out, err = dialSyslog(cfg.SyslogNetwork, cfg.SyslogAddr, cfg.ServiceName)
+	} else {
+		out, err = openLogOutput(cfg.LogOutput)
+	}
+	if err != nil {
+		warnings = append(warnings, fmt.Errorf("LOG_OUTPUT: %w", err))
+		out = nopWriteCloser{os.Stdout}
+	}
+	handler := newLogHandler(out, cfg.LogFormat, cfg.LogSource)
+	if s, ok := out.(*syslogOutput); ok {
+		handler = syslogHandler{Handler: handler, out: s}
+	}
+	slog.SetDefault(slog.New(handler))
+	for _, w := range warnings {
+		slog.Warn("invalid setting, using the default", "error", w)
+	}
+	return func() { _ = out.Close() }
+}
+
+// rotatingFile appends to the log file at path. Once a write would take it
+// past maxSize the file is renamed to path.1, older copies shift up to
+// path.<maxBackups> and a fresh file is started.
+type rotatingFile struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	f          *os.File
+	size       int64
+}
+
+func openRotatingFile(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	r.f, r.size = f, info.Size()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return 0, os.ErrClosed
+	}
+	if r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotatingFile) rotate() error {
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+	r.f = nil
+	if r.maxBackups == 0 {
+		if err := os.Remove(r.path); err != nil {
+			return err
+		}
+		return r.open()
+	}
+	for i := r.maxBackups - 1; i >= 1; i-- {
+		err := os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	if err := os.Rename(r.path, r.path+".1"); err != nil {
+		return err
+	}
+	return r.open()
+}
+
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.f = nil
+	return err
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewLogHandler(t *testing.T) {
+	resetLogSecrets(t)
+	addLogSecret("postgres://app:s3cr3t-pw@db:5432/app")
+	for _, format := range []string{logFormatJSON, logFormatText} {
+		for _, source := range []bool{false, true} {
+			var buf bytes.Buffer
+			logger := slog.New(newLogHandler(&buf, format, source))
+			logger.Info("hello", "dsn", "postgres://app:s3cr3t-pw@db:5432/app")
+			logger.Debug("hidden")
+			out := buf.String()
+			name := format
+			if source {
+				name += "+source"
+			}
+
+			if strings.Count(out, "\n") != 1 {
+				t.Errorf("%s: expected one record at the info level, got %q", name, out)
+			}
+			if strings.Contains(out, "s3cr3t-pw") {
+				t.Errorf("%s: password in %q", name, out)
+			}
+			if got := strings.Contains(out, "logger_test.go"); got != source {
+				t.Errorf("%s: expected source in the record to be %v, got %q", name, source, out)
+			}
+			switch format {
+			case logFormatJSON:
+				var rec map[string]any
+				if err := json.Unmarshal(buf.Bytes(), &rec); err != nil {
+					t.Fatalf("%s: expected a JSON record: %v", name, err)
+				}
+				if rec["msg"] != "hello" || rec["level"] != "INFO" {
+					t.Errorf("%s: unexpected record %v", name, rec)
+				}
+			case logFormatText:
+				if !strings.Contains(out, "level=INFO ") || !strings.Contains(out, " msg=hello dsn=") || strings.HasPrefix(out, "{") {
+					t.Errorf("%s: expected a key=value record, got %q", name, out)
+				}
+			}
+		}
+	}
+}
+
+func TestParseLogFormat(t *testing.T) {
+	for in, want := range map[string]string{"json": logFormatJSON, "TEXT": logFormatText} {
+		if got, err := parseLogFormat(in); err != nil || got != want {
+			t.Errorf("parseLogFormat(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	if _, err := parseLogFormat("logfmt"); err == nil {
+		t.Error("expected an unknown format to be rejected")
+	}
+}
+
+func TestOpenLogOutput(t *testing.T) {
+	for output, want := range map[string]*os.File{"": os.Stdout, logOutputStdout: os.Stdout, logOutputStderr: os.Stderr} {
+		w, err := openLogOutput(output)
+		if err != nil {
+			t.Fatalf("openLogOutput(%q): %v", output, err)
+		}
+		if nop, ok := w.(nopWriteCloser); !ok || nop.Writer != want {
+			t.Errorf("openLogOutput(%q): expected %s, got %v", output, want.Name(), w)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "api.log")
+	w, err := openLogOutput(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("line\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "line\n" {
+		t.Errorf("expected the record in the file, got %q", data)
+	}
+
+	if _, err := openLogOutput(filepath.Join(t.TempDir(), "missing", "api.log")); err == nil {
+		t.Error("expected an error for a file in a missing directory")
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api.log")
+	if err := os.WriteFile(path, []byte("old\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r, err := openRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = r.Close() }()
+	for _, line := range []string{"aaaa\n", "bbbbbbbb\n", "cccccccc\n", "dddddddd\n"} {
+		if _, err := r.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for file, want := range map[string]string{
+		path:        "dddddddd\n",
+		path + ".1": "cccccccc\n",
+		path + ".2": "bbbbbbbb\n",
+	} {
+		if data, err := os.ReadFile(file); err != nil || string(data) != want {
+			t.Errorf("%s: expected %q, got %q (%v)", filepath.Base(file), want, data, err)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected only two backups to be kept, got %v", err)
+	}
+
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Write([]byte("late\n")); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("expected writes after Close to fail, got %v", err)
+	}
+}
+
+func TestSetupLogger(t *testing.T) {
+	prev := slog.Default()
+	defer slog.SetDefault(prev)
+
+	path := filepath.Join(t.TempDir(), "api.log")
+	cfg := defaultConfig()
+	cfg.LogFormat = logFormatText
+	cfg.LogOutput = path
+	cfg.warnings = []error{errors.New("LOG_LEVEL: invalid log level \"verbose\"")}
+	closeLog := setupLogger(cfg)
+	slog.Info("configured")
+	closeLog()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(data)
+	if !strings.Contains(out, `level=WARN msg="invalid setting, using the default"`) || !strings.Contains(out, "LOG_LEVEL") {
+		t.Errorf("expected the fallback warning in the log file, got %q", out)
+	}
+	if !strings.Contains(out, "level=INFO msg=configured") {
+		t.Errorf("expected later records in the log file, got %q", out)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logLevel is the level of the default slog handler; main sets it from
+// LOG_LEVEL and /admin/loglevel changes it at runtime.
+var logLevel = new(slog.LevelVar)
+
+// parseLogLevel accepts debug, info, warn or error, case-insensitively.
+func parseLogLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("invalid log level %q: want debug, info, warn or error", s)
+}
+
+// logLevelControl changes logLevel, optionally only for a while, so a debug
+// session can't be forgotten in production.
+type logLevelControl struct {
+	mu       sync.Mutex
+	timer    *time.Timer
+	revertTo slog.Level
+	revertAt time.Time
+}
+
+var logLevels logLevelControl
+
+// set changes the level to level. With a positive d it reverts after d to
+// the level in force before the first temporary change; otherwise the
+// change is permanent and any pending revert is cancelled.
+func (c *logLevelControl) set(level slog.Level, d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	base := logLevel.Level()
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+		base = c.revertTo
+	}
+	c.revertAt = time.Time{}
+	logLevel.Set(level)
+	if d <= 0 {
+		return
+	}
+	c.revertTo, c.revertAt = base, time.Now().Add(d)
+	var timer *time.Timer
+	timer = time.AfterFunc(d, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.timer != timer {
+			return
+		}
+		logLevel.Set(c.revertTo)
+		c.timer, c.revertAt = nil, time.Time{}
+		slog.Info("log level reverted", "level", c.revertTo.String())
+	})
+	c.timer = timer
+}
+
+// LogLevelResponse is the JSON body of /admin/loglevel.
+type LogLevelResponse struct {
+	Status   string `json:"status"`
+	Level    string `json:"level"`
+	RevertTo string `json:"revert_to,omitempty"`
+	RevertAt string `json:"revert_at,omitempty"`
+}
+
+func (c *logLevelControl) response() LogLevelResponse {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	resp := LogLevelResponse{Status: "ok", Level: strings.ToLower(logLevel.Level().String())}
+	if !c.revertAt.IsZero() {
+		resp.RevertTo = strings.ToLower(c.revertTo.String())
+		resp.RevertAt = c.revertAt.UTC().Format(time.RFC3339)
+	}
+	return resp
+}
+
+// logLevelHandler reports the log level on GET and changes it on PUT with
+// ?level= and an optional ?duration= after which it reverts.
+func logLevelHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPut {
+		level, err := parseLogLevel(r.URL.Query().Get("level"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+			return
+		}
+		var d time.Duration
+		if durStr := r.URL.Query().Get("duration"); durStr != "" {
+			d, err = time.ParseDuration(durStr)
+			if err != nil || d <= 0 {
+				writeError(w, http.StatusBadRequest, codeInvalidRequest, "invalid duration: want a positive Go duration such as 10m")
+				return
+			}
+		}
+		logLevels.set(level, d)
+		slog.Warn("log level changed", "level", level.String(), "duration", d)
+	}
+
+	writeJSON(w, http.StatusOK, logLevels.response())
+}
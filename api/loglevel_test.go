@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// captureLogs routes the default logger through logLevel and the secret
+// redaction main installs into a buffer, and resets the level afterwards.
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(newLogHandler(&buf, logFormatJSON, false)))
+	t.Cleanup(func() {
+		slog.SetDefault(prev)
+		logLevels.set(slog.LevelInfo, 0)
+	})
+	logLevels.set(slog.LevelInfo, 0)
+	return &buf
+}
+
+func serveLogLevel(t *testing.T, method, target string) (*httptest.ResponseRecorder, LogLevelResponse) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	logLevelHandler(rec, httptest.NewRequest(method, target, nil))
+	var resp LogLevelResponse
+	if rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+	}
+	return rec, resp
+}
+
+func TestLogLevelHandler_EnablesDebug(t *testing.T) {
+	buf := captureLogs(t)
+
+	slog.Debug("before")
+	if _, resp := serveLogLevel(t, http.MethodGet, routeAdminLogLevel); resp.Level != "info" {
+		t.Errorf("expected level info, got %q", resp.Level)
+	}
+	if _, resp := serveLogLevel(t, http.MethodPut, routeAdminLogLevel+"?level=debug"); resp.Level != "debug" || resp.RevertAt != "" {
+		t.Errorf("expected a permanent debug level, got %+v", resp)
+	}
+	slog.Debug("after")
+
+	if strings.Contains(buf.String(), `"msg":"before"`) {
+		t.Error("expected debug records to be dropped at info")
+	}
+	if !strings.Contains(buf.String(), `"msg":"after"`) {
+		t.Errorf("expected debug records after switching to debug, got %s", buf)
+	}
+}
+
+func TestLogLevelHandler_Revert(t *testing.T) {
+	captureLogs(t)
+
+	_, resp := serveLogLevel(t, http.MethodPut, routeAdminLogLevel+"?level=debug&duration=50ms")
+	if resp.Level != "debug" || resp.RevertTo != "info" || resp.RevertAt == "" {
+		t.Errorf("expected debug reverting to info, got %+v", resp)
+	}
+	// A second temporary change still reverts to the original level.
+	_, resp = serveLogLevel(t, http.MethodPut, routeAdminLogLevel+"?level=warn&duration=50ms")
+	if resp.RevertTo != "info" {
+		t.Errorf("expected the revert target to stay info, got %+v", resp)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for logLevel.Level() != slog.LevelInfo {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the level to revert to info, still %v", logLevel.Level())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, resp := serveLogLevel(t, http.MethodGet, routeAdminLogLevel); resp.RevertAt != "" {
+		t.Errorf("expected no pending revert, got %+v", resp)
+	}
+}
+
+func TestLogLevelHandler_PermanentChangeCancelsRevert(t *testing.T) {
+	captureLogs(t)
+
+	serveLogLevel(t, http.MethodPut, routeAdminLogLevel+"?level=debug&duration=20ms")
+	serveLogLevel(t, http.MethodPut, routeAdminLogLevel+"?level=error")
+	time.Sleep(60 * time.Millisecond)
+
+	if logLevel.Level() != slog.LevelError {
+		t.Errorf("expected the permanent error level to stick, got %v", logLevel.Level())
+	}
+}
+
+func TestLogLevelHandler_BadParams(t *testing.T) {
+	captureLogs(t)
+	for _, query := range []string{"", "?level=verbose", "?level=debug&duration=soon", "?level=debug&duration=-1m"} {
+		rec, _ := serveLogLevel(t, http.MethodPut, routeAdminLogLevel+query)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%q: expected 400, got %d", query, rec.Code)
+		}
+	}
+	if logLevel.Level() != slog.LevelInfo {
+		t.Errorf("expected rejected changes to leave the level alone, got %v", logLevel.Level())
+	}
+}
+
+func TestLogLevel_RequiresAdminToken(t *testing.T) {
+	withAdminToken(t, "s3cret")
+	m, reg := newTestMetrics(t)
+	rt := newInternalMux(reg, m, time.Now())
+
+	rec := httptest.NewRecorder()
+	rt.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, routeAdminLogLevel+"?level=debug", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without a token, got %d", rec.Code)
+	}
+}
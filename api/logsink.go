@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Values of DB_DRIVER, the data layer the log flusher writes through.
+const (
+	dbDriverStdlib    = "stdlib"
+	dbDriverPgxNative = "pgx-native"
+)
+
+// LogSink stores access log entries; the log flusher writes every entry
+// through one.
+type LogSink interface {
+	WriteLog(ctx context.Context, e logEntry) error
+}
+
+// errNoDB means a sink has no database to write to. The entry is dropped
+// without counting as a flush, as when DB_DSN is unset.
+var errNoDB = errors.New("database not connected")
+
+const insertLogSQL = `
+	INSERT INTO api_logs (method, endpoint, status, duration_ms, ttfb_ms, remote_addr, trace_id, rate_limited)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+`
+
+// sqlLogSink writes through database/sql, using whichever pool db points
+// at.
+type sqlLogSink struct{}
+
+func (sqlLogSink) WriteLog(ctx context.Context, e logEntry) error {
+	d := WriteDB()
+	if d == nil {
+		return errNoDB
+	}
+	_, err := d.ExecContext(ctx, insertLogSQL, e.method, e.endpoint, e.status, e.durationMs, e.ttfbMs, e.remoteAddr, e.traceID(), e.rateLimited)
+	return err
+}
+
+// flushLog writes entry to sink and records the insert on m, in a span
+// under the request's own.
+func flushLog(sink LogSink, entry logEntry, m *metrics) {
+	ctx, span := tracer.Start(trace.ContextWithSpanContext(context.Background(), entry.trace), "INSERT api_logs",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system.name", "postgresql"),
+			attribute.String("db.operation.name", "INSERT"),
+			attribute.String("db.collection.name", "api_logs"),
+		),
+	)
+	start := time.Now()
+	err := sink.WriteLog(ctx, entry)
+	endSpan(span, err)
+	if errors.Is(err, errNoDB) {
+		return
+	}
+	m.logFlushDuration.Observe(time.Since(start).Seconds())
+	m.logFlushBatchSize.Observe(1)
+	if err != nil {
+		m.logFlushErrors.Inc()
+		slog.Error("failed to log request to db", "error", err)
+	}
+}
@@ -1,28 +1,37 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"database/sql"
-	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"golang.org/x/time/rate"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// db is the primary pool and readDB the DB_READ_DSN replica, if any; read
+// them through WriteDB and ReadDB.
 var (
-	db   *sql.DB
-	dbMu sync.RWMutex
+	db     *sql.DB
+	readDB *sql.DB
+	dbMu   sync.RWMutex
 )
 
 // logEntry holds the data for an async DB log insert.
@@ -31,145 +40,124 @@ type logEntry struct {
 	endpoint   string
 	status     int
 	durationMs float64
+	// ttfbMs is the part of durationMs before the response header was
+	// written; the rest went to writing the body.
+	ttfbMs     float64
 	remoteAddr string
+	// rateLimited is set when the rate limiter answered the request with
+	// its 429 instead of a handler.
+	rateLimited bool
+	// trace is the request's span, the parent of the insert's span.
+	trace trace.SpanContext
+}
+
+// traceID is the trace_id column of e: the request's trace, or NULL when it
+// wasn't traced.
+func (e logEntry) traceID() sql.NullString {
+	if !e.trace.IsValid() {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: e.trace.TraceID().String(), Valid: true}
 }
 
 // logBuffer is the channel used for async DB logging.
 var logBuffer chan logEntry
 
 // startLogFlusher starts a background goroutine that drains logBuffer
-// and inserts rows into the database. It stops when ctx is cancelled
+// and writes the entries to sink. It stops when ctx is cancelled
 // and drains any remaining entries before returning.
-func startLogFlusher(ctx context.Context, bufSize int) {
-	ch := make(chan logEntry, bufSize)
-	logBuffer = ch
-	go func() {
-		for {
-			select {
-			case entry := <-ch:
-				flushLog(entry)
-			case <-ctx.Done():
-				// Drain remaining entries
-				for {
-					select {
-					case entry := <-ch:
-						flushLog(entry)
-					default:
-						return
-					}
-				}
-			}
-		}
-	}()
+func startLogFlusher(ctx context.Context, bufSize int, sink LogSink, m *metrics) {
+	f := newLogFlusher(bufSize, sink, m)
+	logBuffer = f.ch
+	flusher = f
+	eventFlusher.Store(f)
+	go f.run(ctx)
 }
 
-func flushLog(entry logEntry) {
-	dbMu.RLock()
-	d := db
-	dbMu.RUnlock()
-	if d == nil {
-		return
-	}
-	_, err := d.Exec(`
-		INSERT INTO api_logs (method, endpoint, status, duration_ms, remote_addr)
-		VALUES ($1, $2, $3, $4, $5)
-	`, entry.method, entry.endpoint, entry.status, entry.durationMs, entry.remoteAddr)
+// initDB opens a pool on dsn, checks it can connect and brings the schema
+// up to date. Cancelling ctx abandons the attempt.
+func initDB(ctx context.Context, dsn string) (_ *sql.DB, err error) {
+	defer func() { lastDBError.record(redactDSNError(err, dsn)) }()
+	d, err := sql.Open("pgx", dsn)
 	if err != nil {
-		slog.Error("failed to log request to db", "error", err)
+		return nil, err
 	}
+	if err := d.PingContext(ctx); err != nil {
+		_ = d.Close()
+		return nil, err
+	}
+	if _, err := migrate(ctx, d, migrations); err != nil {
+		_ = d.Close()
+		return nil, err
+	}
+	return d, nil
 }
 
-func initDB(dsn string) (*sql.DB, error) {
-	d, err := sql.Open("pgx", dsn)
+// openDB connects to dsn, brings the schema up to date and sizes the pool
+// with dbPool. The error never includes the DSN password.
+func openDB(ctx context.Context, dsn string) (*sql.DB, error) {
+	d, err := initDB(ctx, dsn)
 	if err != nil {
-		return nil, err
+		return nil, redactDSNError(err, dsn)
 	}
-
-	_, err = d.Exec(`
-		CREATE TABLE IF NOT EXISTS api_logs (
-			id SERIAL PRIMARY KEY,
-			method VARCHAR(10),
-			endpoint VARCHAR(255),
-			status INT,
-			duration_ms FLOAT,
-			remote_addr VARCHAR(255),
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			processed_at TIMESTAMP NULL
-		)
-	`)
-	return d, err
+	dbPool.apply(d)
+	return d, nil
 }
 
-// connectWithRetry attempts to connect to the database with exponential backoff.
-func connectWithRetry(dsn string, maxRetries int, baseDelay time.Duration) (*sql.DB, error) {
+// connectWithRetry attempts to connect to the database with exponential
+// backoff, counting failed attempts and exhausted retries on m. Each attempt
+// starts with preflightDSN, and while no DSN host resolves it gives up after
+// dsnUnresolvableRetries attempts. Cancelling ctx, on a shutdown signal say,
+// cuts the current attempt or wait short and returns the context's error.
+func connectWithRetry(ctx context.Context, dsn string, maxRetries int, baseDelay time.Duration, m *metrics) (*sql.DB, error) {
 	var d *sql.DB
 	var err error
 	for i := 0; i < maxRetries; i++ {
-		d, err = initDB(dsn)
-		if err == nil {
-			// Configure connection pool
-			d.SetMaxOpenConns(25)
-			d.SetMaxIdleConns(5)
-			d.SetConnMaxLifetime(5 * time.Minute)
-			return d, nil
+		if err = preflightDSN(ctx, dsn); err == nil {
+			d, err = openDB(ctx, dsn)
+			if err == nil {
+				return d, nil
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		m.dbConnectRetries.Inc()
+		if errors.As(err, new(unresolvableError)) {
+			maxRetries = min(maxRetries, dsnUnresolvableRetries)
+		}
+		if i+1 == maxRetries {
+			break
 		}
 		delay := baseDelay * (1 << uint(i))
 		slog.Warn("db connection failed, retrying", "attempt", i+1, "max", maxRetries, "delay", delay, "error", err)
-		time.Sleep(delay)
+		select {
+		case <-ctx.Done():
+		case <-time.After(delay):
+		}
 	}
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("database connection abandoned: %w", err)
+	}
+	m.dbConnectFailures.Inc()
 	return nil, fmt.Errorf("failed to connect after %d retries: %w", maxRetries, err)
 }
 
-// Prometheus metrics
-var (
-	httpRequestsTotal = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Name: "http_requests_total",
-			Help: "Total number of HTTP requests",
-		},
-		[]string{"method", "endpoint", "status"},
-	)
-	httpRequestDuration = prometheus.NewHistogramVec(
-		prometheus.HistogramOpts{
-			Name:    "http_request_duration_seconds",
-			Help:    "HTTP request duration in seconds",
-			Buckets: prometheus.DefBuckets,
-		},
-		[]string{"method", "endpoint"},
-	)
-	httpErrorsTotal = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Name: "http_errors_total",
-			Help: "Total number of HTTP errors (4xx and 5xx)",
-		},
-		[]string{"method", "endpoint", "status"},
-	)
-	httpRateLimitedTotal = prometheus.NewCounter(
-		prometheus.CounterOpts{
-			Name: "http_rate_limited_total",
-			Help: "Total number of rate-limited requests",
-		},
-	)
-)
-
-func init() {
-	prometheus.MustRegister(httpRequestsTotal)
-	prometheus.MustRegister(httpRequestDuration)
-	prometheus.MustRegister(httpErrorsTotal)
-	prometheus.MustRegister(httpRateLimitedTotal)
-}
-
-// rateLimitMiddleware returns HTTP 429 when the rate limit is exceeded.
-func rateLimitMiddleware(limiter *rate.Limiter) func(http.Handler) http.Handler {
+// rateLimitMiddleware returns HTTP 429 when the rate limit is exceeded,
+// counting the rejection under the route it would have matched on rt and the
+// client's class. The rate limit admin routes are exempt so the limiter can
+// be inspected and reset while it is rejecting traffic. Inside
+// metricsMiddleware, the rejection is marked on its statusRecorder so the
+// request is logged as rate limited.
+func rateLimitMiddleware(limiter *rateLimiter, m *metrics, rt *router) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if !limiter.Allow() {
-				httpRateLimitedTotal.Inc()
-				w.Header().Set(headerContentType, contentTypeJSON)
-				w.WriteHeader(http.StatusTooManyRequests)
-				if _, err := w.Write([]byte(`{"status":"error","message":"rate limit exceeded"}`)); err != nil {
-					slog.Error(errWriteResponse, "error", err)
+			if !isRateLimitAdmin(r.URL.Path) && !limiter.allow() {
+				m.countRateLimited(rt.routePattern(r.URL.Path), clientClass(r))
+				if rec, ok := w.(*statusRecorder); ok {
+					rec.rateLimited = true
 				}
+				writeError(w, http.StatusTooManyRequests, codeRateLimited, "rate limit exceeded")
 				return
 			}
 			next.ServeHTTP(w, r)
@@ -185,262 +173,624 @@ type HealthResponse struct {
 	Version   string `json:"version"`
 }
 
+// defaultServiceName is reported when SERVICE_NAME is unset.
+const defaultServiceName = "api"
+
+// serviceName identifies this binary in health responses; main overrides it
+// from SERVICE_NAME.
+var serviceName = defaultServiceName
+
 // HTTP header and content type constants to avoid duplicated string literals.
 const (
-	headerContentType   = "Content-Type"
-	contentTypeJSON     = "application/json"
-	errWriteResponse    = "failed to write response"
+	headerContentType = "Content-Type"
+	contentTypeJSON   = "application/json"
+	errWriteResponse  = "failed to write response"
 )
 
-// Route path constants to avoid duplicated string literals.
+// Readiness probe defaults: how long /ready waits for the DB ping and how
+// long a result is reused.
 const (
-	routeLive    = "/live"
-	routeReady   = "/ready"
-	routeMetrics = "/metrics"
-	routePublic  = "/api/v1/time"
+	defaultReadyPingTimeout = 2 * time.Second
+	defaultReadyCacheTTL    = 2 * time.Second
 )
 
-// knownRoutes maps registered paths to their route pattern to prevent
-// unbounded cardinality in Prometheus labels.
-var knownRoutes = map[string]string{
-	routeLive:    routeLive,
-	routeReady:   routeReady,
-	routeMetrics: routeMetrics,
-	routePublic:  routePublic,
-}
+// readyPingTimeout bounds the readiness DB ping so a stalled Postgres fails
+// the probe instead of hanging it. Overridden by READY_PING_TIMEOUT.
+var readyPingTimeout = defaultReadyPingTimeout
 
-func routePattern(path string) string {
-	if route, ok := knownRoutes[path]; ok {
-		return route
-	}
-	return "/other"
-}
+// Route path constants to avoid duplicated string literals.
+const (
+	routeLive                = "/live"
+	routeReady               = "/ready"
+	routeMetrics             = "/metrics"
+	routePublic              = "/api/v1/time"
+	routeVersion             = "/version"
+	routeStartup             = "/startup"
+	routeHealthz             = "/healthz"
+	routeStats               = "/api/v1/stats"
+	routeStatsClients        = "/api/v1/stats/clients"
+	routeLogsExport          = "/api/v1/logs/export"
+	routeEvents              = "/api/v1/events"
+	routeAdminLogs           = "/admin/logs"
+	routeAdminLogLevel       = "/admin/loglevel"
+	routeAdminRateLimit      = "/admin/ratelimit"
+	routeAdminRateLimitReset = "/admin/ratelimit/reset"
+	routeAdminConfig         = "/admin/config"
+	routeOpenAPI             = "/openapi.json"
+)
 
-// metricsMiddleware records request metrics for Prometheus.
-func metricsMiddleware(next http.Handler) http.Handler {
+// metricsMiddleware records request metrics for Prometheus, labelling each
+// request with the route it matched on rt. With FAULT_INJECTION on, it
+// applies the request's fault before rt sees it. The wrap middlewares,
+// outermost first, run between it and rt, so a request one of them answers
+// itself, like the rate limiter's 429, is recorded the same way, once.
+func metricsMiddleware(m *metrics, rt *router, wrap ...func(http.Handler) http.Handler) http.Handler {
+	var next http.Handler = rt
+	for _, mw := range slices.Backward(wrap) {
+		next = mw(next)
+	}
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 		rec := &statusRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+		f, faultErr := faults.pick(r, rt.routePattern(r.URL.Path))
 
-		next.ServeHTTP(rec, r)
+		// Record from a defer so a panicking handler is still observed, as
+		// the 500 recoverMiddleware will send, while the panic propagates.
+		panicked := true
+		defer func() {
+			if panicked && !rec.wroteHeader {
+				rec.statusCode = http.StatusInternalServerError
+			}
+			observeRequest(m, rt, r, rec, start, f.injected())
+			if !panicked && !f.injected() {
+				reportErrorResponse(rt, r, rec.statusCode)
+			}
+		}()
+		switch {
+		case faultErr != nil:
+			writeError(rec, http.StatusBadRequest, codeInvalidRequest, faultErr.Error())
+		case f.apply(r.Context(), rec):
+			// The injected status is the response; the handler never runs.
+		default:
+			next.ServeHTTP(rec, r)
+		}
+		panicked = false
+	})
+}
 
-		duration := time.Since(start).Seconds()
-		status := http.StatusText(rec.statusCode)
-		route := routePattern(r.URL.Path)
+// observeRequest records a finished request in the Prometheus and statsd
+// metrics, the SLO counters, the access log buffer and the request log, plus
+// a debug record when the request is sampled for one. An injected fault is
+// labelled as one and kept out of the SLO and slow request counts.
+func observeRequest(m *metrics, rt *router, r *http.Request, rec *statusRecorder, start time.Time, injected bool) {
+	end := time.Now()
+	elapsed := end.Sub(start)
+	ttfb := rec.ttfb(start, end)
+	status := http.StatusText(rec.statusCode)
+	route := rt.routePattern(r.URL.Path)
+	sc := trace.SpanContextFromContext(r.Context())
+
+	m.observeHTTP(r.Method, route, rec.statusCode, elapsed, ttfb, injected, sc)
+	if !injected {
+		slo.observe(m, route, rec.statusCode)
+		observeSlowRequest(m, r, route, rec.statusCode, elapsed)
+	}
 
-		httpRequestsTotal.WithLabelValues(r.Method, route, status).Inc()
-		httpRequestDuration.WithLabelValues(r.Method, route).Observe(duration)
+	// Rejected scrapes are access control doing its job, not service errors.
+	rejectedScrape := route == routeMetrics && rec.statusCode == http.StatusUnauthorized
+	if rec.statusCode >= 400 && !rejectedScrape {
+		m.errorsTotal.WithLabelValues(r.Method, route, status, strconv.FormatBool(injected)).Inc()
+	}
 
-		if rec.statusCode >= 400 {
-			httpErrorsTotal.WithLabelValues(r.Method, route, status).Inc()
-		}
+	entry := logEntry{
+		method:      r.Method,
+		endpoint:    r.URL.Path,
+		status:      rec.statusCode,
+		durationMs:  elapsed.Seconds() * 1000,
+		ttfbMs:      ttfb.Seconds() * 1000,
+		remoteAddr:  clientIP(r),
+		trace:       sc,
+		rateLimited: rec.rateLimited,
+	}
 
-		if logBuffer != nil {
-			select {
-			case logBuffer <- logEntry{
-				method:     r.Method,
-				endpoint:   r.URL.Path,
-				status:     rec.statusCode,
-				durationMs: duration * 1000,
-				remoteAddr: r.RemoteAddr,
-			}:
-			default:
-				slog.Warn("log buffer full, dropping log entry")
-			}
+	// Profiles are large and pulled repeatedly during an investigation;
+	// they'd only crowd out real traffic in api_logs.
+	if logBuffer != nil && !strings.HasPrefix(route, routePprof) {
+		select {
+		case logBuffer <- entry:
+		default:
+			slog.Warn("log buffer full, dropping log entry")
 		}
+	}
 
-		slog.Info("request completed", // #nosec G706 -- slog JSON handler safely encodes values
-			"method", r.Method,
-			"path", r.URL.Path,
-			"status", rec.statusCode,
-			"duration_ms", duration*1000,
-			"remote_addr", r.RemoteAddr,
-		)
-	})
+	record := newAccessRecord(r, entry, start)
+	accessLog(record)
+	if debugLog.sampled(r) {
+		logRequestDebug(r.Context(), r, record)
+	}
 }
 
-// statusRecorder wraps http.ResponseWriter to capture the status code.
+// statusRecorder wraps http.ResponseWriter to capture the status code. It
+// implements http.Flusher, http.Hijacker, http.Pusher and io.ReaderFrom so
+// handlers that assert them still work through it; each delegates to the
+// underlying writer, or reports http.ErrNotSupported when it lacks one.
 type statusRecorder struct {
 	http.ResponseWriter
-	statusCode int
+	statusCode  int
+	wroteHeader bool
+	// headerAt is when the header was written, explicitly or by the first
+	// Write.
+	headerAt time.Time
+	// rateLimited is set by rateLimitMiddleware when it sent the response.
+	rateLimited bool
 }
 
+// WriteHeader records code unless the header is already out, in which case
+// net/http ignores it too.
 func (r *statusRecorder) WriteHeader(code int) {
-	r.statusCode = code
+	if !r.wroteHeader {
+		r.statusCode = code
+		r.wroteHeader = true
+		r.headerAt = time.Now()
+	}
 	r.ResponseWriter.WriteHeader(code)
 }
 
-// Live response format
-func liveHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set(headerContentType, contentTypeJSON)
-	w.WriteHeader(http.StatusOK)
-	if _, err := w.Write([]byte(`{"status":"ok"}`)); err != nil {
-		slog.Error(errWriteResponse, "error", err)
+// markWritten records the implicit 200 that net/http sends when the body is
+// written, or flushed, before WriteHeader.
+func (r *statusRecorder) markWritten() {
+	if !r.wroteHeader {
+		r.statusCode = http.StatusOK
+		r.wroteHeader = true
+		r.headerAt = time.Now()
 	}
 }
 
-// Ready response evaluates Postgres DB
-func readyHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set(headerContentType, contentTypeJSON)
-	dbMu.RLock()
-	d := db
-	dbMu.RUnlock()
-	if d == nil {
-		w.WriteHeader(http.StatusServiceUnavailable)
-		if _, err := w.Write([]byte(`{"status":"error","message":"db not configured"}`)); err != nil {
-			slog.Error(errWriteResponse, "error", err)
-		}
-		return
+// ttfb is the time from start to the header of a request that ended at end.
+// A handler that wrote nothing has its header sent as it returns, so that
+// counts as end.
+func (r *statusRecorder) ttfb(start, end time.Time) time.Duration {
+	if r.headerAt.IsZero() {
+		return end.Sub(start)
 	}
-	if err := d.Ping(); err != nil {
-		w.WriteHeader(http.StatusServiceUnavailable)
-		if _, err := w.Write([]byte(`{"status":"error","message":"db unreachable"}`)); err != nil {
-			slog.Error(errWriteResponse, "error", err)
-		}
-		return
+	return r.headerAt.Sub(start)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	r.markWritten()
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		r.markWritten()
+		f.Flush()
 	}
+}
 
-	w.WriteHeader(http.StatusOK)
-	if _, err := w.Write([]byte(`{"status":"ready"}`)); err != nil {
-		slog.Error(errWriteResponse, "error", err)
+// Hijack hands the connection over, for a WebSocket upgrade say. The status
+// is left as recorded since the handler now owns the connection.
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := r.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
 	}
+	return nil, nil, http.ErrNotSupported
 }
 
-// PublicResponse represents the JSON response for the public time endpoint.
-type PublicResponse struct {
-	Status    string `json:"status"`
-	Timestamp string `json:"timestamp"`
-	Env       string `json:"env"`
+// ReadFrom keeps net/http's sendfile path for handlers that io.Copy a file.
+func (r *statusRecorder) ReadFrom(src io.Reader) (int64, error) {
+	r.markWritten()
+	if rf, ok := r.ResponseWriter.(io.ReaderFrom); ok {
+		return rf.ReadFrom(src)
+	}
+	// Hide ReadFrom from io.Copy so it doesn't call back into this method.
+	return io.Copy(struct{ io.Writer }{r.ResponseWriter}, src)
 }
 
-func publicHandler(env string) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set(headerContentType, contentTypeJSON)
-		w.WriteHeader(http.StatusOK)
-		resp := PublicResponse{
-			Status:    "ok",
-			Timestamp: time.Now().UTC().Format(time.RFC3339),
-			Env:       env,
-		}
-		if err := json.NewEncoder(w).Encode(resp); err != nil {
-			slog.Error(errWriteResponse, "error", err)
-		}
+func (r *statusRecorder) Push(target string, opts *http.PushOptions) error {
+	if p, ok := r.ResponseWriter.(http.Pusher); ok {
+		return p.Push(target, opts)
 	}
+	return http.ErrNotSupported
 }
 
-func getEnvOrDefault(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Live response format
+func liveHandler(w http.ResponseWriter, r *http.Request) {
+	writeLive(w, time.Now())
+}
+
+// started flips to true once main has finished initialization and never
+// goes back, so /startup doesn't flap with dependency health like /ready.
+var started atomic.Bool
+
+func startupHandler(w http.ResponseWriter, r *http.Request) {
+	if !started.Load() {
+		writeJSONBody(w, http.StatusServiceUnavailable, startupStarting)
+		return
+	}
+	writeJSONBody(w, http.StatusOK, startupStarted)
+}
+
+// readiness caches /ready results; main sets its ttl from READY_CACHE_TTL.
+var readiness readyCache
+
+// Ready response evaluates Postgres DB
+func readyHandler(w http.ResponseWriter, r *http.Request) {
+	if draining.Load() {
+		writeJSON(w, http.StatusServiceUnavailable, readyResponse{Status: "draining", Draining: true, CheckedAt: time.Now().UTC().Format(time.RFC3339)})
+		return
 	}
-	return defaultValue
+	res := readiness.get(r.Context(), checkReady)
+	if res.body != nil {
+		writeJSONBody(w, res.code, res.body)
+		return
+	}
+	writeJSON(w, res.code, res.response())
 }
 
-func getRateLimit() int {
-	rateLimit := 100
-	if rlStr := os.Getenv("RATE_LIMIT"); rlStr != "" {
-		if rl, err := strconv.Atoi(rlStr); err == nil && rl > 0 {
-			rateLimit = rl
+// newInternalMux registers the health, version, stats and metrics routes
+// served on PORT, and the admin routes unless ADMIN_PORT moves them to the
+// admin server; /metrics serves gatherer, /healthz reports uptime since
+// startedAt and /admin/logs counts deletions on m.
+func newInternalMux(gatherer prometheus.Gatherer, m *metrics, startedAt time.Time) *router {
+	rt := newRouter()
+	registerRoute(rt, routeLive, methods(liveHandler, http.MethodGet, http.MethodHead))
+	registerRoute(rt, routeReady, methods(readyHandler, http.MethodGet, http.MethodHead))
+	registerRoute(rt, routeStartup, methods(startupHandler, http.MethodGet, http.MethodHead))
+	registerRoute(rt, routeHealthz, methods(healthzHandler(startedAt), http.MethodGet, http.MethodHead))
+	registerRoute(rt, routeVersion, methods(withETag(routeVersion, versionHandler), http.MethodGet, http.MethodHead))
+	registerRoute(rt, routeStats, methods(statsHandler, http.MethodGet, http.MethodHead))
+	registerRoute(rt, routeStatsClients, methods(clientStatsHandler, http.MethodGet, http.MethodHead))
+	registerRoute(rt, routeLogsExport, methods(adminHandler(exportLogsHandler), http.MethodGet))
+	registerRoute(rt, routeEvents, methods(eventsHandler, http.MethodGet, http.MethodHead))
+	registerRoute(rt, routeOpenAPI, methods(withETag(routeOpenAPI, openAPIHandler(false)), http.MethodGet, http.MethodHead))
+	registerRoute(rt, routeMetrics, methods(requireMetricsAuth(newMetricsHandler(gatherer).ServeHTTP), http.MethodGet, http.MethodHead))
+	if !adminServerEnabled {
+		registerAdminRoutes(rt, m)
+		if pprofEnabled {
+			registerPprof(rt)
 		}
 	}
-	return rateLimit
+	return rt
+}
+
+// newAdminMux registers the admin routes served on ADMIN_PORT; /admin/logs
+// counts deletions on m.
+func newAdminMux(m *metrics) *router {
+	rt := newRouter()
+	registerAdminRoutes(rt, m)
+	return rt
+}
+
+// registerAdminRoutes registers the /admin/* routes on rt.
+func registerAdminRoutes(rt *router, m *metrics) {
+	registerRoute(rt, routeAdminLogs, methods(adminHandler(purgeLogsHandler(m)), http.MethodDelete))
+	registerRoute(rt, routeAdminLogLevel, methods(adminHandler(logLevelHandler), http.MethodGet, http.MethodHead, http.MethodPut))
+	registerRoute(rt, routeAdminRateLimit, methods(adminHandler(rateLimitHandler), http.MethodGet, http.MethodHead))
+	registerRoute(rt, routeAdminRateLimitReset, methods(adminHandler(rateLimitResetHandler), http.MethodPost))
+	registerRoute(rt, routeAdminConfig, methods(adminHandler(configHandler), http.MethodGet, http.MethodHead))
+}
+
+// newPublicMux registers the routes served on PUBLIC_PORT.
+func newPublicMux(env string) *router {
+	rt := newRouter()
+	registerRoute(rt, routePublic, methods(withTimeCache(timeCacheResolution, publicHandler(env)), http.MethodGet, http.MethodHead))
+	registerRoute(rt, routeOpenAPI, methods(withETag(routeOpenAPI, openAPIHandler(true)), http.MethodGet, http.MethodHead))
+	return rt
 }
 
-func setupDatabase(dsn string) (*sql.DB, error) {
-	d, err := connectWithRetry(dsn, 5, 1*time.Second)
+func setupDatabase(ctx context.Context, dsn string, m *metrics) (*sql.DB, error) {
+	d, err := connectWithRetry(ctx, dsn, 5, 1*time.Second, m)
 	if err != nil {
 		return nil, err
 	}
 	dbMu.Lock()
 	db = d
 	dbMu.Unlock()
+	dbConnected.Store(true)
 	slog.Info("connected to postgres successfully")
 	return d, nil
 }
 
+// serverWriteTimeout caps how long a response may take; timeoutHandler
+// extends it for routes allowed to run longer.
+const serverWriteTimeout = 10 * time.Second
+
 func newHTTPServer(addr string, handler http.Handler) *http.Server {
 	return &http.Server{
 		Addr:              addr,
 		Handler:           handler,
 		ReadTimeout:       10 * time.Second,
 		ReadHeaderTimeout: 5 * time.Second,
-		WriteTimeout:      10 * time.Second,
+		WriteTimeout:      serverWriteTimeout,
 		IdleTimeout:       60 * time.Second,
 	}
 }
 
 func main() {
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
-		Level: slog.LevelInfo,
-	}))
-	slog.SetDefault(logger)
-
-	port := getEnvOrDefault("PORT", "8080")
-	publicPort := getEnvOrDefault("PUBLIC_PORT", "8090")
-	env := getEnvOrDefault("APP_ENV", "development")
-
-	if dsn := os.Getenv("DB_DSN"); dsn != "" {
-		d, err := setupDatabase(dsn)
+	startedAt := time.Now()
+	slog.SetDefault(slog.New(newLogHandler(os.Stdout, logFormatJSON, false)))
+
+	cfg, err := LoadConfig(os.Args[1:])
+	switch {
+	case errors.Is(err, flag.ErrHelp):
+		os.Exit(0)
+	case errors.Is(err, errVersion):
+		fmt.Println(buildInfo())
+		os.Exit(0)
+	}
+	logLevel.Set(cfg.LogLevel)
+	closeLog := setupLogger(cfg)
+	defer closeLog()
+	switch {
+	case errors.Is(err, errValidateOnly):
+		slog.Info("configuration valid", "config", cfg)
+		os.Exit(0)
+	case errors.Is(err, errMigrateOnly):
+		addLogSecret(cfg.DSN)
+		d, err := initDB(context.Background(), cfg.DSN)
 		if err != nil {
-			slog.Error("failed to connect to database", "error", err)
+			slog.Error("migration failed", "error", redactDSNError(err, cfg.DSN))
 			os.Exit(1)
 		}
-		defer func() {
-			if err := d.Close(); err != nil {
-				slog.Error("error closing db", "error", err)
+		_ = d.Close()
+		slog.Info("database schema is up to date", "migrations", len(migrations))
+		os.Exit(0)
+	case err != nil:
+		slog.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	slog.Info("configuration loaded", "config", cfg)
+
+	port := strconv.Itoa(cfg.Port)
+	publicPort := strconv.Itoa(cfg.PublicPort)
+	env := cfg.Env
+	serviceName = cfg.ServiceName
+	readyPingTimeout = cfg.ReadyPingTimeout
+	statsQueryTimeout = cfg.StatsQueryTimeout
+	readiness.ttl = cfg.ReadyCacheTTL
+	m := newMetrics(prometheus.DefaultRegisterer, cfg.DurationBuckets)
+	slo = sloConfig{objectives: cfg.SLORoutes, countRateLimited: cfg.SLOCountRateLimited}
+	slo.register(m)
+	requestTimeouts = cfg.requestTimeouts()
+	slowRequestThresholds = cfg.slowRequestThresholds()
+	cacheMaxAges = cfg.cacheMaxAges()
+	timeCacheResolution = cfg.TimeCacheResolution
+	trustedProxies = cfg.TrustedProxies
+	healthzStatusCodes["degraded"] = cfg.HealthzDegradedStatus
+	healthzStatusCodes["error"] = cfg.HealthzErrorStatus
+	adminToken = cfg.AdminToken
+	metricsAuth = cfg.MetricsAuth
+	dbRequired = cfg.DBRequired
+	dbPool = cfg.DBPool
+	pprofEnabled = cfg.EnablePprof
+	adminServerEnabled = cfg.AdminPort != 0
+	faults = newFaultInjector(cfg.FaultInjection, cfg.Env, cfg.FaultDelayP50, cfg.FaultErrorRate)
+	accessLog = accessLogFormatters[cfg.AccessLogFormat]
+	debugLog = debugLogSampling{rate: cfg.DebugLogSample, prefix: cfg.DebugLogMatch}
+	singlePort := cfg.SinglePort
+	internalPrefix := cfg.InternalPrefix
+
+	res := newServiceResource(serviceName, env)
+	var flushTraces, flushMetrics func(context.Context) error
+	if endpoint := cfg.OTLPEndpoint; endpoint != "" {
+		flushTraces, err = setupTracing(context.Background(), endpoint, res)
+		if err != nil {
+			slog.Error("failed to set up tracing", "error", err)
+			os.Exit(1)
+		}
+		slog.Info("tracing enabled", "endpoint", endpoint)
+	}
+	if endpoint := cfg.OTLPMetricsEndpoint; endpoint != "" {
+		flushMetrics, err = setupOTLPMetrics(context.Background(), endpoint, cfg.OTLPMetricsInterval, res, prometheus.DefaultGatherer, m.otlpPushFailures)
+		if err != nil {
+			slog.Error("failed to set up the OTLP metrics push", "error", err)
+			os.Exit(1)
+		}
+		slog.Info("OTLP metrics push enabled", "endpoint", endpoint, "interval", cfg.OTLPMetricsInterval.String())
+	}
+	if addr := cfg.StatsdAddr; addr != "" {
+		tags := append([]string{statsdTag("service", serviceName), statsdTag("env", env)}, cfg.StatsdTags...)
+		m.statsd, err = newStatsdClient(addr, cfg.StatsdPrefix, tags, m.statsdFailures)
+		if err != nil {
+			slog.Error("failed to set up the statsd emitter", "error", err)
+			os.Exit(1)
+		}
+		slog.Info("statsd emitter enabled", "addr", addr, "prefix", cfg.StatsdPrefix)
+	}
+	var closeReports func(context.Context) error
+	if url := cfg.ErrorWebhookURL; url != "" {
+		reporter := newWebhookReporter(url, serviceName, env, m.errorReports)
+		go reporter.run()
+		errorReporter = reporter
+		closeReports = reporter.Close
+		slog.Info("error reporting enabled")
+	}
+
+	// Listen for shutdown signals before connecting: one that arrives while
+	// the initial connect is still retrying cancels connectCtx and exits.
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	connectCtx, stopConnect := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stopConnect()
+
+	bgCtx, bgCancel := context.WithCancel(context.Background())
+	defer bgCancel()
+	var sink LogSink = sqlLogSink{}
+	closeNative := func() {}
+	var src *dsnSource
+	if dsn := cfg.DSN; dsn != "" {
+		addLogSecret(dsn)
+		src = &dsnSource{dsn: dsn, connect: func(dsn string) (*sql.DB, error) {
+			return connectWithRetry(bgCtx, dsn, 5, 1*time.Second, m)
+		}}
+		_, err := setupDatabase(connectCtx, dsn, m)
+		if connectCtx.Err() != nil {
+			slog.Info("shutdown signal received while connecting to the database, exiting")
+			return
+		}
+		if err != nil {
+			slog.Error("failed to connect to database, serving without it and retrying in the background", "error", err)
+			connectLater(bgCtx, func() (*sql.DB, error) {
+				d, err := openDB(bgCtx, src.current())
+				if err != nil {
+					m.dbConnectRetries.Inc()
+				}
+				return d, err
+			}, 1*time.Second)
+		}
+		if cfg.DBDriver == dbDriverPgxNative {
+			native, err := newPgxLogSink(context.Background(), dsn, dbPool)
+			if err != nil {
+				slog.Error("failed to open the native database pool", "error", err)
+				os.Exit(1)
 			}
-		}()
+			closeNative = native.Close
+			poolStats := newPgxPoolCollector(native)
+			registerOrReuse(prometheus.DefaultRegisterer, &poolStats)
+			sink = native
+			src.reloaded = func(dsn string) error {
+				return native.connect(context.Background(), dsn)
+			}
+		}
 	} else {
 		slog.Warn("DB_DSN not set, running without database logging")
 	}
+	if src != nil && cfg.DBKeepaliveInterval > 0 {
+		keepalive := &dbKeepalive{
+			interval: cfg.DBKeepaliveInterval,
+			connect: func(ctx context.Context) (*sql.DB, error) {
+				return openDB(ctx, src.current())
+			},
+			reconnects: m.dbReconnects,
+		}
+		go keepalive.run(bgCtx)
+	}
+	if dsn := cfg.ReadDSN; dsn != "" {
+		addLogSecret(dsn)
+		d, err := openReadDB(connectCtx, dsn)
+		if err != nil {
+			slog.Error("failed to open the read replica pool", "error", err)
+			os.Exit(1)
+		}
+		dbMu.Lock()
+		readDB = d
+		dbMu.Unlock()
+		slog.Info("stats and export queries use the read replica", "db_read_dsn", redactDSN(dsn))
+	}
+	stopConnect()
 
 	logCtx, logCancel := context.WithCancel(context.Background())
 	defer logCancel()
-	startLogFlusher(logCtx, 1024)
-
-	limiter := rate.NewLimiter(rate.Limit(getRateLimit()), getRateLimit())
-
-	mux := http.NewServeMux()
-	mux.HandleFunc(routeLive, liveHandler)
-	mux.HandleFunc(routeReady, readyHandler)
-	mux.Handle(routeMetrics, promhttp.Handler())
+	startLogFlusher(logCtx, 1024, sink, m)
+	recordEvent(eventStarted, map[string]any{"version": version, "commit": commit, "env": env})
+	if cfg.ReadyCheckWrite {
+		readyChecks = append(readyChecks, readinessCheck{Checker: dbWriteChecker{}, required: cfg.DBRequired})
+	}
+	if cfg.ReadDSN != "" {
+		readyChecks = append(readyChecks, readinessCheck{Checker: replicaChecker{}})
+	}
+	readyChecks = append(readyChecks, readinessCheck{Checker: logPipelineChecker{}, required: cfg.LogPipelineRequired})
+	if url := cfg.ReadinessWebhookURL; url != "" {
+		go newReadinessWatcher(url, serviceName, env, cfg.ReadinessInterval, m.readyNotifications).Run(bgCtx)
+	}
 
-	server := newHTTPServer(":"+port, rateLimitMiddleware(limiter)(metricsMiddleware(mux)))
+	apiLimiter = cfg.RateLimit.newLimiter()
+	slog.Info("rate limit", "limit", cfg.RateLimit.String(), "per_second", float64(apiLimiter.limit), "burst", apiLimiter.burst)
 
-	publicMux := http.NewServeMux()
-	publicMux.HandleFunc(routePublic, publicHandler(env))
-	publicServer := newHTTPServer(":"+publicPort, publicMux)
+	reloader := &configReloader{
+		load:     func() (Config, error) { return LoadConfig(os.Args[1:]) },
+		settings: reloadableSettings(apiLimiter, src),
+		running:  cfg,
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go reloader.watch(hup)
+	currentConfig = reloader.current
+
+	internalMux := newInternalMux(prometheus.DefaultGatherer, m, startedAt)
+	publicMux := newPublicMux(env)
+	routers := []*router{internalMux, publicMux}
+	var adminMux *router
+	if adminServerEnabled {
+		adminMux = newAdminMux(m)
+		routers = append(routers, adminMux)
+	}
+	if err := checkRoutes(cfg, routers...); err != nil {
+		slog.Error("invalid route registration", "error", err)
+		os.Exit(1)
+	}
+	internalHandler := recoverMiddleware(m, internalMux, traceparentMiddleware(m.traceparentInvalid, tracingMiddleware(internalMux, metricsMiddleware(m, internalMux, rateLimitMiddleware(apiLimiter, m, internalMux)))))
+	publicHandler := recoverMiddleware(m, publicMux, traceparentMiddleware(m.traceparentInvalid, tracingMiddleware(publicMux, metricsMiddleware(m, publicMux))))
 
-	go func() {
-		slog.Info("internal api server starting", "port", port, "env", env)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			slog.Error("internal server failed to start", "error", err)
-			os.Exit(1)
+	servers := map[string]*http.Server{
+		"internal": newHTTPServer(":"+port, internalHandler),
+		"public":   newHTTPServer(":"+publicPort, publicHandler),
+	}
+	if singlePort {
+		servers = map[string]*http.Server{
+			"single": newHTTPServer(":"+port, newSinglePortHandler(internalPrefix, internalHandler, publicHandler)),
 		}
-	}()
+		slog.Info("single-port mode: internal routes served under prefix", "port", port, "internal_prefix", internalPrefix)
+	} else {
+		slog.Info("two-port mode", "port", port, "public_port", publicPort)
+	}
+	if adminMux != nil {
+		servers["admin"] = newAdminServer(cfg.AdminPort, cfg.AdminToken, recoverMiddleware(m, adminMux, adminMux), cfg.EnablePprof)
+		slog.Info("admin routes served on their own port", "admin_port", cfg.AdminPort, "pprof", cfg.EnablePprof)
+	}
 
-	go func() {
-		slog.Info("public api server starting", "port", publicPort, "env", env)
-		if err := publicServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			slog.Error("public server failed to start", "error", err)
+	// Bind every port up front so /startup only reports success once the
+	// servers are actually listening.
+	listeners := make(map[string]net.Listener, len(servers))
+	for name, srv := range servers {
+		ln, err := net.Listen("tcp", srv.Addr)
+		if err != nil {
+			slog.Error("server failed to start", "server", name, "error", err)
 			os.Exit(1)
 		}
-	}()
-
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	sig := <-quit
-	slog.Info("shutdown signal received", "signal", sig.String())
-
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
-
-	if err := server.Shutdown(ctx); err != nil {
-		slog.Error("internal server forced to shutdown", "error", err)
+		listeners[name] = ln
 	}
-	if err := publicServer.Shutdown(ctx); err != nil {
-		slog.Error("public server forced to shutdown", "error", err)
+
+	for name, srv := range servers {
+		go func() {
+			slog.Info("api server starting", "server", name, "addr", srv.Addr, "env", env, "version", version, "commit", commit)
+			if err := srv.Serve(listeners[name]); err != nil && err != http.ErrServerClosed {
+				slog.Error("server failed", "server", name, "error", err)
+				os.Exit(1)
+			}
+		}()
 	}
 
-	logCancel()
-	slog.Info("servers stopped gracefully")
+	started.Store(true)
+
+	shutdownSequence{
+		drainDelay: cfg.ShutdownDrainDelay,
+		timeout:    cfg.ShutdownTimeout,
+		servers:    servers,
+		stopLogs:   logCancel,
+		logsDone:   flusher.done,
+		closeDB: func() error {
+			bgCancel() // stop a background connect before closing the pools
+			closeNative()
+			// The pool may have been connected late or swapped by a
+			// DB_DSN_FILE reload, so close whichever is current.
+			var errs []error
+			if d := WriteDB(); d != nil {
+				errs = append(errs, d.Close())
+			}
+			if readDB != nil {
+				errs = append(errs, readDB.Close())
+			}
+			return errors.Join(errs...)
+		},
+		closeReports: closeReports,
+		flushMetrics: flushMetrics,
+		flushTraces:  flushTraces,
+	}.run(quit)
 }
@@ -4,12 +4,15 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"golang.org/x/time/rate"
 )
 
@@ -23,9 +26,15 @@ func TestLiveHandler_ReturnsOK(t *testing.T) {
 		t.Errorf("expected status 200, got %d", rec.Code)
 	}
 
-	expectedBody := `{"status":"ok"}`
-	if rec.Body.String() != expectedBody {
-		t.Errorf("expected body '%s', got '%s'", expectedBody, rec.Body.String())
+	var resp HealthResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if resp.Status != "ok" || resp.Service != "api" || resp.Version != "dev" {
+		t.Errorf("unexpected health response %+v", resp)
+	}
+	if _, err := time.Parse(time.RFC3339, resp.Timestamp); err != nil {
+		t.Errorf("expected RFC3339 timestamp, got %q", resp.Timestamp)
 	}
 
 	ct := rec.Header().Get("Content-Type")
@@ -61,13 +70,14 @@ func TestServer_GracefulShutdown(t *testing.T) {
 
 	// Initialize log buffer for test
 	logCtx, logCancel := context.WithCancel(context.Background())
-	startLogFlusher(logCtx, 64)
+	m, _ := newTestMetrics(t)
+	startLogFlusher(logCtx, 64, sqlLogSink{}, m)
 
-	mux := http.NewServeMux()
-	mux.HandleFunc(routeLive, liveHandler)
-	mux.HandleFunc(routeReady, readyHandler)
+	rt := newRouter()
+	registerRoute(rt, routeLive, liveHandler)
+	registerRoute(rt, routeReady, readyHandler)
 
-	handler := metricsMiddleware(mux)
+	handler := metricsMiddleware(m, rt)
 
 	server := &http.Server{
 		Addr:              ":8888",
@@ -110,7 +120,7 @@ func TestServer_GracefulShutdown(t *testing.T) {
 }
 
 func TestInitDB_InvalidDSN(t *testing.T) {
-	_, err := initDB("invalid-dsn-format")
+	_, err := initDB(context.Background(), "invalid-dsn-format")
 	if err == nil {
 		t.Error("expected error for invalid DSN, got nil")
 	}
@@ -152,6 +162,66 @@ func TestReadyHandler_DBPingError(t *testing.T) {
 	}
 }
 
+func TestStartupHandler_DoesNotRegress(t *testing.T) {
+	started.Store(false)
+	defer started.Store(false)
+
+	rec := httptest.NewRecorder()
+	startupHandler(rec, httptest.NewRequest(http.MethodGet, "/startup", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 before initialization, got %d", rec.Code)
+	}
+
+	mockDB, mock, _ := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	defer func() { _ = mockDB.Close() }()
+	dbMu.Lock()
+	db = mockDB
+	dbMu.Unlock()
+	mock.ExpectPing().WillReturnError(errors.New("db down"))
+
+	started.Store(true)
+
+	rec = httptest.NewRecorder()
+	readyHandler(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected /ready 503 with a failing db, got %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	startupHandler(rec, httptest.NewRequest(http.MethodGet, "/startup", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected /startup to stay 200 after db failures, got %d", rec.Code)
+	}
+}
+
+func TestReadyHandler_DBPingTimeout(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	defer func() { _ = mockDB.Close() }()
+
+	dbMu.Lock()
+	db = mockDB
+	dbMu.Unlock()
+	mock.ExpectPing().WillDelayFor(5 * time.Second)
+
+	prev := readyPingTimeout
+	readyPingTimeout = 50 * time.Millisecond
+	defer func() { readyPingTimeout = prev }()
+
+	req := httptest.NewRequest(http.MethodGet, "/ready", nil)
+	rec := httptest.NewRecorder()
+	start := time.Now()
+	readyHandler(rec, req)
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected handler to return within the ping budget, took %v", elapsed)
+	}
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503, got %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "db ping timeout") {
+		t.Errorf("expected ping timeout message, got %s", rec.Body.String())
+	}
+}
+
 func TestMetricsMiddleware_WithDB(t *testing.T) {
 	mockDB, mock, _ := sqlmock.New()
 	defer func() { _ = mockDB.Close() }()
@@ -164,12 +234,15 @@ func TestMetricsMiddleware_WithDB(t *testing.T) {
 
 	// Set up async log buffer for the test
 	logCtx, logCancel := context.WithCancel(context.Background())
-	startLogFlusher(logCtx, 64)
+	m, reg := newTestMetrics(t)
+	startLogFlusher(logCtx, 64, sqlLogSink{}, m)
 	defer logCancel()
 
-	handler := metricsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	rt := newRouter()
+	registerRoute(rt, "/test", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
-	}))
+	})
+	handler := metricsMiddleware(m, rt)
 
 	req := httptest.NewRequest(http.MethodGet, "/test", nil)
 	rec := httptest.NewRecorder()
@@ -181,6 +254,15 @@ func TestMetricsMiddleware_WithDB(t *testing.T) {
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("unfulfilled mock: %s", err)
 	}
+	if got := histogramCount(t, reg, "log_flush_duration_seconds"); got != 1 {
+		t.Errorf("expected one flush duration observation, got %d", got)
+	}
+	if got := histogramCount(t, reg, "log_flush_batch_size"); got != 1 {
+		t.Errorf("expected one flush batch size observation, got %d", got)
+	}
+	if got := testutil.ToFloat64(m.logFlushErrors); got != 0 {
+		t.Errorf("expected no flush errors, got %v", got)
+	}
 }
 
 func TestMetricsMiddleware_DBError(t *testing.T) {
@@ -194,12 +276,15 @@ func TestMetricsMiddleware_DBError(t *testing.T) {
 	mock.ExpectExec("INSERT INTO api_logs").WillReturnError(errors.New("insert temp error"))
 
 	logCtx, logCancel := context.WithCancel(context.Background())
-	startLogFlusher(logCtx, 64)
+	m, reg := newTestMetrics(t)
+	startLogFlusher(logCtx, 64, sqlLogSink{}, m)
 	defer logCancel()
 
-	handler := metricsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	rt := newRouter()
+	registerRoute(rt, "/test", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
-	}))
+	})
+	handler := metricsMiddleware(m, rt)
 
 	req := httptest.NewRequest(http.MethodGet, "/test", nil)
 	rec := httptest.NewRecorder()
@@ -211,6 +296,12 @@ func TestMetricsMiddleware_DBError(t *testing.T) {
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("unfulfilled mock: %s", err)
 	}
+	if got := testutil.ToFloat64(m.logFlushErrors); got != 1 {
+		t.Errorf("expected one flush error, got %v", got)
+	}
+	if got := histogramCount(t, reg, "log_flush_duration_seconds"); got != 1 {
+		t.Errorf("expected failed flush to be timed, got %d", got)
+	}
 }
 
 type errorResponseWriter struct {
@@ -258,15 +349,54 @@ func waitForServer(t *testing.T, url string) {
 }
 
 func TestConnectWithRetry_InvalidDSN(t *testing.T) {
-	_, err := connectWithRetry("invalid-dsn", 2, 10*time.Millisecond)
+	m, _ := newTestMetrics(t)
+	_, err := connectWithRetry(context.Background(), "invalid-dsn", 2, 10*time.Millisecond, m)
 	if err == nil {
 		t.Error("expected error for invalid DSN, got nil")
 	}
+	if got := testutil.ToFloat64(m.dbConnectRetries); got != 2 {
+		t.Errorf("expected 2 retries counted, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.dbConnectFailures); got != 1 {
+		t.Errorf("expected 1 exhausted connect counted, got %v", got)
+	}
+}
+
+func TestConnectWithRetry_Cancelled(t *testing.T) {
+	m, _ := newTestMetrics(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	// An unreachable address keeps every attempt failing, and the delay
+	// would make the retries take far longer than the test allows.
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	_, err := connectWithRetry(ctx, "postgres://app@127.0.0.1:1/app?connect_timeout=1", 5, time.Minute, m)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected the backoff cut short, took %v", elapsed)
+	}
+	if got := testutil.ToFloat64(m.dbConnectFailures); got != 0 {
+		t.Errorf("expected an abandoned connect not counted as exhausted, got %v", got)
+	}
+
+	// Already cancelled: no attempt is retried at all.
+	start = time.Now()
+	if _, err := connectWithRetry(ctx, "postgres://app@127.0.0.1:1/app", 5, time.Minute, m); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected a prompt return, took %v", elapsed)
+	}
+	if got := testutil.ToFloat64(m.dbConnectRetries); got != 1 {
+		t.Errorf("expected only the first attempt counted as a retry, got %v", got)
+	}
 }
 
 func TestRateLimitMiddleware_Allows(t *testing.T) {
-	limiter := rate.NewLimiter(rate.Limit(100), 100)
-	handler := rateLimitMiddleware(limiter)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	limiter := newRateLimiter(rate.Limit(100), 100)
+	m, _ := newTestMetrics(t)
+	handler := rateLimitMiddleware(limiter, m, newRouter())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 	}))
 
@@ -316,48 +446,6 @@ func TestPublicHandler_ReturnsOK(t *testing.T) {
 	}
 }
 
-func TestGetEnvOrDefault(t *testing.T) {
-	t.Setenv("TEST_API_ENV_VAR", "custom-value")
-	if v := getEnvOrDefault("TEST_API_ENV_VAR", "default"); v != "custom-value" {
-		t.Errorf("expected 'custom-value', got '%s'", v)
-	}
-	if v := getEnvOrDefault("TEST_API_UNSET_VAR", "fallback"); v != "fallback" {
-		t.Errorf("expected 'fallback', got '%s'", v)
-	}
-}
-
-func TestGetRateLimit_Default(t *testing.T) {
-	t.Setenv("RATE_LIMIT", "")
-	rl := getRateLimit()
-	if rl != 100 {
-		t.Errorf("expected 100, got %d", rl)
-	}
-}
-
-func TestGetRateLimit_Custom(t *testing.T) {
-	t.Setenv("RATE_LIMIT", "50")
-	rl := getRateLimit()
-	if rl != 50 {
-		t.Errorf("expected 50, got %d", rl)
-	}
-}
-
-func TestGetRateLimit_Invalid(t *testing.T) {
-	t.Setenv("RATE_LIMIT", "not-a-number")
-	rl := getRateLimit()
-	if rl != 100 {
-		t.Errorf("expected default 100 for invalid input, got %d", rl)
-	}
-}
-
-func TestGetRateLimit_Zero(t *testing.T) {
-	t.Setenv("RATE_LIMIT", "0")
-	rl := getRateLimit()
-	if rl != 100 {
-		t.Errorf("expected default 100 for zero input, got %d", rl)
-	}
-}
-
 func TestNewHTTPServer(t *testing.T) {
 	handler := http.NewServeMux()
 	srv := newHTTPServer(":9999", handler)
@@ -373,7 +461,8 @@ func TestNewHTTPServer(t *testing.T) {
 }
 
 func TestSetupDatabase_InvalidDSN(t *testing.T) {
-	_, err := setupDatabase("invalid-dsn")
+	m, _ := newTestMetrics(t)
+	_, err := setupDatabase(context.Background(), "invalid-dsn", m)
 	if err == nil {
 		t.Error("expected error for invalid DSN, got nil")
 	}
@@ -384,45 +473,188 @@ func TestFlushLog_NilDB(t *testing.T) {
 	db = nil
 	dbMu.Unlock()
 	// Should not panic
-	flushLog(logEntry{method: "GET", endpoint: "/test", status: 200, durationMs: 1.0, remoteAddr: "127.0.0.1"})
+	m, _ := newTestMetrics(t)
+	flushLog(sqlLogSink{}, logEntry{method: "GET", endpoint: "/test", status: 200, durationMs: 1.0, remoteAddr: "127.0.0.1"}, m)
 }
 
-func TestRoutePattern_Known(t *testing.T) {
-	if r := routePattern("/live"); r != "/live" {
-		t.Errorf("expected '/live', got '%s'", r)
+func TestStatusRecorder_WriteHeader(t *testing.T) {
+	rec := httptest.NewRecorder()
+	sr := &statusRecorder{ResponseWriter: rec, statusCode: http.StatusOK}
+	sr.WriteHeader(http.StatusNotFound)
+	if sr.statusCode != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", sr.statusCode)
 	}
-	if r := routePattern("/api/v1/time"); r != "/api/v1/time" {
-		t.Errorf("expected '/api/v1/time', got '%s'", r)
+}
+
+func TestStatusRecorder_ImplicitOK(t *testing.T) {
+	rec := httptest.NewRecorder()
+	sr := &statusRecorder{ResponseWriter: rec, statusCode: http.StatusTeapot}
+	_, _ = sr.Write([]byte("hello"))
+	// Too late: the 200 is already out, so net/http ignores this.
+	sr.WriteHeader(http.StatusInternalServerError)
+	if sr.statusCode != http.StatusOK || !sr.wroteHeader {
+		t.Errorf("expected the implicit 200 recorded, got %d", sr.statusCode)
+	}
+
+	sr = &statusRecorder{ResponseWriter: httptest.NewRecorder(), statusCode: http.StatusTeapot}
+	if n, err := sr.ReadFrom(strings.NewReader("streamed")); err != nil || n != 8 {
+		t.Fatalf("expected 8 bytes copied, got %d, %v", n, err)
+	}
+	if sr.statusCode != http.StatusOK || sr.ResponseWriter.(*httptest.ResponseRecorder).Body.String() != "streamed" {
+		t.Errorf("expected the body and an implicit 200, got %d", sr.statusCode)
 	}
 }
 
-func TestRoutePattern_Unknown(t *testing.T) {
-	if r := routePattern("/unknown"); r != "/other" {
-		t.Errorf("expected '/other', got '%s'", r)
+func TestMetricsMiddleware_TTFB(t *testing.T) {
+	logs := captureLogs(t)
+	m, _ := newTestMetrics(t)
+	rt := newRouter()
+	const pause = 60 * time.Millisecond
+	registerRoute(rt, "/split", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(pause)
+		w.WriteHeader(http.StatusOK)
+		time.Sleep(pause)
+		_, _ = w.Write([]byte("late body"))
+	})
+	registerRoute(rt, "/implicit", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(pause)
+		_, _ = w.Write([]byte("first chunk"))
+		time.Sleep(pause)
+	})
+	registerRoute(rt, "/empty", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(pause)
+	})
+	handler := metricsMiddleware(m, rt)
+	for _, path := range []string{"/split", "/implicit", "/empty"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	type timing struct {
+		Path       string  `json:"path"`
+		DurationMs float64 `json:"duration_ms"`
+		TTFBMs     float64 `json:"ttfb_ms"`
+	}
+	got := map[string]timing{}
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var rec timing
+		if strings.Contains(line, `"msg":"request completed"`) && json.Unmarshal([]byte(line), &rec) == nil {
+			got[rec.Path] = rec
+		}
+	}
+	pauseMs := float64(pause.Milliseconds())
+	for _, path := range []string{"/split", "/implicit"} {
+		tm := got[path]
+		if tm.TTFBMs < pauseMs || tm.DurationMs < 2*pauseMs || tm.DurationMs-tm.TTFBMs < pauseMs {
+			t.Errorf("%s: expected the header after one pause and the body after two, got %+v", path, tm)
+		}
+	}
+	if tm := got["/empty"]; tm.TTFBMs < pauseMs || tm.TTFBMs != tm.DurationMs {
+		t.Errorf("/empty: expected the header at the end, got %+v", tm)
+	}
+	if n := testutil.CollectAndCount(m.requestTTFB); n != 3 {
+		t.Errorf("expected a TTFB histogram per route, got %d", n)
 	}
 }
 
-func TestStatusRecorder_WriteHeader(t *testing.T) {
+func TestStatusRecorder_Interfaces(t *testing.T) {
+	// httptest.ResponseRecorder flushes but can't hijack or push.
 	rec := httptest.NewRecorder()
-	sr := &statusRecorder{ResponseWriter: rec, statusCode: http.StatusOK}
-	sr.WriteHeader(http.StatusNotFound)
-	if sr.statusCode != http.StatusNotFound {
-		t.Errorf("expected 404, got %d", sr.statusCode)
+	var w http.ResponseWriter = &statusRecorder{ResponseWriter: rec, statusCode: http.StatusOK}
+	if _, ok := w.(io.ReaderFrom); !ok {
+		t.Error("expected io.ReaderFrom through the wrapper")
+	}
+	f, ok := w.(http.Flusher)
+	if !ok {
+		t.Fatal("expected http.Flusher through the wrapper")
+	}
+	f.Flush()
+	if !rec.Flushed || !w.(*statusRecorder).wroteHeader {
+		t.Error("expected Flush to reach the recorder and send the header")
+	}
+	h, ok := w.(http.Hijacker)
+	if !ok {
+		t.Fatal("expected http.Hijacker through the wrapper")
+	}
+	if _, _, err := h.Hijack(); !errors.Is(err, http.ErrNotSupported) {
+		t.Errorf("expected ErrNotSupported from a writer that can't hijack, got %v", err)
+	}
+	p, ok := w.(http.Pusher)
+	if !ok {
+		t.Fatal("expected http.Pusher through the wrapper")
+	}
+	if err := p.Push("/app.js", nil); !errors.Is(err, http.ErrNotSupported) {
+		t.Errorf("expected ErrNotSupported from a writer that can't push, got %v", err)
+	}
+}
+
+func TestStatusRecorder_Hijack(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sr := &statusRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+		conn, buf, err := sr.Hijack()
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		_, _ = buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		_ = buf.Flush()
+	}))
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Errorf("expected 101 from the hijacked connection, got %d", resp.StatusCode)
 	}
 }
 
 func TestRateLimitMiddleware_Rejects(t *testing.T) {
+	withTrustedProxies(t, "10.0.0.0/8")
 	// Limiter with 0 rate effectively blocks all requests
-	limiter := rate.NewLimiter(rate.Limit(0), 0)
-	handler := rateLimitMiddleware(limiter)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	limiter := newRateLimiter(rate.Limit(0), 0)
+	m, _ := newTestMetrics(t)
+	rt := newRouter()
+	registerRoute(rt, routeStats, func(w http.ResponseWriter, r *http.Request) {})
+	handler := rateLimitMiddleware(limiter, m, rt)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 	}))
 
-	req := httptest.NewRequest(http.MethodGet, "/test", nil)
-	rec := httptest.NewRecorder()
-	handler.ServeHTTP(rec, req)
+	for _, req := range []struct {
+		path, remoteAddr, forwardedFor string
+	}{
+		{routeStats, "10.0.0.1:1234", ""},
+		{routeStats, "10.0.0.1:1234", "203.0.113.7"},
+		{routeStats, "203.0.113.7:1234", ""},
+		{"/unknown", "10.0.0.1:1234", ""},
+	} {
+		r := httptest.NewRequest(http.MethodGet, req.path, nil)
+		r.RemoteAddr = req.remoteAddr
+		if req.forwardedFor != "" {
+			r.Header.Set("X-Forwarded-For", req.forwardedFor)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		if rec.Code != http.StatusTooManyRequests {
+			t.Errorf("expected 429, got %d", rec.Code)
+		}
+	}
 
-	if rec.Code != http.StatusTooManyRequests {
-		t.Errorf("expected 429, got %d", rec.Code)
+	for _, want := range []struct {
+		route, class string
+		count        float64
+	}{
+		{routeStats, clientInternal, 1},
+		{routeStats, clientExternal, 2},
+		{"/other", clientInternal, 1},
+	} {
+		if got := testutil.ToFloat64(m.rateLimitedTotal.WithLabelValues(want.route, want.class)); got != want.count {
+			t.Errorf("expected %v rejections for %s from %s clients, got %v", want.count, want.route, want.class, got)
+		}
 	}
 }
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMethods_DisallowedOnEveryRoute(t *testing.T) {
+	m, reg := newTestMetrics(t)
+	internal := metricsMiddleware(m, newInternalMux(reg, m, time.Now()))
+	public := newPublicMux("test")
+	routes := []struct {
+		path    string
+		handler http.Handler
+	}{
+		{routeLive, internal},
+		{routeReady, internal},
+		{routeStartup, internal},
+		{routeHealthz, internal},
+		{routeVersion, internal},
+		{routeMetrics, internal},
+		{routeStats, internal},
+		{routeOpenAPI, internal},
+		{routePublic, public},
+		{routeOpenAPI, public},
+	}
+	disallowed := []string{http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions}
+
+	for _, route := range routes {
+		for _, method := range disallowed {
+			t.Run(method+" "+route.path, func(t *testing.T) {
+				rec := httptest.NewRecorder()
+				route.handler.ServeHTTP(rec, httptest.NewRequest(method, route.path, nil))
+
+				if rec.Code != http.StatusMethodNotAllowed {
+					t.Errorf("expected 405, got %d", rec.Code)
+				}
+				if allow := rec.Header().Get("Allow"); allow != "GET, HEAD" {
+					t.Errorf("expected Allow 'GET, HEAD', got '%s'", allow)
+				}
+				if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+					t.Errorf("expected Content-Type application/json, got '%s'", ct)
+				}
+			})
+		}
+	}
+}
+
+func TestMethods_RecordsMethodNotAllowed(t *testing.T) {
+	m, reg := newTestMetrics(t)
+	handler := metricsMiddleware(m, newInternalMux(reg, m, time.Now()))
+	counter := m.requestsTotal.WithLabelValues(http.MethodDelete, routeLive, http.StatusText(http.StatusMethodNotAllowed), "false")
+	before := testutil.ToFloat64(counter)
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodDelete, routeLive, nil))
+
+	if got := testutil.ToFloat64(counter) - before; got != 1 {
+		t.Errorf("expected one 405 recorded, got %v", got)
+	}
+}
+
+func TestMethods_HeadLive(t *testing.T) {
+	m, reg := newTestMetrics(t)
+	rec := httptest.NewRecorder()
+	newInternalMux(reg, m, time.Now()).ServeHTTP(rec, httptest.NewRequest(http.MethodHead, routeLive, nil))
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected 200, got %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected Content-Type application/json, got '%s'", ct)
+	}
+	if rec.Body.Len() != 0 {
+		t.Errorf("expected an empty body for HEAD, got %q", rec.Body.String())
+	}
+}
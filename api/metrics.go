@@ -0,0 +1,321 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// metricsMaxRequestsInFlight caps concurrent scrapes; extra ones get 503
+	// instead of piling up behind a slow gather.
+	metricsMaxRequestsInFlight = 3
+	// metricsScrapeTimeout bounds a single scrape, inside the server's
+	// 10s write timeout.
+	metricsScrapeTimeout = 5 * time.Second
+)
+
+// defaultDurationBuckets resolve the 1ms–1s range most handlers fall in.
+var defaultDurationBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5}
+
+// metrics holds the API's Prometheus collectors. main registers one set on
+// the default registry; tests use isolated registries.
+type metrics struct {
+	requestsTotal      *prometheus.CounterVec
+	requestDuration    *prometheus.HistogramVec
+	requestTTFB        *prometheus.HistogramVec
+	errorsTotal        *prometheus.CounterVec
+	rateLimitedTotal   *prometheus.CounterVec
+	panicsTotal        *prometheus.CounterVec
+	slowRequests       *prometheus.CounterVec
+	sloRequests        *prometheus.CounterVec
+	sloErrors          *prometheus.CounterVec
+	sloObjective       *prometheus.GaugeVec
+	logsPurged         prometheus.Counter
+	logFlushDuration   prometheus.Histogram
+	logFlushBatchSize  prometheus.Histogram
+	logFlushErrors     prometheus.Counter
+	dbConnectRetries   prometheus.Counter
+	dbConnectFailures  prometheus.Counter
+	dbReconnects       prometheus.Counter
+	otlpPushFailures   prometheus.Counter
+	errorReports       *prometheus.CounterVec
+	readyNotifications *prometheus.CounterVec
+	serviceEvents      *prometheus.CounterVec
+	statsdFailures     prometheus.Counter
+	traceparentInvalid prometheus.Counter
+	buildInfo          *prometheus.GaugeVec
+
+	// statsd mirrors the key metrics to a statsd agent when STATSD_ADDR is
+	// set; nil otherwise.
+	statsd *statsdClient
+}
+
+// newMetrics creates the API collectors, plus build info and DB pool stats,
+// and registers them on reg.
+func newMetrics(reg prometheus.Registerer, durationBuckets []float64) *metrics {
+	m := &metrics{
+		requestsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "http_requests_total",
+				Help: "Total number of HTTP requests",
+			},
+			[]string{"method", "endpoint", "status", "injected"},
+		),
+		requestDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "http_request_duration_seconds",
+				Help:    "HTTP request duration in seconds",
+				Buckets: durationBuckets,
+			},
+			[]string{"method", "endpoint", "injected"},
+		),
+		requestTTFB: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "http_request_ttfb_seconds",
+				Help:    "Time until the HTTP response header was written, in seconds",
+				Buckets: durationBuckets,
+			},
+			[]string{"method", "endpoint", "injected"},
+		),
+		errorsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "http_errors_total",
+				Help: "Total number of HTTP errors (4xx and 5xx)",
+			},
+			[]string{"method", "endpoint", "status", "injected"},
+		),
+		rateLimitedTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "http_rate_limited_total",
+				Help: "Total number of rate-limited requests by route and client class",
+			},
+			[]string{"route", "client_class"},
+		),
+		panicsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "http_panics_total",
+				Help: "Total number of handler panics recovered",
+			},
+			[]string{"route"},
+		),
+		slowRequests: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "http_slow_requests_total",
+				Help: "Total number of requests slower than their route's slow request threshold",
+			},
+			[]string{"route"},
+		),
+		sloRequests: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "slo_requests_total",
+				Help: "Total number of requests to routes with an SLO",
+			},
+			[]string{"route"},
+		),
+		sloErrors: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "slo_errors_total",
+				Help: "Total number of requests counted against a route's error budget",
+			},
+			[]string{"route"},
+		),
+		sloObjective: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "slo_objective",
+				Help: "Availability objective configured for a route",
+			},
+			[]string{"route"},
+		),
+		logsPurged: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "api_logs_purged_total",
+				Help: "Total number of api_logs rows deleted via DELETE /admin/logs",
+			},
+		),
+		logFlushDuration: prometheus.NewHistogram(
+			prometheus.HistogramOpts{
+				Name:    "log_flush_duration_seconds",
+				Help:    "Time spent writing access log entries to the database",
+				Buckets: durationBuckets,
+			},
+		),
+		logFlushBatchSize: prometheus.NewHistogram(
+			prometheus.HistogramOpts{
+				Name:    "log_flush_batch_size",
+				Help:    "Access log entries written per flush",
+				Buckets: prometheus.ExponentialBuckets(1, 2, 10),
+			},
+		),
+		logFlushErrors: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "log_flush_errors_total",
+				Help: "Total number of failed access log flushes",
+			},
+		),
+		dbConnectRetries: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "db_connect_retries_total",
+				Help: "Total number of failed database connection attempts",
+			},
+		),
+		dbConnectFailures: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "db_connect_failures_total",
+				Help: "Total number of times all database connection retries were exhausted",
+			},
+		),
+		dbReconnects: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "db_keepalive_reconnects_total",
+				Help: "Total number of pool replacements started by failing keepalive pings",
+			},
+		),
+		otlpPushFailures: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "otlp_metrics_push_failures_total",
+				Help: "Total number of failed OTLP metrics pushes",
+			},
+		),
+		errorReports: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "error_reports_total",
+				Help: "Total number of error reports by outcome: sent, failed, rate_limited or queue_full",
+			},
+			[]string{"outcome"},
+		),
+		readyNotifications: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "readiness_notifications_total",
+				Help: "Total number of readiness change notifications by outcome: sent or failed",
+			},
+			[]string{"outcome"},
+		),
+		serviceEvents: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "service_events_total",
+				Help: "Total number of lifecycle events by outcome: written, failed or dropped",
+			},
+			[]string{"outcome"},
+		),
+		statsdFailures: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "statsd_send_failures_total",
+				Help: "Total number of statsd packets that failed to send",
+			},
+		),
+		traceparentInvalid: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "traceparent_invalid_total",
+				Help: "Total number of requests with a malformed traceparent header, which is ignored",
+			},
+		),
+		buildInfo: newBuildInfo(),
+	}
+	registerOrReuse(reg, &m.requestsTotal)
+	registerOrReuse(reg, &m.requestDuration)
+	registerOrReuse(reg, &m.requestTTFB)
+	registerOrReuse(reg, &m.errorsTotal)
+	registerOrReuse(reg, &m.rateLimitedTotal)
+	registerOrReuse(reg, &m.panicsTotal)
+	registerOrReuse(reg, &m.slowRequests)
+	registerOrReuse(reg, &m.sloRequests)
+	registerOrReuse(reg, &m.sloErrors)
+	registerOrReuse(reg, &m.sloObjective)
+	registerOrReuse(reg, &m.logsPurged)
+	registerOrReuse(reg, &m.logFlushDuration)
+	registerOrReuse(reg, &m.logFlushBatchSize)
+	registerOrReuse(reg, &m.logFlushErrors)
+	registerOrReuse(reg, &m.dbConnectRetries)
+	registerOrReuse(reg, &m.dbConnectFailures)
+	registerOrReuse(reg, &m.dbReconnects)
+	registerOrReuse(reg, &m.otlpPushFailures)
+	registerOrReuse(reg, &m.errorReports)
+	registerOrReuse(reg, &m.readyNotifications)
+	registerOrReuse(reg, &m.serviceEvents)
+	registerOrReuse(reg, &m.statsdFailures)
+	registerOrReuse(reg, &m.traceparentInvalid)
+	registerOrReuse(reg, &m.buildInfo)
+	dbStats := newDBStatsCollector()
+	registerOrReuse(reg, &dbStats)
+	return m
+}
+
+// registerOrReuse registers *c on reg. When reg already holds an equal
+// collector, as it does when the metrics are built twice in one binary, *c
+// is pointed at that one instead so both sets update the same series. Any
+// other registration error is a programming error and panics, as
+// MustRegister would.
+func registerOrReuse[C prometheus.Collector](reg prometheus.Registerer, c *C) {
+	err := reg.Register(*c)
+	if err == nil {
+		return
+	}
+	var already prometheus.AlreadyRegisteredError
+	if errors.As(err, &already) {
+		if existing, ok := already.ExistingCollector.(C); ok {
+			*c = existing
+			return
+		}
+	}
+	panic(err)
+}
+
+// newMetricsHandler serves gatherer in the text or OpenMetrics format,
+// whichever the scraper asks for, gzip-compressed when it accepts gzip.
+func newMetricsHandler(gatherer prometheus.Gatherer) http.Handler {
+	return promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{
+		EnableOpenMetrics:   true,
+		OfferedCompressions: []promhttp.Compression{promhttp.Identity, promhttp.Gzip},
+		MaxRequestsInFlight: metricsMaxRequestsInFlight,
+		Timeout:             metricsScrapeTimeout,
+	})
+}
+
+// The methods below update a metric the statsd emitter mirrors, in
+// Prometheus and, when enabled, statsd, so call sites stay single lines.
+
+// observeHTTP records a finished request: http_requests_total,
+// http_request_duration_seconds and http_request_ttfb_seconds, labelled
+// with whether a fault was injected, and the http.requests counter and
+// http.request.duration timer tagged with the status class, plus
+// injected:true for an injected fault. When the
+// request's trace sc is sampled, its ID is the duration's exemplar, so a
+// latency spike in Grafana links straight to a trace.
+func (m *metrics) observeHTTP(method, route string, code int, d, ttfb time.Duration, injected bool, sc trace.SpanContext) {
+	inj := strconv.FormatBool(injected)
+	m.requestsTotal.WithLabelValues(method, route, http.StatusText(code), inj).Inc()
+	m.requestTTFB.WithLabelValues(method, route, inj).Observe(ttfb.Seconds())
+	duration := m.requestDuration.WithLabelValues(method, route, inj)
+	if eo, ok := duration.(prometheus.ExemplarObserver); ok && sc.IsValid() && sc.IsSampled() {
+		eo.ObserveWithExemplar(d.Seconds(), prometheus.Labels{"trace_id": sc.TraceID().String()})
+	} else {
+		duration.Observe(d.Seconds())
+	}
+	if m.statsd == nil {
+		return
+	}
+	tags := []string{statsdTag("method", method), statsdTag("route", route), statsdTag("status_class", statusClass(code))}
+	if injected {
+		tags = append(tags, statsdTag("injected", inj))
+	}
+	m.statsd.count("http.requests", 1, tags...)
+	m.statsd.timing("http.request.duration", d, tags...)
+}
+
+// countRateLimited records a request to route rejected by the rate limiter,
+// from a client of the given class.
+func (m *metrics) countRateLimited(route, class string) {
+	m.rateLimitedTotal.WithLabelValues(route, class).Inc()
+	m.statsd.count("http.rate_limited", 1, statsdTag("route", route), statsdTag("client_class", class))
+}
+
+// statusClass returns "2xx" for 200 to 299, and so on.
+func statusClass(code int) string {
+	return strconv.Itoa(code/100) + "xx"
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// newTestMetrics returns API metrics on an isolated registry.
+func newTestMetrics(t *testing.T) (*metrics, *prometheus.Registry) {
+	t.Helper()
+	reg := prometheus.NewRegistry()
+	return newMetrics(reg, defaultDurationBuckets), reg
+}
+
+// histogramCount returns the sample count of the unlabelled histogram name
+// gathered from reg.
+func histogramCount(t *testing.T, reg *prometheus.Registry, name string) uint64 {
+	t.Helper()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("gather failed: %v", err)
+	}
+	for _, f := range families {
+		if f.GetName() == name && len(f.GetMetric()) == 1 {
+			return f.GetMetric()[0].GetHistogram().GetSampleCount()
+		}
+	}
+	return 0
+}
+
+func TestNewMetrics_Names(t *testing.T) {
+	m, reg := newTestMetrics(t)
+	m.requestsTotal.WithLabelValues("GET", "/live", "OK", "false").Inc()
+	m.requestDuration.WithLabelValues("GET", "/live", "false").Observe(0.01)
+	m.errorsTotal.WithLabelValues("GET", "/live", "Not Found", "false").Inc()
+	m.rateLimitedTotal.WithLabelValues("/live", clientExternal).Inc()
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("gather failed: %v", err)
+	}
+	var names []string
+	for _, f := range families {
+		names = append(names, f.GetName())
+	}
+	for _, want := range []string{"http_requests_total", "http_request_duration_seconds", "http_errors_total", "http_rate_limited_total", "build_info"} {
+		if !slices.Contains(names, want) {
+			t.Errorf("expected %s to be registered, got %v", want, names)
+		}
+	}
+}
+
+func TestNewMetrics_IsolatedRegistries(t *testing.T) {
+	// Each registry gets its own collectors, so this must not panic.
+	newTestMetrics(t)
+	newTestMetrics(t)
+}
+
+func TestMetricsHandler_Gzip(t *testing.T) {
+	_, reg := newTestMetrics(t)
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	newMetricsHandler(reg).ServeHTTP(rec, req)
+
+	if ce := rec.Header().Get("Content-Encoding"); ce != "gzip" {
+		t.Fatalf("expected Content-Encoding gzip, got %q", ce)
+	}
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatalf("expected a gzip body: %v", err)
+	}
+	body, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("failed to decompress body: %v", err)
+	}
+	if !strings.Contains(string(body), "build_info") {
+		t.Errorf("expected build_info in the decompressed body, got %q", body)
+	}
+}
+
+func TestMetricsHandler_OpenMetrics(t *testing.T) {
+	_, reg := newTestMetrics(t)
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+	rec := httptest.NewRecorder()
+	newMetricsHandler(reg).ServeHTTP(rec, req)
+
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/openmetrics-text") {
+		t.Errorf("expected an OpenMetrics content type, got %q", ct)
+	}
+	if !strings.HasSuffix(rec.Body.String(), "# EOF\n") {
+		t.Error("expected the OpenMetrics # EOF trailer")
+	}
+}
+
+func TestMetricsHandler_PlainText(t *testing.T) {
+	_, reg := newTestMetrics(t)
+	rec := httptest.NewRecorder()
+	newMetricsHandler(reg).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if ce := rec.Header().Get("Content-Encoding"); ce != "" {
+		t.Errorf("expected no compression without Accept-Encoding, got %q", ce)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("expected the text format by default, got %q", ct)
+	}
+}
+
+func TestDefaultGatherer_ProcessStartTime(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("process collector metrics are only complete on Linux")
+	}
+	// Restart annotations rely on the default registry's process collector.
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatalf("gather failed: %v", err)
+	}
+	for _, f := range families {
+		if f.GetName() == "process_start_time_seconds" {
+			return
+		}
+	}
+	t.Error("expected process_start_time_seconds from the default gatherer")
+}
+
+func TestNewMetrics_SameRegistryTwice(t *testing.T) {
+	first, reg := newTestMetrics(t)
+	second := newMetrics(reg, defaultDurationBuckets)
+
+	// The second set reuses the first's collectors rather than panicking.
+	second.rateLimitedTotal.WithLabelValues("/live", clientExternal).Inc()
+	first.logsPurged.Add(2)
+	if got := testutil.ToFloat64(first.rateLimitedTotal.WithLabelValues("/live", clientExternal)); got != 1 {
+		t.Errorf("expected the sets to share http_rate_limited_total, got %v", got)
+	}
+	if got := testutil.ToFloat64(second.logsPurged); got != 2 {
+		t.Errorf("expected the sets to share api_logs_purged_total, got %v", got)
+	}
+	if _, err := reg.Gather(); err != nil {
+		t.Errorf("gather failed: %v", err)
+	}
+}
+
+func TestRegisterOrReuse_Conflict(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	c := prometheus.NewCounter(prometheus.CounterOpts{Name: "conflict_total", Help: "a counter"})
+	registerOrReuse(reg, &c)
+	// Same name, different help: not the same metric, so it must still panic.
+	other := prometheus.NewCounter(prometheus.CounterOpts{Name: "conflict_total", Help: "something else"})
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a conflicting registration to panic")
+		}
+	}()
+	registerOrReuse(reg, &other)
+}
+
+func TestObserveHTTP_TraceExemplar(t *testing.T) {
+	m, reg := newTestMetrics(t)
+	handler := metricsMiddleware(m, newInternalMux(reg, m, time.Now()))
+	serve := func(path string, last byte, flags trace.TraceFlags) trace.TraceID {
+		sc := trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID:    trace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, last},
+			SpanID:     trace.SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+			TraceFlags: flags,
+			Remote:     true,
+		})
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req = req.WithContext(trace.ContextWithRemoteSpanContext(req.Context(), sc))
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		return sc.TraceID()
+	}
+	sampled := serve(routeLive, 0x36, trace.FlagsSampled)
+	unsampled := serve(routeVersion, 0x37, 0)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, routeStartup, nil))
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+	rec := httptest.NewRecorder()
+	newMetricsHandler(reg).ServeHTTP(rec, req)
+	body := rec.Body.String()
+
+	exemplar := `# {trace_id="` + sampled.String() + `"}`
+	var found bool
+	for _, line := range strings.Split(body, "\n") {
+		if strings.HasPrefix(line, "http_request_duration_seconds_bucket{") && strings.Contains(line, `endpoint="/live"`) && strings.Contains(line, exemplar) {
+			found = true
+		}
+		if strings.Contains(line, `endpoint="/startup"`) && strings.Contains(line, "# {") {
+			t.Errorf("expected no exemplar without a trace, got %s", line)
+		}
+	}
+	if !found {
+		t.Errorf("expected a /live duration bucket with exemplar %s in:\n%s", exemplar, body)
+	}
+	if strings.Contains(body, unsampled.String()) {
+		t.Errorf("expected no exemplar for the unsampled trace %s", unsampled)
+	}
+}
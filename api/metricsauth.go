@@ -0,0 +1,56 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// metricsCredentials protects /metrics. Basic auth takes precedence over
+// the bearer token; when both are empty the endpoint is open.
+type metricsCredentials struct {
+	token    string
+	user     string
+	password string
+}
+
+// metricsAuth is set by main from METRICS_AUTH_TOKEN and METRICS_BASIC_AUTH.
+var metricsAuth metricsCredentials
+
+// requireMetricsAuth rejects scrapes that don't carry the configured
+// credentials with a 401 JSON error.
+func requireMetricsAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		creds := metricsAuth
+		switch {
+		case creds.user != "":
+			user, password, ok := r.BasicAuth()
+			if ok && subtle.ConstantTimeCompare([]byte(user), []byte(creds.user))&
+				subtle.ConstantTimeCompare([]byte(password), []byte(creds.password)) == 1 {
+				next(w, r)
+				return
+			}
+			w.Header().Set("WWW-Authenticate", `Basic realm="metrics"`)
+		case creds.token != "":
+			if validBearerToken(r, creds.token) {
+				next(w, r)
+				return
+			}
+			w.Header().Set("WWW-Authenticate", "Bearer")
+		default:
+			next(w, r)
+			return
+		}
+		writeError(w, http.StatusUnauthorized, codeUnauthorized, "unauthorized")
+	}
+}
+
+// validBearerToken compares the request's bearer token against token in
+// constant time.
+func validBearerToken(r *http.Request, token string) bool {
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRequireMetricsAuth(t *testing.T) {
+	defer func() { metricsAuth = metricsCredentials{} }()
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+
+	tests := []struct {
+		name  string
+		creds metricsCredentials
+		setup func(r *http.Request)
+		want  int
+	}{
+		{"open", metricsCredentials{}, func(*http.Request) {}, http.StatusOK},
+		{"token missing", metricsCredentials{token: "s3cret"}, func(*http.Request) {}, http.StatusUnauthorized},
+		{"token wrong", metricsCredentials{token: "s3cret"}, func(r *http.Request) { r.Header.Set("Authorization", "Bearer nope") }, http.StatusUnauthorized},
+		{"token valid", metricsCredentials{token: "s3cret"}, func(r *http.Request) { r.Header.Set("Authorization", "Bearer s3cret") }, http.StatusOK},
+		{"basic wrong", metricsCredentials{user: "prom", password: "pw"}, func(r *http.Request) { r.SetBasicAuth("prom", "nope") }, http.StatusUnauthorized},
+		{"basic valid", metricsCredentials{user: "prom", password: "pw"}, func(r *http.Request) { r.SetBasicAuth("prom", "pw") }, http.StatusOK},
+		{"basic overrides token", metricsCredentials{token: "s3cret", user: "prom", password: "pw"}, func(r *http.Request) { r.Header.Set("Authorization", "Bearer s3cret") }, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metricsAuth = tt.creds
+			req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			tt.setup(req)
+			rec := httptest.NewRecorder()
+			requireMetricsAuth(ok)(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("expected %d, got %d", tt.want, rec.Code)
+			}
+			if tt.want == http.StatusUnauthorized {
+				if rec.Header().Get("WWW-Authenticate") == "" {
+					t.Error("expected a WWW-Authenticate challenge")
+				}
+				if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+					t.Errorf("expected Content-Type application/json, got '%s'", ct)
+				}
+			}
+		})
+	}
+}
+
+// Rejected scrapes are counted as requests but deliberately kept out of
+// http_errors_total so a misconfigured scraper doesn't page as an API error.
+func TestMetricsAuth_RejectedScrapeIsNotAnError(t *testing.T) {
+	metricsAuth = metricsCredentials{token: "s3cret"}
+	defer func() { metricsAuth = metricsCredentials{} }()
+
+	m, reg := newTestMetrics(t)
+	status := http.StatusText(http.StatusUnauthorized)
+	requests := m.requestsTotal.WithLabelValues(http.MethodGet, routeMetrics, status, "false")
+	errs := m.errorsTotal.WithLabelValues(http.MethodGet, routeMetrics, status, "false")
+	requestsBefore, errsBefore := testutil.ToFloat64(requests), testutil.ToFloat64(errs)
+
+	rec := httptest.NewRecorder()
+	metricsMiddleware(m, newInternalMux(reg, m, time.Now())).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, routeMetrics, nil))
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", rec.Code)
+	}
+	if got := testutil.ToFloat64(requests) - requestsBefore; got != 1 {
+		t.Errorf("expected the rejected scrape in http_requests_total, got %v", got)
+	}
+	if got := testutil.ToFloat64(errs) - errsBefore; got != 0 {
+		t.Errorf("expected no http_errors_total increment, got %v", got)
+	}
+}
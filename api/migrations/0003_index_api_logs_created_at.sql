@@ -0,0 +1,3 @@
+-- /api/v1/stats, DELETE /admin/logs and the worker's retention purge all
+-- filter on created_at.
+CREATE INDEX IF NOT EXISTS api_logs_created_at_idx ON api_logs (created_at);
@@ -0,0 +1,7 @@
+-- A single row the API's write readiness check (READY_CHECK_WRITE) upserts,
+-- so a database that only accepts reads, such as a replica mid-failover,
+-- fails /ready.
+CREATE TABLE IF NOT EXISTS ready_heartbeat (
+    id SMALLINT PRIMARY KEY CHECK (id = 1),
+    checked_at TIMESTAMPTZ NOT NULL
+);
@@ -0,0 +1,3 @@
+-- Time until the response header was written, so a slow handler can be told
+-- from a slow client. NULL for rows logged before it was recorded.
+ALTER TABLE api_logs ADD COLUMN IF NOT EXISTS ttfb_ms FLOAT;
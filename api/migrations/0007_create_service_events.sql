@@ -0,0 +1,13 @@
+-- Lifecycle events both services record: startups, shutdowns, database
+-- reconnects and worker pauses, for postmortems. GET /api/v1/events lists
+-- them, newest first.
+CREATE TABLE IF NOT EXISTS service_events (
+    id BIGSERIAL PRIMARY KEY,
+    service VARCHAR(255) NOT NULL,
+    event VARCHAR(64) NOT NULL,
+    detail JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS service_events_created_at_idx ON service_events (created_at DESC);
+CREATE INDEX IF NOT EXISTS service_events_service_created_at_idx ON service_events (service, created_at DESC);
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// The OpenAPI document is generated from the response structs, so it can't
+// drift from what the handlers actually encode.
+
+type openAPISpec struct {
+	OpenAPI    string                                 `json:"openapi"`
+	Info       openAPIInfo                            `json:"info"`
+	Paths      map[string]map[string]openAPIOperation `json:"paths"`
+	Components *openAPIComponents                     `json:"components,omitempty"`
+}
+
+type openAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type openAPIComponents struct {
+	SecuritySchemes map[string]openAPISecurityScheme `json:"securitySchemes"`
+}
+
+type openAPISecurityScheme struct {
+	Type   string `json:"type"`
+	Scheme string `json:"scheme"`
+}
+
+type openAPIOperation struct {
+	Summary    string                     `json:"summary"`
+	Parameters []openAPIParameter         `json:"parameters,omitempty"`
+	Security   []map[string][]string      `json:"security,omitempty"`
+	Responses  map[string]openAPIResponse `json:"responses"`
+}
+
+type openAPIParameter struct {
+	Name        string         `json:"name"`
+	In          string         `json:"in"`
+	Description string         `json:"description"`
+	Required    bool           `json:"required,omitempty"`
+	Schema      *openAPISchema `json:"schema"`
+}
+
+type openAPIResponse struct {
+	Description string                      `json:"description"`
+	Content     map[string]openAPIMediaType `json:"content,omitempty"`
+}
+
+type openAPIMediaType struct {
+	Schema *openAPISchema `json:"schema"`
+}
+
+type openAPISchema struct {
+	Type       string                    `json:"type"`
+	Format     string                    `json:"format,omitempty"`
+	Enum       []string                  `json:"enum,omitempty"`
+	Properties map[string]*openAPISchema `json:"properties,omitempty"`
+	Required   []string                  `json:"required,omitempty"`
+	Items      *openAPISchema            `json:"items,omitempty"`
+}
+
+// schemaOf derives a schema from t the way encoding/json would encode it:
+// fields named by their json tag, omitempty fields optional, embedded
+// structs flattened.
+func schemaOf(t reflect.Type) *openAPISchema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		s := &openAPISchema{Type: "object", Properties: map[string]*openAPISchema{}}
+		addFields(s, t)
+		return s
+	case reflect.Slice, reflect.Array:
+		return &openAPISchema{Type: "array", Items: schemaOf(t.Elem())}
+	case reflect.Map:
+		return &openAPISchema{Type: "object"}
+	case reflect.Bool:
+		return &openAPISchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &openAPISchema{Type: "integer", Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &openAPISchema{Type: "number", Format: "double"}
+	default:
+		return &openAPISchema{Type: "string"}
+	}
+}
+
+func addFields(s *openAPISchema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" || (!f.IsExported() && !f.Anonymous) {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			addFields(s, f.Type)
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		s.Properties[name] = schemaOf(f.Type)
+		if !strings.Contains(opts, "omitempty") && f.Type.Kind() != reflect.Pointer {
+			s.Required = append(s.Required, name)
+		}
+	}
+}
+
+func jsonResponse(description string, body any) openAPIResponse {
+	return openAPIResponse{
+		Description: description,
+		Content:     map[string]openAPIMediaType{contentTypeJSON: {Schema: schemaOf(reflect.TypeOf(body))}},
+	}
+}
+
+// timeResponse describes the encodings the time endpoint negotiates.
+func timeResponse() openAPIResponse {
+	res := jsonResponse("Current time; text/plain carries only the timestamp", PublicResponse{})
+	res.Content[contentTypeMsgpack] = res.Content[contentTypeJSON]
+	res.Content["text/plain"] = openAPIMediaType{Schema: stringSchema("")}
+	return res
+}
+
+func errorReply(description string) openAPIResponse {
+	return jsonResponse(description, errorResponse{})
+}
+
+// ifNoneMatchHeader describes the conditional request withETag honours.
+func ifNoneMatchHeader() openAPIParameter {
+	return openAPIParameter{Name: "If-None-Match", In: "header", Description: "ETag of a cached copy; a match gets an empty 304", Schema: stringSchema("")}
+}
+
+func queryParam(name, description string, schema *openAPISchema) openAPIParameter {
+	return openAPIParameter{Name: name, In: "query", Description: description, Schema: schema}
+}
+
+func stringSchema(format string, enum ...string) *openAPISchema {
+	return &openAPISchema{Type: "string", Format: format, Enum: enum}
+}
+
+// newOpenAPISpec describes the routes of the internal server, or only those
+// of the public server when public is true.
+func newOpenAPISpec(public bool) openAPISpec {
+	spec := openAPISpec{
+		OpenAPI: "3.0.3",
+		Info:    openAPIInfo{Title: serviceName, Version: version},
+		Paths: map[string]map[string]openAPIOperation{
+			routeOpenAPI: {"get": {
+				Summary:    "This document",
+				Parameters: []openAPIParameter{ifNoneMatchHeader()},
+				Responses: map[string]openAPIResponse{
+					"200": {Description: "OpenAPI 3 document"},
+					"304": {Description: "Not modified since the ETag in If-None-Match"},
+				},
+			}},
+		},
+	}
+	if public {
+		spec.Paths[routePublic] = map[string]openAPIOperation{"get": {
+			Summary: "Current time",
+			Parameters: []openAPIParameter{
+				queryParam("tz", "IANA time zone, e.g. Asia/Bangkok; defaults to UTC", stringSchema("")),
+				queryParam("format", "Epoch unit to include, or response encoding overriding Accept", stringSchema("", "rfc3339", "unix", "unixms", "json", "text", "msgpack")),
+			},
+			Responses: map[string]openAPIResponse{
+				"200": timeResponse(),
+				"400": errorReply("Unknown tz or format"),
+			},
+		}}
+		return spec
+	}
+
+	spec.Paths[routeLive] = map[string]openAPIOperation{"get": {
+		Summary:   "Liveness",
+		Responses: map[string]openAPIResponse{"200": jsonResponse("Process is alive", HealthResponse{})},
+	}}
+	spec.Paths[routeReady] = map[string]openAPIOperation{"get": {
+		Summary: "Readiness, with the result of each check",
+		Responses: map[string]openAPIResponse{
+			"200": jsonResponse("Ready or degraded", readyResponse{}),
+			"503": jsonResponse("A required check failed", readyResponse{}),
+		},
+	}}
+	spec.Paths[routeStartup] = map[string]openAPIOperation{"get": {
+		Summary: "Startup",
+		Responses: map[string]openAPIResponse{
+			"200": jsonResponse(`Initialization finished: {"status":"started"}`, statusResponse{}),
+			"503": jsonResponse(`Still starting: {"status":"starting"}`, statusResponse{}),
+		},
+	}}
+	spec.Paths[routeHealthz] = map[string]openAPIOperation{"get": {
+		Summary: "Liveness, readiness, uptime and version in one document",
+		Responses: map[string]openAPIResponse{
+			"200": jsonResponse("Ready or degraded", HealthzResponse{}),
+			"503": jsonResponse("Unhealthy", HealthzResponse{}),
+		},
+	}}
+	spec.Paths[routeVersion] = map[string]openAPIOperation{"get": {
+		Summary:    "Build information",
+		Parameters: []openAPIParameter{ifNoneMatchHeader()},
+		Responses: map[string]openAPIResponse{
+			"200": jsonResponse("Build information", VersionResponse{}),
+			"304": {Description: "Not modified since the ETag in If-None-Match"},
+		},
+	}}
+	spec.Paths[routeMetrics] = map[string]openAPIOperation{"get": {
+		Summary: "Prometheus metrics",
+		Responses: map[string]openAPIResponse{
+			"200": {Description: "Prometheus text or OpenMetrics exposition"},
+			"401": errorReply("Missing or wrong METRICS_AUTH_TOKEN / METRICS_BASIC_AUTH credentials"),
+		},
+	}}
+	spec.Paths[routeStats] = map[string]openAPIOperation{"get": {
+		Summary: "Request statistics from api_logs",
+		Parameters: []openAPIParameter{
+			queryParam("from", "Window start; defaults to an hour before to", stringSchema("date-time")),
+			queryParam("to", "Window end; defaults to now. The window may span at most 7 days", stringSchema("date-time")),
+			queryParam("group_by", "Grouping column; defaults to endpoint", stringSchema("", "endpoint", "method", "status")),
+		},
+		Responses: map[string]openAPIResponse{
+			"200": jsonResponse("Per-group statistics", StatsResponse{}),
+			"400": errorReply("Invalid window or group_by"),
+			"503": errorReply("Database not configured"),
+			"504": errorReply("Query exceeded STATS_QUERY_TIMEOUT"),
+		},
+	}}
+	spec.Paths[routeStatsClients] = map[string]openAPIOperation{"get": {
+		Summary: "Clients that sent the most requests, from api_logs",
+		Parameters: []openAPIParameter{
+			queryParam("window", "How far back to look, as a duration; defaults to 1h, at most 24h", stringSchema("")),
+			queryParam("limit", "Clients to list, 1 to 100; defaults to 20", &openAPISchema{Type: "integer"}),
+		},
+		Responses: map[string]openAPIResponse{
+			"200": jsonResponse("Clients by request count, cached for 30 seconds", ClientStatsResponse{}),
+			"400": errorReply("Invalid window or limit"),
+			"503": errorReply("Database not configured"),
+			"504": errorReply("Query exceeded STATS_QUERY_TIMEOUT"),
+		},
+	}}
+	spec.Paths[routeEvents] = map[string]openAPIOperation{"get": {
+		Summary: "Recent lifecycle events of the API and the worker, newest first",
+		Parameters: []openAPIParameter{
+			queryParam("service", "Only events of this SERVICE_NAME; defaults to every service", stringSchema("")),
+			queryParam("limit", "Events to list, 1 to 500; defaults to 50", &openAPISchema{Type: "integer"}),
+		},
+		Responses: map[string]openAPIResponse{
+			"200": jsonResponse("Events from service_events", EventsResponse{}),
+			"400": errorReply("Invalid service or limit"),
+			"503": errorReply("Database not configured"),
+			"504": errorReply("Query exceeded STATS_QUERY_TIMEOUT"),
+		},
+	}}
+	spec.Paths[routeLogsExport] = map[string]openAPIOperation{"get": {
+		Summary: "Stream the api_logs rows of a window as CSV or NDJSON",
+		Parameters: []openAPIParameter{
+			{Name: "from", In: "query", Description: "Window start", Required: true, Schema: stringSchema("date-time")},
+			{Name: "to", In: "query", Description: "Window end, exclusive. The window may span at most 24 hours", Required: true, Schema: stringSchema("date-time")},
+			queryParam("format", "Export format; defaults to csv", stringSchema("", exportFormatCSV, exportFormatNDJSON)),
+		},
+		Security: []map[string][]string{{"adminToken": {}}},
+		Responses: map[string]openAPIResponse{
+			"200": {
+				Description: "The rows, oldest first, as an attachment",
+				Content: map[string]openAPIMediaType{
+					exportContentTypes[exportFormatCSV]:    {Schema: stringSchema("")},
+					exportContentTypes[exportFormatNDJSON]: {Schema: schemaOf(reflect.TypeOf(LogExportRow{}))},
+				},
+			},
+			"400": errorReply("Missing or invalid window, or unknown format"),
+			"401": errorReply("Wrong bearer token"),
+			"403": errorReply("ADMIN_TOKEN not set"),
+			"500": errorReply("Query failed"),
+			"503": errorReply("Database not configured"),
+		},
+	}}
+	spec.Paths[routeAdminLogs] = map[string]openAPIOperation{"delete": {
+		Summary: "Delete api_logs rows created before a timestamp",
+		Parameters: []openAPIParameter{
+			{Name: "before", In: "query", Description: "Delete rows created before this time; must not be in the future", Required: true, Schema: stringSchema("date-time")},
+			queryParam("dry_run", "Only count matching rows", &openAPISchema{Type: "boolean"}),
+		},
+		Security: []map[string][]string{{"adminToken": {}}},
+		Responses: map[string]openAPIResponse{
+			"200": jsonResponse("Rows deleted, or matched on a dry run", PurgeLogsResponse{}),
+			"400": errorReply("Missing, invalid or future before"),
+			"401": errorReply("Wrong bearer token"),
+			"403": errorReply("ADMIN_TOKEN not set"),
+			"500": jsonResponse("Purge failed part way; deleted counts rows already removed", PurgeLogsResponse{}),
+		},
+	}}
+	logLevelOK := jsonResponse("Current level and any pending revert", LogLevelResponse{})
+	adminDenied := map[string]openAPIResponse{
+		"401": errorReply("Wrong bearer token"),
+		"403": errorReply("ADMIN_TOKEN not set"),
+	}
+	spec.Paths[routeAdminLogLevel] = map[string]openAPIOperation{
+		"get": {
+			Summary:   "Current log level",
+			Security:  []map[string][]string{{"adminToken": {}}},
+			Responses: map[string]openAPIResponse{"200": logLevelOK, "401": adminDenied["401"], "403": adminDenied["403"]},
+		},
+		"put": {
+			Summary: "Change the log level, optionally for a while",
+			Parameters: []openAPIParameter{
+				{Name: "level", In: "query", Description: "New level", Required: true, Schema: stringSchema("", "debug", "info", "warn", "error")},
+				queryParam("duration", "Revert to the previous level after this Go duration, e.g. 10m", stringSchema("")),
+			},
+			Security: []map[string][]string{{"adminToken": {}}},
+			Responses: map[string]openAPIResponse{
+				"200": logLevelOK,
+				"400": errorReply("Invalid level or duration"),
+				"401": adminDenied["401"],
+				"403": adminDenied["403"],
+			},
+		},
+	}
+	rateLimitOK := jsonResponse("Limiter configuration and remaining tokens", RateLimitResponse{})
+	spec.Paths[routeAdminRateLimit] = map[string]openAPIOperation{"get": {
+		Summary:   "Rate limiter state",
+		Security:  []map[string][]string{{"adminToken": {}}},
+		Responses: map[string]openAPIResponse{"200": rateLimitOK, "401": adminDenied["401"], "403": adminDenied["403"]},
+	}}
+	spec.Paths[routeAdminRateLimitReset] = map[string]openAPIOperation{"post": {
+		Summary:   "Refill the rate limiter",
+		Security:  []map[string][]string{{"adminToken": {}}},
+		Responses: map[string]openAPIResponse{"200": rateLimitOK, "401": adminDenied["401"], "403": adminDenied["403"]},
+	}}
+	spec.Paths[routeAdminConfig] = map[string]openAPIOperation{"get": {
+		Summary:   "Effective configuration, secrets masked, with each setting's source",
+		Security:  []map[string][]string{{"adminToken": {}}},
+		Responses: map[string]openAPIResponse{"200": jsonResponse("Settings by field and sources by environment variable", ConfigResponse{}), "401": adminDenied["401"], "403": adminDenied["403"]},
+	}}
+	spec.Components = &openAPIComponents{SecuritySchemes: map[string]openAPISecurityScheme{
+		"adminToken": {Type: "http", Scheme: "bearer"},
+	}}
+	return spec
+}
+
+// openAPIHandler serves the spec for the internal or public server. The
+// document is built once, when the mux is created.
+func openAPIHandler(public bool) http.HandlerFunc {
+	body, err := json.Marshal(newOpenAPISpec(public))
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err != nil {
+			slog.Error("failed to build OpenAPI document", "error", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "internal server error")
+			return
+		}
+		w.Header().Set(headerContentType, contentTypeJSON)
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write(body); err != nil {
+			slog.Error(errWriteResponse, "error", err)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+func fetchOpenAPI(t *testing.T, handler http.Handler) openAPISpec {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, routeOpenAPI, nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected Content-Type application/json, got '%s'", ct)
+	}
+	var spec openAPISpec
+	if err := json.Unmarshal(rec.Body.Bytes(), &spec); err != nil {
+		t.Fatalf("failed to decode spec: %v", err)
+	}
+	return spec
+}
+
+func TestOpenAPI_Internal(t *testing.T) {
+	m, reg := newTestMetrics(t)
+	spec := fetchOpenAPI(t, newInternalMux(reg, m, time.Now()))
+
+	if spec.OpenAPI != "3.0.3" {
+		t.Errorf("expected openapi 3.0.3, got '%s'", spec.OpenAPI)
+	}
+	for _, path := range []string{routeLive, routeReady, routeStartup, routeHealthz, routeVersion, routeMetrics, routeStats, routeStatsClients, routeLogsExport, routeEvents, routeAdminLogs, routeAdminLogLevel, routeAdminRateLimit, routeAdminRateLimitReset, routeAdminConfig, routeOpenAPI} {
+		if _, ok := spec.Paths[path]; !ok {
+			t.Errorf("expected %s in the internal spec", path)
+		}
+	}
+	if _, ok := spec.Paths[routePublic]; ok {
+		t.Errorf("expected %s to be left out of the internal spec", routePublic)
+	}
+	purge, ok := spec.Paths[routeAdminLogs]["delete"]
+	if !ok {
+		t.Fatalf("expected DELETE %s", routeAdminLogs)
+	}
+	if len(purge.Security) == 0 || spec.Components == nil {
+		t.Error("expected the admin route to require the bearer scheme")
+	}
+}
+
+func TestOpenAPI_Public(t *testing.T) {
+	spec := fetchOpenAPI(t, newPublicMux("test"))
+
+	var paths []string
+	for path := range spec.Paths {
+		paths = append(paths, path)
+	}
+	slices.Sort(paths)
+	if want := []string{routePublic, routeOpenAPI}; !slices.Equal(paths, want) {
+		t.Errorf("expected paths %v, got %v", want, paths)
+	}
+	ok := spec.Paths[routePublic]["get"].Responses["200"].Content[contentTypeJSON].Schema
+	if ok == nil || ok.Properties["timestamp"] == nil {
+		t.Errorf("expected the PublicResponse schema, got %+v", ok)
+	}
+}
+
+func TestSchemaOf(t *testing.T) {
+	type inner struct {
+		ID int64 `json:"id"`
+	}
+	type sample struct {
+		inner
+		Name     string            `json:"name"`
+		Note     string            `json:"note,omitempty"`
+		Count    *int64            `json:"count"`
+		Tags     []string          `json:"tags"`
+		Labels   map[string]string `json:"labels"`
+		Ratio    float64           `json:"ratio"`
+		Enabled  bool              `json:"enabled"`
+		Skipped  string            `json:"-"`
+		internal string
+	}
+	s := schemaOf(reflect.TypeOf(sample{}))
+
+	want := map[string]string{"id": "integer", "name": "string", "note": "string", "count": "integer", "tags": "array", "labels": "object", "ratio": "number", "enabled": "boolean"}
+	if len(s.Properties) != len(want) {
+		t.Errorf("expected %d properties, got %d", len(want), len(s.Properties))
+	}
+	for name, typ := range want {
+		if p := s.Properties[name]; p == nil || p.Type != typ {
+			t.Errorf("expected %s to be %s, got %+v", name, typ, p)
+		}
+	}
+	if s.Properties["tags"].Items.Type != "string" {
+		t.Errorf("expected string items, got %+v", s.Properties["tags"].Items)
+	}
+	required := []string{"id", "name", "tags", "labels", "ratio", "enabled"}
+	if !slices.Equal(s.Required, required) {
+		t.Errorf("expected required %v, got %v", required, s.Required)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// pgxForever stands in for a zero DB_CONN_MAX_LIFETIME or
+// DB_CONN_MAX_IDLE_TIME: database/sql reads zero as no limit, pgxpool as
+// an immediate one.
+const pgxForever = 100 * 365 * 24 * time.Hour
+
+// pgxConfig is the native pool configuration for dsn, sized by the same
+// DB_* limits as the database/sql pool.
+func (p dbPoolConfig) pgxConfig(dsn string) (*pgxpool.Config, error) {
+	cfg, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		return nil, err
+	}
+	cfg.MaxConns = int32(p.MaxOpenConns)
+	cfg.MinConns = 0
+	cfg.MaxConnLifetime = p.ConnMaxLifetime
+	if cfg.MaxConnLifetime == 0 {
+		cfg.MaxConnLifetime = pgxForever
+	}
+	cfg.MaxConnIdleTime = p.ConnMaxIdleTime
+	if cfg.MaxConnIdleTime == 0 {
+		cfg.MaxConnIdleTime = pgxForever
+	}
+	return cfg, nil
+}
+
+// pgxLogSink writes through a native pgx pool, skipping the database/sql
+// adapter on the hot logging path. Selected with DB_DRIVER=pgx-native.
+type pgxLogSink struct {
+	limits dbPoolConfig
+	pool   atomic.Pointer[pgxpool.Pool]
+}
+
+// newPgxLogSink opens a native pool on dsn. pgxpool connects on first use,
+// so an unreachable database only draws a warning here: the pool picks the
+// database up once it is back, as the database/sql pool does.
+func newPgxLogSink(ctx context.Context, dsn string, limits dbPoolConfig) (*pgxLogSink, error) {
+	s := &pgxLogSink{limits: limits}
+	pool, err := s.open(ctx, dsn)
+	if err != nil {
+		return nil, err
+	}
+	s.pool.Store(pool)
+	pingCtx, cancel := context.WithTimeout(ctx, readyPingTimeout)
+	defer cancel()
+	if err := pool.Ping(pingCtx); err != nil {
+		slog.Warn("native database pool can't reach the database yet", "error", redactDSNError(err, dsn))
+	}
+	return s, nil
+}
+
+// open creates a pool on dsn without connecting.
+func (s *pgxLogSink) open(ctx context.Context, dsn string) (*pgxpool.Pool, error) {
+	cfg, err := s.limits.pgxConfig(dsn)
+	if err != nil {
+		return nil, redactDSNError(err, dsn)
+	}
+	pool, err := pgxpool.NewWithConfig(ctx, cfg)
+	if err != nil {
+		return nil, redactDSNError(err, dsn)
+	}
+	return pool, nil
+}
+
+// connect opens a pool on dsn and, once a ping succeeds, swaps it in for
+// the current one, which is closed after its in-flight inserts finish.
+func (s *pgxLogSink) connect(ctx context.Context, dsn string) error {
+	pool, err := s.open(ctx, dsn)
+	if err != nil {
+		return err
+	}
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return redactDSNError(err, dsn)
+	}
+	if old := s.pool.Swap(pool); old != nil {
+		old.Close()
+	}
+	return nil
+}
+
+func (s *pgxLogSink) WriteLog(ctx context.Context, e logEntry) error {
+	pool := s.pool.Load()
+	if pool == nil {
+		return errNoDB
+	}
+	_, err := pool.Exec(ctx, insertLogSQL, e.method, e.endpoint, e.status, e.durationMs, e.ttfbMs, e.remoteAddr, e.traceID(), e.rateLimited)
+	return err
+}
+
+// Close closes the pool; later writes report errNoDB.
+func (s *pgxLogSink) Close() {
+	if pool := s.pool.Swap(nil); pool != nil {
+		pool.Close()
+	}
+}
+
+// pgxPoolCollector exports the native pool's statistics under the names
+// dbStatsCollector uses for the database/sql pool, prefixed db_native_.
+type pgxPoolCollector struct {
+	sink            *pgxLogSink
+	openConnections *prometheus.Desc
+	inUse           *prometheus.Desc
+	idle            *prometheus.Desc
+	waitCount       *prometheus.Desc
+	waitDuration    *prometheus.Desc
+	maxOpen         *prometheus.Desc
+}
+
+func newPgxPoolCollector(sink *pgxLogSink) *pgxPoolCollector {
+	return &pgxPoolCollector{
+		sink:            sink,
+		openConnections: prometheus.NewDesc("db_native_open_connections", "Established native pool connections, in use and idle", nil, nil),
+		inUse:           prometheus.NewDesc("db_native_in_use", "Native pool connections currently in use", nil, nil),
+		idle:            prometheus.NewDesc("db_native_idle", "Idle native pool connections", nil, nil),
+		waitCount:       prometheus.NewDesc("db_native_wait_count", "Total native pool acquires that waited for a connection", nil, nil),
+		waitDuration:    prometheus.NewDesc("db_native_wait_duration_seconds_total", "Total time blocked waiting for a native pool connection", nil, nil),
+		maxOpen:         prometheus.NewDesc("db_native_max_open_connections", "Maximum number of native pool connections", nil, nil),
+	}
+}
+
+func (c *pgxPoolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.openConnections
+	ch <- c.inUse
+	ch <- c.idle
+	ch <- c.waitCount
+	ch <- c.waitDuration
+	ch <- c.maxOpen
+}
+
+func (c *pgxPoolCollector) Collect(ch chan<- prometheus.Metric) {
+	pool := c.sink.pool.Load()
+	if pool == nil {
+		return
+	}
+	s := pool.Stat()
+	ch <- prometheus.MustNewConstMetric(c.openConnections, prometheus.GaugeValue, float64(s.TotalConns()))
+	ch <- prometheus.MustNewConstMetric(c.inUse, prometheus.GaugeValue, float64(s.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(c.idle, prometheus.GaugeValue, float64(s.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(c.waitCount, prometheus.CounterValue, float64(s.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.waitDuration, prometheus.CounterValue, s.EmptyAcquireWaitTime().Seconds())
+	ch <- prometheus.MustNewConstMetric(c.maxOpen, prometheus.GaugeValue, float64(s.MaxConns()))
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"net/http"
+	"net/http/pprof"
+)
+
+// routePprof is the prefix of the profiling routes; every profile below it
+// shares this one route label.
+const routePprof = "/debug/pprof/"
+
+// pprofEnabled is set by main from ENABLE_PPROF.
+var pprofEnabled bool
+
+// registerPprof mounts the net/http/pprof handlers under routePprof behind
+// the admin token. CPU profiles and traces run for ?seconds=, which must stay
+// below the server's write timeout.
+func registerPprof(rt *router) {
+	registerRoute(rt, routePprof, methods(adminHandler(pprof.Index), http.MethodGet, http.MethodHead))
+	registerRoute(rt, routePprof+"cmdline", methods(adminHandler(pprof.Cmdline), http.MethodGet, http.MethodHead))
+	registerRoute(rt, routePprof+"profile", methods(adminHandler(pprof.Profile), http.MethodGet))
+	registerRoute(rt, routePprof+"symbol", methods(adminHandler(pprof.Symbol), http.MethodGet, http.MethodHead, http.MethodPost))
+	registerRoute(rt, routePprof+"trace", methods(adminHandler(pprof.Trace), http.MethodGet))
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func withPprof(t *testing.T, enabled bool) {
+	t.Helper()
+	prev := pprofEnabled
+	pprofEnabled = enabled
+	t.Cleanup(func() { pprofEnabled = prev })
+}
+
+func TestPprof_DisabledByDefault(t *testing.T) {
+	withAdminToken(t, "s3cret")
+	m, reg := newTestMetrics(t)
+	rt := newInternalMux(reg, m, time.Now())
+
+	req := httptest.NewRequest(http.MethodGet, routePprof, nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rec := httptest.NewRecorder()
+	rt.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 without ENABLE_PPROF, got %d", rec.Code)
+	}
+}
+
+func TestPprof_Enabled(t *testing.T) {
+	withPprof(t, true)
+	withAdminToken(t, "s3cret")
+	m, reg := newTestMetrics(t)
+	rt := newInternalMux(reg, m, time.Now())
+
+	tests := []struct {
+		path  string
+		token string
+		want  int
+	}{
+		{routePprof, "s3cret", http.StatusOK},
+		{routePprof + "heap", "s3cret", http.StatusOK},
+		{routePprof + "cmdline", "s3cret", http.StatusOK},
+		{routePprof, "", http.StatusUnauthorized},
+		{routePprof + "goroutine", "wrong", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		rec := httptest.NewRecorder()
+		rt.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s with token %q: expected %d, got %d", tt.path, tt.token, tt.want, rec.Code)
+		}
+	}
+
+	if got := rt.routePattern(routePprof + "heap"); got != routePprof {
+		t.Errorf("expected named profiles under the %s route, got %q", routePprof, got)
+	}
+}
+
+func TestPprof_NotWrittenToAccessLog(t *testing.T) {
+	withPprof(t, true)
+	withAdminToken(t, "s3cret")
+	prev := logBuffer
+	logBuffer = make(chan logEntry, 2)
+	defer func() { logBuffer = prev }()
+
+	m, reg := newTestMetrics(t)
+	handler := metricsMiddleware(m, newInternalMux(reg, m, time.Now()))
+	for _, path := range []string{routePprof + "heap", routeLive} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer s3cret")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	if n := len(logBuffer); n != 1 {
+		t.Fatalf("expected only the %s request in the access log, got %d entries", routeLive, n)
+	}
+	if entry := <-logBuffer; entry.endpoint != routeLive {
+		t.Errorf("expected %s, got %s", routeLive, entry.endpoint)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// rateSpec is a RATE_LIMIT value: count requests per window.
+type rateSpec struct {
+	count  float64
+	window time.Duration
+}
+
+// rateWindows are the window names RATE_LIMIT accepts after the slash.
+var rateWindows = map[string]time.Duration{
+	"s": time.Second, "sec": time.Second, "second": time.Second,
+	"m": time.Minute, "min": time.Minute, "minute": time.Minute,
+	"h": time.Hour, "hour": time.Hour,
+	"d": 24 * time.Hour, "day": 24 * time.Hour,
+}
+
+// parseRate accepts a positive number of requests per second, such as 100
+// or 0.5, or a count per window: 30/minute, 10/hour, 1000/day or 5/10s.
+func parseRate(s string) (rateSpec, error) {
+	count, window, perWindow := strings.Cut(strings.TrimSpace(s), "/")
+	n, err := strconv.ParseFloat(count, 64)
+	if err != nil || !(n > 0) || math.IsInf(n, 0) {
+		return rateSpec{}, fmt.Errorf("want a rate such as 100, 0.5 or 30/minute, got %q", s)
+	}
+	spec := rateSpec{count: n, window: time.Second}
+	if !perWindow {
+		return spec, nil
+	}
+	if d, ok := rateWindows[strings.ToLower(window)]; ok {
+		spec.window = d
+		return spec, nil
+	}
+	d, err := time.ParseDuration(window)
+	if err != nil || d <= 0 {
+		return rateSpec{}, fmt.Errorf("want a window of second, minute, hour, day or a positive duration, got %q", window)
+	}
+	spec.window = d
+	return spec, nil
+}
+
+// limit is the rate in requests per second.
+func (r rateSpec) limit() rate.Limit {
+	return rate.Limit(r.count / r.window.Seconds())
+}
+
+// burst is a second's worth of requests, and at least one so a rate below
+// one per second still lets requests through.
+func (r rateSpec) burst() int {
+	return max(1, int(math.Ceil(float64(r.limit()))))
+}
+
+func (r rateSpec) String() string {
+	count := strconv.FormatFloat(r.count, 'f', -1, 64)
+	switch r.window {
+	case time.Second:
+		return count + "/second"
+	case time.Minute:
+		return count + "/minute"
+	case time.Hour:
+		return count + "/hour"
+	case 24 * time.Hour:
+		return count + "/day"
+	}
+	return count + "/" + r.window.String()
+}
+
+// newLimiter builds the limiter for r.
+func (r rateSpec) newLimiter() *rateLimiter {
+	l := newRateLimiter(r.limit(), r.burst())
+	l.spec = r.String()
+	return l
+}
+
+// rateLimiter is the token bucket shared by every request to the internal
+// server. It sits behind a pointer swap so an operator can reset it, and
+// set changes its rate in place on a configuration reload.
+type rateLimiter struct {
+	// mu guards the settings below, which a reload changes while requests
+	// and the admin routes read them.
+	mu    sync.Mutex
+	limit rate.Limit
+	burst int
+	// spec is the RATE_LIMIT the limiter was built from, such as
+	// 30/minute.
+	spec    string
+	current atomic.Pointer[rate.Limiter]
+}
+
+func newRateLimiter(limit rate.Limit, burst int) *rateLimiter {
+	l := &rateLimiter{limit: limit, burst: burst}
+	l.reset()
+	return l
+}
+
+func (l *rateLimiter) allow() bool {
+	return l.current.Load().Allow()
+}
+
+// reset starts over with a full bucket.
+func (l *rateLimiter) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.current.Store(rate.NewLimiter(l.limit, l.burst))
+}
+
+// set switches to the rate r allows, keeping the tokens already in the
+// bucket up to the new burst.
+func (l *rateLimiter) set(r rateSpec) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limit, l.burst, l.spec = r.limit(), r.burst(), r.String()
+	bucket := l.current.Load()
+	bucket.SetLimit(l.limit)
+	bucket.SetBurst(l.burst)
+}
+
+// apiLimiter is the internal server's limiter; main sets it from RATE_LIMIT.
+var apiLimiter *rateLimiter
+
+// RateLimitResponse is the JSON body of /admin/ratelimit and its reset.
+// Limiting is global today, so Clients is always empty; it lists the most
+// limited clients once limiting is tracked per client.
+type RateLimitResponse struct {
+	Status  string            `json:"status"`
+	Scope   string            `json:"scope"`
+	Limit   string            `json:"limit,omitempty"`
+	Rate    float64           `json:"rate"`
+	Burst   int               `json:"burst"`
+	Tokens  float64           `json:"tokens"`
+	Clients []RateLimitClient `json:"clients"`
+}
+
+// RateLimitClient is one tracked client and its remaining tokens.
+type RateLimitClient struct {
+	Client string  `json:"client"`
+	Tokens float64 `json:"tokens"`
+}
+
+func (l *rateLimiter) response(now time.Time) RateLimitResponse {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return RateLimitResponse{
+		Status:  "ok",
+		Scope:   "global",
+		Limit:   l.spec,
+		Rate:    float64(l.limit),
+		Burst:   l.burst,
+		Tokens:  l.current.Load().TokensAt(now),
+		Clients: []RateLimitClient{},
+	}
+}
+
+// isRateLimitAdmin reports whether path is one of the rate limit admin
+// routes, which must stay reachable while the limiter is rejecting traffic.
+func isRateLimitAdmin(path string) bool {
+	return path == routeAdminRateLimit || strings.HasPrefix(path, routeAdminRateLimit+"/")
+}
+
+// rateLimitHandler reports the limiter's configuration and remaining tokens.
+func rateLimitHandler(w http.ResponseWriter, r *http.Request) {
+	writeRateLimitState(w)
+}
+
+// rateLimitResetHandler refills the limiter after a false-positive flood.
+func rateLimitResetHandler(w http.ResponseWriter, r *http.Request) {
+	if apiLimiter != nil {
+		apiLimiter.reset()
+		slog.Warn("rate limiter reset")
+	}
+	writeRateLimitState(w)
+}
+
+func writeRateLimitState(w http.ResponseWriter) {
+	if apiLimiter == nil {
+		writeError(w, http.StatusServiceUnavailable, codeUnavailable, "rate limiter not configured")
+		return
+	}
+	writeJSON(w, http.StatusOK, apiLimiter.response(time.Now()))
+}
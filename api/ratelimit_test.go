@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"golang.org/x/time/rate"
+)
+
+func withAPILimiter(t *testing.T, l *rateLimiter) {
+	t.Helper()
+	prev := apiLimiter
+	apiLimiter = l
+	t.Cleanup(func() { apiLimiter = prev })
+}
+
+func decodeRateLimit(t *testing.T, rec *httptest.ResponseRecorder) RateLimitResponse {
+	t.Helper()
+	var resp RateLimitResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	return resp
+}
+
+// newLimitedInternalServer serves the internal routes behind l, the way
+// main wires them.
+func newLimitedInternalServer(t *testing.T, l *rateLimiter) http.Handler {
+	t.Helper()
+	withAPILimiter(t, l)
+	withAdminToken(t, "s3cret")
+	m, reg := newTestMetrics(t)
+	mux := newInternalMux(reg, m, time.Now())
+	return metricsMiddleware(m, mux, rateLimitMiddleware(l, m, mux))
+}
+
+func adminRequest(method, target string) *http.Request {
+	req := httptest.NewRequest(method, target, nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	return req
+}
+
+func TestRateLimitAdmin_ReportsState(t *testing.T) {
+	handler := newLimitedInternalServer(t, newRateLimiter(rate.Limit(0), 2))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, routeLive, nil))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, adminRequest(http.MethodGet, routeAdminRateLimit))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	resp := decodeRateLimit(t, rec)
+	if resp.Scope != "global" || resp.Rate != 0 || resp.Burst != 2 || resp.Tokens != 1 {
+		t.Errorf("unexpected state %+v", resp)
+	}
+	if resp.Clients == nil || len(resp.Clients) != 0 {
+		t.Errorf("expected an empty clients list, got %v", resp.Clients)
+	}
+}
+
+func TestRateLimitAdmin_ResetWhileLimited(t *testing.T) {
+	handler := newLimitedInternalServer(t, newRateLimiter(rate.Limit(0), 1))
+	for _, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, routeLive, nil))
+		if rec.Code != want {
+			t.Fatalf("expected %d, got %d", want, rec.Code)
+		}
+	}
+
+	// The admin routes stay reachable with an empty bucket.
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, adminRequest(http.MethodGet, routeAdminRateLimit))
+	if rec.Code != http.StatusOK || decodeRateLimit(t, rec).Tokens != 0 {
+		t.Fatalf("expected an empty bucket, got %d: %s", rec.Code, rec.Body)
+	}
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, adminRequest(http.MethodPost, routeAdminRateLimitReset))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 from reset, got %d", rec.Code)
+	}
+	if resp := decodeRateLimit(t, rec); resp.Tokens != 1 {
+		t.Errorf("expected a full bucket after reset, got %+v", resp)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, routeLive, nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected traffic to flow after reset, got %d", rec.Code)
+	}
+}
+
+func TestRateLimitAdmin_RequiresAdminToken(t *testing.T) {
+	handler := newLimitedInternalServer(t, newRateLimiter(rate.Limit(10), 10))
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, routeAdminRateLimit, nil),
+		httptest.NewRequest(http.MethodPost, routeAdminRateLimitReset, nil),
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("%s %s: expected 401, got %d", req.Method, req.URL.Path, rec.Code)
+		}
+	}
+}
+
+func TestRateLimitAdmin_NotConfigured(t *testing.T) {
+	withAPILimiter(t, nil)
+	rec := httptest.NewRecorder()
+	rateLimitHandler(rec, httptest.NewRequest(http.MethodGet, routeAdminRateLimit, nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 without a limiter, got %d", rec.Code)
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		in        string
+		want      string
+		perSecond float64
+		burst     int
+	}{
+		{"100", "100/second", 100, 100},
+		{"0.5", "0.5/second", 0.5, 1},
+		{" 2.5 ", "2.5/second", 2.5, 3},
+		{"30/minute", "30/minute", 0.5, 1},
+		{"30/MIN", "30/minute", 0.5, 1},
+		{"120/m", "120/minute", 2, 2},
+		{"100/second", "100/second", 100, 100},
+		{"5/s", "5/second", 5, 5},
+		{"36000/hour", "36000/hour", 10, 10},
+		{"10/h", "10/hour", 10.0 / 3600, 1},
+		{"86400/day", "86400/day", 1, 1},
+		{"5/10s", "5/10s", 0.5, 1},
+		{"1/1m", "1/minute", 1.0 / 60, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			spec, err := parseRate(tt.in)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if spec.String() != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, spec)
+			}
+			if got := float64(spec.limit()); got != tt.perSecond {
+				t.Errorf("expected %v requests per second, got %v", tt.perSecond, got)
+			}
+			if got := spec.burst(); got != tt.burst {
+				t.Errorf("expected a burst of %d, got %d", tt.burst, got)
+			}
+		})
+	}
+}
+
+func TestParseRate_Invalid(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"", "want a rate"},
+		{"lots", "want a rate"},
+		{"0", "want a rate"},
+		{"-5", "want a rate"},
+		{"NaN", "want a rate"},
+		{"Inf", "want a rate"},
+		{"/minute", "want a rate"},
+		{"30/fortnight", "want a window"},
+		{"30/", "want a window"},
+		{"30/-1s", "want a window"},
+		{"30/0s", "want a window"},
+		{"30/minute/2", "want a window"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if _, err := parseRate(tt.in); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected an error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestLoadConfig_RateLimitFallback(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv("RATE_LIMIT", "lots/minute")
+	cfg, err := LoadConfig(nil)
+	if err != nil {
+		t.Fatalf("expected an invalid RATE_LIMIT not to fail startup, got %v", err)
+	}
+	if cfg.RateLimit != defaultConfig().RateLimit {
+		t.Errorf("expected the default rate, got %s", cfg.RateLimit)
+	}
+	if w := errors.Join(cfg.warnings...); w == nil || !strings.Contains(w.Error(), "RATE_LIMIT: want a rate") {
+		t.Errorf("expected a RATE_LIMIT warning, got %v", w)
+	}
+}
+
+func TestRateLimitAdmin_ReportsSpec(t *testing.T) {
+	spec, err := parseRate("30/minute")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := newLimitedInternalServer(t, spec.newLimiter())
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, adminRequest(http.MethodGet, routeAdminRateLimit))
+	resp := decodeRateLimit(t, rec)
+	if resp.Limit != "30/minute" || resp.Rate != 0.5 || resp.Burst != 1 {
+		t.Errorf("expected the parsed rate in the view, got %+v", resp)
+	}
+}
+
+func TestRateLimitMiddleware_LogsRejection(t *testing.T) {
+	logs := captureLogs(t)
+	prev := logBuffer
+	logBuffer = make(chan logEntry, 2)
+	t.Cleanup(func() { logBuffer = prev })
+	withAPILimiter(t, newRateLimiter(rate.Limit(0), 1))
+	m, reg := newTestMetrics(t)
+	mux := newInternalMux(reg, m, time.Now())
+	handler := metricsMiddleware(m, mux, rateLimitMiddleware(apiLimiter, m, mux))
+
+	for _, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, routeLive, nil))
+		if rec.Code != want {
+			t.Fatalf("expected %d, got %d", want, rec.Code)
+		}
+	}
+
+	if allowed := <-logBuffer; allowed.rateLimited {
+		t.Error("expected the allowed request not marked rate limited")
+	}
+	rejected := <-logBuffer
+	if rejected.status != http.StatusTooManyRequests || !rejected.rateLimited {
+		t.Fatalf("expected a rate limited 429 entry, got %+v", rejected)
+	}
+	mockDB, mock, _ := sqlmock.New()
+	defer func() { _ = mockDB.Close() }()
+	dbMu.Lock()
+	db = mockDB
+	dbMu.Unlock()
+	t.Cleanup(func() {
+		dbMu.Lock()
+		db = nil
+		dbMu.Unlock()
+	})
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO api_logs")).
+		WithArgs("GET", routeLive, http.StatusTooManyRequests, sqlmock.AnyArg(), sqlmock.AnyArg(), rejected.remoteAddr, nil, true).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	if err := (sqlLogSink{}).WriteLog(context.Background(), rejected); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	if !strings.Contains(logs.String(), `"status":429,`) || strings.Count(logs.String(), `"rate_limited":true`) != 1 {
+		t.Errorf("expected one request completed line marked rate limited, got %s", logs)
+	}
+
+	// The rejection is one request in http_requests_total and one in
+	// http_rate_limited_total, which breaks it down by client class.
+	tooMany := http.StatusText(http.StatusTooManyRequests)
+	for _, c := range []struct {
+		name string
+		got  float64
+	}{
+		{"http_requests_total", testutil.ToFloat64(m.requestsTotal.WithLabelValues(http.MethodGet, routeLive, tooMany, "false"))},
+		{"http_errors_total", testutil.ToFloat64(m.errorsTotal.WithLabelValues(http.MethodGet, routeLive, tooMany, "false"))},
+		{"http_rate_limited_total", testutil.ToFloat64(m.rateLimitedTotal.WithLabelValues(routeLive, clientExternal))},
+	} {
+		if c.got != 1 {
+			t.Errorf("expected the rejection counted once in %s, got %v", c.name, c.got)
+		}
+	}
+	if n := testutil.CollectAndCount(m.requestsTotal); n != 2 {
+		t.Errorf("expected a 200 and a 429 series in http_requests_total, got %d", n)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+)
+
+// WriteDB returns the primary pool, which takes every write and the reads
+// that must see them, or nil while the database isn't connected.
+func WriteDB() *sql.DB {
+	dbMu.RLock()
+	defer dbMu.RUnlock()
+	return db
+}
+
+// ReadDB returns the pool for the heavy analytical reads, /api/v1/stats and
+// the log export: the DB_READ_DSN replica when one is configured, otherwise
+// the primary.
+func ReadDB() *sql.DB {
+	dbMu.RLock()
+	defer dbMu.RUnlock()
+	if readDB != nil {
+		return readDB
+	}
+	return db
+}
+
+// openReadDB opens the replica pool for dsn, sized like the primary. It
+// doesn't run migrations, which only the primary can apply, and doesn't
+// wait for the replica: the pool connects on first use, and replicaChecker
+// reports it while it is down.
+func openReadDB(ctx context.Context, dsn string) (*sql.DB, error) {
+	d, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, redactDSNError(err, dsn)
+	}
+	dbPool.apply(d)
+	pingCtx, cancel := context.WithTimeout(ctx, readyPingTimeout)
+	defer cancel()
+	if err := d.PingContext(pingCtx); err != nil {
+		slog.Warn("read replica unreachable, reads will go to it once it answers", "error", redactDSNError(err, dsn))
+	}
+	return d, nil
+}
+
+// replicaChecker pings the DB_READ_DSN replica within readyPingTimeout.
+// main adds it as an optional check, so a lost replica degrades readiness
+// without taking the API out of rotation.
+type replicaChecker struct{}
+
+func (replicaChecker) Name() string { return "database_replica" }
+
+func (replicaChecker) Check(ctx context.Context) error {
+	dbMu.RLock()
+	d := readDB
+	dbMu.RUnlock()
+	if d == nil {
+		return errors.New("db replica not configured")
+	}
+	pingCtx, cancel := context.WithTimeout(ctx, readyPingTimeout)
+	defer cancel()
+	if err := d.PingContext(pingCtx); err != nil {
+		if errors.Is(pingCtx.Err(), context.DeadlineExceeded) {
+			return errors.New("db replica ping timeout")
+		}
+		return errors.New("db replica unreachable")
+	}
+	return nil
+}
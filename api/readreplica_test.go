@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// withDBs points db at primary and readDB at replica for the duration of
+// the test.
+func withDBs(t *testing.T, primary, replica *sql.DB) {
+	t.Helper()
+	dbMu.Lock()
+	db, readDB = primary, replica
+	dbMu.Unlock()
+	t.Cleanup(func() {
+		dbMu.Lock()
+		db, readDB = nil, nil
+		dbMu.Unlock()
+	})
+}
+
+func TestReadDB_DefaultsToPrimary(t *testing.T) {
+	primary, _, _ := sqlmock.New()
+	defer func() { _ = primary.Close() }()
+	replica, _, _ := sqlmock.New()
+	defer func() { _ = replica.Close() }()
+
+	withDBs(t, primary, nil)
+	if ReadDB() != primary || WriteDB() != primary {
+		t.Error("expected reads and writes on the primary without a replica")
+	}
+	withDBs(t, primary, replica)
+	if ReadDB() != replica || WriteDB() != primary {
+		t.Error("expected reads on the replica and writes on the primary")
+	}
+	withDBs(t, nil, nil)
+	if ReadDB() != nil || WriteDB() != nil {
+		t.Error("expected no pool before the database connects")
+	}
+}
+
+func TestDBRouting(t *testing.T) {
+	primary, primaryMock, _ := sqlmock.New()
+	defer func() { _ = primary.Close() }()
+	replica, replicaMock, _ := sqlmock.New()
+	defer func() { _ = replica.Close() }()
+	withDBs(t, primary, replica)
+
+	// Each mock fails any statement it wasn't told to expect, so a query
+	// on the wrong pool shows up as an error.
+	replicaMock.ExpectQuery(regexp.QuoteMeta(statsQuery("method"))).
+		WillReturnRows(sqlmock.NewRows([]string{"key", "requests", "errors", "p50", "p95", "p99"}))
+	primaryMock.ExpectExec(regexp.QuoteMeta("INSERT INTO api_logs")).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	rec := httptest.NewRecorder()
+	statsHandler(rec, httptest.NewRequest(http.MethodGet, "/api/v1/stats?group_by=method", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected the stats query to succeed on the replica, got %d: %s", rec.Code, rec.Body)
+	}
+	if err := (sqlLogSink{}).WriteLog(context.Background(), testLogEntry); err != nil {
+		t.Errorf("expected the insert to succeed on the primary, got %v", err)
+	}
+	if err := replicaMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("replica: %s", err)
+	}
+	if err := primaryMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("primary: %s", err)
+	}
+}
+
+func TestReadyHandler_ReplicaDegrades(t *testing.T) {
+	primary, primaryMock, _ := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	defer func() { _ = primary.Close() }()
+	replica, replicaMock, _ := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	defer func() { _ = replica.Close() }()
+	withDBs(t, primary, replica)
+	prev := readyChecks
+	readyChecks = append(readyChecks[:len(readyChecks):len(readyChecks)], readinessCheck{Checker: replicaChecker{}})
+	defer func() { readyChecks = prev }()
+
+	primaryMock.ExpectPing()
+	replicaMock.ExpectPing().WillReturnError(errors.New("connection refused"))
+
+	rec := httptest.NewRecorder()
+	readyHandler(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	var resp readyResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if rec.Code != http.StatusOK || resp.Status != "degraded" {
+		t.Fatalf("expected 200 degraded when only the replica is down, got %d %+v", rec.Code, resp)
+	}
+	last := resp.Checks[len(resp.Checks)-1]
+	if last.Name != "database_replica" || last.OK || last.Required || last.Error != "db replica unreachable" {
+		t.Errorf("expected a failing optional database_replica check, got %+v", last)
+	}
+	if err := replicaMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("replica: %s", err)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	defaultReadinessInterval = 15 * time.Second
+	// readinessNotifyAttempts bounds the deliveries tried for one change.
+	readinessNotifyAttempts = 3
+	// readinessNotifyTimeout bounds a single delivery.
+	readinessNotifyTimeout = 5 * time.Second
+)
+
+// readinessRetryDelay is the pause before the first redelivery, doubling
+// for each one after; a variable so tests needn't wait.
+var readinessRetryDelay = time.Second
+
+// Outcomes counted in readiness_notifications_total.
+const (
+	notificationSent   = "sent"
+	notificationFailed = "failed"
+)
+
+// readinessNotification is the JSON body POSTed to READINESS_WEBHOOK_URL
+// when the service turns unready or ready again.
+type readinessNotification struct {
+	Service      string        `json:"service"`
+	Version      string        `json:"version"`
+	Env          string        `json:"env"`
+	Ready        bool          `json:"ready"`
+	Status       string        `json:"status"`
+	Message      string        `json:"message,omitempty"`
+	FailedChecks []checkResult `json:"failed_checks"`
+	Time         string        `json:"time"`
+}
+
+// readinessWatcher runs the readiness checks every interval and notifies a
+// webhook when the outcome flips between ready and unready. The first
+// evaluation only sets the baseline, and nothing is sent while the state
+// holds, so a stable service stays quiet however often it is checked.
+// Degraded counts as ready, as it does on /ready.
+type readinessWatcher struct {
+	url      string
+	service  string
+	env      string
+	interval time.Duration
+	check    func(context.Context) readyResult
+	client   *http.Client
+	outcomes *prometheus.CounterVec
+
+	// known is set once the baseline is taken; ready is the last state.
+	known bool
+	ready bool
+}
+
+// newReadinessWatcher creates a watcher that evaluates checkReady every
+// interval and posts changes for service in env to url. outcomes counts
+// each notification under its outcome label.
+func newReadinessWatcher(url, service, env string, interval time.Duration, outcomes *prometheus.CounterVec) *readinessWatcher {
+	return &readinessWatcher{
+		url:      url,
+		service:  service,
+		env:      env,
+		interval: interval,
+		check:    checkReady,
+		client:   &http.Client{Timeout: readinessNotifyTimeout},
+		outcomes: outcomes,
+	}
+}
+
+// Run evaluates readiness every interval until ctx is cancelled.
+func (w *readinessWatcher) Run(ctx context.Context) {
+	slog.Info("readiness watcher started", "interval", w.interval.String())
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.evaluate(ctx)
+		}
+	}
+}
+
+// evaluate runs the checks once and notifies when the state has changed
+// since the last evaluation.
+func (w *readinessWatcher) evaluate(ctx context.Context) {
+	res := w.check(ctx)
+	if ctx.Err() != nil {
+		// Checks cut short by shutdown say nothing about the dependencies.
+		return
+	}
+	ready := res.code == http.StatusOK
+	if !w.known {
+		w.known, w.ready = true, ready
+		return
+	}
+	if ready == w.ready {
+		return
+	}
+	w.ready = ready
+	n := readinessNotification{
+		Service:      w.service,
+		Version:      version,
+		Env:          w.env,
+		Ready:        ready,
+		Status:       res.status,
+		Message:      res.message,
+		FailedChecks: []checkResult{},
+		Time:         time.Now().UTC().Format(time.RFC3339Nano),
+	}
+	for _, c := range res.checks {
+		if !c.OK {
+			n.FailedChecks = append(n.FailedChecks, c)
+		}
+	}
+	slog.Warn("readiness changed", "ready", ready, "status", res.status, "message", res.message)
+	w.notify(ctx, n)
+}
+
+// notify delivers n, retrying with a doubling delay up to
+// readinessNotifyAttempts times.
+func (w *readinessWatcher) notify(ctx context.Context, n readinessNotification) {
+	body, err := json.Marshal(n)
+	if err != nil {
+		w.outcomes.WithLabelValues(notificationFailed).Inc()
+		slog.Warn("readiness notification failed", "error", err)
+		return
+	}
+	delay := readinessRetryDelay
+	for attempt := 1; ; attempt++ {
+		if err = w.post(ctx, body); err == nil {
+			w.outcomes.WithLabelValues(notificationSent).Inc()
+			return
+		}
+		if attempt == readinessNotifyAttempts {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-time.After(delay):
+			delay *= 2
+			continue
+		}
+		break
+	}
+	w.outcomes.WithLabelValues(notificationFailed).Inc()
+	slog.Warn("readiness notification failed", "ready", n.Ready, "error", err)
+}
+
+func (w *readinessWatcher) post(ctx context.Context, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, readinessNotifyTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set(headerContentType, contentTypeJSON)
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// trustedProxies is set by main from TRUSTED_PROXIES. Forwarding headers are
+// only believed when the direct peer falls in one of these prefixes.
+var trustedProxies []netip.Prefix
+
+// parseTrustedProxies parses a comma-separated list of CIDRs; a bare address
+// is taken as a single-host prefix.
+func parseTrustedProxies(s string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid entry %q: want a CIDR or IP address", entry)
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+func isTrustedProxy(addr netip.Addr) bool {
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Client classes label requests by where they came from without recording
+// addresses, which would make for unbounded metric labels.
+const (
+	clientInternal = "internal"
+	clientExternal = "external"
+)
+
+// clientClass reports whether the client that made r, as clientIP resolves
+// it, is inside TRUSTED_PROXIES. Anything else, including an address that
+// doesn't parse, is external.
+func clientClass(r *http.Request) string {
+	if addr, err := netip.ParseAddr(clientIP(r)); err == nil && isTrustedProxy(addr.Unmap()) {
+		return clientInternal
+	}
+	return clientExternal
+}
+
+// clientIP returns the address of the client that made r. When the direct
+// peer is a trusted proxy it is taken from Forwarded, X-Forwarded-For or
+// X-Real-IP, in that order of preference, walking the chain right to left
+// past further trusted hops. Otherwise the headers are ignored, since anyone
+// can send them, and the peer address is used.
+func clientIP(r *http.Request) string {
+	peer := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(peer); err == nil {
+		peer = host
+	}
+	peerAddr, err := netip.ParseAddr(peer)
+	if err != nil || !isTrustedProxy(peerAddr.Unmap()) {
+		return peer
+	}
+
+	if hops := forwardedFor(r.Header.Values("Forwarded")); len(hops) > 0 {
+		return walkHops(hops, peer)
+	}
+	if hops := splitHeaderList(r.Header.Values("X-Forwarded-For")); len(hops) > 0 {
+		return walkHops(hops, peer)
+	}
+	if addr, ok := parseHop(r.Header.Get("X-Real-IP")); ok {
+		return addr.String()
+	}
+	return peer
+}
+
+// walkHops returns the rightmost hop that isn't a trusted proxy. It stops at
+// the first hop it can't parse, keeping the last good one, and returns the
+// leftmost hop when every hop is trusted.
+func walkHops(hops []string, peer string) string {
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, ok := parseHop(hops[i])
+		if !ok {
+			break
+		}
+		client = addr.String()
+		if !isTrustedProxy(addr) {
+			break
+		}
+	}
+	return client
+}
+
+// parseHop parses one hop as written by proxies: an IP address, optionally
+// quoted, with an optional port and IPv6 brackets.
+func parseHop(hop string) (netip.Addr, bool) {
+	hop = strings.Trim(strings.TrimSpace(hop), `"`)
+	if addrPort, err := netip.ParseAddrPort(hop); err == nil {
+		return addrPort.Addr().Unmap(), true
+	}
+	addr, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(hop, "["), "]"))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+// splitHeaderList flattens comma-separated header values, across repeated
+// headers, into one list.
+func splitHeaderList(values []string) []string {
+	var items []string
+	for _, v := range values {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+	}
+	return items
+}
+
+// forwardedFor extracts the for= node of each element of RFC 7239 Forwarded
+// headers. Elements without one are kept as empty hops, which end the walk.
+func forwardedFor(values []string) []string {
+	var hops []string
+	for _, element := range splitHeaderList(values) {
+		hop := ""
+		for _, pair := range strings.Split(element, ";") {
+			key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if ok && strings.EqualFold(key, "for") {
+				hop = value
+			}
+		}
+		hops = append(hops, hop)
+	}
+	return hops
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+// withTrustedProxies sets trustedProxies for the duration of the test.
+func withTrustedProxies(t *testing.T, cidrs string) {
+	t.Helper()
+	prev := trustedProxies
+	prefixes, err := parseTrustedProxies(cidrs)
+	if err != nil {
+		t.Fatalf("invalid test CIDRs: %v", err)
+	}
+	trustedProxies = prefixes
+	t.Cleanup(func() { trustedProxies = prev })
+}
+
+func TestClientIP(t *testing.T) {
+	withTrustedProxies(t, "10.0.0.0/8,fd00::/8")
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		want       string
+	}{
+		{"no headers", "10.0.0.1:1234", nil, "10.0.0.1"},
+		{"xff single", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "203.0.113.7"}, "203.0.113.7"},
+		{"xff skips trusted hops", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "203.0.113.7, 198.51.100.2, 10.1.2.3"}, "198.51.100.2"},
+		{"xff all trusted", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "10.9.9.9, 10.1.2.3"}, "10.9.9.9"},
+		{"xff garbage stops walk", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "nonsense, 10.1.2.3"}, "10.1.2.3"},
+		{"x-real-ip", "10.0.0.1:1234", map[string]string{"X-Real-IP": "203.0.113.7"}, "203.0.113.7"},
+		{"x-real-ip invalid", "10.0.0.1:1234", map[string]string{"X-Real-IP": "nonsense"}, "10.0.0.1"},
+		{"forwarded", "10.0.0.1:1234", map[string]string{"Forwarded": `for=203.0.113.7;proto=https, for="[fd00::1]:443"`}, "203.0.113.7"},
+		{"forwarded ipv6", "10.0.0.1:1234", map[string]string{"Forwarded": `for="[2001:db8::1]:4711"`}, "2001:db8::1"},
+		{"forwarded preferred over xff", "10.0.0.1:1234", map[string]string{"Forwarded": "for=203.0.113.7", "X-Forwarded-For": "198.51.100.2"}, "203.0.113.7"},
+		{"forwarded obfuscated", "10.0.0.1:1234", map[string]string{"Forwarded": "for=_hidden, for=10.1.2.3"}, "10.1.2.3"},
+		{"ipv6 peer", "[fd00::2]:1234", map[string]string{"X-Forwarded-For": "203.0.113.7"}, "203.0.113.7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			if got := clientIP(req); got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestClientClass(t *testing.T) {
+	withTrustedProxies(t, "10.0.0.0/8")
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor string
+		want         string
+	}{
+		{"trusted peer", "10.0.0.1:1234", "", clientInternal},
+		{"trusted chain", "10.0.0.1:1234", "10.9.9.9", clientInternal},
+		{"forwarded for an outside client", "10.0.0.1:1234", "203.0.113.7", clientExternal},
+		{"outside peer", "203.0.113.7:1234", "10.9.9.9", clientExternal},
+		{"unparsable peer", "pipe", "", clientExternal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.forwardedFor != "" {
+				req.Header.Set("X-Forwarded-For", tt.forwardedFor)
+			}
+			if got := clientClass(req); got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+// A client talking to us directly must not be able to choose the address we
+// log by sending forwarding headers.
+func TestClientIP_UntrustedPeerCannotSpoof(t *testing.T) {
+	withTrustedProxies(t, "10.0.0.0/8")
+	spoofed := map[string]string{
+		"X-Forwarded-For": "1.2.3.4",
+		"X-Real-IP":       "1.2.3.4",
+		"Forwarded":       "for=1.2.3.4",
+	}
+	for header, value := range spoofed {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = "203.0.113.9:5555"
+		req.Header.Set(header, value)
+		if got := clientIP(req); got != "203.0.113.9" {
+			t.Errorf("%s from an untrusted peer: expected 203.0.113.9, got %s", header, got)
+		}
+	}
+
+	// A trusted proxy passes along what the client sent; the spoofed entry
+	// sits left of the real client and is never reached.
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", "1.2.3.4, 203.0.113.9")
+	if got := clientIP(req); got != "203.0.113.9" {
+		t.Errorf("expected the address the proxy saw, got %s", got)
+	}
+}
+
+func TestClientIP_NoTrustedProxies(t *testing.T) {
+	prev := trustedProxies
+	trustedProxies = nil
+	defer func() { trustedProxies = prev }()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", "203.0.113.7")
+	if got := clientIP(req); got != "10.0.0.1" {
+		t.Errorf("expected headers to be ignored by default, got %s", got)
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	got, err := parseTrustedProxies("10.0.0.0/8, 192.168.1.7, ::1, 172.16.5.4/12")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("192.168.1.7/32"),
+		netip.MustParsePrefix("::1/128"),
+		netip.MustParsePrefix("172.16.0.0/12"),
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("entry %d: expected %v, got %v", i, want[i], got[i])
+		}
+	}
+
+	for _, bad := range []string{"", "10.0.0.0/33", "proxy.local", "10.0.0.0/8,"} {
+		if _, err := parseTrustedProxies(bad); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
+
+func TestMetricsMiddleware_LogsClientIP(t *testing.T) {
+	withTrustedProxies(t, "10.0.0.0/8")
+	prev := logBuffer
+	logBuffer = make(chan logEntry, 1)
+	defer func() { logBuffer = prev }()
+
+	m, reg := newTestMetrics(t)
+	req := httptest.NewRequest(http.MethodGet, routeLive, nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", "203.0.113.7")
+	metricsMiddleware(m, newInternalMux(reg, m, time.Now())).ServeHTTP(httptest.NewRecorder(), req)
+
+	if entry := <-logBuffer; entry.remoteAddr != "203.0.113.7" {
+		t.Errorf("expected remote_addr 203.0.113.7, got %s", entry.remoteAddr)
+	}
+}
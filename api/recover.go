@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+)
+
+// headerRequestID carries the request ID set by the ingress, if any.
+const headerRequestID = "X-Request-ID"
+
+// recoverMiddleware turns a panic in next into a JSON 500, counted in
+// http_panics_total under the route matched on rt, instead of a dropped
+// connection. It must be the outermost middleware so it also covers the
+// rate limiter and metrics middleware.
+func recoverMiddleware(m *metrics, rt *router, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			// net/http uses ErrAbortHandler to abort a response on purpose.
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+			route := rt.routePattern(r.URL.Path)
+			stack := string(debug.Stack())
+			m.panicsTotal.WithLabelValues(route).Inc()
+			slog.Error("panic serving request", // #nosec G706 -- slog JSON handler safely encodes values
+				"method", r.Method,
+				"path", r.URL.Path,
+				"route", route,
+				"remote_addr", clientIP(r),
+				"request_id", r.Header.Get(headerRequestID),
+				"panic", fmt.Sprint(p),
+				"stack", stack,
+			)
+			errorReporter.Report(ErrorReport{
+				Message:   "panic serving request",
+				Error:     fmt.Sprint(p),
+				Stack:     stack,
+				RequestID: r.Header.Get(headerRequestID),
+				Details:   map[string]any{"method": r.Method, "route": route},
+			})
+			if rec.wroteHeader {
+				// Part of the response is already out; all we can do is
+				// cut the connection so the client sees it's truncated.
+				panic(http.ErrAbortHandler)
+			}
+			writeError(w, http.StatusInternalServerError, codeInternal, "internal server error")
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
+
+// probeRoutes answer 503 to report a state, such as draining or a database
+// outage, rather than a failure, so their 5xx responses aren't reported.
+var probeRoutes = map[string]bool{
+	routeLive:    true,
+	routeReady:   true,
+	routeStartup: true,
+	routeHealthz: true,
+}
+
+// reportErrorResponse reports a 5xx response a handler returned without
+// panicking; panics are reported by recoverMiddleware.
+func reportErrorResponse(rt *router, r *http.Request, status int) {
+	if status < http.StatusInternalServerError {
+		return
+	}
+	route := rt.routePattern(r.URL.Path)
+	if probeRoutes[route] {
+		return
+	}
+	errorReporter.Report(ErrorReport{
+		Message:   "server error response",
+		Error:     http.StatusText(status),
+		RequestID: r.Header.Get(headerRequestID),
+		Details:   map[string]any{"method": r.Method, "route": route, "status": status},
+	})
+}
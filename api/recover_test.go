@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func newPanickingServer(t *testing.T, m *metrics) *httptest.Server {
+	t.Helper()
+	rt := newRouter()
+	registerRoute(rt, "/boom", func(w http.ResponseWriter, r *http.Request) {
+		panic("deliberate test panic")
+	})
+	registerRoute(rt, routeLive, liveHandler)
+	srv := httptest.NewServer(recoverMiddleware(m, rt, metricsMiddleware(m, rt)))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestRecoverMiddleware_Panic(t *testing.T) {
+	m, _ := newTestMetrics(t)
+	srv := newPanickingServer(t, m)
+	status := http.StatusText(http.StatusInternalServerError)
+	requests := m.requestsTotal.WithLabelValues(http.MethodGet, "/boom", status, "false")
+	errs := m.errorsTotal.WithLabelValues(http.MethodGet, "/boom", status, "false")
+
+	resp, err := http.Get(srv.URL + "/boom")
+	if err != nil {
+		t.Fatalf("expected a response, got %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("expected 500, got %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected Content-Type application/json, got '%s'", ct)
+	}
+	var body errorResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("expected a JSON body: %v", err)
+	}
+	if body.Status != "error" || body.Code != codeInternal || body.Message != "internal server error" {
+		t.Errorf("unexpected body %+v", body)
+	}
+	if got := testutil.ToFloat64(m.panicsTotal.WithLabelValues("/boom")); got != 1 {
+		t.Errorf("expected http_panics_total 1, got %v", got)
+	}
+	if got := testutil.ToFloat64(requests); got != 1 {
+		t.Errorf("expected the 500 in http_requests_total, got %v", got)
+	}
+	if got := testutil.ToFloat64(errs); got != 1 {
+		t.Errorf("expected the 500 in http_errors_total, got %v", got)
+	}
+
+	// The server keeps serving after the panic.
+	resp, err = http.Get(srv.URL + routeLive)
+	if err != nil {
+		t.Fatalf("expected the server to survive the panic: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected 200 after the panic, got %d", resp.StatusCode)
+	}
+}
+
+func TestRecoverMiddleware_PanicAfterWrite(t *testing.T) {
+	m, _ := newTestMetrics(t)
+	rt := newRouter()
+	registerRoute(rt, "/partial", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		panic("deliberate test panic")
+	})
+	handler := recoverMiddleware(m, rt, metricsMiddleware(m, rt))
+
+	defer func() {
+		if p := recover(); p != http.ErrAbortHandler {
+			t.Errorf("expected http.ErrAbortHandler to abort the response, got %v", p)
+		}
+		if got := testutil.ToFloat64(m.panicsTotal.WithLabelValues("/partial")); got != 1 {
+			t.Errorf("expected http_panics_total 1, got %v", got)
+		}
+	}()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/partial", nil))
+}
+
+func TestRecoverMiddleware_NoPanic(t *testing.T) {
+	m, _ := newTestMetrics(t)
+	rt := newRouter()
+	registerRoute(rt, routeLive, liveHandler)
+	rec := httptest.NewRecorder()
+	recoverMiddleware(m, rt, rt).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, routeLive, nil))
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected 200, got %d", rec.Code)
+	}
+	if got := testutil.ToFloat64(m.panicsTotal.WithLabelValues(routeLive)); got != 0 {
+		t.Errorf("expected no panics, got %v", got)
+	}
+}
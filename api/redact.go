@@ -0,0 +1,91 @@
+package main
+
+import (
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const redacted = "xxxxx"
+
+// logSecrets are masked wherever they appear in log output: the passwords
+// of the DSNs the process has been configured with.
+var logSecrets struct {
+	sync.RWMutex
+	values []string
+}
+
+// addLogSecret masks the password of dsn in every later log record.
+func addLogSecret(dsn string) {
+	pw := passwordOf(dsn)
+	if pw == "" {
+		return
+	}
+	logSecrets.Lock()
+	defer logSecrets.Unlock()
+	for _, v := range logSecrets.values {
+		if v == pw {
+			return
+		}
+	}
+	logSecrets.values = append(logSecrets.values, pw)
+}
+
+// redactSecrets replaces every registered secret in s.
+func redactSecrets(s string) string {
+	logSecrets.RLock()
+	defer logSecrets.RUnlock()
+	for _, v := range logSecrets.values {
+		s = strings.ReplaceAll(s, v, redacted)
+	}
+	return s
+}
+
+// redactLogAttr is the log handler's ReplaceAttr. It masks logSecrets in
+// string and error values whatever key they are logged under, the message
+// included, so a driver error that echoes the DSN can't leak the password.
+func redactLogAttr(_ []string, a slog.Attr) slog.Attr {
+	switch a.Value.Kind() {
+	case slog.KindString:
+		a.Value = slog.StringValue(redactSecrets(a.Value.String()))
+	case slog.KindAny:
+		if err, ok := a.Value.Any().(error); ok {
+			a.Value = slog.StringValue(redactSecrets(err.Error()))
+		}
+	}
+	return a
+}
+
+// passwordOf returns the password dsn connects with, or "" when it has none
+// or doesn't parse.
+func passwordOf(dsn string) string {
+	cfg, err := pgx.ParseConfig(dsn)
+	if err != nil {
+		return ""
+	}
+	return cfg.Password
+}
+
+// dsnError hides the DSN password in err's message while keeping err in
+// the chain for errors.Is and errors.As.
+type dsnError struct {
+	err      error
+	password string
+}
+
+func (e dsnError) Error() string {
+	return strings.ReplaceAll(e.err.Error(), e.password, redacted)
+}
+
+func (e dsnError) Unwrap() error { return e.err }
+
+// redactDSNError masks the password of dsn in err's message.
+func redactDSNError(err error, dsn string) error {
+	pw := passwordOf(dsn)
+	if err == nil || pw == "" {
+		return err
+	}
+	return dsnError{err: err, password: pw}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestDiffConfig(t *testing.T) {
+	running := defaultConfig()
+	running.DSN = "postgres://app:first@db:5432/app"
+	next := running
+	next.Port = 9000
+	next.LogLevel = slog.LevelDebug
+	next.DSN = "postgres://app:second@db:5432/app"
+
+	got := make(map[string]settingChange)
+	for _, c := range diffConfig(running, next) {
+		got[c.key] = c
+	}
+	if len(got) != 3 {
+		t.Errorf("expected port, log_level and db_dsn to differ, got %+v", got)
+	}
+	if c := got["port"]; c.from != "8080" || c.to != "9000" {
+		t.Errorf("expected port 8080 -> 9000, got %+v", c)
+	}
+	if c := got["log_level"]; c.from != "INFO" || c.to != "DEBUG" {
+		t.Errorf("expected log_level INFO -> DEBUG, got %+v", c)
+	}
+	if c, ok := got["db_dsn"]; !ok || strings.Contains(c.from+c.to, "first") || strings.Contains(c.from+c.to, "second") {
+		t.Errorf("expected the rotated password detected without revealing it, got %+v", c)
+	}
+	if changes := diffConfig(running, running); len(changes) != 0 {
+		t.Errorf("expected no changes, got %+v", changes)
+	}
+}
+
+// reloadSequence returns a loader that hands out configs in order, then
+// keeps returning the last.
+func reloadSequence(configs ...Config) func() (Config, error) {
+	var mu sync.Mutex
+	return func() (Config, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		next := configs[0]
+		if len(configs) > 1 {
+			configs = configs[1:]
+		}
+		return next, nil
+	}
+}
+
+func TestConfigReloader_AppliesSafeSettings(t *testing.T) {
+	logs := captureLogs(t)
+	running := defaultConfig()
+	limiter := running.RateLimit.newLimiter()
+	next := running
+	next.LogLevel = slog.LevelDebug
+	next.RateLimit = rateSpec{count: 30, window: time.Minute}
+	next.Port = 9000
+	next.Env = "staging"
+	r := &configReloader{load: reloadSequence(next), settings: reloadableSettings(limiter, nil), running: running}
+
+	// Send the signal the way the process's SIGHUP channel would.
+	hup := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	go func() {
+		r.watch(hup)
+		close(done)
+	}()
+	hup <- syscall.SIGHUP
+	close(hup)
+	<-done
+
+	if logLevel.Level() != slog.LevelDebug {
+		t.Errorf("expected the log level applied, got %s", logLevel.Level())
+	}
+	if resp := limiter.response(time.Now()); resp.Limit != "30/minute" || resp.Burst != 1 {
+		t.Errorf("expected the rate limit applied, got %+v", resp)
+	}
+	if r.running.LogLevel != slog.LevelDebug || r.running.RateLimit != next.RateLimit {
+		t.Error("expected the applied settings recorded as running")
+	}
+	if r.running.Port != running.Port || r.running.Env != running.Env {
+		t.Error("expected the restart-only settings left at their running values")
+	}
+	out := logs.String()
+	for _, want := range []string{
+		`"msg":"setting changed, restart to apply it","setting":"port","running":"8080","loaded":"9000"`,
+		`"setting":"env"`,
+		`"msg":"configuration reloaded","applied":["log_level","rate_limit","rate_limit_per_second"],"restart_required":["port","env"]`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %s in the logs, got %s", want, out)
+		}
+	}
+}
+
+func TestConfigReloader_LoadFailure(t *testing.T) {
+	captureLogs(t)
+	running := defaultConfig()
+	limiter := running.RateLimit.newLimiter()
+	r := &configReloader{
+		load:     func() (Config, error) { return Config{}, errors.New("PORT: want a port") },
+		settings: reloadableSettings(limiter, nil),
+		running:  running,
+	}
+	if err := r.reload(); err == nil || !strings.Contains(err.Error(), "PORT") {
+		t.Errorf("expected the load error, got %v", err)
+	}
+	if diffConfig(running, r.running) != nil || limiter.response(time.Now()).Limit != running.RateLimit.String() {
+		t.Error("expected a failed load to change nothing")
+	}
+}
+
+func TestConfigReloader_SwitchesDSN(t *testing.T) {
+	resetLogSecrets(t)
+	captureLogs(t)
+	old, oldMock, _ := sqlmock.New()
+	next, _, _ := sqlmock.New()
+	defer func() { _ = next.Close() }()
+	dbMu.Lock()
+	db = old
+	dbMu.Unlock()
+	defer func() {
+		dbMu.Lock()
+		db = nil
+		dbMu.Unlock()
+	}()
+
+	running := defaultConfig()
+	running.DSN = "postgres://app:first@db:5432/app"
+	src := &dsnSource{dsn: running.DSN, connect: func(dsn string) (*sql.DB, error) {
+		if strings.Contains(dsn, "unreachable") {
+			return nil, errors.New("connection refused")
+		}
+		return next, nil
+	}}
+	unreachable, rotated := running, running
+	unreachable.DSN = "postgres://app:unreachable@db:5432/app"
+	rotated.DSN = "postgres://app:second@db:5432/app"
+	r := &configReloader{load: reloadSequence(unreachable, rotated), settings: reloadableSettings(running.RateLimit.newLimiter(), src), running: running}
+
+	if err := r.reload(); err == nil || !strings.Contains(err.Error(), "db_dsn") {
+		t.Errorf("expected the failed switch reported, got %v", err)
+	}
+	if r.running.DSN != running.DSN || WriteDB() != old {
+		t.Error("expected the running pool kept when the new DSN can't connect")
+	}
+	oldMock.ExpectClose()
+	if err := r.reload(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if r.running.DSN != rotated.DSN || WriteDB() != next {
+		t.Error("expected the pool switched to the rotated DSN")
+	}
+}
+
+func TestRateLimiter_SetWhileServing(t *testing.T) {
+	limiter := rateSpec{count: 100, window: time.Second}.newLimiter()
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 200 {
+				limiter.allow()
+				_ = limiter.response(time.Now())
+			}
+		}()
+	}
+	for i := range 50 {
+		limiter.set(rateSpec{count: float64(10 + i), window: time.Second})
+	}
+	wg.Wait()
+	if resp := limiter.response(time.Now()); resp.Limit != "59/second" || resp.Burst != 59 {
+		t.Errorf("expected the last rate in effect, got %+v", resp)
+	}
+}
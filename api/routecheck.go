@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// checkRoutes compares the routes registered on routers with the routes the
+// metrics label requests with, and with the per-route settings in cfg, so a
+// handler added without registerRoute or a typo in ROUTE_TIMEOUTS shows up
+// at deploy time rather than as a gap in the dashboards. A route missing
+// from the metrics is an error with METRICS_STRICT_ROUTES and a warning
+// otherwise; a setting for a route nothing serves is always a warning.
+func checkRoutes(cfg Config, routers ...*router) error {
+	var missing []string
+	for _, rt := range routers {
+		missing = append(missing, rt.unlabelledRoutes()...)
+	}
+	settings := []struct {
+		env    string
+		routes []string
+	}{
+		{"SLO_ROUTES", slices.Collect(maps.Keys(cfg.SLORoutes))},
+		{"ROUTE_TIMEOUTS", slices.Collect(maps.Keys(cfg.RouteTimeouts))},
+		{"ROUTE_SLOW_THRESHOLDS", slices.Collect(maps.Keys(cfg.RouteSlowThresholds))},
+		{"ROUTE_CACHE_MAX_AGE", slices.Collect(maps.Keys(cfg.RouteCacheMaxAges))},
+	}
+	for _, s := range settings {
+		slices.Sort(s.routes)
+		for _, route := range s.routes {
+			if !slices.ContainsFunc(routers, func(rt *router) bool { return rt.hasRoute(route) }) {
+				slog.Warn("setting names a route that isn't registered", "setting", s.env, "route", route)
+			}
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	if cfg.MetricsStrictRoutes {
+		return fmt.Errorf("routes missing from the metrics route set: %s", strings.Join(missing, ", "))
+	}
+	for _, route := range missing {
+		slog.Warn("route is missing from the metrics route set, its requests are counted under another route", "route", route)
+	}
+	return nil
+}
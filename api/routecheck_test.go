@@ -0,0 +1,87 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckRoutes_ServedRoutesPass(t *testing.T) {
+	prev := pprofEnabled
+	pprofEnabled = true
+	defer func() { pprofEnabled = prev }()
+	m, reg := newTestMetrics(t)
+	cfg := defaultConfig()
+	cfg.MetricsStrictRoutes = true
+	cfg.SLORoutes = map[string]float64{routePublic: 0.999}
+	cfg.RouteTimeouts = map[string]time.Duration{routeStats: 30 * time.Second}
+
+	logs := captureLogs(t)
+	if err := checkRoutes(cfg, newInternalMux(reg, m, time.Now()), newPublicMux("test")); err != nil {
+		t.Fatalf("expected every served route in the metrics route set, got %v", err)
+	}
+	if logs.Len() != 0 {
+		t.Errorf("expected no warnings, got %s", logs)
+	}
+}
+
+func TestCheckRoutes_UnlabelledRoutes(t *testing.T) {
+	rt := newRouter()
+	registerRoute(rt, routeLive, noopHandler)
+	registerRoute(rt, routePprof, noopHandler)
+	rt.HandleFunc("/debug/vars", noopHandler)
+	rt.HandleFunc(routePprof+"heap", noopHandler)
+	rt.HandleFunc("GET /api/v1/items/{id}", noopHandler)
+	registerRoute(rt, "GET /api/v1/orders", noopHandler)
+
+	cfg := defaultConfig()
+	cfg.MetricsStrictRoutes = true
+	err := checkRoutes(cfg, rt)
+	if err == nil {
+		t.Fatal("expected strict mode to reject routes missing from the metrics")
+	}
+	for _, route := range []string{"/debug/vars", routePprof + "heap", "GET /api/v1/items/{id}", "GET /api/v1/orders"} {
+		if !strings.Contains(err.Error(), route) {
+			t.Errorf("expected %s in the error, got %v", route, err)
+		}
+	}
+	if strings.Contains(err.Error(), routeLive) {
+		t.Errorf("expected registered routes left out, got %v", err)
+	}
+
+	logs := captureLogs(t)
+	cfg.MetricsStrictRoutes = false
+	if err := checkRoutes(cfg, rt); err != nil {
+		t.Fatalf("expected only warnings without strict mode, got %v", err)
+	}
+	if n := strings.Count(logs.String(), "route is missing from the metrics route set"); n != 4 {
+		t.Errorf("expected 4 warnings, got %d: %s", n, logs)
+	}
+}
+
+func TestCheckRoutes_SettingsForUnregisteredRoutes(t *testing.T) {
+	rt := newRouter()
+	registerRoute(rt, routeStats, noopHandler)
+	cfg := defaultConfig()
+	cfg.MetricsStrictRoutes = true
+	cfg.SLORoutes = map[string]float64{"/api/v1/tme": 0.999}
+	cfg.RouteTimeouts = map[string]time.Duration{routeStats: 30 * time.Second}
+	cfg.RouteSlowThresholds = map[string]time.Duration{"/api/v1/stats/": time.Second}
+
+	logs := captureLogs(t)
+	if err := checkRoutes(cfg, rt); err != nil {
+		t.Fatalf("expected settings for unregistered routes to only warn, got %v", err)
+	}
+	out := logs.String()
+	for _, want := range []string{
+		`"setting":"SLO_ROUTES","route":"/api/v1/tme"`,
+		`"setting":"ROUTE_SLOW_THRESHOLDS","route":"/api/v1/stats/"`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %s in the logs, got %s", want, out)
+		}
+	}
+	if strings.Contains(out, "ROUTE_TIMEOUTS") {
+		t.Errorf("expected no warning for a registered route, got %s", out)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// router is a ServeMux that remembers the patterns registered on it, so the
+// metrics middleware can label requests with the route they matched instead
+// of a hand-maintained list. Routes must be registered before serving.
+type router struct {
+	*http.ServeMux
+	exact    map[string]struct{}
+	prefixes []string
+	// patterns is every pattern registered on the mux, including any
+	// added around registerRoute, for checkRoutes.
+	patterns []string
+}
+
+func newRouter() *router {
+	return &router{ServeMux: http.NewServeMux(), exact: make(map[string]struct{})}
+}
+
+// registerRoute registers handler for pattern on rt, bounded by the timeout
+// configured for pattern. As with ServeMux, a pattern ending in "/" also
+// matches every path below it.
+func registerRoute(rt *router, pattern string, handler http.HandlerFunc) {
+	rt.Handle(pattern, timeoutHandler(pattern, handler))
+	if strings.HasSuffix(pattern, "/") {
+		rt.prefixes = append(rt.prefixes, pattern)
+		return
+	}
+	rt.exact[pattern] = struct{}{}
+}
+
+// Handle registers handler for pattern on the mux and records pattern, so
+// checkRoutes sees routes that bypassed registerRoute.
+func (rt *router) Handle(pattern string, handler http.Handler) {
+	rt.ServeMux.Handle(pattern, handler)
+	rt.patterns = append(rt.patterns, pattern)
+}
+
+// HandleFunc is Handle for a handler function.
+func (rt *router) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	rt.Handle(pattern, http.HandlerFunc(handler))
+}
+
+// ServeHTTP answers paths with no registered route with a JSON 404 instead
+// of ServeMux's plain-text one.
+func (rt *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if _, pattern := rt.Handler(r); pattern == "" {
+		writeError(w, http.StatusNotFound, codeNotFound, "not found")
+		return
+	}
+	rt.ServeMux.ServeHTTP(w, r)
+}
+
+// routePattern maps path to the pattern registered for it, preferring exact
+// matches and then the longest prefix pattern. Unregistered paths collapse to
+// "/other" to keep Prometheus label cardinality bounded.
+func (rt *router) routePattern(path string) string {
+	if _, ok := rt.exact[path]; ok {
+		return path
+	}
+	route := ""
+	for _, p := range rt.prefixes {
+		if strings.HasPrefix(path, p) && len(p) > len(route) {
+			route = p
+		}
+	}
+	if route == "" {
+		return "/other"
+	}
+	return route
+}
+
+// unlabelledRoutes returns the patterns registered on rt whose requests
+// aren't counted under their own route: ones added around registerRoute,
+// below a prefix route, or with a method, host or wildcard, which
+// routePattern can't match. Their requests land in "/other" or the prefix's
+// series.
+func (rt *router) unlabelledRoutes() []string {
+	var missing []string
+	for _, p := range rt.patterns {
+		if !strings.HasPrefix(p, "/") || strings.ContainsAny(p, " {") || rt.routePattern(p) != p {
+			missing = append(missing, p)
+		}
+	}
+	return missing
+}
+
+// hasRoute reports whether route is registered on rt.
+func (rt *router) hasRoute(route string) bool {
+	return slices.Contains(rt.patterns, route)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func noopHandler(w http.ResponseWriter, r *http.Request) {}
+
+func TestRouter_RoutePattern(t *testing.T) {
+	rt := newRouter()
+	registerRoute(rt, "/live", noopHandler)
+	registerRoute(rt, "/api/v1/logs/", noopHandler)
+	registerRoute(rt, "/api/v1/logs/export/", noopHandler)
+	registerRoute(rt, "/api/v1/logs/stats", noopHandler)
+
+	tests := map[string]string{
+		"/live":                   "/live",
+		"/live/extra":             "/other",
+		"/api/v1/logs/":           "/api/v1/logs/",
+		"/api/v1/logs/42":         "/api/v1/logs/",
+		"/api/v1/logs/export/csv": "/api/v1/logs/export/",
+		"/api/v1/logs/stats":      "/api/v1/logs/stats",
+		"/api/v1/logs":            "/other",
+		"/unknown":                "/other",
+		"/../../etc/passwd":       "/other",
+	}
+	for path, want := range tests {
+		if got := rt.routePattern(path); got != want {
+			t.Errorf("routePattern(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestRouter_PerServerRoutes(t *testing.T) {
+	m, reg := newTestMetrics(t)
+	internal := newInternalMux(reg, m, time.Now())
+	public := newPublicMux("test")
+
+	if got := internal.routePattern(routeLive); got != routeLive {
+		t.Errorf("expected internal server to know %s, got %q", routeLive, got)
+	}
+	if got := internal.routePattern(routePublic); got != "/other" {
+		t.Errorf("expected %s to be unknown on the internal server, got %q", routePublic, got)
+	}
+	if got := public.routePattern(routePublic); got != routePublic {
+		t.Errorf("expected public server to know %s, got %q", routePublic, got)
+	}
+	if got := public.routePattern(routeLive); got != "/other" {
+		t.Errorf("expected %s to be unknown on the public server, got %q", routeLive, got)
+	}
+}
+
+func TestMetricsMiddleware_PublicRoute(t *testing.T) {
+	m, _ := newTestMetrics(t)
+	handler := metricsMiddleware(m, newPublicMux("test"))
+	counter := m.requestsTotal.WithLabelValues(http.MethodGet, routePublic, http.StatusText(http.StatusOK), "false")
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, routePublic, nil))
+
+	if got := testutil.ToFloat64(counter); got != 1 {
+		t.Errorf("expected public request under %s, got %v", routePublic, got)
+	}
+}
+
+func TestRouter_NotFoundJSON(t *testing.T) {
+	m, reg := newTestMetrics(t)
+	servers := map[string]*router{
+		"internal": newInternalMux(reg, m, time.Now()),
+		"public":   newPublicMux("test"),
+	}
+	status := http.StatusText(http.StatusNotFound)
+	requests := m.requestsTotal.WithLabelValues(http.MethodGet, "/other", status, "false")
+
+	for name, rt := range servers {
+		t.Run(name, func(t *testing.T) {
+			before := testutil.ToFloat64(requests)
+			rec := httptest.NewRecorder()
+			metricsMiddleware(m, rt).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/no/such/route", nil))
+
+			if rec.Code != http.StatusNotFound {
+				t.Errorf("expected 404, got %d", rec.Code)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("expected Content-Type application/json, got '%s'", ct)
+			}
+			var body errorResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("expected a JSON body: %v", err)
+			}
+			if body.Status != "error" || body.Code != codeNotFound || body.Message != "not found" {
+				t.Errorf("unexpected body %+v", body)
+			}
+			if got := testutil.ToFloat64(requests) - before; got != 1 {
+				t.Errorf("expected the 404 under the /other route, got %v", got)
+			}
+		})
+	}
+}
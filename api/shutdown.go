@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultShutdownDrainDelay = 5 * time.Second
+	defaultShutdownTimeout    = 30 * time.Second
+
+	// shutdownReserve is kept back from the servers phase so a slow
+	// client can't leave the log flush and database close without time.
+	shutdownReserve = 2 * time.Second
+)
+
+// draining flips to true when shutdown begins, so /ready fails while the
+// servers are still up and the load balancer stops sending new requests.
+var draining atomic.Bool
+
+// shutdownPhase is one step of an ordered shutdown.
+type shutdownPhase struct {
+	name string
+	// timeout bounds the phase. Zero gives it whatever is left of the
+	// overall budget.
+	timeout time.Duration
+	run     func(ctx context.Context) error
+}
+
+// runShutdown runs phases one after another within budget, giving each a
+// deadline of its own and logging how long it took. A phase that overruns
+// its deadline is reported and the next one starts, so a stuck phase can't
+// push the shutdown past budget. Once the budget is spent the remaining
+// phases still start, with a cancelled context, but aren't waited for.
+func runShutdown(budget time.Duration, phases []shutdownPhase) {
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), budget)
+	defer cancel()
+	for _, p := range phases {
+		phaseStart := time.Now()
+		pctx, pcancel := ctx, context.CancelFunc(func() {})
+		if p.timeout > 0 {
+			pctx, pcancel = context.WithTimeout(ctx, p.timeout)
+		}
+		done := make(chan error, 1)
+		go func() { done <- p.run(pctx) }()
+		var err error
+		select {
+		case err = <-done:
+		case <-pctx.Done():
+			err = pctx.Err()
+		}
+		pcancel()
+		if err != nil {
+			slog.Error("shutdown phase failed", "phase", p.name, "duration", time.Since(phaseStart), "error", err)
+			recordEvent(eventForcedShutdown, map[string]any{"phase": p.name, "error": err.Error()})
+			continue
+		}
+		slog.Info("shutdown phase finished", "phase", p.name, "duration", time.Since(phaseStart))
+	}
+	slog.Info("shutdown complete", "duration", time.Since(start), "budget", budget)
+}
+
+// shutdownSequence stops the service after a termination signal without
+// dropping requests the load balancer is still routing here.
+type shutdownSequence struct {
+	// drainDelay is how long /ready reports draining before the servers
+	// stop accepting connections.
+	drainDelay time.Duration
+	// timeout bounds the whole sequence, drain delay included.
+	timeout  time.Duration
+	servers  map[string]*http.Server
+	stopLogs func()
+	logsDone <-chan struct{}
+	// closeDB, when set, closes the database pools once the logs are
+	// flushed.
+	closeDB func() error
+	// closeReports, when set, delivers the queued error reports.
+	closeReports func(ctx context.Context) error
+	// flushMetrics, when set, makes the final OTLP metrics push.
+	flushMetrics func(ctx context.Context) error
+	// flushTraces, when set, exports the spans still buffered, the
+	// database's included.
+	flushTraces func(ctx context.Context) error
+}
+
+// run waits for a signal on quit and then shuts down in order: mark the
+// service draining and wait drainDelay, shut the servers down in parallel,
+// flush the log buffer, deliver the queued error reports, close the
+// database, make the final OTLP metrics push and flush the traces.
+func (s shutdownSequence) run(quit <-chan os.Signal) {
+	sig := <-quit
+	slog.Info("shutdown signal received", "signal", sig.String(), "drain_delay", s.drainDelay, "timeout", s.timeout)
+	recordEvent(eventShutdown, map[string]any{"signal": sig.String()})
+	runShutdown(s.timeout, s.phases())
+}
+
+func (s shutdownSequence) phases() []shutdownPhase {
+	phases := []shutdownPhase{
+		{name: "drain", run: func(ctx context.Context) error {
+			draining.Store(true)
+			select {
+			case <-time.After(s.drainDelay):
+			case <-ctx.Done():
+			}
+			return nil
+		}},
+		{name: "servers", timeout: s.serversTimeout(), run: func(ctx context.Context) error {
+			var wg sync.WaitGroup
+			for name, srv := range s.servers {
+				wg.Go(func() {
+					if err := srv.Shutdown(ctx); err != nil {
+						slog.Error("server forced to shutdown", "server", name, "error", err)
+					}
+				})
+			}
+			wg.Wait()
+			return ctx.Err()
+		}},
+		{name: "logs", run: func(ctx context.Context) error {
+			s.stopLogs()
+			select {
+			case <-s.logsDone:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}},
+	}
+	if s.closeReports != nil {
+		phases = append(phases, shutdownPhase{name: "error reports", run: s.closeReports})
+	}
+	if s.closeDB != nil {
+		phases = append(phases, shutdownPhase{name: "db", run: func(context.Context) error {
+			return s.closeDB()
+		}})
+	}
+	if s.flushMetrics != nil {
+		phases = append(phases, shutdownPhase{name: "otlp metrics", run: s.flushMetrics})
+	}
+	if s.flushTraces != nil {
+		phases = append(phases, shutdownPhase{name: "traces", run: s.flushTraces})
+	}
+	return phases
+}
+
+// serversTimeout is what is left of the budget after the drain delay and
+// shutdownReserve, or zero (the rest of the budget) when that leaves nothing.
+func (s shutdownSequence) serversTimeout() time.Duration {
+	return max(s.timeout-s.drainDelay-shutdownReserve, 0)
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestReadyHandler_Draining(t *testing.T) {
+	draining.Store(true)
+	defer draining.Store(false)
+
+	rec := httptest.NewRecorder()
+	readyHandler(rec, httptest.NewRequest(http.MethodGet, routeReady, nil))
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 while draining, got %d", rec.Code)
+	}
+	var resp readyResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if !resp.Draining || resp.Status != "draining" {
+		t.Errorf("expected draining status, got %+v", resp)
+	}
+}
+
+func TestShutdownSequence(t *testing.T) {
+	defer draining.Store(false)
+	m, reg := newTestMetrics(t)
+	internal := httptest.NewServer(newInternalMux(reg, m, time.Now()))
+	defer internal.Close()
+	public := httptest.NewServer(newPublicMux("test"))
+	defer public.Close()
+	admin := httptest.NewServer(newAdminServer(0, "s3cret", newAdminMux(m), false).Handler)
+	defer admin.Close()
+
+	logsStopped := make(chan struct{})
+	logsDone := make(chan struct{})
+	go func() {
+		<-logsStopped
+		close(logsDone)
+	}()
+	var reportsClosed, dbClosed, tracesFlushed atomic.Bool
+	seq := shutdownSequence{
+		drainDelay: 200 * time.Millisecond,
+		timeout:    5 * time.Second,
+		servers:    map[string]*http.Server{"internal": internal.Config, "public": public.Config, "admin": admin.Config},
+		stopLogs:   func() { close(logsStopped) },
+		logsDone:   logsDone,
+		closeReports: func(context.Context) error {
+			select {
+			case <-logsDone:
+			default:
+				t.Error("expected the error reports to be delivered after the log buffer was flushed")
+			}
+			reportsClosed.Store(true)
+			return nil
+		},
+		closeDB: func() error {
+			select {
+			case <-logsDone:
+			default:
+				t.Error("expected the database to be closed after the log buffer was flushed")
+			}
+			if !reportsClosed.Load() {
+				t.Error("expected the database to be closed after the error reports were delivered")
+			}
+			dbClosed.Store(true)
+			return nil
+		},
+		flushTraces: func(context.Context) error {
+			if !dbClosed.Load() {
+				t.Error("expected the traces to be flushed after the database was closed")
+			}
+			tracesFlushed.Store(true)
+			return nil
+		},
+	}
+	quit := make(chan os.Signal, 1)
+	finished := make(chan struct{})
+	go func() {
+		seq.run(quit)
+		close(finished)
+	}()
+
+	quit <- syscall.SIGTERM
+
+	// During the drain delay /ready fails but requests are still served.
+	deadline := time.Now().Add(time.Second)
+	for {
+		resp, err := http.Get(internal.URL + routeReady)
+		if err != nil {
+			t.Fatalf("expected the server to keep serving while draining: %v", err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode == http.StatusServiceUnavailable && draining.Load() {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected /ready to fail after the signal")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	resp, err := http.Get(public.URL + routePublic)
+	if err != nil {
+		t.Fatalf("expected the public server to keep serving while draining: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected 200 from the public route while draining, got %d", resp.StatusCode)
+	}
+
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown sequence did not finish")
+	}
+	select {
+	case <-logsDone:
+	default:
+		t.Error("expected the log buffer to be flushed before returning")
+	}
+	for name, url := range map[string]string{"internal": internal.URL + routeLive, "public": public.URL + routePublic, "admin": admin.URL + routeAdminLogLevel} {
+		if _, err := http.Get(url); err == nil {
+			t.Errorf("expected the %s server to be stopped", name)
+		}
+	}
+	if !tracesFlushed.Load() {
+		t.Error("expected the traces to be flushed before returning")
+	}
+}
+
+func TestRunShutdown_Order(t *testing.T) {
+	buf := captureLogs(t)
+	var mu sync.Mutex
+	var order []string
+	phase := func(name string) shutdownPhase {
+		return shutdownPhase{name: name, run: func(context.Context) error {
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+			return nil
+		}}
+	}
+	failing := shutdownPhase{name: "logs", run: func(context.Context) error { return errors.New("boom") }}
+
+	runShutdown(time.Second, []shutdownPhase{phase("drain"), phase("servers"), failing, phase("db")})
+
+	if got := strings.Join(order, ","); got != "drain,servers,db" {
+		t.Errorf("expected phases to run in order despite a failure, got %s", got)
+	}
+	out := buf.String()
+	for _, want := range []string{`"phase":"servers"`, `"msg":"shutdown phase failed","phase":"logs"`, `"msg":"shutdown complete"`, `"duration"`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %s in the shutdown logs, got %s", want, out)
+		}
+	}
+}
+
+func TestRunShutdown_PhaseTimeout(t *testing.T) {
+	captureLogs(t)
+	var nextDeadline time.Time
+	phases := []shutdownPhase{
+		{name: "slow", timeout: 50 * time.Millisecond, run: func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}},
+		{name: "next", run: func(ctx context.Context) error {
+			nextDeadline, _ = ctx.Deadline()
+			return nil
+		}},
+	}
+	start := time.Now()
+	runShutdown(time.Second, phases)
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("expected the slow phase to be cut off at its own deadline, took %s", elapsed)
+	}
+	if remaining := nextDeadline.Sub(start); remaining < 900*time.Millisecond {
+		t.Errorf("expected the next phase to get the rest of the budget, got %s", remaining)
+	}
+}
+
+func TestRunShutdown_RespectsBudget(t *testing.T) {
+	captureLogs(t)
+	release := make(chan struct{})
+	defer close(release)
+	ran := make(chan struct{}, 1)
+	phases := []shutdownPhase{
+		// A phase that ignores its context must not hold up the shutdown.
+		{name: "stuck", run: func(context.Context) error {
+			<-release
+			return nil
+		}},
+		{name: "db", run: func(context.Context) error {
+			ran <- struct{}{}
+			return nil
+		}},
+	}
+	start := time.Now()
+	runShutdown(100*time.Millisecond, phases)
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("expected shutdown to finish within its budget, took %s", elapsed)
+	}
+	select {
+	case <-ran:
+	case <-time.After(time.Second):
+		t.Error("expected the phases after the budget to still be started")
+	}
+}
+
+func TestShutdownSequence_SlowServer(t *testing.T) {
+	defer draining.Store(false)
+	captureLogs(t)
+
+	// A handler that outlives the budget keeps Shutdown waiting until its
+	// deadline, which must still leave time to flush the logs.
+	release := make(chan struct{})
+	started := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+	go func() {
+		resp, err := http.Get(slow.URL)
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+	}()
+	<-started
+
+	logsDone := make(chan struct{})
+	dbClosed := make(chan struct{})
+	seq := shutdownSequence{
+		drainDelay: 10 * time.Millisecond,
+		timeout:    shutdownReserve + time.Second,
+		servers:    map[string]*http.Server{"slow": slow.Config},
+		stopLogs:   func() { close(logsDone) },
+		logsDone:   logsDone,
+		closeDB: func() error {
+			close(dbClosed)
+			return nil
+		},
+	}
+	quit := make(chan os.Signal, 1)
+	quit <- syscall.SIGTERM
+	start := time.Now()
+	seq.run(quit)
+	if elapsed := time.Since(start); elapsed > seq.timeout {
+		t.Errorf("expected shutdown within %s, took %s", seq.timeout, elapsed)
+	}
+	if elapsed := time.Since(start); elapsed < seq.serversTimeout() {
+		t.Errorf("expected the servers phase to wait up to %s, took %s", seq.serversTimeout(), elapsed)
+	}
+	select {
+	case <-dbClosed:
+	default:
+		t.Error("expected the database to be closed after a slow server phase")
+	}
+}
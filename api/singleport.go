@@ -0,0 +1,18 @@
+package main
+
+import (
+	"net/http"
+)
+
+const defaultInternalPrefix = "/internal"
+
+// newSinglePortHandler serves public at its usual paths and internal under
+// prefix, with the prefix stripped so internal routes keep the metric labels,
+// timeouts and access-log paths they have on their own port. Each handler
+// brings its own middleware, so only internal routes are rate limited.
+func newSinglePortHandler(prefix string, internal, public http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle(prefix+"/", http.StripPrefix(prefix, internal))
+	mux.Handle("/", public)
+	return mux
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func newTestSinglePortServer(t *testing.T, m *metrics) *httptest.Server {
+	t.Helper()
+	internalMux := newInternalMux(prometheus.NewRegistry(), m, time.Now())
+	publicMux := newPublicMux("test")
+	srv := httptest.NewServer(newSinglePortHandler(defaultInternalPrefix,
+		recoverMiddleware(m, internalMux, metricsMiddleware(m, internalMux)),
+		recoverMiddleware(m, publicMux, metricsMiddleware(m, publicMux)),
+	))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestSinglePort_ServesBothRouteSets(t *testing.T) {
+	m, _ := newTestMetrics(t)
+	srv := newTestSinglePortServer(t, m)
+	ok := http.StatusText(http.StatusOK)
+	liveRequests := m.requestsTotal.WithLabelValues(http.MethodGet, routeLive, ok, "false")
+	publicRequests := m.requestsTotal.WithLabelValues(http.MethodGet, routePublic, ok, "false")
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{routePublic, http.StatusOK},
+		{defaultInternalPrefix + routeLive, http.StatusOK},
+		{defaultInternalPrefix + routeVersion, http.StatusOK},
+		{routeLive, http.StatusNotFound},
+		{defaultInternalPrefix + routePublic, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		resp, err := http.Get(srv.URL + tt.path)
+		if err != nil {
+			t.Fatalf("%s: request failed: %v", tt.path, err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != tt.want {
+			t.Errorf("%s: expected %d, got %d", tt.path, tt.want, resp.StatusCode)
+		}
+		if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("%s: expected Content-Type application/json, got '%s'", tt.path, ct)
+		}
+	}
+
+	// Internal routes keep their usual labels under the prefix.
+	if got := testutil.ToFloat64(liveRequests); got != 1 {
+		t.Errorf("expected one %s request, got %v", routeLive, got)
+	}
+	if got := testutil.ToFloat64(publicRequests); got != 1 {
+		t.Errorf("expected one %s request, got %v", routePublic, got)
+	}
+}
+
+func TestSinglePort_SeparateOpenAPIDocuments(t *testing.T) {
+	m, _ := newTestMetrics(t)
+	srv := newTestSinglePortServer(t, m)
+
+	for path, wantLive := range map[string]bool{routeOpenAPI: false, defaultInternalPrefix + routeOpenAPI: true} {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatalf("%s: request failed: %v", path, err)
+		}
+		var spec openAPISpec
+		err = json.NewDecoder(resp.Body).Decode(&spec)
+		_ = resp.Body.Close()
+		if err != nil {
+			t.Fatalf("%s: failed to decode spec: %v", path, err)
+		}
+		if _, ok := spec.Paths[routeLive]; ok != wantLive {
+			t.Errorf("%s: expected %s present=%v", path, routeLive, wantLive)
+		}
+	}
+}
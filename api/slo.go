@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// sloConfig lists the routes tracked against an availability objective.
+// Requests to other routes skip SLO accounting entirely.
+type sloConfig struct {
+	objectives       map[string]float64
+	countRateLimited bool
+}
+
+// slo is set by main from SLO_ROUTES and SLO_COUNT_RATE_LIMITED.
+var slo sloConfig
+
+// parseSLORoutes parses a comma-separated list of route=objective pairs,
+// e.g. "/api/v1/time=0.999". Objectives must lie strictly between 0 and 1.
+func parseSLORoutes(s string) (map[string]float64, error) {
+	objectives := make(map[string]float64)
+	for _, pair := range strings.Split(s, ",") {
+		route, objStr, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || !strings.HasPrefix(route, "/") {
+			return nil, fmt.Errorf("invalid entry %q: want /route=objective", pair)
+		}
+		obj, err := strconv.ParseFloat(objStr, 64)
+		if err != nil || obj <= 0 || obj >= 1 {
+			return nil, fmt.Errorf("invalid objective %q for %s: want a value between 0 and 1", objStr, route)
+		}
+		if _, dup := objectives[route]; dup {
+			return nil, fmt.Errorf("duplicate route %s", route)
+		}
+		objectives[route] = obj
+	}
+	return objectives, nil
+}
+
+// register exports the objectives and starts each route's counters at zero,
+// so burn-rate ratios are defined before the first error.
+func (c sloConfig) register(m *metrics) {
+	for route, obj := range c.objectives {
+		m.sloObjective.WithLabelValues(route).Set(obj)
+		m.sloRequests.WithLabelValues(route)
+		m.sloErrors.WithLabelValues(route)
+	}
+}
+
+// observe counts a request to route against its objective, if it has one.
+func (c sloConfig) observe(m *metrics, route string, status int) {
+	if _, ok := c.objectives[route]; !ok {
+		return
+	}
+	m.sloRequests.WithLabelValues(route).Inc()
+	if status >= 500 || (c.countRateLimited && status == http.StatusTooManyRequests) {
+		m.sloErrors.WithLabelValues(route).Inc()
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestParseSLORoutes(t *testing.T) {
+	got, err := parseSLORoutes("/api/v1/time=0.999, /live=0.99")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got) != 2 || got["/api/v1/time"] != 0.999 || got["/live"] != 0.99 {
+		t.Errorf("unexpected objectives %v", got)
+	}
+
+	for _, in := range []string{"/api/v1/time", "api=0.9", "/a=1", "/a=0", "/a=high", "/a=0.9,/a=0.99", ""} {
+		if _, err := parseSLORoutes(in); err == nil {
+			t.Errorf("expected error for %q", in)
+		}
+	}
+}
+
+func TestSLO_Middleware(t *testing.T) {
+	tests := []struct {
+		name             string
+		countRateLimited bool
+		wantErrors       float64
+	}{
+		{"5xx only", false, 1},
+		{"5xx and 429", true, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prev := slo
+			defer func() { slo = prev }()
+			slo = sloConfig{objectives: map[string]float64{"/tracked": 0.999}, countRateLimited: tt.countRateLimited}
+
+			m, _ := newTestMetrics(t)
+			slo.register(m)
+			rt := newRouter()
+			// Each route replies with the status in ?code=, defaulting to 200.
+			reply := func(w http.ResponseWriter, r *http.Request) {
+				code, err := strconv.Atoi(r.URL.Query().Get("code"))
+				if err != nil {
+					code = http.StatusOK
+				}
+				w.WriteHeader(code)
+			}
+			registerRoute(rt, "/tracked", reply)
+			registerRoute(rt, "/untracked", reply)
+			handler := metricsMiddleware(m, rt)
+			for _, target := range []string{"/tracked?code=500", "/tracked?code=429", "/tracked?code=404", "/tracked", "/untracked?code=500"} {
+				handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+			}
+
+			if got := testutil.ToFloat64(m.sloRequests.WithLabelValues("/tracked")); got != 4 {
+				t.Errorf("expected 4 tracked requests, got %v", got)
+			}
+			if got := testutil.ToFloat64(m.sloErrors.WithLabelValues("/tracked")); got != tt.wantErrors {
+				t.Errorf("expected %v errors, got %v", tt.wantErrors, got)
+			}
+			if got := testutil.ToFloat64(m.sloObjective.WithLabelValues("/tracked")); got != 0.999 {
+				t.Errorf("expected objective 0.999, got %v", got)
+			}
+			if n := testutil.CollectAndCount(m.sloRequests); n != 1 {
+				t.Errorf("expected no series for untracked routes, got %d", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const defaultSlowRequestThreshold = time.Second
+
+// slowRequestThresholds is set by main from SLOW_REQUEST_THRESHOLD and
+// ROUTE_SLOW_THRESHOLDS.
+var slowRequestThresholds = routeDurations{
+	fallback: defaultSlowRequestThreshold,
+	routes:   unboundedRoutes(),
+}
+
+// observeSlowRequest flags a request to route that took longer than the
+// route's threshold: it logs a "slow request" warning with what's needed to
+// find the request again and counts it in http_slow_requests_total.
+func observeSlowRequest(m *metrics, r *http.Request, route string, status int, elapsed time.Duration) {
+	threshold := slowRequestThresholds.forRoute(route)
+	if threshold <= 0 || elapsed <= threshold {
+		return
+	}
+	m.slowRequests.WithLabelValues(route).Inc()
+	slog.Warn("slow request", // #nosec G706 -- slog JSON handler safely encodes values
+		"method", r.Method,
+		"path", r.URL.Path,
+		"route", route,
+		"status", status,
+		"duration_ms", elapsed.Seconds()*1000,
+		"threshold", threshold.String(),
+		"request_id", r.Header.Get(headerRequestID),
+		"remote_addr", clientIP(r),
+	)
+}
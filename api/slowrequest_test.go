@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetricsMiddleware_SlowRequest(t *testing.T) {
+	logs := captureLogs(t)
+	prev := slowRequestThresholds
+	slowRequestThresholds = routeDurations{fallback: 20 * time.Millisecond, routes: map[string]time.Duration{"/tolerant": time.Minute}}
+	defer func() { slowRequestThresholds = prev }()
+	m, _ := newTestMetrics(t)
+	rt := newRouter()
+	sleepy := func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		w.WriteHeader(http.StatusAccepted)
+	}
+	registerRoute(rt, "/slow", sleepy)
+	registerRoute(rt, "/tolerant", sleepy)
+	registerRoute(rt, "/quick", func(w http.ResponseWriter, r *http.Request) {})
+	handler := metricsMiddleware(m, rt)
+
+	for _, path := range []string{"/slow", "/tolerant", "/quick"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = "203.0.113.7:1234"
+		req.Header.Set(headerRequestID, "req-"+path[1:])
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	for route, want := range map[string]float64{"/slow": 1, "/tolerant": 0, "/quick": 0} {
+		if got := testutil.ToFloat64(m.slowRequests.WithLabelValues(route)); got != want {
+			t.Errorf("%s: expected http_slow_requests_total %v, got %v", route, want, got)
+		}
+	}
+	var slow []string
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		if strings.Contains(line, `"msg":"slow request"`) {
+			slow = append(slow, line)
+		}
+	}
+	if len(slow) != 1 {
+		t.Fatalf("expected one slow request record, got %v", slow)
+	}
+	for _, want := range []string{
+		`"level":"WARN"`,
+		`"route":"/slow"`,
+		`"status":202`,
+		`"duration_ms":`,
+		`"threshold":"20ms"`,
+		`"request_id":"req-slow"`,
+		`"remote_addr":"203.0.113.7"`,
+	} {
+		if !strings.Contains(slow[0], want) {
+			t.Errorf("expected %s in %s", want, slow[0])
+		}
+	}
+}
+
+func TestConfig_SlowRequestThresholds(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.RouteSlowThresholds = map[string]time.Duration{routeStats: 5 * time.Second, routeLogsExport: time.Minute}
+	thresholds := cfg.slowRequestThresholds()
+	for route, want := range map[string]time.Duration{
+		routeLive:       defaultSlowRequestThreshold,
+		routeStats:      5 * time.Second,
+		routeAdminLogs:  0,
+		routeLogsExport: time.Minute,
+	} {
+		if got := thresholds.forRoute(route); got != want {
+			t.Errorf("%s: expected %s, got %s", route, want, got)
+		}
+	}
+}
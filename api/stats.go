@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultStatsWindow       = time.Hour
+	maxStatsWindow           = 7 * 24 * time.Hour
+	defaultStatsQueryTimeout = 5 * time.Second
+)
+
+// statsQueryTimeout bounds the /api/v1/stats aggregation query.
+var statsQueryTimeout = defaultStatsQueryTimeout
+
+// statsGroupColumns whitelists the group_by values and the api_logs column
+// each one groups on; nothing else reaches the SQL.
+var statsGroupColumns = map[string]string{
+	"endpoint": "endpoint",
+	"method":   "method",
+	"status":   "status",
+}
+
+// StatsResponse is the JSON response for /api/v1/stats.
+type StatsResponse struct {
+	From    string       `json:"from"`
+	To      string       `json:"to"`
+	GroupBy string       `json:"group_by"`
+	Groups  []StatsGroup `json:"groups"`
+}
+
+// StatsGroup summarizes the requests in one group. Errors counts 4xx and 5xx
+// responses; percentiles are of duration_ms.
+type StatsGroup struct {
+	Key      string  `json:"key"`
+	Requests int64   `json:"requests"`
+	Errors   int64   `json:"errors"`
+	P50MS    float64 `json:"p50_ms"`
+	P95MS    float64 `json:"p95_ms"`
+	P99MS    float64 `json:"p99_ms"`
+}
+
+// statsQuery returns the aggregation for the whitelisted column.
+func statsQuery(column string) string {
+	return `
+		SELECT ` + column + `::text,
+			count(*),
+			count(*) FILTER (WHERE status >= 400),
+			percentile_cont(0.5) WITHIN GROUP (ORDER BY duration_ms),
+			percentile_cont(0.95) WITHIN GROUP (ORDER BY duration_ms),
+			percentile_cont(0.99) WITHIN GROUP (ORDER BY duration_ms)
+		FROM api_logs
+		WHERE created_at >= $1 AND created_at < $2
+		GROUP BY 1
+		ORDER BY 2 DESC`
+}
+
+// parseStatsWindow reads from and to as RFC3339, defaulting to the hour
+// before now, and rejects empty, reversed or over-long windows.
+func parseStatsWindow(fromStr, toStr string, now time.Time) (time.Time, time.Time, error) {
+	to := now
+	if toStr != "" {
+		t, err := time.Parse(time.RFC3339, toStr)
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("invalid to: want RFC3339")
+		}
+		to = t
+	}
+	from := to.Add(-defaultStatsWindow)
+	if fromStr != "" {
+		t, err := time.Parse(time.RFC3339, fromStr)
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("invalid from: want RFC3339")
+		}
+		from = t
+	}
+	if !from.Before(to) {
+		return time.Time{}, time.Time{}, errors.New("from must be before to")
+	}
+	if to.Sub(from) > maxStatsWindow {
+		return time.Time{}, time.Time{}, errors.New("window must not exceed 7 days")
+	}
+	return from.UTC(), to.UTC(), nil
+}
+
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	groupBy := q.Get("group_by")
+	if groupBy == "" {
+		groupBy = "endpoint"
+	}
+	column, ok := statsGroupColumns[groupBy]
+	if !ok {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "invalid group_by: want endpoint, method or status")
+		return
+	}
+	from, to, err := parseStatsWindow(q.Get("from"), q.Get("to"), time.Now())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	}
+
+	d := ReadDB()
+	if d == nil {
+		writeError(w, http.StatusServiceUnavailable, codeUnavailable, "db not configured")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), statsQueryTimeout)
+	defer cancel()
+	groups, err := queryStats(ctx, d, column, from, to)
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			writeError(w, http.StatusGatewayTimeout, codeTimeout, "stats query timed out")
+			return
+		}
+		slog.Error("stats query failed", "error", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "stats query failed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, StatsResponse{
+		From:    from.Format(time.RFC3339),
+		To:      to.Format(time.RFC3339),
+		GroupBy: groupBy,
+		Groups:  groups,
+	})
+}
+
+func queryStats(ctx context.Context, d *sql.DB, column string, from, to time.Time) ([]StatsGroup, error) {
+	rows, err := d.QueryContext(ctx, statsQuery(column), from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	groups := []StatsGroup{}
+	for rows.Next() {
+		var (
+			key           sql.NullString
+			g             StatsGroup
+			p50, p95, p99 sql.NullFloat64
+		)
+		if err := rows.Scan(&key, &g.Requests, &g.Errors, &p50, &p95, &p99); err != nil {
+			return nil, err
+		}
+		g.Key, g.P50MS, g.P95MS, g.P99MS = key.String, p50.Float64, p95.Float64, p99.Float64
+		groups = append(groups, g)
+	}
+	return groups, rows.Err()
+}
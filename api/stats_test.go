@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestParseStatsWindow(t *testing.T) {
+	now := time.Date(2024, 1, 8, 12, 0, 0, 0, time.UTC)
+
+	from, to, err := parseStatsWindow("", "", now)
+	if err != nil || !to.Equal(now) || !from.Equal(now.Add(-time.Hour)) {
+		t.Errorf("expected the last hour, got %v..%v, %v", from, to, err)
+	}
+	from, _, err = parseStatsWindow("", "2024-01-02T00:00:00Z", now)
+	if err != nil || !from.Equal(time.Date(2024, 1, 1, 23, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected from to default to an hour before to, got %v, %v", from, err)
+	}
+
+	tests := map[string][2]string{
+		"invalid from":   {"yesterday", ""},
+		"invalid to":     {"", "now"},
+		"reversed":       {"2024-01-02T00:00:00Z", "2024-01-01T00:00:00Z"},
+		"empty":          {"2024-01-01T00:00:00Z", "2024-01-01T00:00:00Z"},
+		"longer than 7d": {"2024-01-01T00:00:00Z", "2024-01-08T00:00:01Z"},
+	}
+	for name, tt := range tests {
+		if _, _, err := parseStatsWindow(tt[0], tt[1], now); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	if _, _, err := parseStatsWindow("2024-01-01T00:00:00Z", "2024-01-08T00:00:00Z", now); err != nil {
+		t.Errorf("expected exactly 7 days to be allowed, got %v", err)
+	}
+}
+
+func TestStatsHandler(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer func() { _ = mockDB.Close() }()
+	dbMu.Lock()
+	db = mockDB
+	dbMu.Unlock()
+	defer func() {
+		dbMu.Lock()
+		db = nil
+		dbMu.Unlock()
+	}()
+
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(2 * time.Hour)
+	mock.ExpectQuery(regexp.QuoteMeta(statsQuery("method"))).
+		WithArgs(from, to).
+		WillReturnRows(sqlmock.NewRows([]string{"key", "requests", "errors", "p50", "p95", "p99"}).
+			AddRow("GET", 10, 2, 1.5, 9.0, 12.25).
+			AddRow(nil, 1, 0, nil, nil, nil))
+
+	rec := httptest.NewRecorder()
+	statsHandler(rec, httptest.NewRequest(http.MethodGet, "/api/v1/stats?group_by=method&from=2024-01-01T00:00:00Z&to=2024-01-01T02:00:00Z", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	want := `{"from":"2024-01-01T00:00:00Z","to":"2024-01-01T02:00:00Z","group_by":"method","groups":[` +
+		`{"key":"GET","requests":10,"errors":2,"p50_ms":1.5,"p95_ms":9,"p99_ms":12.25},` +
+		`{"key":"","requests":1,"errors":0,"p50_ms":0,"p95_ms":0,"p99_ms":0}]}`
+	if got := strings.TrimSpace(rec.Body.String()); got != want {
+		t.Errorf("unexpected body:\n got %s\nwant %s", got, want)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled mock: %s", err)
+	}
+}
+
+func TestStatsHandler_Errors(t *testing.T) {
+	dbMu.Lock()
+	db = nil
+	dbMu.Unlock()
+
+	tests := []struct {
+		name   string
+		target string
+		code   int
+	}{
+		{"unknown group_by", "/api/v1/stats?group_by=remote_addr", http.StatusBadRequest},
+		{"injection attempt", "/api/v1/stats?group_by=endpoint%3BDROP+TABLE+api_logs", http.StatusBadRequest},
+		{"window too long", "/api/v1/stats?from=2024-01-01T00:00:00Z&to=2024-02-01T00:00:00Z", http.StatusBadRequest},
+		{"no database", "/api/v1/stats", http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			statsHandler(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if rec.Code != tt.code {
+				t.Errorf("expected %d, got %d", tt.code, rec.Code)
+			}
+			var resp map[string]string
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || resp["status"] != "error" || resp["message"] == "" {
+				t.Errorf("expected an error JSON body, got %v, %v", resp, err)
+			}
+		})
+	}
+}
+
+func TestStatsHandler_Timeout(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer func() { _ = mockDB.Close() }()
+	dbMu.Lock()
+	db = mockDB
+	dbMu.Unlock()
+	defer func() {
+		dbMu.Lock()
+		db = nil
+		dbMu.Unlock()
+	}()
+	prev := statsQueryTimeout
+	statsQueryTimeout = 50 * time.Millisecond
+	defer func() { statsQueryTimeout = prev }()
+
+	mock.ExpectQuery("SELECT endpoint::text").
+		WillDelayFor(time.Second).
+		WillReturnRows(sqlmock.NewRows([]string{"key", "requests", "errors", "p50", "p95", "p99"}))
+
+	rec := httptest.NewRecorder()
+	statsHandler(rec, httptest.NewRequest(http.MethodGet, "/api/v1/stats", nil))
+
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("expected 504, got %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "stats query timed out") {
+		t.Errorf("unexpected body %s", rec.Body)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// statsdClient sends metrics to a statsd agent over UDP in the DogStatsD
+// format, one packet per value:
+//
+//	<prefix><name>:<value>|<type>|#<tag>,<tag>
+//
+// A nil client sends nothing, so callers needn't check whether STATSD_ADDR
+// is set. As is usual for statsd, a failed send is never reported to the
+// caller; it is only counted on failures.
+type statsdClient struct {
+	conn     net.Conn
+	prefix   string
+	tags     []string
+	failures prometheus.Counter
+}
+
+// newStatsdClient sends to the agent at addr, prefixing every name with
+// prefix and tagging every value with tags.
+func newStatsdClient(addr, prefix string, tags []string, failures prometheus.Counter) (*statsdClient, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("open statsd socket: %w", err)
+	}
+	return &statsdClient{conn: conn, prefix: prefix, tags: tags, failures: failures}, nil
+}
+
+// count adds n to the counter name.
+func (c *statsdClient) count(name string, n int64, tags ...string) {
+	c.send(name, strconv.FormatInt(n, 10), "c", tags)
+}
+
+// timing records d, in milliseconds, on the timer name.
+func (c *statsdClient) timing(name string, d time.Duration, tags ...string) {
+	c.send(name, strconv.FormatFloat(float64(d.Microseconds())/1000, 'f', -1, 64), "ms", tags)
+}
+
+// gauge sets the gauge name to v.
+func (c *statsdClient) gauge(name string, v float64, tags ...string) {
+	c.send(name, strconv.FormatFloat(v, 'f', -1, 64), "g", tags)
+}
+
+func (c *statsdClient) send(name, value, kind string, tags []string) {
+	if c == nil {
+		return
+	}
+	var b strings.Builder
+	b.WriteString(c.prefix)
+	b.WriteString(name)
+	b.WriteByte(':')
+	b.WriteString(value)
+	b.WriteByte('|')
+	b.WriteString(kind)
+	sep := "|#"
+	for _, set := range [][]string{c.tags, tags} {
+		for _, tag := range set {
+			b.WriteString(sep)
+			b.WriteString(tag)
+			sep = ","
+		}
+	}
+	if _, err := c.conn.Write([]byte(b.String())); err != nil {
+		c.failures.Inc()
+	}
+}
+
+// statsdTag formats a key:value tag, replacing the characters the format
+// reserves.
+func statsdTag(key, value string) string {
+	return key + ":" + statsdTagReplacer.Replace(value)
+}
+
+var statsdTagReplacer = strings.NewReplacer("|", "_", ",", "_", "#", "_")
+
+// parseStatsdTags parses a comma-separated list of key:value (or bare key)
+// tags, e.g. "team:platform,region:ap-southeast-1".
+func parseStatsdTags(s string) ([]string, error) {
+	var tags []string
+	for _, tag := range strings.Split(s, ",") {
+		tag = strings.TrimSpace(tag)
+		key, _, _ := strings.Cut(tag, ":")
+		if key == "" || strings.ContainsAny(tag, "|# ") {
+			return nil, fmt.Errorf("invalid tag %q: want key:value", tag)
+		}
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
+
+// checkStatsdAddr reports whether s is a host:port to send statsd packets
+// to.
+func checkStatsdAddr(s string) error {
+	_, port, err := net.SplitHostPort(s)
+	if err != nil {
+		return fmt.Errorf("want host:port, got %q", s)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("want a port between 1 and 65535, got %q", port)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// listenStatsd binds a local UDP agent and returns its address and a
+// function reading the next packet from it.
+func listenStatsd(t *testing.T) (string, func() string) {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn.LocalAddr().String(), func() string {
+		t.Helper()
+		buf := make([]byte, 1500)
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("expected a statsd packet: %v", err)
+		}
+		return string(buf[:n])
+	}
+}
+
+func newTestStatsd(t *testing.T, addr, prefix string, tags ...string) (*statsdClient, prometheus.Counter) {
+	t.Helper()
+	failures := prometheus.NewCounter(prometheus.CounterOpts{Name: "statsd_send_failures_total"})
+	c, err := newStatsdClient(addr, prefix, tags, failures)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, failures
+}
+
+func TestStatsdClient_Packets(t *testing.T) {
+	addr, next := listenStatsd(t)
+	c, _ := newTestStatsd(t, addr, "agnos.", "service:api", "env:test")
+
+	c.count("http.requests", 2, "route:/live")
+	c.timing("http.request.duration", 1500*time.Microsecond)
+	c.gauge("worker.backlog", 42)
+	for _, want := range []string{
+		"agnos.http.requests:2|c|#service:api,env:test,route:/live",
+		"agnos.http.request.duration:1.5|ms|#service:api,env:test",
+		"agnos.worker.backlog:42|g|#service:api,env:test",
+	} {
+		if got := next(); got != want {
+			t.Errorf("expected %q, got %q", want, got)
+		}
+	}
+
+	bare, _ := newTestStatsd(t, addr, "")
+	bare.count("http.rate_limited", 1)
+	if got := next(); got != "http.rate_limited:1|c" {
+		t.Errorf("expected no tag section without tags, got %q", got)
+	}
+}
+
+func TestStatsdClient_Failures(t *testing.T) {
+	addr, _ := listenStatsd(t)
+	c, failures := newTestStatsd(t, addr, "")
+	_ = c.conn.Close()
+
+	c.count("http.requests", 1)
+	if got := testutil.ToFloat64(failures); got != 1 {
+		t.Errorf("expected the failed send to be counted, got %v", got)
+	}
+
+	var disabled *statsdClient
+	disabled.count("http.requests", 1) // must not panic
+}
+
+func TestParseStatsdTags(t *testing.T) {
+	tags, err := parseStatsdTags(" team:platform , region:th,canary")
+	if want := []string{"team:platform", "region:th", "canary"}; err != nil || !slices.Equal(tags, want) {
+		t.Errorf("expected %v, got %v, %v", want, tags, err)
+	}
+	for _, s := range []string{"team:platform,", ":th", "team:a|b", "team:#1", "team:a b"} {
+		if _, err := parseStatsdTags(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestStatsdTag(t *testing.T) {
+	if got := statsdTag("route", "/a|b,c#d"); got != "route:/a_b_c_d" {
+		t.Errorf("expected reserved characters replaced, got %q", got)
+	}
+}
+
+func TestMetricsMiddleware_Statsd(t *testing.T) {
+	addr, next := listenStatsd(t)
+	m, _ := newTestMetrics(t)
+	m.statsd, _ = newTestStatsd(t, addr, "")
+
+	handler := metricsMiddleware(m, newInternalMux(prometheus.NewRegistry(), m, time.Now()))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, routeLive, nil))
+	if got, want := next(), "http.requests:1|c|#method:GET,route:/live,status_class:2xx"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	if got := next(); !strings.HasPrefix(got, "http.request.duration:") || !strings.HasSuffix(got, "|ms|#method:GET,route:/live,status_class:2xx") {
+		t.Errorf("expected the request timer, got %q", got)
+	}
+
+	m.countRateLimited(routeLive, clientExternal)
+	if got := next(); got != "http.rate_limited:1|c|#route:/live,client_class:external" {
+		t.Errorf("expected the rate-limited counter, got %q", got)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"log/syslog"
+	"strings"
+	"sync"
+)
+
+const (
+	// logOutputSyslog is the LOG_OUTPUT that sends records to syslog: the
+	// local daemon, or SYSLOG_ADDR over SYSLOG_NETWORK when it is set.
+	logOutputSyslog = "syslog"
+
+	syslogNetworkUDP = "udp"
+	syslogNetworkTCP = "tcp"
+
+	// syslogFacility is the facility of every message; the severity comes
+	// from the record's level.
+	syslogFacility = syslog.LOG_DAEMON
+)
+
+// parseSyslogNetwork accepts udp or tcp, case-insensitively.
+func parseSyslogNetwork(s string) (string, error) {
+	switch n := strings.ToLower(s); n {
+	case syslogNetworkUDP, syslogNetworkTCP:
+		return n, nil
+	}
+	return "", fmt.Errorf("want %s or %s, got %q", syslogNetworkUDP, syslogNetworkTCP, s)
+}
+
+// syslogOutput sends each log record as one syslog message tagged tag. It
+// is only written through syslogHandler, which picks the severity of the
+// message before the record is formatted. A dropped connection is dialled
+// again on the next write.
+type syslogOutput struct {
+	w *syslog.Writer
+
+	// mu is held by syslogHandler from choosing severity until the record
+	// is written.
+	mu       sync.Mutex
+	severity syslog.Priority
+}
+
+// dialSyslog connects to the syslog server at addr over network, or to the
+// local daemon when addr is empty.
+func dialSyslog(network, addr, tag string) (*syslogOutput, error) {
+	if addr == "" {
+		network = ""
+	}
+	w, err := syslog.Dial(network, addr, syslogFacility|syslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &syslogOutput{w: w, severity: syslog.LOG_INFO}, nil
+}
+
+func (o *syslogOutput) Write(p []byte) (int, error) {
+	msg := string(p)
+	var err error
+	switch o.severity {
+	case syslog.LOG_DEBUG:
+		err = o.w.Debug(msg)
+	case syslog.LOG_WARNING:
+		err = o.w.Warning(msg)
+	case syslog.LOG_ERR:
+		err = o.w.Err(msg)
+	default:
+		err = o.w.Info(msg)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (o *syslogOutput) Close() error { return o.w.Close() }
+
+// syslogSeverity maps a slog level to the syslog severity of its records.
+func syslogSeverity(level slog.Level) syslog.Priority {
+	switch {
+	case level >= slog.LevelError:
+		return syslog.LOG_ERR
+	case level >= slog.LevelWarn:
+		return syslog.LOG_WARNING
+	case level >= slog.LevelInfo:
+		return syslog.LOG_INFO
+	}
+	return syslog.LOG_DEBUG
+}
+
+// syslogHandler formats records with its Handler, which writes to out, and
+// sends each at the severity its level maps to.
+type syslogHandler struct {
+	slog.Handler
+	out *syslogOutput
+}
+
+func (h syslogHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.out.mu.Lock()
+	defer h.out.mu.Unlock()
+	h.out.severity = syslogSeverity(r.Level)
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h syslogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return syslogHandler{Handler: h.Handler.WithAttrs(attrs), out: h.out}
+}
+
+func (h syslogHandler) WithGroup(name string) slog.Handler {
+	return syslogHandler{Handler: h.Handler.WithGroup(name), out: h.out}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"log/slog"
+	"log/syslog"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// syslogLine is the framing log/syslog uses over the network:
+// <PRI>TIMESTAMP HOSTNAME TAG[PID]: MSG, one message per line.
+var syslogLine = regexp.MustCompile(`^<(\d+)>\d{4}-\d\d-\d\dT\S+ \S+ api-test\[\d+\]: (\{.*\})$`)
+
+// listenSyslog runs a TCP syslog server and returns its address and a
+// channel of the connections it accepts.
+func listenSyslog(t *testing.T) (string, <-chan net.Conn) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conns := make(chan net.Conn, 4)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conns <- conn
+		}
+	}()
+	t.Cleanup(func() { _ = ln.Close() })
+	return ln.Addr().String(), conns
+}
+
+// acceptSyslog waits for the next connection to the server and returns it
+// with a scanner over its messages.
+func acceptSyslog(t *testing.T, conns <-chan net.Conn) (net.Conn, *bufio.Scanner) {
+	t.Helper()
+	select {
+	case conn := <-conns:
+		t.Cleanup(func() { _ = conn.Close() })
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		return conn, bufio.NewScanner(conn)
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a syslog connection")
+		return nil, nil
+	}
+}
+
+func syslogTestConfig(addr string) Config {
+	cfg := defaultConfig()
+	cfg.ServiceName = "api-test"
+	cfg.LogOutput = logOutputSyslog
+	cfg.SyslogNetwork = syslogNetworkTCP
+	cfg.SyslogAddr = addr
+	return cfg
+}
+
+func useDebugLevel(t *testing.T) {
+	t.Helper()
+	prevLogger, prevLevel := slog.Default(), logLevel.Level()
+	logLevel.Set(slog.LevelDebug)
+	t.Cleanup(func() {
+		slog.SetDefault(prevLogger)
+		logLevel.Set(prevLevel)
+	})
+}
+
+func TestSetupLogger_Syslog(t *testing.T) {
+	useDebugLevel(t)
+	addr, conns := listenSyslog(t)
+	closeLog := setupLogger(syslogTestConfig(addr))
+	defer closeLog()
+	_, lines := acceptSyslog(t, conns)
+
+	slog.Debug("debug record")
+	slog.Info("info record")
+	slog.With("component", "flusher").Warn("warn record")
+	slog.Error("error record")
+
+	// The daemon facility is 3, so the priority is 3*8 plus the severity.
+	for _, want := range []struct {
+		priority int
+		msg      string
+	}{
+		{31, `"level":"DEBUG","msg":"debug record"`},
+		{30, `"level":"INFO","msg":"info record"`},
+		{28, `"level":"WARN","msg":"warn record","component":"flusher"`},
+		{27, `"level":"ERROR","msg":"error record"`},
+	} {
+		if !lines.Scan() {
+			t.Fatalf("expected a message for %s: %v", want.msg, lines.Err())
+		}
+		m := syslogLine.FindStringSubmatch(lines.Text())
+		if m == nil {
+			t.Fatalf("expected a framed syslog message, got %q", lines.Text())
+		}
+		if pri, _ := strconv.Atoi(m[1]); pri != want.priority || !strings.Contains(m[2], want.msg) {
+			t.Errorf("expected <%d> with %s, got %q", want.priority, want.msg, lines.Text())
+		}
+	}
+}
+
+func TestSetupLogger_SyslogUnreachable(t *testing.T) {
+	useDebugLevel(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	_ = ln.Close()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	prevStdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = prevStdout }()
+
+	closeLog := setupLogger(syslogTestConfig(addr))
+	slog.Info("still logging")
+	closeLog()
+	_ = w.Close()
+	out, _ := io.ReadAll(r)
+
+	if !strings.Contains(string(out), `"msg":"invalid setting, using the default"`) || !strings.Contains(string(out), "LOG_OUTPUT") {
+		t.Errorf("expected the failed dial reported as a warning, got %s", out)
+	}
+	if !strings.Contains(string(out), `"msg":"still logging"`) {
+		t.Errorf("expected records to fall back to stdout, got %s", out)
+	}
+}
+
+func TestSetupLogger_SyslogReconnects(t *testing.T) {
+	useDebugLevel(t)
+	addr, conns := listenSyslog(t)
+	closeLog := setupLogger(syslogTestConfig(addr))
+	defer closeLog()
+
+	conn, lines := acceptSyslog(t, conns)
+	slog.Info("before the drop")
+	if !lines.Scan() || !strings.Contains(lines.Text(), "before the drop") {
+		t.Fatalf("expected the first record, got %q", lines.Text())
+	}
+	// Drop the connection the way a restarting daemon would. A record
+	// written before the writer notices may be lost, as with any syslog
+	// over TCP, so keep logging until it dials again.
+	_ = conn.Close()
+	var redialled net.Conn
+	for deadline := time.Now().Add(5 * time.Second); redialled == nil && time.Now().Before(deadline); {
+		slog.Info("after the drop")
+		select {
+		case redialled = <-conns:
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+	if redialled == nil {
+		t.Fatal("expected the writer to dial again after the drop")
+	}
+	defer func() { _ = redialled.Close() }()
+	_ = redialled.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if lines := bufio.NewScanner(redialled); !lines.Scan() || !strings.Contains(lines.Text(), "after the drop") {
+		t.Errorf("expected records on the new connection, got %q", lines.Text())
+	}
+}
+
+func TestSyslogSeverity(t *testing.T) {
+	for level, want := range map[slog.Level]syslog.Priority{
+		slog.LevelDebug:     syslog.LOG_DEBUG,
+		slog.LevelInfo:      syslog.LOG_INFO,
+		slog.LevelInfo + 2:  syslog.LOG_INFO,
+		slog.LevelWarn:      syslog.LOG_WARNING,
+		slog.LevelError:     syslog.LOG_ERR,
+		slog.LevelError + 4: syslog.LOG_ERR,
+	} {
+		if got := syslogSeverity(level); got != want {
+			t.Errorf("%s: expected severity %d, got %d", level, want, got)
+		}
+	}
+}
+
+func TestParseSyslogNetwork(t *testing.T) {
+	if got, err := parseSyslogNetwork("TCP"); err != nil || got != syslogNetworkTCP {
+		t.Errorf("expected tcp, got %q, %v", got, err)
+	}
+	if _, err := parseSyslogNetwork("unix"); err == nil {
+		t.Error("expected unix to be rejected")
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+const defaultTimeCacheResolution = 100 * time.Millisecond
+
+// timeCacheResolution is how long a rendered /api/v1/time response is
+// reused, set by main from TIME_CACHE_RESOLUTION. Zero renders every
+// response.
+var timeCacheResolution = defaultTimeCacheResolution
+
+// timeCacheEntry is one rendered response and when it stops being served.
+type timeCacheEntry struct {
+	expires time.Time
+	header  http.Header
+	body    []byte
+}
+
+// timeCacheWriter captures a response for timeCache to store.
+type timeCacheWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *timeCacheWriter) Header() http.Header { return w.header }
+
+func (w *timeCacheWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+}
+
+func (w *timeCacheWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(b)
+}
+
+// timeCache renders the time endpoint at most once per resolution for each
+// encoding and serves the same bytes to every request in between, so the
+// timestamp may be up to one resolution old. Requests with a query string
+// always reach next, as tz and format make too many variants to keep.
+// Entries are swapped atomically: requests racing past an expired entry may
+// each render one, and the last stored wins.
+type timeCache struct {
+	next       http.HandlerFunc
+	resolution time.Duration
+	now        func() time.Time
+	// entries holds one slot per encoding negotiateTimeEncoding returns,
+	// created up front so serving never writes to the map.
+	entries map[string]*atomic.Pointer[timeCacheEntry]
+}
+
+// withTimeCache wraps next in a timeCache, or returns it unchanged when
+// resolution is zero.
+func withTimeCache(resolution time.Duration, next http.HandlerFunc) http.HandlerFunc {
+	if resolution <= 0 {
+		return next
+	}
+	return newTimeCache(resolution, next).ServeHTTP
+}
+
+func newTimeCache(resolution time.Duration, next http.HandlerFunc) *timeCache {
+	c := &timeCache{next: next, resolution: resolution, now: time.Now, entries: make(map[string]*atomic.Pointer[timeCacheEntry])}
+	for _, contentType := range []string{contentTypeJSON, contentTypeText, contentTypeMsgpack} {
+		c.entries[contentType] = new(atomic.Pointer[timeCacheEntry])
+	}
+	return c
+}
+
+func (c *timeCache) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.RawQuery != "" {
+		c.next(w, r)
+		return
+	}
+	slot := c.entries[negotiateTimeEncoding(r.Header.Get("Accept"))]
+	now := c.now()
+	entry := slot.Load()
+	if entry == nil || !now.Before(entry.expires) {
+		rw := &timeCacheWriter{header: make(http.Header)}
+		c.next(rw, r)
+		entry = &timeCacheEntry{expires: now.Add(c.resolution), header: rw.header, body: rw.body.Bytes()}
+		if rw.status != http.StatusOK && rw.status != 0 {
+			writeCachedTime(w, rw.status, entry)
+			return
+		}
+		slot.Store(entry)
+	}
+	writeCachedTime(w, http.StatusOK, entry)
+}
+
+// writeCachedTime sends entry's headers and body. Every request shares the
+// entry, so its header values are clipped: a middleware appending to one
+// gets a new slice instead of writing into the cached one.
+func writeCachedTime(w http.ResponseWriter, status int, entry *timeCacheEntry) {
+	for key, values := range entry.header {
+		w.Header()[key] = slices.Clip(values)
+	}
+	w.WriteHeader(status)
+	if _, err := w.Write(entry.body); err != nil {
+		slog.Error(errWriteResponse, "error", err)
+	}
+}
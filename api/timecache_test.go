@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingTime stands in for publicHandler, answering with how many times
+// it has rendered.
+func countingTime(renders *atomic.Int64) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		n := renders.Add(1)
+		w.Header().Set(headerContentType, negotiateTimeEncoding(r.Header.Get("Accept")))
+		_, _ = w.Write([]byte(strconv.FormatInt(n, 10)))
+	}
+}
+
+func serveCachedTime(c *timeCache, target, accept string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	rec := httptest.NewRecorder()
+	c.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestTimeCache_OneRenderPerWindow(t *testing.T) {
+	var renders atomic.Int64
+	c := newTimeCache(100*time.Millisecond, countingTime(&renders))
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+
+	for range 3 {
+		if rec := serveCachedTime(c, "/api/v1/time", ""); rec.Body.String() != "1" {
+			t.Fatalf("expected the first render reused, got %s", rec.Body)
+		}
+	}
+	rec := serveCachedTime(c, "/api/v1/time", "text/plain")
+	if rec.Body.String() != "2" || rec.Header().Get(headerContentType) != contentTypeText {
+		t.Errorf("expected text rendered and cached apart from JSON, got %s as %s", rec.Body, rec.Header().Get(headerContentType))
+	}
+
+	now = now.Add(99 * time.Millisecond)
+	if rec := serveCachedTime(c, "/api/v1/time", ""); rec.Body.String() != "1" {
+		t.Errorf("expected the render reused within the window, got %s", rec.Body)
+	}
+	now = now.Add(time.Millisecond)
+	if rec := serveCachedTime(c, "/api/v1/time", ""); rec.Body.String() != "3" {
+		t.Errorf("expected a new render once the window passed, got %s", rec.Body)
+	}
+}
+
+func TestTimeCache_Bypassed(t *testing.T) {
+	var renders atomic.Int64
+	c := newTimeCache(time.Hour, countingTime(&renders))
+
+	serveCachedTime(c, "/api/v1/time?tz=UTC", "")
+	if rec := serveCachedTime(c, "/api/v1/time?tz=UTC", ""); rec.Body.String() != "2" {
+		t.Errorf("expected requests with a query rendered every time, got %s", rec.Body)
+	}
+
+	// An error isn't cached, though no plain request produces one today.
+	c.next = func(w http.ResponseWriter, r *http.Request) {
+		renders.Add(1)
+		writeError(w, http.StatusServiceUnavailable, codeInternal, "unavailable")
+	}
+	for range 2 {
+		if rec := serveCachedTime(c, "/api/v1/time", ""); rec.Code != http.StatusServiceUnavailable {
+			t.Fatalf("expected the error passed through, got %d", rec.Code)
+		}
+	}
+	if got := renders.Load(); got != 4 {
+		t.Errorf("expected the error rendered each time, got %d renders", got)
+	}
+}
+
+func TestTimeCache_TimestampsAdvance(t *testing.T) {
+	handler := withTimeCache(50*time.Millisecond, publicHandler("test-env"))
+	timestamp := func() time.Time {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodGet, "/api/v1/time", nil))
+		var resp PublicResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to parse response JSON: %v", err)
+		}
+		ts, err := time.Parse(time.RFC3339, resp.Timestamp)
+		if err != nil {
+			t.Fatalf("timestamp %q is not RFC3339: %v", resp.Timestamp, err)
+		}
+		return ts
+	}
+
+	first := timestamp()
+	// The timestamp has whole seconds, so it takes a second to change.
+	time.Sleep(1100 * time.Millisecond)
+	if next := timestamp(); !next.After(first) {
+		t.Errorf("expected the timestamp to advance across windows, got %s then %s", first, next)
+	}
+}
+
+func TestTimeCache_Concurrent(t *testing.T) {
+	handler := withTimeCache(time.Millisecond, publicHandler("test-env"))
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Go(func() {
+			for range 200 {
+				rec := httptest.NewRecorder()
+				handler(rec, httptest.NewRequest(http.MethodGet, "/api/v1/time", nil))
+				rec.Header().Add(headerContentType, "mutated")
+				if rec.Code != http.StatusOK || !json.Valid(rec.Body.Bytes()) {
+					t.Errorf("unexpected response %d: %s", rec.Code, rec.Body)
+					return
+				}
+			}
+		})
+	}
+	wg.Wait()
+}
+
+// BenchmarkPublicHandler compares rendering every response with serving
+// from the cache: go test -run '^$' -bench PublicHandler -benchmem
+func BenchmarkPublicHandler(b *testing.B) {
+	for _, bc := range []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{"uncached", publicHandler("test-env")},
+		{"cached", withTimeCache(defaultTimeCacheResolution, publicHandler("test-env"))},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/time", nil)
+			w := &discardWriter{header: make(http.Header)}
+			b.ReportAllocs()
+			for b.Loop() {
+				clear(w.header)
+				bc.handler(w, req)
+			}
+		})
+	}
+}
+
+// discardWriter is a ResponseWriter cheap enough not to hide the handler's
+// own allocations.
+type discardWriter struct{ header http.Header }
+
+func (w *discardWriter) Header() http.Header         { return w.header }
+func (w *discardWriter) WriteHeader(int)             {}
+func (w *discardWriter) Write(b []byte) (int, error) { return len(b), nil }
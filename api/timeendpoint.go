@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// PublicResponse represents the JSON response for the public time endpoint.
+// Timezone and Epoch only appear when tz or a unix format is requested, so
+// the default response is unchanged.
+type PublicResponse struct {
+	Status    string `json:"status"`
+	Timestamp string `json:"timestamp"`
+	Env       string `json:"env"`
+	Timezone  string `json:"timezone,omitempty"`
+	Epoch     *int64 `json:"epoch,omitempty"`
+}
+
+// Response encodings offered by the time endpoint.
+const (
+	contentTypeText    = "text/plain; charset=utf-8"
+	contentTypeMsgpack = "application/msgpack"
+)
+
+// publicHandler serves the current time. Optional query parameters: tz, an
+// IANA zone name, and format, which picks either the epoch unit (rfc3339,
+// the default, unix or unixms) or the encoding (json, text or msgpack),
+// overriding the Accept header. Plain text is just the timestamp.
+func publicHandler(env string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now().UTC()
+		resp := PublicResponse{Status: "ok", Env: env}
+
+		if tz := r.URL.Query().Get("tz"); tz != "" {
+			// "Local" would expose the server's zone and "" means UTC anyway.
+			loc, err := time.LoadLocation(tz)
+			if err != nil || tz == "Local" {
+				writeError(w, http.StatusBadRequest, codeInvalidRequest, "unknown tz: want an IANA zone name such as Asia/Bangkok")
+				return
+			}
+			now = now.In(loc)
+			resp.Timezone = loc.String()
+		}
+		resp.Timestamp = now.Format(time.RFC3339)
+		plain := resp.Timestamp
+
+		contentType := negotiateTimeEncoding(r.Header.Get("Accept"))
+		switch format := r.URL.Query().Get("format"); format {
+		case "", "rfc3339":
+		case "unix", "unixms":
+			epoch := now.Unix()
+			if format == "unixms" {
+				epoch = now.UnixMilli()
+			}
+			resp.Epoch = &epoch
+			plain = strconv.FormatInt(epoch, 10)
+		case "json":
+			contentType = contentTypeJSON
+		case "text":
+			contentType = contentTypeText
+		case "msgpack":
+			contentType = contentTypeMsgpack
+		default:
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "invalid format: want rfc3339, unix, unixms, json, text or msgpack")
+			return
+		}
+
+		w.Header().Set(headerContentType, contentType)
+		w.WriteHeader(http.StatusOK)
+		var err error
+		switch contentType {
+		case contentTypeText:
+			_, err = w.Write([]byte(plain + "\n"))
+		case contentTypeMsgpack:
+			enc := msgpack.NewEncoder(w)
+			enc.SetCustomStructTag("json")
+			err = enc.Encode(resp)
+		default:
+			err = json.NewEncoder(w).Encode(resp)
+		}
+		if err != nil {
+			slog.Error(errWriteResponse, "error", err)
+		}
+	}
+}
+
+// negotiateTimeEncoding returns the content type for the first media type
+// in accept that the time endpoint can produce. Wildcards and anything else
+// keep the JSON default.
+func negotiateTimeEncoding(accept string) string {
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		switch mediaType {
+		case "text/plain":
+			return contentTypeText
+		case contentTypeMsgpack, "application/x-msgpack":
+			return contentTypeMsgpack
+		case contentTypeJSON:
+			return contentTypeJSON
+		}
+	}
+	return contentTypeJSON
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func serveTime(t *testing.T, target, accept string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	rec := httptest.NewRecorder()
+	publicHandler("test-env")(rec, req)
+	return rec
+}
+
+func TestPublicHandler_DefaultUnchanged(t *testing.T) {
+	rec := serveTime(t, "/api/v1/time", "")
+	want := regexp.MustCompile(`^\{"status":"ok","timestamp":"\d{4}-\d\d-\d\dT\d\d:\d\d:\d\dZ","env":"test-env"\}\n$`)
+	if !want.MatchString(rec.Body.String()) {
+		t.Errorf("default response changed: %s", rec.Body)
+	}
+}
+
+func TestPublicHandler_Params(t *testing.T) {
+	tests := []struct {
+		name         string
+		query        string
+		wantTimezone string
+		wantOffset   string
+		wantEpoch    bool
+		epochDigits  int
+	}{
+		{"tz", "tz=Asia/Bangkok", "Asia/Bangkok", "+07:00", false, 0},
+		{"rfc3339", "format=rfc3339", "", "Z", false, 0},
+		{"unix", "format=unix", "", "Z", true, 10},
+		{"unixms", "format=unixms", "", "Z", true, 13},
+		{"tz and unix", "tz=America/New_York&format=unix", "America/New_York", "", true, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serveTime(t, "/api/v1/time?"+tt.query, "")
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+			}
+			var resp PublicResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("failed to parse response JSON: %v", err)
+			}
+			if resp.Timezone != tt.wantTimezone {
+				t.Errorf("expected timezone %q, got %q", tt.wantTimezone, resp.Timezone)
+			}
+			ts, err := time.Parse(time.RFC3339, resp.Timestamp)
+			if err != nil {
+				t.Fatalf("timestamp %q is not RFC3339: %v", resp.Timestamp, err)
+			}
+			if tt.wantOffset != "" && !strings.HasSuffix(resp.Timestamp, tt.wantOffset) {
+				t.Errorf("expected offset %s in %s", tt.wantOffset, resp.Timestamp)
+			}
+			if (resp.Epoch != nil) != tt.wantEpoch {
+				t.Fatalf("expected epoch present=%v, got %v", tt.wantEpoch, resp.Epoch)
+			}
+			if tt.wantEpoch {
+				if got := len(strconv.FormatInt(*resp.Epoch, 10)); got != tt.epochDigits {
+					t.Errorf("expected a %d-digit epoch, got %d", tt.epochDigits, *resp.Epoch)
+				}
+				if tt.epochDigits == 10 && *resp.Epoch != ts.Unix() {
+					t.Errorf("epoch %d does not match timestamp %s", *resp.Epoch, resp.Timestamp)
+				}
+			}
+		})
+	}
+}
+
+func TestPublicHandler_PlainText(t *testing.T) {
+	rec := serveTime(t, "/api/v1/time?format=unix", "text/plain")
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("expected text/plain, got %q", ct)
+	}
+	if !regexp.MustCompile(`^\d{10}\n$`).MatchString(rec.Body.String()) {
+		t.Errorf("expected a bare unix timestamp, got %q", rec.Body)
+	}
+
+	rec = serveTime(t, "/api/v1/time?tz=Asia/Tokyo", "text/plain; q=0.9")
+	if !regexp.MustCompile(`^\S+\+09:00\n$`).MatchString(rec.Body.String()) {
+		t.Errorf("expected a bare RFC3339 timestamp, got %q", rec.Body)
+	}
+}
+
+func TestPublicHandler_BadParams(t *testing.T) {
+	for _, query := range []string{"tz=Mars/Olympus_Mons", "tz=Local", "tz=../../etc/passwd", "format=iso"} {
+		rec := serveTime(t, "/api/v1/time?"+query, "")
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, rec.Code)
+		}
+		if !strings.Contains(rec.Body.String(), `"status":"error"`) {
+			t.Errorf("%s: expected an error JSON body, got %s", query, rec.Body)
+		}
+	}
+}
+
+func TestPublicHandler_Msgpack(t *testing.T) {
+	for name, tt := range map[string]struct{ target, accept string }{
+		"accept": {"/api/v1/time?format=unix", "application/msgpack"},
+		"alias":  {"/api/v1/time?format=unix", "application/x-msgpack"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			rec := serveTime(t, tt.target, tt.accept)
+			if ct := rec.Header().Get("Content-Type"); ct != "application/msgpack" {
+				t.Errorf("expected application/msgpack, got %q", ct)
+			}
+			var resp PublicResponse
+			dec := msgpack.NewDecoder(rec.Body)
+			dec.SetCustomStructTag("json")
+			if err := dec.Decode(&resp); err != nil {
+				t.Fatalf("failed to decode msgpack: %v", err)
+			}
+			if resp.Status != "ok" || resp.Env != "test-env" || resp.Epoch == nil {
+				t.Errorf("unexpected response %+v", resp)
+			}
+		})
+	}
+
+	// Keys match the JSON field names.
+	var raw map[string]any
+	if err := msgpack.Unmarshal(serveTime(t, "/api/v1/time", "application/msgpack").Body.Bytes(), &raw); err != nil {
+		t.Fatalf("failed to decode msgpack: %v", err)
+	}
+	for _, key := range []string{"status", "timestamp", "env"} {
+		if _, ok := raw[key]; !ok {
+			t.Errorf("expected key %q, got %v", key, raw)
+		}
+	}
+	if _, ok := raw["epoch"]; ok {
+		t.Error("expected epoch to be omitted by default")
+	}
+}
+
+func TestPublicHandler_FormatOverridesAccept(t *testing.T) {
+	tests := []struct {
+		format, accept, want string
+	}{
+		{"json", "text/plain", "application/json"},
+		{"text", "application/json", "text/plain; charset=utf-8"},
+		{"msgpack", "text/plain", "application/msgpack"},
+		{"", "image/png", "application/json"},
+		{"", "", "application/json"},
+	}
+	for _, tt := range tests {
+		rec := serveTime(t, "/api/v1/time?format="+tt.format, tt.accept)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("format=%s: expected 200, got %d", tt.format, rec.Code)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != tt.want {
+			t.Errorf("format=%q Accept=%q: expected %q, got %q", tt.format, tt.accept, tt.want, ct)
+		}
+	}
+}
+
+func TestNegotiateTimeEncoding(t *testing.T) {
+	tests := map[string]string{
+		"":                                      contentTypeJSON,
+		"*/*":                                   contentTypeJSON,
+		"text/plain":                            contentTypeText,
+		"text/plain, application/json":          contentTypeText,
+		"application/json, text/plain":          contentTypeJSON,
+		"text/html,application/xhtml+xml":       contentTypeJSON,
+		"text/plain;charset=utf-8, */*;q=0.1":   contentTypeText,
+		"application/msgpack":                   contentTypeMsgpack,
+		"image/png, application/x-msgpack":      contentTypeMsgpack,
+		"application/json, application/msgpack": contentTypeJSON,
+	}
+	for accept, want := range tests {
+		if got := negotiateTimeEncoding(accept); got != want {
+			t.Errorf("negotiateTimeEncoding(%q) = %q, want %q", accept, got, want)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultRequestTimeout = 5 * time.Second
+
+// routeDurations is a duration per registered route with a fallback for the
+// rest: how long a handler may run before the client gets a 504, or how long
+// before a request is logged as slow. Zero turns either off for the route.
+type routeDurations struct {
+	fallback time.Duration
+	routes   map[string]time.Duration
+}
+
+// requestTimeouts is set by main from REQUEST_TIMEOUT and ROUTE_TIMEOUTS.
+var requestTimeouts = routeDurations{
+	fallback: defaultRequestTimeout,
+	routes:   unboundedRoutes(),
+}
+
+// unboundedRoutes lists the routes that run without a timeout, and are never
+// logged as slow, unless ROUTE_TIMEOUTS or ROUTE_SLOW_THRESHOLDS say
+// otherwise: /admin/logs deletes in chunks for as long as it takes, log
+// exports stream until the window is sent, and CPU profiles and traces run
+// for as long as asked.
+func unboundedRoutes() map[string]time.Duration {
+	return map[string]time.Duration{
+		routeAdminLogs:         0,
+		routeLogsExport:        0,
+		routePprof + "profile": 0,
+		routePprof + "trace":   0,
+	}
+}
+
+// forRoute returns the duration for the registered pattern route.
+func (c routeDurations) forRoute(route string) time.Duration {
+	if timeout, ok := c.routes[route]; ok {
+		return timeout
+	}
+	return c.fallback
+}
+
+// parseRouteDurations parses a comma-separated list of route=duration pairs,
+// e.g. "/api/v1/stats=30s". A duration of 0 is allowed.
+func parseRouteDurations(s string) (map[string]time.Duration, error) {
+	timeouts := make(map[string]time.Duration)
+	for _, pair := range strings.Split(s, ",") {
+		route, durStr, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || !strings.HasPrefix(route, "/") {
+			return nil, fmt.Errorf("invalid entry %q: want /route=duration", pair)
+		}
+		timeout, err := time.ParseDuration(durStr)
+		if err != nil || timeout < 0 {
+			return nil, fmt.Errorf("invalid duration %q for %s: want a non-negative duration", durStr, route)
+		}
+		if _, dup := timeouts[route]; dup {
+			return nil, fmt.Errorf("duplicate route %s", route)
+		}
+		timeouts[route] = timeout
+	}
+	return timeouts, nil
+}
+
+// timeoutHandler runs next with a request context that expires after the
+// timeout for route. If next hasn't written headers by then the client gets
+// a 504 JSON error and anything next writes afterwards is discarded; once
+// headers are out, next is left to finish on the cancelled context.
+//
+// next runs on its own goroutine so a handler that ignores its context can't
+// hold the response hostage. Its panics are re-raised here, where
+// recoverMiddleware sees them.
+func timeoutHandler(route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timeout := requestTimeouts.forRoute(route)
+		if timeout <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if timeout > serverWriteTimeout {
+			// Otherwise the server would cut the connection first.
+			_ = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(timeout + time.Second))
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		tw := &timeoutWriter{w: w, header: make(http.Header), ctx: ctx}
+		done := make(chan struct{})
+		panicked := make(chan any, 1)
+		go func() {
+			defer func() {
+				if p := recover(); p != nil {
+					if p != http.ErrAbortHandler {
+						p = fmt.Sprintf("%v\n\n%s", p, debug.Stack())
+					}
+					panicked <- p
+				}
+			}()
+			next.ServeHTTP(tw, r.WithContext(ctx))
+			close(done)
+		}()
+
+		select {
+		case p := <-panicked:
+			panic(p)
+		case <-done:
+			// The handler may have returned after its writes were refused,
+			// or without writing at all, leaving its headers staged.
+			tw.mu.Lock()
+			timedOut := tw.timedOut
+			if !timedOut && !tw.wroteHeader {
+				tw.copyHeaderLocked()
+			}
+			tw.mu.Unlock()
+			if timedOut {
+				writeError(w, http.StatusGatewayTimeout, codeTimeout, "request timed out")
+			}
+			return
+		case <-ctx.Done():
+		}
+
+		tw.mu.Lock()
+		if tw.wroteHeader {
+			tw.mu.Unlock()
+			select {
+			case p := <-panicked:
+				panic(p)
+			case <-done:
+			}
+			return
+		}
+		tw.timedOut = true
+		tw.mu.Unlock()
+		writeError(w, http.StatusGatewayTimeout, codeTimeout, "request timed out")
+	})
+}
+
+// timeoutWriter forwards writes to w until the handler's context expires.
+// Headers are staged in a map of its own so a late handler can't race with
+// the 504, and a handler that reacts to the expiry by writing can't beat
+// timeoutHandler to the response.
+type timeoutWriter struct {
+	w      http.ResponseWriter
+	header http.Header
+	ctx    context.Context
+
+	mu          sync.Mutex
+	wroteHeader bool
+	timedOut    bool
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	tw.writeHeaderLocked(code)
+}
+
+func (tw *timeoutWriter) writeHeaderLocked(code int) {
+	if tw.timedOut || tw.wroteHeader {
+		return
+	}
+	if tw.ctx.Err() != nil {
+		tw.timedOut = true
+		return
+	}
+	tw.wroteHeader = true
+	tw.copyHeaderLocked()
+	tw.w.WriteHeader(code)
+}
+
+// copyHeaderLocked copies the staged headers to w.
+func (tw *timeoutWriter) copyHeaderLocked() {
+	dst := tw.w.Header()
+	for k, v := range tw.header {
+		dst[k] = v
+	}
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	tw.writeHeaderLocked(http.StatusOK)
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	return tw.w.Write(b)
+}
+
+// Flush sends the header, if it isn't out yet, and what the handler has
+// written so far, unless the handler's writes are already refused.
+func (tw *timeoutWriter) Flush() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return
+	}
+	tw.writeHeaderLocked(http.StatusOK)
+	if tw.timedOut {
+		return
+	}
+	_ = http.NewResponseController(tw.w).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, so a
+// streaming handler can lift its write deadline.
+func (tw *timeoutWriter) Unwrap() http.ResponseWriter {
+	return tw.w
+}
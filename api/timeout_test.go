@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// withRequestTimeouts sets requestTimeouts for the duration of the test.
+func withRequestTimeouts(t *testing.T, cfg routeDurations) {
+	t.Helper()
+	prev := requestTimeouts
+	requestTimeouts = cfg
+	t.Cleanup(func() { requestTimeouts = prev })
+}
+
+func TestTimeoutHandler_SlowHandler(t *testing.T) {
+	withRequestTimeouts(t, routeDurations{fallback: 20 * time.Millisecond})
+	m, _ := newTestMetrics(t)
+	ctxErr := make(chan error, 1)
+	lateWrite := make(chan error, 1)
+	rt := newRouter()
+	registerRoute(rt, "/slow", func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		ctxErr <- r.Context().Err()
+		// Writing after the 504 must neither panic nor reach the client.
+		w.WriteHeader(http.StatusOK)
+		_, err := w.Write([]byte("late"))
+		lateWrite <- err
+	})
+	status := http.StatusText(http.StatusGatewayTimeout)
+	requests := m.requestsTotal.WithLabelValues(http.MethodGet, "/slow", status, "false")
+
+	rec := httptest.NewRecorder()
+	metricsMiddleware(m, rt).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/slow", nil))
+
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("expected 504, got %d", rec.Code)
+	}
+	var body errorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("expected a JSON body: %v", err)
+	}
+	if body.Code != codeTimeout || body.Message != "request timed out" {
+		t.Errorf("expected code timeout and message 'request timed out', got %+v", body)
+	}
+	if err := <-ctxErr; !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the handler's context to expire, got %v", err)
+	}
+	if err := <-lateWrite; !errors.Is(err, http.ErrHandlerTimeout) {
+		t.Errorf("expected http.ErrHandlerTimeout for the late write, got %v", err)
+	}
+	if got := testutil.ToFloat64(requests); got != 1 {
+		t.Errorf("expected the 504 in http_requests_total, got %v", got)
+	}
+}
+
+func TestTimeoutHandler_WriteOnCancelledContext(t *testing.T) {
+	withRequestTimeouts(t, routeDurations{fallback: time.Millisecond})
+	rt := newRouter()
+	registerRoute(rt, "/wakes", func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		w.WriteHeader(http.StatusOK)
+	})
+	// The handler races timeoutHandler for the response once the deadline
+	// passes; whichever wins, the client gets the 504.
+	for i := range 50 {
+		rec := httptest.NewRecorder()
+		rt.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/wakes", nil))
+		if rec.Code != http.StatusGatewayTimeout {
+			t.Fatalf("request %d: expected 504 after the deadline, got %d", i, rec.Code)
+		}
+	}
+
+	// The race the handler wins, made deterministic: its header is refused
+	// and timeoutHandler still owns the response.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rec := httptest.NewRecorder()
+	tw := &timeoutWriter{w: rec, header: make(http.Header), ctx: ctx}
+	tw.WriteHeader(http.StatusOK)
+	if _, err := tw.Write([]byte("late")); !errors.Is(err, http.ErrHandlerTimeout) {
+		t.Errorf("expected http.ErrHandlerTimeout once the context is done, got %v", err)
+	}
+	if tw.wroteHeader || !tw.timedOut || rec.Body.Len() != 0 {
+		t.Errorf("expected the handler's write refused, got wroteHeader=%v timedOut=%v body %q", tw.wroteHeader, tw.timedOut, rec.Body)
+	}
+}
+
+func TestTimeoutHandler_HeadersWrittenBeforeDeadline(t *testing.T) {
+	withRequestTimeouts(t, routeDurations{fallback: 20 * time.Millisecond})
+	rt := newRouter()
+	registerRoute(rt, "/stream", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		<-r.Context().Done()
+		_, _ = w.Write([]byte("done"))
+	})
+
+	rec := httptest.NewRecorder()
+	rt.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stream", nil))
+
+	if rec.Code != http.StatusAccepted {
+		t.Errorf("expected the handler's 202 to stand, got %d", rec.Code)
+	}
+	if rec.Body.String() != "done" {
+		t.Errorf("expected the handler to finish its response, got %q", rec.Body.String())
+	}
+}
+
+func TestTimeoutHandler_Streams(t *testing.T) {
+	withRequestTimeouts(t, routeDurations{fallback: time.Second})
+	flushed := make(chan struct{})
+	release := make(chan struct{})
+	rcErrs := make(chan error, 2)
+	rt := newRouter()
+	registerRoute(rt, "/stream", func(w http.ResponseWriter, r *http.Request) {
+		rc := http.NewResponseController(w)
+		rcErrs <- rc.SetWriteDeadline(time.Time{})
+		_, _ = w.Write([]byte("first\n"))
+		rcErrs <- rc.Flush()
+		close(flushed)
+		<-release
+		_, _ = w.Write([]byte("second\n"))
+	})
+	srv := httptest.NewServer(rt)
+	defer srv.Close()
+	defer close(release)
+
+	// The first chunk arrives while the handler is still running.
+	first := make(chan string, 1)
+	go func() {
+		resp, err := http.Get(srv.URL + "/stream")
+		if err != nil {
+			first <- err.Error()
+			return
+		}
+		defer func() { _ = resp.Body.Close() }()
+		line, _ := bufio.NewReader(resp.Body).ReadString('\n')
+		first <- line
+	}()
+	select {
+	case line := <-first:
+		if line != "first\n" {
+			t.Fatalf("expected the flushed chunk, got %q", line)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the flushed chunk before the handler returned")
+	}
+	<-flushed
+	for range 2 {
+		if err := <-rcErrs; err != nil {
+			t.Errorf("expected the ResponseController to reach the connection, got %v", err)
+		}
+	}
+}
+
+func TestTimeoutHandler_HeadersWithoutBody(t *testing.T) {
+	withRequestTimeouts(t, routeDurations{fallback: time.Second})
+	rt := newRouter()
+	registerRoute(rt, "/empty", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Checked", "yes")
+	})
+
+	rec := httptest.NewRecorder()
+	rt.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/empty", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("X-Checked") != "yes" {
+		t.Errorf("expected the staged header on the implicit 200, got %d %v", rec.Code, rec.Header())
+	}
+}
+
+func TestTimeoutHandler_RouteOverride(t *testing.T) {
+	withRequestTimeouts(t, routeDurations{
+		fallback: 10 * time.Millisecond,
+		routes:   map[string]time.Duration{"/long": time.Second, "/unbounded": 0},
+	})
+	rt := newRouter()
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(50 * time.Millisecond):
+			w.Header().Set("X-Test", "ok")
+			w.WriteHeader(http.StatusOK)
+		case <-r.Context().Done():
+		}
+	}
+	registerRoute(rt, "/long", handler)
+	registerRoute(rt, "/unbounded", handler)
+	registerRoute(rt, "/short", handler)
+
+	for path, want := range map[string]int{"/long": http.StatusOK, "/unbounded": http.StatusOK, "/short": http.StatusGatewayTimeout} {
+		rec := httptest.NewRecorder()
+		rt.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != want {
+			t.Errorf("%s: expected %d, got %d", path, want, rec.Code)
+		}
+		if want == http.StatusOK && rec.Header().Get("X-Test") != "ok" {
+			t.Errorf("%s: expected the handler's headers", path)
+		}
+	}
+}
+
+func TestTimeoutHandler_PanicPropagates(t *testing.T) {
+	withRequestTimeouts(t, routeDurations{fallback: time.Second})
+	m, _ := newTestMetrics(t)
+	rt := newRouter()
+	registerRoute(rt, "/boom", func(w http.ResponseWriter, r *http.Request) {
+		panic("deliberate test panic")
+	})
+
+	rec := httptest.NewRecorder()
+	recoverMiddleware(m, rt, metricsMiddleware(m, rt)).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/boom", nil))
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("expected 500, got %d", rec.Code)
+	}
+	if got := testutil.ToFloat64(m.panicsTotal.WithLabelValues("/boom")); got != 1 {
+		t.Errorf("expected http_panics_total 1, got %v", got)
+	}
+}
+
+func TestParseRouteDurations(t *testing.T) {
+	got, err := parseRouteDurations("/api/v1/stats=30s, /admin/logs=0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got["/api/v1/stats"] != 30*time.Second || got["/admin/logs"] != 0 || len(got) != 2 {
+		t.Errorf("unexpected timeouts %v", got)
+	}
+
+	for _, bad := range []string{"", "/a", "a=1s", "/a=soon", "/a=-1s", "/a=1s,/a=2s"} {
+		if _, err := parseRouteDurations(bad); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
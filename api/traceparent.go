@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// headerTraceparent carries the caller's trace, in the W3C Trace Context
+// format.
+const headerTraceparent = "traceparent"
+
+// traceparentLen is the length of a version 00 traceparent:
+// 00-<32 hex trace id>-<16 hex parent id>-<2 hex flags>.
+const traceparentLen = 55
+
+// parseTraceparent parses a W3C traceparent header into the remote span
+// context it names. Following the spec, a version other than 00 is read as
+// far as the 00 fields go, as long as anything after them is set off by a
+// dash, and the invalid version ff is rejected.
+func parseTraceparent(s string) (trace.SpanContext, error) {
+	if len(s) < traceparentLen {
+		return trace.SpanContext{}, errors.New("too short")
+	}
+	version := s[:2]
+	switch {
+	case !isLowerHex(version):
+		return trace.SpanContext{}, errors.New("version is not lowercase hex")
+	case version == "ff":
+		return trace.SpanContext{}, errors.New("version ff is invalid")
+	case version == "00" && len(s) != traceparentLen:
+		return trace.SpanContext{}, errors.New("trailing data after version 00 fields")
+	case len(s) > traceparentLen && s[traceparentLen] != '-':
+		return trace.SpanContext{}, errors.New("trailing data not set off by a dash")
+	}
+	if s[2] != '-' || s[35] != '-' || s[52] != '-' {
+		return trace.SpanContext{}, errors.New("fields not separated by dashes")
+	}
+	traceHex, spanHex, flagsHex := s[3:35], s[36:52], s[53:55]
+	if !isLowerHex(traceHex) || !isLowerHex(spanHex) || !isLowerHex(flagsHex) {
+		return trace.SpanContext{}, errors.New("fields are not lowercase hex")
+	}
+	traceID, err := trace.TraceIDFromHex(traceHex)
+	if err != nil {
+		return trace.SpanContext{}, errors.New("trace id is all zeros")
+	}
+	spanID, err := trace.SpanIDFromHex(spanHex)
+	if err != nil {
+		return trace.SpanContext{}, errors.New("parent id is all zeros")
+	}
+	flags, _ := hex.DecodeString(flagsHex)
+	return trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.TraceFlags(flags[0]),
+		Remote:     true,
+	}), nil
+}
+
+// formatTraceparent formats sc as a version 00 traceparent header.
+func formatTraceparent(sc trace.SpanContext) string {
+	return "00-" + sc.TraceID().String() + "-" + sc.SpanID().String() + "-" + sc.TraceFlags().String()
+}
+
+func isLowerHex(s string) bool {
+	return s != "" && strings.Trim(s, "0123456789abcdef") == ""
+}
+
+// traceparentMiddleware correlates a request with its caller's trace
+// without needing tracing: a valid traceparent header puts the caller's
+// span in the request context, where the access log and api_logs pick up
+// its trace ID, and is echoed on the response for the hops downstream. A
+// malformed header is ignored and counted on invalid. tracingMiddleware
+// runs inside it when tracing is on and starts its span from the same
+// header.
+func traceparentMiddleware(invalid prometheus.Counter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get(headerTraceparent)
+		if header == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		sc, err := parseTraceparent(header)
+		if err != nil {
+			invalid.Inc()
+			slog.Debug("ignoring malformed traceparent", "traceparent", header, "error", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set(headerTraceparent, formatTraceparent(sc))
+		next.ServeHTTP(w, r.WithContext(trace.ContextWithRemoteSpanContext(r.Context(), sc)))
+	})
+}
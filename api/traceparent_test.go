@@ -0,0 +1,154 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The examples of the W3C Trace Context recommendation.
+const (
+	specTraceID      = "0af7651916cd43dd8448eb211c80319c"
+	specParentID     = "b7ad6b7169203331"
+	specTraceparent  = "00-" + specTraceID + "-" + specParentID + "-01"
+	specNotSampled   = "00-" + specTraceID + "-" + specParentID + "-00"
+	specFutureHeader = "cc-" + specTraceID + "-" + specParentID + "-01-what-the-future-will-be-like"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	for _, tc := range []struct {
+		header      string
+		wantSampled bool
+	}{
+		{specTraceparent, true},
+		{specNotSampled, false},
+		{specFutureHeader, true},
+	} {
+		sc, err := parseTraceparent(tc.header)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.header, err)
+			continue
+		}
+		if sc.TraceID().String() != specTraceID || sc.SpanID().String() != specParentID || !sc.IsRemote() {
+			t.Errorf("%s: unexpected span context %+v", tc.header, sc)
+		}
+		if sc.IsSampled() != tc.wantSampled {
+			t.Errorf("%s: expected sampled=%v", tc.header, tc.wantSampled)
+		}
+	}
+}
+
+func TestParseTraceparent_Invalid(t *testing.T) {
+	for name, header := range map[string]string{
+		"empty":             "",
+		"truncated":         specTraceparent[:54],
+		"uppercase":         strings.ToUpper(specTraceparent),
+		"version ff":        "ff" + specTraceparent[2:],
+		"version 00 suffix": specTraceparent + "-extra",
+		"future no dash":    "cc" + specTraceparent[2:] + "x",
+		"zero trace id":     "00-" + strings.Repeat("0", 32) + "-" + specParentID + "-01",
+		"zero parent id":    "00-" + specTraceID + "-" + strings.Repeat("0", 16) + "-01",
+		"separators":        "00_" + specTraceID + "_" + specParentID + "_01",
+		"not hex":           "00-" + specTraceID + "-" + specParentID + "-0g",
+	} {
+		if _, err := parseTraceparent(header); err == nil {
+			t.Errorf("%s: expected %q to be rejected", name, header)
+		}
+	}
+}
+
+func TestFormatTraceparent(t *testing.T) {
+	for header, want := range map[string]string{
+		specTraceparent:  specTraceparent,
+		specNotSampled:   specNotSampled,
+		specFutureHeader: specTraceparent,
+	} {
+		sc, err := parseTraceparent(header)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", header, err)
+		}
+		if got := formatTraceparent(sc); got != want {
+			t.Errorf("expected %s, got %s", want, got)
+		}
+	}
+}
+
+func TestTraceparentMiddleware(t *testing.T) {
+	invalid := prometheus.NewCounter(prometheus.CounterOpts{Name: "traceparent_invalid_total"})
+	var got trace.SpanContext
+	h := traceparentMiddleware(invalid, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = trace.SpanContextFromContext(r.Context())
+	}))
+	serve := func(header string) *httptest.ResponseRecorder {
+		t.Helper()
+		got = trace.SpanContext{}
+		req := httptest.NewRequest(http.MethodGet, routePublic, nil)
+		if header != "" {
+			req.Header.Set(headerTraceparent, header)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve(specTraceparent)
+	if got.TraceID().String() != specTraceID || got.SpanID().String() != specParentID {
+		t.Errorf("expected the caller's span in the request context, got %+v", got)
+	}
+	if echoed := rec.Header().Get(headerTraceparent); echoed != specTraceparent {
+		t.Errorf("expected the header echoed, got %q", echoed)
+	}
+
+	for _, header := range []string{"", "00-garbage"} {
+		rec = serve(header)
+		if got.IsValid() || rec.Header().Get(headerTraceparent) != "" {
+			t.Errorf("%q: expected the request left uncorrelated, got %+v", header, got)
+		}
+	}
+	if n := testutil.ToFloat64(invalid); n != 1 {
+		t.Errorf("expected only the malformed header counted, got %v", n)
+	}
+}
+
+func TestTraceparentMiddleware_Correlates(t *testing.T) {
+	prevBuffer := logBuffer
+	logBuffer = make(chan logEntry, 1)
+	t.Cleanup(func() { logBuffer = prevBuffer })
+	m, _ := newTestMetrics(t)
+	mux := newPublicMux("test")
+	logs := captureLogs(t)
+
+	req := httptest.NewRequest(http.MethodGet, routePublic, nil)
+	req.Header.Set(headerTraceparent, specTraceparent)
+	traceparentMiddleware(m.traceparentInvalid, metricsMiddleware(m, mux)).ServeHTTP(httptest.NewRecorder(), req)
+
+	if id := (<-logBuffer).traceID(); !id.Valid || id.String != specTraceID {
+		t.Errorf("expected trace_id column %s, got %+v", specTraceID, id)
+	}
+	if want := `"trace_id":"` + specTraceID + `","span_id":"` + specParentID + `"`; !strings.Contains(logs.String(), want) {
+		t.Errorf("expected %s in the request completed record, got %s", want, logs.String())
+	}
+}
+
+func TestTraceparentMiddleware_WrapsTracing(t *testing.T) {
+	exp := useTestTracer(t)
+	m, _ := newTestMetrics(t)
+	mux := newPublicMux("test")
+	req := httptest.NewRequest(http.MethodGet, routePublic, nil)
+	req.Header.Set(headerTraceparent, specTraceparent)
+	rec := httptest.NewRecorder()
+	traceparentMiddleware(m.traceparentInvalid, tracingMiddleware(mux, mux)).ServeHTTP(rec, req)
+
+	span := spanNamed(t, exp, "GET "+routePublic)
+	if span.SpanContext.TraceID().String() != specTraceID || span.Parent.SpanID().String() != specParentID {
+		t.Errorf("expected the server span to continue the caller's trace, got %+v", span.SpanContext)
+	}
+	if echoed := rec.Header().Get(headerTraceparent); echoed != specTraceparent {
+		t.Errorf("expected the header echoed, got %q", echoed)
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const testTraceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+// useTestTracer records the spans started until the test ends in memory.
+func useTestTracer(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	exp := tracetest.NewInMemoryExporter()
+	tp := newTracerProvider(newServiceResource("api-test", "test"), sdktrace.WithSyncer(exp))
+	prevTracer, prevTracing := tracer, tracing
+	useTracerProvider(tp)
+	t.Cleanup(func() {
+		tracer, tracing = prevTracer, prevTracing
+		_ = tp.Shutdown(context.Background())
+	})
+	return exp
+}
+
+// spanNamed returns the only recorded span called name.
+func spanNamed(t *testing.T, exp *tracetest.InMemoryExporter, name string) tracetest.SpanStub {
+	t.Helper()
+	var found []tracetest.SpanStub
+	for _, s := range exp.GetSpans() {
+		if s.Name == name {
+			found = append(found, s)
+		}
+	}
+	if len(found) != 1 {
+		t.Fatalf("expected one %q span, got %d of %d spans", name, len(found), len(exp.GetSpans()))
+	}
+	return found[0]
+}
+
+func spanAttr(s tracetest.SpanStub, key attribute.Key) attribute.Value {
+	for _, kv := range s.Attributes {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestTracingMiddleware_ServerSpan(t *testing.T) {
+	exp := useTestTracer(t)
+	m, _ := newTestMetrics(t)
+	mux := newPublicMux("test")
+	h := tracingMiddleware(mux, metricsMiddleware(m, mux))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, routePublic+"?tz=UTC", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+
+	span := spanNamed(t, exp, "GET "+routePublic)
+	if span.SpanKind != trace.SpanKindServer {
+		t.Errorf("expected a server span, got %v", span.SpanKind)
+	}
+	if span.Parent.IsValid() {
+		t.Errorf("expected a new trace without traceparent, got parent %v", span.Parent)
+	}
+	for key, want := range map[attribute.Key]string{
+		"http.request.method": http.MethodGet,
+		"http.route":          routePublic,
+		"url.path":            routePublic,
+	} {
+		if got := spanAttr(span, key).AsString(); got != want {
+			t.Errorf("expected %s=%q, got %q", key, want, got)
+		}
+	}
+	if got := spanAttr(span, "http.response.status_code").AsInt64(); got != http.StatusOK {
+		t.Errorf("expected http.response.status_code=200, got %d", got)
+	}
+	if span.Status.Code != codes.Unset {
+		t.Errorf("expected an unset status for a 200, got %v", span.Status)
+	}
+	if name, ok := span.Resource.Set().Value("service.name"); !ok || name.AsString() != "api-test" {
+		t.Errorf("expected service.name api-test on the resource, got %v", name)
+	}
+}
+
+func TestTracingMiddleware_ContinuesTraceparent(t *testing.T) {
+	exp := useTestTracer(t)
+	mux := newPublicMux("test")
+	req := httptest.NewRequest(http.MethodGet, routePublic, nil)
+	req.Header.Set("traceparent", testTraceparent)
+	tracingMiddleware(mux, mux).ServeHTTP(httptest.NewRecorder(), req)
+
+	span := spanNamed(t, exp, "GET "+routePublic)
+	if got := span.SpanContext.TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("expected the incoming trace to continue, got trace %s", got)
+	}
+	if !span.Parent.IsRemote() || span.Parent.SpanID().String() != "00f067aa0ba902b7" {
+		t.Errorf("expected the caller's span as remote parent, got %v", span.Parent)
+	}
+}
+
+func TestTracingMiddleware_ServerErrors(t *testing.T) {
+	exp := useTestTracer(t)
+	m, _ := newTestMetrics(t)
+	rt := newRouter()
+	registerRoute(rt, "/fail", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusServiceUnavailable, codeUnavailable, "unavailable")
+	})
+	registerRoute(rt, "/panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+	h := recoverMiddleware(m, rt, tracingMiddleware(rt, rt))
+
+	for path, want := range map[string]int{"/fail": http.StatusServiceUnavailable, "/panic": http.StatusInternalServerError} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+		span := spanNamed(t, exp, "GET "+path)
+		if got := spanAttr(span, "http.response.status_code").AsInt64(); got != int64(want) {
+			t.Errorf("%s: expected http.response.status_code=%d, got %d", path, want, got)
+		}
+		if span.Status.Code != codes.Error {
+			t.Errorf("%s: expected an error status, got %v", path, span.Status)
+		}
+	}
+}
+
+func TestTracingMiddleware_Disabled(t *testing.T) {
+	var traced atomic.Bool
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traced.Store(trace.SpanContextFromContext(r.Context()).IsValid())
+	})
+	req := httptest.NewRequest(http.MethodGet, routePublic, nil)
+	req.Header.Set("traceparent", testTraceparent)
+	tracingMiddleware(newPublicMux("test"), next).ServeHTTP(httptest.NewRecorder(), req)
+	if traced.Load() {
+		t.Error("expected no span in the request context without tracing")
+	}
+}
+
+func TestObserveRequest_TraceID(t *testing.T) {
+	prevBuffer := logBuffer
+	logBuffer = make(chan logEntry, 2)
+	t.Cleanup(func() { logBuffer = prevBuffer })
+	m, _ := newTestMetrics(t)
+	mux := newPublicMux("test")
+
+	// serve returns the trace_id of the request completed record, or "",
+	// and the trace_id column of the access log entry.
+	serve := func(h http.Handler) (string, logEntry) {
+		t.Helper()
+		buf := captureLogs(t)
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, routePublic, nil))
+		var record struct {
+			Msg     string `json:"msg"`
+			TraceID string `json:"trace_id"`
+		}
+		for line := range strings.SplitSeq(strings.TrimSpace(buf.String()), "\n") {
+			if err := json.Unmarshal([]byte(line), &record); err == nil && record.Msg == "request completed" {
+				break
+			}
+		}
+		if record.Msg != "request completed" {
+			t.Fatalf("expected a request completed record, got %s", buf.String())
+		}
+		return record.TraceID, <-logBuffer
+	}
+
+	logged, entry := serve(metricsMiddleware(m, mux))
+	if logged != "" || entry.traceID().Valid {
+		t.Errorf("expected no trace ID without tracing, got log %q and column %+v", logged, entry.traceID())
+	}
+
+	exp := useTestTracer(t)
+	logged, entry = serve(tracingMiddleware(mux, metricsMiddleware(m, mux)))
+	want := spanNamed(t, exp, "GET "+routePublic).SpanContext.TraceID().String()
+	if logged != want {
+		t.Errorf("expected trace_id %s in the request completed record, got %q", want, logged)
+	}
+	if id := entry.traceID(); !id.Valid || id.String != want {
+		t.Errorf("expected trace_id column %s, got %+v", want, id)
+	}
+}
+
+func TestFlushLog_InsertSpan(t *testing.T) {
+	exp := useTestTracer(t)
+	m, _ := newTestMetrics(t)
+	_, parent := tracer.Start(context.Background(), "request")
+	parent.End()
+	entry := testLogEntry
+	entry.trace = parent.SpanContext()
+
+	mockDB, mock, _ := sqlmock.New()
+	defer func() { _ = mockDB.Close() }()
+	dbMu.Lock()
+	db = mockDB
+	dbMu.Unlock()
+	defer func() {
+		dbMu.Lock()
+		db = nil
+		dbMu.Unlock()
+	}()
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO api_logs")).
+		WithArgs("GET", "/api/v1/time", 200, 1.5, 0.5, "10.0.0.1", entry.trace.TraceID().String(), false).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	flushLog(sqlLogSink{}, entry, m)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	span := spanNamed(t, exp, "INSERT api_logs")
+	if span.Parent.SpanID() != entry.trace.SpanID() || span.SpanContext.TraceID() != entry.trace.TraceID() {
+		t.Errorf("expected the insert under the request span, got parent %v", span.Parent)
+	}
+	if span.SpanKind != trace.SpanKindClient || spanAttr(span, "db.system.name").AsString() != "postgresql" {
+		t.Errorf("expected a postgresql client span, got kind %v attributes %v", span.SpanKind, span.Attributes)
+	}
+	if span.Status.Code != codes.Unset {
+		t.Errorf("expected an unset status for a successful insert, got %v", span.Status)
+	}
+
+	exp.Reset()
+	flushLog(logSinkFunc(func(context.Context, logEntry) error { return errors.New("insert failed") }), entry, m)
+	if span := spanNamed(t, exp, "INSERT api_logs"); span.Status.Code != codes.Error || span.Status.Description != "insert failed" {
+		t.Errorf("expected a failed insert to mark its span, got %v", span.Status)
+	}
+}
+
+func TestSetupTracing_ExportsToEndpoint(t *testing.T) {
+	var path atomic.Value
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path.Store(r.URL.Path)
+	}))
+	defer collector.Close()
+	prevTracer, prevTracing := tracer, tracing
+	t.Cleanup(func() { tracer, tracing = prevTracer, prevTracing })
+
+	shutdown, err := setupTracing(context.Background(), collector.URL, newServiceResource("api", "test"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !tracing {
+		t.Error("expected tracing to be enabled")
+	}
+	_, span := tracer.Start(context.Background(), "request")
+	span.End()
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("unexpected error flushing spans: %v", err)
+	}
+	if got, _ := path.Load().(string); got != "/v1/traces" {
+		t.Errorf("expected the spans to be posted to /v1/traces, got %q", got)
+	}
+}
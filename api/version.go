@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"runtime"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Build metadata, set with
+// -ldflags "-X main.version=... -X main.commit=... -X main.buildDate=...".
+var (
+	version   = "dev"
+	commit    = "unknown"
+	buildDate = "unknown"
+)
+
+// buildInfo describes this binary for --version.
+func buildInfo() string {
+	return fmt.Sprintf("%s %s (commit %s, built %s, %s)", defaultServiceName, version, commit, buildDate, runtime.Version())
+}
+
+// newBuildInfo returns the build_info gauge with this binary's labels set.
+func newBuildInfo() *prometheus.GaugeVec {
+	g := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "build_info",
+			Help: "Build metadata as labels; the value is always 1",
+		},
+		[]string{"version", "commit", "build_date", "go_version"},
+	)
+	g.WithLabelValues(version, commit, buildDate, runtime.Version()).Set(1)
+	return g
+}
+
+// VersionResponse represents the JSON response for the version endpoint.
+type VersionResponse struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+}
+
+func versionHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, VersionResponse{
+		Version:   version,
+		Commit:    commit,
+		BuildDate: buildDate,
+		GoVersion: runtime.Version(),
+	})
+}
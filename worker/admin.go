@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// adminHandler guards a control endpoint: when token is non-empty the
+// request must carry "Authorization: Bearer <token>". Routes wrap it in
+// methods to restrict it to POST.
+func adminHandler(token string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token != "" && !validBearerToken(r, token) {
+			writeError(w, http.StatusUnauthorized, codeUnauthorized, "unauthorized")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// validBearerToken compares the request's bearer token against token in
+// constant time.
+func validBearerToken(r *http.Request, token string) bool {
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+// pauseResponse is the JSON body of /admin/pause and /admin/resume.
+type pauseResponse struct {
+	Status string `json:"status"`
+	Paused bool   `json:"paused"`
+}
+
+func pauseHandler(worker *Worker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		worker.Pause()
+		writeJSON(w, http.StatusOK, pauseResponse{Status: "ok", Paused: true})
+	}
+}
+
+func resumeHandler(worker *Worker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		worker.Resume()
+		writeJSON(w, http.StatusOK, pauseResponse{Status: "ok", Paused: false})
+	}
+}
+
+// processResponse is the JSON body of /admin/process.
+type processResponse struct {
+	Status    string `json:"status"`
+	Processed int    `json:"processed"`
+}
+
+// processHandler runs a batch now and reports how many rows it processed.
+// By default it waits for a batch already in flight to finish first;
+// ?wait=false gets a 409 instead.
+func processHandler(worker *Worker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		wait := true
+		if v := r.URL.Query().Get("wait"); v != "" {
+			var err error
+			if wait, err = strconv.ParseBool(v); err != nil {
+				writeError(w, http.StatusBadRequest, codeInvalidRequest, "wait must be true or false")
+				return
+			}
+		}
+		processed, err := worker.Kick(r.Context(), wait)
+		switch {
+		case errors.Is(err, errWorkerPaused), errors.Is(err, errBatchInFlight):
+			writeError(w, http.StatusConflict, codeConflict, err.Error())
+		case errors.Is(err, errWorkerReconnecting), errors.Is(err, errWorkerStopped):
+			writeError(w, http.StatusServiceUnavailable, codeUnavailable, err.Error())
+		case r.Context().Err() != nil:
+			// The client has gone; the batch still runs.
+		case err != nil:
+			writeError(w, http.StatusInternalServerError, codeInternal, "batch failed")
+		default:
+			writeJSON(w, http.StatusOK, processResponse{Status: "ok", Processed: processed})
+		}
+	}
+}
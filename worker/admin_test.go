@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestAdminHandler_RequiresToken(t *testing.T) {
+	w := NewWorker(1 * time.Second)
+	handler := setupHealthServer(w, prometheus.NewRegistry(), "0", newAdminMux(w, "s3cret")).Handler
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/pause", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without token, got %d", rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/admin/pause", nil)
+	req.Header.Set("Authorization", "Bearer wrong")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 with wrong token, got %d", rec.Code)
+	}
+	if w.IsPaused() {
+		t.Error("expected unauthorized request not to pause the worker")
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/admin/pause", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected 200 with token, got %d", rec.Code)
+	}
+	if !w.IsPaused() {
+		t.Error("expected worker to be paused")
+	}
+	w.Resume()
+}
+
+func TestAdminPort_RoutesOnlyOnAdminServer(t *testing.T) {
+	w := NewWorker(1 * time.Second)
+	health := setupHealthServer(w, prometheus.NewRegistry(), "0", nil).Handler
+	admin := newAdminServer(0, "s3cret", newAdminMux(w, "s3cret"), true).Handler
+
+	serve := func(h http.Handler, method, target string) int {
+		req := httptest.NewRequest(method, target, nil)
+		req.Header.Set("Authorization", "Bearer s3cret")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	for _, target := range []string{"/admin/pause", "/admin/resume", "/debug/pprof/"} {
+		if code := serve(health, http.MethodPost, target); code != http.StatusNotFound {
+			t.Errorf("expected 404 for %s on the health server, got %d", target, code)
+		}
+	}
+	if w.IsPaused() {
+		t.Error("expected the health server not to pause the worker")
+	}
+	if code := serve(admin, http.MethodPost, "/admin/pause"); code != http.StatusOK || !w.IsPaused() {
+		t.Errorf("expected the admin server to pause the worker, got %d", code)
+	}
+	if code := serve(admin, http.MethodPost, "/admin/resume"); code != http.StatusOK || w.IsPaused() {
+		t.Errorf("expected the admin server to resume the worker, got %d", code)
+	}
+	if code := serve(admin, http.MethodGet, "/debug/pprof/"); code != http.StatusOK {
+		t.Errorf("expected the pprof index on the admin server, got %d", code)
+	}
+	if code := serve(health, http.MethodGet, "/live"); code != http.StatusOK {
+		t.Errorf("expected the health server to keep serving /live, got %d", code)
+	}
+	if code := serve(admin, http.MethodGet, "/live"); code != http.StatusNotFound {
+		t.Errorf("expected 404 for /live on the admin server, got %d", code)
+	}
+}
+
+func TestAdminHandler_MethodNotAllowed(t *testing.T) {
+	w := NewWorker(1 * time.Second)
+	handler := setupHealthServer(w, prometheus.NewRegistry(), "0", newAdminMux(w, "")).Handler
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/pause", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected 405, got %d", rec.Code)
+	}
+	if allow := rec.Header().Get("Allow"); allow != http.MethodPost {
+		t.Errorf("expected Allow: POST, got '%s'", allow)
+	}
+}
+
+func TestPauseResume_StatsAndGauge(t *testing.T) {
+	w := NewWorker(1 * time.Second)
+	handler := setupHealthServer(w, prometheus.NewRegistry(), "0", newAdminMux(w, "")).Handler
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/admin/pause", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	if v := testutil.ToFloat64(w.metrics.paused); v != 1 {
+		t.Errorf("expected worker_paused 1, got %v", v)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stats", nil))
+	var stats WorkerStats
+	if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("failed to parse stats JSON: %v", err)
+	}
+	if !stats.Paused {
+		t.Error("expected stats to report paused")
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/admin/resume", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	if v := testutil.ToFloat64(w.metrics.paused); v != 0 {
+		t.Errorf("expected worker_paused 0, got %v", v)
+	}
+	if w.Stats().Paused {
+		t.Error("expected stats to report running after resume")
+	}
+}
+
+func TestPause_NotStale(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	w := NewWorker(1*time.Second, WithClock(clock))
+	w.lastRunAt = clock.Now()
+	clock.Advance(time.Hour)
+	w.Pause()
+	defer w.Resume()
+	if !w.IsHealthy() {
+		t.Error("expected paused worker not to be reported stale")
+	}
+}
+
+func TestResume_TriggersImmediateCycle(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer func() { _ = mockDB.Close() }()
+	dbMu.Lock()
+	db = mockDB
+	dbMu.Unlock()
+
+	// With an hour-long interval, only Resume can cause a prompt batch.
+	w := NewWorker(time.Hour)
+	w.Pause()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Run(ctx)
+
+	time.Sleep(20 * time.Millisecond)
+	if !w.LastRunAt().IsZero() {
+		t.Fatal("expected paused worker not to process")
+	}
+
+	expectBatch(mock, 0)
+	w.Resume()
+
+	deadline := time.Now().Add(time.Second)
+	for w.LastRunAt().IsZero() && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if w.LastRunAt().IsZero() {
+		t.Fatal("expected Resume to trigger an immediate processing cycle")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled mock: %s", err)
+	}
+}
+
+// startKickTest runs a worker with an hour-long interval, so only a kick can
+// cause a prompt second batch, against a fresh sqlmock expecting the first
+// cycle's batch to fail with firstErr, or to find nothing when it is nil.
+func startKickTest(t *testing.T, firstErr error) (*Worker, sqlmock.Sqlmock, http.Handler) {
+	t.Helper()
+	mockDB, mock, _ := sqlmock.New()
+	t.Cleanup(func() { _ = mockDB.Close() })
+	dbMu.Lock()
+	db = mockDB
+	dbMu.Unlock()
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(io.Discard, nil)))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+
+	w := NewWorker(time.Hour)
+	if firstErr != nil {
+		expectBatchError(mock, firstErr)
+	} else {
+		expectBatch(mock, 0)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	deadline := time.Now().Add(time.Second)
+	for w.LastRunAt().IsZero() && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if w.LastRunAt().IsZero() {
+		t.Fatal("expected the first cycle to run")
+	}
+	return w, mock, setupHealthServer(w, prometheus.NewRegistry(), "0", newAdminMux(w, "")).Handler
+}
+
+func postProcess(t *testing.T, handler http.Handler, target string) (*httptest.ResponseRecorder, processResponse) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, target, nil))
+	var body processResponse
+	_ = json.Unmarshal(rec.Body.Bytes(), &body)
+	return rec, body
+}
+
+func TestProcessHandler_RunsBatchNow(t *testing.T) {
+	w, mock, handler := startKickTest(t, nil)
+
+	// Rows were found, so the loop goes on draining straight away rather
+	// than waiting out the hour.
+	expectBatch(mock, 3)
+	expectBatch(mock, 0)
+	rec, body := postProcess(t, handler, "/admin/process")
+	if rec.Code != http.StatusOK || body.Processed != 3 {
+		t.Fatalf("expected 200 with 3 rows, got %d: %s", rec.Code, rec.Body)
+	}
+	if rows := w.Stats().LastBatchRows; rows != 3 {
+		t.Errorf("expected the kicked batch recorded as the last run, got %d rows", rows)
+	}
+	deadline := time.Now().Add(time.Second)
+	for mock.ExpectationsWereMet() != nil && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expected the loop to keep draining after the kick: %s", err)
+	}
+}
+
+func TestProcessHandler_InFlight(t *testing.T) {
+	_, mock, handler := startKickTest(t, nil)
+
+	// Hold a kicked batch in flight while the others arrive. The waiting
+	// kick's batch finds rows, so the loop drains once more after it.
+	mock.ExpectBegin()
+	mock.ExpectQuery(claimQuery).WillDelayFor(200 * time.Millisecond).WillReturnRows(processedRows(0))
+	mock.ExpectCommit()
+	expectBatch(mock, 2)
+	expectBatch(mock, 0)
+	first := make(chan *httptest.ResponseRecorder)
+	go func() {
+		rec, _ := postProcess(t, handler, "/admin/process")
+		first <- rec
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	rec, _ := postProcess(t, handler, "/admin/process?wait=false")
+	if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), `"code":"conflict"`) {
+		t.Errorf("expected 409 while a batch is in flight, got %d: %s", rec.Code, rec.Body)
+	}
+	rec, body := postProcess(t, handler, "/admin/process?wait=true")
+	if rec.Code != http.StatusOK || body.Processed != 2 {
+		t.Errorf("expected the waiting kick to run the next batch, got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := <-first; rec.Code != http.StatusOK {
+		t.Errorf("expected the first kick to succeed, got %d", rec.Code)
+	}
+}
+
+func TestProcessHandler_KeepsBackoff(t *testing.T) {
+	w, mock, handler := startKickTest(t, errors.New("connection reset"))
+
+	// The first cycle failed, so the loop is backing off; a kick still runs
+	// at once.
+	expectBatchError(mock, errors.New("connection reset"))
+	rec, _ := postProcess(t, handler, "/admin/process")
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500 for a failed batch, got %d: %s", rec.Code, rec.Body)
+	}
+	// A failed kick keeps the loop backing off rather than retrying at once.
+	if next := w.Stats().NextRunAt; next == "" || !closeTo(t, next, time.Now().Add(maxErrorBackoff)) {
+		t.Errorf("expected the next run a backoff away, got %q", next)
+	}
+
+	expectBatch(mock, 0)
+	if rec, body := postProcess(t, handler, "/admin/process"); rec.Code != http.StatusOK || body.Processed != 0 {
+		t.Fatalf("expected 200 with 0 rows, got %d: %s", rec.Code, rec.Body)
+	}
+	if stats := w.Stats(); stats.ConsecutiveErrors != 0 || !closeTo(t, stats.NextRunAt, time.Now().Add(time.Hour)) {
+		t.Errorf("expected a successful kick to reset the backoff, got %+v", stats)
+	}
+}
+
+// closeTo reports whether the RFC 3339 time s is within a few seconds of
+// want.
+func closeTo(t *testing.T, s string, want time.Time) bool {
+	t.Helper()
+	got, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		t.Fatalf("invalid time %q: %v", s, err)
+	}
+	return got.Sub(want).Abs() < 5*time.Second
+}
+
+func TestProcessHandler_Rejects(t *testing.T) {
+	w, mock, handler := startKickTest(t, nil)
+
+	if rec, _ := postProcess(t, handler, "/admin/process?wait=maybe"); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid wait, got %d", rec.Code)
+	}
+
+	w.Pause()
+	rec, _ := postProcess(t, handler, "/admin/process")
+	if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), "paused") {
+		t.Errorf("expected 409 while paused, got %d: %s", rec.Code, rec.Body)
+	}
+	expectBatch(mock, 0)
+	w.Resume() // wakes the loop for a cycle of its own
+
+	dbReconnecting.Store(true)
+	rec, _ = postProcess(t, handler, "/admin/process")
+	dbReconnecting.Store(false)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 while reconnecting, got %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestKick_StoppedWorker(t *testing.T) {
+	w := NewWorker(time.Hour)
+	close(w.stopped) // as Run does when it returns
+	if _, err := w.Kick(context.Background(), true); !errors.Is(err, errWorkerStopped) {
+		t.Errorf("expected errWorkerStopped, got %v", err)
+	}
+}
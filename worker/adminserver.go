@@ -0,0 +1,101 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+	"strconv"
+	"time"
+)
+
+// routeAdminPrefix is where both services mount their control routes.
+const routeAdminPrefix = "/admin/"
+
+// adminWriteTimeout caps how long an admin response may take. It is long
+// enough for a CPU profile or trace of the default 30 seconds and more.
+const adminWriteTimeout = 5 * time.Minute
+
+// newAdminServer returns the server for ADMIN_PORT. It serves admin, the
+// service's /admin/* routes, and, when pprofOn is set from ENABLE_PPROF,
+// the net/http/pprof handlers under /debug/pprof/. Every request needs
+// token as a bearer token, whatever the route, and is logged as an "admin
+// request" line of its own. This file is kept identical in api/ and worker/
+// so both binaries set the server up the same way;
+// TestAdminServer_MatchesWorker checks it.
+func newAdminServer(port int, token string, admin http.Handler, pprofOn bool) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle(routeAdminPrefix, admin)
+	if pprofOn {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+	return &http.Server{
+		Addr:              ":" + strconv.Itoa(port),
+		Handler:           logAdminRequests(requireAdminToken(token, mux)),
+		ReadTimeout:       10 * time.Second,
+		ReadHeaderTimeout: 5 * time.Second,
+		WriteTimeout:      adminWriteTimeout,
+		IdleTimeout:       60 * time.Second,
+	}
+}
+
+// requireAdminToken refuses requests without token as their bearer token.
+// An empty token refuses them all; configuration validation makes
+// ADMIN_PORT require ADMIN_TOKEN.
+func requireAdminToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token == "" || !validBearerToken(r, token) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			writeError(w, http.StatusUnauthorized, codeUnauthorized, "unauthorized")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// logAdminRequests logs every request to the admin server, refused ones
+// included, so privileged calls leave a trail apart from the service's
+// traffic.
+func logAdminRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &adminResponseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		slog.Info("admin request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"duration_ms", float64(time.Since(start).Microseconds())/1000,
+			"remote_addr", r.RemoteAddr,
+		)
+	})
+}
+
+// adminResponseRecorder captures the status an admin handler writes.
+type adminResponseRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (r *adminResponseRecorder) WriteHeader(code int) {
+	if !r.wroteHeader {
+		r.status = code
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *adminResponseRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	return r.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the connection, so a purge or
+// profile can lift its write deadline.
+func (r *adminResponseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
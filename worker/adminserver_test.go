@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// serveAdmin sends one request with bearer, when set, to an admin server
+// with pprof on whose /admin/* routes answer 200 and returns the response
+// with what the server logged.
+func serveAdmin(t *testing.T, token, method, target, bearer string) (*httptest.ResponseRecorder, string) {
+	t.Helper()
+	var buf bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	defer slog.SetDefault(prev)
+
+	admin := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("admin"))
+	})
+	req := httptest.NewRequest(method, target, nil)
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	rec := httptest.NewRecorder()
+	newAdminServer(0, token, admin, true).Handler.ServeHTTP(rec, req)
+	return rec, buf.String()
+}
+
+func TestAdminServer_RequiresToken(t *testing.T) {
+	for _, tt := range []struct {
+		name, token, bearer string
+		want                int
+	}{
+		{"no token", "s3cret", "", http.StatusUnauthorized},
+		{"wrong token", "s3cret", "guess", http.StatusUnauthorized},
+		{"right token", "s3cret", "s3cret", http.StatusOK},
+		{"server without a token", "", "anything", http.StatusUnauthorized},
+	} {
+		for _, target := range []string{"/admin/anything", "/debug/pprof/cmdline"} {
+			rec, _ := serveAdmin(t, tt.token, http.MethodGet, target, tt.bearer)
+			if rec.Code != tt.want {
+				t.Errorf("%s: %s: expected %d, got %d", tt.name, target, tt.want, rec.Code)
+			}
+			if rec.Code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Errorf("%s: %s: expected a WWW-Authenticate challenge", tt.name, target)
+			}
+		}
+	}
+}
+
+func TestAdminServer_Routes(t *testing.T) {
+	rec, _ := serveAdmin(t, "s3cret", http.MethodPost, "/admin/pause", "s3cret")
+	if rec.Code != http.StatusOK || rec.Body.String() != "admin" {
+		t.Errorf("expected /admin/* passed to the service's routes, got %d %s", rec.Code, rec.Body)
+	}
+	rec, _ = serveAdmin(t, "s3cret", http.MethodGet, "/debug/pprof/", "s3cret")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "goroutine") {
+		t.Errorf("expected the pprof index, got %d", rec.Code)
+	}
+	rec, _ = serveAdmin(t, "s3cret", http.MethodGet, "/live", "s3cret")
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 outside /admin/* and pprof, got %d", rec.Code)
+	}
+}
+
+func TestAdminServer_PprofOff(t *testing.T) {
+	admin := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	srv := newAdminServer(0, "s3cret", admin, false)
+	for _, target := range []string{"/debug/pprof/", "/debug/pprof/profile", "/debug/pprof/trace"} {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set("Authorization", "Bearer s3cret")
+		rec := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("expected 404 for %s without ENABLE_PPROF, got %d", target, rec.Code)
+		}
+	}
+}
+
+func TestAdminServer_AccessLog(t *testing.T) {
+	_, logs := serveAdmin(t, "s3cret", http.MethodPost, "/admin/pause", "")
+	for _, want := range []string{`"msg":"admin request"`, `"method":"POST"`, `"path":"/admin/pause"`, `"status":401`, `"remote_addr"`} {
+		if !strings.Contains(logs, want) {
+			t.Errorf("expected %s in the admin access log, got %s", want, logs)
+		}
+	}
+	if strings.Contains(logs, "s3cret") {
+		t.Errorf("expected the token kept out of the access log, got %s", logs)
+	}
+}
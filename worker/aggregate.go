@@ -0,0 +1,85 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"time"
+)
+
+// defaultAggregateBucket is the width of an api_log_rollups time bucket.
+const defaultAggregateBucket = time.Minute
+
+const upsertRollupSQL = `
+	INSERT INTO api_log_rollups (bucket, endpoint, status_class, requests, duration_ms_sum)
+	VALUES ($1, $2, $3, $4, $5)
+	ON CONFLICT (bucket, endpoint, status_class) DO UPDATE
+	SET requests = api_log_rollups.requests + EXCLUDED.requests,
+		duration_ms_sum = api_log_rollups.duration_ms_sum + EXCLUDED.duration_ms_sum
+`
+
+// errNoBatchTx is returned by a processor that writes through the batch's
+// transaction when it runs outside one.
+var errNoBatchTx = errors.New("no batch transaction")
+
+// aggregateProcessor rolls each batch up into api_log_rollups: the requests
+// and their total duration per time bucket, route and status class. It
+// writes through the batch's transaction, so a retried batch is counted
+// once. Rows without a created_at have no bucket and are skipped.
+type aggregateProcessor struct {
+	bucket time.Duration
+}
+
+func newAggregateProcessor(cfg Config) (Processor, error) {
+	return &aggregateProcessor{bucket: cfg.AggregateBucket}, nil
+}
+
+// rollupKey is one api_log_rollups row.
+type rollupKey struct {
+	bucket time.Time
+	routeStatus
+}
+
+// rollup is what a batch adds to a rollupKey.
+type rollup struct {
+	requests   int64
+	durationMs float64
+}
+
+// Process upserts the batch's rollups, in key order so concurrent
+// partitions lock the rows they share in the same order.
+func (a *aggregateProcessor) Process(ctx context.Context, rows []LogRow) error {
+	tx := batchTx(ctx)
+	if tx == nil {
+		return errNoBatchTx
+	}
+	sums := a.rollUp(rows)
+	keys := slices.SortedFunc(maps.Keys(sums), func(x, y rollupKey) int {
+		return cmp.Or(x.bucket.Compare(y.bucket), cmp.Compare(x.route, y.route), cmp.Compare(x.class, y.class))
+	})
+	for _, k := range keys {
+		r := sums[k]
+		if _, err := tx.ExecContext(ctx, upsertRollupSQL, k.bucket, k.route, k.class, r.requests, r.durationMs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rollUp sums rows by rollupKey.
+func (a *aggregateProcessor) rollUp(rows []LogRow) map[rollupKey]rollup {
+	sums := make(map[rollupKey]rollup)
+	for _, r := range rows {
+		if r.CreatedAt.IsZero() {
+			continue
+		}
+		k := rollupKey{r.CreatedAt.Truncate(a.bucket), r.routeStatus()}
+		s := sums[k]
+		s.requests++
+		s.durationMs += r.DurationMs
+		sums[k] = s
+	}
+	return sums
+}
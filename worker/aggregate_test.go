@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestAggregateProcessor_RollUp(t *testing.T) {
+	at := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	a := &aggregateProcessor{bucket: time.Minute}
+	sums := a.rollUp([]LogRow{
+		{ID: 1, Endpoint: "/api/v1/time", Status: 200, DurationMs: 2, CreatedAt: at.Add(10 * time.Second)},
+		{ID: 2, Endpoint: "/api/v1/time", Status: 204, DurationMs: 3, CreatedAt: at.Add(50 * time.Second)},
+		{ID: 3, Endpoint: "/api/v1/time", Status: 200, DurationMs: 4, CreatedAt: at.Add(70 * time.Second)},
+		{ID: 4, Endpoint: "/wp-login.php", Status: 404, DurationMs: 1, CreatedAt: at},
+		{ID: 5, Endpoint: "/live", Status: 200, DurationMs: 1}, // no created_at
+	})
+	want := map[rollupKey]rollup{
+		{at, routeStatus{"/api/v1/time", "2xx"}}:                  {requests: 2, durationMs: 5},
+		{at.Add(time.Minute), routeStatus{"/api/v1/time", "2xx"}}: {requests: 1, durationMs: 4},
+		{at, routeStatus{"/other", "4xx"}}:                        {requests: 1, durationMs: 1},
+	}
+	if len(sums) != len(want) {
+		t.Fatalf("expected %d rollups, got %v", len(want), sums)
+	}
+	for k, w := range want {
+		if sums[k] != w {
+			t.Errorf("%v: expected %+v, got %+v", k, w, sums[k])
+		}
+	}
+}
+
+func TestAggregateProcessor_WritesThroughTheBatch(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer func() { _ = mockDB.Close() }()
+	dbMu.Lock()
+	db = mockDB
+	dbMu.Unlock()
+	w := NewWorker(time.Second, WithProcessor("aggregate", &aggregateProcessor{bucket: time.Minute}))
+
+	// processedRows(3) are /live 2xx requests a second apart in one minute.
+	mock.ExpectBegin()
+	mock.ExpectQuery(claimQuery).WillReturnRows(processedRows(3))
+	mock.ExpectExec("INSERT INTO api_log_rollups").
+		WithArgs(processedCreatedAt, "/live", "2xx", int64(3), 4.5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectMark(mock, 3)
+
+	if _, err := w.processLogs(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled mock: %s", err)
+	}
+}
+
+func TestAggregateProcessor_FailureRollsBack(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer func() { _ = mockDB.Close() }()
+	dbMu.Lock()
+	db = mockDB
+	dbMu.Unlock()
+	w := NewWorker(time.Second, WithProcessor("aggregate", &aggregateProcessor{bucket: time.Minute}))
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(claimQuery).WillReturnRows(processedRows(3))
+	mock.ExpectExec("INSERT INTO api_log_rollups").WillReturnError(errors.New(`relation "api_log_rollups" does not exist`))
+	mock.ExpectRollback()
+
+	if _, err := w.processLogs(context.Background()); err == nil {
+		t.Fatal("expected the aggregate failure to fail the batch")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled mock: %s", err)
+	}
+}
+
+func TestAggregateProcessor_NeedsBatchTx(t *testing.T) {
+	a := &aggregateProcessor{bucket: time.Minute}
+	if err := a.Process(context.Background(), []LogRow{{ID: 1}}); !errors.Is(err, errNoBatchTx) {
+		t.Errorf("expected errNoBatchTx outside a batch, got %v", err)
+	}
+}
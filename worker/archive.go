@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// archivedRow is one api_logs row as written to an archive file.
+type archivedRow struct {
+	ID          int64
+	Method      string
+	Endpoint    string
+	Status      int
+	DurationMs  float64
+	RemoteAddr  string
+	CreatedAt   time.Time
+	ProcessedAt time.Time
+}
+
+var archiveHeader = []string{"id", "method", "endpoint", "status", "duration_ms", "remote_addr", "created_at", "processed_at"}
+
+// Archiver durably stores a named archive file. Archive must only return
+// nil once the data has been persisted.
+type Archiver interface {
+	Archive(ctx context.Context, name string, data []byte) error
+}
+
+// writeArchive encodes rows as gzip-compressed CSV with a header line.
+func writeArchive(w io.Writer, rows []archivedRow) error {
+	gz := gzip.NewWriter(w)
+	cw := csv.NewWriter(gz)
+	if err := cw.Write(archiveHeader); err != nil {
+		return err
+	}
+	for _, r := range rows {
+		record := []string{
+			strconv.FormatInt(r.ID, 10),
+			r.Method,
+			r.Endpoint,
+			strconv.Itoa(r.Status),
+			strconv.FormatFloat(r.DurationMs, 'f', -1, 64),
+			r.RemoteAddr,
+			r.CreatedAt.UTC().Format(time.RFC3339Nano),
+			r.ProcessedAt.UTC().Format(time.RFC3339Nano),
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// readArchive decodes a file produced by writeArchive.
+func readArchive(r io.Reader) ([]archivedRow, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = gz.Close() }()
+
+	records, err := csv.NewReader(gz).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 || strings.Join(records[0], ",") != strings.Join(archiveHeader, ",") {
+		return nil, errors.New("archive: missing or unexpected header")
+	}
+
+	rows := make([]archivedRow, 0, len(records)-1)
+	for i, rec := range records[1:] {
+		var row archivedRow
+		var errs []error
+		row.ID, err = strconv.ParseInt(rec[0], 10, 64)
+		errs = append(errs, err)
+		row.Method, row.Endpoint, row.RemoteAddr = rec[1], rec[2], rec[5]
+		row.Status, err = strconv.Atoi(rec[3])
+		errs = append(errs, err)
+		row.DurationMs, err = strconv.ParseFloat(rec[4], 64)
+		errs = append(errs, err)
+		row.CreatedAt, err = time.Parse(time.RFC3339Nano, rec[6])
+		errs = append(errs, err)
+		row.ProcessedAt, err = time.Parse(time.RFC3339Nano, rec[7])
+		errs = append(errs, err)
+		if err := errors.Join(errs...); err != nil {
+			return nil, fmt.Errorf("archive: line %d: %w", i+2, err)
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// archiveName returns a deterministic file name covering the rows' time
+// and id range, e.g. api_logs_20240101T000000Z_20240101T010000Z_1-1000.csv.gz.
+func archiveName(rows []archivedRow) string {
+	first, last := rows[0], rows[len(rows)-1]
+	from, to := first.CreatedAt, first.CreatedAt
+	for _, r := range rows {
+		if r.CreatedAt.Before(from) {
+			from = r.CreatedAt
+		}
+		if r.CreatedAt.After(to) {
+			to = r.CreatedAt
+		}
+	}
+	const layout = "20060102T150405Z"
+	return fmt.Sprintf("api_logs_%s_%s_%d-%d.csv.gz",
+		from.UTC().Format(layout), to.UTC().Format(layout), first.ID, last.ID)
+}
+
+// dirArchiver writes archives to a local directory.
+type dirArchiver struct {
+	dir string
+}
+
+// Archive writes to a temporary file, syncs it, and renames it into place
+// so a partially written archive is never mistaken for a complete one.
+func (a *dirArchiver) Archive(ctx context.Context, name string, data []byte) error {
+	tmp, err := os.CreateTemp(a.dir, name+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(a.dir, name))
+}
+
+// s3Archiver uploads archives to an S3-compatible bucket with SigV4-signed
+// path-style PUT requests.
+type s3Archiver struct {
+	endpoint  string
+	bucket    string
+	prefix    string
+	region    string
+	accessKey string
+	secretKey string
+	client    *http.Client
+	now       func() time.Time
+}
+
+func (a *s3Archiver) Archive(ctx context.Context, name string, data []byte) error {
+	key := strings.TrimPrefix(a.prefix+"/"+name, "/")
+	url := strings.TrimSuffix(a.endpoint, "/") + "/" + a.bucket + "/" + key
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/gzip")
+	a.sign(req, data)
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("archive upload failed: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// sign adds AWS Signature Version 4 headers to req.
+func (a *s3Archiver) sign(req *http.Request, payload []byte) {
+	now := a.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(payload)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host + "\n" +
+			"x-amz-content-sha256:" + payloadHash + "\n" +
+			"x-amz-date:" + amzDate + "\n",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + a.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+a.secretKey), date)
+	key = hmacSHA256(key, a.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		a.accessKey, scope, signedHeaders, signature))
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// archiveProcessor writes each batch to the ARCHIVE_DIR or
+// ARCHIVE_S3_BUCKET archive as it is processed, as a batch_ file beside the
+// ones the retention purge writes. A batch retried after a later processor
+// failed is written again under the same name.
+type archiveProcessor struct {
+	archiver Archiver
+	now      func() time.Time
+}
+
+func newArchiveProcessor(cfg Config) (Processor, error) {
+	archiver, err := newArchiver(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if archiver == nil {
+		return nil, errors.New("needs ARCHIVE_DIR or ARCHIVE_S3_BUCKET")
+	}
+	return &archiveProcessor{archiver: archiver, now: time.Now}, nil
+}
+
+// Process archives rows, stamped with the time they are being processed.
+func (a *archiveProcessor) Process(ctx context.Context, rows []LogRow) error {
+	processed := a.now()
+	archived := make([]archivedRow, len(rows))
+	for i, r := range rows {
+		archived[i] = archivedRow{
+			ID:          r.ID,
+			Method:      r.Method,
+			Endpoint:    r.Endpoint,
+			Status:      r.Status,
+			DurationMs:  r.DurationMs,
+			RemoteAddr:  r.RemoteAddr,
+			CreatedAt:   r.CreatedAt,
+			ProcessedAt: processed,
+		}
+	}
+	var buf bytes.Buffer
+	if err := writeArchive(&buf, archived); err != nil {
+		return fmt.Errorf("encode archive: %w", err)
+	}
+	name := "batch_" + archiveName(archived)
+	if err := a.archiver.Archive(ctx, name, buf.Bytes()); err != nil {
+		return fmt.Errorf("write archive %s: %w", name, err)
+	}
+	return nil
+}
+
+// newArchiver builds the archiver selected by ARCHIVE_DIR or
+// ARCHIVE_S3_BUCKET. It returns nil when archiving is not configured.
+func newArchiver(cfg Config) (Archiver, error) {
+	switch {
+	case cfg.ArchiveDir != "":
+		if err := os.MkdirAll(cfg.ArchiveDir, 0o750); err != nil {
+			return nil, fmt.Errorf("create archive dir: %w", err)
+		}
+		return &dirArchiver{dir: cfg.ArchiveDir}, nil
+	case cfg.ArchiveS3Bucket != "":
+		endpoint := cfg.ArchiveS3Endpoint
+		if endpoint == "" {
+			endpoint = "https://s3." + cfg.ArchiveS3Region + ".amazonaws.com"
+		}
+		return &s3Archiver{
+			endpoint:  endpoint,
+			bucket:    cfg.ArchiveS3Bucket,
+			prefix:    cfg.ArchiveS3Prefix,
+			region:    cfg.ArchiveS3Region,
+			accessKey: cfg.AWSAccessKeyID,
+			secretKey: cfg.AWSSecretAccessKey,
+			client:    &http.Client{Timeout: time.Minute},
+			now:       time.Now,
+		}, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func sampleArchiveRows() []archivedRow {
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	return []archivedRow{
+		{ID: 1, Method: "GET", Endpoint: "/api/v1/time", Status: 200, DurationMs: 1.25, RemoteAddr: "10.0.0.1:1234",
+			CreatedAt: created, ProcessedAt: created.Add(time.Second)},
+		{ID: 2, Method: "POST", Endpoint: `/weird,"path"`, Status: 405, DurationMs: 0.5, RemoteAddr: "",
+			CreatedAt: created.Add(time.Hour), ProcessedAt: created.Add(time.Hour + 123*time.Millisecond)},
+	}
+}
+
+func TestArchive_RoundTrip(t *testing.T) {
+	rows := sampleArchiveRows()
+	var buf bytes.Buffer
+	if err := writeArchive(&buf, rows); err != nil {
+		t.Fatalf("writeArchive: %v", err)
+	}
+	got, err := readArchive(&buf)
+	if err != nil {
+		t.Fatalf("readArchive: %v", err)
+	}
+	if !reflect.DeepEqual(got, rows) {
+		t.Errorf("round trip mismatch:\n got  %+v\n want %+v", got, rows)
+	}
+}
+
+func TestReadArchive_Invalid(t *testing.T) {
+	if _, err := readArchive(strings.NewReader("not gzip")); err == nil {
+		t.Error("expected error for non-gzip input")
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, _ = gz.Write([]byte("a,b,c\n1,2,3\n"))
+	_ = gz.Close()
+	if _, err := readArchive(&buf); err == nil {
+		t.Error("expected error for unexpected header")
+	}
+}
+
+func TestArchiveName(t *testing.T) {
+	want := "api_logs_20240101T000000Z_20240101T010000Z_1-2.csv.gz"
+	if got := archiveName(sampleArchiveRows()); got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+}
+
+func TestDirArchiver(t *testing.T) {
+	dir := t.TempDir()
+	a := &dirArchiver{dir: dir}
+	if err := a.Archive(context.Background(), "batch.csv.gz", []byte("data")); err != nil {
+		t.Fatalf("Archive: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "batch.csv.gz"))
+	if err != nil || string(data) != "data" {
+		t.Errorf("expected archived file contents, got %q (%v)", data, err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("expected temp file to be cleaned up, found %d entries", len(entries))
+	}
+}
+
+func TestS3Archiver_SignedPut(t *testing.T) {
+	var gotPath, gotAuth, gotHash string
+	var gotBody []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			t.Errorf("expected PUT, got %s", r.Method)
+		}
+		gotPath = r.URL.Path
+		gotAuth = r.Header.Get("Authorization")
+		gotHash = r.Header.Get("X-Amz-Content-Sha256")
+		gotBody, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	a := &s3Archiver{
+		endpoint: srv.URL, bucket: "logs", prefix: "api", region: "eu-west-1",
+		accessKey: "AKIDEXAMPLE", secretKey: "secret",
+		client: srv.Client(),
+		now:    func() time.Time { return time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC) },
+	}
+	if err := a.Archive(context.Background(), "batch.csv.gz", []byte("payload")); err != nil {
+		t.Fatalf("Archive: %v", err)
+	}
+	if gotPath != "/logs/api/batch.csv.gz" {
+		t.Errorf("unexpected object path %s", gotPath)
+	}
+	if string(gotBody) != "payload" {
+		t.Errorf("unexpected body %q", gotBody)
+	}
+	if gotHash != sha256Hex([]byte("payload")) {
+		t.Errorf("unexpected payload hash %s", gotHash)
+	}
+	if !strings.HasPrefix(gotAuth, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20240102/eu-west-1/s3/aws4_request, SignedHeaders=host;x-amz-content-sha256;x-amz-date, Signature=") {
+		t.Errorf("unexpected Authorization header %s", gotAuth)
+	}
+}
+
+func TestS3Archiver_ErrorStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "AccessDenied", http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	a := &s3Archiver{endpoint: srv.URL, bucket: "logs", region: "us-east-1", client: srv.Client(), now: time.Now}
+	if err := a.Archive(context.Background(), "batch.csv.gz", []byte("x")); err == nil {
+		t.Error("expected error for 403 response")
+	}
+}
+
+func TestNewArchiver(t *testing.T) {
+	cfg := defaultConfig()
+	if a, err := newArchiver(cfg); a != nil || err != nil {
+		t.Errorf("expected no archiver when unconfigured, got %v, %v", a, err)
+	}
+
+	cfg.ArchiveDir = t.TempDir()
+	if a, err := newArchiver(cfg); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if _, ok := a.(*dirArchiver); !ok {
+		t.Errorf("expected dirArchiver, got %T", a)
+	}
+
+	cfg = defaultConfig()
+	cfg.ArchiveS3Bucket = "logs"
+	cfg.ArchiveS3Region = "eu-west-1"
+	a, err := newArchiver(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s3, ok := a.(*s3Archiver); !ok || s3.endpoint != "https://s3.eu-west-1.amazonaws.com" {
+		t.Errorf("expected an s3Archiver on the regional endpoint, got %#v", a)
+	}
+}
+
+func TestArchiveProcessor(t *testing.T) {
+	dir := t.TempDir()
+	processed := time.Date(2024, 1, 1, 2, 0, 0, 0, time.UTC)
+	p := &archiveProcessor{archiver: &dirArchiver{dir: dir}, now: func() time.Time { return processed }}
+	var rows []LogRow
+	for _, r := range sampleArchiveRows() {
+		rows = append(rows, LogRow{ID: r.ID, Method: r.Method, Endpoint: r.Endpoint, Status: r.Status,
+			DurationMs: r.DurationMs, RemoteAddr: r.RemoteAddr, CreatedAt: r.CreatedAt})
+	}
+	if err := p.Process(context.Background(), rows); err != nil {
+		t.Fatalf("Process: %v", err)
+	}
+
+	f, err := os.Open(filepath.Join(dir, "batch_api_logs_20240101T000000Z_20240101T010000Z_1-2.csv.gz"))
+	if err != nil {
+		t.Fatalf("expected the batch archived: %v", err)
+	}
+	defer func() { _ = f.Close() }()
+	got, err := readArchive(f)
+	if err != nil {
+		t.Fatalf("readArchive: %v", err)
+	}
+	if len(got) != 2 || got[1].Endpoint != `/weird,"path"` || !got[0].ProcessedAt.Equal(processed) {
+		t.Errorf("unexpected archived rows %+v", got)
+	}
+}
+
+func TestNewArchiveProcessor_NeedsDestination(t *testing.T) {
+	if _, err := newArchiveProcessor(defaultConfig()); err == nil {
+		t.Error("expected an error without ARCHIVE_DIR or ARCHIVE_S3_BUCKET")
+	}
+}
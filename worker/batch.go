@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+)
+
+// routeStatus keys worker_processed_by_status_total.
+type routeStatus struct {
+	route, class string
+}
+
+// routeStatus returns the route and status class r is counted under. A
+// NULL status reads as 0, which is "unknown" as NULL is.
+func (r LogRow) routeStatus() routeStatus {
+	return routeStatus{routePattern(r.Endpoint), statusClass(sql.NullInt64{Int64: int64(r.Status), Valid: true})}
+}
+
+// markBatch claims, processes and marks one batch in a transaction: it
+// locks up to batchSize unprocessed rows of the partition, passes them
+// through the processors, stamps them processed and commits. The stamp is
+// the final step, taken only once every processor has succeeded. The result
+// and the per route and status tally cover only the ids the UPDATE
+// returned, so they match what was committed. Any error rolls the
+// transaction back, leaving the rows for the next cycle, and returns no
+// tally; the caller counts the rows only after a nil error.
+func (w *Worker) markBatch(ctx context.Context, d *sql.DB, partition int) (batchResult, map[routeStatus]int, error) {
+	tx, err := d.BeginTx(ctx, nil)
+	if err != nil {
+		return batchResult{}, nil, err
+	}
+	// A no-op once the transaction has committed.
+	defer func() { _ = tx.Rollback() }()
+
+	claimed, err := w.claimBatch(ctx, tx, partition)
+	if err != nil {
+		return batchResult{}, nil, err
+	}
+	var res batchResult
+	tally := make(map[routeStatus]int)
+	if len(claimed) > 0 {
+		if err := w.runPipeline(withBatchTx(ctx, tx), claimed); err != nil {
+			return batchResult{}, nil, err
+		}
+		byID := make(map[int64]LogRow, len(claimed))
+		ids := make([]int64, len(claimed))
+		for i, r := range claimed {
+			byID[r.ID] = r
+			ids[i] = r.ID
+		}
+		marked, err := markClaimed(ctx, tx, ids)
+		if err != nil {
+			return batchResult{}, nil, err
+		}
+		for _, id := range marked {
+			row, ok := byID[id]
+			if !ok {
+				continue
+			}
+			tally[row.routeStatus()]++
+			res.add(sql.NullTime{Time: row.CreatedAt, Valid: !row.CreatedAt.IsZero()})
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return batchResult{}, nil, err
+	}
+	return res, tally, nil
+}
+
+// claimBatch locks the next unprocessed rows whose id falls in partition.
+// SKIP LOCKED leaves rows another replica's transaction holds to it.
+func (w *Worker) claimBatch(ctx context.Context, tx *sql.Tx, partition int) ([]LogRow, error) {
+	rs, err := tx.QueryContext(ctx, `
+		SELECT id, method, endpoint, status, duration_ms, remote_addr, created_at FROM api_logs
+		WHERE processed_at IS NULL
+		AND id % $2 = $3
+		ORDER BY id
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED
+	`, w.batchSize, max(w.concurrency, 1), partition)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rs.Close() }()
+
+	var rows []LogRow
+	for rs.Next() {
+		var (
+			r                        LogRow
+			method, endpoint, remote sql.NullString
+			status                   sql.NullInt64
+			duration                 sql.NullFloat64
+			created                  sql.NullTime
+		)
+		if err := rs.Scan(&r.ID, &method, &endpoint, &status, &duration, &remote, &created); err != nil {
+			return nil, err
+		}
+		r.Method, r.Endpoint, r.RemoteAddr = method.String, endpoint.String, remote.String
+		r.Status, r.DurationMs, r.CreatedAt = int(status.Int64), duration.Float64, created.Time
+		rows = append(rows, r)
+	}
+	return rows, rs.Err()
+}
+
+// markClaimed marks the claimed ids processed and returns the ones it
+// updated.
+func markClaimed(ctx context.Context, tx *sql.Tx, ids []int64) ([]int64, error) {
+	rows, err := tx.QueryContext(ctx, `
+		UPDATE api_logs
+		SET processed_at = CURRENT_TIMESTAMP
+		WHERE id = ANY($1::bigint[])
+		RETURNING id
+	`, idArray(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var marked []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		marked = append(marked, id)
+	}
+	return marked, rows.Err()
+}
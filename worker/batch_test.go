@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestIDArray(t *testing.T) {
+	if got := idArray([]int64{3, 1, 42}); got != "{3,1,42}" {
+		t.Errorf("expected {3,1,42}, got %s", got)
+	}
+	if got := idArray(nil); got != "{}" {
+		t.Errorf("expected {}, got %s", got)
+	}
+}
+
+// TestProcessLogs_RollbackCountsNothing fails each step of the batch after
+// the rows are claimed and checks the transaction rolls back without any
+// processed count advancing, so the rows are counted once when retried.
+func TestProcessLogs_RollbackCountsNothing(t *testing.T) {
+	ids := processedIDs(3)
+	tests := map[string]func(mock sqlmock.Sqlmock){
+		"update fails": func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery("UPDATE api_logs").WithArgs(idArray(ids)).WillReturnError(errors.New("deadlock detected"))
+			mock.ExpectRollback()
+		},
+		"returned row unreadable": func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery("UPDATE api_logs").WithArgs(idArray(ids)).
+				WillReturnRows(markedRows(ids).RowError(1, errors.New("connection reset")))
+			mock.ExpectRollback()
+		},
+		"commit fails": func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery("UPDATE api_logs").WithArgs(idArray(ids)).WillReturnRows(markedRows(ids))
+			mock.ExpectCommit().WillReturnError(errors.New("serialization failure"))
+		},
+	}
+	for name, fail := range tests {
+		t.Run(name, func(t *testing.T) {
+			mockDB, mock, _ := sqlmock.New()
+			defer func() { _ = mockDB.Close() }()
+			dbMu.Lock()
+			db = mockDB
+			dbMu.Unlock()
+			m, _ := newTestMetrics(t)
+			w := NewWorker(time.Second, WithMetrics(m))
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(claimQuery).WillReturnRows(processedRows(3))
+			fail(mock)
+
+			processed, err := w.processLogs(context.Background())
+			if err == nil || processed.rows != 0 {
+				t.Errorf("expected an error and no rows, got %d, %v", processed.rows, err)
+			}
+			if got := testutil.ToFloat64(m.logsProcessed); got != 0 {
+				t.Errorf("expected no processed rows counted, got %v", got)
+			}
+			if n := testutil.CollectAndCount(m.processedByStatus); n != 0 {
+				t.Errorf("expected no per-status counts, got %d series", n)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("unfulfilled mock: %s", err)
+			}
+		})
+	}
+}
+
+func TestProcessLogs_CountsReturnedIDs(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer func() { _ = mockDB.Close() }()
+	dbMu.Lock()
+	db = mockDB
+	dbMu.Unlock()
+	m, _ := newTestMetrics(t)
+	w := NewWorker(time.Second, WithMetrics(m))
+
+	// Three rows are claimed but the UPDATE only returns two of them, so
+	// only those two count.
+	mock.ExpectBegin()
+	mock.ExpectQuery(claimQuery).WillReturnRows(processedRows(3))
+	mock.ExpectQuery("UPDATE api_logs").WithArgs(idArray(processedIDs(3))).WillReturnRows(markedRows([]int64{1, 3}))
+	mock.ExpectCommit()
+
+	processed, err := w.processLogs(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if processed.rows != 2 || !processed.oldest.Equal(processedCreatedAt) || !processed.newest.Equal(processedCreatedAt.Add(2*time.Second)) {
+		t.Errorf("expected rows 1 and 3, got %+v", processed)
+	}
+	if got := testutil.ToFloat64(m.logsProcessed); got != 2 {
+		t.Errorf("expected 2 processed rows counted, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.processedByStatus.WithLabelValues("/live", "2xx")); got != 2 {
+		t.Errorf("expected 2 /live 2xx rows counted, got %v", got)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled mock: %s", err)
+	}
+}
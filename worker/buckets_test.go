@@ -0,0 +1,22 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseBuckets(t *testing.T) {
+	got, err := parseBuckets("0.001, 0.01,0.1 ,1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []float64{0.001, 0.01, 0.1, 1}; !slices.Equal(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	for _, bad := range []string{"", "abc", "0.1,,1", "0,1", "-1,1", "1,0.5", "1,1", "0.1,+Inf", "NaN"} {
+		if _, err := parseBuckets(bad); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// Checker is a single dependency probed by /ready.
+type Checker interface {
+	Name() string
+	Check(ctx context.Context) error
+}
+
+// readinessCheck pairs a Checker with whether its failure makes the service
+// unready. Optional checks that fail only mark the service degraded.
+type readinessCheck struct {
+	Checker
+	required bool
+}
+
+// checkResult is the per-check entry in the /ready response.
+type checkResult struct {
+	Name      string  `json:"name"`
+	OK        bool    `json:"ok"`
+	Required  bool    `json:"required"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// readyChecks are the checks run by readyHandler; main registers the
+// optional ones that need runtime state.
+var readyChecks = []readinessCheck{{Checker: dbChecker{}, required: true}}
+
+// runChecks runs every check in order. The result is 503 if any required
+// check fails, and 200 "degraded" if only optional checks fail.
+func runChecks(ctx context.Context, checks []readinessCheck) readyResult {
+	res := readyResult{code: http.StatusOK, status: "ready"}
+	for _, c := range checks {
+		start := time.Now()
+		err := c.Check(ctx)
+		cr := checkResult{
+			Name:      c.Name(),
+			OK:        err == nil,
+			Required:  c.required,
+			LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+		}
+		if err != nil {
+			cr.Error = err.Error()
+			switch {
+			case c.required && res.code == http.StatusOK:
+				res.code = http.StatusServiceUnavailable
+				res.status = "error"
+				res.message = cr.Error
+			case !c.required && res.status == "ready":
+				res.status = "degraded"
+			}
+		}
+		res.checks = append(res.checks, cr)
+	}
+	return res
+}
+
+// dbRequired makes a missing database fail readiness; main sets it from
+// DB_REQUIRED.
+var dbRequired = true
+
+// checkReady runs readyChecks and adds pool statistics and the last database
+// error, or notes when the database is deliberately disabled.
+func checkReady(ctx context.Context) readyResult {
+	res := runChecks(ctx, readyChecks)
+	dbMu.RLock()
+	d := db
+	dbMu.RUnlock()
+	switch {
+	case d != nil:
+		res.pool = newPoolStats(d.Stats())
+	case !dbRequired:
+		res.db = "disabled"
+	}
+	res.dbError = lastDBError.get()
+	return res
+}
+
+// dbChecker pings the database within readyPingTimeout. A missing database
+// passes when it is not required.
+type dbChecker struct{}
+
+func (dbChecker) Name() string { return "database" }
+
+func (dbChecker) Check(ctx context.Context) error {
+	if dbReconnecting.Load() {
+		return errors.New("db reconnecting")
+	}
+	dbMu.RLock()
+	d := db
+	dbMu.RUnlock()
+	if d == nil {
+		if !dbRequired {
+			return nil
+		}
+		return errors.New("db not configured")
+	}
+	pingCtx, cancel := context.WithTimeout(ctx, readyPingTimeout)
+	defer cancel()
+	err := d.PingContext(pingCtx)
+	dbConnected.Store(err == nil)
+	lastDBError.record(err)
+	if err != nil {
+		if errors.Is(pingCtx.Err(), context.DeadlineExceeded) {
+			return errors.New("db ping timeout")
+		}
+		return errors.New("db unreachable")
+	}
+	return nil
+}
+
+// loopChecker reports whether the processing loop is running and its last
+// batch succeeded.
+type loopChecker struct {
+	worker *Worker
+}
+
+func (loopChecker) Name() string { return "processing_loop" }
+
+func (c loopChecker) Check(context.Context) error {
+	if !c.worker.IsHealthy() {
+		return errors.New("processing loop unhealthy")
+	}
+	return nil
+}
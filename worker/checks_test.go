@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+type stubChecker struct {
+	name string
+	err  error
+}
+
+func (c stubChecker) Name() string                { return c.name }
+func (c stubChecker) Check(context.Context) error { return c.err }
+
+func TestRunChecks(t *testing.T) {
+	boom := errors.New("boom")
+	tests := []struct {
+		name       string
+		checks     []readinessCheck
+		wantCode   int
+		wantStatus string
+	}{
+		{"all pass", []readinessCheck{{stubChecker{"a", nil}, true}, {stubChecker{"b", nil}, false}}, http.StatusOK, "ready"},
+		{"optional fails", []readinessCheck{{stubChecker{"a", nil}, true}, {stubChecker{"b", boom}, false}}, http.StatusOK, "degraded"},
+		{"required fails", []readinessCheck{{stubChecker{"a", boom}, true}, {stubChecker{"b", boom}, false}}, http.StatusServiceUnavailable, "error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := runChecks(context.Background(), tt.checks)
+			if res.code != tt.wantCode || res.status != tt.wantStatus {
+				t.Errorf("expected %d %q, got %d %q", tt.wantCode, tt.wantStatus, res.code, res.status)
+			}
+			if len(res.checks) != len(tt.checks) {
+				t.Fatalf("expected %d check results, got %d", len(tt.checks), len(res.checks))
+			}
+			for i, cr := range res.checks {
+				wantErr := tt.checks[i].Check(context.Background())
+				if cr.OK != (wantErr == nil) || cr.Required != tt.checks[i].required {
+					t.Errorf("check %s: unexpected result %+v", cr.Name, cr)
+				}
+			}
+		})
+	}
+}
+
+func TestReadyHandler_ListsChecks(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	defer func() { _ = mockDB.Close() }()
+	dbMu.Lock()
+	db = mockDB
+	dbMu.Unlock()
+	mock.ExpectPing()
+
+	req := httptest.NewRequest(http.MethodGet, "/ready", nil)
+	rec := httptest.NewRecorder()
+	readyHandler(rec, req)
+
+	var resp readyResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(resp.Checks) != 1 || resp.Checks[0].Name != "database" || !resp.Checks[0].OK || !resp.Checks[0].Required {
+		t.Errorf("expected a passing required database check, got %+v", resp.Checks)
+	}
+}
+
+func TestReadyHandler_DBRequired(t *testing.T) {
+	defer func() { dbRequired = true }()
+
+	serve := func() (int, readyResponse) {
+		rec := httptest.NewRecorder()
+		readyHandler(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+		var resp readyResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		return rec.Code, resp
+	}
+
+	dbMu.Lock()
+	db = nil
+	dbMu.Unlock()
+
+	dbRequired = true
+	if code, resp := serve(); code != http.StatusServiceUnavailable || resp.DB != "" {
+		t.Errorf("required missing db: expected 503 without db field, got %d %+v", code, resp)
+	}
+
+	dbRequired = false
+	if code, resp := serve(); code != http.StatusOK || resp.DB != "disabled" {
+		t.Errorf("optional missing db: expected 200 with db disabled, got %d %+v", code, resp)
+	}
+
+	mockDB, mock, _ := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	defer func() { _ = mockDB.Close() }()
+	dbMu.Lock()
+	db = mockDB
+	dbMu.Unlock()
+	mock.ExpectPing().WillReturnError(errors.New("db down"))
+	if code, resp := serve(); code != http.StatusServiceUnavailable || resp.DB != "" {
+		t.Errorf("optional unreachable db: expected 503, got %d %+v", code, resp)
+	}
+}
+
+func TestLoopChecker(t *testing.T) {
+	w := NewWorker(time.Second)
+	if err := (loopChecker{worker: w}).Check(context.Background()); err != nil {
+		t.Errorf("expected a fresh worker to pass, got %v", err)
+	}
+	w.mu.Lock()
+	w.isHealthy = false
+	w.mu.Unlock()
+	if err := (loopChecker{worker: w}).Check(context.Background()); err == nil {
+		t.Error("expected an unhealthy worker to fail the check")
+	}
+}
+
+func TestReadyHandler_LastDBError(t *testing.T) {
+	resetLogSecrets(t)
+	addLogSecret("postgres://app:s3cret@db:5432/app")
+	mockDB, mock, _ := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	defer func() { _ = mockDB.Close() }()
+	dbMu.Lock()
+	db = mockDB
+	dbMu.Unlock()
+	defer func() {
+		dbMu.Lock()
+		db = nil
+		dbMu.Unlock()
+		lastDBError.record(nil)
+	}()
+	serve := func(handler http.HandlerFunc, body any) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		if err := json.NewDecoder(rec.Body).Decode(body); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+	}
+
+	pingErr := errors.New("FATAL: password authentication failed for user \"app\" (password s3cret) " + strings.Repeat("x", 1000))
+	mock.ExpectPing().WillReturnError(pingErr)
+	mock.ExpectPing().WillReturnError(pingErr)
+	var ready readyResponse
+	serve(readyHandler, &ready)
+	var healthz HealthzResponse
+	serve(healthzHandler(NewWorker(time.Second)), &healthz)
+	for name, got := range map[string]*DBError{"/ready": ready.DBError, "/healthz": healthz.Readiness.DBError} {
+		switch {
+		case got == nil:
+			t.Errorf("%s: expected the ping error reported", name)
+		case !strings.Contains(got.Error, "password authentication failed") || strings.Contains(got.Error, "s3cret"):
+			t.Errorf("%s: expected the redacted driver error, got %q", name, got.Error)
+		case len(got.Error) > maxDBErrorLen || got.At == "":
+			t.Errorf("%s: expected a timestamped error cut to %d bytes, got %d bytes at %q", name, maxDBErrorLen, len(got.Error), got.At)
+		}
+	}
+
+	// A successful ping clears it.
+	mock.ExpectPing()
+	ready = readyResponse{}
+	serve(readyHandler, &ready)
+	if ready.DBError != nil {
+		t.Errorf("expected the error cleared after recovery, got %+v", ready.DBError)
+	}
+}
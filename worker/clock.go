@@ -0,0 +1,25 @@
+package main
+
+import "time"
+
+// Clock abstracts the wall clock and timers so the worker's pacing and
+// staleness logic can be driven deterministically in tests.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// realClock is the Clock backed by the time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// WithClock replaces the worker's clock.
+func WithClock(c Clock) WorkerOption {
+	return func(w *Worker) {
+		if c != nil {
+			w.clock = c
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// fakeClock is a manually advanced Clock. Every After call is reported on
+// sleeps so tests can observe the pacing the worker chose.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []fakeTimer
+	sleeps chan time.Duration
+}
+
+type fakeTimer struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now, sleeps: make(chan time.Duration, 64)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+	} else {
+		c.timers = append(c.timers, fakeTimer{at: c.now.Add(d), ch: ch})
+	}
+	select {
+	case c.sleeps <- d:
+	default:
+	}
+	return ch
+}
+
+// Advance moves the clock forward and fires any timers that are due.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	pending := c.timers[:0]
+	for _, t := range c.timers {
+		if !t.at.After(c.now) {
+			t.ch <- c.now
+		} else {
+			pending = append(pending, t)
+		}
+	}
+	c.timers = pending
+}
+
+// nextSleep waits for the worker to request its next sleep.
+func (c *fakeClock) nextSleep(t *testing.T) time.Duration {
+	t.Helper()
+	select {
+	case d := <-c.sleeps:
+		return d
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the worker to sleep")
+		return 0
+	}
+}
+
+func TestWorker_RunPacing_ActiveThenIdle(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer func() { _ = mockDB.Close() }()
+	dbMu.Lock()
+	db = mockDB
+	dbMu.Unlock()
+
+	expectBatch(mock, 5)
+	expectBatch(mock, 0)
+
+	clock := newFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	w := NewWorker(2*time.Second, WithClock(clock))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Run(ctx)
+
+	if d := clock.nextSleep(t); d != activeYield {
+		t.Errorf("expected active yield %v after a full batch, got %v", activeYield, d)
+	}
+	clock.Advance(activeYield)
+	if d := clock.nextSleep(t); d != 2*time.Second {
+		t.Errorf("expected idle interval after an empty batch, got %v", d)
+	}
+	if !w.LastRunAt().Equal(clock.Now()) {
+		t.Errorf("expected lastRunAt from the injected clock, got %v", w.LastRunAt())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled mock: %s", err)
+	}
+}
+
+func TestWorker_RunPacing_ErrorBackoff(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer func() { _ = mockDB.Close() }()
+	dbMu.Lock()
+	db = mockDB
+	dbMu.Unlock()
+
+	expectBatchError(mock, errors.New("boom"))
+	expectBatchError(mock, errors.New("boom"))
+	expectBatch(mock, 0)
+
+	clock := newFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	w := NewWorker(time.Second, WithClock(clock))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Run(ctx)
+
+	for _, want := range []time.Duration{time.Second, 2 * time.Second, time.Second} {
+		d := clock.nextSleep(t)
+		if d != want {
+			t.Errorf("expected sleep %v, got %v", want, d)
+		}
+		clock.Advance(d)
+	}
+}
+
+func TestWorker_RunPacing_MaxRowsPerSecond(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer func() { _ = mockDB.Close() }()
+	dbMu.Lock()
+	db = mockDB
+	dbMu.Unlock()
+
+	expectBatch(mock, defaultBatchSize)
+	expectBatch(mock, defaultBatchSize)
+	expectBatch(mock, 500)
+	expectBatch(mock, 0)
+
+	clock := newFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	w := NewWorker(time.Minute, WithClock(clock), WithMaxRowsPerSecond(100))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Run(ctx)
+
+	// The first batch fits the burst; after that each batch waits until
+	// its rows have been earned back at 100 rows/s.
+	for _, want := range []time.Duration{activeYield, 9900 * time.Millisecond, 5 * time.Second, time.Minute} {
+		d := clock.nextSleep(t)
+		if d != want {
+			t.Errorf("expected sleep %v, got %v", want, d)
+		}
+		clock.Advance(d)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled mock: %s", err)
+	}
+}
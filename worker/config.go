@@ -0,0 +1,672 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Config is every setting the worker reads at startup. LoadConfig fills it
+// from the environment and main hands the pieces to the code that uses them.
+//
+// Fields holding credentials carry a secret tag for /admin/config:
+// "true" masks the whole value and "password" only the password in a URL
+// or connection string. Tag every new one.
+type Config struct {
+	HealthPort              int
+	AdminPort               int
+	EnablePprof             bool
+	Env                     string
+	ServiceName             string
+	DSN                     string `secret:"password"`
+	DSNFile                 string
+	DBPool                  dbPoolConfig
+	LogLevel                slog.Level
+	LogFormat               string
+	LogOutput               string
+	SyslogNetwork           string
+	SyslogAddr              string
+	LogSource               bool
+	Interval                time.Duration
+	Schedule                *Schedule
+	StalenessFactor         float64
+	StalenessMin            time.Duration
+	Jitter                  float64
+	MaxRowsPerSecond        float64
+	StartupDrain            bool
+	QueryTimeout            time.Duration
+	BatchSize               int
+	Concurrency             int
+	Processors              []string
+	AggregateBucket         time.Duration
+	ReconnectThreshold      int
+	DBRequired              bool
+	ReadyCacheTTL           time.Duration
+	ReadyPingTimeout        time.Duration
+	AdminToken              string             `secret:"true"`
+	MetricsAuth             metricsCredentials `secret:"true"`
+	DurationBuckets         []float64
+	HealthzDegradedStatus   int
+	HealthzErrorStatus      int
+	LogRetention            time.Duration
+	LogTableMaxBytes        int
+	LogTableEnforce         bool
+	ArchiveDir              string
+	ArchiveS3Bucket         string
+	ArchiveS3Prefix         string
+	ArchiveS3Region         string
+	ArchiveS3Endpoint       string
+	AWSAccessKeyID          string `secret:"true"`
+	AWSSecretAccessKey      string `secret:"true"`
+	PushgatewayURL          string `secret:"password"`
+	PushgatewayJob          string
+	PushgatewayInstance     string
+	PushgatewayInterval     time.Duration
+	PushgatewayDeleteOnExit bool
+	ShutdownTimeout         time.Duration
+	ShutdownGrace           time.Duration
+	OTLPEndpoint            string
+	OTLPMetricsEndpoint     string
+	OTLPMetricsInterval     time.Duration
+	ErrorWebhookURL         string `secret:"true"`
+	StatsdAddr              string
+	StatsdPrefix            string
+	StatsdTags              []string
+	ReadinessWebhookURL     string `secret:"true"`
+	ReadinessInterval       time.Duration
+	WebhookURL              string `secret:"true"`
+	WebhookSecret           string `secret:"true"`
+	ErrorReportThreshold    int
+
+	// warnings are the invalid logging settings that fell back to their
+	// defaults; setupLogger reports them once the logger is built.
+	warnings []error
+	// sources names where each setting, by environment variable, got its
+	// value from; settings left at their defaults are missing.
+	sources map[string]string
+}
+
+// defaultConfig is the configuration with nothing set.
+func defaultConfig() Config {
+	return Config{
+		HealthPort:            8081,
+		Env:                   "development",
+		ServiceName:           defaultServiceName,
+		DBPool:                defaultDBPool,
+		LogLevel:              slog.LevelInfo,
+		LogFormat:             logFormatJSON,
+		LogOutput:             logOutputStdout,
+		SyslogNetwork:         syslogNetworkUDP,
+		Interval:              2 * time.Second,
+		StalenessFactor:       defaultStalenessFactor,
+		StalenessMin:          defaultStalenessMin,
+		QueryTimeout:          defaultQueryTimeout,
+		BatchSize:             defaultBatchSize,
+		Concurrency:           1,
+		AggregateBucket:       defaultAggregateBucket,
+		ReconnectThreshold:    defaultReconnectThreshold,
+		DBRequired:            true,
+		ReadyCacheTTL:         defaultReadyCacheTTL,
+		ReadyPingTimeout:      defaultReadyPingTimeout,
+		DurationBuckets:       defaultDurationBuckets,
+		HealthzDegradedStatus: healthzStatusCodes["degraded"],
+		HealthzErrorStatus:    healthzStatusCodes["error"],
+		ArchiveS3Region:       "us-east-1",
+		PushgatewayInterval:   defaultPushInterval,
+		ShutdownTimeout:       defaultShutdownTimeout,
+		ShutdownGrace:         defaultShutdownGrace,
+		OTLPMetricsInterval:   defaultOTLPMetricsInterval,
+		ReadinessInterval:     defaultReadinessInterval,
+		ErrorReportThreshold:  defaultErrorReportThreshold,
+	}
+}
+
+// configField is one environment variable and how it is parsed into Config.
+type configField struct {
+	env   string
+	usage string
+	set   func(c *Config, s string) error
+}
+
+// flagNames holds the flags that aren't simply the environment variable in
+// lower case with dashes.
+var flagNames = map[string]string{
+	"WORKER_BATCH_SIZE": "batch-size",
+}
+
+// flagName is the command-line flag for f, e.g. --health-port for
+// HEALTH_PORT.
+func (f configField) flagName() string {
+	if name, ok := flagNames[f.env]; ok {
+		return name
+	}
+	return strings.ReplaceAll(strings.ToLower(f.env), "_", "-")
+}
+
+var configFields = []configField{
+	{"HEALTH_PORT", "health server port", portVar(func(c *Config) *int { return &c.HealthPort })},
+	{"ADMIN_PORT", "admin server port for /admin/* and pprof; unset serves /admin/* on HEALTH_PORT", portVar(func(c *Config) *int { return &c.AdminPort })},
+	{"APP_ENV", "environment name", stringVar(func(c *Config) *string { return &c.Env })},
+	{"SERVICE_NAME", "service label on metrics", stringVar(func(c *Config) *string { return &c.ServiceName })},
+	{"DB_DSN", "PostgreSQL connection string", func(c *Config, s string) error {
+		if err := checkDSN(s); err != nil {
+			return err
+		}
+		c.DSN = s
+		return nil
+	}},
+	{"DB_DSN_FILE", "file holding the PostgreSQL connection string; wins over DB_DSN but not --db-dsn", stringVar(func(c *Config) *string { return &c.DSNFile })},
+	{"DB_MAX_OPEN_CONNS", "connections the pool may open", positiveIntVar(func(c *Config) *int { return &c.DBPool.MaxOpenConns })},
+	{"DB_MAX_IDLE_CONNS", "idle connections the pool keeps", nonNegativeIntVar(func(c *Config) *int { return &c.DBPool.MaxIdleConns })},
+	{"DB_CONN_MAX_LIFETIME", "age at which a connection is replaced; 0 disables it", durationVar(func(c *Config) *time.Duration { return &c.DBPool.ConnMaxLifetime }, true)},
+	{"DB_CONN_MAX_IDLE_TIME", "idle time after which a connection is closed; 0 disables it", durationVar(func(c *Config) *time.Duration { return &c.DBPool.ConnMaxIdleTime }, true)},
+	{"LOG_LEVEL", "debug, info, warn or error", lenient(func(c *Config, s string) error {
+		level, err := parseLogLevel(s)
+		if err != nil {
+			return err
+		}
+		c.LogLevel = level
+		return nil
+	})},
+	{"LOG_FORMAT", "json or text", lenient(func(c *Config, s string) error {
+		format, err := parseLogFormat(s)
+		if err != nil {
+			return err
+		}
+		c.LogFormat = format
+		return nil
+	})},
+	{"LOG_OUTPUT", "stdout, stderr, syslog or the path of a rotated log file", stringVar(func(c *Config) *string { return &c.LogOutput })},
+	{"SYSLOG_NETWORK", "udp or tcp, for LOG_OUTPUT=syslog with SYSLOG_ADDR", lenient(func(c *Config, s string) error {
+		network, err := parseSyslogNetwork(s)
+		if err != nil {
+			return err
+		}
+		c.SyslogNetwork = network
+		return nil
+	})},
+	{"SYSLOG_ADDR", "host:port of a remote syslog server; unset uses the local daemon", lenient(func(c *Config, s string) error {
+		if _, _, err := net.SplitHostPort(s); err != nil {
+			return fmt.Errorf("want host:port, got %q", s)
+		}
+		c.SyslogAddr = s
+		return nil
+	})},
+	{"LOG_SOURCE", "add the source file and line to log records", lenient(boolVar(func(c *Config) *bool { return &c.LogSource }))},
+	{"WORKER_INTERVAL", "pause between batches", durationVar(func(c *Config) *time.Duration { return &c.Interval }, false)},
+	{"WORKER_SCHEDULE", "cron expression replacing the interval loop", func(c *Config, s string) (err error) {
+		c.Schedule, err = ParseSchedule(s)
+		return err
+	}},
+	{"WORKER_STALENESS_FACTOR", "intervals without a run before /ready fails", func(c *Config, s string) error {
+		factor, err := strconv.ParseFloat(s, 64)
+		if err != nil || factor <= 0 {
+			return fmt.Errorf("want a positive number, got %q", s)
+		}
+		c.StalenessFactor = factor
+		return nil
+	}},
+	{"WORKER_STALENESS_MIN", "lower bound of the staleness threshold", durationVar(func(c *Config) *time.Duration { return &c.StalenessMin }, true)},
+	{"WORKER_JITTER", "true, false or a fraction between 0 and 1", func(c *Config, s string) error {
+		if enabled, err := strconv.ParseBool(s); err == nil {
+			c.Jitter = 0
+			if enabled {
+				c.Jitter = defaultJitter
+			}
+			return nil
+		}
+		fraction, err := strconv.ParseFloat(s, 64)
+		if err != nil || fraction <= 0 || fraction >= 1 {
+			return fmt.Errorf("want true, false or a fraction between 0 and 1, got %q", s)
+		}
+		c.Jitter = fraction
+		return nil
+	}},
+	{"WORKER_MAX_ROWS_PER_SEC", "processing rate cap; 0 disables it", func(c *Config, s string) error {
+		limit, err := strconv.ParseFloat(s, 64)
+		if err != nil || limit < 0 {
+			return fmt.Errorf("want a non-negative number, got %q", s)
+		}
+		c.MaxRowsPerSecond = limit
+		return nil
+	}},
+	{"WORKER_STARTUP_DRAIN", "drain the backlog back to back on boot", boolVar(func(c *Config) *bool { return &c.StartupDrain })},
+	{"WORKER_QUERY_TIMEOUT", "timeout of each batch transaction", durationVar(func(c *Config) *time.Duration { return &c.QueryTimeout }, false)},
+	{"WORKER_BATCH_SIZE", "rows claimed per batch", positiveIntVar(func(c *Config) *int { return &c.BatchSize })},
+	{"WORKER_CONCURRENCY", "batches processed in parallel", positiveIntVar(func(c *Config) *int { return &c.Concurrency })},
+	{"WORKER_PROCESSORS", "processors each batch passes through before it is marked, in order, e.g. aggregate,archive", func(c *Config, s string) (err error) {
+		c.Processors, err = parseProcessors(s)
+		return err
+	}},
+	{"WORKER_AGGREGATE_BUCKET", "width of the api_log_rollups time buckets the aggregate processor writes", durationVar(func(c *Config) *time.Duration { return &c.AggregateBucket }, false)},
+	{"WORKER_RECONNECT_THRESHOLD", "consecutive failures before reconnecting", positiveIntVar(func(c *Config) *int { return &c.ReconnectThreshold })},
+	{"DB_REQUIRED", "fail /ready when the database is down", boolVar(func(c *Config) *bool { return &c.DBRequired })},
+	{"READY_CACHE_TTL", "how long a /ready result is reused", durationVar(func(c *Config) *time.Duration { return &c.ReadyCacheTTL }, true)},
+	{"READY_PING_TIMEOUT", "timeout of the /ready database ping", durationVar(func(c *Config) *time.Duration { return &c.ReadyPingTimeout }, false)},
+	{"SHUTDOWN_TIMEOUT", "bound on the whole shutdown", durationVar(func(c *Config) *time.Duration { return &c.ShutdownTimeout }, false)},
+	{"WORKER_SHUTDOWN_GRACE", "how long shutdown waits for the batch in flight", durationVar(func(c *Config) *time.Duration { return &c.ShutdownGrace }, true)},
+	{"ADMIN_TOKEN", "bearer token for the admin routes", stringVar(func(c *Config) *string { return &c.AdminToken })},
+	{"ENABLE_PPROF", "serve /debug/pprof/ on the admin server", boolVar(func(c *Config) *bool { return &c.EnablePprof })},
+	{"METRICS_AUTH_TOKEN", "bearer token for /metrics", stringVar(func(c *Config) *string { return &c.MetricsAuth.token })},
+	{"METRICS_BASIC_AUTH", "user:pass for /metrics", func(c *Config, s string) error {
+		user, password, ok := strings.Cut(s, ":")
+		if !ok || user == "" || password == "" {
+			return errors.New("want user:pass")
+		}
+		c.MetricsAuth.user, c.MetricsAuth.password = user, password
+		return nil
+	}},
+	{"METRICS_DURATION_BUCKETS", "batch duration histogram buckets in seconds", func(c *Config, s string) (err error) {
+		c.DurationBuckets, err = parseBuckets(s)
+		return err
+	}},
+	{"HEALTHZ_DEGRADED_STATUS", "/healthz status when degraded", statusVar(func(c *Config) *int { return &c.HealthzDegradedStatus })},
+	{"HEALTHZ_ERROR_STATUS", "/healthz status when unhealthy", statusVar(func(c *Config) *int { return &c.HealthzErrorStatus })},
+	{"LOG_RETENTION", "age after which api_logs rows are purged; 0 disables it", durationVar(func(c *Config) *time.Duration { return &c.LogRetention }, true)},
+	{"LOG_TABLE_MAX_BYTES", "size of api_logs past which the worker alerts; 0 disables the check", nonNegativeIntVar(func(c *Config) *int { return &c.LogTableMaxBytes })},
+	{"LOG_TABLE_ENFORCE", "purge the oldest processed rows when api_logs passes LOG_TABLE_MAX_BYTES", boolVar(func(c *Config) *bool { return &c.LogTableEnforce })},
+	{"ARCHIVE_DIR", "directory purged rows are archived to", stringVar(func(c *Config) *string { return &c.ArchiveDir })},
+	{"ARCHIVE_S3_BUCKET", "S3 bucket purged rows are archived to", stringVar(func(c *Config) *string { return &c.ArchiveS3Bucket })},
+	{"ARCHIVE_S3_PREFIX", "key prefix of archived objects", stringVar(func(c *Config) *string { return &c.ArchiveS3Prefix })},
+	{"ARCHIVE_S3_REGION", "region of the archive bucket", stringVar(func(c *Config) *string { return &c.ArchiveS3Region })},
+	{"ARCHIVE_S3_ENDPOINT", "S3 endpoint, for S3-compatible stores", urlVar(func(c *Config) *string { return &c.ArchiveS3Endpoint })},
+	{"AWS_ACCESS_KEY_ID", "archive bucket access key", stringVar(func(c *Config) *string { return &c.AWSAccessKeyID })},
+	{"AWS_SECRET_ACCESS_KEY", "archive bucket secret key", stringVar(func(c *Config) *string { return &c.AWSSecretAccessKey })},
+	{"PUSHGATEWAY_URL", "Pushgateway to push metrics to", urlVar(func(c *Config) *string { return &c.PushgatewayURL })},
+	{"PUSHGATEWAY_JOB", "job label of pushed metrics; defaults to SERVICE_NAME", stringVar(func(c *Config) *string { return &c.PushgatewayJob })},
+	{"PUSHGATEWAY_INSTANCE", "instance label of pushed metrics; defaults to the hostname", stringVar(func(c *Config) *string { return &c.PushgatewayInstance })},
+	{"PUSHGATEWAY_INTERVAL", "pause between pushes", durationVar(func(c *Config) *time.Duration { return &c.PushgatewayInterval }, false)},
+	{"PUSHGATEWAY_DELETE_ON_EXIT", "delete the group instead of a final push", boolVar(func(c *Config) *bool { return &c.PushgatewayDeleteOnExit })},
+	{"OTEL_EXPORTER_OTLP_ENDPOINT", "OTLP/HTTP collector to send traces to; unset disables tracing", urlVar(func(c *Config) *string { return &c.OTLPEndpoint })},
+	{"OTEL_EXPORTER_OTLP_METRICS_ENDPOINT", "OTLP/HTTP collector to push metrics to; unset disables the push", urlVar(func(c *Config) *string { return &c.OTLPMetricsEndpoint })},
+	{"OTLP_METRICS_INTERVAL", "pause between OTLP metrics pushes", durationVar(func(c *Config) *time.Duration { return &c.OTLPMetricsInterval }, false)},
+	{"ERROR_WEBHOOK_URL", "webhook to POST error reports to; unset disables reporting", urlVar(func(c *Config) *string { return &c.ErrorWebhookURL })},
+	{"STATSD_ADDR", "statsd agent host:port to mirror metrics to over UDP; unset disables it", func(c *Config, s string) error {
+		if err := checkStatsdAddr(s); err != nil {
+			return err
+		}
+		c.StatsdAddr = s
+		return nil
+	}},
+	{"STATSD_PREFIX", "prefix of every statsd metric name, e.g. agnos.", stringVar(func(c *Config) *string { return &c.StatsdPrefix })},
+	{"STATSD_TAGS", "tags on every statsd metric, e.g. team:platform,region:th", func(c *Config, s string) (err error) {
+		c.StatsdTags, err = parseStatsdTags(s)
+		return err
+	}},
+	{"READINESS_WEBHOOK_URL", "webhook to POST readiness changes to; unset disables the watcher", urlVar(func(c *Config) *string { return &c.ReadinessWebhookURL })},
+	{"READINESS_WATCH_INTERVAL", "pause between the watcher's readiness checks", durationVar(func(c *Config) *time.Duration { return &c.ReadinessInterval }, false)},
+	{"WORKER_WEBHOOK_URL", "webhook to POST a summary of every batch that processed rows to; unset disables it", urlVar(func(c *Config) *string { return &c.WebhookURL })},
+	{"WORKER_WEBHOOK_SECRET", "HMAC-SHA256 key signing the batch summaries", stringVar(func(c *Config) *string { return &c.WebhookSecret })},
+	{"ERROR_REPORT_THRESHOLD", "consecutive failed batches before an error report", positiveIntVar(func(c *Config) *int { return &c.ErrorReportThreshold })},
+}
+
+// LoadConfig stops early with these instead of a configuration when the
+// command line asks for something other than a normal start.
+var (
+	// errVersion means --version was passed.
+	errVersion = errors.New("version requested")
+	// errValidateOnly means --validate-config was passed and the
+	// configuration is valid.
+	errValidateOnly = errors.New("configuration validated")
+	// errMigrateOnly means --migrate-only was passed and the configuration,
+	// which names a database, is valid.
+	errMigrateOnly = errors.New("migrations requested")
+)
+
+// LoadConfig builds the configuration from, in increasing precedence, the
+// defaults, the YAML file named by --config-file or CONFIG_FILE, the
+// environment and the flags in args, the command line without the program
+// name. The error lists every invalid value, not just the first; it is
+// flag.ErrHelp, errVersion, errValidateOnly or errMigrateOnly when the
+// command line asked for that instead.
+func LoadConfig(args []string) (Config, error) {
+	var passed []flagValue
+	fs := newFlagSet(&passed)
+	configFile := fs.String("config-file", os.Getenv("CONFIG_FILE"), "YAML file of settings, overridden by the environment (env CONFIG_FILE)")
+	showVersion := fs.Bool("version", false, "print build information and exit")
+	validateOnly := fs.Bool("validate-config", false, "print the effective configuration and exit")
+	migrateOnly := fs.Bool("migrate-only", false, "apply database migrations and exit")
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+	if *showVersion {
+		return Config{}, errVersion
+	}
+
+	cfg := defaultConfig()
+	var errs []error
+	if *configFile != "" {
+		errs = append(errs, loadConfigFile(&cfg, *configFile)...)
+	}
+	for _, f := range configFields {
+		s := os.Getenv(f.env)
+		if s == "" {
+			continue
+		}
+		if err := f.set(&cfg, s); err != nil {
+			errs = cfg.addError(errs, fmt.Errorf("%s: %w", f.env, err))
+			continue
+		}
+		cfg.setSource(f.env, sourceEnv)
+	}
+	for _, p := range passed {
+		if err := p.field.set(&cfg, p.value); err != nil {
+			errs = cfg.addError(errs, fmt.Errorf("--%s: %w", p.field.flagName(), err))
+			continue
+		}
+		cfg.setSource(p.field.env, sourceFlag)
+	}
+	// DB_DSN_FILE wins over DB_DSN from the environment or CONFIG_FILE,
+	// but not over a --db-dsn that was passed.
+	if cfg.DSNFile != "" && cfg.sources["DB_DSN"] != sourceFlag {
+		if dsn, err := readDSNFile(cfg.DSNFile); err != nil {
+			errs = append(errs, fmt.Errorf("DB_DSN_FILE: %w", err))
+		} else {
+			cfg.DSN = dsn
+			cfg.setSource("DB_DSN", sourceSecretFile)
+		}
+	}
+	if fs.NArg() > 0 {
+		errs = append(errs, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " ")))
+	}
+	errs = append(errs, cfg.validate()...)
+	if *migrateOnly && cfg.DSN == "" {
+		errs = append(errs, errors.New("--migrate-only needs DB_DSN or DB_DSN_FILE"))
+	}
+	if err := errors.Join(errs...); err != nil {
+		return cfg, err
+	}
+	if *validateOnly {
+		return cfg, errValidateOnly
+	}
+	if *migrateOnly {
+		return cfg, errMigrateOnly
+	}
+	return cfg, nil
+}
+
+// flagValue is a setting passed on the command line, applied once the file
+// and the environment have been.
+type flagValue struct {
+	field configField
+	value string
+}
+
+// newFlagSet defines a flag for every config field. Parsing records the
+// passed ones in passed, in command-line order.
+func newFlagSet(passed *[]flagValue) *flag.FlagSet {
+	fs := flag.NewFlagSet("worker", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage of worker:\n\nEach flag falls back to the environment variable named after it, then to CONFIG_FILE, then to its default.\n\n")
+		fs.PrintDefaults()
+	}
+	for _, f := range configFields {
+		fs.Func(f.flagName(), fmt.Sprintf("%s (env %s)", f.usage, f.env), func(s string) error {
+			*passed = append(*passed, flagValue{field: f, value: s})
+			return nil
+		})
+	}
+	return fs
+}
+
+// validate checks the rules that span more than one setting.
+func (c Config) validate() []error {
+	var errs []error
+	if c.DBPool.MaxIdleConns > c.DBPool.MaxOpenConns {
+		errs = append(errs, fmt.Errorf("DB_MAX_IDLE_CONNS (%d) must not exceed DB_MAX_OPEN_CONNS (%d)", c.DBPool.MaxIdleConns, c.DBPool.MaxOpenConns))
+	}
+	if c.Concurrency > c.DBPool.MaxOpenConns {
+		errs = append(errs, fmt.Errorf("WORKER_CONCURRENCY (%d) must not exceed DB_MAX_OPEN_CONNS (%d)", c.Concurrency, c.DBPool.MaxOpenConns))
+	}
+	if c.AdminPort != 0 {
+		if c.AdminToken == "" {
+			errs = append(errs, errors.New("ADMIN_PORT requires ADMIN_TOKEN"))
+		}
+		if c.AdminPort == c.HealthPort {
+			errs = append(errs, fmt.Errorf("ADMIN_PORT and HEALTH_PORT are both %d", c.AdminPort))
+		}
+	}
+	if c.ArchiveDir != "" && c.ArchiveS3Bucket != "" {
+		errs = append(errs, errors.New("ARCHIVE_DIR and ARCHIVE_S3_BUCKET are mutually exclusive"))
+	}
+	if slices.Contains(c.Processors, "archive") && c.ArchiveDir == "" && c.ArchiveS3Bucket == "" {
+		errs = append(errs, errors.New("WORKER_PROCESSORS=archive requires ARCHIVE_DIR or ARCHIVE_S3_BUCKET"))
+	}
+	if c.LogTableEnforce && c.LogTableMaxBytes == 0 {
+		errs = append(errs, errors.New("LOG_TABLE_ENFORCE requires LOG_TABLE_MAX_BYTES"))
+	}
+	if c.ShutdownGrace > c.ShutdownTimeout-shutdownReserve {
+		errs = append(errs, fmt.Errorf("WORKER_SHUTDOWN_GRACE (%s) must leave %s of SHUTDOWN_TIMEOUT (%s)", c.ShutdownGrace, shutdownReserve, c.ShutdownTimeout))
+	}
+	if c.WebhookURL != "" && c.WebhookSecret == "" {
+		errs = append(errs, errors.New("WORKER_WEBHOOK_SECRET is required with WORKER_WEBHOOK_URL"))
+	}
+	return errs
+}
+
+// LogValue logs the effective configuration without its secrets.
+func (c Config) LogValue() slog.Value {
+	metricsAuthMode := "none"
+	switch {
+	case c.MetricsAuth.user != "":
+		metricsAuthMode = "basic"
+	case c.MetricsAuth.token != "":
+		metricsAuthMode = "bearer"
+	}
+	schedule := ""
+	if c.Schedule != nil {
+		schedule = c.Schedule.String()
+	}
+	return slog.GroupValue(
+		slog.Int("health_port", c.HealthPort),
+		slog.Int("admin_port", c.AdminPort),
+		slog.String("env", c.Env),
+		slog.String("service_name", c.ServiceName),
+		slog.String("db_dsn", redactDSN(c.DSN)),
+		slog.String("db_dsn_file", c.DSNFile),
+		slog.Any("db_pool", c.DBPool),
+		slog.String("log_level", c.LogLevel.String()),
+		slog.String("log_format", c.LogFormat),
+		slog.String("log_output", c.LogOutput),
+		slog.String("syslog_network", c.SyslogNetwork),
+		slog.String("syslog_addr", c.SyslogAddr),
+		slog.Bool("log_source", c.LogSource),
+		slog.String("interval", c.Interval.String()),
+		slog.String("schedule", schedule),
+		slog.Float64("staleness_factor", c.StalenessFactor),
+		slog.String("staleness_min", c.StalenessMin.String()),
+		slog.Float64("jitter", c.Jitter),
+		slog.Float64("max_rows_per_sec", c.MaxRowsPerSecond),
+		slog.Bool("startup_drain", c.StartupDrain),
+		slog.String("query_timeout", c.QueryTimeout.String()),
+		slog.Int("batch_size", c.BatchSize),
+		slog.Int("concurrency", c.Concurrency),
+		slog.Any("processors", c.Processors),
+		slog.String("aggregate_bucket", c.AggregateBucket.String()),
+		slog.Int("reconnect_threshold", c.ReconnectThreshold),
+		slog.Bool("db_required", c.DBRequired),
+		slog.String("ready_cache_ttl", c.ReadyCacheTTL.String()),
+		slog.String("ready_ping_timeout", c.ReadyPingTimeout.String()),
+		slog.String("shutdown_timeout", c.ShutdownTimeout.String()),
+		slog.String("shutdown_grace", c.ShutdownGrace.String()),
+		slog.Bool("admin_token_set", c.AdminToken != ""),
+		slog.Bool("enable_pprof", c.EnablePprof),
+		slog.String("metrics_auth", metricsAuthMode),
+		slog.Any("metrics_duration_buckets", c.DurationBuckets),
+		slog.Int("healthz_degraded_status", c.HealthzDegradedStatus),
+		slog.Int("healthz_error_status", c.HealthzErrorStatus),
+		slog.String("log_retention", c.LogRetention.String()),
+		slog.Int("log_table_max_bytes", c.LogTableMaxBytes),
+		slog.Bool("log_table_enforce", c.LogTableEnforce),
+		slog.String("archive_dir", c.ArchiveDir),
+		slog.String("archive_s3_bucket", c.ArchiveS3Bucket),
+		slog.Bool("aws_credentials_set", c.AWSAccessKeyID != "" && c.AWSSecretAccessKey != ""),
+		slog.String("pushgateway_url", c.PushgatewayURL),
+		slog.String("pushgateway_interval", c.PushgatewayInterval.String()),
+		slog.String("otel_exporter_otlp_endpoint", c.OTLPEndpoint),
+		slog.String("otel_exporter_otlp_metrics_endpoint", c.OTLPMetricsEndpoint),
+		slog.String("otlp_metrics_interval", c.OTLPMetricsInterval.String()),
+		// Webhook URLs often carry their credentials in the path.
+		slog.Bool("error_webhook_url_set", c.ErrorWebhookURL != ""),
+		slog.String("statsd_addr", c.StatsdAddr),
+		slog.String("statsd_prefix", c.StatsdPrefix),
+		slog.Any("statsd_tags", c.StatsdTags),
+		slog.Bool("readiness_webhook_url_set", c.ReadinessWebhookURL != ""),
+		slog.String("readiness_watch_interval", c.ReadinessInterval.String()),
+		slog.Bool("worker_webhook_url_set", c.WebhookURL != ""),
+		slog.Int("error_report_threshold", c.ErrorReportThreshold),
+	)
+}
+
+// fallbackError is an invalid value of a logging setting. It leaves the
+// setting as it was and is only warned about, since a typo in how the
+// service logs shouldn't keep it from starting.
+type fallbackError struct{ err error }
+
+func (e fallbackError) Error() string { return e.err.Error() }
+func (e fallbackError) Unwrap() error { return e.err }
+
+// lenient makes the errors of set fallbackErrors.
+func lenient(set func(*Config, string) error) func(*Config, string) error {
+	return func(c *Config, s string) error {
+		if err := set(c, s); err != nil {
+			return fallbackError{err}
+		}
+		return nil
+	}
+}
+
+// addError appends err to errs, or records it as a warning when it is a
+// fallbackError.
+func (c *Config) addError(errs []error, err error) []error {
+	if fe := (fallbackError{}); errors.As(err, &fe) {
+		c.warnings = append(c.warnings, err)
+		return errs
+	}
+	return append(errs, err)
+}
+
+func stringVar(field func(*Config) *string) func(*Config, string) error {
+	return func(c *Config, s string) error {
+		*field(c) = s
+		return nil
+	}
+}
+
+func boolVar(field func(*Config) *bool) func(*Config, string) error {
+	return func(c *Config, s string) error {
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("want true or false, got %q", s)
+		}
+		*field(c) = b
+		return nil
+	}
+}
+
+func portVar(field func(*Config) *int) func(*Config, string) error {
+	return func(c *Config, s string) error {
+		port, err := strconv.Atoi(s)
+		if err != nil || port < 1 || port > 65535 {
+			return fmt.Errorf("want a port between 1 and 65535, got %q", s)
+		}
+		*field(c) = port
+		return nil
+	}
+}
+
+func positiveIntVar(field func(*Config) *int) func(*Config, string) error {
+	return func(c *Config, s string) error {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("want a positive integer, got %q", s)
+		}
+		*field(c) = n
+		return nil
+	}
+}
+
+func nonNegativeIntVar(field func(*Config) *int) func(*Config, string) error {
+	return func(c *Config, s string) error {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return fmt.Errorf("want a non-negative integer, got %q", s)
+		}
+		*field(c) = n
+		return nil
+	}
+}
+
+func statusVar(field func(*Config) *int) func(*Config, string) error {
+	return func(c *Config, s string) error {
+		code, err := strconv.Atoi(s)
+		if err != nil || code < 200 || code > 599 {
+			return fmt.Errorf("want an HTTP status between 200 and 599, got %q", s)
+		}
+		*field(c) = code
+		return nil
+	}
+}
+
+// durationVar parses a Go duration, which must be positive, or non-negative
+// when allowZero is set.
+func durationVar(field func(*Config) *time.Duration, allowZero bool) func(*Config, string) error {
+	return func(c *Config, s string) error {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("want a duration such as 5s, got %q", s)
+		}
+		if d < 0 && allowZero {
+			return fmt.Errorf("must not be negative, got %s", s)
+		}
+		if d <= 0 && !allowZero {
+			return fmt.Errorf("must be positive, got %s", s)
+		}
+		*field(c) = d
+		return nil
+	}
+}
+
+// urlVar accepts an absolute http or https URL.
+func urlVar(field func(*Config) *string) func(*Config, string) error {
+	return func(c *Config, s string) error {
+		if u, err := url.Parse(s); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("want http(s)://host[:port], got %q", s)
+		}
+		*field(c) = s
+		return nil
+	}
+}
+
+// checkDSN reports whether s parses as a PostgreSQL connection string.
+func checkDSN(s string) error {
+	if _, err := pgx.ParseConfig(s); err != nil {
+		return errors.New("not a valid PostgreSQL URL or key=value connection string")
+	}
+	return nil
+}
+
+var dsnPassword = regexp.MustCompile(`(password\s*=\s*)('(?:[^'\\]|\\.)*'|\S*)`)
+
+// redactDSN masks the password in a URL or key=value connection string.
+func redactDSN(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && u.Scheme != "" {
+		if q := u.Query(); q.Has("password") {
+			q.Set("password", redacted)
+			u.RawQuery = q.Encode()
+		}
+		return u.Redacted()
+	}
+	return dsnPassword.ReplaceAllString(dsn, "${1}xxxxx")
+}
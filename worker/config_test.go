@@ -0,0 +1,463 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clearConfigEnv unsets every variable LoadConfig reads, so tests start from
+// the defaults whatever the environment running them has set.
+func clearConfigEnv(t *testing.T) {
+	t.Helper()
+	for _, f := range configFields {
+		t.Setenv(f.env, "")
+	}
+	t.Setenv("CONFIG_FILE", "")
+}
+
+func TestLoadConfig_Defaults(t *testing.T) {
+	clearConfigEnv(t)
+	cfg, err := LoadConfig(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(cfg, defaultConfig()) {
+		t.Errorf("expected the defaults, got %+v", cfg)
+	}
+	if cfg.HealthPort != 8081 || cfg.Interval != 2*time.Second || cfg.Concurrency != 1 || cfg.Schedule != nil {
+		t.Errorf("unexpected defaults %+v", cfg)
+	}
+}
+
+func TestLoadConfig_Values(t *testing.T) {
+	clearConfigEnv(t)
+	for env, value := range map[string]string{
+		"HEALTH_PORT":                         "9091",
+		"ADMIN_PORT":                          "9092",
+		"ADMIN_TOKEN":                         "admsecret",
+		"ENABLE_PPROF":                        "true",
+		"DB_DSN":                              "host=db user=app password=secret dbname=app",
+		"DB_MAX_OPEN_CONNS":                   "4",
+		"DB_MAX_IDLE_CONNS":                   "0",
+		"DB_CONN_MAX_LIFETIME":                "0",
+		"LOG_LEVEL":                           "debug",
+		"LOG_FORMAT":                          "text",
+		"LOG_OUTPUT":                          "/var/log/worker.log",
+		"SYSLOG_NETWORK":                      "tcp",
+		"SYSLOG_ADDR":                         "10.0.0.5:514",
+		"LOG_SOURCE":                          "1",
+		"WORKER_INTERVAL":                     "5s",
+		"WORKER_SCHEDULE":                     "*/5 * * * *",
+		"WORKER_STALENESS_FACTOR":             "2.5",
+		"WORKER_STALENESS_MIN":                "1m",
+		"WORKER_JITTER":                       "true",
+		"WORKER_MAX_ROWS_PER_SEC":             "0.5",
+		"WORKER_STARTUP_DRAIN":                "true",
+		"WORKER_QUERY_TIMEOUT":                "45s",
+		"WORKER_CONCURRENCY":                  "4",
+		"WORKER_PROCESSORS":                   "archive, aggregate",
+		"WORKER_AGGREGATE_BUCKET":             "5m",
+		"WORKER_RECONNECT_THRESHOLD":          "7",
+		"DB_REQUIRED":                         "false",
+		"READY_CACHE_TTL":                     "0",
+		"HEALTHZ_ERROR_STATUS":                "500",
+		"METRICS_AUTH_TOKEN":                  "s3cret",
+		"LOG_RETENTION":                       "720h",
+		"LOG_TABLE_MAX_BYTES":                 "10737418240",
+		"LOG_TABLE_ENFORCE":                   "true",
+		"ARCHIVE_S3_BUCKET":                   "logs",
+		"PUSHGATEWAY_URL":                     "http://pushgateway:9091",
+		"OTEL_EXPORTER_OTLP_ENDPOINT":         "http://tempo:4318",
+		"OTEL_EXPORTER_OTLP_METRICS_ENDPOINT": "http://collector:4318/v1/metrics",
+		"OTLP_METRICS_INTERVAL":               "15s",
+		"ERROR_WEBHOOK_URL":                   "https://hooks.example.com/services/T0/B0/secret",
+		"STATSD_ADDR":                         "127.0.0.1:8125",
+		"STATSD_PREFIX":                       "agnos.",
+		"STATSD_TAGS":                         "team:platform,region:th",
+		"READINESS_WEBHOOK_URL":               "https://hooks.example.com/services/T0/B1/secret",
+		"READINESS_WATCH_INTERVAL":            "30s",
+		"WORKER_WEBHOOK_URL":                  "https://analytics.example.com/hooks/batches",
+		"WORKER_WEBHOOK_SECRET":               "hmac-key",
+		"ERROR_REPORT_THRESHOLD":              "10",
+		"PUSHGATEWAY_INTERVAL":                "1m",
+		"PUSHGATEWAY_DELETE_ON_EXIT":          "true",
+		"SHUTDOWN_TIMEOUT":                    "20s",
+		"WORKER_SHUTDOWN_GRACE":               "15s",
+	} {
+		t.Setenv(env, value)
+	}
+	cfg, err := LoadConfig(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Schedule == nil || cfg.Schedule.String() != "*/5 * * * *" {
+		t.Errorf("expected the schedule to be parsed, got %v", cfg.Schedule)
+	}
+	want := defaultConfig()
+	want.HealthPort, want.AdminPort = 9091, 9092
+	want.AdminToken = "admsecret"
+	want.EnablePprof = true
+	want.DSN = "host=db user=app password=secret dbname=app"
+	want.DBPool = dbPoolConfig{MaxOpenConns: 4}
+	want.LogLevel = slog.LevelDebug
+	want.LogFormat = logFormatText
+	want.LogOutput = "/var/log/worker.log"
+	want.SyslogNetwork = syslogNetworkTCP
+	want.SyslogAddr = "10.0.0.5:514"
+	want.LogSource = true
+	want.Interval = 5 * time.Second
+	want.Schedule = cfg.Schedule
+	want.StalenessFactor = 2.5
+	want.StalenessMin = time.Minute
+	want.Jitter = defaultJitter
+	want.MaxRowsPerSecond = 0.5
+	want.StartupDrain = true
+	want.QueryTimeout = 45 * time.Second
+	want.Concurrency = 4
+	want.Processors = []string{"archive", "aggregate"}
+	want.AggregateBucket = 5 * time.Minute
+	want.ReconnectThreshold = 7
+	want.DBRequired = false
+	want.ReadyCacheTTL = 0
+	want.HealthzErrorStatus = 500
+	want.MetricsAuth = metricsCredentials{token: "s3cret"}
+	want.LogRetention = 720 * time.Hour
+	want.LogTableMaxBytes = 10 << 30
+	want.LogTableEnforce = true
+	want.ArchiveS3Bucket = "logs"
+	want.PushgatewayURL = "http://pushgateway:9091"
+	want.OTLPEndpoint = "http://tempo:4318"
+	want.OTLPMetricsEndpoint = "http://collector:4318/v1/metrics"
+	want.OTLPMetricsInterval = 15 * time.Second
+	want.ErrorWebhookURL = "https://hooks.example.com/services/T0/B0/secret"
+	want.StatsdAddr = "127.0.0.1:8125"
+	want.StatsdPrefix = "agnos."
+	want.StatsdTags = []string{"team:platform", "region:th"}
+	want.ReadinessWebhookURL = "https://hooks.example.com/services/T0/B1/secret"
+	want.ReadinessInterval = 30 * time.Second
+	want.WebhookURL = "https://analytics.example.com/hooks/batches"
+	want.WebhookSecret = "hmac-key"
+	want.ErrorReportThreshold = 10
+	want.PushgatewayInterval = time.Minute
+	want.PushgatewayDeleteOnExit = true
+	want.ShutdownTimeout = 20 * time.Second
+	want.ShutdownGrace = 15 * time.Second
+	want.sources = cfg.sources // checked by TestLoadConfig_Sources
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("expected %+v, got %+v", want, cfg)
+	}
+}
+
+func TestLoadConfig_Jitter(t *testing.T) {
+	cases := map[string]float64{"true": defaultJitter, "false": 0, "0": 0, "0.25": 0.25}
+	for in, want := range cases {
+		clearConfigEnv(t)
+		t.Setenv("WORKER_JITTER", in)
+		if cfg, err := LoadConfig(nil); err != nil || cfg.Jitter != want {
+			t.Errorf("WORKER_JITTER=%q: expected %v, got %v, %v", in, want, cfg.Jitter, err)
+		}
+	}
+}
+
+func TestLoadConfig_Invalid(t *testing.T) {
+	tests := []struct {
+		env, value, want string
+	}{
+		{"HEALTH_PORT", "health", "HEALTH_PORT: want a port between 1 and 65535"},
+		{"HEALTH_PORT", "65536", "HEALTH_PORT: want a port"},
+		{"ADMIN_PORT", "0", "ADMIN_PORT: want a port"},
+		{"DB_DSN", "not a dsn", "DB_DSN: not a valid PostgreSQL"},
+		{"DB_MAX_OPEN_CONNS", "0", "DB_MAX_OPEN_CONNS: want a positive integer"},
+		{"DB_MAX_IDLE_CONNS", "-1", "DB_MAX_IDLE_CONNS: want a non-negative integer"},
+		{"DB_CONN_MAX_LIFETIME", "-1m", "DB_CONN_MAX_LIFETIME: must not be negative"},
+		{"DB_CONN_MAX_IDLE_TIME", "forever", "DB_CONN_MAX_IDLE_TIME: want a duration"},
+		{"WORKER_INTERVAL", "fast", "WORKER_INTERVAL: want a duration"},
+		{"WORKER_INTERVAL", "0s", "WORKER_INTERVAL: must be positive"},
+		{"WORKER_SCHEDULE", "every minute", "WORKER_SCHEDULE:"},
+		{"WORKER_STALENESS_FACTOR", "-1", "WORKER_STALENESS_FACTOR: want a positive number"},
+		{"WORKER_STALENESS_MIN", "soon", "WORKER_STALENESS_MIN: want a duration"},
+		{"WORKER_JITTER", "2", "WORKER_JITTER: want true, false or a fraction"},
+		{"WORKER_JITTER", "abc", "WORKER_JITTER: want true, false or a fraction"},
+		{"WORKER_MAX_ROWS_PER_SEC", "-5", "WORKER_MAX_ROWS_PER_SEC: want a non-negative number"},
+		{"WORKER_STARTUP_DRAIN", "maybe", "WORKER_STARTUP_DRAIN: want true or false"},
+		{"WORKER_QUERY_TIMEOUT", "-5s", "WORKER_QUERY_TIMEOUT: must be positive"},
+		{"WORKER_CONCURRENCY", "0", "WORKER_CONCURRENCY: want a positive integer"},
+		{"WORKER_PROCESSORS", "aggregate,webhook", `WORKER_PROCESSORS: unknown processor "webhook": want aggregate, archive`},
+		{"WORKER_PROCESSORS", "aggregate,aggregate", "WORKER_PROCESSORS: duplicate processor aggregate"},
+		{"WORKER_AGGREGATE_BUCKET", "0", "WORKER_AGGREGATE_BUCKET: must be positive"},
+		{"WORKER_RECONNECT_THRESHOLD", "abc", "WORKER_RECONNECT_THRESHOLD: want a positive integer"},
+		{"DB_REQUIRED", "abc", "DB_REQUIRED: want true or false"},
+		{"ENABLE_PPROF", "on", "ENABLE_PPROF: want true or false"},
+		{"READY_CACHE_TTL", "-1s", "READY_CACHE_TTL: must not be negative"},
+		{"READY_PING_TIMEOUT", "0", "READY_PING_TIMEOUT: must be positive"},
+		{"SHUTDOWN_TIMEOUT", "0", "SHUTDOWN_TIMEOUT: must be positive"},
+		{"WORKER_SHUTDOWN_GRACE", "-1s", "WORKER_SHUTDOWN_GRACE: must not be negative"},
+		{"METRICS_BASIC_AUTH", ":pw", "METRICS_BASIC_AUTH: want user:pass"},
+		{"METRICS_DURATION_BUCKETS", "1,0.5", "METRICS_DURATION_BUCKETS: buckets must be strictly increasing"},
+		{"HEALTHZ_DEGRADED_STATUS", "99", "HEALTHZ_DEGRADED_STATUS: want an HTTP status"},
+		{"LOG_RETENTION", "-24h", "LOG_RETENTION: must not be negative"},
+		{"LOG_TABLE_MAX_BYTES", "10GB", "LOG_TABLE_MAX_BYTES: want a non-negative integer"},
+		{"ARCHIVE_S3_ENDPOINT", "minio:9000", "ARCHIVE_S3_ENDPOINT: want http(s)://host"},
+		{"PUSHGATEWAY_URL", "pushgateway:9091", "PUSHGATEWAY_URL: want http(s)://host"},
+		{"OTEL_EXPORTER_OTLP_ENDPOINT", "tempo:4318", "OTEL_EXPORTER_OTLP_ENDPOINT: want http(s)://host"},
+		{"OTLP_METRICS_INTERVAL", "0s", "OTLP_METRICS_INTERVAL: must be positive"},
+		{"ERROR_WEBHOOK_URL", "hooks.example.com/report", "ERROR_WEBHOOK_URL: want http(s)://host"},
+		{"STATSD_ADDR", "localhost", "STATSD_ADDR: want host:port"},
+		{"STATSD_ADDR", "localhost:0", "STATSD_ADDR: want a port between 1 and 65535"},
+		{"STATSD_TAGS", "team:platform,,", "STATSD_TAGS: invalid tag"},
+		{"READINESS_WEBHOOK_URL", "hooks.example.com/ready", "READINESS_WEBHOOK_URL: want http(s)://host"},
+		{"READINESS_WATCH_INTERVAL", "0s", "READINESS_WATCH_INTERVAL: must be positive"},
+		{"WORKER_WEBHOOK_URL", "analytics.example.com", "WORKER_WEBHOOK_URL: want http(s)://host"},
+		{"ERROR_REPORT_THRESHOLD", "0", "ERROR_REPORT_THRESHOLD: want a positive integer"},
+		{"PUSHGATEWAY_INTERVAL", "often", "PUSHGATEWAY_INTERVAL: want a duration"},
+		{"PUSHGATEWAY_DELETE_ON_EXIT", "yes", "PUSHGATEWAY_DELETE_ON_EXIT: want true or false"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.env+"="+tt.value, func(t *testing.T) {
+			clearConfigEnv(t)
+			t.Setenv(tt.env, tt.value)
+			_, err := LoadConfig(nil)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected an error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestLoadConfig_LoggingFallback(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv("LOG_LEVEL", "trace")
+	t.Setenv("LOG_SOURCE", "maybe")
+	t.Setenv("SYSLOG_NETWORK", "tls")
+	path := writeConfigFile(t, "log_format: pretty\n")
+	cfg, err := LoadConfig([]string{"--config-file", path})
+	if err != nil {
+		t.Fatalf("expected invalid logging settings not to fail startup, got %v", err)
+	}
+	if cfg.LogLevel != slog.LevelInfo || cfg.LogFormat != logFormatJSON || cfg.LogSource {
+		t.Errorf("expected the logging defaults, got level=%s format=%s source=%v", cfg.LogLevel, cfg.LogFormat, cfg.LogSource)
+	}
+	warnings := errors.Join(cfg.warnings...)
+	for _, want := range []string{"log_format: want json or text", "LOG_LEVEL: invalid log level", "LOG_SOURCE: want true or false", "SYSLOG_NETWORK: want udp or tcp"} {
+		if warnings == nil || !strings.Contains(warnings.Error(), want) {
+			t.Errorf("expected a warning containing %q, got %v", want, warnings)
+		}
+	}
+
+	t.Setenv("WORKER_BATCH_SIZE", "0")
+	_, err = LoadConfig(nil)
+	if err == nil || strings.Contains(err.Error(), "LOG_") {
+		t.Errorf("expected only the batch size to be an error, got %v", err)
+	}
+}
+
+func TestLoadConfig_ArchiveDestinationsExclusive(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv("ARCHIVE_DIR", t.TempDir())
+	t.Setenv("ARCHIVE_S3_BUCKET", "logs")
+	if _, err := LoadConfig(nil); err == nil || !strings.Contains(err.Error(), "mutually exclusive") {
+		t.Errorf("expected both destinations to be rejected, got %v", err)
+	}
+}
+
+func TestLoadConfig_ArchiveProcessorNeedsDestination(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv("WORKER_PROCESSORS", "archive")
+	if _, err := LoadConfig(nil); err == nil || !strings.Contains(err.Error(), "WORKER_PROCESSORS=archive requires ARCHIVE_DIR or ARCHIVE_S3_BUCKET") {
+		t.Errorf("expected the archive processor without a destination to be rejected, got %v", err)
+	}
+	t.Setenv("ARCHIVE_DIR", t.TempDir())
+	if _, err := LoadConfig(nil); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestLoadConfig_AdminPort(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv("ADMIN_PORT", "8081")
+	_, err := LoadConfig(nil)
+	if err == nil || !strings.Contains(err.Error(), "ADMIN_PORT requires ADMIN_TOKEN") || !strings.Contains(err.Error(), "ADMIN_PORT and HEALTH_PORT are both 8081") {
+		t.Errorf("expected an admin port without a token, clashing with HEALTH_PORT, to be rejected, got %v", err)
+	}
+	t.Setenv("ADMIN_PORT", "8082")
+	t.Setenv("ADMIN_TOKEN", "s3cret")
+	if _, err := LoadConfig(nil); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestLoadConfig_ShutdownGraceFitsTimeout(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv("SHUTDOWN_TIMEOUT", "10s")
+	if _, err := LoadConfig(nil); err == nil || !strings.Contains(err.Error(), "WORKER_SHUTDOWN_GRACE (20s) must leave 2s of SHUTDOWN_TIMEOUT (10s)") {
+		t.Errorf("expected a grace longer than the loops phase to be rejected, got %v", err)
+	}
+	t.Setenv("WORKER_SHUTDOWN_GRACE", "8s")
+	if _, err := LoadConfig(nil); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestLoadConfig_TableEnforceNeedsMax(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv("LOG_TABLE_ENFORCE", "true")
+	if _, err := LoadConfig(nil); err == nil || !strings.Contains(err.Error(), "LOG_TABLE_ENFORCE requires LOG_TABLE_MAX_BYTES") {
+		t.Errorf("expected enforcement without a limit to be rejected, got %v", err)
+	}
+}
+
+func TestLoadConfig_WebhookNeedsSecret(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv("WORKER_WEBHOOK_URL", "https://analytics.example.com/hooks/batches")
+	if _, err := LoadConfig(nil); err == nil || !strings.Contains(err.Error(), "WORKER_WEBHOOK_SECRET is required with WORKER_WEBHOOK_URL") {
+		t.Errorf("expected an unsigned webhook to be rejected, got %v", err)
+	}
+}
+
+func TestLoadConfig_PoolLimits(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv("DB_MAX_IDLE_CONNS", "2")
+	t.Setenv("WORKER_CONCURRENCY", "4")
+	t.Setenv("DB_MAX_OPEN_CONNS", "4")
+	if _, err := LoadConfig(nil); err != nil {
+		t.Fatalf("expected one connection per batch to be enough, got %v", err)
+	}
+	t.Setenv("WORKER_CONCURRENCY", "8")
+	if _, err := LoadConfig(nil); err == nil || !strings.Contains(err.Error(), "WORKER_CONCURRENCY (8) must not exceed DB_MAX_OPEN_CONNS (4)") {
+		t.Errorf("expected more batches than connections to be rejected, got %v", err)
+	}
+
+	t.Setenv("WORKER_CONCURRENCY", "1")
+	t.Setenv("DB_MAX_OPEN_CONNS", "1")
+	if _, err := LoadConfig(nil); err == nil || !strings.Contains(err.Error(), "DB_MAX_IDLE_CONNS (2) must not exceed DB_MAX_OPEN_CONNS (1)") {
+		t.Errorf("expected an idle pool larger than the open pool to be rejected, got %v", err)
+	}
+}
+
+func TestLoadConfig_ReportsEveryProblem(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv("HEALTH_PORT", "health")
+	t.Setenv("WORKER_INTERVAL", "fast")
+	t.Setenv("WORKER_CONCURRENCY", "-2")
+	_, err := LoadConfig(nil)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, env := range []string{"HEALTH_PORT", "WORKER_INTERVAL", "WORKER_CONCURRENCY"} {
+		if !strings.Contains(err.Error(), env+":") {
+			t.Errorf("expected %s in %v", env, err)
+		}
+	}
+	var joined interface{ Unwrap() []error }
+	if !errors.As(err, &joined) || len(joined.Unwrap()) != 3 {
+		t.Errorf("expected three joined errors, got %v", err)
+	}
+}
+
+func TestRedactDSN(t *testing.T) {
+	tests := map[string]string{
+		"":                                      "",
+		"postgres://app:secret@db:5432/app":     "postgres://app:xxxxx@db:5432/app",
+		"postgres://app@db/app?password=secret": "postgres://app@db/app?password=xxxxx",
+		"host=db user=app password=secret":      "host=db user=app password=xxxxx",
+		"host=db password='se cret' dbname=app": "host=db password=xxxxx dbname=app",
+	}
+	for dsn, want := range tests {
+		if got := redactDSN(dsn); got != want {
+			t.Errorf("redactDSN(%q) = %q, want %q", dsn, got, want)
+		}
+	}
+}
+
+func TestConfig_LogValueHidesSecrets(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.DSN = "host=db password=dbsecret"
+	cfg.AdminToken = "admsecret"
+	cfg.MetricsAuth = metricsCredentials{token: "promsecret"}
+	cfg.AWSSecretAccessKey = "awssecret"
+
+	var buf bytes.Buffer
+	slog.New(slog.NewJSONHandler(&buf, nil)).Info("worker initializing", "config", cfg)
+	out := buf.String()
+	for _, secret := range []string{"dbsecret", "admsecret", "promsecret", "awssecret"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("log line leaks %s: %s", secret, out)
+		}
+	}
+	for _, want := range []string{`"db_dsn":"host=db password=xxxxx"`, `"admin_token_set":true`, `"metrics_auth":"bearer"`, `"health_port":8081`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %s in %s", want, out)
+		}
+	}
+}
+
+func TestLoadConfig_FlagsOverrideEnv(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv("WORKER_INTERVAL", "5s")
+	t.Setenv("WORKER_BATCH_SIZE", "500")
+	t.Setenv("WORKER_CONCURRENCY", "2")
+	cfg, err := LoadConfig([]string{"--worker-interval=10s", "--batch-size", "250"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Interval != 10*time.Second || cfg.BatchSize != 250 {
+		t.Errorf("expected the flags to beat the environment, got %v and %d", cfg.Interval, cfg.BatchSize)
+	}
+	if cfg.Concurrency != 2 {
+		t.Errorf("expected WORKER_CONCURRENCY without a flag, got %d", cfg.Concurrency)
+	}
+	if cfg.HealthPort != 8081 {
+		t.Errorf("expected the default health port, got %d", cfg.HealthPort)
+	}
+}
+
+func TestLoadConfig_FlagErrors(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv("HEALTH_PORT", "health")
+	_, err := LoadConfig([]string{"--batch-size=0", "--worker-interval", "fast"})
+	for _, want := range []string{"HEALTH_PORT: want a port", "--batch-size: want a positive integer", "--worker-interval: want a duration"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q in %v", want, err)
+		}
+	}
+
+	if _, err := LoadConfig([]string{"run"}); err == nil || !strings.Contains(err.Error(), "unexpected arguments: run") {
+		t.Errorf("expected positional arguments to be rejected, got %v", err)
+	}
+}
+
+func TestLoadConfig_HelpAndVersion(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv("HEALTH_PORT", "health")
+	if _, err := LoadConfig([]string{"--version"}); !errors.Is(err, errVersion) {
+		t.Errorf("expected errVersion even with invalid settings, got %v", err)
+	}
+	if _, err := LoadConfig([]string{"--help"}); !errors.Is(err, flag.ErrHelp) {
+		t.Errorf("expected flag.ErrHelp, got %v", err)
+	}
+	if !strings.HasPrefix(buildInfo(), "worker "+version+" (commit "+commit) {
+		t.Errorf("unexpected build info %q", buildInfo())
+	}
+}
+
+func TestFlagSet_UsageNamesEnvVars(t *testing.T) {
+	var passed []flagValue
+	fs := newFlagSet(&passed)
+	var buf bytes.Buffer
+	fs.SetOutput(&buf)
+	fs.Usage()
+	for _, f := range configFields {
+		if !strings.Contains(buf.String(), "-"+f.flagName()+" ") || !strings.Contains(buf.String(), "(env "+f.env+")") {
+			t.Errorf("expected --%s and %s in the usage", f.flagName(), f.env)
+		}
+	}
+	if !strings.Contains(buf.String(), "-batch-size ") || !strings.Contains(buf.String(), "(env WORKER_BATCH_SIZE)") {
+		t.Errorf("expected --batch-size to name WORKER_BATCH_SIZE:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfigFile writes body to a config.yaml in a temporary directory and
+// returns its path.
+func writeConfigFile(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	path := writeConfigFile(t, `
+health_port: 9000
+worker_interval: 5s
+worker_batch_size: 50
+worker_jitter: 0.2
+metrics_duration_buckets: [0.5, 1]
+log_retention: 720h
+archive_dir: /var/archive
+pushgateway_delete_on_exit: true
+admin_token:
+`)
+	cfg := defaultConfig()
+	if errs := loadConfigFile(&cfg, path); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	want := defaultConfig()
+	want.HealthPort = 9000
+	want.Interval = 5 * time.Second
+	want.BatchSize = 50
+	want.Jitter = 0.2
+	want.DurationBuckets = []float64{0.5, 1}
+	want.LogRetention = 720 * time.Hour
+	want.ArchiveDir = "/var/archive"
+	want.PushgatewayDeleteOnExit = true
+	want.sources = cfg.sources // checked by TestLoadConfig_Sources
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("expected %+v, got %+v", want, cfg)
+	}
+}
+
+func TestLoadConfigFile_Errors(t *testing.T) {
+	tests := []struct {
+		name, body, want string
+	}{
+		{"unknown key", "health_port: 9000\nworker_intreval: 5s\n", `config.yaml:2: unknown key "worker_intreval"`},
+		{"flag-style key", "batch_size: 50\n", `unknown key "batch_size"`},
+		{"duplicate key", "health_port: 9000\nhealth_port: 9001\n", `config.yaml:2: duplicate key "health_port"`},
+		{"invalid value", "worker_interval: soon\n", "config.yaml:1: worker_interval:"},
+		{"not a mapping", "- health_port\n", "want a mapping of settings"},
+		{"not yaml", "health_port: [9000\n", "config.yaml"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := defaultConfig()
+			err := errors.Join(loadConfigFile(&cfg, writeConfigFile(t, tt.body))...)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected an error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+
+	cfg := defaultConfig()
+	if errs := loadConfigFile(&cfg, filepath.Join(t.TempDir(), "missing.yaml")); len(errs) != 1 || !strings.Contains(errs[0].Error(), "CONFIG_FILE:") {
+		t.Errorf("expected a missing file to be reported, got %v", errs)
+	}
+	if errs := loadConfigFile(&cfg, writeConfigFile(t, "")); len(errs) != 0 {
+		t.Errorf("expected an empty file to change nothing, got %v", errs)
+	}
+}
+
+// Each layer overrides the one below it: default < file < environment < flag.
+func TestLoadConfig_Precedence(t *testing.T) {
+	path := writeConfigFile(t, "health_port: 9001\nworker_batch_size: 20\napp_env: staging\n")
+	tests := []struct {
+		name                string
+		env                 map[string]string
+		args                []string
+		wantPort, wantBatch int
+		wantAppEnv          string
+	}{
+		{"defaults", nil, nil, 8081, defaultBatchSize, "development"},
+		{"file", map[string]string{"CONFIG_FILE": path}, nil, 9001, 20, "staging"},
+		{"env over file", map[string]string{"CONFIG_FILE": path, "HEALTH_PORT": "9002", "APP_ENV": "qa"}, nil, 9002, 20, "qa"},
+		{"flag over env", map[string]string{"CONFIG_FILE": path, "HEALTH_PORT": "9002", "APP_ENV": "qa"}, []string{"--health-port=9003"}, 9003, 20, "qa"},
+		{"flag names the file", map[string]string{"HEALTH_PORT": "9002"}, []string{"--config-file", path}, 9002, 20, "staging"},
+		{"flag over file", nil, []string{"--config-file", path, "--batch-size", "40", "--app-env", "prod"}, 9001, 40, "prod"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearConfigEnv(t)
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			cfg, err := LoadConfig(tt.args)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if cfg.HealthPort != tt.wantPort || cfg.BatchSize != tt.wantBatch || cfg.Env != tt.wantAppEnv {
+				t.Errorf("expected port %d, batch size %d, env %s; got %d, %d, %s",
+					tt.wantPort, tt.wantBatch, tt.wantAppEnv, cfg.HealthPort, cfg.BatchSize, cfg.Env)
+			}
+		})
+	}
+}
+
+func TestLoadConfig_ValidateOnly(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv("CONFIG_FILE", writeConfigFile(t, "health_port: 9000\n"))
+	cfg, err := LoadConfig([]string{"--validate-config"})
+	if !errors.Is(err, errValidateOnly) || cfg.HealthPort != 9000 {
+		t.Errorf("expected errValidateOnly with the loaded config, got %v, %+v", err, cfg)
+	}
+
+	t.Setenv("CONFIG_FILE", writeConfigFile(t, "archive_dir: /var/archive\narchive_s3_bucket: logs\n"))
+	if _, err := LoadConfig([]string{"--validate-config"}); err == nil || errors.Is(err, errValidateOnly) {
+		t.Errorf("expected the validation error, got %v", err)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"database/sql"
+	"log/slog"
+	"time"
+)
+
+// dbPoolConfig sizes the connection pool connectWithRetry opens. A zero
+// lifetime or idle time keeps connections indefinitely.
+type dbPoolConfig struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+}
+
+// defaultDBPool keeps the worker to a handful of connections: one per
+// concurrent batch plus the readiness ping and retention purge.
+var defaultDBPool = dbPoolConfig{
+	MaxOpenConns:    5,
+	MaxIdleConns:    5,
+	ConnMaxLifetime: 5 * time.Minute,
+}
+
+// dbPool is applied to every pool connectWithRetry opens and reported by
+// the pool metrics; main sets it from DB_MAX_OPEN_CONNS and friends.
+var dbPool = defaultDBPool
+
+// apply configures d's pool with p.
+func (p dbPoolConfig) apply(d *sql.DB) {
+	d.SetMaxOpenConns(p.MaxOpenConns)
+	d.SetMaxIdleConns(p.MaxIdleConns)
+	d.SetConnMaxLifetime(p.ConnMaxLifetime)
+	d.SetConnMaxIdleTime(p.ConnMaxIdleTime)
+}
+
+func (p dbPoolConfig) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.Int("max_open_conns", p.MaxOpenConns),
+		slog.Int("max_idle_conns", p.MaxIdleConns),
+		slog.String("conn_max_lifetime", p.ConnMaxLifetime.String()),
+		slog.String("conn_max_idle_time", p.ConnMaxIdleTime.String()),
+	)
+}
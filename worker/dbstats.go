@@ -0,0 +1,143 @@
+package main
+
+import (
+	"database/sql"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// dbConnected is set when db is assigned a working pool and cleared when the
+// pool is dropped or a readiness ping fails.
+var dbConnected atomic.Bool
+
+// maxDBErrorLen caps the database error /ready and /healthz report.
+const maxDBErrorLen = 512
+
+// lastDBError is the most recent failed connection attempt or readiness
+// ping, reported by /ready and /healthz until a ping succeeds.
+var lastDBError dbErrorState
+
+// DBError is the last database error in the /ready and /healthz payloads.
+type DBError struct {
+	Error string `json:"error"`
+	At    string `json:"at"`
+}
+
+type dbErrorState struct {
+	mu  sync.Mutex
+	err string
+	at  time.Time
+}
+
+// record keeps err, with DSN passwords masked and cut to maxDBErrorLen, as
+// the latest database error. A nil err, from a successful ping, clears it.
+func (s *dbErrorState) record(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err == nil {
+		s.err, s.at = "", time.Time{}
+		return
+	}
+	s.err, s.at = truncateReport(redactSecrets(err.Error()), maxDBErrorLen), time.Now()
+}
+
+// get returns the latest database error, or nil when there is none.
+func (s *dbErrorState) get() *DBError {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err == "" {
+		return nil
+	}
+	return &DBError{Error: s.err, At: s.at.UTC().Format(time.RFC3339)}
+}
+
+// dbStatsCollector exports connection pool statistics for whichever pool db
+// points at when Prometheus scrapes, plus db_connected. Pool statistics and
+// the dbPool settings are omitted while db is nil.
+type dbStatsCollector struct {
+	connected       *prometheus.Desc
+	openConnections *prometheus.Desc
+	inUse           *prometheus.Desc
+	idle            *prometheus.Desc
+	waitCount       *prometheus.Desc
+	waitDuration    *prometheus.Desc
+	maxOpen         *prometheus.Desc
+	maxIdle         *prometheus.Desc
+	maxLifetime     *prometheus.Desc
+	maxIdleTime     *prometheus.Desc
+}
+
+func newDBStatsCollector() *dbStatsCollector {
+	return &dbStatsCollector{
+		connected:       prometheus.NewDesc("db_connected", "Whether the database connection is up (1) or down (0)", nil, nil),
+		openConnections: prometheus.NewDesc("db_open_connections", "Established connections, in use and idle", nil, nil),
+		inUse:           prometheus.NewDesc("db_in_use", "Connections currently in use", nil, nil),
+		idle:            prometheus.NewDesc("db_idle", "Idle connections", nil, nil),
+		waitCount:       prometheus.NewDesc("db_wait_count", "Total connections waited for", nil, nil),
+		waitDuration:    prometheus.NewDesc("db_wait_duration_seconds_total", "Total time blocked waiting for a connection", nil, nil),
+		maxOpen:         prometheus.NewDesc("db_max_open_connections", "Maximum number of open connections", nil, nil),
+		maxIdle:         prometheus.NewDesc("db_max_idle_connections", "Maximum number of idle connections", nil, nil),
+		maxLifetime:     prometheus.NewDesc("db_conn_max_lifetime_seconds", "Age at which a connection is replaced, 0 if never", nil, nil),
+		maxIdleTime:     prometheus.NewDesc("db_conn_max_idle_time_seconds", "Idle time after which a connection is closed, 0 if never", nil, nil),
+	}
+}
+
+func (c *dbStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.connected
+	ch <- c.openConnections
+	ch <- c.inUse
+	ch <- c.idle
+	ch <- c.waitCount
+	ch <- c.waitDuration
+	ch <- c.maxOpen
+	ch <- c.maxIdle
+	ch <- c.maxLifetime
+	ch <- c.maxIdleTime
+}
+
+func (c *dbStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	connected := 0.0
+	if dbConnected.Load() {
+		connected = 1
+	}
+	ch <- prometheus.MustNewConstMetric(c.connected, prometheus.GaugeValue, connected)
+
+	dbMu.RLock()
+	d := db
+	dbMu.RUnlock()
+	if d == nil {
+		return
+	}
+	s := d.Stats()
+	ch <- prometheus.MustNewConstMetric(c.openConnections, prometheus.GaugeValue, float64(s.OpenConnections))
+	ch <- prometheus.MustNewConstMetric(c.inUse, prometheus.GaugeValue, float64(s.InUse))
+	ch <- prometheus.MustNewConstMetric(c.idle, prometheus.GaugeValue, float64(s.Idle))
+	ch <- prometheus.MustNewConstMetric(c.waitCount, prometheus.CounterValue, float64(s.WaitCount))
+	ch <- prometheus.MustNewConstMetric(c.waitDuration, prometheus.CounterValue, s.WaitDuration.Seconds())
+	ch <- prometheus.MustNewConstMetric(c.maxOpen, prometheus.GaugeValue, float64(s.MaxOpenConnections))
+	ch <- prometheus.MustNewConstMetric(c.maxIdle, prometheus.GaugeValue, float64(dbPool.MaxIdleConns))
+	ch <- prometheus.MustNewConstMetric(c.maxLifetime, prometheus.GaugeValue, dbPool.ConnMaxLifetime.Seconds())
+	ch <- prometheus.MustNewConstMetric(c.maxIdleTime, prometheus.GaugeValue, dbPool.ConnMaxIdleTime.Seconds())
+}
+
+// poolStats is the compact pool summary included in /ready.
+type poolStats struct {
+	Open      int   `json:"open"`
+	InUse     int   `json:"in_use"`
+	Idle      int   `json:"idle"`
+	WaitCount int64 `json:"wait_count"`
+	MaxOpen   int   `json:"max_open"`
+}
+
+func newPoolStats(s sql.DBStats) *poolStats {
+	return &poolStats{
+		Open:      s.OpenConnections,
+		InUse:     s.InUse,
+		Idle:      s.Idle,
+		WaitCount: s.WaitCount,
+		MaxOpen:   s.MaxOpenConnections,
+	}
+}
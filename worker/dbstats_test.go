@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestDBStatsCollector(t *testing.T) {
+	c := newDBStatsCollector()
+
+	dbMu.Lock()
+	db = nil
+	dbMu.Unlock()
+	if n := testutil.CollectAndCount(c); n != 1 {
+		t.Errorf("expected only db_connected without a db, got %d", n)
+	}
+
+	first, _, _ := sqlmock.New()
+	defer func() { _ = first.Close() }()
+	first.SetMaxOpenConns(25)
+	dbMu.Lock()
+	db = first
+	dbMu.Unlock()
+	if n := testutil.CollectAndCount(c); n != 10 {
+		t.Errorf("expected db_connected and 9 pool metrics, got %d", n)
+	}
+
+	// A reconnect swaps the pool; the next scrape must follow it.
+	second, _, _ := sqlmock.New()
+	defer func() { _ = second.Close() }()
+	second.SetMaxOpenConns(10)
+	dbMu.Lock()
+	db = second
+	dbMu.Unlock()
+	want := `
+# HELP db_max_open_connections Maximum number of open connections
+# TYPE db_max_open_connections gauge
+db_max_open_connections 10
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(want), "db_max_open_connections"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDBConnected_FollowsReadinessPing(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	defer func() { _ = mockDB.Close() }()
+	dbMu.Lock()
+	db = mockDB
+	dbMu.Unlock()
+	defer dbConnected.Store(false)
+	c := newDBStatsCollector()
+
+	dbConnected.Store(true)
+	mock.ExpectPing().WillReturnError(errors.New("connection reset"))
+	if err := (dbChecker{}).Check(context.Background()); err == nil {
+		t.Fatal("expected ping failure")
+	}
+	want := `
+# HELP db_connected Whether the database connection is up (1) or down (0)
+# TYPE db_connected gauge
+db_connected 0
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(want), "db_connected"); err != nil {
+		t.Error(err)
+	}
+
+	mock.ExpectPing()
+	if err := (dbChecker{}).Check(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := testutil.CollectAndCompare(c, strings.NewReader(strings.Replace(want, "db_connected 0", "db_connected 1", 1)), "db_connected"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReadyHandler_IncludesPoolStats(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	defer func() { _ = mockDB.Close() }()
+	mockDB.SetMaxOpenConns(25)
+	dbMu.Lock()
+	db = mockDB
+	dbMu.Unlock()
+	mock.ExpectPing()
+
+	rec := httptest.NewRecorder()
+	readyHandler(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+
+	var resp readyResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if resp.DBPool == nil || resp.DBPool.MaxOpen != 25 {
+		t.Errorf("expected db_pool with max_open 25, got %+v", resp.DBPool)
+	}
+}
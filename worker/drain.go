@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// drainProgressEvery is how many batches the startup drain runs between
+// progress log lines.
+const drainProgressEvery = 10
+
+// DrainStats is the startup drain's progress in /stats.
+type DrainStats struct {
+	Active     bool   `json:"active"`
+	Batches    int    `json:"batches"`
+	Rows       int    `json:"rows"`
+	StartedAt  string `json:"started_at,omitempty"`
+	FinishedAt string `json:"finished_at,omitempty"`
+}
+
+// drainState tracks the startup drain. It is guarded by Worker.mu.
+type drainState struct {
+	active     bool
+	batches    int
+	rows       int
+	startedAt  time.Time
+	finishedAt time.Time
+}
+
+// WithStartupDrain makes Run work through the backlog back to back on boot,
+// only pacing by the interval once a batch comes back short.
+func WithStartupDrain(enabled bool) WorkerOption {
+	return func(w *Worker) {
+		w.startupDrain = enabled
+	}
+}
+
+// drainStats returns the startup drain's progress, or nil when the mode is
+// off. Callers must hold w.mu.
+func (w *Worker) drainStats() *DrainStats {
+	if !w.startupDrain {
+		return nil
+	}
+	stats := &DrainStats{
+		Active:  w.drain.active,
+		Batches: w.drain.batches,
+		Rows:    w.drain.rows,
+	}
+	if !w.drain.startedAt.IsZero() {
+		stats.StartedAt = w.drain.startedAt.UTC().Format(time.RFC3339)
+	}
+	if !w.drain.finishedAt.IsZero() {
+		stats.FinishedAt = w.drain.finishedAt.UTC().Format(time.RFC3339)
+	}
+	return stats
+}
+
+// drainAtStartup processes batches with no yield between them, apart from
+// the throughput cap, until a cycle claims fewer rows than it could, a batch
+// fails, or the worker is paused or stopped.
+func (w *Worker) drainAtStartup(ctx context.Context) {
+	full := w.batchSize * max(w.concurrency, 1)
+	w.mu.Lock()
+	w.drain.active = true
+	w.drain.startedAt = w.clock.Now()
+	w.mu.Unlock()
+	w.metrics.drainMode.Set(1)
+	slog.Info("startup drain started", "batch_size", full)
+
+	reason := "caught up"
+	for {
+		if ctx.Err() != nil {
+			reason = "context cancelled"
+			break
+		}
+		if !w.canProcess() {
+			reason = "paused"
+			if dbReconnecting.Load() {
+				reason = "reconnecting"
+			}
+			break
+		}
+		processed, err := w.runBatch(ctx)
+		w.mu.Lock()
+		w.drain.batches++
+		w.drain.rows += processed
+		batches, rows := w.drain.batches, w.drain.rows
+		w.mu.Unlock()
+		if err != nil {
+			reason = "batch failed"
+			if ctx.Err() != nil {
+				reason = "context cancelled"
+			}
+			break
+		}
+		if processed < full {
+			break
+		}
+		if batches%drainProgressEvery == 0 {
+			slog.Info("startup drain progress", "batches", batches, "rows", rows)
+		}
+		if delay := w.throttle(processed); delay > 0 && !w.sleep(ctx, delay) {
+			reason = "context cancelled"
+			break
+		}
+	}
+
+	w.mu.Lock()
+	w.drain.active = false
+	w.drain.finishedAt = w.clock.Now()
+	batches, rows, elapsed := w.drain.batches, w.drain.rows, w.drain.finishedAt.Sub(w.drain.startedAt)
+	w.mu.Unlock()
+	w.metrics.drainMode.Set(0)
+	slog.Info("startup drain completed",
+		"reason", reason,
+		"batches", batches,
+		"rows", rows,
+		"elapsed", elapsed.String(),
+	)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestWorker_StartupDrain(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer func() { _ = mockDB.Close() }()
+	dbMu.Lock()
+	db = mockDB
+	dbMu.Unlock()
+
+	// Full batches run back to back; the short one ends the drain, and the
+	// interval loop takes over with its own batch.
+	expectBatch(mock, 2)
+	expectBatch(mock, 2)
+	expectBatch(mock, 1)
+	expectBatch(mock, 0)
+
+	m, _ := newTestMetrics(t)
+	clock := newFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	w := NewWorker(2*time.Second, WithClock(clock), WithMetrics(m), WithBatchSize(2), WithStartupDrain(true))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Run(ctx)
+
+	// No yield between the drain's batches: the first sleep is the idle one.
+	if d := clock.nextSleep(t); d != 2*time.Second {
+		t.Errorf("expected the idle interval as the first sleep, got %v", d)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled mock: %s", err)
+	}
+	drain := w.Stats().StartupDrain
+	if drain == nil || drain.Active || drain.Batches != 3 || drain.Rows != 5 || drain.FinishedAt == "" {
+		t.Errorf("expected a finished drain of 3 batches and 5 rows, got %+v", drain)
+	}
+	if got := testutil.ToFloat64(m.drainMode); got != 0 {
+		t.Errorf("expected worker_drain_mode 0 after the drain, got %v", got)
+	}
+}
+
+func TestWorker_StartupDrain_Cancelled(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer func() { _ = mockDB.Close() }()
+	dbMu.Lock()
+	db = mockDB
+	dbMu.Unlock()
+
+	expectBatch(mock, 2)
+	mock.ExpectBegin()
+	mock.ExpectQuery(claimQuery).WillDelayFor(time.Minute).WillReturnRows(processedRows(2))
+
+	m, _ := newTestMetrics(t)
+	w := NewWorker(time.Second, WithMetrics(m), WithBatchSize(2), WithStartupDrain(true), WithShutdownGrace(0))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.drainAtStartup(ctx)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for w.Stats().StartupDrain.Batches == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if drain := w.Stats().StartupDrain; !drain.Active {
+		t.Errorf("expected the drain to be active, got %+v", drain)
+	}
+	if got := testutil.ToFloat64(m.drainMode); got != 1 {
+		t.Errorf("expected worker_drain_mode 1 while draining, got %v", got)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected cancellation to interrupt the drain")
+	}
+	if drain := w.Stats().StartupDrain; drain.Active || drain.Rows != 2 {
+		t.Errorf("expected an inactive drain with 2 rows, got %+v", drain)
+	}
+}
+
+func TestWorker_StatsWithoutStartupDrain(t *testing.T) {
+	if drain := NewWorker(time.Second).Stats().StartupDrain; drain != nil {
+		t.Errorf("expected no startup_drain without the mode, got %+v", drain)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// writeDSNFile writes contents to a dsn file in a temporary directory and
+// returns its path.
+func writeDSNFile(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "dsn")
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadDSNFile(t *testing.T) {
+	dsn, err := readDSNFile(writeDSNFile(t, "  postgres://app:pw@db:5432/app\n"))
+	if err != nil || dsn != "postgres://app:pw@db:5432/app" {
+		t.Errorf("expected the trimmed DSN, got %q, %v", dsn, err)
+	}
+	for name, path := range map[string]string{
+		"empty":   writeDSNFile(t, "\n"),
+		"invalid": writeDSNFile(t, "postgres://app:pw@db:notaport/app"),
+		"missing": filepath.Join(t.TempDir(), "missing"),
+	} {
+		if _, err := readDSNFile(path); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestLoadConfig_DSNFile(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv("DB_DSN", "postgres://app:old@db:5432/app")
+	t.Setenv("DB_DSN_FILE", writeDSNFile(t, "postgres://app:new@db:5432/app\n"))
+	cfg, err := LoadConfig(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.DSN != "postgres://app:new@db:5432/app" {
+		t.Errorf("expected DB_DSN_FILE to win over DB_DSN, got %q", cfg.DSN)
+	}
+
+	// A passed flag still wins over the file.
+	cfg, err = LoadConfig([]string{"--db-dsn", "postgres://app:flag@db:5432/app"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.DSN != "postgres://app:flag@db:5432/app" {
+		t.Errorf("expected --db-dsn to win over DB_DSN_FILE, got %q", cfg.DSN)
+	}
+
+	t.Setenv("DB_DSN_FILE", filepath.Join(t.TempDir(), "missing"))
+	if _, err := LoadConfig(nil); err == nil || !strings.Contains(err.Error(), "DB_DSN_FILE:") {
+		t.Errorf("expected a missing file to fail startup, got %v", err)
+	}
+}
+
+func TestDSNSource_SwitchTo(t *testing.T) {
+	resetLogSecrets(t)
+	old, oldMock, _ := sqlmock.New()
+	next, _, _ := sqlmock.New()
+	defer func() { _ = next.Close() }()
+	dbMu.Lock()
+	db = old
+	dbMu.Unlock()
+	defer func() {
+		dbMu.Lock()
+		db = nil
+		dbMu.Unlock()
+	}()
+
+	var connected []string
+	src := &dsnSource{dsn: "postgres://app:first@db:5432/app", connect: func(dsn string) (*sql.DB, error) {
+		connected = append(connected, dsn)
+		if strings.Contains(dsn, "unreachable") {
+			return nil, errors.New("connection refused")
+		}
+		return next, nil
+	}}
+
+	if err := src.switchTo("postgres://app:first@db:5432/app"); err != nil || len(connected) != 0 {
+		t.Fatalf("expected an unchanged DSN to keep the pool, got %v after %v", err, connected)
+	}
+
+	if err := src.switchTo("postgres://app:unreachable@db:5432/app"); err == nil {
+		t.Fatal("expected the failed connection to be reported")
+	}
+	dbMu.RLock()
+	kept := db
+	dbMu.RUnlock()
+	if kept != old || src.current() != "postgres://app:first@db:5432/app" {
+		t.Error("expected the old pool and DSN to be kept when the new DSN can't connect")
+	}
+
+	oldMock.ExpectClose()
+	if err := src.switchTo("postgres://app:second@db:5432/app"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	dbMu.RLock()
+	swapped := db
+	dbMu.RUnlock()
+	if swapped != next || src.current() != "postgres://app:second@db:5432/app" {
+		t.Error("expected the new pool and DSN to be in use")
+	}
+	if err := oldMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expected the old pool to be closed: %v", err)
+	}
+	if got := redactSecrets("second"); got != redacted {
+		t.Errorf("expected the new password to be masked in logs, got %q", got)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// errorReportQueueSize bounds the reports waiting for delivery; more are
+	// dropped rather than held.
+	errorReportQueueSize = 64
+	// errorReportTimeout bounds a single delivery.
+	errorReportTimeout = 5 * time.Second
+	// errorReportBurst reports may go out at once, then one per
+	// errorReportEvery, so an outage sends a sample instead of a flood.
+	errorReportBurst = 10
+	errorReportEvery = 6 * time.Second
+
+	maxReportErrorLen = 2048
+	maxReportStackLen = 8192
+)
+
+// Outcomes counted in error_reports_total.
+const (
+	reportSent        = "sent"
+	reportFailed      = "failed"
+	reportRateLimited = "rate_limited"
+	reportQueueFull   = "queue_full"
+)
+
+// ErrorReport describes one server-side failure. The reporter fills in the
+// service, version, env and time.
+type ErrorReport struct {
+	Service   string         `json:"service"`
+	Version   string         `json:"version"`
+	Env       string         `json:"env"`
+	Time      string         `json:"time"`
+	Message   string         `json:"message"`
+	Error     string         `json:"error,omitempty"`
+	Stack     string         `json:"stack,omitempty"`
+	RequestID string         `json:"request_id,omitempty"`
+	Details   map[string]any `json:"details,omitempty"`
+}
+
+// ErrorReporter sends error reports somewhere a person will see them.
+// Report must never block its caller.
+type ErrorReporter interface {
+	Report(r ErrorReport)
+}
+
+// noopReporter drops every report; it is used while ERROR_WEBHOOK_URL is
+// unset.
+type noopReporter struct{}
+
+func (noopReporter) Report(ErrorReport) {}
+
+// errorReporter receives the service's error reports; main replaces it when
+// ERROR_WEBHOOK_URL is set.
+var errorReporter ErrorReporter = noopReporter{}
+
+// webhookReporter POSTs each report as JSON to a webhook from a goroutine
+// of its own, behind a small queue and a rate limit. Reports that don't fit
+// are dropped and counted; failed deliveries are logged and counted but
+// never retried.
+type webhookReporter struct {
+	url      string
+	service  string
+	env      string
+	client   *http.Client
+	limiter  *rate.Limiter
+	outcomes *prometheus.CounterVec
+
+	queue chan ErrorReport
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// newWebhookReporter creates a reporter that delivers reports for service in
+// env to url once run is started. outcomes counts each report under its
+// outcome label.
+func newWebhookReporter(url, service, env string, outcomes *prometheus.CounterVec) *webhookReporter {
+	return &webhookReporter{
+		url:      url,
+		service:  service,
+		env:      env,
+		client:   &http.Client{Timeout: errorReportTimeout},
+		limiter:  rate.NewLimiter(rate.Every(errorReportEvery), errorReportBurst),
+		outcomes: outcomes,
+		queue:    make(chan ErrorReport, errorReportQueueSize),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Report queues rep for delivery, or drops it when over the rate limit or
+// the queue is full.
+func (r *webhookReporter) Report(rep ErrorReport) {
+	if !r.limiter.Allow() {
+		r.outcomes.WithLabelValues(reportRateLimited).Inc()
+		return
+	}
+	rep.Service, rep.Version, rep.Env = r.service, version, r.env
+	rep.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	rep.Error = truncateReport(rep.Error, maxReportErrorLen)
+	rep.Stack = truncateReport(rep.Stack, maxReportStackLen)
+	select {
+	case r.queue <- rep:
+	default:
+		r.outcomes.WithLabelValues(reportQueueFull).Inc()
+	}
+}
+
+// run delivers queued reports one at a time until Close.
+func (r *webhookReporter) run() {
+	defer close(r.done)
+	for {
+		select {
+		case rep := <-r.queue:
+			r.deliver(rep)
+		case <-r.stop:
+			// Deliver what was queued before the stop, then exit.
+			for {
+				select {
+				case rep := <-r.queue:
+					r.deliver(rep)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// Close delivers the reports still queued and stops the reporter, giving up
+// when ctx is done. Reports made afterwards are never delivered.
+func (r *webhookReporter) Close(ctx context.Context) error {
+	close(r.stop)
+	select {
+	case <-r.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *webhookReporter) deliver(rep ErrorReport) {
+	if err := r.post(rep); err != nil {
+		r.outcomes.WithLabelValues(reportFailed).Inc()
+		slog.Warn("error report delivery failed", "error", err)
+		return
+	}
+	r.outcomes.WithLabelValues(reportSent).Inc()
+}
+
+func (r *webhookReporter) post(rep ErrorReport) error {
+	body, err := json.Marshal(rep)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), errorReportTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// truncateReport cuts s to at most n bytes, marking the cut.
+func truncateReport(s string, n int) string {
+	const marker = "... (truncated)"
+	if len(s) <= n {
+		return s
+	}
+	return s[:n-len(marker)] + marker
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"golang.org/x/time/rate"
+)
+
+// reportReceiver is a webhook that records the reports posted to it and
+// answers with status.
+type reportReceiver struct {
+	status int
+
+	mu      sync.Mutex
+	reports []ErrorReport
+}
+
+func (rr *reportReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var rep ErrorReport
+	if err := json.NewDecoder(r.Body).Decode(&rep); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rr.mu.Lock()
+	rr.reports = append(rr.reports, rep)
+	rr.mu.Unlock()
+	w.WriteHeader(rr.status)
+}
+
+func newTestReporter(t *testing.T, url string) (*webhookReporter, *prometheus.CounterVec) {
+	t.Helper()
+	outcomes := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "error_reports_total"}, []string{"outcome"})
+	return newWebhookReporter(url, "worker", "test", outcomes), outcomes
+}
+
+func TestWebhookReporter_Delivers(t *testing.T) {
+	receiver := &reportReceiver{status: http.StatusOK}
+	srv := httptest.NewServer(receiver)
+	defer srv.Close()
+	r, outcomes := newTestReporter(t, srv.URL)
+	go r.run()
+
+	r.Report(ErrorReport{Message: "batches failing repeatedly", Error: strings.Repeat("e", maxReportErrorLen+1)})
+	if err := r.Close(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	receiver.mu.Lock()
+	defer receiver.mu.Unlock()
+	if len(receiver.reports) != 1 {
+		t.Fatalf("expected 1 report, got %d", len(receiver.reports))
+	}
+	rep := receiver.reports[0]
+	if rep.Service != "worker" || rep.Env != "test" || rep.Version != version || rep.Time == "" {
+		t.Errorf("expected service, version, env and time on the report, got %+v", rep)
+	}
+	if len(rep.Error) != maxReportErrorLen || !strings.HasSuffix(rep.Error, "(truncated)") {
+		t.Errorf("expected the error cut to %d bytes, got %d", maxReportErrorLen, len(rep.Error))
+	}
+	if got := testutil.ToFloat64(outcomes.WithLabelValues(reportSent)); got != 1 {
+		t.Errorf("expected 1 sent report, got %v", got)
+	}
+}
+
+func TestWebhookReporter_Drops(t *testing.T) {
+	srv := httptest.NewServer(&reportReceiver{status: http.StatusOK})
+	defer srv.Close()
+	r, outcomes := newTestReporter(t, srv.URL)
+
+	// Nothing is delivered until run starts, so the burst sits in the queue
+	// and the rate limit stops the rest.
+	for range errorReportBurst + 1 {
+		r.Report(ErrorReport{Message: "batches failing repeatedly"})
+	}
+	if got := testutil.ToFloat64(outcomes.WithLabelValues(reportRateLimited)); got != 1 {
+		t.Errorf("expected 1 rate-limited report, got %v", got)
+	}
+	r.limiter.SetLimit(rate.Inf)
+	for range errorReportQueueSize {
+		r.Report(ErrorReport{Message: "batches failing repeatedly"})
+	}
+	if got := testutil.ToFloat64(outcomes.WithLabelValues(reportQueueFull)); got != errorReportBurst {
+		t.Errorf("expected %d reports dropped on a full queue, got %v", errorReportBurst, got)
+	}
+}
+
+func TestWebhookReporter_Failures(t *testing.T) {
+	srv := httptest.NewServer(&reportReceiver{status: http.StatusInternalServerError})
+	defer srv.Close()
+	r, outcomes := newTestReporter(t, srv.URL)
+	go r.run()
+	var buf bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	defer slog.SetDefault(prev)
+
+	r.Report(ErrorReport{Message: "batches failing repeatedly"})
+	if err := r.Close(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := testutil.ToFloat64(outcomes.WithLabelValues(reportFailed)); got != 1 {
+		t.Errorf("expected 1 failed report, got %v", got)
+	}
+	if !strings.Contains(buf.String(), "error report delivery failed") {
+		t.Errorf("expected the failure to be logged, got %s", buf.String())
+	}
+}
+
+// recordingReporter keeps the reports made while it is errorReporter.
+type recordingReporter struct {
+	mu      sync.Mutex
+	reports []ErrorReport
+}
+
+func (rr *recordingReporter) Report(rep ErrorReport) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	rr.reports = append(rr.reports, rep)
+}
+
+func TestRunBatch_ReportsAtThreshold(t *testing.T) {
+	rr := &recordingReporter{}
+	prev := errorReporter
+	errorReporter = rr
+	defer func() { errorReporter = prev }()
+	mockDB, mock, _ := sqlmock.New()
+	defer func() { _ = mockDB.Close() }()
+	dbMu.Lock()
+	db = mockDB
+	dbMu.Unlock()
+
+	w := NewWorker(time.Second, WithErrorReportThreshold(3))
+	fail := func(n int) {
+		for range n {
+			expectBatchError(mock, errors.New("relation does not exist"))
+			_, _ = w.runBatch(context.Background())
+		}
+	}
+
+	fail(2)
+	if len(rr.reports) != 0 {
+		t.Fatalf("expected no report below the threshold, got %+v", rr.reports)
+	}
+	fail(3)
+	if len(rr.reports) != 1 {
+		t.Fatalf("expected one report for the whole streak, got %+v", rr.reports)
+	}
+	rep := rr.reports[0]
+	if rep.Message != "batches failing repeatedly" || !strings.Contains(rep.Error, "relation does not exist") || rep.Details["consecutive_errors"] != 3 {
+		t.Errorf("unexpected report %+v", rep)
+	}
+
+	// A successful batch ends the streak, so the next one is reported too.
+	expectBatch(mock, 1)
+	if _, err := w.runBatch(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	fail(3)
+	if len(rr.reports) != 2 {
+		t.Errorf("expected a second report after the streak restarted, got %d", len(rr.reports))
+	}
+}
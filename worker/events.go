@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Lifecycle events recorded in service_events. A shutdown without a
+// forced_shutdown after it was clean.
+const (
+	eventStarted        = "started"
+	eventShutdown       = "shutdown"
+	eventForcedShutdown = "forced_shutdown"
+	eventDBReconnected  = "db_reconnected"
+	eventPaused         = "paused"
+	eventResumed        = "resumed"
+)
+
+// Outcomes counted in service_events_total.
+const (
+	eventWritten = "written"
+	eventFailed  = "failed"
+	eventDropped = "dropped"
+)
+
+const (
+	// eventQueueSize bounds the events waiting to be written; more are
+	// dropped so recording one never blocks.
+	eventQueueSize = 64
+	// eventWriteTimeout bounds a single insert.
+	eventWriteTimeout = 5 * time.Second
+)
+
+const insertEventSQL = `
+	INSERT INTO service_events (service, event, detail, created_at)
+	VALUES ($1, $2, $3::jsonb, $4)
+`
+
+// eventRecorder is the recorder recordEvent queues events on; nil until
+// main starts one.
+var eventRecorder atomic.Pointer[EventRecorder]
+
+// recordEvent queues event, with detail as its JSON detail, on the running
+// EventRecorder. Without one the event is dropped.
+func recordEvent(event string, detail map[string]any) {
+	eventRecorder.Load().Record(event, detail)
+}
+
+// serviceEvent is one service_events row waiting to be written. detail is
+// a JSON object.
+type serviceEvent struct {
+	event  string
+	detail []byte
+	at     time.Time
+}
+
+// EventRecorder writes lifecycle events to service_events one at a time
+// from a goroutine of its own, so the step recording an event never waits
+// on the database. An event that doesn't fit in the queue, or arrives while
+// there is no database, is dropped; every outcome is counted.
+type EventRecorder struct {
+	service  string
+	outcomes *prometheus.CounterVec
+
+	queue chan serviceEvent
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// NewEventRecorder creates a recorder that writes events for service once
+// run is started. outcomes counts each event under its outcome label.
+func NewEventRecorder(service string, outcomes *prometheus.CounterVec) *EventRecorder {
+	return &EventRecorder{
+		service:  service,
+		outcomes: outcomes,
+		queue:    make(chan serviceEvent, eventQueueSize),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Record queues event, or drops it when the queue is full. A nil recorder
+// drops everything.
+func (r *EventRecorder) Record(event string, detail map[string]any) {
+	if r == nil {
+		return
+	}
+	b, err := json.Marshal(detail)
+	if err != nil || detail == nil {
+		b = []byte("{}")
+	}
+	select {
+	case r.queue <- serviceEvent{event: event, detail: b, at: time.Now()}:
+	default:
+		r.outcomes.WithLabelValues(eventDropped).Inc()
+	}
+}
+
+// run writes queued events one at a time until Close.
+func (r *EventRecorder) run() {
+	defer close(r.done)
+	for {
+		select {
+		case e := <-r.queue:
+			r.write(e)
+		case <-r.stop:
+			// Write what was queued before the stop, then exit.
+			for {
+				select {
+				case e := <-r.queue:
+					r.write(e)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// Close writes the events still queued and stops the recorder, giving up
+// when ctx is done.
+func (r *EventRecorder) Close(ctx context.Context) error {
+	close(r.stop)
+	select {
+	case <-r.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// write inserts e through the current pool.
+func (r *EventRecorder) write(e serviceEvent) {
+	dbMu.RLock()
+	d := db
+	dbMu.RUnlock()
+	if d == nil {
+		r.outcomes.WithLabelValues(eventDropped).Inc()
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), eventWriteTimeout)
+	defer cancel()
+	if _, err := d.ExecContext(ctx, insertEventSQL, r.service, e.event, string(e.detail), e.at.UTC()); err != nil {
+		r.outcomes.WithLabelValues(eventFailed).Inc()
+		slog.Error("failed to record service event", "event", e.event, "error", err)
+		return
+	}
+	r.outcomes.WithLabelValues(eventWritten).Inc()
+}
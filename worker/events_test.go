@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"log/slog"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// newTestRecorder creates an EventRecorder that recordEvent reaches, writing
+// to a mock database when withDB is set.
+func newTestRecorder(t *testing.T, withDB bool) (*EventRecorder, *metrics, sqlmock.Sqlmock) {
+	t.Helper()
+	var mock sqlmock.Sqlmock
+	if withDB {
+		var d *sql.DB
+		d, mock, _ = sqlmock.New()
+		dbMu.Lock()
+		db = d
+		dbMu.Unlock()
+		t.Cleanup(func() {
+			dbMu.Lock()
+			db = nil
+			dbMu.Unlock()
+			_ = d.Close()
+		})
+	}
+	m, _ := newTestMetrics(t)
+	r := NewEventRecorder("worker", m.serviceEvents)
+	prev := eventRecorder.Swap(r)
+	t.Cleanup(func() { eventRecorder.Store(prev) })
+	return r, m, mock
+}
+
+func expectEvent(mock sqlmock.Sqlmock, event, detail string) {
+	mock.ExpectExec("INSERT INTO service_events").
+		WithArgs("worker", event, detail, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+}
+
+func TestEventRecorder_StartupShutdownCycle(t *testing.T) {
+	prevLog := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(io.Discard, nil)))
+	defer slog.SetDefault(prevLog)
+	r, m, mock := newTestRecorder(t, true)
+	go r.run()
+
+	expectEvent(mock, eventStarted, `{"commit":"unknown","env":"test","version":"dev"}`)
+	expectEvent(mock, eventPaused, `{}`)
+	expectEvent(mock, eventResumed, `{}`)
+	expectEvent(mock, eventShutdown, `{"signal":"terminated"}`)
+	expectEvent(mock, eventForcedShutdown, `{"error":"context deadline exceeded","phase":"loops"}`)
+
+	// As main does once the recorder is running.
+	recordEvent(eventStarted, map[string]any{"version": version, "commit": commit, "env": "test"})
+	w := NewWorker(time.Second)
+	w.Pause()
+	w.Pause() // already paused: nothing recorded
+	w.Resume()
+
+	health := httptest.NewServer(nil)
+	defer health.Close()
+	release := make(chan struct{})
+	defer close(release)
+	var loops sync.WaitGroup
+	loops.Go(func() { <-release }) // a batch that ignores the stop
+	quit := make(chan os.Signal, 1)
+	quit <- syscall.SIGTERM
+	shutdownSequence{
+		timeout:      shutdownReserve + 100*time.Millisecond,
+		stopLoops:    func() {},
+		loops:        &loops,
+		healthServer: health.Config,
+		closeEvents:  r.Close,
+	}.run(quit)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+	if got := testutil.ToFloat64(m.serviceEvents.WithLabelValues(eventWritten)); got != 5 {
+		t.Errorf("expected 5 events written, got %v", got)
+	}
+}
+
+func TestEventRecorder_NeverBlocks(t *testing.T) {
+	r, m, _ := newTestRecorder(t, false)
+	// Not running, so nothing drains the queue.
+	start := time.Now()
+	for range eventQueueSize + 10 {
+		r.Record(eventDBReconnected, nil)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected recording to return at once, took %v", elapsed)
+	}
+	if got := testutil.ToFloat64(m.serviceEvents.WithLabelValues(eventDropped)); got != 10 {
+		t.Errorf("expected the events past the queue dropped, got %v", got)
+	}
+}
+
+func TestEventRecorder_NoDatabase(t *testing.T) {
+	r, m, _ := newTestRecorder(t, false)
+	go r.run()
+	recordEvent(eventStarted, nil)
+	if err := r.Close(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := testutil.ToFloat64(m.serviceEvents.WithLabelValues(eventDropped)); got != 1 {
+		t.Errorf("expected the event dropped without a database, got %v", got)
+	}
+}
+
+func TestRecordEvent_WithoutRecorder(t *testing.T) {
+	prev := eventRecorder.Swap(nil)
+	defer eventRecorder.Store(prev)
+	recordEvent(eventStarted, nil) // must not panic
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// defaultShutdownGrace is how long shutdown waits for the batch in flight.
+const defaultShutdownGrace = 20 * time.Second
+
+// errShutdownGraceExceeded cancels a batch still running
+// WORKER_SHUTDOWN_GRACE after shutdown began.
+var errShutdownGraceExceeded = errors.New("shutdown grace exceeded")
+
+// How the batch in flight when shutdown began ended, as reported in the
+// final "worker stopping" line. Only handoffGraceExceeded is unclean: its
+// statement was cancelled under it, and the rows it held stay locked until
+// the server notices.
+const (
+	handoffIdle          = "idle"
+	handoffCommitted     = "committed"
+	handoffRolledBack    = "rolled_back"
+	handoffGraceExceeded = "grace_exceeded"
+)
+
+// WithShutdownGrace sets how long a batch in flight at shutdown may take to
+// commit or roll back before it is cancelled. Zero cancels it at once.
+func WithShutdownGrace(d time.Duration) WorkerOption {
+	return func(w *Worker) {
+		if d >= 0 {
+			w.shutdownGrace = d
+		}
+	}
+}
+
+// batchContext returns the context a batch runs under. It keeps ctx's
+// values but not its cancellation, so shutdown doesn't tear the batch's
+// statements down mid-flight: once ctx is done the batch has
+// w.shutdownGrace to finish before it is cancelled with
+// errShutdownGraceExceeded. release frees the context and reports whether
+// ctx was cancelled while the batch ran.
+func (w *Worker) batchContext(ctx context.Context) (bctx context.Context, release func() bool) {
+	bctx, cancel := context.WithCancelCause(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(ctx, func() {
+		timer := time.AfterFunc(w.shutdownGrace, func() { cancel(errShutdownGraceExceeded) })
+		context.AfterFunc(bctx, func() { timer.Stop() })
+	})
+	return bctx, func() bool {
+		interrupted := !stop()
+		cancel(nil)
+		return interrupted
+	}
+}
+
+// handoffOutcome is how a batch that shutdown interrupted ended, given the
+// context it ran under and its error.
+func handoffOutcome(bctx context.Context, err error) string {
+	switch {
+	case err == nil:
+		return handoffCommitted
+	case errors.Is(context.Cause(bctx), errShutdownGraceExceeded):
+		return handoffGraceExceeded
+	default:
+		return handoffRolledBack
+	}
+}
+
+// logStopping writes Run's final line, with how the batch in flight at
+// shutdown ended, and sets worker_shutdown_clean to match.
+func (w *Worker) logStopping() {
+	w.mu.RLock()
+	outcome := w.handoff
+	w.mu.RUnlock()
+	if outcome == "" {
+		outcome = handoffIdle
+	}
+	clean := outcome != handoffGraceExceeded
+	level := slog.LevelInfo
+	if clean {
+		w.metrics.shutdownClean.Set(1)
+	} else {
+		w.metrics.shutdownClean.Set(0)
+		level = slog.LevelWarn
+	}
+	slog.Log(context.Background(), level, "worker stopping",
+		"reason", "context cancelled",
+		"batch", outcome,
+		"clean", clean,
+		"grace", w.shutdownGrace.String(),
+	)
+}
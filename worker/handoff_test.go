@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// stopDuringBatch runs w, cancels it once its first batch is in flight and
+// returns how long Run took to return after that, with what it logged.
+func stopDuringBatch(t *testing.T, w *Worker) (time.Duration, string) {
+	t.Helper()
+	var buf bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	defer slog.SetDefault(prev)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		w.Run(ctx)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for !w.inFlight.Load() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if !w.inFlight.Load() {
+		t.Fatal("expected a batch in flight")
+	}
+	cancel()
+	start := time.Now()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected Run to return after cancellation")
+	}
+	return time.Since(start), buf.String()
+}
+
+func TestWorker_ShutdownWaitsForBatch(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer func() { _ = mockDB.Close() }()
+	dbMu.Lock()
+	db = mockDB
+	dbMu.Unlock()
+	m, _ := newTestMetrics(t)
+
+	// The claim outlasts the cancellation; the batch still commits, and
+	// nothing more is claimed after it.
+	mock.ExpectBegin()
+	mock.ExpectQuery(claimQuery).WillDelayFor(200 * time.Millisecond).WillReturnRows(processedRows(3))
+	expectMark(mock, 3)
+
+	w := NewWorker(time.Second, WithMetrics(m), WithShutdownGrace(5*time.Second))
+	elapsed, logs := stopDuringBatch(t, w)
+	if elapsed < 100*time.Millisecond {
+		t.Errorf("expected Run to wait for the batch, returned after %v", elapsed)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled mock: %s", err)
+	}
+	if got := testutil.ToFloat64(m.logsProcessed); got != 3 {
+		t.Errorf("expected the batch's 3 rows counted, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.shutdownClean); got != 1 {
+		t.Errorf("expected worker_shutdown_clean 1, got %v", got)
+	}
+	if !strings.Contains(logs, `"msg":"worker stopping"`) || !strings.Contains(logs, `"batch":"committed","clean":true`) {
+		t.Errorf("expected a clean stop after the committed batch, got %s", logs)
+	}
+}
+
+func TestWorker_ShutdownGraceExceeded(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer func() { _ = mockDB.Close() }()
+	dbMu.Lock()
+	db = mockDB
+	dbMu.Unlock()
+	m, _ := newTestMetrics(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(claimQuery).WillDelayFor(5 * time.Second).WillReturnRows(processedRows(3))
+	mock.ExpectRollback()
+
+	w := NewWorker(time.Second, WithMetrics(m), WithShutdownGrace(100*time.Millisecond))
+	elapsed, logs := stopDuringBatch(t, w)
+	if elapsed < 100*time.Millisecond || elapsed > time.Second {
+		t.Errorf("expected Run to return once the 100ms grace ran out, took %v", elapsed)
+	}
+	if got := testutil.ToFloat64(m.logsProcessed); got != 0 {
+		t.Errorf("expected no rows counted, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.shutdownClean); got != 0 {
+		t.Errorf("expected worker_shutdown_clean 0, got %v", got)
+	}
+	if !strings.Contains(logs, `"level":"WARN","msg":"worker stopping"`) || !strings.Contains(logs, `"batch":"grace_exceeded","clean":false`) {
+		t.Errorf("expected an unclean stop after the grace ran out, got %s", logs)
+	}
+	if !strings.Contains(logs, `"msg":"batch cancelled","reason":"shutdown grace exceeded"`) {
+		t.Errorf("expected the cancelled batch logged with the grace as its reason, got %s", logs)
+	}
+}
+
+func TestWorker_ShutdownIdle(t *testing.T) {
+	m, _ := newTestMetrics(t)
+	w := NewWorker(time.Second, WithMetrics(m))
+	w.logStopping()
+	if got := testutil.ToFloat64(m.shutdownClean); got != 1 {
+		t.Errorf("expected worker_shutdown_clean 1 with no batch in flight, got %v", got)
+	}
+}
+
+func TestRunBatch_ClaimsNothingAfterCancel(t *testing.T) {
+	mockDB, _, _ := sqlmock.New()
+	defer func() { _ = mockDB.Close() }()
+	dbMu.Lock()
+	db = mockDB
+	dbMu.Unlock()
+
+	w := NewWorker(time.Second)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := w.runBatch(ctx); err == nil {
+		t.Error("expected runBatch to refuse a cancelled context")
+	}
+	if !w.LastRunAt().IsZero() {
+		t.Error("expected no batch recorded after cancellation")
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// healthzStatusCodes maps the overall readiness status to the /healthz HTTP
+// status; main applies HEALTHZ_DEGRADED_STATUS and HEALTHZ_ERROR_STATUS.
+var healthzStatusCodes = map[string]int{
+	"ready":    http.StatusOK,
+	"degraded": http.StatusOK,
+	"error":    http.StatusServiceUnavailable,
+}
+
+// HealthzResponse is the single document served by /healthz for external
+// monitors that can only poll one URL.
+type HealthzResponse struct {
+	Status        string        `json:"status"`
+	Live          string        `json:"live"`
+	Service       string        `json:"service"`
+	Version       string        `json:"version"`
+	StartedAt     string        `json:"started_at"`
+	Uptime        string        `json:"uptime"`
+	UptimeSeconds float64       `json:"uptime_seconds"`
+	Readiness     readyResponse `json:"readiness"`
+	Worker        workerSummary `json:"worker"`
+}
+
+// workerSummary is the worker's run state plus the number of rows still
+// waiting to be processed, omitted when it can't be counted.
+type workerSummary struct {
+	WorkerStats
+	Backlog *int64 `json:"backlog,omitempty"`
+}
+
+// newHealthzResponse builds the /healthz document for a process that started
+// at startedAt.
+func newHealthzResponse(res readyResult, startedAt time.Time) HealthzResponse {
+	uptime := time.Since(startedAt).Truncate(time.Second)
+	return HealthzResponse{
+		Status:        res.status,
+		Live:          "ok",
+		Service:       serviceName,
+		Version:       version,
+		StartedAt:     startedAt.UTC().Format(time.RFC3339),
+		Uptime:        uptime.String(),
+		UptimeSeconds: uptime.Seconds(),
+		Readiness:     res.response(),
+	}
+}
+
+func healthzHandler(worker *Worker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		res := readiness.get(r.Context(), checkReady)
+		resp := newHealthzResponse(res, worker.startedAt)
+		resp.Worker = workerSummary{WorkerStats: worker.Stats(), Backlog: countBacklog(r.Context())}
+		if resp.Worker.Backlog != nil {
+			worker.metrics.setBacklog(*resp.Worker.Backlog)
+		}
+		writeJSON(w, healthzStatusCodes[res.status], resp)
+	}
+}
+
+// countBacklog returns the number of unprocessed rows, bounded by
+// readyPingTimeout, or nil when there is no database or the query fails.
+func countBacklog(ctx context.Context) *int64 {
+	dbMu.RLock()
+	d := db
+	dbMu.RUnlock()
+	if d == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, readyPingTimeout)
+	defer cancel()
+	var n int64
+	if err := d.QueryRowContext(ctx, `SELECT count(*) FROM api_logs WHERE processed_at IS NULL`).Scan(&n); err != nil {
+		slog.Warn("failed to count backlog", "error", err)
+		return nil
+	}
+	return &n
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func serveHealthz(t *testing.T) (int, HealthzResponse) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	healthzHandler(NewWorker(time.Second))(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	var resp HealthzResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	return rec.Code, resp
+}
+
+func TestHealthzHandler_StatusMapping(t *testing.T) {
+	prevChecks := readyChecks
+	defer func() { readyChecks = prevChecks }()
+
+	tests := []struct {
+		name       string
+		checks     []readinessCheck
+		wantCode   int
+		wantStatus string
+	}{
+		{"healthy", []readinessCheck{{stubChecker{"a", nil}, true}}, http.StatusOK, "ready"},
+		{"degraded", []readinessCheck{{stubChecker{"a", nil}, true}, {stubChecker{"b", errors.New("boom")}, false}}, http.StatusOK, "degraded"},
+		{"unhealthy", []readinessCheck{{stubChecker{"a", errors.New("boom")}, true}}, http.StatusServiceUnavailable, "error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			readyChecks = tt.checks
+			code, resp := serveHealthz(t)
+			if code != tt.wantCode || resp.Status != tt.wantStatus {
+				t.Errorf("expected %d %q, got %d %q", tt.wantCode, tt.wantStatus, code, resp.Status)
+			}
+			if resp.Live != "ok" || resp.Service != serviceName || resp.Version != version {
+				t.Errorf("unexpected liveness fields %+v", resp)
+			}
+			if len(resp.Readiness.Checks) != len(tt.checks) {
+				t.Errorf("expected %d checks, got %+v", len(tt.checks), resp.Readiness.Checks)
+			}
+		})
+	}
+}
+
+func TestHealthzHandler_WorkerSummary(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	defer func() { _ = mockDB.Close() }()
+	dbMu.Lock()
+	db = mockDB
+	dbMu.Unlock()
+	mock.ExpectPing()
+	mock.ExpectQuery("SELECT count").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(42))
+
+	code, resp := serveHealthz(t)
+	if code != http.StatusOK {
+		t.Errorf("expected 200, got %d", code)
+	}
+	if resp.Worker.Interval != "1s" {
+		t.Errorf("expected worker stats in the summary, got %+v", resp.Worker)
+	}
+	if resp.Worker.Backlog == nil || *resp.Worker.Backlog != 42 {
+		t.Errorf("expected backlog 42, got %v", resp.Worker.Backlog)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled mock: %s", err)
+	}
+}
+
+func TestNewHealthzResponse_Uptime(t *testing.T) {
+	startedAt := time.Now().Add(-90*time.Second - 400*time.Millisecond)
+	resp := newHealthzResponse(readyResult{status: "ready"}, startedAt)
+	if resp.Uptime != "1m30s" || resp.UptimeSeconds != 90 {
+		t.Errorf("expected uptime 1m30s / 90, got %q / %v", resp.Uptime, resp.UptimeSeconds)
+	}
+	if want := startedAt.UTC().Format(time.RFC3339); resp.StartedAt != want {
+		t.Errorf("expected started_at %s, got %s", want, resp.StartedAt)
+	}
+}
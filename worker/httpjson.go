@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+// Error codes carried in the code field of errorResponse, so clients can
+// branch on the kind of failure without parsing the message.
+const (
+	codeInvalidRequest   = "invalid_request"
+	codeUnauthorized     = "unauthorized"
+	codeForbidden        = "forbidden"
+	codeMethodNotAllowed = "method_not_allowed"
+	codeConflict         = "conflict"
+	codeInternal         = "internal_error"
+	codeUnavailable      = "unavailable"
+)
+
+// statusResponse is a body that only reports a status, such as /startup's.
+type statusResponse struct {
+	Status string `json:"status"`
+}
+
+// errorResponse is the JSON body of every error reply.
+type errorResponse struct {
+	Status  string `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// writeJSON writes v as the JSON body of a status response. A failed write
+// means the client has gone, so it is only logged.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error(errWriteResponse, "error", err)
+	}
+}
+
+// writeError writes {"status":"error","code":code,"message":message} with
+// status.
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, errorResponse{Status: "error", Code: code, Message: message})
+}
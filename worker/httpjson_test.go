@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestWriteJSON(t *testing.T) {
+	rec := httptest.NewRecorder()
+	writeJSON(rec, http.StatusOK, pauseResponse{Status: "ok", Paused: true})
+
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected Content-Type application/json, got %q", ct)
+	}
+	if got := rec.Body.String(); got != "{\"status\":\"ok\",\"paused\":true}\n" {
+		t.Errorf("unexpected body %q", got)
+	}
+}
+
+func TestWriteJSON_LogsWriteFailureOnce(t *testing.T) {
+	var buf bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	defer slog.SetDefault(prev)
+
+	writeJSON(&errorResponseWriter{ResponseWriter: httptest.NewRecorder()}, http.StatusOK, statusResponse{Status: "ok"})
+	if n := strings.Count(buf.String(), errWriteResponse); n != 1 {
+		t.Errorf("expected the write failure to be logged once, got %d times: %s", n, buf.String())
+	}
+}
+
+func TestErrorEnvelope(t *testing.T) {
+	metricsAuth = metricsCredentials{token: "metrics-token"}
+	defer func() { metricsAuth = metricsCredentials{} }()
+	w := NewWorker(time.Second)
+	handler := setupHealthServer(w, prometheus.NewRegistry(), "0", newAdminMux(w, "s3cret")).Handler
+
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		wantCode int
+		want     string
+	}{
+		{"method not allowed", http.MethodPost, "/live", http.StatusMethodNotAllowed, codeMethodNotAllowed},
+		{"admin unauthorized", http.MethodPost, "/admin/pause", http.StatusUnauthorized, codeUnauthorized},
+		{"metrics unauthorized", http.MethodGet, "/metrics", http.StatusUnauthorized, codeUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.target, nil))
+			if rec.Code != tt.wantCode {
+				t.Fatalf("expected %d, got %d: %s", tt.wantCode, rec.Code, rec.Body.String())
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("expected Content-Type application/json, got %q", ct)
+			}
+			var body map[string]string
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("expected a JSON object of strings, got %q: %v", rec.Body.String(), err)
+			}
+			keys := make([]string, 0, len(body))
+			for k := range body {
+				keys = append(keys, k)
+			}
+			slices.Sort(keys)
+			if want := []string{"code", "message", "status"}; !reflect.DeepEqual(keys, want) {
+				t.Errorf("expected the envelope fields %v, got %v", want, keys)
+			}
+			if body["status"] != "error" || body["code"] != tt.want || body["message"] == "" {
+				t.Errorf("unexpected envelope %v", body)
+			}
+		})
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"log/slog"
+	"testing"
+)
+
+func TestParseLogLevel(t *testing.T) {
+	tests := map[string]slog.Level{
+		"debug": slog.LevelDebug,
+		"info":  slog.LevelInfo,
+		"WARN":  slog.LevelWarn,
+		"error": slog.LevelError,
+	}
+	for value, want := range tests {
+		if got, err := parseLogLevel(value); err != nil || got != want {
+			t.Errorf("parseLogLevel(%q) = %v, %v; want %v", value, got, err, want)
+		}
+	}
+	for _, bad := range []string{"", "verbose"} {
+		if _, err := parseLogLevel(bad); err == nil {
+			t.Errorf("parseLogLevel(%q): expected an error", bad)
+		}
+	}
+}
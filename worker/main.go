@@ -3,18 +3,26 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"flag"
 	"fmt"
 	"log/slog"
+	"math/rand/v2"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/time/rate"
 )
 
 var (
@@ -22,222 +30,964 @@ var (
 	dbMu sync.RWMutex
 )
 
-// Prometheus metrics
-var (
-	workerLogsProcessed = prometheus.NewCounter(
-		prometheus.CounterOpts{
-			Name: "worker_logs_processed_total",
-			Help: "Total number of log entries processed by the worker",
-		},
-	)
-	workerProcessingDuration = prometheus.NewHistogram(
-		prometheus.HistogramOpts{
-			Name:    "worker_processing_duration_seconds",
-			Help:    "Duration of each worker batch processing cycle",
-			Buckets: prometheus.DefBuckets,
-		},
-	)
-	workerBatchErrors = prometheus.NewCounter(
-		prometheus.CounterOpts{
-			Name: "worker_batch_errors_total",
-			Help: "Total number of batch processing errors",
-		},
-	)
+const (
+	defaultBatchSize            = 1000
+	defaultQueryTimeout         = 30 * time.Second
+	defaultStalenessFactor      = 3.0
+	defaultStalenessMin         = 10 * time.Second
+	defaultJitter               = 0.1
+	activeYield                 = 100 * time.Millisecond
+	maxErrorBackoff             = time.Minute
+	defaultErrorReportThreshold = 5
+	defaultReadyPingTimeout     = 2 * time.Second
+	defaultReadyCacheTTL        = 2 * time.Second
+	defaultServiceName          = "worker"
+	errWriteResponse            = "failed to write response"
 )
 
-func init() {
-	prometheus.MustRegister(workerLogsProcessed)
-	prometheus.MustRegister(workerProcessingDuration)
-	prometheus.MustRegister(workerBatchErrors)
-}
+// errDBNotConnected is returned by processLogs when no database is configured.
+var errDBNotConnected = errors.New("db not connected")
 
-const (
-	defaultBatchSize = 1000
-	errWriteResponse = "failed to write response"
-)
+// serviceName identifies this binary in health responses; main overrides it
+// from SERVICE_NAME.
+var serviceName = defaultServiceName
+
+// readyPingTimeout bounds the readiness DB ping so a stalled Postgres fails
+// the probe instead of hanging it. Overridden by READY_PING_TIMEOUT.
+var readyPingTimeout = defaultReadyPingTimeout
 
-func initDB(dsn string) (*sql.DB, error) {
+// initDB opens a pool on dsn, checks it can connect and brings the schema
+// up to date. Cancelling ctx abandons the attempt.
+func initDB(ctx context.Context, dsn string) (_ *sql.DB, err error) {
+	defer func() { lastDBError.record(redactDSNError(err, dsn)) }()
 	d, err := sql.Open("pgx", dsn)
 	if err != nil {
 		return nil, err
 	}
-	// Verify connection
-	if err := d.Ping(); err != nil {
+	if err := d.PingContext(ctx); err != nil {
+		_ = d.Close()
+		return nil, err
+	}
+	if _, err := migrate(ctx, d, migrations); err != nil {
+		_ = d.Close()
 		return nil, err
 	}
 	return d, nil
 }
 
-// connectWithRetry attempts to connect to the database with exponential backoff.
-func connectWithRetry(dsn string, maxRetries int, baseDelay time.Duration) (*sql.DB, error) {
+// connectWithRetry attempts to connect to the database with exponential
+// backoff, counting failed attempts and exhausted retries on m. Each attempt
+// starts with preflightDSN, and while no DSN host resolves it gives up after
+// dsnUnresolvableRetries attempts. Cancelling ctx, on a shutdown signal say,
+// cuts the current attempt or wait short and returns the context's error.
+func connectWithRetry(ctx context.Context, dsn string, maxRetries int, baseDelay time.Duration, m *metrics) (*sql.DB, error) {
 	var d *sql.DB
 	var err error
 	for i := 0; i < maxRetries; i++ {
-		d, err = initDB(dsn)
-		if err == nil {
-			// Configure connection pool
-			d.SetMaxOpenConns(25)
-			d.SetMaxIdleConns(5)
-			d.SetConnMaxLifetime(5 * time.Minute)
-			return d, nil
+		if err = preflightDSN(ctx, dsn); err == nil {
+			d, err = initDB(ctx, dsn)
+			if err == nil {
+				dbPool.apply(d)
+				return d, nil
+			}
+			err = redactDSNError(err, dsn)
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		m.dbConnectRetries.Inc()
+		if errors.As(err, new(unresolvableError)) {
+			maxRetries = min(maxRetries, dsnUnresolvableRetries)
+		}
+		if i+1 == maxRetries {
+			break
 		}
 		delay := baseDelay * (1 << uint(i))
 		slog.Warn("db connection failed, retrying", "attempt", i+1, "max", maxRetries, "delay", delay, "error", err)
-		time.Sleep(delay)
+		select {
+		case <-ctx.Done():
+		case <-time.After(delay):
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("database connection abandoned: %w", err)
 	}
+	m.dbConnectFailures.Inc()
 	return nil, fmt.Errorf("failed to connect after %d retries: %w", maxRetries, err)
 }
 
 // Worker manages the background job for processing api_logs
 type Worker struct {
-	interval  time.Duration
-	batchSize int
-	lastRunAt time.Time
-	isHealthy bool
+	metrics   *metrics
+	startedAt time.Time
+
+	// interval is the idle polling interval in nanoseconds; SetInterval
+	// changes it while the loop runs.
+	interval     atomic.Int64
+	queryTimeout time.Duration
+	batchSize    int
+	concurrency  int
+	schedule     *Schedule
+	clock        Clock
+
+	stalenessFactor float64
+	stalenessMin    time.Duration
+
+	// limiter caps processed rows per second when maxRowsPerSec > 0.
+	maxRowsPerSec float64
+	limiter       *rate.Limiter
+
+	// jitter spreads idle sleeps by ±jitter*interval; rng is only used by
+	// the Run goroutine and falls back to the process-seeded source.
+	jitter float64
+	rng    *rand.Rand
+
+	// mu guards the run state below, which the health server reads
+	// concurrently with the Run loop.
+	mu                sync.RWMutex
+	lastRunAt         time.Time
+	lastBatchRows     int
+	nextRunAt         time.Time
+	isHealthy         bool
+	consecutiveErrors int
+
+	// startupDrain runs batches back to back on boot; see drain.go. drain
+	// is its progress, guarded by mu.
+	startupDrain bool
+	drain        drainState
+
+	// errorReportThreshold is how many consecutive failed batches make one
+	// error report.
+	errorReportThreshold int
+
+	// publisher, when set, announces the batches that processed rows.
+	publisher *BatchPublisher
+
+	// processors run on every claimed batch, in order, before it is
+	// stamped processed; see pipeline.go.
+	processors []namedProcessor
+
+	// Reconnection supervisor; see reconnect.go.
+	connect               ConnectFunc
+	reconnectThreshold    int
+	consecutiveConnErrors int
+	bg                    sync.WaitGroup
+
+	paused atomic.Bool
+	wake   chan struct{}
+
+	// kicks carries /admin/process requests to the Run loop; inFlight is
+	// set while a batch runs, and stopped is closed once Run returns.
+	kicks    chan processKick
+	inFlight atomic.Bool
+	stopped  chan struct{}
+
+	// shutdownGrace is how long a batch in flight at shutdown may run on;
+	// handoff is how it ended, guarded by mu. See handoff.go.
+	shutdownGrace time.Duration
+	handoff       string
+}
+
+// WorkerOption configures optional Worker settings.
+type WorkerOption func(*Worker)
+
+// WithQueryTimeout bounds how long a single batch statement may run.
+func WithQueryTimeout(d time.Duration) WorkerOption {
+	return func(w *Worker) {
+		if d > 0 {
+			w.queryTimeout = d
+		}
+	}
+}
+
+// WithBatchSize sets how many rows a single batch claims.
+func WithBatchSize(n int) WorkerOption {
+	return func(w *Worker) {
+		if n > 0 {
+			w.batchSize = n
+		}
+	}
+}
+
+// WithConcurrency sets how many batches are processed in parallel per cycle.
+func WithConcurrency(n int) WorkerOption {
+	return func(w *Worker) {
+		if n > 0 {
+			w.concurrency = n
+		}
+	}
+}
+
+// WithStaleness sets the staleness factor (multiplied by the interval) and
+// the minimum grace period used by IsHealthy.
+func WithStaleness(factor float64, minimum time.Duration) WorkerOption {
+	return func(w *Worker) {
+		if factor > 0 {
+			w.stalenessFactor = factor
+		}
+		if minimum >= 0 {
+			w.stalenessMin = minimum
+		}
+	}
+}
+
+// WithJitter randomizes each idle sleep by up to ±fraction of the interval.
+// rng may be nil to use the process-wide randomly seeded source.
+func WithJitter(fraction float64, rng *rand.Rand) WorkerOption {
+	return func(w *Worker) {
+		if fraction > 0 && fraction < 1 {
+			w.jitter = fraction
+			w.rng = rng
+		}
+	}
+}
+
+// WithStartTime sets when the process started, for uptime in /stats and
+// /healthz. It defaults to when the worker was created.
+func WithStartTime(t time.Time) WorkerOption {
+	return func(w *Worker) {
+		w.startedAt = t
+	}
+}
+
+// WithMetrics sets the collectors the worker reports to. Without it the
+// worker uses its own unregistered set.
+func WithMetrics(m *metrics) WorkerOption {
+	return func(w *Worker) {
+		w.metrics = m
+	}
+}
+
+// WithMaxRowsPerSecond caps processing throughput. Zero means unlimited.
+func WithMaxRowsPerSecond(limit float64) WorkerOption {
+	return func(w *Worker) {
+		if limit > 0 {
+			w.maxRowsPerSec = limit
+		}
+	}
+}
+
+// WithErrorReportThreshold reports an error once n consecutive batches
+// have failed.
+func WithErrorReportThreshold(n int) WorkerOption {
+	return func(w *Worker) {
+		if n > 0 {
+			w.errorReportThreshold = n
+		}
+	}
+}
+
+// WithBatchPublisher announces every batch that processed rows on p.
+func WithBatchPublisher(p *BatchPublisher) WorkerOption {
+	return func(w *Worker) {
+		w.publisher = p
+	}
+}
+
+// WithSchedule switches the worker from interval polling to draining the
+// backlog at the times given by s.
+func WithSchedule(s *Schedule) WorkerOption {
+	return func(w *Worker) {
+		w.schedule = s
+	}
 }
 
 // NewWorker creates a new Worker.
-func NewWorker(interval time.Duration) *Worker {
-	return &Worker{
-		interval:  interval,
-		batchSize: defaultBatchSize,
-		isHealthy: true,
+func NewWorker(interval time.Duration, opts ...WorkerOption) *Worker {
+	w := &Worker{
+		queryTimeout: defaultQueryTimeout,
+		batchSize:    defaultBatchSize,
+		concurrency:  1,
+		clock:        realClock{},
+		isHealthy:    true,
+
+		stalenessFactor: defaultStalenessFactor,
+		stalenessMin:    defaultStalenessMin,
+
+		reconnectThreshold:   defaultReconnectThreshold,
+		errorReportThreshold: defaultErrorReportThreshold,
+		wake:                 make(chan struct{}, 1),
+		kicks:                make(chan processKick, 1),
+		stopped:              make(chan struct{}),
+		shutdownGrace:        defaultShutdownGrace,
 	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	if w.metrics == nil {
+		w.metrics = newMetrics(prometheus.NewRegistry(), defaultDurationBuckets)
+	}
+	if w.startedAt.IsZero() {
+		w.startedAt = w.clock.Now()
+	}
+	w.SetInterval(interval)
+	if w.maxRowsPerSec > 0 {
+		// Allow one full cycle as burst so a single batch is never rejected.
+		w.limiter = rate.NewLimiter(rate.Limit(w.maxRowsPerSec), w.batchSize*w.concurrency)
+	}
+	return w
 }
 
-// Run starts the worker loop for near-real-time updates.
+// Run starts the worker loop for near-real-time updates, or for scheduled
+// runs when a schedule is configured.
 func (w *Worker) Run(ctx context.Context) {
-	slog.Info("worker started", "interval", w.interval.String())
+	defer close(w.stopped)
+	slog.Info("worker started",
+		"interval", w.Interval().String(),
+		"schedule", w.scheduleString(),
+		"query_timeout", w.queryTimeout.String(),
+		"concurrency", w.concurrency,
+		"max_rows_per_sec", w.maxRowsPerSec,
+	)
 
+	if w.startupDrain {
+		w.drainAtStartup(ctx)
+	}
+	for {
+		var delay time.Duration
+		if w.schedule != nil {
+			delay = w.runScheduled(ctx)
+		} else {
+			delay = w.idleInterval()
+			if w.canProcess() {
+				delay = w.runOnce(ctx)
+			}
+		}
+		w.setNextRun(w.clock.Now().Add(delay))
+		if !w.wait(ctx, delay) {
+			w.bg.Wait()
+			w.logStopping()
+			return
+		}
+	}
+}
+
+// canProcess reports whether the loop may claim batches right now.
+func (w *Worker) canProcess() bool {
+	return !w.IsPaused() && !dbReconnecting.Load()
+}
+
+// runOnce processes a single batch and returns how long the loop should
+// wait before the next batch.
+func (w *Worker) runOnce(ctx context.Context) time.Duration {
+	processed, err := w.runBatch(ctx)
+	return w.nextDelay(processed, err)
+}
+
+// nextDelay is how long the loop waits after a batch that processed rows or
+// failed with err.
+func (w *Worker) nextDelay(processed int, err error) time.Duration {
+	switch {
+	case err != nil:
+		return w.errorBackoff()
+	case processed == 0:
+		// Sleep if there are no logs to process
+		return w.idleInterval()
+	default:
+		// Yield but continue processing quickly if we have an active queue,
+		// unless that would exceed the throughput cap
+		return max(activeYield, w.throttle(processed))
+	}
+}
+
+// throttle charges rows against the throughput cap and returns how long to
+// wait before claiming the next batch to stay within it.
+func (w *Worker) throttle(rows int) time.Duration {
+	if w.limiter == nil || rows == 0 {
+		return 0
+	}
+	now := w.clock.Now()
+	r := w.limiter.ReserveN(now, rows)
+	if !r.OK() {
+		return 0
+	}
+	return r.DelayFrom(now)
+}
+
+// idleInterval returns the interval, spread by up to ±jitter of itself so
+// that replicas started together drift apart instead of polling in sync.
+func (w *Worker) idleInterval() time.Duration {
+	if w.jitter <= 0 {
+		return w.Interval()
+	}
+	r := rand.Float64()
+	if w.rng != nil {
+		r = w.rng.Float64()
+	}
+	return time.Duration(float64(w.Interval()) * (1 + w.jitter*(2*r-1)))
+}
+
+// runScheduled drains the backlog if the planned run time has been reached
+// and returns the wait until the next scheduled time.
+func (w *Worker) runScheduled(ctx context.Context) time.Duration {
+	w.mu.RLock()
+	due := !w.nextRunAt.IsZero() && !w.clock.Now().Before(w.nextRunAt)
+	w.mu.RUnlock()
+	if due && w.canProcess() {
+		w.drainBacklog(ctx)
+	}
+	now := w.clock.Now()
+	next := w.schedule.Next(now)
+	if next.IsZero() {
+		slog.Error("schedule never fires, falling back to interval", "schedule", w.schedule.String())
+		return w.Interval()
+	}
+	return next.Sub(now)
+}
+
+// drainBacklog processes batches back to back until one comes back empty,
+// fails, or the worker is paused or stopped.
+func (w *Worker) drainBacklog(ctx context.Context) {
+	total := 0
+	for ctx.Err() == nil && w.canProcess() {
+		processed, err := w.runBatch(ctx)
+		total += processed
+		if err != nil || processed == 0 {
+			break
+		}
+		if delay := w.throttle(processed); delay > 0 && !w.sleep(ctx, delay) {
+			break
+		}
+	}
+	slog.Info("scheduled run completed", "processed", total)
+}
+
+// runBatch processes one batch, in a span of its own, and records the
+// outcome in the run state. Once ctx is done it claims nothing more, and a
+// batch already running is given the shutdown grace to finish; see
+// batchContext.
+func (w *Worker) runBatch(ctx context.Context) (int, error) {
+	if ctx.Err() != nil {
+		return 0, ctx.Err()
+	}
+	w.inFlight.Store(true)
+	defer w.inFlight.Store(false)
+	bctx, release := w.batchContext(ctx)
+	bctx, span := tracer.Start(bctx, "process batch", trace.WithAttributes(
+		attribute.Int("worker.batch_size", w.batchSize),
+		attribute.Int("worker.concurrency", max(w.concurrency, 1)),
+	))
+	start := time.Now()
+	res, err := w.processLogs(bctx)
+	duration := time.Since(start)
+	interrupted := release()
+	processed := res.rows
+	span.SetAttributes(attribute.Int("worker.rows_processed", processed))
+	endSpan(span, err)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if interrupted {
+		w.handoff = handoffOutcome(bctx, err)
+	}
+	w.lastRunAt = w.clock.Now()
+	w.lastBatchRows = processed
+	w.metrics.lastRunTimestamp.Set(float64(w.lastRunAt.Unix()))
+	w.metrics.setLastBatchRows(processed)
+	if err != nil {
+		w.consecutiveErrors++
+		w.observeBatchError(ctx, err)
+		w.reportBatchErrors(ctx, err)
+	} else {
+		w.consecutiveErrors = 0
+		w.consecutiveConnErrors = 0
+		if processed > 0 {
+			w.publisher.Publish(newBatchSummary(res, duration, w.lastRunAt))
+		}
+	}
+	return processed, err
+}
+
+// reportBatchErrors makes one error report when the failed batches reach
+// errorReportThreshold in a row; the streak must end before another is
+// made. Caller must hold w.mu.
+func (w *Worker) reportBatchErrors(ctx context.Context, err error) {
+	if w.consecutiveErrors != w.errorReportThreshold || ctx.Err() != nil {
+		return
+	}
+	errorReporter.Report(ErrorReport{
+		Message: "batches failing repeatedly",
+		Error:   err.Error(),
+		Details: map[string]any{"consecutive_errors": w.consecutiveErrors, "batch_size": w.batchSize},
+	})
+}
+
+func (w *Worker) setNextRun(t time.Time) {
+	w.mu.Lock()
+	w.nextRunAt = t
+	w.mu.Unlock()
+	w.metrics.nextRunTimestamp.Set(float64(t.Unix()))
+}
+
+func (w *Worker) scheduleString() string {
+	if w.schedule == nil {
+		return ""
+	}
+	return w.schedule.String()
+}
+
+// wait is the Run loop's sleep between cycles: like sleep, but it also
+// runs any batch kicked meanwhile through /admin/process. In interval mode a
+// kicked batch that ran restarts the wait with the delay its outcome calls
+// for, so a failure still backs off; otherwise the planned wake-up stands.
+func (w *Worker) wait(ctx context.Context, d time.Duration) bool {
+	until := w.clock.Now().Add(d)
 	for {
 		select {
 		case <-ctx.Done():
-			slog.Info("worker stopping", "reason", "context cancelled")
-			return
-		default:
-			processed := w.processLogs()
-			w.lastRunAt = time.Now()
-
-			if processed == 0 {
-				// Sleep if there are no logs to process
-				time.Sleep(w.interval)
-			} else {
-				// Yield but continue processing quickly if we have an active queue
-				time.Sleep(100 * time.Millisecond)
+			return false
+		case <-w.wake:
+			return true
+		case k := <-w.kicks:
+			if delay, ran := w.runKicked(ctx, k); ran && w.schedule == nil {
+				until = w.clock.Now().Add(delay)
 			}
+		case <-w.clock.After(until.Sub(w.clock.Now())):
+			return true
+		}
+	}
+}
+
+// sleep waits for d or until the worker is woken by Resume. It returns
+// false if ctx is cancelled first.
+func (w *Worker) sleep(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-w.wake:
+	case <-w.clock.After(d):
+	}
+	return true
+}
+
+// Pause stops the worker from claiming new batches until Resume is called.
+// An in-flight batch is allowed to finish.
+func (w *Worker) Pause() {
+	if w.paused.CompareAndSwap(false, true) {
+		w.metrics.paused.Set(1)
+		slog.Info("worker paused")
+		recordEvent(eventPaused, nil)
+	}
+}
+
+// Resume re-enables processing and wakes the loop for an immediate cycle.
+func (w *Worker) Resume() {
+	if w.paused.CompareAndSwap(true, false) {
+		w.metrics.paused.Set(0)
+		slog.Info("worker resumed")
+		recordEvent(eventResumed, nil)
+		select {
+		case w.wake <- struct{}{}:
+		default:
 		}
 	}
 }
 
-func (w *Worker) processLogs() int {
+// Errors a kicked batch fails with before it runs.
+var (
+	errWorkerPaused       = errors.New("worker is paused")
+	errWorkerReconnecting = errors.New("worker is reconnecting to the database")
+	errBatchInFlight      = errors.New("a batch is already in flight")
+	errWorkerStopped      = errors.New("worker has stopped")
+)
+
+// processKick asks the Run loop for a batch now; the loop sends the outcome
+// on done, which must have room for it.
+type processKick struct {
+	done chan kickResult
+}
+
+type kickResult struct {
+	rows int
+	err  error
+}
+
+// Kick has the Run loop process a batch now rather than at its next cycle
+// and returns the rows the batch processed. With wait it queues behind a
+// batch that is already running; without, it fails with errBatchInFlight.
+func (w *Worker) Kick(ctx context.Context, wait bool) (int, error) {
+	if w.IsPaused() {
+		return 0, errWorkerPaused
+	}
+	if !wait && w.inFlight.Load() {
+		return 0, errBatchInFlight
+	}
+	k := processKick{done: make(chan kickResult, 1)}
+	if wait {
+		select {
+		case w.kicks <- k:
+		case <-w.stopped:
+			return 0, errWorkerStopped
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	} else {
+		select {
+		case w.kicks <- k:
+		default:
+			return 0, errBatchInFlight
+		}
+	}
+	select {
+	case res := <-k.done:
+		return res.rows, res.err
+	case <-w.stopped:
+		return 0, errWorkerStopped
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+// runKicked runs the batch k asked for, unless the worker was paused or
+// started reconnecting since, and reports the outcome to the kicker. It
+// returns the delay the outcome calls for and whether the batch ran.
+func (w *Worker) runKicked(ctx context.Context, k processKick) (time.Duration, bool) {
+	switch {
+	case w.IsPaused():
+		k.done <- kickResult{err: errWorkerPaused}
+		return 0, false
+	case dbReconnecting.Load():
+		k.done <- kickResult{err: errWorkerReconnecting}
+		return 0, false
+	}
+	slog.Info("processing run triggered")
+	processed, err := w.runBatch(ctx)
+	delay := w.nextDelay(processed, err)
+	if w.schedule == nil {
+		w.setNextRun(w.clock.Now().Add(delay))
+	}
+	k.done <- kickResult{rows: processed, err: err}
+	return delay, true
+}
+
+// Interval returns the idle polling interval.
+func (w *Worker) Interval() time.Duration {
+	return time.Duration(w.interval.Load())
+}
+
+// SetInterval changes the idle polling interval. It is safe to call while
+// the worker runs and takes effect from the next sleep.
+func (w *Worker) SetInterval(d time.Duration) {
+	w.interval.Store(int64(d))
+	w.metrics.intervalSeconds.Set(d.Seconds())
+}
+
+// IsPaused reports whether processing is currently paused.
+func (w *Worker) IsPaused() bool {
+	return w.paused.Load()
+}
+
+// errorBackoff doubles the interval for every consecutive failed batch,
+// capped at maxErrorBackoff.
+func (w *Worker) errorBackoff() time.Duration {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	delay := w.Interval()
+	for i := 1; i < w.consecutiveErrors && delay < maxErrorBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxErrorBackoff {
+		delay = maxErrorBackoff
+	}
+	return delay
+}
+
+// processLogs marks the next batch of unprocessed rows, running one
+// statement per configured partition in parallel and waiting for all of
+// them. Statements run under a timeout derived from ctx, so cancelling ctx
+// aborts them immediately; runBatch passes a ctx that outlives shutdown by
+// the grace period.
+func (w *Worker) processLogs(ctx context.Context) (batchResult, error) {
 	dbMu.RLock()
 	d := db
 	dbMu.RUnlock()
 	if d == nil {
-		w.isHealthy = false
+		w.setHealthy(false)
 		slog.Warn("db not connected")
-		return 0
+		return batchResult{}, errDBNotConnected
+	}
+
+	if w.concurrency <= 1 {
+		res, err := w.processBatch(ctx, d, 0)
+		return w.recordBatch(ctx, res, err)
+	}
+
+	var wg sync.WaitGroup
+	results := make([]batchResult, w.concurrency)
+	errs := make([]error, w.concurrency)
+	for i := range w.concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = w.processBatch(ctx, d, i)
+		}()
+	}
+	wg.Wait()
+
+	var total batchResult
+	for _, res := range results {
+		total.merge(res)
+	}
+	return w.recordBatch(ctx, total, errors.Join(errs...))
+}
+
+// recordBatch updates worker health from the combined outcome of a cycle.
+func (w *Worker) recordBatch(ctx context.Context, res batchResult, err error) (batchResult, error) {
+	if err != nil && ctx.Err() == nil {
+		w.setHealthy(false)
+	} else if err == nil {
+		w.setHealthy(true)
+	}
+	return res, err
+}
+
+// batchResult is what a batch, or a cycle of them, processed: the number of
+// rows and the range of their created_at, zero when none had one.
+type batchResult struct {
+	rows           int
+	oldest, newest time.Time
+}
+
+// add counts a row created at created.
+func (b *batchResult) add(created sql.NullTime) {
+	b.rows++
+	if created.Valid {
+		b.extend(created.Time, created.Time)
 	}
+}
+
+// merge adds the rows of o.
+func (b *batchResult) merge(o batchResult) {
+	b.rows += o.rows
+	if !o.oldest.IsZero() {
+		b.extend(o.oldest, o.newest)
+	}
+}
+
+func (b *batchResult) extend(oldest, newest time.Time) {
+	if b.oldest.IsZero() || oldest.Before(b.oldest) {
+		b.oldest = oldest
+	}
+	if newest.After(b.newest) {
+		b.newest = newest
+	}
+}
+
+// processBatch claims and marks up to batchSize rows whose id falls in the
+// given partition (id % concurrency) in one transaction; see markBatch.
+// Partitions are disjoint, and SKIP LOCKED keeps other worker replicas from
+// claiming the same rows.
+func (w *Worker) processBatch(ctx context.Context, d *sql.DB, partition int) (batchResult, error) {
+	queryCtx, cancel := context.WithTimeout(ctx, w.queryTimeout)
+	defer cancel()
+	queryCtx, span := tracer.Start(queryCtx, "UPDATE api_logs",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system.name", "postgresql"),
+			attribute.String("db.operation.name", "UPDATE"),
+			attribute.String("db.collection.name", "api_logs"),
+			attribute.Int("worker.partition", partition),
+		),
+	)
 
 	start := time.Now()
-	res, err := d.Exec(`
-		UPDATE api_logs
-		SET processed_at = CURRENT_TIMESTAMP
-		WHERE id IN (
-			SELECT id FROM api_logs
-			WHERE processed_at IS NULL
-			ORDER BY id
-			LIMIT $1
-		)
-	`, w.batchSize)
-	duration := time.Since(start).Seconds()
-	workerProcessingDuration.Observe(duration)
+	res, tally, err := w.markBatch(queryCtx, d, partition)
+	w.metrics.observeBatch(time.Since(start))
+	span.SetAttributes(attribute.Int("db.response.returned_rows", res.rows))
+	endSpan(span, err)
 
 	if err != nil {
-		w.isHealthy = false
-		workerBatchErrors.Inc()
-		slog.Error("failed to process logs", "error", err)
-		return 0
+		if ctx.Err() != nil {
+			// Shutdown in progress; the statement was cancelled on purpose.
+			slog.Info("batch cancelled", "reason", context.Cause(ctx).Error(), "partition", partition)
+			return batchResult{}, context.Cause(ctx)
+		}
+		w.metrics.countBatchError()
+		if errors.Is(queryCtx.Err(), context.DeadlineExceeded) {
+			w.metrics.batchTimeouts.Inc()
+			slog.Error("batch timed out", "timeout", w.queryTimeout.String(), "partition", partition, "error", err)
+			return batchResult{}, fmt.Errorf("batch timed out after %s: %w", w.queryTimeout, err)
+		}
+		slog.Error("failed to process logs", "partition", partition, "error", err)
+		return batchResult{}, err
 	}
 
-	w.isHealthy = true
-	rows, _ := res.RowsAffected()
-	if rows > 0 {
-		workerLogsProcessed.Add(float64(rows))
-		slog.Info("processed api logs", "count", rows)
+	// The batch has committed, so the rows are counted exactly once.
+	for key, n := range tally {
+		w.metrics.processedByStatus.WithLabelValues(key.route, key.class).Add(float64(n))
 	}
-	return int(rows)
+	if res.rows > 0 {
+		w.metrics.addProcessed(res.rows)
+		slog.Info("processed api logs", "count", res.rows, "partition", partition)
+	}
+	return res, nil
+}
+
+func (w *Worker) setHealthy(healthy bool) {
+	w.mu.Lock()
+	w.isHealthy = healthy
+	w.mu.Unlock()
 }
 
 func (w *Worker) LastRunAt() time.Time {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
 	return w.lastRunAt
 }
 
 func (w *Worker) IsHealthy() bool {
-	// Unhealthy if last run was more than 3x the interval ago
-	if !w.lastRunAt.IsZero() && time.Since(w.lastRunAt) > 3*w.interval {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	// A paused worker is idle on purpose, so it is never considered stale.
+	if !w.IsPaused() && w.isStale(w.clock.Now()) {
 		return false
 	}
 	return w.isHealthy
 }
 
-func getEnvOrDefault(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
-	}
-	return defaultValue
+// stalenessThreshold is how far behind the loop may fall before it is
+// considered stale: the longest possible (jittered) interval times the
+// staleness factor, but never less than the minimum grace period.
+func (w *Worker) stalenessThreshold() time.Duration {
+	maxInterval := float64(w.Interval()) * (1 + w.jitter)
+	return max(time.Duration(w.stalenessFactor*maxInterval), w.stalenessMin)
 }
 
-func getWorkerInterval() time.Duration {
-	intervalStr := getEnvOrDefault("WORKER_INTERVAL", "")
-	interval := 2 * time.Second // default: 2 seconds for near real-time
-	if intervalStr != "" {
-		if parsed, err := time.ParseDuration(intervalStr); err == nil {
-			interval = parsed
+// staleDeadline returns the time after which the worker counts as stale, or
+// the zero time if it has not started yet. In interval mode the clock starts
+// at the last run; in schedule mode, at the next scheduled run until that
+// run has happened. Callers must hold w.mu.
+func (w *Worker) staleDeadline() time.Time {
+	if w.schedule != nil {
+		if w.nextRunAt.IsZero() || !w.lastRunAt.Before(w.nextRunAt) {
+			return time.Time{}
 		}
+		return w.nextRunAt.Add(w.stalenessThreshold())
+	}
+	if w.lastRunAt.IsZero() {
+		return time.Time{}
 	}
-	return interval
+	return w.lastRunAt.Add(w.stalenessThreshold())
 }
 
-func liveHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	if _, err := w.Write([]byte(`{"status":"ok"}`)); err != nil {
-		slog.Error(errWriteResponse, "error", err)
-	}
+// isStale reports whether now is past the stale deadline. Callers must hold
+// w.mu.
+func (w *Worker) isStale(now time.Time) bool {
+	deadline := w.staleDeadline()
+	return !deadline.IsZero() && now.After(deadline)
 }
 
-func readyHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	dbMu.RLock()
-	d := db
-	dbMu.RUnlock()
-	if d == nil {
-		w.WriteHeader(http.StatusServiceUnavailable)
-		if _, err := w.Write([]byte(`{"status":"error","message":"db not configured"}`)); err != nil {
-			slog.Error(errWriteResponse, "error", err)
-		}
-		return
+// WorkerStats is the JSON document served at /stats.
+type WorkerStats struct {
+	Interval          string      `json:"interval"`
+	IntervalSeconds   float64     `json:"interval_seconds"`
+	Schedule          string      `json:"schedule,omitempty"`
+	Concurrency       int         `json:"concurrency"`
+	LastRunAt         string      `json:"last_run_at,omitempty"`
+	LastBatchRows     int         `json:"last_batch_rows"`
+	StartedAt         string      `json:"started_at"`
+	Uptime            string      `json:"uptime"`
+	NextRunAt         string      `json:"next_run_at,omitempty"`
+	Healthy           bool        `json:"healthy"`
+	Paused            bool        `json:"paused"`
+	Reconnecting      bool        `json:"reconnecting"`
+	ConsecutiveErrors int         `json:"consecutive_errors"`
+	Stale             bool        `json:"stale"`
+	StaleThreshold    string      `json:"stale_threshold"`
+	StaleDeadline     string      `json:"stale_deadline,omitempty"`
+	StartupDrain      *DrainStats `json:"startup_drain,omitempty"`
+}
+
+// Stats returns a snapshot of the worker's run state.
+func (w *Worker) Stats() WorkerStats {
+	stats := WorkerStats{
+		Interval:        w.Interval().String(),
+		IntervalSeconds: w.Interval().Seconds(),
+		Schedule:        w.scheduleString(),
+		Concurrency:     w.concurrency,
+		Healthy:         w.IsHealthy(),
+		Paused:          w.IsPaused(),
+		Reconnecting:    dbReconnecting.Load(),
 	}
-	if err := d.Ping(); err != nil {
-		w.WriteHeader(http.StatusServiceUnavailable)
-		if _, err := w.Write([]byte(`{"status":"error","message":"db unreachable"}`)); err != nil {
-			slog.Error(errWriteResponse, "error", err)
-		}
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if !w.lastRunAt.IsZero() {
+		stats.LastRunAt = w.lastRunAt.UTC().Format(time.RFC3339)
+	}
+	if !w.nextRunAt.IsZero() {
+		stats.NextRunAt = w.nextRunAt.UTC().Format(time.RFC3339)
+	}
+	stats.LastBatchRows = w.lastBatchRows
+	stats.StartedAt = w.startedAt.UTC().Format(time.RFC3339)
+	stats.Uptime = w.clock.Now().Sub(w.startedAt).Truncate(time.Second).String()
+	stats.ConsecutiveErrors = w.consecutiveErrors
+	stats.StaleThreshold = w.stalenessThreshold().String()
+	if deadline := w.staleDeadline(); !deadline.IsZero() {
+		stats.StaleDeadline = deadline.UTC().Format(time.RFC3339)
+		stats.Stale = !w.IsPaused() && w.clock.Now().After(deadline)
+	}
+	stats.StartupDrain = w.drainStats()
+	return stats
+}
+
+// HealthResponse represents the JSON response for the health endpoint.
+type HealthResponse struct {
+	Status    string `json:"status"`
+	Timestamp string `json:"timestamp"`
+	Service   string `json:"service"`
+	Version   string `json:"version"`
+}
+
+func liveHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, HealthResponse{
+		Status:    "ok",
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Service:   serviceName,
+		Version:   version,
+	})
+}
+
+// started flips to true once main has finished initialization and never
+// goes back, so /startup doesn't flap with dependency health like /ready.
+var started atomic.Bool
+
+func startupHandler(w http.ResponseWriter, r *http.Request) {
+	if !started.Load() {
+		writeJSON(w, http.StatusServiceUnavailable, statusResponse{Status: "starting"})
 		return
 	}
+	writeJSON(w, http.StatusOK, statusResponse{Status: "started"})
+}
+
+// readiness caches /ready results; main sets its ttl from READY_CACHE_TTL.
+var readiness readyCache
 
-	w.WriteHeader(http.StatusOK)
-	if _, err := w.Write([]byte(`{"status":"ready"}`)); err != nil {
-		slog.Error(errWriteResponse, "error", err)
+func readyHandler(w http.ResponseWriter, r *http.Request) {
+	res := readiness.get(r.Context(), checkReady)
+	writeJSON(w, res.code, res.response())
+}
+
+func statsHandler(worker *Worker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, worker.Stats())
 	}
 }
 
-func setupHealthServer(worker *Worker, healthPort string) *http.Server {
+// setupHealthServer returns the server for HEALTH_PORT. It also serves
+// admin, the /admin/* routes, unless that is nil because ADMIN_PORT gives
+// them a server of their own.
+func setupHealthServer(worker *Worker, gatherer prometheus.Gatherer, healthPort string, admin http.Handler) *http.Server {
 	mux := http.NewServeMux()
-	mux.HandleFunc("/live", liveHandler)
-	mux.HandleFunc("/ready", readyHandler)
-	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/live", methods(liveHandler, http.MethodGet, http.MethodHead))
+	mux.HandleFunc("/ready", methods(readyHandler, http.MethodGet, http.MethodHead))
+	mux.HandleFunc("/startup", methods(startupHandler, http.MethodGet, http.MethodHead))
+	mux.HandleFunc("/healthz", methods(healthzHandler(worker), http.MethodGet, http.MethodHead))
+	mux.HandleFunc("/version", methods(versionHandler, http.MethodGet, http.MethodHead))
+	mux.HandleFunc("/stats", methods(statsHandler(worker), http.MethodGet, http.MethodHead))
+	mux.HandleFunc("/metrics", methods(requireMetricsAuth(newMetricsHandler(gatherer).ServeHTTP), http.MethodGet, http.MethodHead))
+	if admin != nil {
+		mux.Handle(routeAdminPrefix, admin)
+	}
 
 	return &http.Server{
 		Addr:              ":" + healthPort,
@@ -249,25 +999,122 @@ func setupHealthServer(worker *Worker, healthPort string) *http.Server {
 	}
 }
 
+// newAdminMux registers the /admin/* routes that control worker, behind
+// adminToken when it is set.
+func newAdminMux(worker *Worker, adminToken string) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin/pause", methods(adminHandler(adminToken, pauseHandler(worker)), http.MethodPost))
+	mux.HandleFunc("/admin/resume", methods(adminHandler(adminToken, resumeHandler(worker)), http.MethodPost))
+	mux.HandleFunc("/admin/process", methods(adminHandler(adminToken, processHandler(worker)), http.MethodPost))
+	mux.HandleFunc("/admin/config", methods(configHandler(adminToken), http.MethodGet, http.MethodHead))
+	return mux
+}
+
 func main() {
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
-		Level: slog.LevelInfo,
-	}))
-	slog.SetDefault(logger)
-
-	env := getEnvOrDefault("APP_ENV", "development")
-	interval := getWorkerInterval()
-	healthPort := getEnvOrDefault("HEALTH_PORT", "8081")
-
-	slog.Info("worker initializing",
-		"env", env,
-		"interval", interval.String(),
-		"health_port", healthPort,
-	)
+	startedAt := time.Now()
+	slog.SetDefault(slog.New(newLogHandler(os.Stdout, logFormatJSON, false)))
+
+	cfg, err := LoadConfig(os.Args[1:])
+	switch {
+	case errors.Is(err, flag.ErrHelp):
+		os.Exit(0)
+	case errors.Is(err, errVersion):
+		fmt.Println(buildInfo())
+		os.Exit(0)
+	}
+	logLevel.Set(cfg.LogLevel)
+	closeLog := setupLogger(cfg)
+	defer closeLog()
+	switch {
+	case errors.Is(err, errValidateOnly):
+		slog.Info("configuration valid", "config", cfg)
+		os.Exit(0)
+	case errors.Is(err, errMigrateOnly):
+		addLogSecret(cfg.DSN)
+		d, err := initDB(context.Background(), cfg.DSN)
+		if err != nil {
+			slog.Error("migration failed", "error", redactDSNError(err, cfg.DSN))
+			os.Exit(1)
+		}
+		_ = d.Close()
+		slog.Info("database schema is up to date", "migrations", len(migrations))
+		os.Exit(0)
+	case err != nil:
+		slog.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+
+	serviceName = cfg.ServiceName
+	healthPort := strconv.Itoa(cfg.HealthPort)
+	readyPingTimeout = cfg.ReadyPingTimeout
+	readiness.ttl = cfg.ReadyCacheTTL
+	m := newMetrics(prometheus.DefaultRegisterer, cfg.DurationBuckets)
+	healthzStatusCodes["degraded"] = cfg.HealthzDegradedStatus
+	healthzStatusCodes["error"] = cfg.HealthzErrorStatus
+	metricsAuth = cfg.MetricsAuth
+	dbRequired = cfg.DBRequired
+	dbPool = cfg.DBPool
+
+	slog.Info("worker initializing", "version", version, "commit", commit, "config", cfg)
+
+	res := newServiceResource(serviceName, cfg.Env)
+	var flushTraces, flushMetrics func(context.Context) error
+	if endpoint := cfg.OTLPEndpoint; endpoint != "" {
+		flushTraces, err = setupTracing(context.Background(), endpoint, res)
+		if err != nil {
+			slog.Error("failed to set up tracing", "error", err)
+			os.Exit(1)
+		}
+		slog.Info("tracing enabled", "endpoint", endpoint)
+	}
+	if endpoint := cfg.OTLPMetricsEndpoint; endpoint != "" {
+		flushMetrics, err = setupOTLPMetrics(context.Background(), endpoint, cfg.OTLPMetricsInterval, res, prometheus.DefaultGatherer, m.otlpPushFailures)
+		if err != nil {
+			slog.Error("failed to set up the OTLP metrics push", "error", err)
+			os.Exit(1)
+		}
+		slog.Info("OTLP metrics push enabled", "endpoint", endpoint, "interval", cfg.OTLPMetricsInterval.String())
+	}
+	if addr := cfg.StatsdAddr; addr != "" {
+		tags := append([]string{statsdTag("service", serviceName), statsdTag("env", cfg.Env)}, cfg.StatsdTags...)
+		m.statsd, err = newStatsdClient(addr, cfg.StatsdPrefix, tags, m.statsdFailures)
+		if err != nil {
+			slog.Error("failed to set up the statsd emitter", "error", err)
+			os.Exit(1)
+		}
+		slog.Info("statsd emitter enabled", "addr", addr, "prefix", cfg.StatsdPrefix)
+	}
+	var closeReports func(context.Context) error
+	if url := cfg.ErrorWebhookURL; url != "" {
+		reporter := newWebhookReporter(url, serviceName, cfg.Env, m.errorReports)
+		go reporter.run()
+		errorReporter = reporter
+		closeReports = reporter.Close
+		slog.Info("error reporting enabled")
+	}
 
-	dsn := os.Getenv("DB_DSN")
+	// Listen for shutdown signals before connecting: one that arrives while
+	// the initial connect is still retrying cancels connectCtx and exits.
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	connectCtx, stopConnect := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stopConnect()
+
+	// ctx stops the loops at shutdown, and with them any reconnect in flight.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var src *dsnSource
+	dsn := cfg.DSN
 	if dsn != "" {
-		d, err := connectWithRetry(dsn, 5, 1*time.Second)
+		addLogSecret(dsn)
+		src = &dsnSource{dsn: dsn, connect: func(dsn string) (*sql.DB, error) {
+			return connectWithRetry(ctx, dsn, 5, 1*time.Second, m)
+		}}
+		d, err := connectWithRetry(connectCtx, dsn, 5, 1*time.Second, m)
+		if connectCtx.Err() != nil {
+			slog.Info("shutdown signal received while connecting to the database, exiting")
+			return
+		}
 		if err != nil {
 			slog.Error("failed to connect to database", "error", err)
 			os.Exit(1)
@@ -275,41 +1122,177 @@ func main() {
 		dbMu.Lock()
 		db = d
 		dbMu.Unlock()
-		defer func() {
-			if err := d.Close(); err != nil {
-				slog.Error("error closing db", "error", err)
-			}
-		}()
+		dbConnected.Store(true)
 		slog.Info("connected to postgres successfully")
 	} else {
 		slog.Warn("DB_DSN not set, running without database connection")
 	}
+	stopConnect()
 
-	worker := NewWorker(interval)
-	healthServer := setupHealthServer(worker, healthPort)
+	events := NewEventRecorder(serviceName, m.serviceEvents)
+	go events.run()
+	eventRecorder.Store(events)
+	recordEvent(eventStarted, map[string]any{"version": version, "commit": commit, "env": cfg.Env})
+
+	opts := []WorkerOption{
+		WithMetrics(m),
+		WithStartTime(startedAt),
+		WithQueryTimeout(cfg.QueryTimeout),
+		WithBatchSize(cfg.BatchSize),
+		WithConcurrency(cfg.Concurrency),
+		WithSchedule(cfg.Schedule),
+		WithStaleness(cfg.StalenessFactor, cfg.StalenessMin),
+		WithJitter(cfg.Jitter, nil),
+		WithMaxRowsPerSecond(cfg.MaxRowsPerSecond),
+		WithStartupDrain(cfg.StartupDrain),
+		WithShutdownGrace(cfg.ShutdownGrace),
+		WithErrorReportThreshold(cfg.ErrorReportThreshold),
+	}
+	processors, err := processorOptions(cfg)
+	if err != nil {
+		slog.Error("invalid processor configuration", "error", err)
+		os.Exit(1)
+	}
+	opts = append(opts, processors...)
+	var closeSummaries func(context.Context) error
+	if url := cfg.WebhookURL; url != "" {
+		publisher := NewBatchPublisher(url, cfg.WebhookSecret, serviceName, cfg.Env, m.webhookDeliveries)
+		go publisher.run()
+		opts = append(opts, WithBatchPublisher(publisher))
+		closeSummaries = publisher.Close
+		slog.Info("batch summaries enabled")
+	}
+	if dsn != "" {
+		opts = append(opts, WithReconnect(func(ctx context.Context) (*sql.DB, error) {
+			return src.connect(src.current())
+		}, cfg.ReconnectThreshold))
+	}
+	worker := NewWorker(cfg.Interval, opts...)
+	reloader := &configReloader{
+		load:     func() (Config, error) { return LoadConfig(os.Args[1:]) },
+		settings: reloadableSettings(worker, src),
+		running:  cfg,
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go reloader.watch(hup)
+	currentConfig = reloader.current
+	readyChecks = append(readyChecks, readinessCheck{Checker: loopChecker{worker: worker}})
+	var guard *TableGuard
+	if maxBytes := cfg.LogTableMaxBytes; maxBytes > 0 && dsn != "" {
+		guard = NewTableGuard(int64(maxBytes), cfg.LogTableEnforce, cfg.QueryTimeout, m)
+		readyChecks = append(readyChecks, readinessCheck{Checker: tableSizeChecker{guard: guard}})
+	}
+	admin := newAdminMux(worker, cfg.AdminToken)
+	var adminServer *http.Server
+	if cfg.AdminPort != 0 {
+		adminServer = newAdminServer(cfg.AdminPort, cfg.AdminToken, admin, cfg.EnablePprof)
+		admin = nil
+	}
+	healthServer := setupHealthServer(worker, prometheus.DefaultGatherer, healthPort, admin)
 
+	ln, err := net.Listen("tcp", healthServer.Addr)
+	if err != nil {
+		slog.Error("health server failed", "error", err)
+		os.Exit(1)
+	}
 	go func() {
 		slog.Info("health server starting", "port", healthPort)
-		if err := healthServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if err := healthServer.Serve(ln); err != nil && err != http.ErrServerClosed {
 			slog.Error("health server failed", "error", err)
 		}
 	}()
+	if adminServer != nil {
+		adminLn, err := net.Listen("tcp", adminServer.Addr)
+		if err != nil {
+			slog.Error("admin server failed", "error", err)
+			os.Exit(1)
+		}
+		go func() {
+			slog.Info("admin server starting", "port", cfg.AdminPort)
+			if err := adminServer.Serve(adminLn); err != nil && err != http.ErrServerClosed {
+				slog.Error("admin server failed", "error", err)
+			}
+		}()
+	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	go worker.Run(ctx)
+	var workerWG sync.WaitGroup
+	workerWG.Add(1)
+	go func() {
+		defer workerWG.Done()
+		worker.Run(ctx)
+	}()
 
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	sig := <-quit
-	slog.Info("shutdown signal received", "signal", sig.String())
+	if retention := cfg.LogRetention; retention > 0 && dsn != "" {
+		archiver, err := newArchiver(cfg)
+		if err != nil {
+			slog.Error("invalid archive configuration", "error", err)
+			os.Exit(1)
+		}
+		purger := NewPurger(retention, archiver, m)
+		workerWG.Add(1)
+		go func() {
+			defer workerWG.Done()
+			purger.Run(ctx)
+		}()
+	}
 
-	cancel() // Stop worker
+	if guard != nil {
+		workerWG.Add(1)
+		go func() {
+			defer workerWG.Done()
+			guard.Run(ctx)
+		}()
+	}
+
+	if url := cfg.ReadinessWebhookURL; url != "" {
+		watcher := newReadinessWatcher(url, serviceName, cfg.Env, cfg.ReadinessInterval, m.readyNotifications)
+		workerWG.Add(1)
+		go func() {
+			defer workerWG.Done()
+			watcher.Run(ctx)
+		}()
+	}
 
-	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer shutdownCancel()
-	if err := healthServer.Shutdown(shutdownCtx); err != nil {
-		slog.Error("health server shutdown error", "error", err)
+	pusher, err := newPusher(cfg, prometheus.DefaultGatherer, m)
+	if err != nil {
+		slog.Error("invalid pushgateway configuration", "error", err)
+		os.Exit(1)
 	}
+	if pusher != nil {
+		workerWG.Add(1)
+		go func() {
+			defer workerWG.Done()
+			pusher.Run(ctx)
+		}()
+	}
+
+	started.Store(true)
 
-	slog.Info("worker stopped gracefully")
+	seq := shutdownSequence{
+		timeout:        cfg.ShutdownTimeout,
+		stopLoops:      cancel,
+		loops:          &workerWG,
+		pusher:         pusher,
+		closeSummaries: closeSummaries,
+		healthServer:   healthServer,
+		adminServer:    adminServer,
+		closeEvents:    events.Close,
+		closeReports:   closeReports,
+		flushMetrics:   flushMetrics,
+		flushTraces:    flushTraces,
+	}
+	if dsn != "" {
+		// The supervisor may have swapped the pool, so close whichever is current.
+		seq.closeDB = func() error {
+			dbMu.RLock()
+			d := db
+			dbMu.RUnlock()
+			if d == nil {
+				return nil
+			}
+			return d.Close()
+		}
+	}
+	seq.run(quit)
 }
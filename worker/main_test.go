@@ -2,13 +2,19 @@ package main
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
 	"errors"
+	"math/rand/v2"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 )
 
 func TestLiveHandler_ReturnsOK(t *testing.T) {
@@ -21,9 +27,15 @@ func TestLiveHandler_ReturnsOK(t *testing.T) {
 		t.Errorf("expected status 200, got %d", rec.Code)
 	}
 
-	expectedBody := `{"status":"ok"}`
-	if rec.Body.String() != expectedBody {
-		t.Errorf("expected body '%s', got '%s'", expectedBody, rec.Body.String())
+	var resp HealthResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if resp.Status != "ok" || resp.Service != "worker" || resp.Version != "dev" {
+		t.Errorf("unexpected health response %+v", resp)
+	}
+	if _, err := time.Parse(time.RFC3339, resp.Timestamp); err != nil {
+		t.Errorf("expected RFC3339 timestamp, got %q", resp.Timestamp)
 	}
 
 	ct := rec.Header().Get("Content-Type")
@@ -52,14 +64,23 @@ func TestReadyHandler_NoDB(t *testing.T) {
 }
 
 func TestWorker_IsHealthy(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+
 	// Worker is healthy initially
-	w := NewWorker(1 * time.Second)
+	w := NewWorker(1*time.Second, WithClock(clock), WithStaleness(3, 0))
 	if !w.IsHealthy() {
 		t.Errorf("expected worker to be healthy initially")
 	}
 
+	// Worker isn't stale right up to 3x the interval
+	w.lastRunAt = clock.Now()
+	clock.Advance(3 * time.Second)
+	if !w.IsHealthy() {
+		t.Errorf("expected worker to be healthy at exactly 3x the interval")
+	}
+
 	// Worker is unhealthy if last run was more than 3x the interval ago
-	w.lastRunAt = time.Now().Add(-4 * time.Second)
+	clock.Advance(time.Second)
 	if w.IsHealthy() {
 		t.Errorf("expected worker to be unhealthy due to staleness")
 	}
@@ -71,11 +92,141 @@ func TestWorker_IsHealthy(t *testing.T) {
 	}
 }
 
+func TestWorker_StalenessMinimumGrace(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	// With a 100ms interval the default minimum (10s) dominates 3x interval.
+	w := NewWorker(100*time.Millisecond, WithClock(clock))
+	if got := w.stalenessThreshold(); got != defaultStalenessMin {
+		t.Errorf("expected threshold %v, got %v", defaultStalenessMin, got)
+	}
+	w.lastRunAt = clock.Now()
+	clock.Advance(400 * time.Millisecond)
+	if !w.IsHealthy() {
+		t.Error("expected a short pause not to flag a fast-polling worker")
+	}
+	clock.Advance(10 * time.Second)
+	if w.IsHealthy() {
+		t.Error("expected worker to be stale past the minimum grace period")
+	}
+}
+
+func TestWorker_StalenessFactor(t *testing.T) {
+	w := NewWorker(time.Minute, WithStaleness(1.5, time.Second))
+	if got := w.stalenessThreshold(); got != 90*time.Second {
+		t.Errorf("expected 90s threshold, got %v", got)
+	}
+}
+
+func TestWorker_StatsStaleness(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	w := NewWorker(10*time.Second, WithClock(clock))
+
+	stats := w.Stats()
+	if stats.Stale || stats.StaleDeadline != "" {
+		t.Errorf("expected no deadline before the first run, got %+v", stats)
+	}
+	if stats.StaleThreshold != "30s" {
+		t.Errorf("expected 30s threshold, got %s", stats.StaleThreshold)
+	}
+
+	w.lastRunAt = clock.Now()
+	clock.Advance(31 * time.Second)
+	stats = w.Stats()
+	if !stats.Stale {
+		t.Error("expected stats to report stale")
+	}
+	if stats.StaleDeadline != "2024-01-01T00:00:30Z" {
+		t.Errorf("expected deadline 2024-01-01T00:00:30Z, got %s", stats.StaleDeadline)
+	}
+}
+
+func TestWorker_RunBatchGauges(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer func() { _ = mockDB.Close() }()
+	dbMu.Lock()
+	db = mockDB
+	dbMu.Unlock()
+
+	clock := newFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	w := NewWorker(30*time.Second, WithClock(clock))
+	if got := testutil.ToFloat64(w.metrics.intervalSeconds); got != 30 {
+		t.Errorf("expected worker_interval_seconds 30, got %v", got)
+	}
+
+	expectBatch(mock, 3)
+	if _, err := w.runBatch(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := testutil.ToFloat64(w.metrics.lastRunTimestamp); got != float64(clock.Now().Unix()) {
+		t.Errorf("expected last run timestamp %d, got %v", clock.Now().Unix(), got)
+	}
+	if got := testutil.ToFloat64(w.metrics.lastBatchRows); got != 3 {
+		t.Errorf("expected worker_last_batch_rows 3, got %v", got)
+	}
+	stats := w.Stats()
+	if stats.LastBatchRows != 3 || stats.IntervalSeconds != 30 {
+		t.Errorf("expected last_batch_rows 3 and interval_seconds 30, got %+v", stats)
+	}
+}
+
+func TestWorker_StatsUptime(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	w := NewWorker(time.Second, WithClock(clock), WithStartTime(clock.Now().Add(-time.Hour)))
+	clock.Advance(90 * time.Second)
+
+	stats := w.Stats()
+	if stats.StartedAt != "2023-12-31T23:00:00Z" || stats.Uptime != "1h1m30s" {
+		t.Errorf("expected started_at 2023-12-31T23:00:00Z and uptime 1h1m30s, got %q, %q", stats.StartedAt, stats.Uptime)
+	}
+}
+
+func TestWorker_JitterBand(t *testing.T) {
+	interval := 2 * time.Second
+	w := NewWorker(interval, WithJitter(0.2, rand.New(rand.NewPCG(1, 2))))
+
+	lo, hi := 1600*time.Millisecond, 2400*time.Millisecond
+	seen := map[time.Duration]bool{}
+	for i := 0; i < 1000; i++ {
+		d := w.idleInterval()
+		if d < lo || d > hi {
+			t.Fatalf("sleep %v outside the ±20%% band [%v, %v]", d, lo, hi)
+		}
+		seen[d] = true
+	}
+	if len(seen) < 100 {
+		t.Errorf("expected jittered sleeps to vary, got %d distinct values", len(seen))
+	}
+}
+
+func TestWorker_JitterDisabled(t *testing.T) {
+	w := NewWorker(2 * time.Second)
+	if d := w.idleInterval(); d != 2*time.Second {
+		t.Errorf("expected exact interval without jitter, got %v", d)
+	}
+	w = NewWorker(2*time.Second, WithJitter(1.5, nil))
+	if w.jitter != 0 {
+		t.Errorf("expected out-of-range jitter to be ignored, got %v", w.jitter)
+	}
+}
+
+func TestWorker_JitterStalenessThreshold(t *testing.T) {
+	w := NewWorker(10*time.Second, WithJitter(0.5, nil), WithStaleness(3, 0))
+	if got := w.stalenessThreshold(); got != 45*time.Second {
+		t.Errorf("expected threshold based on the longest jittered interval (45s), got %v", got)
+	}
+}
+
 func TestWorker_BatchSize(t *testing.T) {
 	w := NewWorker(1 * time.Second)
 	if w.batchSize != defaultBatchSize {
 		t.Errorf("expected default batch size %d, got %d", defaultBatchSize, w.batchSize)
 	}
+	w = NewWorker(time.Second, WithBatchSize(250))
+	if w.batchSize != 250 {
+		t.Errorf("expected batch size 250, got %d", w.batchSize)
+	}
 }
 
 func TestServer_GracefulShutdown(t *testing.T) {
@@ -84,7 +235,7 @@ func TestServer_GracefulShutdown(t *testing.T) {
 	dbMu.Unlock()
 
 	worker := NewWorker(100 * time.Millisecond)
-	healthServer := setupHealthServer(worker, "8889")
+	healthServer := setupHealthServer(worker, prometheus.NewRegistry(), "8889", nil)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	go worker.Run(ctx)
@@ -148,6 +299,66 @@ func TestReadyHandler_DBPingError(t *testing.T) {
 	}
 }
 
+func TestStartupHandler_DoesNotRegress(t *testing.T) {
+	started.Store(false)
+	defer started.Store(false)
+
+	rec := httptest.NewRecorder()
+	startupHandler(rec, httptest.NewRequest(http.MethodGet, "/startup", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 before initialization, got %d", rec.Code)
+	}
+
+	mockDB, mock, _ := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	defer func() { _ = mockDB.Close() }()
+	dbMu.Lock()
+	db = mockDB
+	dbMu.Unlock()
+	mock.ExpectPing().WillReturnError(errors.New("db down"))
+
+	started.Store(true)
+
+	rec = httptest.NewRecorder()
+	readyHandler(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected /ready 503 with a failing db, got %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	startupHandler(rec, httptest.NewRequest(http.MethodGet, "/startup", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected /startup to stay 200 after db failures, got %d", rec.Code)
+	}
+}
+
+func TestReadyHandler_DBPingTimeout(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	defer func() { _ = mockDB.Close() }()
+
+	dbMu.Lock()
+	db = mockDB
+	dbMu.Unlock()
+	mock.ExpectPing().WillDelayFor(5 * time.Second)
+
+	prev := readyPingTimeout
+	readyPingTimeout = 50 * time.Millisecond
+	defer func() { readyPingTimeout = prev }()
+
+	req := httptest.NewRequest(http.MethodGet, "/ready", nil)
+	rec := httptest.NewRecorder()
+	start := time.Now()
+	readyHandler(rec, req)
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected handler to return within the ping budget, took %v", elapsed)
+	}
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503, got %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "db ping timeout") {
+		t.Errorf("expected ping timeout message, got %s", rec.Body.String())
+	}
+}
+
 func TestProcessLogs_Success(t *testing.T) {
 	mockDB, mock, _ := sqlmock.New()
 	defer func() { _ = mockDB.Close() }()
@@ -157,9 +368,15 @@ func TestProcessLogs_Success(t *testing.T) {
 
 	w := NewWorker(1 * time.Second)
 
-	mock.ExpectExec("UPDATE api_logs").WillReturnResult(sqlmock.NewResult(0, 5))
+	expectBatch(mock, 5)
 
-	w.processLogs()
+	processed, err := w.processLogs(context.Background())
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if processed.rows != 5 {
+		t.Errorf("expected 5 processed rows, got %d", processed.rows)
+	}
 
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("unfulfilled mock: %s", err)
@@ -175,15 +392,206 @@ func TestProcessLogs_Error(t *testing.T) {
 
 	w := NewWorker(1 * time.Second)
 
-	mock.ExpectExec("UPDATE api_logs").WillReturnError(errors.New("db update failed"))
+	expectBatchError(mock, errors.New("db update failed"))
 
-	w.processLogs()
+	if _, err := w.processLogs(context.Background()); err == nil {
+		t.Error("expected error from failed update, got nil")
+	}
 
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("unfulfilled mock: %s", err)
 	}
 }
 
+func TestProcessLogs_Timeout(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer func() { _ = mockDB.Close() }()
+	dbMu.Lock()
+	db = mockDB
+	dbMu.Unlock()
+
+	w := NewWorker(1*time.Second, WithQueryTimeout(50*time.Millisecond))
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(claimQuery).WillDelayFor(5 * time.Second).WillReturnRows(processedRows(5))
+
+	before := testutil.ToFloat64(w.metrics.batchTimeouts)
+	start := time.Now()
+	_, err := w.processLogs(context.Background())
+	if err == nil {
+		t.Fatal("expected timeout error, got nil")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected statement to be aborted by the timeout, took %v", elapsed)
+	}
+	if got := testutil.ToFloat64(w.metrics.batchTimeouts) - before; got != 1 {
+		t.Errorf("expected timeout counter to increase by 1, got %v", got)
+	}
+	if w.IsHealthy() {
+		t.Error("expected worker to be unhealthy after a timed out batch")
+	}
+}
+
+func TestProcessLogs_ContextCancelled(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer func() { _ = mockDB.Close() }()
+	dbMu.Lock()
+	db = mockDB
+	dbMu.Unlock()
+
+	w := NewWorker(1 * time.Second)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(claimQuery).WillDelayFor(5 * time.Second).WillReturnRows(processedRows(5))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	beforeTimeouts := testutil.ToFloat64(w.metrics.batchTimeouts)
+	beforeErrors := testutil.ToFloat64(w.metrics.batchErrors)
+	start := time.Now()
+	_, err := w.processLogs(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected statement to be cancelled promptly, took %v", elapsed)
+	}
+	if testutil.ToFloat64(w.metrics.batchTimeouts) != beforeTimeouts || testutil.ToFloat64(w.metrics.batchErrors) != beforeErrors {
+		t.Error("expected shutdown cancellation not to be counted as a batch error")
+	}
+}
+
+func TestProcessLogs_NoDB(t *testing.T) {
+	dbMu.Lock()
+	db = nil
+	dbMu.Unlock()
+
+	w := NewWorker(1 * time.Second)
+	if _, err := w.processLogs(context.Background()); !errors.Is(err, errDBNotConnected) {
+		t.Errorf("expected errDBNotConnected, got %v", err)
+	}
+}
+
+func TestWorker_RunStopsDuringSlowBatch(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer func() { _ = mockDB.Close() }()
+	dbMu.Lock()
+	db = mockDB
+	dbMu.Unlock()
+
+	// With no shutdown grace the batch in flight is cancelled at once.
+	w := NewWorker(1*time.Second, WithShutdownGrace(0))
+	mock.ExpectBegin()
+	mock.ExpectQuery(claimQuery).WillDelayFor(5 * time.Second).WillReturnRows(processedRows(5))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.Run(ctx)
+		close(done)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected Run to return promptly after cancellation")
+	}
+}
+
+func TestProcessLogs_ConcurrentPartitionsAreDisjoint(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer func() { _ = mockDB.Close() }()
+	dbMu.Lock()
+	db = mockDB
+	dbMu.Unlock()
+
+	w := NewWorker(1*time.Second, WithConcurrency(2))
+
+	// Each goroutine must claim its own id partition; if two batches used
+	// the same partition, one of these expectations would go unmatched.
+	mock.MatchExpectationsInOrder(false)
+	mock.ExpectBegin()
+	mock.ExpectQuery(claimQuery).
+		WithArgs(defaultBatchSize, 2, 0).
+		WillDelayFor(100 * time.Millisecond).
+		WillReturnRows(processedRows(3))
+	expectMark(mock, 3)
+	mock.ExpectBegin()
+	mock.ExpectQuery(claimQuery).
+		WithArgs(defaultBatchSize, 2, 1).
+		WillDelayFor(100 * time.Millisecond).
+		WillReturnRows(processedRows(4))
+	expectMark(mock, 4)
+
+	start := time.Now()
+	processed, err := w.processLogs(context.Background())
+	elapsed := time.Since(start)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if processed.rows != 7 {
+		t.Errorf("expected 7 processed rows across partitions, got %d", processed.rows)
+	}
+	if elapsed >= 190*time.Millisecond {
+		t.Errorf("expected batches to run in parallel, took %v", elapsed)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled mock: %s", err)
+	}
+}
+
+func TestProcessLogs_ConcurrentPartialFailure(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer func() { _ = mockDB.Close() }()
+	dbMu.Lock()
+	db = mockDB
+	dbMu.Unlock()
+
+	w := NewWorker(1*time.Second, WithConcurrency(2))
+
+	mock.MatchExpectationsInOrder(false)
+	mock.ExpectBegin()
+	mock.ExpectQuery(claimQuery).WithArgs(defaultBatchSize, 2, 0).WillReturnRows(processedRows(3))
+	expectMark(mock, 3)
+	mock.ExpectBegin()
+	mock.ExpectQuery(claimQuery).WithArgs(defaultBatchSize, 2, 1).WillReturnError(errors.New("partition failed"))
+	mock.ExpectRollback()
+
+	processed, err := w.processLogs(context.Background())
+	if err == nil {
+		t.Error("expected error when one partition fails")
+	}
+	if processed.rows != 3 {
+		t.Errorf("expected rows from the successful partition to be counted, got %d", processed.rows)
+	}
+	if w.IsHealthy() {
+		t.Error("expected worker to be unhealthy after a partition failure")
+	}
+}
+
+func TestWorker_ErrorBackoff(t *testing.T) {
+	w := NewWorker(2 * time.Second)
+	cases := []struct {
+		errors int
+		want   time.Duration
+	}{
+		{1, 2 * time.Second},
+		{2, 4 * time.Second},
+		{3, 8 * time.Second},
+		{10, maxErrorBackoff},
+	}
+	for _, c := range cases {
+		w.consecutiveErrors = c.errors
+		if got := w.errorBackoff(); got != c.want {
+			t.Errorf("consecutiveErrors=%d: expected %v, got %v", c.errors, c.want, got)
+		}
+	}
+}
+
 type errorResponseWriter struct {
 	http.ResponseWriter
 }
@@ -214,20 +622,83 @@ func TestReadyHandler_WriteError(t *testing.T) {
 	readyHandler(errRec, req)
 }
 
-func TestGetWorkerInterval_Default(t *testing.T) {
-	t.Setenv("WORKER_INTERVAL", "")
-	interval := getWorkerInterval()
-	if interval != 2*time.Second {
-		t.Errorf("expected 2s default, got %v", interval)
+func TestBatchResult(t *testing.T) {
+	at := func(sec int) sql.NullTime {
+		return sql.NullTime{Time: processedCreatedAt.Add(time.Duration(sec) * time.Second), Valid: true}
+	}
+	var a, b, total batchResult
+	a.add(at(5))
+	a.add(at(2))
+	a.add(sql.NullTime{}) // a NULL created_at is counted but has no time
+	b.add(at(9))
+	total.merge(a)
+	total.merge(batchResult{})
+	total.merge(b)
+
+	if total.rows != 4 || !total.oldest.Equal(at(2).Time) || !total.newest.Equal(at(9).Time) {
+		t.Errorf("expected 4 rows from +2s to +9s, got %+v", total)
+	}
+}
+
+// claimQuery matches the SELECT that locks a batch.
+const claimQuery = "SELECT id, method, endpoint, status, duration_ms, remote_addr, created_at FROM api_logs"
+
+// processedCreatedAt is the created_at of the first row processedRows
+// returns; each row after it is a second younger.
+var processedCreatedAt = time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+
+// processedRows builds the claimed rows of a batch of n successful /live
+// requests, with ids 1 to n.
+func processedRows(n int) *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{"id", "method", "endpoint", "status", "duration_ms", "remote_addr", "created_at"})
+	for i := 0; i < n; i++ {
+		rows.AddRow(i+1, "GET", "/live", 200, 1.5, "10.0.0.1", processedCreatedAt.Add(time.Duration(i)*time.Second))
+	}
+	return rows
+}
+
+// processedIDs returns the ids 1 to n, as processedRows claims them.
+func processedIDs(n int) []int64 {
+	ids := make([]int64, n)
+	for i := range ids {
+		ids[i] = int64(i + 1)
 	}
+	return ids
 }
 
-func TestGetWorkerInterval_Custom(t *testing.T) {
-	t.Setenv("WORKER_INTERVAL", "5s")
-	interval := getWorkerInterval()
-	if interval != 5*time.Second {
-		t.Errorf("expected 5s, got %v", interval)
+// markedRows builds the RETURNING result of marking ids.
+func markedRows(ids []int64) *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{"id"})
+	for _, id := range ids {
+		rows.AddRow(id)
 	}
+	return rows
+}
+
+// expectBatch expects the transaction of a batch that claims n rows and
+// marks all of them.
+func expectBatch(mock sqlmock.Sqlmock, n int) {
+	mock.ExpectBegin()
+	mock.ExpectQuery(claimQuery).WillReturnRows(processedRows(n))
+	expectMark(mock, n)
+}
+
+// expectMark expects the rest of a batch that claimed processedRows(n):
+// the UPDATE of their ids, unless there are none, and the commit.
+func expectMark(mock sqlmock.Sqlmock, n int) {
+	if n > 0 {
+		ids := processedIDs(n)
+		mock.ExpectQuery("UPDATE api_logs").WithArgs(idArray(ids)).WillReturnRows(markedRows(ids))
+	}
+	mock.ExpectCommit()
+}
+
+// expectBatchError expects a batch whose claiming SELECT fails with err
+// and rolls back.
+func expectBatchError(mock sqlmock.Sqlmock, err error) {
+	mock.ExpectBegin()
+	mock.ExpectQuery(claimQuery).WillReturnError(err)
+	mock.ExpectRollback()
 }
 
 // waitForServer polls the given URL until it gets a response or times out.
@@ -257,8 +728,46 @@ func TestWorker_LastRunAt(t *testing.T) {
 }
 
 func TestConnectWithRetry_InvalidDSN(t *testing.T) {
-	_, err := connectWithRetry("invalid-dsn", 2, 10*time.Millisecond)
+	m, _ := newTestMetrics(t)
+	_, err := connectWithRetry(context.Background(), "invalid-dsn", 2, 10*time.Millisecond, m)
 	if err == nil {
 		t.Error("expected error for invalid DSN, got nil")
 	}
+	if got := testutil.ToFloat64(m.dbConnectRetries); got != 2 {
+		t.Errorf("expected 2 retries counted, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.dbConnectFailures); got != 1 {
+		t.Errorf("expected 1 exhausted connect counted, got %v", got)
+	}
+}
+
+func TestConnectWithRetry_Cancelled(t *testing.T) {
+	m, _ := newTestMetrics(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	// An unreachable address keeps every attempt failing, and the delay
+	// would make the retries take far longer than the test allows.
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	_, err := connectWithRetry(ctx, "postgres://app@127.0.0.1:1/app?connect_timeout=1", 5, time.Minute, m)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected the backoff cut short, took %v", elapsed)
+	}
+	if got := testutil.ToFloat64(m.dbConnectFailures); got != 0 {
+		t.Errorf("expected an abandoned connect not counted as exhausted, got %v", got)
+	}
+
+	// Already cancelled: no attempt is retried at all.
+	start = time.Now()
+	if _, err := connectWithRetry(ctx, "postgres://app@127.0.0.1:1/app", 5, time.Minute, m); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected a prompt return, took %v", elapsed)
+	}
+	if got := testutil.ToFloat64(m.dbConnectRetries); got != 1 {
+		t.Errorf("expected only the first attempt counted as a retry, got %v", got)
+	}
 }
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// methods restricts next to the allowed HTTP methods, answering anything
+// else with a 405 JSON error and an Allow header. HEAD, when allowed, runs
+// next with the body discarded so headers match the GET response.
+func methods(next http.HandlerFunc, allowed ...string) http.HandlerFunc {
+	allow := strings.Join(allowed, ", ")
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !slices.Contains(allowed, r.Method) {
+			w.Header().Set("Allow", allow)
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
+			return
+		}
+		if r.Method == http.MethodHead {
+			w = headResponseWriter{w}
+		}
+		next(w, r)
+	}
+}
+
+// headResponseWriter drops the body of a HEAD response.
+type headResponseWriter struct {
+	http.ResponseWriter
+}
+
+func (w headResponseWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestMethods_DisallowedOnEveryRoute(t *testing.T) {
+	w := NewWorker(time.Second)
+	handler := setupHealthServer(w, prometheus.NewRegistry(), "0", newAdminMux(w, "")).Handler
+	routes := map[string]string{
+		"/live":         "GET, HEAD",
+		"/ready":        "GET, HEAD",
+		"/startup":      "GET, HEAD",
+		"/healthz":      "GET, HEAD",
+		"/version":      "GET, HEAD",
+		"/stats":        "GET, HEAD",
+		"/metrics":      "GET, HEAD",
+		"/admin/pause":  "POST",
+		"/admin/resume": "POST",
+	}
+	all := []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions}
+
+	for path, allow := range routes {
+		for _, method := range all {
+			if method == http.MethodGet || method == http.MethodHead {
+				if allow != "POST" {
+					continue
+				}
+			} else if method == http.MethodPost && allow == "POST" {
+				continue
+			}
+			t.Run(method+" "+path, func(t *testing.T) {
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+
+				if rec.Code != http.StatusMethodNotAllowed {
+					t.Errorf("expected 405, got %d", rec.Code)
+				}
+				if got := rec.Header().Get("Allow"); got != allow {
+					t.Errorf("expected Allow '%s', got '%s'", allow, got)
+				}
+			})
+		}
+	}
+}
+
+func TestMethods_HeadLive(t *testing.T) {
+	handler := setupHealthServer(NewWorker(time.Second), prometheus.NewRegistry(), "0", nil).Handler
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodHead, "/live", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected 200, got %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected Content-Type application/json, got '%s'", ct)
+	}
+	if rec.Body.Len() != 0 {
+		t.Errorf("expected an empty body for HEAD, got %q", rec.Body.String())
+	}
+}
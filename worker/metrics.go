@@ -0,0 +1,369 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	// metricsMaxRequestsInFlight caps concurrent scrapes; extra ones get 503
+	// instead of piling up behind a slow gather.
+	metricsMaxRequestsInFlight = 3
+	// metricsScrapeTimeout bounds a single scrape, inside the server's
+	// 10s write timeout.
+	metricsScrapeTimeout = 5 * time.Second
+)
+
+// defaultDurationBuckets span quick empty polls up to the query timeout.
+var defaultDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// metrics holds the worker's Prometheus collectors. main registers one set
+// on the default registry; tests use isolated registries.
+type metrics struct {
+	logsProcessed      prometheus.Counter
+	processingDuration prometheus.Histogram
+	batchErrors        prometheus.Counter
+	batchTimeouts      prometheus.Counter
+	processedByStatus  *prometheus.CounterVec
+	processorDuration  *prometheus.HistogramVec
+	processorErrors    *prometheus.CounterVec
+	logsPurged         prometheus.Counter
+	logsArchived       prometheus.Counter
+	archiveFailures    prometheus.Counter
+	tableSize          prometheus.Gauge
+	tableOverLimit     prometheus.Gauge
+	tablePurged        prometheus.Counter
+	dbReconnects       prometheus.Counter
+	nextRunTimestamp   prometheus.Gauge
+	paused             prometheus.Gauge
+	drainMode          prometheus.Gauge
+	shutdownClean      prometheus.Gauge
+	lastRunTimestamp   prometheus.Gauge
+	lastBatchRows      prometheus.Gauge
+	intervalSeconds    prometheus.Gauge
+	pushFailures       prometheus.Counter
+	dbConnectRetries   prometheus.Counter
+	dbConnectFailures  prometheus.Counter
+	otlpPushFailures   prometheus.Counter
+	errorReports       *prometheus.CounterVec
+	readyNotifications *prometheus.CounterVec
+	webhookDeliveries  *prometheus.CounterVec
+	serviceEvents      *prometheus.CounterVec
+	statsdFailures     prometheus.Counter
+	buildInfo          *prometheus.GaugeVec
+
+	// statsd mirrors the key metrics to a statsd agent when STATSD_ADDR is
+	// set; nil otherwise.
+	statsd *statsdClient
+}
+
+// newMetrics creates the worker collectors, plus build info and DB pool
+// stats, and registers them on reg.
+func newMetrics(reg prometheus.Registerer, durationBuckets []float64) *metrics {
+	m := &metrics{
+		logsProcessed: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "worker_logs_processed_total",
+				Help: "Total number of log entries processed by the worker",
+			},
+		),
+		processingDuration: prometheus.NewHistogram(
+			prometheus.HistogramOpts{
+				Name:    "worker_processing_duration_seconds",
+				Help:    "Duration of each worker batch processing cycle",
+				Buckets: durationBuckets,
+			},
+		),
+		batchErrors: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "worker_batch_errors_total",
+				Help: "Total number of batch processing errors",
+			},
+		),
+		batchTimeouts: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "worker_batch_timeouts_total",
+				Help: "Total number of batches aborted by the query timeout",
+			},
+		),
+		processedByStatus: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "worker_processed_by_status_total",
+				Help: "Processed log entries by endpoint route and status class",
+			},
+			[]string{"endpoint", "status_class"},
+		),
+		processorDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "worker_processor_duration_seconds",
+				Help:    "Time each WORKER_PROCESSORS step took per batch",
+				Buckets: durationBuckets,
+			},
+			[]string{"processor"},
+		),
+		processorErrors: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "worker_processor_errors_total",
+				Help: "Total number of batches a WORKER_PROCESSORS step failed, leaving them unprocessed",
+			},
+			[]string{"processor"},
+		),
+		logsPurged: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "worker_logs_purged_total",
+				Help: "Total number of log entries deleted by the retention purge",
+			},
+		),
+		tableSize: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "worker_table_size_bytes",
+				Help: "Total size of api_logs, indexes and TOAST included, at the last size check",
+			},
+		),
+		tableOverLimit: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "worker_table_over_limit",
+				Help: "Whether api_logs was over LOG_TABLE_MAX_BYTES at the last size check (1) or not (0)",
+			},
+		),
+		tablePurged: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "worker_table_emergency_purged_total",
+				Help: "Total number of processed log entries deleted to bring api_logs back under LOG_TABLE_MAX_BYTES",
+			},
+		),
+		logsArchived: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "worker_logs_archived_total",
+				Help: "Total number of log entries written to archive files",
+			},
+		),
+		archiveFailures: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "worker_archive_failures_total",
+				Help: "Total number of archive writes that failed, skipping deletion",
+			},
+		),
+		dbReconnects: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "worker_db_reconnects_total",
+				Help: "Total number of times the worker replaced a broken connection pool",
+			},
+		),
+		nextRunTimestamp: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "worker_next_run_timestamp",
+				Help: "Unix timestamp of the next planned processing run",
+			},
+		),
+		paused: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "worker_paused",
+				Help: "Whether batch processing is paused via the admin endpoint (1) or running (0)",
+			},
+		),
+		drainMode: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "worker_drain_mode",
+				Help: "Whether the worker is draining the backlog at startup (1) or pacing by the interval (0)",
+			},
+		),
+		shutdownClean: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "worker_shutdown_clean",
+				Help: "Set when the worker stops: 1 if no batch was in flight or it committed or rolled back within WORKER_SHUTDOWN_GRACE, 0 if it was cancelled",
+			},
+		),
+		lastRunTimestamp: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "worker_last_run_timestamp_seconds",
+				Help: "Unix timestamp of the last completed batch",
+			},
+		),
+		lastBatchRows: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "worker_last_batch_rows",
+				Help: "Number of rows processed by the last batch",
+			},
+		),
+		intervalSeconds: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "worker_interval_seconds",
+				Help: "Configured polling interval in seconds",
+			},
+		),
+		pushFailures: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "worker_pushgateway_failures_total",
+				Help: "Total number of failed Pushgateway pushes and deletes",
+			},
+		),
+		dbConnectRetries: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "db_connect_retries_total",
+				Help: "Total number of failed database connection attempts",
+			},
+		),
+		dbConnectFailures: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "db_connect_failures_total",
+				Help: "Total number of times all database connection retries were exhausted",
+			},
+		),
+		otlpPushFailures: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "otlp_metrics_push_failures_total",
+				Help: "Total number of failed OTLP metrics pushes",
+			},
+		),
+		errorReports: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "error_reports_total",
+				Help: "Total number of error reports by outcome: sent, failed, rate_limited or queue_full",
+			},
+			[]string{"outcome"},
+		),
+		readyNotifications: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "readiness_notifications_total",
+				Help: "Total number of readiness change notifications by outcome: sent or failed",
+			},
+			[]string{"outcome"},
+		),
+		serviceEvents: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "service_events_total",
+				Help: "Total number of lifecycle events by outcome: written, failed or dropped",
+			},
+			[]string{"outcome"},
+		),
+		webhookDeliveries: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "worker_webhook_deliveries_total",
+				Help: "Total number of batch summaries by outcome: sent, failed or dropped",
+			},
+			[]string{"outcome"},
+		),
+		statsdFailures: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "statsd_send_failures_total",
+				Help: "Total number of statsd packets that failed to send",
+			},
+		),
+		buildInfo: newBuildInfo(),
+	}
+	registerOrReuse(reg, &m.logsProcessed)
+	registerOrReuse(reg, &m.processingDuration)
+	registerOrReuse(reg, &m.batchErrors)
+	registerOrReuse(reg, &m.batchTimeouts)
+	registerOrReuse(reg, &m.processedByStatus)
+	registerOrReuse(reg, &m.processorDuration)
+	registerOrReuse(reg, &m.processorErrors)
+	registerOrReuse(reg, &m.logsPurged)
+	registerOrReuse(reg, &m.tableSize)
+	registerOrReuse(reg, &m.tableOverLimit)
+	registerOrReuse(reg, &m.tablePurged)
+	registerOrReuse(reg, &m.logsArchived)
+	registerOrReuse(reg, &m.archiveFailures)
+	registerOrReuse(reg, &m.dbReconnects)
+	registerOrReuse(reg, &m.nextRunTimestamp)
+	registerOrReuse(reg, &m.paused)
+	registerOrReuse(reg, &m.drainMode)
+	registerOrReuse(reg, &m.shutdownClean)
+	registerOrReuse(reg, &m.lastRunTimestamp)
+	registerOrReuse(reg, &m.lastBatchRows)
+	registerOrReuse(reg, &m.intervalSeconds)
+	registerOrReuse(reg, &m.pushFailures)
+	registerOrReuse(reg, &m.dbConnectRetries)
+	registerOrReuse(reg, &m.dbConnectFailures)
+	registerOrReuse(reg, &m.otlpPushFailures)
+	registerOrReuse(reg, &m.errorReports)
+	registerOrReuse(reg, &m.readyNotifications)
+	registerOrReuse(reg, &m.webhookDeliveries)
+	registerOrReuse(reg, &m.serviceEvents)
+	registerOrReuse(reg, &m.statsdFailures)
+	registerOrReuse(reg, &m.buildInfo)
+	dbStats := newDBStatsCollector()
+	registerOrReuse(reg, &dbStats)
+	return m
+}
+
+// registerOrReuse registers *c on reg. When reg already holds an equal
+// collector, as it does when the metrics are built twice in one binary, *c
+// is pointed at that one instead so both sets update the same series. Any
+// other registration error is a programming error and panics, as
+// MustRegister would.
+func registerOrReuse[C prometheus.Collector](reg prometheus.Registerer, c *C) {
+	err := reg.Register(*c)
+	if err == nil {
+		return
+	}
+	var already prometheus.AlreadyRegisteredError
+	if errors.As(err, &already) {
+		if existing, ok := already.ExistingCollector.(C); ok {
+			*c = existing
+			return
+		}
+	}
+	panic(err)
+}
+
+// newMetricsHandler serves gatherer in the text or OpenMetrics format,
+// whichever the scraper asks for, gzip-compressed when it accepts gzip.
+func newMetricsHandler(gatherer prometheus.Gatherer) http.Handler {
+	return promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{
+		EnableOpenMetrics:   true,
+		OfferedCompressions: []promhttp.Compression{promhttp.Identity, promhttp.Gzip},
+		MaxRequestsInFlight: metricsMaxRequestsInFlight,
+		Timeout:             metricsScrapeTimeout,
+	})
+}
+
+// The methods below update a metric the statsd emitter mirrors, in
+// Prometheus and, when enabled, statsd, so call sites stay single lines.
+
+// addProcessed counts rows marked processed.
+func (m *metrics) addProcessed(n int) {
+	m.logsProcessed.Add(float64(n))
+	m.statsd.count("worker.logs_processed", int64(n))
+}
+
+// observeBatch records how long one batch statement took.
+func (m *metrics) observeBatch(d time.Duration) {
+	m.processingDuration.Observe(d.Seconds())
+	m.statsd.timing("worker.batch.duration", d)
+}
+
+// countBatchError counts a failed batch statement.
+func (m *metrics) countBatchError() {
+	m.batchErrors.Inc()
+	m.statsd.count("worker.batch.errors", 1)
+}
+
+// observeProcessor records how long processor took on one batch, and its
+// failure when err is set.
+func (m *metrics) observeProcessor(processor string, d time.Duration, err error) {
+	tag := statsdTag("processor", processor)
+	m.processorDuration.WithLabelValues(processor).Observe(d.Seconds())
+	m.statsd.timing("worker.processor.duration", d, tag)
+	if err != nil {
+		m.processorErrors.WithLabelValues(processor).Inc()
+		m.statsd.count("worker.processor.errors", 1, tag)
+	}
+}
+
+// setLastBatchRows records the rows processed by the last cycle.
+func (m *metrics) setLastBatchRows(n int) {
+	m.lastBatchRows.Set(float64(n))
+	m.statsd.gauge("worker.last_batch_rows", float64(n))
+}
+
+// setBacklog records the unprocessed rows counted for /healthz. It has no
+// Prometheus counterpart: scrapers read the backlog from /healthz itself.
+func (m *metrics) setBacklog(n int64) {
+	m.statsd.gauge("worker.backlog", float64(n))
+}
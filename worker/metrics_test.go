@@ -0,0 +1,160 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// newTestMetrics returns worker metrics on an isolated registry.
+func newTestMetrics(t *testing.T) (*metrics, *prometheus.Registry) {
+	t.Helper()
+	reg := prometheus.NewRegistry()
+	return newMetrics(reg, defaultDurationBuckets), reg
+}
+
+func TestNewMetrics_Names(t *testing.T) {
+	m, reg := newTestMetrics(t)
+	m.logsProcessed.Inc()
+	m.processingDuration.Observe(0.1)
+	m.processedByStatus.WithLabelValues("/api/v1/time", "2xx").Inc()
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("gather failed: %v", err)
+	}
+	var names []string
+	for _, f := range families {
+		names = append(names, f.GetName())
+	}
+	for _, want := range []string{"worker_logs_processed_total", "worker_processing_duration_seconds", "worker_processed_by_status_total", "worker_paused", "build_info"} {
+		if !slices.Contains(names, want) {
+			t.Errorf("expected %s to be registered, got %v", want, names)
+		}
+	}
+}
+
+func TestNewWorker_DefaultMetrics(t *testing.T) {
+	// Workers without WithMetrics get their own collectors, so two of them
+	// must not collide.
+	a, b := NewWorker(time.Second), NewWorker(time.Second)
+	if a.metrics == nil || a.metrics == b.metrics {
+		t.Error("expected each worker to get its own metrics")
+	}
+}
+
+func TestWithMetrics(t *testing.T) {
+	m, _ := newTestMetrics(t)
+	if w := NewWorker(time.Second, WithMetrics(m)); w.metrics != m {
+		t.Error("expected WithMetrics to set the worker's metrics")
+	}
+}
+
+func TestMetricsHandler_Gzip(t *testing.T) {
+	_, reg := newTestMetrics(t)
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	newMetricsHandler(reg).ServeHTTP(rec, req)
+
+	if ce := rec.Header().Get("Content-Encoding"); ce != "gzip" {
+		t.Fatalf("expected Content-Encoding gzip, got %q", ce)
+	}
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatalf("expected a gzip body: %v", err)
+	}
+	body, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("failed to decompress body: %v", err)
+	}
+	if !strings.Contains(string(body), "build_info") {
+		t.Errorf("expected build_info in the decompressed body, got %q", body)
+	}
+}
+
+func TestMetricsHandler_OpenMetrics(t *testing.T) {
+	_, reg := newTestMetrics(t)
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+	rec := httptest.NewRecorder()
+	newMetricsHandler(reg).ServeHTTP(rec, req)
+
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/openmetrics-text") {
+		t.Errorf("expected an OpenMetrics content type, got %q", ct)
+	}
+	if !strings.HasSuffix(rec.Body.String(), "# EOF\n") {
+		t.Error("expected the OpenMetrics # EOF trailer")
+	}
+}
+
+func TestMetricsHandler_PlainText(t *testing.T) {
+	_, reg := newTestMetrics(t)
+	rec := httptest.NewRecorder()
+	newMetricsHandler(reg).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if ce := rec.Header().Get("Content-Encoding"); ce != "" {
+		t.Errorf("expected no compression without Accept-Encoding, got %q", ce)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("expected the text format by default, got %q", ct)
+	}
+}
+
+func TestDefaultGatherer_ProcessStartTime(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("process collector metrics are only complete on Linux")
+	}
+	// Restart annotations rely on the default registry's process collector.
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatalf("gather failed: %v", err)
+	}
+	for _, f := range families {
+		if f.GetName() == "process_start_time_seconds" {
+			return
+		}
+	}
+	t.Error("expected process_start_time_seconds from the default gatherer")
+}
+
+func TestNewMetrics_SameRegistryTwice(t *testing.T) {
+	first, reg := newTestMetrics(t)
+	second := newMetrics(reg, defaultDurationBuckets)
+
+	// The second set reuses the first's collectors rather than panicking.
+	second.processedByStatus.WithLabelValues("/live", "2xx").Inc()
+	first.logsProcessed.Add(2)
+	if got := testutil.ToFloat64(first.processedByStatus.WithLabelValues("/live", "2xx")); got != 1 {
+		t.Errorf("expected the sets to share worker_processed_by_status_total, got %v", got)
+	}
+	if got := testutil.ToFloat64(second.logsProcessed); got != 2 {
+		t.Errorf("expected the sets to share worker_logs_processed_total, got %v", got)
+	}
+	if _, err := reg.Gather(); err != nil {
+		t.Errorf("gather failed: %v", err)
+	}
+}
+
+func TestRegisterOrReuse_Conflict(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	c := prometheus.NewCounter(prometheus.CounterOpts{Name: "conflict_total", Help: "a counter"})
+	registerOrReuse(reg, &c)
+	// Same name, different help: not the same metric, so it must still panic.
+	other := prometheus.NewCounter(prometheus.CounterOpts{Name: "conflict_total", Help: "something else"})
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a conflicting registration to panic")
+		}
+	}()
+	registerOrReuse(reg, &other)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireMetricsAuth(t *testing.T) {
+	defer func() { metricsAuth = metricsCredentials{} }()
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+
+	tests := []struct {
+		name  string
+		creds metricsCredentials
+		setup func(r *http.Request)
+		want  int
+	}{
+		{"open", metricsCredentials{}, func(*http.Request) {}, http.StatusOK},
+		{"token missing", metricsCredentials{token: "s3cret"}, func(*http.Request) {}, http.StatusUnauthorized},
+		{"token wrong", metricsCredentials{token: "s3cret"}, func(r *http.Request) { r.Header.Set("Authorization", "Bearer nope") }, http.StatusUnauthorized},
+		{"token valid", metricsCredentials{token: "s3cret"}, func(r *http.Request) { r.Header.Set("Authorization", "Bearer s3cret") }, http.StatusOK},
+		{"basic wrong", metricsCredentials{user: "prom", password: "pw"}, func(r *http.Request) { r.SetBasicAuth("prom", "nope") }, http.StatusUnauthorized},
+		{"basic valid", metricsCredentials{user: "prom", password: "pw"}, func(r *http.Request) { r.SetBasicAuth("prom", "pw") }, http.StatusOK},
+		{"basic overrides token", metricsCredentials{token: "s3cret", user: "prom", password: "pw"}, func(r *http.Request) { r.Header.Set("Authorization", "Bearer s3cret") }, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metricsAuth = tt.creds
+			req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			tt.setup(req)
+			rec := httptest.NewRecorder()
+			requireMetricsAuth(ok)(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("expected %d, got %d", tt.want, rec.Code)
+			}
+			if tt.want == http.StatusUnauthorized {
+				if rec.Header().Get("WWW-Authenticate") == "" {
+					t.Error("expected a WWW-Authenticate challenge")
+				}
+				if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+					t.Errorf("expected Content-Type application/json, got '%s'", ct)
+				}
+			}
+		})
+	}
+}
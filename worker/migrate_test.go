@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestLoadMigrations_Embedded(t *testing.T) {
+	if len(migrations) == 0 {
+		t.Fatal("expected embedded migrations")
+	}
+	for i, m := range migrations {
+		if m.version != i+1 {
+			t.Errorf("expected versions 1..n without gaps, got %d at %d", m.version, i)
+		}
+	}
+	var indexed bool
+	for _, m := range migrations {
+		if strings.Contains(m.sql, "ON api_logs (processed_at) WHERE processed_at IS NULL") {
+			indexed = true
+		}
+	}
+	if !indexed {
+		t.Error("expected a partial index on unprocessed api_logs rows")
+	}
+}
+
+func TestLoadMigrations(t *testing.T) {
+	ms, err := loadMigrations(fstest.MapFS{
+		"m/0010_later.sql":  {Data: []byte("SELECT 10")},
+		"m/0002_second.sql": {Data: []byte("SELECT 2")},
+		"m/README.md":       {Data: []byte("not a migration")},
+	}, "m")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(ms) != 2 || ms[0].version != 2 || ms[1].version != 10 || ms[1].sql != "SELECT 10" {
+		t.Errorf("expected versions 2 and 10 in order, got %+v", ms)
+	}
+
+	for name, fsys := range map[string]fstest.MapFS{
+		"unnumbered": {"m/init.sql": {}},
+		"zero":       {"m/0000_init.sql": {}},
+		"duplicate":  {"m/0001_a.sql": {}, "m/01_b.sql": {}},
+	} {
+		if _, err := loadMigrations(fsys, "m"); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+var testMigrations = []migration{
+	{version: 1, name: "0001_one.sql", sql: "CREATE TABLE one (id INT)"},
+	{version: 2, name: "0002_two.sql", sql: "CREATE TABLE two (id INT)"},
+	{version: 3, name: "0003_three.sql", sql: "CREATE TABLE three (id INT)"},
+}
+
+// expectMigrationPreamble expects the lock, the schema_migrations table and
+// the read of applied versions.
+func expectMigrationPreamble(mock sqlmock.Sqlmock, applied ...int) {
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_advisory_xact_lock($1)`)).
+		WithArgs(int64(migrationLockID)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`CREATE TABLE IF NOT EXISTS schema_migrations`).WillReturnResult(sqlmock.NewResult(0, 0))
+	rows := sqlmock.NewRows([]string{"version"})
+	for _, v := range applied {
+		rows.AddRow(v)
+	}
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT version FROM schema_migrations`)).WillReturnRows(rows)
+}
+
+func TestMigrate_AppliesPending(t *testing.T) {
+	d, mock, _ := sqlmock.New()
+	defer func() { _ = d.Close() }()
+
+	// Version 2 landed out of order on this database; only 1 and 3 remain.
+	expectMigrationPreamble(mock, 2)
+	for _, m := range []migration{testMigrations[0], testMigrations[2]} {
+		mock.ExpectExec(regexp.QuoteMeta(m.sql)).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`)).
+			WithArgs(m.version, m.name).WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	mock.ExpectCommit()
+
+	applied, err := migrate(context.Background(), d, testMigrations)
+	if err != nil || applied != 2 {
+		t.Errorf("expected 2 migrations applied, got %d, %v", applied, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMigrate_UpToDate(t *testing.T) {
+	d, mock, _ := sqlmock.New()
+	defer func() { _ = d.Close() }()
+
+	expectMigrationPreamble(mock, 1, 2, 3)
+	mock.ExpectCommit()
+
+	if applied, err := migrate(context.Background(), d, testMigrations); err != nil || applied != 0 {
+		t.Errorf("expected nothing to apply, got %d, %v", applied, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMigrate_RollsBackOnFailure(t *testing.T) {
+	d, mock, _ := sqlmock.New()
+	defer func() { _ = d.Close() }()
+
+	expectMigrationPreamble(mock, 1)
+	mock.ExpectExec(regexp.QuoteMeta(testMigrations[1].sql)).WillReturnError(errors.New("relation already exists"))
+	mock.ExpectRollback()
+
+	_, err := migrate(context.Background(), d, testMigrations)
+	if err == nil || !strings.Contains(err.Error(), "migration 0002_two.sql: relation already exists") {
+		t.Errorf("expected the failing migration to be named, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMigrate_LockFailure(t *testing.T) {
+	d, mock, _ := sqlmock.New()
+	defer func() { _ = d.Close() }()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_advisory_xact_lock($1)`)).WillReturnError(errors.New("canceling statement due to lock timeout"))
+	mock.ExpectRollback()
+
+	if _, err := migrate(context.Background(), d, testMigrations); err == nil || !strings.Contains(err.Error(), "acquire migration lock") {
+		t.Errorf("expected a lock error, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLoadConfig_MigrateOnly(t *testing.T) {
+	clearConfigEnv(t)
+	if _, err := LoadConfig([]string{"--migrate-only"}); err == nil || !strings.Contains(err.Error(), "--migrate-only needs DB_DSN") {
+		t.Errorf("expected a missing DSN to be rejected, got %v", err)
+	}
+	t.Setenv("DB_DSN", "postgres://app:pw@db:5432/app")
+	if _, err := LoadConfig([]string{"--migrate-only"}); !errors.Is(err, errMigrateOnly) {
+		t.Errorf("expected errMigrateOnly, got %v", err)
+	}
+}
@@ -0,0 +1,12 @@
+-- The table initDB used to create on every start; IF NOT EXISTS lets
+-- databases created that way adopt the migrations.
+CREATE TABLE IF NOT EXISTS api_logs (
+	id SERIAL PRIMARY KEY,
+	method VARCHAR(10),
+	endpoint VARCHAR(255),
+	status INT,
+	duration_ms FLOAT,
+	remote_addr VARCHAR(255),
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	processed_at TIMESTAMP NULL
+);
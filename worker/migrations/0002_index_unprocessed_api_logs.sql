@@ -0,0 +1,3 @@
+-- The worker claims batches with WHERE processed_at IS NULL ORDER BY id;
+-- without this the subselect scans the whole table.
+CREATE INDEX IF NOT EXISTS api_logs_unprocessed_idx ON api_logs (processed_at) WHERE processed_at IS NULL;
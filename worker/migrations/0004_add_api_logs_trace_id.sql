@@ -0,0 +1,3 @@
+-- The trace the request belonged to, so a row can be looked up in Tempo.
+-- NULL when tracing is off.
+ALTER TABLE api_logs ADD COLUMN IF NOT EXISTS trace_id VARCHAR(32);
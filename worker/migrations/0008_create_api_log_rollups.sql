@@ -0,0 +1,11 @@
+-- Per-route request counts the worker's aggregate processor
+-- (WORKER_PROCESSORS=aggregate) rolls api_logs up into, one row per time
+-- bucket, route and status class.
+CREATE TABLE IF NOT EXISTS api_log_rollups (
+    bucket TIMESTAMP NOT NULL,
+    endpoint VARCHAR(255) NOT NULL,
+    status_class VARCHAR(16) NOT NULL,
+    requests BIGINT NOT NULL,
+    duration_ms_sum DOUBLE PRECISION NOT NULL,
+    PRIMARY KEY (bucket, endpoint, status_class)
+);
@@ -0,0 +1,4 @@
+-- Whether the rate limiter rejected the request rather than a handler
+-- answering it, so an audit can pick out the 429s it sent. Rows logged
+-- before it was recorded are false: rejected requests weren't logged then.
+ALTER TABLE api_logs ADD COLUMN IF NOT EXISTS rate_limited BOOLEAN NOT NULL DEFAULT FALSE;
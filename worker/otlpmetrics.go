@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	prombridge "go.opentelemetry.io/contrib/bridges/prometheus"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+const (
+	defaultOTLPMetricsInterval = 30 * time.Second
+	// otlpMetricsTimeout bounds a single push so an unreachable collector
+	// can't hold up shutdown.
+	otlpMetricsTimeout = 10 * time.Second
+	// otlpMetricsPath is where a collector takes metrics when
+	// OTEL_EXPORTER_OTLP_METRICS_ENDPOINT has no path of its own.
+	otlpMetricsPath = "/v1/metrics"
+)
+
+// setupOTLPMetrics pushes the contents of gatherer over OTLP/HTTP to
+// endpoint every interval, as the service res describes. The Prometheus
+// registry stays the source of truth: each push converts what it holds at
+// that moment, and /metrics keeps serving the same values. Failed pushes
+// are logged and counted on failures. The returned function makes a final
+// push and stops the exporter.
+func setupOTLPMetrics(ctx context.Context, endpoint string, interval time.Duration, res *resource.Resource, gatherer prometheus.Gatherer, failures prometheus.Counter) (func(context.Context) error, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	urlPath := u.Path
+	if urlPath == "" || urlPath == "/" {
+		urlPath = otlpMetricsPath
+	}
+	opts := []otlpmetrichttp.Option{
+		otlpmetrichttp.WithEndpoint(u.Host),
+		otlpmetrichttp.WithURLPath(urlPath),
+		otlpmetrichttp.WithTimeout(otlpMetricsTimeout),
+	}
+	if u.Scheme == "http" {
+		opts = append(opts, otlpmetrichttp.WithInsecure())
+	}
+	exporter, err := otlpmetrichttp.New(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("create OTLP metrics exporter: %w", err)
+	}
+	reader := sdkmetric.NewPeriodicReader(countingExporter{Exporter: exporter, failures: failures},
+		sdkmetric.WithInterval(interval),
+		sdkmetric.WithTimeout(otlpMetricsTimeout),
+		sdkmetric.WithProducer(prombridge.NewMetricProducer(prombridge.WithGatherer(gatherer))),
+	)
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader), sdkmetric.WithResource(res))
+	return provider.Shutdown, nil
+}
+
+// countingExporter logs and counts failed pushes, which the periodic reader
+// would otherwise only hand to OTel's global error handler.
+type countingExporter struct {
+	sdkmetric.Exporter
+	failures prometheus.Counter
+}
+
+// Export pushes rm. A failure is reported here and not returned, so the
+// reader doesn't log it a second time; the next interval pushes again.
+func (e countingExporter) Export(ctx context.Context, rm *metricdata.ResourceMetrics) error {
+	if err := e.Exporter.Export(ctx, rm); err != nil {
+		e.failures.Inc()
+		slog.Warn("OTLP metrics push failed", "error", err)
+	}
+	return nil
+}
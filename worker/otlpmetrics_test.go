@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	collectorpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// otlpCollector records the metrics export requests posted to it.
+type otlpCollector struct {
+	mu       sync.Mutex
+	paths    []string
+	requests []*collectorpb.ExportMetricsServiceRequest
+}
+
+func (c *otlpCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	req := &collectorpb.ExportMetricsServiceRequest{}
+	if err == nil {
+		err = proto.Unmarshal(body, req)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.paths = append(c.paths, r.URL.Path)
+	c.requests = append(c.requests, req)
+}
+
+func TestSetupOTLPMetrics_PushesRegistry(t *testing.T) {
+	collector := &otlpCollector{}
+	srv := httptest.NewServer(collector)
+	defer srv.Close()
+
+	reg := prometheus.NewRegistry()
+	requests := prometheus.NewCounter(prometheus.CounterOpts{Name: "test_requests_total", Help: "Test requests"})
+	reg.MustRegister(requests)
+	requests.Add(3)
+	failures := prometheus.NewCounter(prometheus.CounterOpts{Name: "failures_total"})
+
+	// An hour-long interval leaves the shutdown's final push as the only one.
+	flush, err := setupOTLPMetrics(context.Background(), srv.URL, time.Hour, newServiceResource("worker", "test"), reg, failures)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := flush(context.Background()); err != nil {
+		t.Fatalf("unexpected error on the final push: %v", err)
+	}
+
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	if len(collector.requests) != 1 || collector.paths[0] != otlpMetricsPath {
+		t.Fatalf("expected one push to %s, got paths %v", otlpMetricsPath, collector.paths)
+	}
+	rm := collector.requests[0].GetResourceMetrics()
+	if len(rm) != 1 {
+		t.Fatalf("expected one resource, got %d", len(rm))
+	}
+	attrs := map[string]string{}
+	for _, kv := range rm[0].GetResource().GetAttributes() {
+		attrs[kv.GetKey()] = kv.GetValue().GetStringValue()
+	}
+	if attrs["service.name"] != "worker" || attrs["deployment.environment.name"] != "test" || attrs["service.version"] != version {
+		t.Errorf("expected service name, version and env on the resource, got %v", attrs)
+	}
+	var found bool
+	for _, sm := range rm[0].GetScopeMetrics() {
+		for _, metric := range sm.GetMetrics() {
+			if metric.GetName() != "test_requests_total" {
+				continue
+			}
+			found = true
+			points := metric.GetSum().GetDataPoints()
+			if len(points) != 1 || points[0].GetAsDouble() != 3 {
+				t.Errorf("expected test_requests_total to be pushed as a sum of 3, got %v", metric)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("expected test_requests_total in the push, got %v", rm[0].GetScopeMetrics())
+	}
+	if got := testutil.ToFloat64(failures); got != 0 {
+		t.Errorf("expected no failed pushes, got %v", got)
+	}
+}
+
+func TestSetupOTLPMetrics_CountsFailures(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "collector unavailable", http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+	failures := prometheus.NewCounter(prometheus.CounterOpts{Name: "failures_total"})
+
+	flush, err := setupOTLPMetrics(context.Background(), srv.URL+"/otlp/v1/metrics", time.Hour, newServiceResource("worker", "test"), prometheus.NewRegistry(), failures)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := flush(context.Background()); err != nil {
+		t.Errorf("expected a failed push not to be returned, got %v", err)
+	}
+	if got := testutil.ToFloat64(failures); got != 1 {
+		t.Errorf("expected 1 failed push, got %v", got)
+	}
+}
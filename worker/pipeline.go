@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// LogRow is one api_logs row a batch has claimed, as processors see it.
+// NULL columns read as their zero value.
+type LogRow struct {
+	ID         int64
+	Method     string
+	Endpoint   string
+	Status     int
+	DurationMs float64
+	RemoteAddr string
+	CreatedAt  time.Time
+}
+
+// Processor is one step of the batch pipeline. Each claimed batch passes
+// through the processors in order, inside the batch's transaction, and is
+// stamped processed only once all of them have succeeded. An error rolls
+// the batch back for the next cycle, so a processor can see rows again
+// after a later step fails.
+type Processor interface {
+	Process(ctx context.Context, rows []LogRow) error
+}
+
+// ProcessorFunc adapts a function to a Processor.
+type ProcessorFunc func(ctx context.Context, rows []LogRow) error
+
+// Process calls f.
+func (f ProcessorFunc) Process(ctx context.Context, rows []LogRow) error {
+	return f(ctx, rows)
+}
+
+// namedProcessor is a Processor in the pipeline, under the name its
+// metrics, spans and errors carry.
+type namedProcessor struct {
+	name string
+	proc Processor
+}
+
+// processorFactories build the processors WORKER_PROCESSORS can enable.
+var processorFactories = map[string]func(Config) (Processor, error){
+	"aggregate": newAggregateProcessor,
+	"archive":   newArchiveProcessor,
+}
+
+// parseProcessors parses WORKER_PROCESSORS, a comma-separated list of
+// processor names run in the order given.
+func parseProcessors(s string) ([]string, error) {
+	var names []string
+	for name := range strings.SplitSeq(s, ",") {
+		name = strings.TrimSpace(name)
+		if _, ok := processorFactories[name]; !ok {
+			return nil, fmt.Errorf("unknown processor %q: want %s", name, strings.Join(slices.Sorted(maps.Keys(processorFactories)), ", "))
+		}
+		if slices.Contains(names, name) {
+			return nil, fmt.Errorf("duplicate processor %s", name)
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// WithProcessor appends p to the pipeline every batch passes through
+// before it is stamped processed.
+func WithProcessor(name string, p Processor) WorkerOption {
+	return func(w *Worker) {
+		w.processors = append(w.processors, namedProcessor{name: name, proc: p})
+	}
+}
+
+// processorOptions builds the processors cfg enables, in order.
+func processorOptions(cfg Config) ([]WorkerOption, error) {
+	var opts []WorkerOption
+	for _, name := range cfg.Processors {
+		p, err := processorFactories[name](cfg)
+		if err != nil {
+			return nil, fmt.Errorf("processor %s: %w", name, err)
+		}
+		opts = append(opts, WithProcessor(name, p))
+	}
+	return opts, nil
+}
+
+// runPipeline passes rows through each processor in order, timing each
+// one, and stops at the first error.
+func (w *Worker) runPipeline(ctx context.Context, rows []LogRow) error {
+	for _, p := range w.processors {
+		pctx, span := tracer.Start(ctx, "processor "+p.name, trace.WithAttributes(
+			attribute.String("worker.processor", p.name),
+			attribute.Int("worker.rows", len(rows)),
+		))
+		start := time.Now()
+		err := p.proc.Process(pctx, rows)
+		w.metrics.observeProcessor(p.name, time.Since(start), err)
+		endSpan(span, err)
+		if err != nil {
+			return fmt.Errorf("processor %s: %w", p.name, err)
+		}
+	}
+	return nil
+}
+
+type batchTxKey struct{}
+
+// withBatchTx returns ctx carrying the batch's transaction.
+func withBatchTx(ctx context.Context, tx *sql.Tx) context.Context {
+	return context.WithValue(ctx, batchTxKey{}, tx)
+}
+
+// batchTx returns the transaction of the batch a processor runs in, or nil
+// outside one. Writes made through it commit with the processed_at stamp or
+// roll back with it, so they happen exactly once per row.
+func batchTx(ctx context.Context) *sql.Tx {
+	tx, _ := ctx.Value(batchTxKey{}).(*sql.Tx)
+	return tx
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// recordingProcessor records the ids of every batch it sees and fails with
+// err, when set.
+type recordingProcessor struct {
+	batches [][]int64
+	err     error
+}
+
+func (p *recordingProcessor) Process(ctx context.Context, rows []LogRow) error {
+	ids := make([]int64, len(rows))
+	for i, r := range rows {
+		ids[i] = r.ID
+	}
+	p.batches = append(p.batches, ids)
+	return p.err
+}
+
+func TestParseProcessors(t *testing.T) {
+	got, err := parseProcessors(" archive ,aggregate")
+	if err != nil || !slices.Equal(got, []string{"archive", "aggregate"}) {
+		t.Errorf("expected archive then aggregate, got %v, %v", got, err)
+	}
+	for _, in := range []string{"", "aggregate,", "webhook", "archive,archive"} {
+		if _, err := parseProcessors(in); err == nil {
+			t.Errorf("expected error for %q", in)
+		}
+	}
+}
+
+func TestPipeline_RunsInOrderBeforeTheStamp(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer func() { _ = mockDB.Close() }()
+	dbMu.Lock()
+	db = mockDB
+	dbMu.Unlock()
+	m, _ := newTestMetrics(t)
+
+	var order []string
+	step := func(name string) Processor {
+		return ProcessorFunc(func(ctx context.Context, rows []LogRow) error {
+			if batchTx(ctx) == nil {
+				t.Errorf("%s: expected to run inside the batch transaction", name)
+			}
+			if len(rows) != 3 || rows[0].Method != "GET" || rows[0].Endpoint != "/live" || rows[0].DurationMs != 1.5 || !rows[0].CreatedAt.Equal(processedCreatedAt) {
+				t.Errorf("%s: unexpected rows %+v", name, rows)
+			}
+			order = append(order, name)
+			return nil
+		})
+	}
+	w := NewWorker(time.Second, WithMetrics(m), WithProcessor("first", step("first")), WithProcessor("second", step("second")))
+
+	expectBatch(mock, 3)
+	processed, err := w.processLogs(context.Background())
+	if err != nil || processed.rows != 3 {
+		t.Fatalf("expected 3 rows processed, got %d, %v", processed.rows, err)
+	}
+	if !slices.Equal(order, []string{"first", "second"}) {
+		t.Errorf("expected the processors in order, got %v", order)
+	}
+	if n := testutil.CollectAndCount(m.processorDuration); n != 2 {
+		t.Errorf("expected a duration series per processor, got %d", n)
+	}
+	if n := testutil.CollectAndCount(m.processorErrors); n != 0 {
+		t.Errorf("expected no processor errors, got %d series", n)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled mock: %s", err)
+	}
+}
+
+// TestPipeline_PartialFailure fails the second of three processors and
+// checks the batch is neither stamped nor counted, the third processor never
+// sees it, and the retry passes the same rows through every step.
+func TestPipeline_PartialFailure(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer func() { _ = mockDB.Close() }()
+	dbMu.Lock()
+	db = mockDB
+	dbMu.Unlock()
+	m, _ := newTestMetrics(t)
+
+	first, second, third := &recordingProcessor{}, &recordingProcessor{err: errors.New("webhook down")}, &recordingProcessor{}
+	w := NewWorker(time.Second, WithMetrics(m),
+		WithProcessor("first", first), WithProcessor("second", second), WithProcessor("third", third))
+
+	// No UPDATE: the stamp never runs and the transaction rolls back.
+	mock.ExpectBegin()
+	mock.ExpectQuery(claimQuery).WillReturnRows(processedRows(3))
+	mock.ExpectRollback()
+
+	processed, err := w.processLogs(context.Background())
+	if err == nil || err.Error() != "processor second: webhook down" || processed.rows != 0 {
+		t.Fatalf("expected the second processor's error and no rows, got %d, %v", processed.rows, err)
+	}
+	if len(first.batches) != 1 || len(second.batches) != 1 || len(third.batches) != 0 {
+		t.Errorf("expected the pipeline to stop at the failure, got %d, %d, %d batches", len(first.batches), len(second.batches), len(third.batches))
+	}
+	if got := testutil.ToFloat64(m.processorErrors.WithLabelValues("second")); got != 1 {
+		t.Errorf("expected the failure counted against second, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.processorErrors.WithLabelValues("first")); got != 0 {
+		t.Errorf("expected no errors counted against first, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.logsProcessed); got != 0 {
+		t.Errorf("expected no processed rows counted, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.batchErrors); got != 1 {
+		t.Errorf("expected the batch counted as failed, got %v", got)
+	}
+	if w.IsHealthy() {
+		t.Error("expected the worker unhealthy after the failed batch")
+	}
+
+	// The next cycle claims the same rows and, with the fault gone, stamps
+	// them.
+	second.err = nil
+	expectBatch(mock, 3)
+	processed, err = w.processLogs(context.Background())
+	if err != nil || processed.rows != 3 {
+		t.Fatalf("expected the retry to process 3 rows, got %d, %v", processed.rows, err)
+	}
+	want := [][]int64{{1, 2, 3}, {1, 2, 3}}
+	if !slices.EqualFunc(first.batches, want, slices.Equal) || !slices.EqualFunc(second.batches, want, slices.Equal) {
+		t.Errorf("expected first and second to see the batch twice, got %v and %v", first.batches, second.batches)
+	}
+	if len(third.batches) != 1 {
+		t.Errorf("expected third to see the batch once, got %d", len(third.batches))
+	}
+	if got := testutil.ToFloat64(m.logsProcessed); got != 3 {
+		t.Errorf("expected 3 processed rows counted, got %v", got)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled mock: %s", err)
+	}
+}
+
+func TestPipeline_EmptyBatchSkipsProcessors(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer func() { _ = mockDB.Close() }()
+	dbMu.Lock()
+	db = mockDB
+	dbMu.Unlock()
+	p := &recordingProcessor{}
+	w := NewWorker(time.Second, WithProcessor("recording", p))
+
+	expectBatch(mock, 0)
+	if _, err := w.processLogs(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(p.batches) != 0 {
+		t.Errorf("expected no processor call without rows, got %d", len(p.batches))
+	}
+}
+
+func TestProcessorOptions(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Processors = []string{"aggregate", "archive"}
+	cfg.ArchiveDir = t.TempDir()
+	opts, err := processorOptions(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	w := NewWorker(time.Second, opts...)
+	if len(w.processors) != 2 || w.processors[0].name != "aggregate" || w.processors[1].name != "archive" {
+		t.Errorf("expected aggregate then archive, got %+v", w.processors)
+	}
+
+	cfg.ArchiveDir = ""
+	if _, err := processorOptions(cfg); err == nil {
+		t.Error("expected the archive processor without a destination to fail")
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// batchWebhookQueueSize bounds the summaries waiting for delivery; more
+	// are dropped so a slow receiver never holds up processing.
+	batchWebhookQueueSize = 32
+	// batchWebhookAttempts bounds the deliveries tried for one summary.
+	batchWebhookAttempts = 3
+	// batchWebhookTimeout bounds a single delivery.
+	batchWebhookTimeout = 5 * time.Second
+	// headerSignature carries "sha256=" and the hex HMAC-SHA256 of the
+	// body, keyed with WORKER_WEBHOOK_SECRET.
+	headerSignature = "X-Signature-256"
+)
+
+// batchWebhookRetryDelay is the pause before the first redelivery, doubling
+// for each one after; a variable so tests needn't wait.
+var batchWebhookRetryDelay = time.Second
+
+// Outcomes counted in worker_webhook_deliveries_total.
+const (
+	deliverySent    = "sent"
+	deliveryFailed  = "failed"
+	deliveryDropped = "dropped"
+)
+
+// batchSummary is the JSON body POSTed to WORKER_WEBHOOK_URL after a batch
+// that processed rows. From and To are the created_at of the oldest and
+// newest of them, and are left out when none had one.
+type batchSummary struct {
+	Service       string  `json:"service"`
+	Env           string  `json:"env"`
+	RowsProcessed int     `json:"rows_processed"`
+	From          string  `json:"from,omitempty"`
+	To            string  `json:"to,omitempty"`
+	DurationMS    float64 `json:"duration_ms"`
+	CompletedAt   string  `json:"completed_at"`
+}
+
+// newBatchSummary describes res, a batch that took d and finished at
+// completed. The publisher fills in the service and env.
+func newBatchSummary(res batchResult, d time.Duration, completed time.Time) batchSummary {
+	s := batchSummary{
+		RowsProcessed: res.rows,
+		DurationMS:    float64(d.Microseconds()) / 1000,
+		CompletedAt:   completed.UTC().Format(time.RFC3339Nano),
+	}
+	if !res.oldest.IsZero() {
+		s.From = res.oldest.UTC().Format(time.RFC3339Nano)
+		s.To = res.newest.UTC().Format(time.RFC3339Nano)
+	}
+	return s
+}
+
+// signBatchSummary returns the headerSignature value for body.
+func signBatchSummary(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// BatchPublisher POSTs batch summaries to a webhook, one at a time from a
+// goroutine of its own, so the worker never waits on the receiver. A
+// summary that doesn't fit in the queue is dropped, and one that still
+// fails after batchWebhookAttempts is logged; both are counted.
+type BatchPublisher struct {
+	url      string
+	secret   []byte
+	service  string
+	env      string
+	client   *http.Client
+	outcomes *prometheus.CounterVec
+
+	queue chan batchSummary
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// NewBatchPublisher creates a publisher that signs summaries for service in
+// env with secret and delivers them to url once run is started. outcomes
+// counts each summary under its outcome label.
+func NewBatchPublisher(url, secret, service, env string, outcomes *prometheus.CounterVec) *BatchPublisher {
+	return &BatchPublisher{
+		url:      url,
+		secret:   []byte(secret),
+		service:  service,
+		env:      env,
+		client:   &http.Client{Timeout: batchWebhookTimeout},
+		outcomes: outcomes,
+		queue:    make(chan batchSummary, batchWebhookQueueSize),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Publish queues s for delivery, or drops it when the queue is full. A nil
+// publisher drops everything.
+func (p *BatchPublisher) Publish(s batchSummary) {
+	if p == nil {
+		return
+	}
+	s.Service, s.Env = p.service, p.env
+	select {
+	case p.queue <- s:
+	default:
+		p.outcomes.WithLabelValues(deliveryDropped).Inc()
+	}
+}
+
+// run delivers queued summaries one at a time until Close.
+func (p *BatchPublisher) run() {
+	defer close(p.done)
+	for {
+		select {
+		case s := <-p.queue:
+			p.deliver(s)
+		case <-p.stop:
+			// Deliver what was queued before the stop, then exit.
+			for {
+				select {
+				case s := <-p.queue:
+					p.deliver(s)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// Close delivers the summaries still queued and stops the publisher, giving
+// up when ctx is done.
+func (p *BatchPublisher) Close(ctx context.Context) error {
+	close(p.stop)
+	select {
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// deliver posts s, retrying with a doubling delay up to
+// batchWebhookAttempts times.
+func (p *BatchPublisher) deliver(s batchSummary) {
+	body, err := json.Marshal(s)
+	if err != nil {
+		p.outcomes.WithLabelValues(deliveryFailed).Inc()
+		slog.Warn("batch summary delivery failed", "error", err)
+		return
+	}
+	delay := batchWebhookRetryDelay
+	for attempt := 1; attempt <= batchWebhookAttempts; attempt++ {
+		if err = p.post(body); err == nil {
+			p.outcomes.WithLabelValues(deliverySent).Inc()
+			return
+		}
+		if attempt < batchWebhookAttempts {
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+	p.outcomes.WithLabelValues(deliveryFailed).Inc()
+	slog.Warn("batch summary delivery failed", "rows_processed", s.RowsProcessed, "attempts", batchWebhookAttempts, "error", err)
+}
+
+func (p *BatchPublisher) post(body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), batchWebhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(headerSignature, signBatchSummary(p.secret, body))
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
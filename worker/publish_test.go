@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+const testWebhookSecret = "hmac-key"
+
+// summaryReceiver is a webhook that checks each delivery's signature the way
+// a receiver would and records the valid ones, failing the first failures
+// deliveries with a 503.
+type summaryReceiver struct {
+	t        *testing.T
+	failures int
+
+	mu        sync.Mutex
+	attempts  int
+	summaries []batchSummary
+}
+
+func (sr *summaryReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	mac := hmac.New(sha256.New, []byte(testWebhookSecret))
+	mac.Write(body)
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if got := r.Header.Get(headerSignature); !hmac.Equal([]byte(got), []byte(want)) {
+		sr.t.Errorf("expected signature %s, got %q", want, got)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	sr.attempts++
+	if sr.attempts <= sr.failures {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	var s batchSummary
+	if err := json.Unmarshal(body, &s); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sr.summaries = append(sr.summaries, s)
+}
+
+func (sr *summaryReceiver) results() (int, []batchSummary) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	return sr.attempts, append([]batchSummary(nil), sr.summaries...)
+}
+
+func newTestPublisher(t *testing.T, url string) (*BatchPublisher, *prometheus.CounterVec) {
+	t.Helper()
+	outcomes := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "worker_webhook_deliveries_total"}, []string{"outcome"})
+	return NewBatchPublisher(url, testWebhookSecret, "worker", "test", outcomes), outcomes
+}
+
+func TestSignBatchSummary(t *testing.T) {
+	// Known-answer HMAC-SHA256 from RFC 4231, test case 2.
+	got := signBatchSummary([]byte("Jefe"), []byte("what do ya want for nothing?"))
+	if want := "sha256=5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843"; got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+}
+
+func TestBatchPublisher_Delivers(t *testing.T) {
+	receiver := &summaryReceiver{t: t}
+	srv := httptest.NewServer(receiver)
+	defer srv.Close()
+	p, outcomes := newTestPublisher(t, srv.URL)
+	go p.run()
+
+	res := batchResult{rows: 3, oldest: processedCreatedAt, newest: processedCreatedAt.Add(2 * time.Second)}
+	p.Publish(newBatchSummary(res, 1500*time.Microsecond, processedCreatedAt.Add(time.Minute)))
+	if err := p.Close(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, got := receiver.results()
+	want := batchSummary{
+		Service:       "worker",
+		Env:           "test",
+		RowsProcessed: 3,
+		From:          "2026-03-01T10:00:00Z",
+		To:            "2026-03-01T10:00:02Z",
+		DurationMS:    1.5,
+		CompletedAt:   "2026-03-01T10:01:00Z",
+	}
+	if len(got) != 1 || got[0] != want {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+	if n := testutil.ToFloat64(outcomes.WithLabelValues(deliverySent)); n != 1 {
+		t.Errorf("expected 1 sent summary, got %v", n)
+	}
+}
+
+func TestBatchPublisher_Retries(t *testing.T) {
+	prev := batchWebhookRetryDelay
+	batchWebhookRetryDelay = time.Millisecond
+	defer func() { batchWebhookRetryDelay = prev }()
+
+	for _, tc := range []struct {
+		name               string
+		failures           int
+		wantSent, wantFail float64
+	}{
+		{"recovers", batchWebhookAttempts - 1, 1, 0},
+		{"gives up", batchWebhookAttempts + 1, 0, 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			receiver := &summaryReceiver{t: t, failures: tc.failures}
+			srv := httptest.NewServer(receiver)
+			defer srv.Close()
+			p, outcomes := newTestPublisher(t, srv.URL)
+			go p.run()
+			var logs bytes.Buffer
+			prevLogger := slog.Default()
+			slog.SetDefault(slog.New(slog.NewJSONHandler(&logs, nil)))
+			defer slog.SetDefault(prevLogger)
+
+			p.Publish(batchSummary{RowsProcessed: 1})
+			if err := p.Close(context.Background()); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if attempts, _ := receiver.results(); attempts != batchWebhookAttempts {
+				t.Errorf("expected %d attempts, got %d", batchWebhookAttempts, attempts)
+			}
+			if got := testutil.ToFloat64(outcomes.WithLabelValues(deliverySent)); got != tc.wantSent {
+				t.Errorf("expected %v sent, got %v", tc.wantSent, got)
+			}
+			if got := testutil.ToFloat64(outcomes.WithLabelValues(deliveryFailed)); got != tc.wantFail {
+				t.Errorf("expected %v failed, got %v", tc.wantFail, got)
+			}
+			if failed := strings.Contains(logs.String(), "batch summary delivery failed"); failed != (tc.wantFail > 0) {
+				t.Errorf("expected the failure logged only when delivery gave up, got %s", logs.String())
+			}
+		})
+	}
+}
+
+func TestBatchPublisher_DropsWhenFull(t *testing.T) {
+	p, outcomes := newTestPublisher(t, "http://127.0.0.1:0")
+
+	// Nothing is delivered until run starts, so the queue fills up.
+	for range batchWebhookQueueSize + 2 {
+		p.Publish(batchSummary{RowsProcessed: 1})
+	}
+	if got := testutil.ToFloat64(outcomes.WithLabelValues(deliveryDropped)); got != 2 {
+		t.Errorf("expected 2 dropped summaries, got %v", got)
+	}
+
+	var disabled *BatchPublisher
+	disabled.Publish(batchSummary{RowsProcessed: 1}) // must not panic
+}
+
+func TestRunBatch_PublishesSummary(t *testing.T) {
+	receiver := &summaryReceiver{t: t}
+	srv := httptest.NewServer(receiver)
+	defer srv.Close()
+	p, _ := newTestPublisher(t, srv.URL)
+	go p.run()
+	mockDB, mock, _ := sqlmock.New()
+	defer func() { _ = mockDB.Close() }()
+	dbMu.Lock()
+	db = mockDB
+	dbMu.Unlock()
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(io.Discard, nil)))
+	defer slog.SetDefault(prev)
+
+	w := NewWorker(time.Second, WithBatchPublisher(p))
+	expectBatch(mock, 3)
+	expectBatch(mock, 0)
+	expectBatchError(mock, errors.New("relation does not exist"))
+	for range 3 {
+		_, _ = w.runBatch(context.Background())
+	}
+	if err := p.Close(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, got := receiver.results()
+	if len(got) != 1 {
+		t.Fatalf("expected a summary for the batch with rows only, got %+v", got)
+	}
+	s := got[0]
+	if s.RowsProcessed != 3 || s.From != "2026-03-01T10:00:00Z" || s.To != "2026-03-01T10:00:02Z" || s.DurationMS < 0 || s.CompletedAt == "" {
+		t.Errorf("unexpected summary %+v", s)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+const (
+	defaultPushInterval = 30 * time.Second
+	// pushTimeout bounds a single push or delete so an unreachable gateway
+	// can't hold up shutdown.
+	pushTimeout = 10 * time.Second
+)
+
+// Pusher pushes the worker's metrics to a Prometheus Pushgateway, for runs
+// that may finish before Prometheus scrapes them. Push failures are logged
+// and counted but never fail the run.
+type Pusher struct {
+	pusher       *push.Pusher
+	metrics      *metrics
+	interval     time.Duration
+	deleteOnExit bool
+}
+
+// NewPusher creates a Pusher that pushes gatherer to the gateway at gatewayURL
+// under job, grouped by instance.
+func NewPusher(gatewayURL, job, instance string, gatherer prometheus.Gatherer, m *metrics) *Pusher {
+	return &Pusher{
+		pusher: push.New(gatewayURL, job).
+			Gatherer(gatherer).
+			Grouping("instance", instance).
+			Client(&http.Client{Timeout: pushTimeout}),
+		metrics:  m,
+		interval: defaultPushInterval,
+	}
+}
+
+// Run pushes every interval until ctx is cancelled. The final push is left
+// to Finish so it includes everything recorded during shutdown.
+func (p *Pusher) Run(ctx context.Context) {
+	slog.Info("pushgateway push started", "interval", p.interval.String(), "delete_on_exit", p.deleteOnExit)
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.push(ctx)
+		}
+	}
+}
+
+// Finish makes the end-of-run push, or deletes the group instead when
+// PUSHGATEWAY_DELETE_ON_EXIT is set.
+func (p *Pusher) Finish(ctx context.Context) {
+	if p.deleteOnExit {
+		if err := p.pusher.Delete(); err != nil {
+			p.metrics.pushFailures.Inc()
+			slog.Warn("pushgateway delete failed", "error", err)
+		}
+		return
+	}
+	p.push(ctx)
+}
+
+func (p *Pusher) push(ctx context.Context) {
+	if err := p.pusher.PushContext(ctx); err != nil {
+		p.metrics.pushFailures.Inc()
+		slog.Warn("pushgateway push failed", "error", err)
+	}
+}
+
+// newPusher builds a Pusher from the PUSHGATEWAY_* settings, or returns nil
+// when PUSHGATEWAY_URL is unset.
+func newPusher(cfg Config, gatherer prometheus.Gatherer, m *metrics) (*Pusher, error) {
+	if cfg.PushgatewayURL == "" {
+		return nil, nil
+	}
+	instance := cfg.PushgatewayInstance
+	if instance == "" {
+		host, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("resolve pushgateway instance: %w", err)
+		}
+		instance = host
+	}
+	job := cfg.PushgatewayJob
+	if job == "" {
+		job = cfg.ServiceName
+	}
+	p := NewPusher(cfg.PushgatewayURL, job, instance, gatherer, m)
+	p.interval = cfg.PushgatewayInterval
+	p.deleteOnExit = cfg.PushgatewayDeleteOnExit
+	return p, nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// fakeGateway records the requests a Pushgateway would receive.
+type fakeGateway struct {
+	mu       sync.Mutex
+	status   int
+	methods  []string
+	paths    []string
+	families map[string]*dto.MetricFamily
+}
+
+func newFakeGateway(t *testing.T, status int) (*fakeGateway, *httptest.Server) {
+	t.Helper()
+	g := &fakeGateway{status: status, families: map[string]*dto.MetricFamily{}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		g.methods = append(g.methods, r.Method)
+		g.paths = append(g.paths, r.URL.Path)
+		dec := expfmt.NewDecoder(r.Body, expfmt.ResponseFormat(r.Header))
+		for {
+			var mf dto.MetricFamily
+			if err := dec.Decode(&mf); err != nil {
+				if err != io.EOF {
+					t.Errorf("failed to decode pushed metrics: %v", err)
+				}
+				break
+			}
+			g.families[mf.GetName()] = &mf
+		}
+		w.WriteHeader(g.status)
+	}))
+	t.Cleanup(srv.Close)
+	return g, srv
+}
+
+func TestPusher_FinishPushesRegistry(t *testing.T) {
+	g, srv := newFakeGateway(t, http.StatusOK)
+	m, reg := newTestMetrics(t)
+	m.logsProcessed.Add(3)
+
+	NewPusher(srv.URL, "worker", "pod-1", reg, m).Finish(context.Background())
+
+	if len(g.methods) != 1 || g.methods[0] != http.MethodPut {
+		t.Fatalf("expected a single PUT, got %v", g.methods)
+	}
+	if g.paths[0] != "/metrics/job/worker/instance/pod-1" {
+		t.Errorf("unexpected grouping path %s", g.paths[0])
+	}
+	mf, ok := g.families["worker_logs_processed_total"]
+	if !ok {
+		t.Fatalf("expected worker_logs_processed_total in the payload, got %v", g.families)
+	}
+	if got := mf.GetMetric()[0].GetCounter().GetValue(); got != 3 {
+		t.Errorf("expected pushed value 3, got %v", got)
+	}
+}
+
+func TestPusher_DeleteOnExit(t *testing.T) {
+	g, srv := newFakeGateway(t, http.StatusAccepted)
+	m, reg := newTestMetrics(t)
+	p := NewPusher(srv.URL, "worker", "pod-1", reg, m)
+	p.deleteOnExit = true
+
+	p.Finish(context.Background())
+
+	if len(g.methods) != 1 || g.methods[0] != http.MethodDelete || g.paths[0] != "/metrics/job/worker/instance/pod-1" {
+		t.Errorf("expected DELETE of the group, got %v %v", g.methods, g.paths)
+	}
+}
+
+func TestPusher_FailureIsCounted(t *testing.T) {
+	_, srv := newFakeGateway(t, http.StatusInternalServerError)
+	m, reg := newTestMetrics(t)
+
+	NewPusher(srv.URL, "worker", "pod-1", reg, m).Finish(context.Background())
+
+	if got := testutil.ToFloat64(m.pushFailures); got != 1 {
+		t.Errorf("expected one counted failure, got %v", got)
+	}
+}
+
+func TestPusher_RunPushesPeriodically(t *testing.T) {
+	g, srv := newFakeGateway(t, http.StatusOK)
+	m, reg := newTestMetrics(t)
+	p := NewPusher(srv.URL, "worker", "pod-1", reg, m)
+	p.interval = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		p.Run(ctx)
+		close(done)
+	}()
+	waitFor(t, "two periodic pushes", func() bool {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		return len(g.methods) >= 2
+	})
+	cancel()
+	<-done
+}
+
+func TestNewPusher(t *testing.T) {
+	m, reg := newTestMetrics(t)
+
+	cfg := defaultConfig()
+	if p, err := newPusher(cfg, reg, m); p != nil || err != nil {
+		t.Errorf("expected no pusher without PUSHGATEWAY_URL, got %v, %v", p, err)
+	}
+
+	cfg.PushgatewayURL = "http://pushgateway:9091"
+	cfg.PushgatewayInterval = time.Minute
+	cfg.PushgatewayDeleteOnExit = true
+	p, err := newPusher(cfg, reg, m)
+	if err != nil || p == nil {
+		t.Fatalf("expected a pusher, got %v, %v", p, err)
+	}
+	if p.interval != time.Minute || !p.deleteOnExit {
+		t.Errorf("expected 1m interval and delete on exit, got %v, %v", p.interval, p.deleteOnExit)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// readyResult is the outcome of one readiness check.
+type readyResult struct {
+	code      int
+	status    string
+	message   string
+	db        string
+	pool      *poolStats
+	dbError   *DBError
+	checks    []checkResult
+	checkedAt time.Time
+}
+
+// readyResponse is the JSON body returned by /ready.
+type readyResponse struct {
+	Status    string        `json:"status"`
+	Message   string        `json:"message,omitempty"`
+	DB        string        `json:"db,omitempty"`
+	CheckedAt string        `json:"checked_at"`
+	Checks    []checkResult `json:"checks,omitempty"`
+	DBPool    *poolStats    `json:"db_pool,omitempty"`
+	DBError   *DBError      `json:"db_error,omitempty"`
+}
+
+func (r readyResult) response() readyResponse {
+	return readyResponse{
+		Status:    r.status,
+		Message:   r.message,
+		DB:        r.db,
+		CheckedAt: r.checkedAt.UTC().Format(time.RFC3339),
+		Checks:    r.checks,
+		DBPool:    r.pool,
+		DBError:   r.dbError,
+	}
+}
+
+// readyCache remembers the last readiness result for ttl so frequent probes
+// don't each cost a DB round trip. A zero ttl disables caching.
+//
+// Concurrent callers that miss the cache share one in-flight check, so a
+// probe storm costs a single ping. The shared check is detached from the
+// caller that started it; each caller still gives up at its own deadline.
+type readyCache struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mu       sync.Mutex
+	last     readyResult
+	inFlight singleflight.Group
+}
+
+// readyTimeoutMessage is the message of a caller that gave up waiting for the
+// shared check.
+const readyTimeoutMessage = "readiness check timed out"
+
+// get returns the cached result while it is younger than ttl, otherwise it
+// runs check, or joins the one already running, and caches the outcome. A
+// caller whose ctx ends first gets a 503 with readyTimeoutMessage; the check
+// carries on for the others.
+func (c *readyCache) get(ctx context.Context, check func(context.Context) readyResult) readyResult {
+	if res, ok := c.cached(); ok {
+		return res
+	}
+	ch := c.inFlight.DoChan("ready", func() (any, error) {
+		// A check that finished since the lookup above is fresh enough.
+		if res, ok := c.cached(); ok {
+			return res, nil
+		}
+		now := c.clock()
+		res := check(context.WithoutCancel(ctx))
+		res.checkedAt = now
+		c.mu.Lock()
+		c.last = res
+		c.mu.Unlock()
+		return res, nil
+	})
+	select {
+	case shared := <-ch:
+		return shared.Val.(readyResult)
+	case <-ctx.Done():
+		return readyResult{
+			code:      http.StatusServiceUnavailable,
+			status:    "error",
+			message:   readyTimeoutMessage,
+			checkedAt: c.clock(),
+		}
+	}
+}
+
+// cached returns the last result if it is younger than ttl.
+func (c *readyCache) cached() (readyResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ttl > 0 && !c.last.checkedAt.IsZero() && c.clock().Sub(c.last.checkedAt) < c.ttl {
+		return c.last, true
+	}
+	return readyResult{}, false
+}
+
+func (c *readyCache) clock() time.Time {
+	if c.now != nil {
+		return c.now()
+	}
+	return time.Now()
+}
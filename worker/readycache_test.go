@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func countingCheck(calls *int) func(context.Context) readyResult {
+	return func(context.Context) readyResult {
+		*calls++
+		return readyResult{code: http.StatusOK, status: "ready"}
+	}
+}
+
+func TestReadyCache_ReusesResultWithinTTL(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := &readyCache{ttl: 2 * time.Second, now: func() time.Time { return now }}
+	calls := 0
+
+	first := c.get(context.Background(), countingCheck(&calls))
+	now = now.Add(time.Second)
+	second := c.get(context.Background(), countingCheck(&calls))
+
+	if calls != 1 {
+		t.Errorf("expected one check within the ttl, got %d", calls)
+	}
+	if !second.checkedAt.Equal(first.checkedAt) {
+		t.Errorf("expected cached checkedAt %v, got %v", first.checkedAt, second.checkedAt)
+	}
+
+	now = now.Add(time.Second)
+	third := c.get(context.Background(), countingCheck(&calls))
+	if calls != 2 {
+		t.Errorf("expected a fresh check once the ttl elapsed, got %d calls", calls)
+	}
+	if !third.checkedAt.Equal(now) {
+		t.Errorf("expected checkedAt %v, got %v", now, third.checkedAt)
+	}
+}
+
+func TestReadyCache_ZeroTTLDisables(t *testing.T) {
+	c := &readyCache{}
+	calls := 0
+	c.get(context.Background(), countingCheck(&calls))
+	c.get(context.Background(), countingCheck(&calls))
+	if calls != 2 {
+		t.Errorf("expected every call to check with ttl 0, got %d", calls)
+	}
+}
+
+func TestReadyCache_CallerDeadline(t *testing.T) {
+	c := &readyCache{ttl: time.Minute}
+	var calls atomic.Int32
+	release := make(chan struct{})
+	check := func(context.Context) readyResult {
+		calls.Add(1)
+		<-release
+		return readyResult{code: http.StatusOK, status: "ready"}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	res := c.get(ctx, check)
+	if res.code != http.StatusServiceUnavailable || res.message != readyTimeoutMessage {
+		t.Errorf("expected a 503 timeout for the expired caller, got %d %q", res.code, res.message)
+	}
+
+	// The check outlives the caller that started it, and its result is
+	// shared and cached rather than discarded.
+	close(release)
+	if res := c.get(context.Background(), check); res.code != http.StatusOK {
+		t.Errorf("expected the shared result, got %d %q", res.code, res.message)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("expected one check, got %d", n)
+	}
+}
+
+func TestReadyHandler_CoalescesPings(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	defer func() { _ = mockDB.Close() }()
+	dbMu.Lock()
+	db = mockDB
+	dbMu.Unlock()
+	defer func() {
+		dbMu.Lock()
+		db = nil
+		dbMu.Unlock()
+	}()
+	// Only one ping is expected: a second would fail as unexpected.
+	mock.ExpectPing().WillDelayFor(200 * time.Millisecond)
+
+	const probes = 20
+	codes := make(chan int, probes)
+	var wg sync.WaitGroup
+	for i := 0; i < probes; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rec := httptest.NewRecorder()
+			readyHandler(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+			codes <- rec.Code
+		}()
+	}
+
+	// A probe with a short deadline gives up alone.
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	rec := httptest.NewRecorder()
+	readyHandler(rec, httptest.NewRequest(http.MethodGet, "/ready", nil).WithContext(ctx))
+	var resp readyResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if rec.Code != http.StatusServiceUnavailable || resp.Message != readyTimeoutMessage {
+		t.Errorf("expected a 503 timeout for the short deadline, got %d %+v", rec.Code, resp)
+	}
+
+	wg.Wait()
+	close(codes)
+	for code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("expected every probe to share the successful ping, got %d", code)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled mock: %s", err)
+	}
+}
+
+func TestReadyHandler_CheckedAt(t *testing.T) {
+	dbMu.Lock()
+	db = nil
+	dbMu.Unlock()
+
+	req := httptest.NewRequest(http.MethodGet, "/ready", nil)
+	rec := httptest.NewRecorder()
+	readyHandler(rec, req)
+
+	var resp readyResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if resp.Message != "db not configured" {
+		t.Errorf("expected db not configured, got %q", resp.Message)
+	}
+	if _, err := time.Parse(time.RFC3339, resp.CheckedAt); err != nil {
+		t.Errorf("expected RFC3339 checked_at, got %q", resp.CheckedAt)
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// flippingChecker fails with err while it is set; tests change it between
+// evaluations.
+type flippingChecker struct {
+	mu  sync.Mutex
+	err error
+}
+
+func (c *flippingChecker) Name() string { return "database" }
+
+func (c *flippingChecker) Check(context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+func (c *flippingChecker) set(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.err = err
+}
+
+// notificationReceiver is a webhook that records the notifications posted
+// to it, failing the first failures deliveries with a 503.
+type notificationReceiver struct {
+	mu       sync.Mutex
+	failures int
+	attempts int
+	got      []readinessNotification
+}
+
+func (nr *notificationReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	nr.mu.Lock()
+	defer nr.mu.Unlock()
+	nr.attempts++
+	if nr.attempts <= nr.failures {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	var n readinessNotification
+	if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	nr.got = append(nr.got, n)
+}
+
+func (nr *notificationReceiver) notifications() []readinessNotification {
+	nr.mu.Lock()
+	defer nr.mu.Unlock()
+	return append([]readinessNotification(nil), nr.got...)
+}
+
+// newTestWatcher returns a watcher posting to url whose readiness is c,
+// beside an optional check that always passes.
+func newTestWatcher(t *testing.T, url string, c Checker) (*readinessWatcher, *prometheus.CounterVec) {
+	t.Helper()
+	outcomes := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "readiness_notifications_total"}, []string{"outcome"})
+	w := newReadinessWatcher(url, "worker", "test", time.Second, outcomes)
+	w.check = func(ctx context.Context) readyResult {
+		return runChecks(ctx, []readinessCheck{{Checker: c, required: true}, {Checker: stubChecker{"processing_loop", nil}}})
+	}
+	return w, outcomes
+}
+
+func TestReadinessWatcher_NotifiesOncePerTransition(t *testing.T) {
+	receiver := &notificationReceiver{}
+	srv := httptest.NewServer(receiver)
+	defer srv.Close()
+	checker := &flippingChecker{}
+	w, _ := newTestWatcher(t, srv.URL, checker)
+
+	steps := []struct {
+		err  error
+		want int
+	}{
+		{nil, 0}, // the baseline is never notified
+		{nil, 0},
+		{errors.New("db unreachable"), 1},
+		{errors.New("db unreachable"), 1},
+		{errors.New("db ping timeout"), 1},
+		{nil, 2},
+		{nil, 2},
+	}
+	for i, step := range steps {
+		checker.set(step.err)
+		w.evaluate(context.Background())
+		if got := len(receiver.notifications()); got != step.want {
+			t.Fatalf("step %d: expected %d notifications, got %d", i, step.want, got)
+		}
+	}
+
+	got := receiver.notifications()
+	down, up := got[0], got[1]
+	if down.Ready || down.Status != "error" || down.Message != "db unreachable" || down.Service != "worker" || down.Env != "test" || down.Version != version {
+		t.Errorf("unexpected unready notification %+v", down)
+	}
+	if len(down.FailedChecks) != 1 || down.FailedChecks[0].Name != "database" || down.FailedChecks[0].Error != "db unreachable" {
+		t.Errorf("expected only the database check listed as failed, got %+v", down.FailedChecks)
+	}
+	if _, err := time.Parse(time.RFC3339Nano, down.Time); err != nil {
+		t.Errorf("expected an RFC3339 time, got %q", down.Time)
+	}
+	if !up.Ready || up.Status != "ready" || up.FailedChecks == nil || len(up.FailedChecks) != 0 {
+		t.Errorf("unexpected ready notification %+v", up)
+	}
+}
+
+func TestReadinessWatcher_UnreadyBaseline(t *testing.T) {
+	receiver := &notificationReceiver{}
+	srv := httptest.NewServer(receiver)
+	defer srv.Close()
+	checker := &flippingChecker{err: errors.New("db connecting")}
+	w, _ := newTestWatcher(t, srv.URL, checker)
+
+	w.evaluate(context.Background())
+	checker.set(nil)
+	w.evaluate(context.Background())
+	if got := receiver.notifications(); len(got) != 1 || !got[0].Ready {
+		t.Errorf("expected a single ready notification, got %+v", got)
+	}
+}
+
+func TestReadinessWatcher_Retries(t *testing.T) {
+	prev := readinessRetryDelay
+	readinessRetryDelay = time.Millisecond
+	defer func() { readinessRetryDelay = prev }()
+
+	for _, tc := range []struct {
+		name               string
+		failures           int
+		wantAttempts       int
+		wantSent, wantFail float64
+	}{
+		{"recovers", readinessNotifyAttempts - 1, readinessNotifyAttempts, 1, 0},
+		{"gives up", readinessNotifyAttempts + 1, readinessNotifyAttempts, 0, 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			receiver := &notificationReceiver{failures: tc.failures}
+			srv := httptest.NewServer(receiver)
+			defer srv.Close()
+			checker := &flippingChecker{}
+			w, outcomes := newTestWatcher(t, srv.URL, checker)
+			var logs bytes.Buffer
+			prev := slog.Default()
+			slog.SetDefault(slog.New(slog.NewJSONHandler(&logs, nil)))
+			defer slog.SetDefault(prev)
+
+			w.evaluate(context.Background())
+			checker.set(errors.New("db unreachable"))
+			w.evaluate(context.Background())
+
+			receiver.mu.Lock()
+			attempts := receiver.attempts
+			receiver.mu.Unlock()
+			if attempts != tc.wantAttempts {
+				t.Errorf("expected %d attempts, got %d", tc.wantAttempts, attempts)
+			}
+			if got := testutil.ToFloat64(outcomes.WithLabelValues(notificationSent)); got != tc.wantSent {
+				t.Errorf("expected %v sent, got %v", tc.wantSent, got)
+			}
+			if got := testutil.ToFloat64(outcomes.WithLabelValues(notificationFailed)); got != tc.wantFail {
+				t.Errorf("expected %v failed, got %v", tc.wantFail, got)
+			}
+			if failed := strings.Contains(logs.String(), "readiness notification failed"); failed != (tc.wantFail > 0) {
+				t.Errorf("expected the failure logged only when delivery gave up, got %s", logs.String())
+			}
+		})
+	}
+}
+
+func TestReadinessWatcher_Run(t *testing.T) {
+	receiver := &notificationReceiver{}
+	srv := httptest.NewServer(receiver)
+	defer srv.Close()
+	checker := &flippingChecker{}
+	w, _ := newTestWatcher(t, srv.URL, checker)
+	w.interval = 5 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		w.Run(ctx)
+	}()
+	// Let the baseline be taken as ready before failing.
+	time.Sleep(50 * time.Millisecond)
+	checker.set(errors.New("db unreachable"))
+	deadline := time.Now().Add(2 * time.Second)
+	for len(receiver.notifications()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-done
+	if got := receiver.notifications(); len(got) != 1 || got[0].Ready {
+		t.Errorf("expected a single unready notification, got %+v", got)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync/atomic"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const defaultReconnectThreshold = 3
+
+// dbReconnecting is set while the supervisor is replacing a broken pool.
+// Readiness fails and batch processing is skipped until it clears.
+var dbReconnecting atomic.Bool
+
+// ConnectFunc opens a fresh, verified connection pool.
+type ConnectFunc func(ctx context.Context) (*sql.DB, error)
+
+// WithReconnect enables the reconnection supervisor: after threshold
+// consecutive batches fail with connection errors, the pool is closed and
+// replaced using connect.
+func WithReconnect(connect ConnectFunc, threshold int) WorkerOption {
+	return func(w *Worker) {
+		w.connect = connect
+		if threshold > 0 {
+			w.reconnectThreshold = threshold
+		}
+	}
+}
+
+// isConnectionError reports whether err indicates a broken connection
+// rather than a problem with the statement itself.
+func isConnectionError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		// Class 08 is connection_exception; 57P01-57P03 are raised while the
+		// server is shutting down or not yet accepting connections.
+		return strings.HasPrefix(pgErr.Code, "08") ||
+			pgErr.Code == "57P01" || pgErr.Code == "57P02" || pgErr.Code == "57P03"
+	}
+	var connectErr *pgconn.ConnectError
+	if errors.As(err, &connectErr) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// observeBatchError counts consecutive connection errors and starts the
+// reconnection supervisor once the threshold is reached. Callers must hold
+// w.mu.
+func (w *Worker) observeBatchError(ctx context.Context, err error) {
+	if !isConnectionError(err) {
+		w.consecutiveConnErrors = 0
+		return
+	}
+	w.consecutiveConnErrors++
+	if w.connect == nil || w.consecutiveConnErrors < w.reconnectThreshold {
+		return
+	}
+	if !dbReconnecting.CompareAndSwap(false, true) {
+		return
+	}
+	w.consecutiveConnErrors = 0
+	w.metrics.dbReconnects.Inc()
+	w.bg.Add(1)
+	go func() {
+		defer w.bg.Done()
+		w.reconnect(ctx)
+	}()
+}
+
+// reconnect closes the current pool and keeps trying to open a new one
+// until it succeeds or ctx is cancelled.
+func (w *Worker) reconnect(ctx context.Context) {
+	defer dbReconnecting.Store(false)
+	slog.Warn("db connection lost, reconnecting")
+
+	dbMu.Lock()
+	old := db
+	db = nil
+	dbMu.Unlock()
+	dbConnected.Store(false)
+	if old != nil {
+		if err := old.Close(); err != nil {
+			slog.Error("error closing db", "error", err)
+		}
+	}
+
+	for {
+		d, err := w.connect(ctx)
+		if err == nil {
+			dbMu.Lock()
+			db = d
+			dbMu.Unlock()
+			dbConnected.Store(true)
+			slog.Info("reconnected to postgres successfully")
+			recordEvent(eventDBReconnected, map[string]any{"reason": "batches failed with connection errors"})
+			return
+		}
+		slog.Error("db reconnection failed", "error", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.clock.After(w.Interval()):
+		}
+	}
+}
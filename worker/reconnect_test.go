@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestIsConnectionError(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"bad conn", driver.ErrBadConn, true},
+		{"conn done", fmt.Errorf("exec: %w", sql.ErrConnDone), true},
+		{"connection failure", &pgconn.PgError{Code: "08006"}, true},
+		{"admin shutdown", &pgconn.PgError{Code: "57P01"}, true},
+		{"syntax error", &pgconn.PgError{Code: "42601"}, false},
+		{"net error", &net.OpError{Op: "read", Err: errors.New("connection reset")}, true},
+		{"timeout", fmt.Errorf("batch timed out: %w", context.DeadlineExceeded), false},
+		{"generic", errors.New("boom"), false},
+	}
+	for _, c := range cases {
+		if got := isConnectionError(c.err); got != c.want {
+			t.Errorf("%s: expected %v, got %v", c.name, c.want, got)
+		}
+	}
+}
+
+func TestWorker_ReconnectsAfterConnectionErrors(t *testing.T) {
+	brokenDB, brokenMock, _ := sqlmock.New()
+	healthyDB, healthyMock, _ := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	defer func() { _ = healthyDB.Close() }()
+
+	dbMu.Lock()
+	db = brokenDB
+	dbMu.Unlock()
+
+	shutdown := &pgconn.PgError{Code: "57P01", Message: "terminating connection due to administrator command"}
+	expectBatchError(brokenMock, shutdown)
+	expectBatchError(brokenMock, shutdown)
+	brokenMock.ExpectClose()
+	expectBatch(healthyMock, 2)
+
+	release := make(chan struct{})
+	connect := func(ctx context.Context) (*sql.DB, error) {
+		<-release
+		return healthyDB, nil
+	}
+	w := NewWorker(10*time.Millisecond, WithReconnect(connect, 2))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.Run(ctx)
+		close(done)
+	}()
+
+	waitFor(t, "reconnection to start", dbReconnecting.Load)
+
+	// While reconnecting the worker is unready and does not process.
+	rec := httptest.NewRecorder()
+	readyHandler(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 while reconnecting, got %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "db reconnecting") {
+		t.Errorf("expected reconnecting message, got %s", rec.Body.String())
+	}
+	if !w.Stats().Reconnecting {
+		t.Error("expected stats to report reconnecting")
+	}
+
+	close(release)
+	waitFor(t, "batch on the new pool", func() bool {
+		return healthyMock.ExpectationsWereMet() == nil
+	})
+
+	cancel()
+	<-done
+
+	if err := brokenMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expected broken pool to be closed: %s", err)
+	}
+	dbMu.RLock()
+	current := db
+	dbMu.RUnlock()
+	if current != healthyDB {
+		t.Error("expected package db to be swapped to the new pool")
+	}
+}
+
+func TestWorker_NoReconnectForQueryErrors(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer func() { _ = mockDB.Close() }()
+	dbMu.Lock()
+	db = mockDB
+	dbMu.Unlock()
+
+	called := false
+	w := NewWorker(1*time.Second, WithReconnect(func(ctx context.Context) (*sql.DB, error) {
+		called = true
+		return nil, errors.New("unexpected")
+	}, 1))
+
+	expectBatchError(mock, &pgconn.PgError{Code: "42P01"})
+	w.runOnce(context.Background())
+	w.bg.Wait()
+
+	if called || dbReconnecting.Load() {
+		t.Error("expected statement errors not to trigger reconnection")
+	}
+}
+
+// waitFor polls cond until it returns true or a second elapses.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+)
+
+// reloadable is a setting a configuration reload can change while the
+// service runs. keys are its names in Config.LogValue; apply puts next's
+// value in effect and copies it into running.
+type reloadable struct {
+	keys  []string
+	apply func(running *Config, next Config) error
+}
+
+// reloadableSettings are the settings the worker applies on SIGHUP: the log
+// level, the polling interval and, when the worker started with a database,
+// the DSN.
+func reloadableSettings(w *Worker, src *dsnSource) []reloadable {
+	settings := []reloadable{
+		{keys: []string{"log_level"}, apply: func(running *Config, next Config) error {
+			logLevel.Set(next.LogLevel)
+			running.LogLevel = next.LogLevel
+			return nil
+		}},
+		{keys: []string{"interval"}, apply: func(running *Config, next Config) error {
+			w.SetInterval(next.Interval)
+			running.Interval = next.Interval
+			return nil
+		}},
+	}
+	if src != nil {
+		settings = append(settings, reloadable{keys: []string{"db_dsn", "db_dsn_file"}, apply: func(running *Config, next Config) error {
+			if next.DSN == "" {
+				return errors.New("running without a database needs a restart")
+			}
+			if err := src.switchTo(next.DSN); err != nil {
+				return err
+			}
+			running.DSN, running.DSNFile = next.DSN, next.DSNFile
+			return nil
+		}})
+	}
+	return settings
+}
+
+// settingChange is a setting whose logged value differs between two
+// configurations.
+type settingChange struct {
+	key      string
+	from, to string
+}
+
+// diffConfig lists the settings that differ between running and next,
+// compared by their Config.LogValue names and values so a change can be
+// logged without revealing secrets.
+func diffConfig(running, next Config) []settingChange {
+	before := make(map[string]string)
+	for _, a := range running.LogValue().Group() {
+		before[a.Key] = a.Value.Resolve().String()
+	}
+	var changes []settingChange
+	for _, a := range next.LogValue().Group() {
+		if from, to := before[a.Key], a.Value.Resolve().String(); from != to {
+			changes = append(changes, settingChange{key: a.Key, from: from, to: to})
+		}
+	}
+	// The logged DSN masks its password, so a rotated password alone only
+	// shows up in the DSNs themselves.
+	if running.DSN != next.DSN && redactDSN(running.DSN) == redactDSN(next.DSN) {
+		changes = append(changes, settingChange{key: "db_dsn", from: redactDSN(running.DSN), to: redactDSN(next.DSN)})
+	}
+	return changes
+}
+
+// configReloader re-runs the configuration loader and applies what changed
+// in the reloadable settings. Any other change is logged as needing a
+// restart and left alone, so running always describes what is in effect.
+type configReloader struct {
+	load     func() (Config, error)
+	settings []reloadable
+
+	// mu serializes reloads.
+	mu      sync.Mutex
+	running Config
+}
+
+// reload loads the configuration and applies it. A configuration that fails
+// to load changes nothing; a setting that fails to apply keeps its running
+// value and is reported in the returned error.
+func (r *configReloader) reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	next, err := r.load()
+	if err != nil {
+		return err
+	}
+	for _, w := range next.warnings {
+		slog.Warn("invalid logging setting, using the default", "error", w)
+	}
+
+	changes := diffConfig(r.running, next)
+	handled := make(map[string]bool)
+	var applied, restart []string
+	var errs []error
+	for _, s := range r.settings {
+		var changed []settingChange
+		for _, c := range changes {
+			if slices.Contains(s.keys, c.key) {
+				changed = append(changed, c)
+				handled[c.key] = true
+			}
+		}
+		if len(changed) == 0 {
+			continue
+		}
+		if err := s.apply(&r.running, next); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", s.keys[0], err))
+			continue
+		}
+		for _, c := range changed {
+			slog.Info("setting reloaded", "setting", c.key, "from", c.from, "to", c.to)
+			applied = append(applied, c.key)
+		}
+	}
+	for _, c := range changes {
+		if !handled[c.key] {
+			slog.Warn("setting changed, restart to apply it", "setting", c.key, "running", c.from, "loaded", c.to)
+			restart = append(restart, c.key)
+		}
+	}
+	// The sources only follow a reload that was applied in full, so they
+	// keep describing the running values.
+	if len(restart) == 0 && len(errs) == 0 {
+		r.running.sources = next.sources
+	}
+	slog.Info("configuration reloaded", "applied", applied, "restart_required", restart)
+	return errors.Join(errs...)
+}
+
+// current returns the configuration in effect.
+func (r *configReloader) current() Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.running
+}
+
+// watch reloads the configuration each time a signal arrives on hup.
+func (r *configReloader) watch(hup <-chan os.Signal) {
+	for range hup {
+		if err := r.reload(); err != nil {
+			slog.Error("configuration reload failed, keeping the running settings", "error", err)
+		}
+	}
+}
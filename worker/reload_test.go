@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestDiffConfig(t *testing.T) {
+	running := defaultConfig()
+	running.DSN = "postgres://app:first@db:5432/app"
+	next := running
+	next.HealthPort = 9000
+	next.Interval = 10 * time.Second
+	next.DSN = "postgres://app:second@db:5432/app"
+
+	got := make(map[string]settingChange)
+	for _, c := range diffConfig(running, next) {
+		got[c.key] = c
+	}
+	if len(got) != 3 {
+		t.Errorf("expected health_port, interval and db_dsn to differ, got %+v", got)
+	}
+	if c := got["interval"]; c.from != "2s" || c.to != "10s" {
+		t.Errorf("expected interval 2s -> 10s, got %+v", c)
+	}
+	if c, ok := got["db_dsn"]; !ok || strings.Contains(c.from+c.to, "first") || strings.Contains(c.from+c.to, "second") {
+		t.Errorf("expected the rotated password detected without revealing it, got %+v", c)
+	}
+	if changes := diffConfig(running, running); len(changes) != 0 {
+		t.Errorf("expected no changes, got %+v", changes)
+	}
+}
+
+// reloadSequence returns a loader that hands out configs in order, then
+// keeps returning the last.
+func reloadSequence(configs ...Config) func() (Config, error) {
+	var mu sync.Mutex
+	return func() (Config, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		next := configs[0]
+		if len(configs) > 1 {
+			configs = configs[1:]
+		}
+		return next, nil
+	}
+}
+
+func TestConfigReloader_AppliesSafeSettings(t *testing.T) {
+	var logs bytes.Buffer
+	prevLogger, prevLevel := slog.Default(), logLevel.Level()
+	slog.SetDefault(slog.New(newLogHandler(&logs, logFormatJSON, false)))
+	defer func() {
+		slog.SetDefault(prevLogger)
+		logLevel.Set(prevLevel)
+	}()
+
+	running := defaultConfig()
+	w := NewWorker(running.Interval)
+	next := running
+	next.LogLevel = slog.LevelDebug
+	next.Interval = 10 * time.Second
+	next.BatchSize = running.BatchSize * 2
+	r := &configReloader{load: reloadSequence(next), settings: reloadableSettings(w, nil), running: running}
+
+	// Send the signal the way the process's SIGHUP channel would.
+	hup := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	go func() {
+		r.watch(hup)
+		close(done)
+	}()
+	hup <- syscall.SIGHUP
+	close(hup)
+	<-done
+
+	if logLevel.Level() != slog.LevelDebug {
+		t.Errorf("expected the log level applied, got %s", logLevel.Level())
+	}
+	if w.Interval() != 10*time.Second || testutil.ToFloat64(w.metrics.intervalSeconds) != 10 {
+		t.Errorf("expected the interval applied, got %s", w.Interval())
+	}
+	if r.running.BatchSize != running.BatchSize {
+		t.Error("expected the restart-only settings left at their running values")
+	}
+	out := logs.String()
+	for _, want := range []string{
+		`"msg":"setting reloaded","setting":"interval","from":"2s","to":"10s"`,
+		`"msg":"setting changed, restart to apply it","setting":"batch_size"`,
+		`"msg":"configuration reloaded","applied":["log_level","interval"],"restart_required":["batch_size"]`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %s in the logs, got %s", want, out)
+		}
+	}
+}
+
+func TestConfigReloader_SwitchesDSN(t *testing.T) {
+	resetLogSecrets(t)
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(io.Discard, nil)))
+	defer slog.SetDefault(prev)
+	old, oldMock, _ := sqlmock.New()
+	next, _, _ := sqlmock.New()
+	defer func() { _ = next.Close() }()
+	dbMu.Lock()
+	db = old
+	dbMu.Unlock()
+	defer func() {
+		dbMu.Lock()
+		db = nil
+		dbMu.Unlock()
+	}()
+	current := func() *sql.DB {
+		dbMu.RLock()
+		defer dbMu.RUnlock()
+		return db
+	}
+
+	running := defaultConfig()
+	running.DSN = "postgres://app:first@db:5432/app"
+	src := &dsnSource{dsn: running.DSN, connect: func(dsn string) (*sql.DB, error) {
+		if strings.Contains(dsn, "unreachable") {
+			return nil, errors.New("connection refused")
+		}
+		return next, nil
+	}}
+	unreachable, rotated := running, running
+	unreachable.DSN = "postgres://app:unreachable@db:5432/app"
+	rotated.DSN = "postgres://app:second@db:5432/app"
+	r := &configReloader{load: reloadSequence(unreachable, rotated), settings: reloadableSettings(NewWorker(time.Second), src), running: running}
+
+	if err := r.reload(); err == nil || !strings.Contains(err.Error(), "db_dsn") {
+		t.Errorf("expected the failed switch reported, got %v", err)
+	}
+	if r.running.DSN != running.DSN || current() != old {
+		t.Error("expected the running pool kept when the new DSN can't connect")
+	}
+	oldMock.ExpectClose()
+	if err := r.reload(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if r.running.DSN != rotated.DSN || current() != next {
+		t.Error("expected the pool switched to the rotated DSN")
+	}
+}
+
+func TestWorker_SetIntervalWhileRunning(t *testing.T) {
+	w := NewWorker(time.Second)
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 200 {
+				_ = w.idleInterval()
+				_ = w.Stats()
+			}
+		}()
+	}
+	for i := range 50 {
+		w.SetInterval(time.Duration(i+1) * time.Second)
+	}
+	wg.Wait()
+	if w.Interval() != 50*time.Second {
+		t.Errorf("expected the last interval in effect, got %s", w.Interval())
+	}
+}
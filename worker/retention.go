@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultPurgeInterval = 10 * time.Minute
+
+// Purger deletes processed api_logs rows older than the retention period.
+// When an Archiver is configured, each batch is archived first and only
+// deleted once the archive write has succeeded.
+type Purger struct {
+	metrics   *metrics
+	retention time.Duration
+	interval  time.Duration
+	batchSize int
+	archiver  Archiver
+}
+
+// NewPurger creates a Purger reporting to m. archiver may be nil to delete
+// without archiving.
+func NewPurger(retention time.Duration, archiver Archiver, m *metrics) *Purger {
+	return &Purger{
+		metrics:   m,
+		retention: retention,
+		interval:  defaultPurgeInterval,
+		batchSize: defaultBatchSize,
+		archiver:  archiver,
+	}
+}
+
+// Run purges expired rows every interval until ctx is cancelled.
+func (p *Purger) Run(ctx context.Context) {
+	slog.Info("retention purge started",
+		"retention", p.retention.String(),
+		"interval", p.interval.String(),
+		"archive", p.archiver != nil,
+	)
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		if deleted, err := p.purge(ctx); err != nil && ctx.Err() == nil {
+			slog.Error("retention purge failed", "deleted", deleted, "error", err)
+		} else if deleted > 0 {
+			slog.Info("retention purge completed", "deleted", deleted)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// purge deletes expired rows batch by batch until none remain.
+func (p *Purger) purge(ctx context.Context) (int, error) {
+	dbMu.RLock()
+	d := db
+	dbMu.RUnlock()
+	if d == nil || dbReconnecting.Load() {
+		return 0, errDBNotConnected
+	}
+
+	cutoff := time.Now().Add(-p.retention)
+	total := 0
+	for ctx.Err() == nil {
+		n, err := p.purgeBatch(ctx, d, cutoff)
+		total += n
+		if err != nil {
+			return total, err
+		}
+		if n < p.batchSize {
+			break
+		}
+	}
+	return total, ctx.Err()
+}
+
+// purgeBatch locks one batch of expired rows, archives them, and deletes
+// them in a single transaction. Any archive failure rolls back so the rows
+// are retried on the next run.
+func (p *Purger) purgeBatch(ctx context.Context, d *sql.DB, cutoff time.Time) (int, error) {
+	tx, err := d.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	rows, err := selectExpired(ctx, tx, cutoff, p.batchSize)
+	if err != nil || len(rows) == 0 {
+		return 0, err
+	}
+
+	if p.archiver != nil {
+		var buf bytes.Buffer
+		if err := writeArchive(&buf, rows); err != nil {
+			p.metrics.archiveFailures.Inc()
+			return 0, fmt.Errorf("encode archive: %w", err)
+		}
+		name := archiveName(rows)
+		if err := p.archiver.Archive(ctx, name, buf.Bytes()); err != nil {
+			p.metrics.archiveFailures.Inc()
+			return 0, fmt.Errorf("write archive %s: %w", name, err)
+		}
+		p.metrics.logsArchived.Add(float64(len(rows)))
+		slog.Info("archived api logs", "file", name, "count", len(rows))
+	}
+
+	ids := make([]int64, len(rows))
+	for i, r := range rows {
+		ids[i] = r.ID
+	}
+	res, err := tx.ExecContext(ctx, `DELETE FROM api_logs WHERE id = ANY($1::bigint[])`, idArray(ids))
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	deleted, _ := res.RowsAffected()
+	p.metrics.logsPurged.Add(float64(deleted))
+	return int(deleted), nil
+}
+
+func selectExpired(ctx context.Context, tx *sql.Tx, cutoff time.Time, limit int) ([]archivedRow, error) {
+	rs, err := tx.QueryContext(ctx, `
+		SELECT id, method, endpoint, status, duration_ms, remote_addr, created_at, processed_at
+		FROM api_logs
+		WHERE processed_at IS NOT NULL AND created_at < $1
+		ORDER BY id
+		LIMIT $2
+		FOR UPDATE SKIP LOCKED
+	`, cutoff, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rs.Close() }()
+
+	var rows []archivedRow
+	for rs.Next() {
+		var (
+			r                        archivedRow
+			method, endpoint, remote sql.NullString
+			status                   sql.NullInt64
+			duration                 sql.NullFloat64
+			createdAt, processedAt   sql.NullTime
+		)
+		if err := rs.Scan(&r.ID, &method, &endpoint, &status, &duration, &remote, &createdAt, &processedAt); err != nil {
+			return nil, err
+		}
+		r.Method, r.Endpoint, r.RemoteAddr = method.String, endpoint.String, remote.String
+		r.Status, r.DurationMs = int(status.Int64), duration.Float64
+		r.CreatedAt, r.ProcessedAt = createdAt.Time, processedAt.Time
+		rows = append(rows, r)
+	}
+	return rows, rs.Err()
+}
+
+// idArray formats ids as a Postgres array literal, e.g. "{1,2,3}".
+func idArray(ids []int64) string {
+	s := make([]string, len(ids))
+	for i, id := range ids {
+		s[i] = strconv.FormatInt(id, 10)
+	}
+	return "{" + strings.Join(s, ",") + "}"
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+type fakeArchiver struct {
+	names []string
+	err   error
+}
+
+func (f *fakeArchiver) Archive(ctx context.Context, name string, data []byte) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.names = append(f.names, name)
+	return nil
+}
+
+func expiredRows() *sqlmock.Rows {
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	return sqlmock.NewRows([]string{"id", "method", "endpoint", "status", "duration_ms", "remote_addr", "created_at", "processed_at"}).
+		AddRow(1, "GET", "/live", 200, 1.0, "10.0.0.1", created, created).
+		AddRow(2, "GET", "/ready", 503, 2.0, nil, created.Add(time.Minute), created.Add(time.Minute))
+}
+
+func TestPurger_ArchivesThenDeletes(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer func() { _ = mockDB.Close() }()
+	dbMu.Lock()
+	db = mockDB
+	dbMu.Unlock()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, method, endpoint").WillReturnRows(expiredRows())
+	mock.ExpectExec("DELETE FROM api_logs").WithArgs("{1,2}").WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	archiver := &fakeArchiver{}
+	m, _ := newTestMetrics(t)
+	p := NewPurger(24*time.Hour, archiver, m)
+	before := testutil.ToFloat64(m.logsPurged)
+
+	deleted, err := p.purge(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if deleted != 2 {
+		t.Errorf("expected 2 deleted, got %d", deleted)
+	}
+	if len(archiver.names) != 1 || archiver.names[0] != "api_logs_20240101T000000Z_20240101T000100Z_1-2.csv.gz" {
+		t.Errorf("unexpected archive names %v", archiver.names)
+	}
+	if got := testutil.ToFloat64(m.logsPurged) - before; got != 2 {
+		t.Errorf("expected purged counter +2, got %v", got)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled mock: %s", err)
+	}
+}
+
+func TestPurger_ArchiveFailureSkipsDeletion(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer func() { _ = mockDB.Close() }()
+	dbMu.Lock()
+	db = mockDB
+	dbMu.Unlock()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, method, endpoint").WillReturnRows(expiredRows())
+	mock.ExpectRollback()
+
+	m, _ := newTestMetrics(t)
+	p := NewPurger(24*time.Hour, &fakeArchiver{err: errors.New("bucket unavailable")}, m)
+	before := testutil.ToFloat64(m.archiveFailures)
+
+	deleted, err := p.purge(context.Background())
+	if err == nil {
+		t.Error("expected archive error")
+	}
+	if deleted != 0 {
+		t.Errorf("expected nothing deleted, got %d", deleted)
+	}
+	if got := testutil.ToFloat64(m.archiveFailures) - before; got != 1 {
+		t.Errorf("expected archive failure counter +1, got %v", got)
+	}
+	// No DELETE expectation was registered, so any delete would fail here.
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled mock: %s", err)
+	}
+}
+
+func TestPurger_NothingExpired(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer func() { _ = mockDB.Close() }()
+	dbMu.Lock()
+	db = mockDB
+	dbMu.Unlock()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, method, endpoint").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "method", "endpoint", "status", "duration_ms", "remote_addr", "created_at", "processed_at"}))
+	mock.ExpectRollback()
+
+	m, _ := newTestMetrics(t)
+	deleted, err := NewPurger(time.Hour, nil, m).purge(context.Background())
+	if err != nil || deleted != 0 {
+		t.Errorf("expected no-op purge, got %d, %v", deleted, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled mock: %s", err)
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import "database/sql"
+
+// knownRoutes mirrors the API's registered routes so per-endpoint series
+// derived from api_logs rows use the same bounded label set as the API's
+// own metrics. Anything else, including junk paths from old rows, collapses
+// to "/other".
+var knownRoutes = map[string]string{
+	"/live":        "/live",
+	"/ready":       "/ready",
+	"/metrics":     "/metrics",
+	"/api/v1/time": "/api/v1/time",
+	"/version":     "/version",
+	"/startup":     "/startup",
+	"/healthz":     "/healthz",
+}
+
+func routePattern(path string) string {
+	if route, ok := knownRoutes[path]; ok {
+		return route
+	}
+	return "/other"
+}
+
+// statusClass maps an HTTP status code to its class label, e.g. "4xx".
+func statusClass(status sql.NullInt64) string {
+	if !status.Valid || status.Int64 < 100 || status.Int64 > 599 {
+		return "unknown"
+	}
+	return string(rune('0'+status.Int64/100)) + "xx"
+}
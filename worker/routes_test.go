@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRoutePattern(t *testing.T) {
+	if r := routePattern("/api/v1/time"); r != "/api/v1/time" {
+		t.Errorf("expected '/api/v1/time', got '%s'", r)
+	}
+	if r := routePattern("/wp-admin/../../etc/passwd"); r != "/other" {
+		t.Errorf("expected '/other', got '%s'", r)
+	}
+	if r := routePattern(""); r != "/other" {
+		t.Errorf("expected '/other' for empty endpoint, got '%s'", r)
+	}
+}
+
+func TestStatusClass(t *testing.T) {
+	cases := []struct {
+		status sql.NullInt64
+		want   string
+	}{
+		{sql.NullInt64{Int64: 200, Valid: true}, "2xx"},
+		{sql.NullInt64{Int64: 304, Valid: true}, "3xx"},
+		{sql.NullInt64{Int64: 429, Valid: true}, "4xx"},
+		{sql.NullInt64{Int64: 503, Valid: true}, "5xx"},
+		{sql.NullInt64{Int64: 42, Valid: true}, "unknown"},
+		{sql.NullInt64{}, "unknown"},
+	}
+	for _, c := range cases {
+		if got := statusClass(c.status); got != c.want {
+			t.Errorf("status %v: expected %s, got %s", c.status, c.want, got)
+		}
+	}
+}
+
+func TestProcessLogs_BreakdownByStatus(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer func() { _ = mockDB.Close() }()
+	dbMu.Lock()
+	db = mockDB
+	dbMu.Unlock()
+
+	w := NewWorker(1 * time.Second)
+	timeOK := w.metrics.processedByStatus.WithLabelValues("/api/v1/time", "2xx")
+	timeErr := w.metrics.processedByStatus.WithLabelValues("/api/v1/time", "5xx")
+	other := w.metrics.processedByStatus.WithLabelValues("/other", "4xx")
+	beforeOK, beforeErr, beforeOther := testutil.ToFloat64(timeOK), testutil.ToFloat64(timeErr), testutil.ToFloat64(other)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(claimQuery).WillReturnRows(sqlmock.NewRows([]string{"id", "method", "endpoint", "status", "duration_ms", "remote_addr", "created_at"}).
+		AddRow(1, "GET", "/api/v1/time", 200, 1.0, "10.0.0.1", nil).
+		AddRow(2, "GET", "/api/v1/time", 200, 1.0, "10.0.0.1", nil).
+		AddRow(3, "GET", "/api/v1/time", 503, 1.0, "10.0.0.1", nil).
+		AddRow(4, "GET", "/random/scanner/path", 404, 1.0, "10.0.0.1", nil).
+		AddRow(5, nil, nil, nil, nil, nil, nil))
+	expectMark(mock, 5)
+
+	processed, err := w.processLogs(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if processed.rows != 5 {
+		t.Errorf("expected 5 processed rows, got %d", processed.rows)
+	}
+	if got := testutil.ToFloat64(timeOK) - beforeOK; got != 2 {
+		t.Errorf("expected 2 time/2xx, got %v", got)
+	}
+	if got := testutil.ToFloat64(timeErr) - beforeErr; got != 1 {
+		t.Errorf("expected 1 time/5xx, got %v", got)
+	}
+	if got := testutil.ToFloat64(other) - beforeOther; got != 1 {
+		t.Errorf("expected unknown path collapsed to /other, got %v", got)
+	}
+	if got := testutil.ToFloat64(w.metrics.processedByStatus.WithLabelValues("/other", "unknown")); got < 1 {
+		t.Errorf("expected NULL row counted as /other/unknown, got %v", got)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestParseSchedule_Invalid(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"@sometimes",
+	} {
+		if _, err := ParseSchedule(expr); err == nil {
+			t.Errorf("expected error for %q", expr)
+		}
+	}
+}
+
+func TestSchedule_Next(t *testing.T) {
+	base := time.Date(2024, 3, 15, 10, 7, 30, 0, time.UTC) // a Friday
+	cases := []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2024, 3, 15, 10, 8, 0, 0, time.UTC)},
+		{"5 * * * *", time.Date(2024, 3, 15, 11, 5, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, 3, 15, 10, 15, 0, 0, time.UTC)},
+		{"0 9-17/4 * * *", time.Date(2024, 3, 15, 13, 0, 0, 0, time.UTC)},
+		{"30 2 * * *", time.Date(2024, 3, 16, 2, 30, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 0", time.Date(2024, 3, 17, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, 3, 17, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 20 * 1", time.Date(2024, 3, 18, 0, 0, 0, 0, time.UTC)}, // dom OR dow
+		{"@hourly", time.Date(2024, 3, 15, 11, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2024, 3, 16, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, c := range cases {
+		s, err := ParseSchedule(c.expr)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", c.expr, err)
+			continue
+		}
+		if got := s.Next(base); !got.Equal(c.want) {
+			t.Errorf("%q: expected %v, got %v", c.expr, c.want, got)
+		}
+	}
+}
+
+func TestSchedule_NextNeverFires(t *testing.T) {
+	s, err := ParseSchedule("0 0 30 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next := s.Next(time.Now()); !next.IsZero() {
+		t.Errorf("expected zero time for impossible schedule, got %v", next)
+	}
+}
+
+func TestWorker_DrainBacklog(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer func() { _ = mockDB.Close() }()
+	dbMu.Lock()
+	db = mockDB
+	dbMu.Unlock()
+
+	expectBatch(mock, 3)
+	expectBatch(mock, 2)
+	expectBatch(mock, 0)
+
+	s, _ := ParseSchedule("5 * * * *")
+	clock := newFakeClock(time.Date(2024, 1, 1, 0, 5, 0, 0, time.UTC))
+	w := NewWorker(time.Minute, WithSchedule(s), WithClock(clock))
+	w.nextRunAt = clock.Now()
+
+	if delay := w.runScheduled(context.Background()); delay != time.Hour {
+		t.Errorf("expected delay until the next hourly run, got %v", delay)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expected the backlog to be drained until empty: %s", err)
+	}
+}
+
+func TestWorker_ScheduledRunNotDue(t *testing.T) {
+	mockDB, mock, _ := sqlmock.New()
+	defer func() { _ = mockDB.Close() }()
+	dbMu.Lock()
+	db = mockDB
+	dbMu.Unlock()
+
+	s, _ := ParseSchedule("5 * * * *")
+	w := NewWorker(time.Minute, WithSchedule(s))
+	w.runScheduled(context.Background())
+
+	// No query expectations: processing before the first scheduled time fails the mock.
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unexpected processing: %s", err)
+	}
+}
+
+func TestWorker_ScheduleModeStatsAndCancel(t *testing.T) {
+	dbMu.Lock()
+	db = nil
+	dbMu.Unlock()
+
+	s, _ := ParseSchedule("0 0 1 1 *")
+	w := NewWorker(time.Minute, WithSchedule(s))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.Run(ctx)
+		close(done)
+	}()
+
+	waitFor(t, "next run to be planned", func() bool { return w.Stats().NextRunAt != "" })
+	stats := w.Stats()
+	if stats.Schedule != "0 0 1 1 *" {
+		t.Errorf("expected schedule in stats, got %q", stats.Schedule)
+	}
+	next := s.Next(time.Now())
+	if stats.NextRunAt != next.UTC().Format(time.RFC3339) {
+		t.Errorf("expected next_run_at %s, got %s", next.UTC().Format(time.RFC3339), stats.NextRunAt)
+	}
+	if got := testutil.ToFloat64(w.metrics.nextRunTimestamp); got != float64(next.Unix()) {
+		t.Errorf("expected gauge %d, got %v", next.Unix(), got)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected Run to stop promptly in schedule mode")
+	}
+}
+
+func TestWorker_ScheduleStaleness(t *testing.T) {
+	s, _ := ParseSchedule("0 * * * *")
+	clock := newFakeClock(time.Date(2024, 1, 1, 0, 50, 0, 0, time.UTC))
+	w := NewWorker(time.Minute, WithSchedule(s), WithClock(clock))
+
+	// Long idle gaps between scheduled runs are expected.
+	w.lastRunAt = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	w.nextRunAt = time.Date(2024, 1, 1, 1, 0, 0, 0, time.UTC)
+	if !w.IsHealthy() {
+		t.Error("expected worker to be healthy between scheduled runs")
+	}
+
+	// A scheduled run that is well overdue is stale.
+	clock.Advance(20 * time.Minute)
+	if w.IsHealthy() {
+		t.Error("expected worker to be stale when a scheduled run is overdue")
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	defaultShutdownTimeout = 25 * time.Second
+
+	// shutdownReserve is kept back from the loops phase so a long batch
+	// can't leave the final push and database close without time.
+	shutdownReserve = 2 * time.Second
+)
+
+// shutdownPhase is one step of an ordered shutdown.
+type shutdownPhase struct {
+	name string
+	// timeout bounds the phase. Zero gives it whatever is left of the
+	// overall budget.
+	timeout time.Duration
+	run     func(ctx context.Context) error
+}
+
+// runShutdown runs phases one after another within budget, giving each a
+// deadline of its own and logging how long it took. A phase that overruns
+// its deadline is reported and the next one starts, so a stuck phase can't
+// push the shutdown past budget. Once the budget is spent the remaining
+// phases still start, with a cancelled context, but aren't waited for.
+func runShutdown(budget time.Duration, phases []shutdownPhase) {
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), budget)
+	defer cancel()
+	for _, p := range phases {
+		phaseStart := time.Now()
+		pctx, pcancel := ctx, context.CancelFunc(func() {})
+		if p.timeout > 0 {
+			pctx, pcancel = context.WithTimeout(ctx, p.timeout)
+		}
+		done := make(chan error, 1)
+		go func() { done <- p.run(pctx) }()
+		var err error
+		select {
+		case err = <-done:
+		case <-pctx.Done():
+			err = pctx.Err()
+		}
+		pcancel()
+		if err != nil {
+			slog.Error("shutdown phase failed", "phase", p.name, "duration", time.Since(phaseStart), "error", err)
+			recordEvent(eventForcedShutdown, map[string]any{"phase": p.name, "error": err.Error()})
+			continue
+		}
+		slog.Info("shutdown phase finished", "phase", p.name, "duration", time.Since(phaseStart))
+	}
+	slog.Info("shutdown complete", "duration", time.Since(start), "budget", budget)
+}
+
+// shutdownSequence stops the worker after a termination signal, letting
+// in-flight batches finish before the final push and before the health
+// server and database go away.
+type shutdownSequence struct {
+	// timeout bounds the whole sequence.
+	timeout time.Duration
+	// stopLoops cancels the worker, purger and pusher loops; loops is done
+	// once they have all returned.
+	stopLoops func()
+	loops     *sync.WaitGroup
+	pusher    *Pusher
+	// closeSummaries, when set, delivers the queued batch summaries.
+	closeSummaries func(ctx context.Context) error
+	// closeReports, when set, delivers the queued error reports.
+	closeReports func(ctx context.Context) error
+	healthServer *http.Server
+	// adminServer, when set, is the ADMIN_PORT server.
+	adminServer *http.Server
+	// closeEvents, when set, writes the queued lifecycle events.
+	closeEvents func(ctx context.Context) error
+	// closeDB, when set, closes the database pool.
+	closeDB func() error
+	// flushMetrics, when set, makes the final OTLP metrics push.
+	flushMetrics func(ctx context.Context) error
+	// flushTraces, when set, exports the spans still buffered last.
+	flushTraces func(ctx context.Context) error
+}
+
+// run waits for a signal on quit and then shuts down in order: stop the
+// loops and wait for them, make the final Pushgateway push, deliver the
+// queued batch summaries and error reports, shut the admin and health
+// servers down, write the queued lifecycle events, close the database, make
+// the final OTLP metrics push and flush the traces.
+func (s shutdownSequence) run(quit <-chan os.Signal) {
+	sig := <-quit
+	slog.Info("shutdown signal received", "signal", sig.String(), "timeout", s.timeout)
+	recordEvent(eventShutdown, map[string]any{"signal": sig.String()})
+	runShutdown(s.timeout, s.phases())
+}
+
+func (s shutdownSequence) phases() []shutdownPhase {
+	phases := []shutdownPhase{
+		{name: "loops", timeout: s.loopsTimeout(), run: func(ctx context.Context) error {
+			s.stopLoops()
+			done := make(chan struct{})
+			go func() {
+				s.loops.Wait()
+				close(done)
+			}()
+			select {
+			case <-done:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}},
+	}
+	if s.pusher != nil {
+		phases = append(phases, shutdownPhase{name: "pushgateway", run: func(ctx context.Context) error {
+			s.pusher.Finish(ctx)
+			return nil
+		}})
+	}
+	if s.closeSummaries != nil {
+		phases = append(phases, shutdownPhase{name: "batch summaries", run: s.closeSummaries})
+	}
+	if s.closeReports != nil {
+		phases = append(phases, shutdownPhase{name: "error reports", run: s.closeReports})
+	}
+	if s.adminServer != nil {
+		phases = append(phases, shutdownPhase{name: "admin server", run: s.adminServer.Shutdown})
+	}
+	phases = append(phases, shutdownPhase{name: "health server", run: s.healthServer.Shutdown})
+	if s.closeEvents != nil {
+		phases = append(phases, shutdownPhase{name: "events", run: s.closeEvents})
+	}
+	if s.closeDB != nil {
+		phases = append(phases, shutdownPhase{name: "db", run: func(context.Context) error {
+			return s.closeDB()
+		}})
+	}
+	if s.flushMetrics != nil {
+		phases = append(phases, shutdownPhase{name: "otlp metrics", run: s.flushMetrics})
+	}
+	if s.flushTraces != nil {
+		phases = append(phases, shutdownPhase{name: "traces", run: s.flushTraces})
+	}
+	return phases
+}
+
+// loopsTimeout is what is left of the budget after shutdownReserve, or zero
+// (the whole budget) when that leaves nothing.
+func (s shutdownSequence) loopsTimeout() time.Duration {
+	return max(s.timeout-shutdownReserve, 0)
+}
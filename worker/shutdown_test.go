@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRunShutdown_Order(t *testing.T) {
+	var buf bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	defer slog.SetDefault(prev)
+
+	var order []string
+	phase := func(name string) shutdownPhase {
+		return shutdownPhase{name: name, run: func(context.Context) error {
+			order = append(order, name)
+			return nil
+		}}
+	}
+	failing := shutdownPhase{name: "pushgateway", run: func(context.Context) error { return errors.New("boom") }}
+
+	runShutdown(time.Second, []shutdownPhase{phase("loops"), failing, phase("health server"), phase("db")})
+
+	if got := strings.Join(order, ","); got != "loops,health server,db" {
+		t.Errorf("expected phases to run in order despite a failure, got %s", got)
+	}
+	out := buf.String()
+	for _, want := range []string{`"phase":"loops"`, `"msg":"shutdown phase failed","phase":"pushgateway"`, `"msg":"shutdown complete"`, `"duration"`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %s in the shutdown logs, got %s", want, out)
+		}
+	}
+}
+
+func TestRunShutdown_RespectsBudget(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	ran := make(chan struct{}, 1)
+	phases := []shutdownPhase{
+		// A phase that ignores its context must not hold up the shutdown.
+		{name: "stuck", run: func(context.Context) error {
+			<-release
+			return nil
+		}},
+		{name: "db", run: func(context.Context) error {
+			ran <- struct{}{}
+			return nil
+		}},
+	}
+	start := time.Now()
+	runShutdown(100*time.Millisecond, phases)
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("expected shutdown to finish within its budget, took %s", elapsed)
+	}
+	select {
+	case <-ran:
+	case <-time.After(time.Second):
+		t.Error("expected the phases after the budget to still be started")
+	}
+}
+
+func TestShutdownSequence(t *testing.T) {
+	health := httptest.NewServer(http.NotFoundHandler())
+	defer health.Close()
+	admin := httptest.NewServer(newAdminServer(0, "s3cret", http.NotFoundHandler(), false).Handler)
+	defer admin.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var loops sync.WaitGroup
+	batchDone := false
+	loops.Go(func() {
+		<-ctx.Done()
+		time.Sleep(50 * time.Millisecond) // an in-flight batch finishing
+		batchDone = true
+	})
+	summariesClosed, reportsClosed, dbClosed, tracesFlushed := false, false, false, false
+	seq := shutdownSequence{
+		timeout:      5 * time.Second,
+		stopLoops:    cancel,
+		loops:        &loops,
+		healthServer: health.Config,
+		adminServer:  admin.Config,
+		closeSummaries: func(context.Context) error {
+			if !batchDone {
+				t.Error("expected the batch summaries to be delivered after the loops returned")
+			}
+			summariesClosed = true
+			return nil
+		},
+		closeReports: func(context.Context) error {
+			if !summariesClosed {
+				t.Error("expected the error reports to be delivered after the batch summaries")
+			}
+			reportsClosed = true
+			return nil
+		},
+		closeDB: func() error {
+			if !reportsClosed {
+				t.Error("expected the database to be closed after the error reports were delivered")
+			}
+			dbClosed = true
+			return nil
+		},
+		flushTraces: func(context.Context) error {
+			if !dbClosed {
+				t.Error("expected the traces to be flushed after the database was closed")
+			}
+			tracesFlushed = true
+			return nil
+		},
+	}
+	quit := make(chan os.Signal, 1)
+	quit <- syscall.SIGTERM
+	seq.run(quit)
+
+	if !batchDone || !dbClosed || !tracesFlushed {
+		t.Errorf("expected the loops to finish, the database to close and the traces to flush, got batch=%v db=%v traces=%v", batchDone, dbClosed, tracesFlushed)
+	}
+	if _, err := http.Get(health.URL); err == nil {
+		t.Error("expected the health server to be stopped")
+	}
+	if _, err := http.Get(admin.URL); err == nil {
+		t.Error("expected the admin server to be stopped")
+	}
+}
+
+func TestShutdownSequence_SlowLoop(t *testing.T) {
+	health := httptest.NewServer(http.NotFoundHandler())
+	defer health.Close()
+
+	// A loop that ignores cancellation is cut off at its phase deadline,
+	// which still leaves the reserve for the remaining phases.
+	release := make(chan struct{})
+	defer close(release)
+	var loops sync.WaitGroup
+	loops.Go(func() { <-release })
+	dbClosed := make(chan struct{})
+	seq := shutdownSequence{
+		timeout:      shutdownReserve + 200*time.Millisecond,
+		stopLoops:    func() {},
+		loops:        &loops,
+		healthServer: health.Config,
+		closeDB: func() error {
+			close(dbClosed)
+			return nil
+		},
+	}
+	quit := make(chan os.Signal, 1)
+	quit <- syscall.SIGTERM
+	start := time.Now()
+	seq.run(quit)
+	elapsed := time.Since(start)
+	if elapsed < seq.loopsTimeout() || elapsed > seq.timeout {
+		t.Errorf("expected the loops phase to take its %s deadline within the %s budget, took %s", seq.loopsTimeout(), seq.timeout, elapsed)
+	}
+	select {
+	case <-dbClosed:
+	default:
+		t.Error("expected the database to be closed after a slow loop")
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// listenStatsd binds a local UDP agent and returns its address and a
+// function reading the next packet from it.
+func listenStatsd(t *testing.T) (string, func() string) {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn.LocalAddr().String(), func() string {
+		t.Helper()
+		buf := make([]byte, 1500)
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("expected a statsd packet: %v", err)
+		}
+		return string(buf[:n])
+	}
+}
+
+func newTestStatsd(t *testing.T, addr, prefix string, tags ...string) (*statsdClient, prometheus.Counter) {
+	t.Helper()
+	failures := prometheus.NewCounter(prometheus.CounterOpts{Name: "statsd_send_failures_total"})
+	c, err := newStatsdClient(addr, prefix, tags, failures)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, failures
+}
+
+func TestStatsdClient_Packets(t *testing.T) {
+	addr, next := listenStatsd(t)
+	c, _ := newTestStatsd(t, addr, "agnos.", "service:worker", "env:test")
+
+	c.count("worker.logs_processed", 2, "partition:0")
+	c.timing("worker.batch.duration", 1500*time.Microsecond)
+	c.gauge("worker.backlog", 42)
+	for _, want := range []string{
+		"agnos.worker.logs_processed:2|c|#service:worker,env:test,partition:0",
+		"agnos.worker.batch.duration:1.5|ms|#service:worker,env:test",
+		"agnos.worker.backlog:42|g|#service:worker,env:test",
+	} {
+		if got := next(); got != want {
+			t.Errorf("expected %q, got %q", want, got)
+		}
+	}
+
+	bare, _ := newTestStatsd(t, addr, "")
+	bare.count("worker.batch.errors", 1)
+	if got := next(); got != "worker.batch.errors:1|c" {
+		t.Errorf("expected no tag section without tags, got %q", got)
+	}
+}
+
+func TestStatsdClient_Failures(t *testing.T) {
+	addr, _ := listenStatsd(t)
+	c, failures := newTestStatsd(t, addr, "")
+	_ = c.conn.Close()
+
+	c.count("worker.batch.errors", 1)
+	if got := testutil.ToFloat64(failures); got != 1 {
+		t.Errorf("expected the failed send to be counted, got %v", got)
+	}
+
+	var disabled *statsdClient
+	disabled.count("worker.batch.errors", 1) // must not panic
+}
+
+func TestParseStatsdTags(t *testing.T) {
+	tags, err := parseStatsdTags(" team:platform , region:th,canary")
+	if want := []string{"team:platform", "region:th", "canary"}; err != nil || !slices.Equal(tags, want) {
+		t.Errorf("expected %v, got %v, %v", want, tags, err)
+	}
+	for _, s := range []string{"team:platform,", ":th", "team:a|b", "team:#1", "team:a b"} {
+		if _, err := parseStatsdTags(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestStatsdTag(t *testing.T) {
+	if got := statsdTag("env", "a|b,c#d"); got != "env:a_b_c_d" {
+		t.Errorf("expected reserved characters replaced, got %q", got)
+	}
+}
+
+func TestRunBatch_Statsd(t *testing.T) {
+	addr, next := listenStatsd(t)
+	m, _ := newTestMetrics(t)
+	m.statsd, _ = newTestStatsd(t, addr, "")
+	mockDB, mock, _ := sqlmock.New()
+	defer func() { _ = mockDB.Close() }()
+	dbMu.Lock()
+	db = mockDB
+	dbMu.Unlock()
+
+	w := NewWorker(time.Second, WithMetrics(m))
+	expectBatch(mock, 3)
+	if _, err := w.runBatch(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := next(); !strings.HasPrefix(got, "worker.batch.duration:") || !strings.HasSuffix(got, "|ms") {
+		t.Errorf("expected the batch timer, got %q", got)
+	}
+	for _, want := range []string{"worker.logs_processed:3|c", "worker.last_batch_rows:3|g"} {
+		if got := next(); got != want {
+			t.Errorf("expected %q, got %q", want, got)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const (
+	// defaultTableSizeInterval is the pause between api_logs size checks.
+	defaultTableSizeInterval = time.Minute
+	// tableSizeHighWater is the fraction of LOG_TABLE_MAX_BYTES an emergency
+	// purge brings api_logs back down to, so it doesn't run again at once.
+	tableSizeHighWater = 0.9
+	// pgInsufficientPrivilege is the SQLSTATE of a permission error.
+	pgInsufficientPrivilege = "42501"
+)
+
+// TableGuard is the safety net for a database without LOG_RETENTION: it
+// checks the size of api_logs every interval and, once it passes maxBytes,
+// logs an error, sets worker_table_over_limit and degrades readiness. With
+// enforce it also deletes the oldest processed rows, without archiving
+// them, until the rows left fit under the high-water mark.
+//
+// Postgres keeps the space of deleted rows for reuse rather than handing it
+// back, so the table stays over the limit until a VACUUM FULL; the purge
+// stops it growing further. A purge therefore only runs again once the
+// table has grown past the size that triggered the last one.
+type TableGuard struct {
+	metrics   *metrics
+	maxBytes  int64
+	enforce   bool
+	interval  time.Duration
+	timeout   time.Duration
+	batchSize int
+
+	overLimit atomic.Bool
+	// purgedAt is the size that triggered the last purge.
+	purgedAt int64
+	// denied is set once the size check has been refused, so the warning
+	// is logged once.
+	denied bool
+}
+
+// NewTableGuard creates a TableGuard reporting to m. Each statement runs
+// within timeout.
+func NewTableGuard(maxBytes int64, enforce bool, timeout time.Duration, m *metrics) *TableGuard {
+	return &TableGuard{
+		metrics:   m,
+		maxBytes:  maxBytes,
+		enforce:   enforce,
+		interval:  defaultTableSizeInterval,
+		timeout:   timeout,
+		batchSize: defaultBatchSize,
+	}
+}
+
+// Run checks the table size every interval until ctx is cancelled.
+func (g *TableGuard) Run(ctx context.Context) {
+	slog.Info("table size guard started",
+		"max_bytes", g.maxBytes,
+		"enforce", g.enforce,
+		"interval", g.interval.String(),
+	)
+	ticker := time.NewTicker(g.interval)
+	defer ticker.Stop()
+	for {
+		if err := g.check(ctx); err != nil && ctx.Err() == nil {
+			slog.Error("table size check failed", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// OverLimit reports whether api_logs was over maxBytes at the last check.
+func (g *TableGuard) OverLimit() bool {
+	return g.overLimit.Load()
+}
+
+// check measures api_logs once and, when it is over the limit, reports it
+// and purges if enforcing. A refused size query is logged once and
+// otherwise ignored, leaving the last result in place.
+func (g *TableGuard) check(ctx context.Context) error {
+	dbMu.RLock()
+	d := db
+	dbMu.RUnlock()
+	if d == nil || dbReconnecting.Load() {
+		return errDBNotConnected
+	}
+
+	size, rows, err := g.measure(ctx, d)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgInsufficientPrivilege {
+			if !g.denied {
+				slog.Warn("not allowed to read the size of api_logs, LOG_TABLE_MAX_BYTES is not enforced", "error", err)
+				g.denied = true
+			}
+			return nil
+		}
+		return err
+	}
+	g.denied = false
+	g.metrics.tableSize.Set(float64(size))
+
+	over := size > g.maxBytes
+	g.overLimit.Store(over)
+	if !over {
+		g.metrics.tableOverLimit.Set(0)
+		g.purgedAt = 0
+		return nil
+	}
+	g.metrics.tableOverLimit.Set(1)
+	slog.Error("api_logs is over LOG_TABLE_MAX_BYTES", "size_bytes", size, "max_bytes", g.maxBytes, "enforce", g.enforce)
+	if !g.enforce || size <= g.purgedAt {
+		return nil
+	}
+
+	excess := rowsOver(size, rows, int64(float64(g.maxBytes)*tableSizeHighWater))
+	if excess <= 0 {
+		// No row estimate yet, as before the table's first ANALYZE.
+		excess = int64(g.batchSize)
+	}
+	deleted, err := g.purgeOldest(ctx, d, excess)
+	g.purgedAt = size
+	slog.Warn("emergency purge of api_logs", "deleted", deleted, "wanted", excess)
+	return err
+}
+
+// rowsOver estimates how many of rows, which take size bytes, must go for
+// the rest to fit in target.
+func rowsOver(size, rows, target int64) int64 {
+	return int64(float64(rows) * float64(size-target) / float64(size))
+}
+
+// measure returns the total size of api_logs and the planner's estimate of
+// its rows, within the statement timeout.
+func (g *TableGuard) measure(ctx context.Context, d *sql.DB) (size, rows int64, err error) {
+	queryCtx, cancel := context.WithTimeout(ctx, g.timeout)
+	defer cancel()
+	err = d.QueryRowContext(queryCtx, `
+		SELECT pg_total_relation_size(c.oid), GREATEST(c.reltuples, 0)::bigint
+		FROM pg_class c
+		WHERE c.oid = 'api_logs'::regclass
+	`).Scan(&size, &rows)
+	return size, rows, err
+}
+
+// purgeOldest deletes the n oldest processed rows, a batch at a time,
+// stopping early when none are left. Unprocessed rows are never
+// touched.
+func (g *TableGuard) purgeOldest(ctx context.Context, d *sql.DB, n int64) (int64, error) {
+	var total int64
+	for total < n && ctx.Err() == nil {
+		queryCtx, cancel := context.WithTimeout(ctx, g.timeout)
+		res, err := d.ExecContext(queryCtx, `
+			DELETE FROM api_logs
+			WHERE id IN (
+				SELECT id FROM api_logs
+				WHERE processed_at IS NOT NULL
+				ORDER BY id
+				LIMIT $1
+				FOR UPDATE SKIP LOCKED
+			)
+		`, min(int64(g.batchSize), n-total))
+		cancel()
+		if err != nil {
+			return total, err
+		}
+		deleted, _ := res.RowsAffected()
+		total += deleted
+		g.metrics.tablePurged.Add(float64(deleted))
+		if deleted == 0 {
+			break
+		}
+	}
+	return total, ctx.Err()
+}
+
+// tableSizeChecker is the optional readiness check main adds with
+// LOG_TABLE_MAX_BYTES, degrading the worker while api_logs is over it.
+type tableSizeChecker struct {
+	guard *TableGuard
+}
+
+func (tableSizeChecker) Name() string { return "api_logs_size" }
+
+func (c tableSizeChecker) Check(context.Context) error {
+	if c.guard.OverLimit() {
+		return errors.New("api_logs over LOG_TABLE_MAX_BYTES")
+	}
+	return nil
+}
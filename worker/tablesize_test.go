@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// newTestGuard points db at a fresh mock and returns a TableGuard on it
+// with isolated metrics.
+func newTestGuard(t *testing.T, maxBytes int64, enforce bool) (*TableGuard, sqlmock.Sqlmock) {
+	t.Helper()
+	mockDB, mock, _ := sqlmock.New()
+	dbMu.Lock()
+	db = mockDB
+	dbMu.Unlock()
+	t.Cleanup(func() {
+		dbMu.Lock()
+		db = nil
+		dbMu.Unlock()
+		_ = mockDB.Close()
+	})
+	m, _ := newTestMetrics(t)
+	return NewTableGuard(maxBytes, enforce, time.Second, m), mock
+}
+
+// tableSize builds the result of the size query.
+func tableSize(size, rows int64) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"size", "rows"}).AddRow(size, rows)
+}
+
+func TestTableGuard_UnderLimit(t *testing.T) {
+	g, mock := newTestGuard(t, 1000, true)
+	mock.ExpectQuery("pg_total_relation_size").WillReturnRows(tableSize(999, 100))
+
+	if err := g.check(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if g.OverLimit() || testutil.ToFloat64(g.metrics.tableOverLimit) != 0 {
+		t.Error("expected the table under the limit")
+	}
+	if got := testutil.ToFloat64(g.metrics.tableSize); got != 999 {
+		t.Errorf("expected a size of 999, got %v", got)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTableGuard_OverLimitAlerts(t *testing.T) {
+	var logs bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&logs, nil)))
+	defer slog.SetDefault(prev)
+	g, mock := newTestGuard(t, 1000, false)
+	prevChecks := readyChecks
+	readyChecks = []readinessCheck{{Checker: tableSizeChecker{guard: g}}}
+	defer func() { readyChecks = prevChecks }()
+
+	// Without LOG_TABLE_ENFORCE nothing is deleted: sqlmock fails any
+	// DELETE it wasn't told to expect.
+	mock.ExpectQuery("pg_total_relation_size").WillReturnRows(tableSize(1500, 100))
+	if err := g.check(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !g.OverLimit() || testutil.ToFloat64(g.metrics.tableOverLimit) != 1 {
+		t.Error("expected the table over the limit")
+	}
+	if !strings.Contains(logs.String(), "api_logs is over LOG_TABLE_MAX_BYTES") {
+		t.Errorf("expected an error record, got %s", logs.String())
+	}
+	res := runChecks(context.Background(), readyChecks)
+	if res.code != http.StatusOK || res.status != "degraded" {
+		t.Errorf("expected 200 degraded, got %d %s", res.code, res.status)
+	}
+
+	mock.ExpectQuery("pg_total_relation_size").WillReturnRows(tableSize(800, 100))
+	if err := g.check(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if g.OverLimit() || testutil.ToFloat64(g.metrics.tableOverLimit) != 0 {
+		t.Error("expected the alert cleared once the table is back under the limit")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTableGuard_EnforcePurgesOldestProcessed(t *testing.T) {
+	g, mock := newTestGuard(t, 1000, true)
+	g.batchSize = 20
+
+	// 100 rows take 2000 bytes, so keeping 900 means deleting 55 of them.
+	mock.ExpectQuery("pg_total_relation_size").WillReturnRows(tableSize(2000, 100))
+	mock.ExpectExec("DELETE FROM api_logs").WithArgs(int64(20)).WillReturnResult(sqlmock.NewResult(0, 20))
+	mock.ExpectExec("DELETE FROM api_logs").WithArgs(int64(20)).WillReturnResult(sqlmock.NewResult(0, 20))
+	mock.ExpectExec("DELETE FROM api_logs").WithArgs(int64(15)).WillReturnResult(sqlmock.NewResult(0, 15))
+	if err := g.check(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := testutil.ToFloat64(g.metrics.tablePurged); got != 55 {
+		t.Errorf("expected 55 rows purged, got %v", got)
+	}
+
+	// The freed space is reused rather than returned, so the same size
+	// doesn't purge again; only growth past it does.
+	mock.ExpectQuery("pg_total_relation_size").WillReturnRows(tableSize(2000, 45))
+	if err := g.check(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	mock.ExpectQuery("pg_total_relation_size").WillReturnRows(tableSize(2100, 100))
+	mock.ExpectExec("DELETE FROM api_logs").WithArgs(int64(20)).WillReturnResult(sqlmock.NewResult(0, 7))
+	mock.ExpectExec("DELETE FROM api_logs").WithArgs(int64(20)).WillReturnResult(sqlmock.NewResult(0, 0))
+	if err := g.check(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := testutil.ToFloat64(g.metrics.tablePurged); got != 62 {
+		t.Errorf("expected the purge to stop once no processed rows are left, got %v purged", got)
+	}
+	if !g.OverLimit() {
+		t.Error("expected the table still over the limit until its space is reclaimed")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTableGuard_PermissionDenied(t *testing.T) {
+	var logs bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&logs, nil)))
+	defer slog.SetDefault(prev)
+	g, mock := newTestGuard(t, 1000, true)
+	denied := &pgconn.PgError{Code: pgInsufficientPrivilege, Message: "permission denied for table api_logs"}
+	mock.ExpectQuery("pg_total_relation_size").WillReturnError(denied)
+	mock.ExpectQuery("pg_total_relation_size").WillReturnError(denied)
+
+	for range 2 {
+		if err := g.check(context.Background()); err != nil {
+			t.Fatalf("expected a permission error to be tolerated, got %v", err)
+		}
+	}
+	if n := strings.Count(logs.String(), "not allowed to read the size of api_logs"); n != 1 {
+		t.Errorf("expected one warning, got %d", n)
+	}
+	if g.OverLimit() {
+		t.Error("expected no alert without a size")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTableGuard_QueryTimeout(t *testing.T) {
+	g, mock := newTestGuard(t, 1000, true)
+	g.timeout = 20 * time.Millisecond
+	mock.ExpectQuery("pg_total_relation_size").WillDelayFor(time.Second).WillReturnRows(tableSize(2000, 100))
+
+	start := time.Now()
+	if err := g.check(context.Background()); err == nil {
+		t.Error("expected the slow size query to time out")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("expected the timeout to cut the query short, took %v", elapsed)
+	}
+}
+
+func TestTableGuard_NoDB(t *testing.T) {
+	m, _ := newTestMetrics(t)
+	g := NewTableGuard(1000, true, time.Second, m)
+	dbMu.Lock()
+	prev := db
+	db = nil
+	dbMu.Unlock()
+	defer func() {
+		dbMu.Lock()
+		db = prev
+		dbMu.Unlock()
+	}()
+	if err := g.check(context.Background()); err != errDBNotConnected {
+		t.Errorf("expected errDBNotConnected, got %v", err)
+	}
+}
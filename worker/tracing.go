@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// tracerName is the instrumentation scope of the spans this service starts.
+const tracerName = "github.com/tonnam/devops-assignment/worker"
+
+// tracer starts this service's spans. It is a no-op until
+// useTracerProvider installs a real one.
+var tracer trace.Tracer = noop.NewTracerProvider().Tracer(tracerName)
+
+// useTracerProvider makes tp the source of this service's spans.
+func useTracerProvider(tp trace.TracerProvider) {
+	tracer = tp.Tracer(tracerName)
+}
+
+// setupTracing exports spans in batches over OTLP/HTTP to endpoint, the
+// collector's base URL such as http://tempo:4318, as the service res
+// describes. The returned function flushes the spans still buffered and
+// stops the exporter.
+func setupTracing(ctx context.Context, endpoint string, res *resource.Resource) (func(context.Context) error, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	opts := []otlptracehttp.Option{
+		otlptracehttp.WithEndpoint(u.Host),
+		otlptracehttp.WithURLPath(path.Join("/", u.Path, "v1/traces")),
+	}
+	if u.Scheme == "http" {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("create OTLP exporter: %w", err)
+	}
+	tp := newTracerProvider(res, sdktrace.WithBatcher(exporter))
+	useTracerProvider(tp)
+	return tp.Shutdown, nil
+}
+
+// newTracerProvider samples every new trace, and follows the caller's
+// decision for a continued one, with spans handled by processor.
+func newTracerProvider(res *resource.Resource, processor sdktrace.TracerProviderOption) *sdktrace.TracerProvider {
+	return sdktrace.NewTracerProvider(processor, sdktrace.WithResource(res))
+}
+
+// newServiceResource describes this process to a collector: the service
+// name and version and the APP_ENV it runs in.
+func newServiceResource(service, env string) *resource.Resource {
+	return resource.NewSchemaless(
+		attribute.String("service.name", service),
+		attribute.String("service.version", version),
+		attribute.String("deployment.environment.name", env),
+	)
+}
+
+// endSpan records err, if any, as the outcome of span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// useTestTracer records the spans started until the test ends in memory.
+func useTestTracer(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	exp := tracetest.NewInMemoryExporter()
+	tp := newTracerProvider(newServiceResource("worker-test", "test"), sdktrace.WithSyncer(exp))
+	prev := tracer
+	useTracerProvider(tp)
+	t.Cleanup(func() {
+		tracer = prev
+		_ = tp.Shutdown(context.Background())
+	})
+	return exp
+}
+
+// spansNamed returns the recorded spans called name.
+func spansNamed(exp *tracetest.InMemoryExporter, name string) []tracetest.SpanStub {
+	var found []tracetest.SpanStub
+	for _, s := range exp.GetSpans() {
+		if s.Name == name {
+			found = append(found, s)
+		}
+	}
+	return found
+}
+
+func spanAttr(s tracetest.SpanStub, key attribute.Key) attribute.Value {
+	for _, kv := range s.Attributes {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestRunBatch_Spans(t *testing.T) {
+	exp := useTestTracer(t)
+	mockDB, mock, _ := sqlmock.New()
+	defer func() { _ = mockDB.Close() }()
+	dbMu.Lock()
+	db = mockDB
+	dbMu.Unlock()
+
+	w := NewWorker(time.Second, WithConcurrency(2))
+	mock.MatchExpectationsInOrder(false)
+	mock.ExpectBegin()
+	mock.ExpectQuery(claimQuery).WithArgs(defaultBatchSize, 2, 0).WillReturnRows(processedRows(3))
+	expectMark(mock, 3)
+	mock.ExpectBegin()
+	mock.ExpectQuery(claimQuery).WithArgs(defaultBatchSize, 2, 1).WillReturnRows(processedRows(4))
+	expectMark(mock, 4)
+	if _, err := w.runBatch(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	batches := spansNamed(exp, "process batch")
+	if len(batches) != 1 {
+		t.Fatalf("expected one process batch span, got %d", len(batches))
+	}
+	batch := batches[0]
+	if got := spanAttr(batch, "worker.rows_processed").AsInt64(); got != 7 {
+		t.Errorf("expected worker.rows_processed=7, got %d", got)
+	}
+	if got := spanAttr(batch, "worker.concurrency").AsInt64(); got != 2 {
+		t.Errorf("expected worker.concurrency=2, got %d", got)
+	}
+
+	updates := spansNamed(exp, "UPDATE api_logs")
+	if len(updates) != 2 {
+		t.Fatalf("expected an UPDATE span per partition, got %d", len(updates))
+	}
+	rows := map[int64]int64{}
+	for _, s := range updates {
+		if s.Parent.SpanID() != batch.SpanContext.SpanID() {
+			t.Errorf("expected the UPDATE under the batch span, got parent %v", s.Parent)
+		}
+		if s.SpanKind != trace.SpanKindClient || spanAttr(s, "db.system.name").AsString() != "postgresql" {
+			t.Errorf("expected a postgresql client span, got kind %v attributes %v", s.SpanKind, s.Attributes)
+		}
+		rows[spanAttr(s, "worker.partition").AsInt64()] = spanAttr(s, "db.response.returned_rows").AsInt64()
+	}
+	if rows[0] != 3 || rows[1] != 4 {
+		t.Errorf("expected 3 rows from partition 0 and 4 from partition 1, got %v", rows)
+	}
+}
+
+func TestRunBatch_ErrorSpan(t *testing.T) {
+	exp := useTestTracer(t)
+	mockDB, mock, _ := sqlmock.New()
+	defer func() { _ = mockDB.Close() }()
+	dbMu.Lock()
+	db = mockDB
+	dbMu.Unlock()
+
+	w := NewWorker(time.Second)
+	expectBatchError(mock, errors.New("relation does not exist"))
+	if _, err := w.runBatch(context.Background()); err == nil {
+		t.Fatal("expected the batch to fail")
+	}
+	for _, name := range []string{"process batch", "UPDATE api_logs"} {
+		spans := spansNamed(exp, name)
+		if len(spans) != 1 || spans[0].Status.Code != codes.Error {
+			t.Errorf("expected one %q span with an error status, got %+v", name, spans)
+		}
+	}
+}
+
+func TestSetupTracing_ExportsToEndpoint(t *testing.T) {
+	var path atomic.Value
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path.Store(r.URL.Path)
+	}))
+	defer collector.Close()
+	prev := tracer
+	t.Cleanup(func() { tracer = prev })
+
+	shutdown, err := setupTracing(context.Background(), collector.URL+"/otlp", newServiceResource("worker", "test"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, span := tracer.Start(context.Background(), "process batch")
+	span.End()
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("unexpected error flushing spans: %v", err)
+	}
+	if got, _ := path.Load().(string); got != "/otlp/v1/traces" {
+		t.Errorf("expected the spans to be posted under the endpoint's path, got %q", got)
+	}
+}
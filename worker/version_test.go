@@ -0,0 +1,36 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestVersionHandler_Defaults(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/version", nil)
+	rec := httptest.NewRecorder()
+	versionHandler(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected status 200, got %d", rec.Code)
+	}
+	var resp VersionResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	want := VersionResponse{Version: "dev", Commit: "unknown", BuildDate: "unknown", GoVersion: runtime.Version()}
+	if resp != want {
+		t.Errorf("expected %+v, got %+v", want, resp)
+	}
+}
+
+func TestBuildInfo(t *testing.T) {
+	got := testutil.ToFloat64(newBuildInfo().WithLabelValues(version, commit, buildDate, runtime.Version()))
+	if got != 1 {
+		t.Errorf("expected build_info 1, got %v", got)
+	}
+}